@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Q1mi/greeter/pkg/client"
+	"github.com/Q1mi/greeter/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// command 一个子命令, run的参数不包括子命令名, 返回进程退出码
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands 按help中的顺序排列
+var commands = []command{
+	{"serve", "启动服务(默认)", serveMain},
+	{"client", "调用运行中实例的RPC: client call | client list", clientMain},
+	{"migrate", "执行或回退数据库迁移: migrate up | down [steps] | status", migrateMain},
+	{"grant", "设置用户的角色和scope, 用于创建第一个管理员", grantMain},
+	{"replay", "把recorder记录的请求重新发送到目标环境", replayMain},
+	{"healthcheck", "检查本机实例是否就绪, 可用作Docker HEALTHCHECK", healthcheckMain},
+	{"version", "输出版本和构建信息", versionMain},
+}
+
+// runCommand 执行args[0]指定的子命令, 没有子命令时启动服务
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return serveMain(nil)
+	}
+	if args[0] == "help" || args[0] == "-h" {
+		usage()
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage()
+	return 2
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: greeter [-conf file] [command] [flags] [args]")
+	fmt.Fprintln(out, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\n运行 greeter <command> -h 查看子命令的参数")
+}
+
+// confFlag 在子命令中同样接受-conf, greeter -conf x serve 和 greeter serve -conf x 等价
+func confFlag(fs *flag.FlagSet) {
+	fs.StringVar(confPath, "conf", *confPath, "配置文件路径, 为空时使用默认配置")
+}
+
+// localTarget 按配置返回本机实例的gRPC地址及该地址是否使用TLS; gateway模式和不提供gRPC服务时返回空地址
+func localTarget(conf *config.Config) (string, bool) {
+	lcs := conf.Server.GRPCListenConfigs()
+	if len(lcs) == 0 && conf.Server.Mode == config.ModeCombined {
+		// 共用端口时gRPC请求由HTTP地址处理
+		lcs = conf.Server.HTTPListenConfigs()
+	}
+	if len(lcs) == 0 {
+		return "", false
+	}
+	return loopbackAddr(lcs[0].Addr), lcs[0].TLS.Enabled()
+}
+
+// localHTTP 按配置返回本机实例的HTTP地址及该地址是否使用TLS, 不提供HTTP接口时返回空地址
+func localHTTP(conf *config.Config) (string, bool) {
+	lcs := conf.Server.HTTPListenConfigs()
+	if len(lcs) == 0 {
+		return "", false
+	}
+	return loopbackAddr(lcs[0].Addr), lcs[0].TLS.Enabled()
+}
+
+// dialFlags 连接运行中实例的公共参数
+type dialFlags struct {
+	target     string
+	tls        bool
+	skipVerify bool
+}
+
+func (d *dialFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&d.target, "target", "", "gRPC服务地址, 为空时使用配置中本机的监听地址")
+	fs.BoolVar(&d.tls, "tls", false, "使用TLS连接; target为空且本机地址配置了TLS时自动启用")
+	fs.BoolVar(&d.skipVerify, "insecure-skip-verify", false, "使用TLS但不校验服务端证书, 只应用于本机或测试环境")
+}
+
+// resolve 在没有指定target时按配置填写本机地址
+func (d *dialFlags) resolve(conf *config.Config) error {
+	if d.target != "" {
+		return nil
+	}
+	var useTLS bool
+	d.target, useTLS = localTarget(conf)
+	d.tls = d.tls || useTLS
+	if d.target == "" {
+		return fmt.Errorf("server.mode %s does not serve gRPC, use -target", conf.Server.Mode)
+	}
+	return nil
+}
+
+// tlsConfig 不使用TLS时返回nil
+func (d *dialFlags) tlsConfig() *tls.Config {
+	if !d.tls && !d.skipVerify {
+		return nil
+	}
+	return &tls.Config{InsecureSkipVerify: d.skipVerify}
+}
+
+// dial 连接target, 调用时传递baggage
+func (d *dialFlags) dial(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if c := d.tlsConfig(); c != nil {
+		// 服务端证书的名称按target中的主机名校验
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(c)))
+	}
+	return client.Dial(client.Config{Target: d.target}, opts...)
+}
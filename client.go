@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/config"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// clientMain 实现client子命令: 调用运行中实例的一元RPC, 请求和响应为JSON; 或列出可调用的方法
+//
+//	greeter client call helloworld.Greeter/SayHello '{"name":"q1mi"}'
+//	greeter client call -target 10.0.0.1:8091 -token $TOKEN user.UserService/GetUser '{"id":1}'
+//	echo '{"id":1}' | greeter client call GetUser -
+//	greeter client list user
+func clientMain(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "call":
+			return clientCall(args[1:])
+		case "list":
+			return clientList(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "usage: greeter client call [flags] method [json | -]\n       greeter client list [filter]")
+	return 2
+}
+
+func clientCall(args []string) int {
+	fs := flag.NewFlagSet("client call", flag.ExitOnError)
+	confFlag(fs)
+	var d dialFlags
+	d.register(fs)
+	timeout := fs.Duration("timeout", 10*time.Second, "请求的超时时间")
+	token := fs.String("token", os.Getenv("GREETER_TOKEN"), "JWT access token, 作为authorization: Bearer发送, 默认读取环境变量GREETER_TOKEN")
+	var headers headerFlags
+	fs.Var(&headers, "H", "请求metadata, 格式为 key:value, 可重复")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "usage: greeter client call [-target addr] [-tls] [-token t] [-H key:value]... [-timeout d] method [json | -]")
+		return 2
+	}
+
+	md, err := resolveMethod(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		fmt.Fprintf(os.Stderr, "%s is a streaming method, only unary methods can be called\n", md.FullName())
+		return 2
+	}
+	body := []byte("{}")
+	switch arg := fs.Arg(1); arg {
+	case "":
+	case "-":
+		if body, err = io.ReadAll(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, "read request:", err)
+			return 1
+		}
+	default:
+		body = []byte(arg)
+	}
+	in, err := newMessage(md.Input())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := protojson.Unmarshal(body, in); err != nil {
+		fmt.Fprintf(os.Stderr, "decode request as %s: %v\n", md.Input().FullName(), err)
+		return 2
+	}
+	out, err := newMessage(md.Output())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if d.target == "" {
+		conf, err := config.Load(*confPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "load config:", err)
+			return 1
+		}
+		if err := d.resolve(conf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	cc, err := d.dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cc.Close()
+
+	omd := metadata.MD{}
+	for _, h := range headers {
+		omd.Append(h[0], h[1])
+	}
+	if *token != "" {
+		omd.Set("authorization", "Bearer "+*token)
+	}
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), omd), *timeout)
+	defer cancel()
+	if err := cc.Invoke(ctx, "/"+methodName(md), in, out); err != nil {
+		s := status.Convert(err)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", s.Code(), s.Message())
+		for _, detail := range s.Proto().GetDetails() {
+			fmt.Fprintln(os.Stderr, "  ", protojson.MarshalOptions{}.Format(detail))
+		}
+		return 1
+	}
+	fmt.Println(protojson.MarshalOptions{Multiline: true, Indent: "  ", EmitUnpopulated: true}.Format(out))
+	return 0
+}
+
+func clientList(args []string) int {
+	fs := flag.NewFlagSet("client list", flag.ExitOnError)
+	fs.Parse(args)
+	filter := strings.ToLower(fs.Arg(0))
+	for _, m := range allMethods() {
+		name := methodName(m)
+		if filter != "" && !strings.Contains(strings.ToLower(name), filter) {
+			continue
+		}
+		kind := ""
+		if m.IsStreamingClient() || m.IsStreamingServer() {
+			kind = " (streaming)"
+		}
+		fmt.Printf("%s(%s) returns %s%s\n", name, m.Input().FullName(), m.Output().FullName(), kind)
+	}
+	return 0
+}
+
+// resolveMethod 查找方法, name可以是 /package.Service/Method、package.Service/Method,
+// 也可以省略前面的部分, 如 Greeter/SayHello、SayHello, 省略后匹配到多个方法时返回错误
+func resolveMethod(name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	var found []protoreflect.MethodDescriptor
+	for _, m := range allMethods() {
+		full := methodName(m)
+		if full == name {
+			return m, nil
+		}
+		if strings.HasSuffix(full, "."+name) || strings.HasSuffix(full, "/"+name) {
+			found = append(found, m)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("method %s not found, see greeter client list", name)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, len(found))
+	for i, m := range found {
+		names[i] = methodName(m)
+	}
+	return nil, fmt.Errorf("method %s is ambiguous: %s", name, strings.Join(names, ", "))
+}
+
+// allMethods 编译进程序的所有服务的方法, 按名称排序
+func allMethods() []protoreflect.MethodDescriptor {
+	var list []protoreflect.MethodDescriptor
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			ms := fd.Services().Get(i).Methods()
+			for j := 0; j < ms.Len(); j++ {
+				list = append(list, ms.Get(j))
+			}
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return methodName(list[i]) < methodName(list[j]) })
+	return list
+}
+
+// methodName 返回 package.Service/Method
+func methodName(m protoreflect.MethodDescriptor) string {
+	return string(m.Parent().FullName()) + "/" + string(m.Name())
+}
+
+func newMessage(d protoreflect.MessageDescriptor) (protoreflect.ProtoMessage, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(d.FullName())
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", d.FullName(), err)
+	}
+	return mt.New().Interface(), nil
+}
+
+// headerFlags 可重复的 -H key:value 参数
+type headerFlags [][2]string
+
+func (h *headerFlags) String() string {
+	var parts []string
+	for _, kv := range *h {
+		parts = append(parts, kv[0]+":"+kv[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (h *headerFlags) Set(v string) error {
+	i := strings.IndexByte(v, ':')
+	if i <= 0 {
+		return fmt.Errorf("header must be key:value")
+	}
+	*h = append(*h, [2]string{strings.ToLower(strings.TrimSpace(v[:i])), strings.TrimSpace(v[i+1:])})
+	return nil
+}
@@ -0,0 +1,23 @@
+# 示例授权策略, authz.engine为opa时上传到OPA.
+# input: method, service, claims(未认证时为null), tenant_id, request(protojson, 字段名与proto一致, int64为字符串)
+package greeter.authz
+
+import rego.v1
+
+default allow := false
+
+public_services := {"helloworld.Greeter", "grpc.greeter.helloworld.v2.Greeter", "auth.AuthService", "grpc.health.v1.Health"}
+
+public_methods := {"/user.UserService/RegisterUser", "/user.UserService/VerifyEmail"}
+
+allow if input.service in public_services
+
+allow if input.method in public_methods
+
+# 用户只能查看自己的信息
+allow if {
+	input.method == "/user.UserService/GetUser"
+	to_number(input.request.id) == input.claims.user_id
+}
+
+allow if "admin" in input.claims.roles
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/config"
+)
+
+// grantMain 实现grant子命令: 直接在db.dsn指向的数据库中设置用户的角色和scope, 用于创建第一个管理员.
+// 之后的修改可由管理员通过UpdateUser完成. 新权限在用户下次登录或刷新token时生效
+//
+//	greeter -conf conf/config.json grant -roles admin alice
+//	greeter -conf conf/config.json grant -roles admin,ops -scopes greeter:write alice
+func grantMain(args []string) int {
+	fs := flag.NewFlagSet("grant", flag.ExitOnError)
+	confFlag(fs)
+	roles := fs.String("roles", "", "以逗号分隔的角色, 替换用户现有的角色, 为空时清除")
+	scopes := fs.String("scopes", "", "以逗号分隔的scope, 替换用户现有的scope, 为空时清除")
+	timeout := fs.Duration("timeout", time.Minute, "整个命令的超时时间")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: greeter grant [-conf file] [-roles r1,r2] [-scopes s1,s2] username")
+		return 2
+	}
+	access := logic.UserAccess{Roles: splitNames(*roles), Scopes: splitNames(*scopes)}
+	if logic.CheckAccess(access.Roles) != nil || logic.CheckAccess(access.Scopes) != nil {
+		fmt.Fprintln(os.Stderr, logic.ErrInvalidAccess.Error())
+		return 2
+	}
+
+	conf, err := config.Load(*confPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+	dsn := conf.DB.OpenDSN(conf.DB.DSN)
+	if strings.HasPrefix(dsn, "memory:") {
+		fmt.Fprintln(os.Stderr, "grant: the database is memory, users only exist inside the running server")
+		return 1
+	}
+	reg, err := db.Open(dsn, db.Pool{QueryTimeout: conf.DB.QueryTimeout.D()})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c, err := reg.Credentials().GetByUsername(ctx, fs.Arg(0))
+	if errors.Is(err, db.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "user %s not found\n", fs.Arg(0))
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	u, err := reg.Users().Get(ctx, c.UserID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	u.Roles, u.Scopes = access.Roles, access.Scopes
+	if err := reg.Users().Update(ctx, u); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("user %s (id %d): roles=[%s] scopes=[%s]\n", u.Username, u.ID, strings.Join(u.Roles, ","), strings.Join(u.Scopes, ","))
+	return 0
+}
+
+// splitNames 拆分逗号分隔的列表, 忽略空项
+func splitNames(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/config"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthcheckMain 实现healthcheck子命令: 按配置检查本机实例是否就绪, 就绪时退出码为0, 否则为1.
+// 提供gRPC服务时使用gRPC健康检查, gateway模式或指定-http时请求/readyz.
+// 本机地址使用TLS时不校验证书; 要求客户端证书(mTLS)的地址无法检查, 需用-target指定明文地址
+//
+//	HEALTHCHECK --interval=10s --timeout=3s CMD ["greeter", "-conf", "/etc/greeter/config.json", "healthcheck"]
+func healthcheckMain(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	confFlag(fs)
+	var d dialFlags
+	d.register(fs)
+	useHTTP := fs.Bool("http", false, "请求HTTP /readyz 而不是gRPC健康检查, target为HTTP地址")
+	service := fs.String("service", "", "gRPC健康检查的服务名, 为空时检查整个服务")
+	timeout := fs.Duration("timeout", 3*time.Second, "检查的超时时间")
+	quiet := fs.Bool("q", false, "不输出检查结果")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: greeter healthcheck [-target addr] [-http] [-service name] [-timeout d] [-q]")
+		return 2
+	}
+
+	conf, err := config.Load(*confPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+	if conf.Server.Mode == config.ModeGateway {
+		*useHTTP = true
+	}
+	if d.target == "" {
+		if *useHTTP {
+			d.target, d.tls = localHTTP(conf)
+		} else if err := d.resolve(conf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if d.target == "" {
+			fmt.Fprintln(os.Stderr, "server does not serve HTTP, use -target")
+			return 2
+		}
+		// 本机地址, 证书通常签发给对外的域名
+		d.skipVerify = d.skipVerify || d.tls
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var result string
+	if *useHTTP {
+		result, err = checkHTTP(ctx, &d)
+	} else {
+		result, err = checkGRPC(ctx, &d, *service)
+	}
+	if err != nil {
+		if !*quiet {
+			fmt.Fprintln(os.Stderr, d.target, err)
+		}
+		return 1
+	}
+	if !*quiet {
+		fmt.Println(d.target, result)
+	}
+	return 0
+}
+
+func checkGRPC(ctx context.Context, d *dialFlags, service string) (string, error) {
+	cc, err := d.dial()
+	if err != nil {
+		return "", err
+	}
+	defer cc.Close()
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return "", err
+	}
+	if s := resp.GetStatus(); s != healthpb.HealthCheckResponse_SERVING {
+		return "", fmt.Errorf("%s", s)
+	}
+	return resp.GetStatus().String(), nil
+}
+
+func checkHTTP(ctx context.Context, d *dialFlags) (string, error) {
+	scheme, transport := "http://", &http.Transport{}
+	if c := d.tlsConfig(); c != nil {
+		scheme, transport.TLSClientConfig = "https://", c
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+d.target+"/readyz", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Status, nil
+}
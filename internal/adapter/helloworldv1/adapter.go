@@ -0,0 +1,84 @@
+// Package helloworldv1 把helloworld.Greeter(v1)的请求和响应转换为v2, 由v2的实现处理.
+// 迁移期间两个版本同时提供, 业务逻辑只在v2中维护; v1下线后删除本包即可.
+package helloworldv1
+
+import (
+	"context"
+
+	"github.com/Q1mi/greeter/pkg/structutil"
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	helloworldv2 "github.com/Q1mi/greeter/proto/helloworld/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Adapter 用v2的实现提供v1的SayHello和ListGreetings
+type Adapter struct {
+	v2 helloworldv2.GreeterServer
+}
+
+// New 创建Adapter, v2一般为greeterv2.Server
+func New(v2 helloworldv2.GreeterServer) *Adapter {
+	return &Adapter{v2: v2}
+}
+
+func (a *Adapter) SayHello(ctx context.Context, in *helloworldpb.HelloRequest) (*helloworldpb.HelloReply, error) {
+	reply, err := a.v2.SayHello(ctx, HelloRequestToV2(in))
+	if err != nil {
+		return nil, err
+	}
+	return HelloReplyFromV2(reply)
+}
+
+func (a *Adapter) ListGreetings(ctx context.Context, in *helloworldpb.ListGreetingsRequest) (*helloworldpb.ListGreetingsReply, error) {
+	reply, err := a.v2.ListGreetings(ctx, &helloworldv2.ListGreetingsRequest{PageSize: in.PageSize, PageToken: in.PageToken})
+	if err != nil {
+		return nil, err
+	}
+	out := &helloworldpb.ListGreetingsReply{NextPageToken: reply.NextPageToken}
+	for _, g := range reply.Greetings {
+		out.Greetings = append(out.Greetings, GreetingFromV2(g))
+	}
+	return out, nil
+}
+
+// HelloRequestToV2 v1的locale对应v2语言偏好列表中的第一项
+func HelloRequestToV2(in *helloworldpb.HelloRequest) *helloworldv2.HelloRequest {
+	out := &helloworldv2.HelloRequest{Name: in.Name, TemplateId: in.TemplateId}
+	if in.Locale != "" {
+		out.Locales = []string{in.Locale}
+	}
+	return out
+}
+
+// HelloReplyFromV2 v1的data为候选语言, obj为v2 Greeting中v1没有单独字段的信息
+func HelloReplyFromV2(in *helloworldv2.HelloReply) (*helloworldpb.HelloReply, error) {
+	g := in.GetGreeting()
+	data, err := structutil.FromSlice(in.CandidateLocales)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	obj, err := structutil.FromMap(map[string]interface{}{
+		"greeting_id": g.GetId(),
+		"template_id": g.GetTemplateId(),
+		"time_of_day": g.GetTimeOfDay(),
+		"caller":      g.GetCaller(),
+		"create_time": g.GetCreateTime().AsTime(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &helloworldpb.HelloReply{Message: g.GetMessage(), Locale: g.GetLocale(), Data: data, Obj: obj}, nil
+}
+
+// GreetingFromV2 转换问候记录, v1没有template_id和time_of_day
+func GreetingFromV2(g *helloworldv2.Greeting) *helloworldpb.Greeting {
+	return &helloworldpb.Greeting{
+		Id:         g.Id,
+		Name:       g.Name,
+		Message:    g.Message,
+		Locale:     g.Locale,
+		Caller:     g.Caller,
+		CreateTime: g.CreateTime,
+	}
+}
@@ -0,0 +1,284 @@
+// Package logic 实现业务逻辑, 不依赖具体的传输协议
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/passwd"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// MinPasswordLen 密码最小长度
+const MinPasswordLen = 8
+
+var (
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errs.New("auth.invalid_credentials", "invalid username or password")
+	// ErrWeakPassword 密码不满足要求
+	ErrWeakPassword = errs.New("auth.weak_password", "password must be at least 8 characters")
+	// ErrPasswordTooLong 密码超过哈希算法支持的长度, 只有bcrypt有此限制
+	ErrPasswordTooLong = errs.New("auth.password_too_long", "password must be at most 72 bytes")
+	// ErrEmailNotVerified 账号未完成邮箱验证
+	ErrEmailNotVerified = errs.New("auth.email_not_verified", "email not verified")
+	// ErrInvalidRefreshToken refresh token不存在或已作废
+	ErrInvalidRefreshToken = errs.New("auth.invalid_refresh_token", "invalid refresh token")
+	// ErrRefreshTokenExpired refresh token已过期
+	ErrRefreshTokenExpired = errs.New("auth.refresh_token_expired", "refresh token expired, please log in again")
+	// ErrRefreshTokenReused 已轮换的refresh token被再次使用, 同一次登录签发的token全部作废
+	ErrRefreshTokenReused = errs.New("auth.refresh_token_reused", "refresh token already used, please log in again")
+)
+
+// AuthUseCase 登录和密码管理
+type AuthUseCase struct {
+	users   db.UserStore
+	creds   db.CredentialStore
+	refresh db.RefreshTokenStore
+	hasher  *passwd.Hasher
+	tokens  *jwt.Signer
+	ttl     time.Duration
+	// dummyHash 用户不存在时也做一次校验, 避免通过响应时间判断用户名是否存在
+	dummyHash string
+
+	// RefreshTTL refresh token有效期, 不大于0时登录不签发refresh token
+	RefreshTTL time.Duration
+	// RotateRefresh 为true时每次刷新签发新的refresh token并使旧token失效, 旧token再次使用时整族作废;
+	// 为false时refresh token在有效期内可重复使用
+	RotateRefresh bool
+}
+
+// NewAuthUseCase 创建AuthUseCase, tokens为登录时签发access token的Signer, ttl为token有效期
+func NewAuthUseCase(reg db.Registry, hasher *passwd.Hasher, tokens *jwt.Signer, ttl time.Duration) (*AuthUseCase, error) {
+	dummy, err := hasher.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	return &AuthUseCase{
+		users:         reg.Users(),
+		creds:         reg.Credentials(),
+		refresh:       reg.RefreshTokens(),
+		hasher:        hasher,
+		tokens:        tokens,
+		ttl:           ttl,
+		dummyHash:     dummy,
+		RefreshTTL:    30 * 24 * time.Hour,
+		RotateRefresh: true,
+	}, nil
+}
+
+// Session 登录或刷新后签发的token
+type Session struct {
+	UserID      int64
+	Username    string
+	AccessToken string
+	ExpiresAt   time.Time
+	// RefreshToken 用于换取新的access token, 未启用时为空
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// Login 校验用户名和密码并签发access token和refresh token; 哈希参数与当前配置不同时顺便更新哈希
+func (uc *AuthUseCase) Login(ctx context.Context, username, password string) (*Session, error) {
+	c, err := uc.verify(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	u, err := uc.users.Get(ctx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != model.UserActive {
+		return nil, ErrEmailNotVerified
+	}
+	// 本次登录签发的refresh token属于同一族, access token中带上族ID, 登出时一并作废
+	claims := &ctxutil.Claims{UserID: c.UserID, Username: c.Username, TenantID: ctxutil.TenantID(ctx), Roles: u.Roles, Scopes: u.Scopes,
+		SessionID: randomHex(16)}
+	sess, err := uc.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	if uc.RefreshTTL > 0 {
+		if sess.RefreshToken, sess.RefreshExpiresAt, err = uc.issueRefresh(ctx, claims, claims.SessionID); err != nil {
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// Refresh 用refresh token换取新的access token. 启用轮换时同时签发新的refresh token,
+// 已使用过的token再次使用说明可能已泄露, 作废同一次登录签发的所有refresh token
+func (uc *AuthUseCase) Refresh(ctx context.Context, refreshToken string) (*Session, error) {
+	now := time.Now()
+	t, err := uc.refresh.Use(ctx, hashRefresh(refreshToken), now)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !t.RevokedAt.IsZero() {
+		return nil, ErrInvalidRefreshToken
+	}
+	if !now.Before(t.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+	if uc.RotateRefresh && !t.UsedAt.IsZero() {
+		zaplog.FromContext(ctx).Warn("refresh token reused, revoking family",
+			zaplog.Int64("user_id", t.UserID), zaplog.String("family_id", t.FamilyID))
+		if err := uc.refresh.RevokeFamily(ctx, t.FamilyID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	// 登录后账号可能被停用
+	u, err := uc.users.Get(ctx, t.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != model.UserActive {
+		return nil, ErrEmailNotVerified
+	}
+	claims := &ctxutil.Claims{UserID: t.UserID, Username: t.Username, TenantID: t.TenantID, Roles: u.Roles, Scopes: u.Scopes,
+		SessionID: t.FamilyID}
+	sess, err := uc.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !uc.RotateRefresh {
+		sess.RefreshToken, sess.RefreshExpiresAt = refreshToken, t.ExpiresAt
+		return sess, nil
+	}
+	if sess.RefreshToken, sess.RefreshExpiresAt, err = uc.issueRefresh(ctx, claims, t.FamilyID); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// sign 为c签发access token. c的角色和scope来自用户记录, 刷新时重新读取, 修改后最迟在下次刷新生效
+func (uc *AuthUseCase) sign(c *ctxutil.Claims) (*Session, error) {
+	tok, exp, err := uc.tokens.Sign(c, uc.ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{UserID: c.UserID, Username: c.Username, AccessToken: tok, ExpiresAt: exp}, nil
+}
+
+// issueRefresh 签发属于family的refresh token, 只保存哈希
+func (uc *AuthUseCase) issueRefresh(ctx context.Context, c *ctxutil.Claims, family string) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	t := &model.RefreshToken{
+		Hash:      hashRefresh(tok),
+		UserID:    c.UserID,
+		Username:  c.Username,
+		TenantID:  c.TenantID,
+		FamilyID:  family,
+		ExpiresAt: now.Add(uc.RefreshTTL).Truncate(time.Second),
+		CreatedAt: now,
+	}
+	if err := uc.refresh.Create(ctx, t); err != nil {
+		return "", time.Time{}, err
+	}
+	return tok, t.ExpiresAt, nil
+}
+
+func hashRefresh(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logout 撤销access token并作废同一次登录的refresh token, 之后使用该token的请求都返回未认证, 也不能再刷新
+func (uc *AuthUseCase) Logout(ctx context.Context, tok string) error {
+	c, err := uc.tokens.Verify(ctx, tok)
+	if err != nil {
+		return err
+	}
+	if c.SessionID != "" {
+		if err := uc.refresh.RevokeFamily(ctx, c.SessionID, time.Now()); err != nil {
+			return err
+		}
+	}
+	return uc.tokens.Revoke(ctx, c)
+}
+
+// ChangePassword 校验旧密码后设置新密码, 并作废用户所有的refresh token; 已签发的access token在过期前仍然有效
+func (uc *AuthUseCase) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) error {
+	if len(newPassword) < MinPasswordLen {
+		return ErrWeakPassword
+	}
+	c, err := uc.verify(ctx, username, oldPassword)
+	if err != nil {
+		return err
+	}
+	hash, err := uc.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := uc.creds.UpdatePasswordHash(ctx, c.UserID, hash); err != nil {
+		return err
+	}
+	return uc.refresh.RevokeUser(ctx, c.UserID, time.Now())
+}
+
+// hashPassword 校验密码强度并计算哈希, 用于注册等流程创建凭据
+func (uc *AuthUseCase) hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLen {
+		return "", ErrWeakPassword
+	}
+	hash, err := uc.hasher.Hash(password)
+	if errors.Is(err, passwd.ErrPasswordTooLong) {
+		return "", ErrPasswordTooLong
+	}
+	return hash, err
+}
+
+func (uc *AuthUseCase) verify(ctx context.Context, username, password string) (*model.Credential, error) {
+	c, err := uc.creds.GetByUsername(ctx, username)
+	if errors.Is(err, db.ErrNotFound) {
+		uc.hasher.Verify(password, uc.dummyHash)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	needsRehash, err := uc.hasher.Verify(password, c.PasswordHash)
+	if errors.Is(err, passwd.ErrMismatch) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if needsRehash {
+		if hash, err := uc.hasher.Hash(password); err == nil {
+			if err := uc.creds.UpdatePasswordHash(ctx, c.UserID, hash); err != nil {
+				zaplog.FromContext(ctx).Warn("rehash password", zaplog.Int64("user_id", c.UserID), zaplog.Error(err))
+			} else {
+				c.PasswordHash = hash
+			}
+		}
+	}
+	return c, nil
+}
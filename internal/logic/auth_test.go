@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/authz"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/passwd"
+)
+
+const testPassword = "correct horse battery"
+
+type testEnv struct {
+	reg    db.Registry
+	signer *jwt.Signer
+	auth   *AuthUseCase
+	users  *UserUseCase
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	// 测试中使用很小的迭代次数
+	hasher, err := passwd.New(passwd.Params{Algorithm: passwd.PBKDF2, Iterations: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := db.NewMemory()
+	signer := jwt.NewSigner([]byte("test secret"), "greeter", jwt.NewMemoryRevocations())
+	auth, err := NewAuthUseCase(reg, hasher, signer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{reg: reg, signer: signer, auth: auth, users: NewUserUseCase(reg, auth, nil, nil, nil)}
+}
+
+// addUser 创建已激活的用户
+func (e *testEnv) addUser(t *testing.T, username string, roles, scopes []string) *model.User {
+	t.Helper()
+	u, err := e.users.create(context.Background(), &model.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Status:   model.UserActive,
+		Roles:    roles,
+		Scopes:   scopes,
+	}, testPassword)
+	if err != nil {
+		t.Fatalf("create %s: %v", username, err)
+	}
+	return u
+}
+
+// login 登录并返回校验后的claims
+func (e *testEnv) login(t *testing.T, username string) (*Session, *ctxutil.Claims) {
+	t.Helper()
+	sess, err := e.auth.Login(context.Background(), username, testPassword)
+	if err != nil {
+		t.Fatalf("login %s: %v", username, err)
+	}
+	c, err := e.signer.Verify(context.Background(), sess.AccessToken)
+	if err != nil {
+		t.Fatalf("verify token of %s: %v", username, err)
+	}
+	return sess, c
+}
+
+// adminContext 返回以admin角色认证的ctx
+func adminContext() context.Context {
+	return ctxutil.WithClaims(context.Background(), &ctxutil.Claims{UserID: 1000, Username: "root", Roles: []string{RoleAdmin}})
+}
+
+func TestLoginSignsRolesAndScopes(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser(t, "alice", []string{RoleAdmin}, []string{"greeter:write"})
+	e.addUser(t, "bob", nil, nil)
+
+	sess, c := e.login(t, "alice")
+	if !c.HasRole(RoleAdmin) || !c.HasScope("greeter:write") {
+		t.Fatalf("alice claims = roles %v scopes %v, want admin and greeter:write", c.Roles, c.Scopes)
+	}
+	// 刷新后的token同样带有角色
+	refreshed, err := e.auth.Refresh(context.Background(), sess.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = e.signer.Verify(context.Background(), refreshed.AccessToken); err != nil || !c.HasRole(RoleAdmin) {
+		t.Fatalf("refreshed claims = %+v, %v, want admin role", c, err)
+	}
+
+	if _, c = e.login(t, "bob"); len(c.Roles) != 0 || len(c.Scopes) != 0 {
+		t.Fatalf("bob claims = roles %v scopes %v, want none", c.Roles, c.Scopes)
+	}
+}
+
+func TestAdminTokenAuthorized(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser(t, "alice", []string{RoleAdmin}, nil)
+	e.addUser(t, "bob", nil, nil)
+	// 与conf/config.json中的规则相同
+	roles, err := authz.NewRoles(authz.RolesConfig{Methods: []authz.MethodRoles{
+		{Method: "/user.UserService/ListUsers", Roles: []string{"admin"}},
+		{Method: "/user.UserService/*", Roles: []string{"*"}},
+		{Method: "/admin.AdminService/*", Roles: []string{"admin", "ops"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, admin := e.login(t, "alice")
+	_, user := e.login(t, "bob")
+
+	tests := []struct {
+		method string
+		claims *ctxutil.Claims
+		allow  bool
+	}{
+		{"/admin.AdminService/Diagnose", admin, true},
+		{"/admin.AdminService/Diagnose", user, false},
+		{"/user.UserService/ListUsers", admin, true},
+		{"/user.UserService/ListUsers", user, false},
+		{"/user.UserService/GetUser", user, true},
+	}
+	for _, tt := range tests {
+		d, err := roles.Decide(context.Background(), &authz.Input{Method: tt.method, Claims: tt.claims})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allow != tt.allow {
+			t.Errorf("%s as %s: allow = %v (%s), want %v", tt.method, tt.claims.Username, d.Allow, d.Reason, tt.allow)
+		}
+	}
+
+	// 业务层对管理操作同样检查角色
+	if _, _, err := e.users.List(ctxutil.WithClaims(context.Background(), user), 10, "", "", ""); !errors.Is(err, ErrUserForbidden) {
+		t.Errorf("List as plain user: err = %v, want ErrUserForbidden", err)
+	}
+	if _, _, err := e.users.List(ctxutil.WithClaims(context.Background(), admin), 10, "", "", ""); err != nil {
+		t.Errorf("List as admin: %v", err)
+	}
+}
+
+func TestUpdateAccessRequiresAdmin(t *testing.T) {
+	e := newTestEnv(t)
+	bob := e.addUser(t, "bob", nil, nil)
+	_, c := e.login(t, "bob")
+	self := ctxutil.WithClaims(context.Background(), c)
+
+	upd := UserUpdate{Access: &UserAccess{Roles: []string{RoleAdmin}}}
+	if _, err := e.users.Update(self, bob.ID, upd); !errors.Is(err, ErrUserForbidden) {
+		t.Fatalf("self-grant: err = %v, want ErrUserForbidden", err)
+	}
+	if _, err := e.users.Update(adminContext(), bob.ID, UserUpdate{Access: &UserAccess{Roles: []string{"bad role"}}}); !errors.Is(err, ErrInvalidAccess) {
+		t.Fatalf("invalid role: err = %v, want ErrInvalidAccess", err)
+	}
+	if _, err := e.users.Update(adminContext(), bob.ID, upd); err != nil {
+		t.Fatal(err)
+	}
+	// 新角色在下次登录时生效
+	if _, c = e.login(t, "bob"); !c.HasRole(RoleAdmin) {
+		t.Fatalf("roles after grant = %v, want admin", c.Roles)
+	}
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser(t, "alice", nil, nil)
+	ctx := context.Background()
+	sess, _ := e.login(t, "alice")
+	// 刷新后的access token同样属于本次登录
+	refreshed, err := e.auth.Refresh(ctx, sess.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := e.login(t, "alice")
+
+	if err := e.auth.Logout(ctx, refreshed.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.auth.Refresh(ctx, refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh after logout: %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := e.signer.Verify(ctx, refreshed.AccessToken); !errors.Is(err, jwt.ErrRevoked) {
+		t.Errorf("access token after logout: %v, want ErrRevoked", err)
+	}
+	// 其他登录不受影响
+	if _, err := e.auth.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Errorf("refresh of another session after logout: %v", err)
+	}
+}
+
+func TestChangePasswordRevokesRefreshTokens(t *testing.T) {
+	e := newTestEnv(t)
+	e.addUser(t, "alice", nil, nil)
+	e.addUser(t, "bob", nil, nil)
+	ctx := context.Background()
+	first, _ := e.login(t, "alice")
+	second, _ := e.login(t, "alice")
+	bob, _ := e.login(t, "bob")
+
+	if err := e.auth.ChangePassword(ctx, "alice", testPassword, "a new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	for i, sess := range []*Session{first, second} {
+		if _, err := e.auth.Refresh(ctx, sess.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("refresh of session %d after changing the password: %v, want ErrInvalidRefreshToken", i+1, err)
+		}
+	}
+	if _, err := e.auth.Refresh(ctx, bob.RefreshToken); err != nil {
+		t.Errorf("refresh of another user after changing the password: %v", err)
+	}
+	if _, err := e.auth.Login(ctx, "alice", "a new passphrase"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+}
+
+// TestChangePasswordTooLong bcrypt放不下的新密码返回ErrPasswordTooLong, 原密码仍然可用
+func TestChangePasswordTooLong(t *testing.T) {
+	e := newTestEnv(t)
+	hasher, err := passwd.New(passwd.Params{Algorithm: passwd.Bcrypt, Cost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.auth, err = NewAuthUseCase(e.reg, hasher, e.signer, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	e.users = NewUserUseCase(e.reg, e.auth, nil, nil, nil)
+	e.addUser(t, "alice", nil, nil)
+
+	long := strings.Repeat("x", passwd.MaxBcryptLen+1)
+	if err := e.auth.ChangePassword(context.Background(), "alice", testPassword, long); !errors.Is(err, ErrPasswordTooLong) {
+		t.Fatalf("change to %d bytes: %v, want ErrPasswordTooLong", len(long), err)
+	}
+	e.login(t, "alice")
+}
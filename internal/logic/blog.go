@@ -0,0 +1,103 @@
+package logic
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+)
+
+const (
+	// maxBlogTitleLen 标题最大字符数
+	maxBlogTitleLen = 200
+	// maxBlogContentLen 正文最大字节数
+	maxBlogContentLen = 64 << 10
+)
+
+var (
+	// ErrBlogNotFound 博客不存在
+	ErrBlogNotFound = errs.New("blog.not_found", "blog not found")
+	// ErrInvalidBlogTitle 标题为空或过长
+	ErrInvalidBlogTitle = errs.New("blog.invalid_title", "title must be 1-200 characters")
+	// ErrInvalidBlogContent 正文为空或过长
+	ErrInvalidBlogContent = errs.New("blog.invalid_content", "content must be non-empty and at most 64KB")
+	// ErrBlogLoginRequired 发表博客需要登录
+	ErrBlogLoginRequired = errs.New("blog.login_required", "log in to create blogs")
+)
+
+// BlogUseCase 发表和查询博客
+type BlogUseCase struct {
+	blogs db.BlogStore
+}
+
+// NewBlogUseCase 创建BlogUseCase
+func NewBlogUseCase(reg db.Registry) *BlogUseCase {
+	return &BlogUseCase{blogs: reg.Blogs()}
+}
+
+// Create 以当前用户为作者发表博客, 标题去掉首尾空白后保存
+func (uc *BlogUseCase) Create(ctx context.Context, title, content string) (*model.Blog, error) {
+	author, ok := ctxutil.UserID(ctx)
+	if !ok {
+		return nil, ErrBlogLoginRequired
+	}
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > maxBlogTitleLen {
+		return nil, ErrInvalidBlogTitle
+	}
+	if strings.TrimSpace(content) == "" || len(content) > maxBlogContentLen {
+		return nil, ErrInvalidBlogContent
+	}
+	b := &model.Blog{AuthorID: author, Title: title, Content: content}
+	if err := uc.blogs.Create(ctx, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Get 按ID查询博客
+func (uc *BlogUseCase) Get(ctx context.Context, id int64) (*model.Blog, error) {
+	b, err := uc.blogs.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrBlogNotFound
+	}
+	return b, err
+}
+
+// List 分页返回博客, 按发表时间倒序, authorID不为0时只返回该作者的; nextToken为空表示没有更多数据
+func (uc *BlogUseCase) List(ctx context.Context, pageSize int, pageToken string, authorID int64) ([]*model.Blog, string, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	q := db.BlogQuery{AuthorID: authorID, Limit: pageSize + 1}
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		if q.BeforeID, err = strconv.ParseInt(string(b), 10, 64); err != nil || q.BeforeID <= 0 {
+			return nil, "", ErrInvalidPageToken
+		}
+	}
+	// 多取一条用于判断是否还有下一页
+	list, err := uc.blogs.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(list[pageSize-1].ID, 10)))
+	}
+	return list, next, nil
+}
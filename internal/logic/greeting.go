@@ -0,0 +1,294 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/cache"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/locale"
+)
+
+const (
+	// maxTemplateLen 模板文本最大长度
+	maxTemplateLen = 1024
+	// maxGreetingLen 渲染结果最大长度
+	maxGreetingLen = 1024
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	// ErrTemplateNotFound 模板不存在
+	ErrTemplateNotFound = errs.New("greeting.template_not_found", "greeting template not found")
+	// ErrInvalidPageToken 分页token无效
+	ErrInvalidPageToken = errs.New("greeting.invalid_page_token", "invalid page token")
+	// ErrGreetingTooLong 渲染结果超过maxGreetingLen, 通常是name过长
+	ErrGreetingTooLong = errs.New("greeting.too_long", "greeting is too long, use a shorter name")
+)
+
+// GreetingRecorder 记录问候的统计接口
+type GreetingRecorder interface {
+	RecordGreeting(name string)
+}
+
+// GreetingData 模板中可用的变量
+type GreetingData struct {
+	Name string
+	// TimeOfDay morning, afternoon, evening 或 night
+	TimeOfDay string
+	Locale    string
+	Time      time.Time
+}
+
+// templateCacheMetrics 数据库模板解析结果缓存的指标
+var templateCacheMetrics = cache.NewMetrics("greeting_template")
+
+type cachedTemplate struct {
+	updatedAt time.Time
+	tmpl      *template.Template
+}
+
+// GreetingUseCase 按模板生成问候语.
+// 请求带有语言偏好时先按回退链查找各语言的消息目录; 否则(或都找不到时)使用配置中的模板,
+// 再找不到时从数据库读取, 解析结果按更新时间缓存.
+type GreetingUseCase struct {
+	store     db.GreetingTemplateStore
+	history   db.GreetingStore
+	static    map[string]*template.Template
+	catalogs  map[string]map[string]*template.Template
+	defaultID string
+
+	// DefaultLocale 模板变量Locale的默认值
+	DefaultLocale string
+	// Recorder 不为nil时每次问候后调用, 用于统计
+	Recorder GreetingRecorder
+
+	mu    sync.RWMutex
+	cache map[string]cachedTemplate
+	now   func() time.Time
+}
+
+// NewGreetingUseCase 创建GreetingUseCase, 启动时解析校验全部模板.
+// templates为不区分语言的模板(id -> 文本), catalogs为各语言的消息目录(语言 -> id -> 文本).
+func NewGreetingUseCase(reg db.Registry, templates map[string]string, catalogs map[string]map[string]string, defaultID string) (*GreetingUseCase, error) {
+	uc := &GreetingUseCase{
+		store:         reg.GreetingTemplates(),
+		history:       reg.Greetings(),
+		static:        map[string]*template.Template{},
+		catalogs:      map[string]map[string]*template.Template{},
+		defaultID:     defaultID,
+		DefaultLocale: "en",
+		cache:         map[string]cachedTemplate{},
+		now:           time.Now,
+	}
+	for id, text := range templates {
+		t, err := parseTemplate(id, text)
+		if err != nil {
+			return nil, err
+		}
+		uc.static[id] = t
+	}
+	for loc, msgs := range catalogs {
+		loc = locale.Normalize(loc)
+		if uc.catalogs[loc] == nil {
+			uc.catalogs[loc] = map[string]*template.Template{}
+		}
+		for id, text := range msgs {
+			t, err := parseTemplate(loc+"/"+id, text)
+			if err != nil {
+				return nil, err
+			}
+			uc.catalogs[loc][id] = t
+		}
+	}
+	return uc, nil
+}
+
+// Greet 使用templateID对应的模板生成问候语并保存调用记录, templateID为空时使用默认模板.
+// locales为按优先级排列的语言偏好, 返回保存的记录, 其中包括问候语和实际使用的语言.
+func (uc *GreetingUseCase) Greet(ctx context.Context, name, templateID string, locales []string) (*model.Greeting, error) {
+	if templateID == "" {
+		templateID = uc.defaultID
+	}
+	t, loc, err := uc.template(ctx, templateID, locales)
+	if err != nil {
+		return nil, err
+	}
+	now := uc.now()
+	msg, err := render(t, &GreetingData{
+		Name:      name,
+		TimeOfDay: TimeOfDay(now),
+		Locale:    loc,
+		Time:      now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	g := &model.Greeting{Name: name, Message: msg, Locale: loc, TemplateID: templateID, Caller: caller(ctx), CreatedAt: now}
+	if err := uc.history.Create(ctx, g); err != nil {
+		return nil, fmt.Errorf("save greeting: %w", err)
+	}
+	if uc.Recorder != nil {
+		uc.Recorder.RecordGreeting(name)
+	}
+	return g, nil
+}
+
+// ListGreetings 分页返回问候记录, 按时间倒序; nextToken为空表示没有更多数据
+func (uc *GreetingUseCase) ListGreetings(ctx context.Context, pageSize int, pageToken string) ([]*model.Greeting, string, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	var before int64
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		if before, err = strconv.ParseInt(string(b), 10, 64); err != nil || before <= 0 {
+			return nil, "", ErrInvalidPageToken
+		}
+	}
+	// 多取一条用于判断是否还有下一页
+	list, err := uc.history.List(ctx, before, pageSize+1)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(list[pageSize-1].ID, 10)))
+	}
+	return list, next, nil
+}
+
+// caller 调用方标识, 已登录时为 user:<id>, 否则为客户端IP
+func caller(ctx context.Context) string {
+	if id, ok := ctxutil.UserID(ctx); ok {
+		return "user:" + strconv.FormatInt(id, 10)
+	}
+	return ctxutil.ClientIP(ctx)
+}
+
+// template 查找模板, 返回模板和对应的语言
+func (uc *GreetingUseCase) template(ctx context.Context, id string, locales []string) (*template.Template, string, error) {
+	if len(locales) > 0 {
+		for _, loc := range locale.Chain(locales, uc.DefaultLocale) {
+			if t, ok := uc.catalogs[loc][id]; ok {
+				return t, loc, nil
+			}
+		}
+	}
+	t, err := uc.unlocalized(ctx, id)
+	if errors.Is(err, ErrTemplateNotFound) && len(locales) == 0 {
+		// 没有语言偏好且没有通用模板时使用默认语言的消息目录
+		if t, ok := uc.catalogs[locale.Normalize(uc.DefaultLocale)][id]; ok {
+			return t, uc.DefaultLocale, nil
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return t, uc.DefaultLocale, nil
+}
+
+func (uc *GreetingUseCase) unlocalized(ctx context.Context, id string) (*template.Template, error) {
+	if t, ok := uc.static[id]; ok {
+		return t, nil
+	}
+	rec, err := uc.store.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uc.mu.RLock()
+	c, ok := uc.cache[id]
+	uc.mu.RUnlock()
+	if ok && c.updatedAt.Equal(rec.UpdatedAt) {
+		templateCacheMetrics.Hit()
+		return c.tmpl, nil
+	}
+	templateCacheMetrics.Miss()
+	if ok {
+		templateCacheMetrics.Evict(cache.EvictStale)
+	}
+	t, err := parseTemplate(id, rec.Text)
+	if err != nil {
+		return nil, err
+	}
+	uc.mu.Lock()
+	uc.cache[id] = cachedTemplate{updatedAt: rec.UpdatedAt, tmpl: t}
+	templateCacheMetrics.SetEntries(len(uc.cache))
+	uc.mu.Unlock()
+	return t, nil
+}
+
+// parseTemplate 解析模板并用示例数据试渲染一次, 不注册任何自定义函数
+func parseTemplate(id, text string) (*template.Template, error) {
+	if len(text) > maxTemplateLen {
+		return nil, fmt.Errorf("greeting template %q is longer than %d bytes", id, maxTemplateLen)
+	}
+	t, err := template.New(id).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse greeting template %q: %w", id, err)
+	}
+	if _, err := render(t, &GreetingData{Name: "world", TimeOfDay: "morning", Locale: "en", Time: time.Now()}); err != nil {
+		return nil, fmt.Errorf("check greeting template %q: %w", id, err)
+	}
+	return t, nil
+}
+
+func render(t *template.Template, data *GreetingData) (string, error) {
+	w := &limitedBuffer{max: maxGreetingLen}
+	if err := t.Execute(w, data); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// limitedBuffer 超过max字节后写入失败, 防止模板输出过大
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, fmt.Errorf("%w: longer than %d bytes", ErrGreetingTooLong, b.max)
+	}
+	return b.Buffer.Write(p)
+}
+
+// TimeOfDay 返回t所在的时段: morning, afternoon, evening 或 night
+func TimeOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h >= 5 && h < 12:
+		return "morning"
+	case h >= 12 && h < 18:
+		return "afternoon"
+	case h >= 18 && h < 22:
+		return "evening"
+	default:
+		return "night"
+	}
+}
@@ -0,0 +1,28 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Q1mi/greeter/internal/repo/db"
+)
+
+func TestGreetTooLong(t *testing.T) {
+	uc, err := NewGreetingUseCase(db.NewMemory(), map[string]string{"default": "Hello, {{.Name}}"}, nil, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := uc.Greet(context.Background(), "q1mi", "", nil)
+	if err != nil || g.Message != "Hello, q1mi" {
+		t.Fatalf("Greet = %v, %v", g, err)
+	}
+	if _, err := uc.Greet(context.Background(), strings.Repeat("x", maxGreetingLen), "", nil); !errors.Is(err, ErrGreetingTooLong) {
+		t.Fatalf("Greet with a long name: err = %v, want ErrGreetingTooLong", err)
+	}
+	// 失败的问候不保存记录
+	if list, _, _ := uc.ListGreetings(context.Background(), 10, ""); len(list) != 1 {
+		t.Errorf("%d greetings saved, want 1", len(list))
+	}
+}
@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Q1mi/greeter/internal/model"
+)
+
+// ProfileSource 用户资料来源
+type ProfileSource interface {
+	// Get 返回用户资料, 没有资料时返回nil
+	Get(ctx context.Context, userID int64) (*model.UserProfile, error)
+}
+
+// ProfileAPI 从外部HTTP接口 GET <base>/users/<id>/profile 读取用户资料, 404表示没有资料.
+// client应由httpclient.New创建, 以获得trace传递、重试和指标
+type ProfileAPI struct {
+	base   string
+	client *http.Client
+}
+
+// NewProfileAPI 创建ProfileAPI
+func NewProfileAPI(base string, client *http.Client) *ProfileAPI {
+	return &ProfileAPI{base: strings.TrimSuffix(base, "/"), client: client}
+}
+
+func (p *ProfileAPI) Get(ctx context.Context, userID int64) (*model.UserProfile, error) {
+	u := p.base + "/users/" + url.PathEscape(strconv.FormatInt(userID, 10)) + "/profile"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("profile api returned %s", resp.Status)
+	}
+	prof := new(model.UserProfile)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(prof); err != nil {
+		return nil, fmt.Errorf("decode profile: %w", err)
+	}
+	return prof, nil
+}
@@ -0,0 +1,97 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/errs"
+)
+
+// DateLayout 报表日期的格式
+const DateLayout = "2006-01-02"
+
+var (
+	// ErrInvalidDate 日期格式错误
+	ErrInvalidDate = errs.New("report.invalid_date", "date must be in YYYY-MM-DD format")
+	// ErrReportNotFound 报表尚未生成
+	ErrReportNotFound = errs.New("report.not_found", "report not found")
+)
+
+// reportPageSize 生成报表时每次读取的问候记录数
+const reportPageSize = 500
+
+// ReportUseCase 每日统计报表
+type ReportUseCase struct {
+	greetings db.GreetingStore
+	users     db.UserStore
+	reports   db.ReportStore
+}
+
+// NewReportUseCase 创建ReportUseCase
+func NewReportUseCase(reg db.Registry) *ReportUseCase {
+	return &ReportUseCase{greetings: reg.Greetings(), users: reg.Users(), reports: reg.Reports()}
+}
+
+// Generate 统计day所在的UTC日期并保存, 已有报表时覆盖
+func (uc *ReportUseCase) Generate(ctx context.Context, day time.Time) (*model.Report, error) {
+	day = day.UTC()
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	r := &model.Report{
+		Date:       from.Format(DateLayout),
+		ByLocale:   map[string]int64{},
+		ByTemplate: map[string]int64{},
+	}
+	callers := map[string]bool{}
+	// 问候记录按ID即创建时间倒序分页, 读到早于from的记录即可停止
+	var before int64
+	for done := false; !done; {
+		gs, err := uc.greetings.List(ctx, before, reportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(gs) < reportPageSize {
+			done = true
+		}
+		for _, g := range gs {
+			before = g.ID
+			if g.CreatedAt.Before(from) {
+				done = true
+				break
+			}
+			if !g.CreatedAt.Before(to) {
+				continue
+			}
+			r.Greetings++
+			r.ByLocale[g.Locale]++
+			r.ByTemplate[g.TemplateID]++
+			callers[g.Caller] = true
+		}
+	}
+	r.UniqueCallers = int64(len(callers))
+	n, err := uc.users.CountCreated(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r.NewUsers = n
+	r.GeneratedAt = time.Now()
+	if err := uc.reports.Save(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get 返回date(YYYY-MM-DD)的报表
+func (uc *ReportUseCase) Get(ctx context.Context, date string) (*model.Report, error) {
+	if _, err := time.Parse(DateLayout, date); err != nil {
+		return nil, ErrInvalidDate
+	}
+	r, err := uc.reports.Get(ctx, date)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrReportNotFound
+	}
+	return r, err
+}
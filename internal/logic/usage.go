@@ -0,0 +1,100 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/errs"
+)
+
+// ErrInvalidRange 开始日期晚于结束日期
+var ErrInvalidRange = errs.New("usage.invalid_range", "from_date must not be after to_date")
+
+// UsageUseCase 调用方用量的查询和导出, 用量由计量拦截器写入
+type UsageUseCase struct {
+	usage db.UsageStore
+}
+
+// NewUsageUseCase 创建UsageUseCase
+func NewUsageUseCase(reg db.Registry) *UsageUseCase {
+	return &UsageUseCase{usage: reg.Usage()}
+}
+
+// Get 查询[from, to](YYYY-MM-DD)内的用量, from为空时为当天(UTC), to为空时与from相同; caller为空时返回所有调用方
+func (uc *UsageUseCase) Get(ctx context.Context, caller, from, to string) ([]*model.Usage, error) {
+	if from == "" {
+		from = time.Now().UTC().Format(DateLayout)
+	}
+	if to == "" {
+		to = from
+	}
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse(DateLayout, d); err != nil {
+			return nil, ErrInvalidDate
+		}
+	}
+	if from > to {
+		return nil, ErrInvalidRange
+	}
+	return uc.usage.List(ctx, caller, from, to)
+}
+
+// usageLine 导出文件中的一行
+type usageLine struct {
+	Date          string `json:"date"`
+	Caller        string `json:"caller"`
+	Method        string `json:"method"`
+	Calls         int64  `json:"calls"`
+	Errors        int64  `json:"errors"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+	ComputeUnits  int64  `json:"compute_units"`
+}
+
+// Export 把date(YYYY-MM-DD)所有调用方的用量写入dir下的 usage-<date>.jsonl, 每行一条, 已有文件时覆盖.
+// 先写临时文件再改名, 读取方不会读到写了一半的文件
+func (uc *UsageUseCase) Export(ctx context.Context, date, dir string) (string, error) {
+	us, err := uc.Get(ctx, "", date, date)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "usage-"+date+".jsonl")
+	f, err := os.CreateTemp(dir, ".usage-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	// CreateTemp只允许本用户读写, 导出文件供其他程序读取
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, u := range us {
+		if err := enc.Encode(usageLine{
+			Date: u.Date, Caller: u.Caller, Method: u.Method, Calls: u.Calls, Errors: u.Errors,
+			RequestBytes: u.RequestBytes, ResponseBytes: u.ResponseBytes, ComputeUnits: u.ComputeUnits,
+		}); err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(f.Name(), path)
+}
@@ -0,0 +1,495 @@
+package logic
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/locale"
+	"github.com/Q1mi/greeter/pkg/mailtmpl"
+	"github.com/Q1mi/greeter/pkg/notify"
+	"github.com/Q1mi/greeter/pkg/token"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// purposeVerifyEmail 邮箱验证token的用途
+const purposeVerifyEmail = "verify_email"
+
+// RoleAdmin 可以创建、修改和删除任意用户的角色
+const RoleAdmin = "admin"
+
+var (
+	// ErrInvalidUsername 用户名格式错误
+	ErrInvalidUsername = errs.New("user.invalid_username", "username must be 3-32 letters, digits or underscores")
+	// ErrInvalidEmail 邮箱格式错误
+	ErrInvalidEmail = errs.New("user.invalid_email", "invalid email address")
+	// ErrUserExists 用户名或邮箱已被注册
+	ErrUserExists = errs.New("user.exists", "username or email already registered")
+	// ErrInvalidToken 验证token无效或已过期
+	ErrInvalidToken = errs.New("user.invalid_token", "invalid or expired token")
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errs.New("user.not_found", "user not found")
+	// ErrInvalidPhone 手机号不是E.164格式
+	ErrInvalidPhone = errs.New("user.invalid_phone", "phone must be in E.164 format, e.g. +8613800138000")
+	// ErrUserForbidden 当前用户无权执行该操作: 修改和删除只允许用户本人或管理员, 创建和列表只允许管理员
+	ErrUserForbidden = errs.New("user.forbidden", "operation not allowed for the current user")
+	// ErrInvalidFilter 用户列表的筛选条件无法解析
+	ErrInvalidFilter = errs.New("user.invalid_filter", `invalid filter, e.g. status=ACTIVE AND username=ali* AND create_time>=2026-01-01T00:00:00Z`)
+	// ErrInvalidOrderBy 用户列表的排序字段不支持
+	ErrInvalidOrderBy = errs.New("user.invalid_order_by", "order_by must be id, username or create_time, optionally followed by asc or desc")
+	// ErrInvalidAccess 角色或scope名称格式错误
+	ErrInvalidAccess = errs.New("user.invalid_access", "roles and scopes must be 1-64 letters, digits or _.:/- characters")
+)
+
+var (
+	usernameRE = regexp.MustCompile(`^[A-Za-z0-9_]{3,32}$`)
+	phoneRE    = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	accessRE   = regexp.MustCompile(`^[A-Za-z0-9_.:/-]{1,64}$`)
+)
+
+// CheckEmail 校验邮箱格式, 不合法时返回ErrInvalidEmail
+func CheckEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// CheckPhone 校验手机号格式, 空字符串表示没有手机号; 不合法时返回ErrInvalidPhone
+func CheckPhone(phone string) error {
+	if phone != "" && !phoneRE.MatchString(phone) {
+		return ErrInvalidPhone
+	}
+	return nil
+}
+
+// CheckAccess 校验角色或scope名称, 不合法时返回ErrInvalidAccess
+func CheckAccess(names []string) error {
+	for _, n := range names {
+		if !accessRE.MatchString(n) {
+			return ErrInvalidAccess
+		}
+	}
+	return nil
+}
+
+// UserUseCase 用户注册和邮箱验证
+type UserUseCase struct {
+	reg    db.Registry
+	users  db.UserStore
+	auth   *AuthUseCase
+	signer *token.Signer
+	notify *notify.Dispatcher
+	mail   *mailtmpl.Renderer
+
+	// VerifyTTL 验证token有效期
+	VerifyTTL time.Duration
+	// VerifyURL 验证链接前缀
+	VerifyURL string
+	// Profiles 不为nil时查询用户时补充外部资料
+	Profiles ProfileSource
+}
+
+// NewUserUseCase 创建UserUseCase
+func NewUserUseCase(reg db.Registry, auth *AuthUseCase, signer *token.Signer, n *notify.Dispatcher, mail *mailtmpl.Renderer) *UserUseCase {
+	return &UserUseCase{
+		reg:       reg,
+		users:     reg.Users(),
+		auth:      auth,
+		signer:    signer,
+		notify:    n,
+		mail:      mail,
+		VerifyTTL: 24 * time.Hour,
+	}
+}
+
+// Register 创建待验证的用户并异步发送验证邮件
+func (uc *UserUseCase) Register(ctx context.Context, username, email, password string) (*model.User, error) {
+	u, err := uc.create(ctx, &model.User{Username: username, Email: email, Status: model.UserPending}, password)
+	if err != nil {
+		return nil, err
+	}
+	ctx = zaplog.With(ctx, zaplog.Int64("user_id", u.ID))
+	ctxutil.TagsFrom(ctx).Set("user_id", strconv.FormatInt(u.ID, 10))
+
+	tok := uc.signer.Sign(purposeVerifyEmail, strconv.FormatInt(u.ID, 10), uc.VerifyTTL)
+	// 按请求的Accept-Language选择邮件语言
+	verify, err := uc.mail.Render(mailtmpl.VerifyEmail, locale.FromIncomingContext(ctx), mailtmpl.VerifyEmailData{
+		Username: username,
+		URL:      uc.VerifyURL + tok,
+		TTL:      uc.VerifyTTL.String(),
+	})
+	if err != nil {
+		zaplog.FromContext(ctx).Error("register: render verification email", zaplog.Error(err))
+		return u, nil
+	}
+	if err := uc.notify.Dispatch(ctx, verify.Message(email)); err != nil {
+		zaplog.FromContext(ctx).Warn("register: queue verification email", zaplog.Error(err))
+	}
+	return u, nil
+}
+
+// Create 由管理员创建已激活的用户, 不发送验证邮件. 使用u的用户名、邮箱、手机号、角色和scope, 其他字段被忽略
+func (uc *UserUseCase) Create(ctx context.Context, u *model.User, password string) (*model.User, error) {
+	if c, ok := ctxutil.ClaimsFrom(ctx); !ok || !c.HasRole(RoleAdmin) {
+		return nil, ErrUserForbidden
+	}
+	return uc.create(ctx, &model.User{
+		Username: u.Username,
+		Email:    u.Email,
+		Phone:    u.Phone,
+		Status:   model.UserActive,
+		Roles:    u.Roles,
+		Scopes:   u.Scopes,
+	}, password)
+}
+
+// create 校验并在同一事务中创建用户u和登录凭证
+func (uc *UserUseCase) create(ctx context.Context, u *model.User, password string) (*model.User, error) {
+	if !usernameRE.MatchString(u.Username) {
+		return nil, ErrInvalidUsername
+	}
+	if err := CheckEmail(u.Email); err != nil {
+		return nil, err
+	}
+	if err := CheckPhone(u.Phone); err != nil {
+		return nil, err
+	}
+	if err := CheckAccess(u.Roles); err != nil {
+		return nil, err
+	}
+	if err := CheckAccess(u.Scopes); err != nil {
+		return nil, err
+	}
+	// 在事务之外计算哈希, 避免占用事务
+	hash, err := uc.auth.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.reg.Transaction(ctx, func(ctx context.Context, tx db.Registry) error {
+		if err := tx.Users().Create(ctx, u); err != nil {
+			return err
+		}
+		return tx.Credentials().Create(ctx, &model.Credential{UserID: u.ID, Username: u.Username, PasswordHash: hash})
+	})
+	if errors.Is(err, db.ErrDuplicate) {
+		return nil, ErrUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ImportUser 批量导入的一个用户
+type ImportUser struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ImportResult 批量导入的结果
+type ImportResult struct {
+	Created int
+	// Skipped 用户名或邮箱已存在而跳过的用户数
+	Skipped int
+	// Invalid 格式错误而未导入的用户, key为用户名
+	Invalid map[string]error
+}
+
+// Import 批量创建已激活的用户, 不发送验证邮件. 已存在的用户跳过, 因此重复导入同一批用户是安全的.
+// 只有存储出错时返回error, 此前已创建的用户保留, 重试时会被跳过
+func (uc *UserUseCase) Import(ctx context.Context, users []ImportUser) (*ImportResult, error) {
+	res := &ImportResult{Invalid: map[string]error{}}
+	for _, iu := range users {
+		_, err := uc.create(ctx, &model.User{Username: iu.Username, Email: iu.Email, Status: model.UserActive}, iu.Password)
+		switch {
+		case err == nil:
+			res.Created++
+		case errors.Is(err, ErrUserExists):
+			res.Skipped++
+		case errors.Is(err, ErrInvalidUsername), errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrWeakPassword),
+			errors.Is(err, ErrPasswordTooLong):
+			res.Invalid[iu.Username] = err
+		default:
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Get 按ID查询用户
+func (uc *UserUseCase) Get(ctx context.Context, id int64) (*model.User, error) {
+	u, err := uc.users.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+// GetWithProfile 按ID查询用户并补充外部资料. 资料服务失败或没有资料时profile为nil, 不影响查询结果
+func (uc *UserUseCase) GetWithProfile(ctx context.Context, id int64) (*model.User, *model.UserProfile, error) {
+	u, err := uc.Get(ctx, id)
+	if err != nil || uc.Profiles == nil {
+		return u, nil, err
+	}
+	p, err := uc.Profiles.Get(ctx, id)
+	if err != nil {
+		zaplog.FromContext(ctx).Warn("user: get profile", zaplog.Int64("user_id", id), zaplog.Error(err))
+		return u, nil, nil
+	}
+	return u, p, nil
+}
+
+// VerifyEmail 校验token并激活用户, 重复验证直接返回当前用户
+func (uc *UserUseCase) VerifyEmail(ctx context.Context, tok string) (*model.User, error) {
+	sub, err := uc.signer.Verify(purposeVerifyEmail, tok)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	id, err := strconv.ParseInt(sub, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	u, err := uc.users.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Status == model.UserActive {
+		return u, nil
+	}
+	u.Status = model.UserActive
+	if err := uc.users.Update(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UserUpdate 要修改的字段, 为nil的字段不修改
+type UserUpdate struct {
+	Email *string
+	// Phone 为空字符串时清除手机号
+	Phone *string
+	// Access 替换用户的角色和scope, 只有管理员可以设置
+	Access *UserAccess
+}
+
+// UserAccess 用户的角色和scope
+type UserAccess struct {
+	Roles  []string
+	Scopes []string
+}
+
+// Update 修改用户的邮箱、手机号和权限, 只有用户本人或管理员可以修改, 权限只有管理员可以修改.
+// 修改权限后已签发的access token不变, 新权限在下次登录或刷新时生效
+func (uc *UserUseCase) Update(ctx context.Context, id int64, upd UserUpdate) (*model.User, error) {
+	if !canModify(ctx, id) {
+		return nil, ErrUserForbidden
+	}
+	if upd.Access != nil {
+		if c, _ := ctxutil.ClaimsFrom(ctx); !c.HasRole(RoleAdmin) {
+			return nil, ErrUserForbidden
+		}
+	}
+	u, err := uc.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upd.Email != nil {
+		if err := CheckEmail(*upd.Email); err != nil {
+			return nil, err
+		}
+		u.Email = *upd.Email
+	}
+	if upd.Phone != nil {
+		if err := CheckPhone(*upd.Phone); err != nil {
+			return nil, err
+		}
+		u.Phone = *upd.Phone
+	}
+	if a := upd.Access; a != nil {
+		if err := CheckAccess(a.Roles); err != nil {
+			return nil, err
+		}
+		if err := CheckAccess(a.Scopes); err != nil {
+			return nil, err
+		}
+		u.Roles, u.Scopes = a.Roles, a.Scopes
+	}
+	switch err := uc.users.Update(ctx, u); {
+	case errors.Is(err, db.ErrNotFound):
+		return nil, ErrUserNotFound
+	case errors.Is(err, db.ErrDuplicate):
+		return nil, ErrUserExists
+	case err != nil:
+		return nil, err
+	}
+	return u, nil
+}
+
+// Delete 删除用户和登录凭证, 只有用户本人或管理员可以删除. 已签发的access token在过期前仍然有效,
+// refresh token在刷新时因用户不存在而失效
+func (uc *UserUseCase) Delete(ctx context.Context, id int64) error {
+	if !canModify(ctx, id) {
+		return ErrUserForbidden
+	}
+	err := uc.reg.Transaction(ctx, func(ctx context.Context, tx db.Registry) error {
+		if err := tx.Credentials().Delete(ctx, id); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		return tx.Users().Delete(ctx, id)
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// canModify 当前用户是否可以修改用户id
+func canModify(ctx context.Context, id int64) bool {
+	c, ok := ctxutil.ClaimsFrom(ctx)
+	return ok && (c.UserID == id || c.HasRole(RoleAdmin))
+}
+
+// userOrders order_by中可用的排序字段
+var userOrders = map[string]db.UserOrder{
+	"id":          db.OrderByID,
+	"username":    db.OrderByUsername,
+	"create_time": db.OrderByCreatedAt,
+}
+
+// userPageToken 用户列表的分页token, 记录上一页最后一个用户的排序字段和查询条件
+type userPageToken struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"u,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	// Query 生成token时的order_by和filter, 与本次请求不同时token无效
+	Query string `json:"q"`
+}
+
+// List 分页查询用户, 只有管理员可以调用. orderBy如 "create_time desc", 默认按ID升序;
+// filter为用 AND 连接的条件: status=ACTIVE或PENDING, username=<前缀>*, create_time>=和create_time<(RFC3339时间).
+// 筛选和排序由存储完成; nextToken为空表示没有更多数据
+func (uc *UserUseCase) List(ctx context.Context, pageSize int, pageToken, orderBy, filter string) ([]*model.User, string, error) {
+	if c, ok := ctxutil.ClaimsFrom(ctx); !ok || !c.HasRole(RoleAdmin) {
+		return nil, "", ErrUserForbidden
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	q := db.UserQuery{Limit: pageSize + 1}
+	if err := parseUserOrder(orderBy, &q); err != nil {
+		return nil, "", err
+	}
+	if err := parseUserFilter(filter, &q); err != nil {
+		return nil, "", err
+	}
+	query := orderBy + "\n" + filter
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", ErrInvalidPageToken
+		}
+		var t userPageToken
+		if err := json.Unmarshal(b, &t); err != nil || t.ID <= 0 || t.Query != query {
+			return nil, "", ErrInvalidPageToken
+		}
+		q.After = &model.User{ID: t.ID, Username: t.Username, CreatedAt: t.CreatedAt}
+	}
+	// 多取一条用于判断是否还有下一页
+	list, err := uc.users.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		last := list[pageSize-1]
+		b, _ := json.Marshal(userPageToken{ID: last.ID, Username: last.Username, CreatedAt: last.CreatedAt, Query: query})
+		next = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return list, next, nil
+}
+
+// parseUserOrder 解析 "<字段> [asc|desc]"
+func parseUserOrder(orderBy string, q *db.UserQuery) error {
+	fields := strings.Fields(orderBy)
+	if len(fields) == 0 {
+		return nil
+	}
+	order, ok := userOrders[fields[0]]
+	if !ok || len(fields) > 2 {
+		return ErrInvalidOrderBy
+	}
+	q.Order = order
+	if len(fields) == 2 {
+		switch strings.ToLower(fields[1]) {
+		case "asc":
+		case "desc":
+			q.Desc = true
+		default:
+			return ErrInvalidOrderBy
+		}
+	}
+	return nil
+}
+
+// parseUserFilter 解析用 AND 连接的筛选条件, 同一字段重复出现时以最后一个为准
+func parseUserFilter(filter string, q *db.UserQuery) error {
+	if strings.TrimSpace(filter) == "" {
+		return nil
+	}
+	for _, term := range strings.Split(filter, " AND ") {
+		term = strings.TrimSpace(term)
+		switch {
+		case strings.HasPrefix(term, "status="):
+			switch strings.TrimPrefix(term, "status=") {
+			case "ACTIVE":
+				q.Status = model.UserActive
+			case "PENDING":
+				q.Status = model.UserPending
+			default:
+				return ErrInvalidFilter
+			}
+		case strings.HasPrefix(term, "username="):
+			v := strings.TrimPrefix(term, "username=")
+			if !strings.HasSuffix(v, "*") || strings.Count(v, "*") != 1 {
+				return ErrInvalidFilter
+			}
+			q.UsernamePrefix = strings.TrimSuffix(v, "*")
+		case strings.HasPrefix(term, "create_time>="):
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(term, "create_time>="))
+			if err != nil {
+				return ErrInvalidFilter
+			}
+			q.CreatedFrom = t
+		case strings.HasPrefix(term, "create_time<"):
+			t, err := time.Parse(time.RFC3339, strings.TrimPrefix(term, "create_time<"))
+			if err != nil {
+				return ErrInvalidFilter
+			}
+			q.CreatedTo = t
+		default:
+			return ErrInvalidFilter
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+)
+
+// listAll 按pageSize翻页读取全部用户名
+func listAll(t *testing.T, uc *UserUseCase, pageSize int, orderBy, filter string) []string {
+	t.Helper()
+	var names []string
+	token := ""
+	for i := 0; ; i++ {
+		if i > 20 {
+			t.Fatal("too many pages")
+		}
+		list, next, err := uc.List(adminContext(), pageSize, token, orderBy, filter)
+		if err != nil {
+			t.Fatalf("List(%q, %q): %v", orderBy, filter, err)
+		}
+		if len(list) > pageSize {
+			t.Fatalf("page has %d users, page_size %d", len(list), pageSize)
+		}
+		for _, u := range list {
+			names = append(names, u.Username)
+		}
+		if next == "" {
+			return names
+		}
+		token = next
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	e := newTestEnv(t)
+	for _, name := range []string{"carol", "alice", "bob", "alfred", "dave"} {
+		e.addUser(t, name, nil, nil)
+	}
+	if _, err := e.users.create(context.Background(), &model.User{Username: "alan", Email: "alan@example.com", Status: model.UserPending}, testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orderBy, filter string
+		want            string
+	}{
+		{"", "", "carol,alice,bob,alfred,dave,alan"},
+		{"username", "", "alan,alfred,alice,bob,carol,dave"},
+		{"username desc", "", "dave,carol,bob,alice,alfred,alan"},
+		{"id DESC", "", "alan,dave,alfred,bob,alice,carol"},
+		{"create_time", "", "carol,alice,bob,alfred,dave,alan"},
+		{"username", "username=al*", "alan,alfred,alice"},
+		{"username", "status=ACTIVE AND username=al*", "alfred,alice"},
+		{"", "status=PENDING", "alan"},
+		{"", "create_time>=" + time.Now().Add(time.Hour).Format(time.RFC3339), ""},
+		{"", "create_time<" + time.Now().Add(time.Hour).Format(time.RFC3339) + " AND username=b*", "bob"},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{1, 2, 100} {
+			got := strings.Join(listAll(t, e.users, size, tt.orderBy, tt.filter), ",")
+			if got != tt.want {
+				t.Errorf("order_by %q filter %q page_size %d: got %s, want %s", tt.orderBy, tt.filter, size, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestListUsersInvalid(t *testing.T) {
+	e := newTestEnv(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		e.addUser(t, name, nil, nil)
+	}
+	_, next, err := e.users.List(adminContext(), 1, "", "username", "")
+	if err != nil || next == "" {
+		t.Fatalf("first page: next %q, err %v", next, err)
+	}
+
+	tests := []struct {
+		name                       string
+		pageToken, orderBy, filter string
+		want                       error
+	}{
+		{"token with another order_by", next, "username desc", "", ErrInvalidPageToken},
+		{"token with another filter", next, "username", "status=ACTIVE", ErrInvalidPageToken},
+		{"malformed token", "not-a-token!", "", "", ErrInvalidPageToken},
+		{"unknown order field", "", "email", "", ErrInvalidOrderBy},
+		{"bad direction", "", "username up", "", ErrInvalidOrderBy},
+		{"unknown filter field", "", "", "email=a@example.com", ErrInvalidFilter},
+		{"unknown status", "", "", "status=DELETED", ErrInvalidFilter},
+		{"username without wildcard", "", "", "username=bob", ErrInvalidFilter},
+		{"bad time", "", "", "create_time>=yesterday", ErrInvalidFilter},
+	}
+	for _, tt := range tests {
+		if _, _, err := e.users.List(adminContext(), 1, tt.pageToken, tt.orderBy, tt.filter); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	// 同样的order_by和filter可以继续翻页
+	if list, _, err := e.users.List(adminContext(), 1, next, "username", ""); err != nil || len(list) != 1 || list[0].Username != "bob" {
+		t.Errorf("second page = %v, %v, want bob", list, err)
+	}
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// AuditEvent 一条审计记录, 由审计拦截器在敏感的RPC结束后写入, 只追加不修改
+type AuditEvent struct {
+	ID   int64
+	Time time.Time
+	// Method gRPC方法全名
+	Method string
+	// Actor 调用者: user:<id>、app:<app_id> 或 anonymous
+	Actor    string
+	UserID   int64
+	Username string
+	AppID    string
+	// Resource 操作对象, 如 id=42
+	Resource string
+	// Outcome success、denied 或 error
+	Outcome string
+	// Code gRPC状态码
+	Code      string
+	RequestID string
+	TraceID   string
+	ClientIP  string
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Blog 博客文章
+type Blog struct {
+	ID int64
+	// AuthorID 作者的用户ID
+	AuthorID  int64
+	Title     string
+	Content   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,13 @@
+// Package model 定义业务模型
+package model
+
+import "time"
+
+// Credential 用户的登录凭据
+type Credential struct {
+	UserID   int64
+	Username string
+	// PasswordHash passwd包生成的哈希字符串, 包含算法和参数
+	PasswordHash string
+	UpdatedAt    time.Time
+}
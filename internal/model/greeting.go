@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// GreetingTemplate 问候语模板, Text为text/template语法
+type GreetingTemplate struct {
+	ID        string
+	Text      string
+	UpdatedAt time.Time
+}
+
+// Greeting 一次SayHello调用的记录
+type Greeting struct {
+	ID      int64
+	Name    string
+	Message string
+	Locale  string
+	// TemplateID 使用的问候语模板
+	TemplateID string
+	// Caller 调用方, 已登录时为 user:<id>, 否则为客户端IP
+	Caller    string
+	CreatedAt time.Time
+}
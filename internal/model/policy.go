@@ -0,0 +1,11 @@
+package model
+
+// PolicyRule 一条授权规则, 字段与casbin_rule表一致.
+// PType为p时V0、V1、V2依次为主体、方法(可以*结尾)、效果(allow或deny);
+// 为g时V0属于角色V1
+type PolicyRule struct {
+	PType string
+	V0    string
+	V1    string
+	V2    string
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Report 一天(UTC)的问候和用户统计, 由每日任务生成
+type Report struct {
+	// Date 统计日期, 格式 2006-01-02
+	Date string
+	// Greetings 问候次数
+	Greetings int64
+	// UniqueCallers 不同调用方的数量
+	UniqueCallers int64
+	// ByLocale 按语言统计的问候次数
+	ByLocale map[string]int64
+	// ByTemplate 按模板统计的问候次数
+	ByTemplate map[string]int64
+	// NewUsers 当天注册的用户数
+	NewUsers    int64
+	GeneratedAt time.Time
+}
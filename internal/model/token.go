@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// RefreshToken 登录签发的refresh token, 只保存哈希, 原文只在签发时返回给客户端
+type RefreshToken struct {
+	// Hash token原文的SHA-256(十六进制)
+	Hash     string
+	UserID   int64
+	Username string
+	TenantID string
+	// FamilyID 同一次登录及其后轮换得到的token属于同一族, 检测到重复使用时整族作废
+	FamilyID  string
+	ExpiresAt time.Time
+	// UsedAt 第一次用于换取access token的时间, 未使用时为零值
+	UsedAt time.Time
+	// RevokedAt 作废时间, 未作废时为零值
+	RevokedAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,17 @@
+package model
+
+// Usage 一个调用方在一天(UTC)内调用一个方法的累计用量, 由计量拦截器定期写入
+type Usage struct {
+	// Date 日期, 格式 2006-01-02
+	Date   string
+	Caller string
+	// Method gRPC方法全名
+	Method string
+	Calls  int64
+	// Errors 返回错误的调用次数, 已包含在Calls中
+	Errors        int64
+	RequestBytes  int64
+	ResponseBytes int64
+	// ComputeUnits 每次调用按handler耗时的毫秒数向上取整, 至少为1
+	ComputeUnits int64
+}
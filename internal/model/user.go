@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// UserStatus 用户状态
+type UserStatus int
+
+const (
+	// UserPending 已注册, 等待验证邮箱
+	UserPending UserStatus = iota + 1
+	// UserActive 已激活
+	UserActive
+)
+
+// User 用户
+type User struct {
+	ID       int64
+	Username string
+	Email    string
+	// Phone E.164格式的手机号, 可以为空
+	Phone  string
+	Status UserStatus
+	// Roles, Scopes 签入access token, 供授权规则判断. 名称中不能含空格
+	Roles     []string
+	Scopes    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Clone 返回u的副本, Roles和Scopes不与u共用底层数组
+func (u *User) Clone() *User {
+	cp := *u
+	cp.Roles = append([]string(nil), u.Roles...)
+	cp.Scopes = append([]string(nil), u.Scopes...)
+	return &cp
+}
+
+// UserProfile 外部资料服务提供的用户资料
+type UserProfile struct {
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Bio         string `json:"bio"`
+}
@@ -0,0 +1,178 @@
+// Package cached 为db.Registry中的存储增加缓存, 数据修改时清除对应的缓存并通知其他实例
+package cached
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/cache"
+	"github.com/Q1mi/greeter/pkg/tracing"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+type registry struct {
+	db.Registry
+	users *users
+}
+
+// NewRegistry 包装reg, Users()返回带缓存的UserStore.
+// 通过Update、Delete修改用户后清除缓存并在bus上发布失效通知, 其他实例收到后清除各自的缓存.
+func NewRegistry(reg db.Registry, c cache.Cache, bus cache.Bus, ttl time.Duration) db.Registry {
+	u := &users{next: reg.Users(), cache: c, bus: bus, ttl: ttl}
+	bus.Subscribe(u.evict)
+	return &registry{Registry: reg, users: u}
+}
+
+func (r *registry) Users() db.UserStore { return r.users }
+
+// Transaction 事务中的用户读写都不经过缓存, 避免缓存未提交的数据; 修改过的用户在事务结束后统一清除
+func (r *registry) Transaction(ctx context.Context, f func(ctx context.Context, tx db.Registry) error) error {
+	var keys []string
+	err := r.Registry.Transaction(ctx, func(ctx context.Context, tx db.Registry) error {
+		return f(ctx, &txRegistry{Registry: tx, users: txUsers{UserStore: tx.Users(), keys: &keys}})
+	})
+	if len(keys) > 0 {
+		r.users.invalidate(ctx, keys...)
+	}
+	return err
+}
+
+// txRegistry 事务中的registry, 记录修改过的用户
+type txRegistry struct {
+	db.Registry
+	users txUsers
+}
+
+func (r *txRegistry) Users() db.UserStore { return r.users }
+
+func (r *txRegistry) Transaction(ctx context.Context, f func(ctx context.Context, tx db.Registry) error) error {
+	return r.Registry.Transaction(ctx, func(ctx context.Context, _ db.Registry) error { return f(ctx, r) })
+}
+
+type txUsers struct {
+	db.UserStore
+	keys *[]string
+}
+
+func (s txUsers) Update(ctx context.Context, u *model.User) error {
+	*s.keys = append(*s.keys, userKey(u.ID))
+	return s.UserStore.Update(ctx, u)
+}
+
+func (s txUsers) Delete(ctx context.Context, id int64) error {
+	*s.keys = append(*s.keys, userKey(id))
+	return s.UserStore.Delete(ctx, id)
+}
+
+// loadTimeout 共享的查库的超时时间. 查询不随发起请求的ctx取消, 否则该请求取消时等待同一结果的请求都会失败
+const loadTimeout = 5 * time.Second
+
+// detached 保留ctx中的值(trace、日志字段等), 但不继承取消和截止时间
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+type users struct {
+	// gen 每次失效时加一. 读库期间若发生失效则不回填缓存, 避免把旧数据写回.
+	// 放在第一个字段以保证32位平台上的原子操作对齐
+	gen   uint64
+	next  db.UserStore
+	cache cache.Cache
+	bus   cache.Bus
+	ttl   time.Duration
+	loads cache.Group
+}
+
+// entry 缓存的用户及填充缓存时所在的span
+type entry struct {
+	User     *model.User         `json:"user"`
+	FilledBy tracing.SpanContext `json:"filled_by"`
+}
+
+func userKey(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10)
+}
+
+func (s *users) Get(ctx context.Context, id int64) (*model.User, error) {
+	key := userKey(id)
+	if b, err := s.cache.Get(ctx, key); err == nil {
+		var e entry
+		if err := json.Unmarshal(b, &e); err == nil && e.User != nil {
+			// 关联到填充这条缓存的请求, 便于在trace中找到数据的来源
+			tracing.FromContext(ctx).AddLink(e.FilledBy)
+			return e.User, nil
+		}
+	} else if err != cache.ErrMiss {
+		zaplog.FromContext(ctx).Warn("cached: get", zaplog.String("key", key), zaplog.Error(err))
+	}
+
+	// 同一用户的并发未命中只查一次库, 其余请求共享结果
+	v, err, _ := s.loads.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(detached{ctx}, loadTimeout)
+		defer cancel()
+		gen := atomic.LoadUint64(&s.gen)
+		u, err := s.next.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if atomic.LoadUint64(&s.gen) == gen {
+			b, _ := json.Marshal(entry{User: u, FilledBy: tracing.FromContext(ctx).SpanContext()})
+			if err := s.cache.Set(ctx, key, b, s.ttl); err != nil {
+				zaplog.FromContext(ctx).Warn("cached: set", zaplog.String("key", key), zaplog.Error(err))
+			}
+		}
+		return u, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 共享的结果可能被多个请求持有, 返回副本避免互相修改
+	u := *v.(*model.User)
+	return &u, nil
+}
+
+func (s *users) Create(ctx context.Context, u *model.User) error {
+	return s.next.Create(ctx, u)
+}
+
+func (s *users) CountCreated(ctx context.Context, from, to time.Time) (int64, error) {
+	return s.next.CountCreated(ctx, from, to)
+}
+
+func (s *users) List(ctx context.Context, q db.UserQuery) ([]*model.User, error) {
+	return s.next.List(ctx, q)
+}
+
+func (s *users) Update(ctx context.Context, u *model.User) error {
+	err := s.next.Update(ctx, u)
+	s.invalidate(ctx, userKey(u.ID))
+	return err
+}
+
+func (s *users) Delete(ctx context.Context, id int64) error {
+	err := s.next.Delete(ctx, id)
+	s.invalidate(ctx, userKey(id))
+	return err
+}
+
+// invalidate 清除本实例的缓存并通知其他实例. 写库失败时同样清除, 因为无法确定写入是否已部分生效
+func (s *users) invalidate(ctx context.Context, keys ...string) {
+	s.evict(keys)
+	if err := s.bus.Publish(ctx, keys...); err != nil {
+		zaplog.FromContext(ctx).Warn("cached: publish invalidation", zaplog.Any("keys", keys), zaplog.Error(err))
+	}
+}
+
+func (s *users) evict(keys []string) {
+	atomic.AddUint64(&s.gen, 1)
+	if err := s.cache.Delete(context.Background(), keys...); err != nil {
+		zaplog.L().Warn("cached: delete", zaplog.Any("keys", keys), zaplog.Error(err))
+	}
+}
@@ -0,0 +1,71 @@
+package cached
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/cache"
+)
+
+// slowUsers 的Get等到release关闭后才返回, 返回前检查ctx, 与查询途中发现ctx取消的数据库驱动相同
+type slowUsers struct {
+	db.UserStore
+	started chan struct{}
+	release chan struct{}
+	calls   int32
+}
+
+func (s *slowUsers) Get(ctx context.Context, id int64) (*model.User, error) {
+	if atomic.AddInt32(&s.calls, 1) == 1 {
+		close(s.started)
+	}
+	<-s.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &model.User{ID: id, Username: "alice"}, nil
+}
+
+// TestGetLeaderCanceled 发起共享查询的请求取消后, 等待同一结果的请求仍然成功
+func TestGetLeaderCanceled(t *testing.T) {
+	next := &slowUsers{started: make(chan struct{}), release: make(chan struct{})}
+	s := &users{next: next, cache: cache.NewMemory(10), bus: cache.NewLocalBus(), ttl: time.Minute}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := s.Get(leaderCtx, 1)
+		leader <- err
+	}()
+	<-next.started
+	cancel()
+
+	follower := make(chan error, 1)
+	go func() {
+		u, err := s.Get(context.Background(), 1)
+		if err == nil && u.Username != "alice" {
+			t.Errorf("follower got %+v", u)
+		}
+		follower <- err
+	}()
+	// 等待follower加入共享查询
+	time.Sleep(50 * time.Millisecond)
+	close(next.release)
+
+	if err := <-follower; err != nil {
+		t.Errorf("follower: %v, want the shared result", err)
+	}
+	<-leader
+	if n := atomic.LoadInt32(&next.calls); n != 1 {
+		t.Errorf("store queried %d times, want 1", n)
+	}
+	// 结果已回填缓存
+	_, err := s.Get(context.Background(), 1)
+	if n := atomic.LoadInt32(&next.calls); err != nil || n != 1 {
+		t.Errorf("get after the shared load: %v, store queried %d times", err, n)
+	}
+}
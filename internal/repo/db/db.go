@@ -0,0 +1,187 @@
+// Package db 定义数据存储接口.
+//
+// MySQL和PostgreSQL实现共用sql.go中基于database/sql的一份SQL, 方言差异集中在dialect中;
+// 没有使用GORM之类的ORM: 刷新token的FOR UPDATE、按游标分页和唯一键冲突的判断都需要明确的SQL,
+// 表结构由migrations中带版本的迁移管理而不是AutoMigrate. 驱动是pkg/mysql和pkg/postgres中
+// 只实现文本协议的精简实现, DSN分别与go-sql-driver/mysql和libpq的URL格式兼容, 需要时只需在mysql.go和
+// postgres.go中替换driver.Connector.
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+)
+
+var (
+	// ErrNotFound 记录不存在
+	ErrNotFound = errors.New("db: record not found")
+	// ErrDuplicate 唯一键冲突
+	ErrDuplicate = errors.New("db: duplicate record")
+)
+
+// Registry 汇总所有存储, logic层通过它访问数据
+type Registry interface {
+	Users() UserStore
+	Credentials() CredentialStore
+	GreetingTemplates() GreetingTemplateStore
+	Greetings() GreetingStore
+	Policies() PolicyStore
+	Reports() ReportStore
+	Usage() UsageStore
+	RefreshTokens() RefreshTokenStore
+	Blogs() BlogStore
+	AuditEvents() AuditStore
+	// Transaction 在事务中执行f: f通过tx访问的存储在f返回nil时一起提交, 返回错误或panic时全部回滚.
+	// f应使用传入的ctx, 其中包含事务的span; 在f中调用tx.Transaction时复用同一事务
+	Transaction(ctx context.Context, f func(ctx context.Context, tx Registry) error) error
+}
+
+// UserStore 用户存储
+type UserStore interface {
+	// Get 按ID查询, 不存在时返回ErrNotFound
+	Get(ctx context.Context, id int64) (*model.User, error)
+	// Create 创建用户并回填ID, 用户名或邮箱已存在时返回ErrDuplicate
+	Create(ctx context.Context, u *model.User) error
+	// Update 更新用户, 不存在时返回ErrNotFound
+	Update(ctx context.Context, u *model.User) error
+	// Delete 删除用户, 不存在时返回ErrNotFound
+	Delete(ctx context.Context, id int64) error
+	// CountCreated 统计创建时间在[from, to)内的用户数
+	CountCreated(ctx context.Context, from, to time.Time) (int64, error)
+	// List 按q筛选和排序, 返回After之后的最多q.Limit个用户. 实现应在存储中完成筛选、排序和分页, 不应读出全表
+	List(ctx context.Context, q UserQuery) ([]*model.User, error)
+}
+
+// UserOrder 用户列表的排序字段
+type UserOrder int
+
+const (
+	// OrderByID 按ID排序
+	OrderByID UserOrder = iota
+	// OrderByUsername 按用户名排序
+	OrderByUsername
+	// OrderByCreatedAt 按创建时间排序
+	OrderByCreatedAt
+)
+
+// UserQuery 用户列表的查询条件. 结果按Order排序, 值相同时按ID, 方向都由Desc决定
+type UserQuery struct {
+	// Status 为0时不限
+	Status model.UserStatus
+	// UsernamePrefix 用户名前缀, 为空时不限
+	UsernamePrefix string
+	// CreatedFrom 和 CreatedTo 限定创建时间在[CreatedFrom, CreatedTo)内, 零值表示不限
+	CreatedFrom, CreatedTo time.Time
+	Order                  UserOrder
+	Desc                   bool
+	// After 上一页的最后一个用户, 只使用排序字段和ID; 为nil时从头开始
+	After *model.User
+	Limit int
+}
+
+// CredentialStore 登录凭据存储
+type CredentialStore interface {
+	// GetByUsername 按用户名查询, 不存在时返回ErrNotFound
+	GetByUsername(ctx context.Context, username string) (*model.Credential, error)
+	// Create 创建凭据, 用户名已存在时返回ErrDuplicate; UserID为0时自动分配
+	Create(ctx context.Context, c *model.Credential) error
+	// UpdatePasswordHash 更新密码哈希, 不存在时返回ErrNotFound
+	UpdatePasswordHash(ctx context.Context, userID int64, hash string) error
+	// Delete 删除用户的凭据, 不存在时返回ErrNotFound
+	Delete(ctx context.Context, userID int64) error
+}
+
+// GreetingTemplateStore 问候语模板存储
+type GreetingTemplateStore interface {
+	// Get 按ID查询, 不存在时返回ErrNotFound
+	Get(ctx context.Context, id string) (*model.GreetingTemplate, error)
+	// Save 创建或更新模板
+	Save(ctx context.Context, t *model.GreetingTemplate) error
+}
+
+// GreetingStore 问候记录存储
+type GreetingStore interface {
+	// Create 保存记录并回填ID
+	Create(ctx context.Context, g *model.Greeting) error
+	// List 按ID倒序返回ID小于beforeID的最多limit条记录, beforeID为0时从最新的开始
+	List(ctx context.Context, beforeID int64, limit int) ([]*model.Greeting, error)
+}
+
+// PolicyStore 授权规则存储
+type PolicyStore interface {
+	// List 按添加顺序返回所有规则
+	List(ctx context.Context) ([]*model.PolicyRule, error)
+	// Add 添加规则, 已存在相同规则时返回ErrDuplicate
+	Add(ctx context.Context, r *model.PolicyRule) error
+	// Remove 删除规则, 不存在时返回ErrNotFound
+	Remove(ctx context.Context, r *model.PolicyRule) error
+}
+
+// ReportStore 统计报表存储
+type ReportStore interface {
+	// Get 按日期查询, 不存在时返回ErrNotFound
+	Get(ctx context.Context, date string) (*model.Report, error)
+	// Save 创建或覆盖同一日期的报表
+	Save(ctx context.Context, r *model.Report) error
+}
+
+// UsageStore 调用方用量存储
+type UsageStore interface {
+	// Add 把us累加到(日期, 调用方, 方法)相同的记录上, 没有时创建
+	Add(ctx context.Context, us []*model.Usage) error
+	// List 查询日期在[from, to](YYYY-MM-DD)内的用量, caller为空时返回所有调用方, 按日期、调用方、方法排序
+	List(ctx context.Context, caller, from, to string) ([]*model.Usage, error)
+}
+
+// RefreshTokenStore refresh token存储, 按token哈希查询
+type RefreshTokenStore interface {
+	// Create 保存token, 哈希已存在时返回ErrDuplicate
+	Create(ctx context.Context, t *model.RefreshToken) error
+	// Use 原子地把未使用的token标记为在at时使用, 返回标记前的记录(已使用过时UsedAt不为零值); 不存在时返回ErrNotFound
+	Use(ctx context.Context, hash string, at time.Time) (*model.RefreshToken, error)
+	// RevokeFamily 作废同一族中所有未作废的token
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	// RevokeUser 作废用户所有未作废的token, 用于修改密码后使其他登录失效
+	RevokeUser(ctx context.Context, userID int64, at time.Time) error
+}
+
+// BlogStore 博客存储
+type BlogStore interface {
+	// Create 保存博客并回填ID和时间
+	Create(ctx context.Context, b *model.Blog) error
+	// Get 按ID查询, 不存在时返回ErrNotFound
+	Get(ctx context.Context, id int64) (*model.Blog, error)
+	// List 按ID倒序返回满足q的最多q.Limit篇博客
+	List(ctx context.Context, q BlogQuery) ([]*model.Blog, error)
+}
+
+// BlogQuery 博客列表的查询条件
+type BlogQuery struct {
+	// AuthorID 为0时不限
+	AuthorID int64
+	// BeforeID 只返回ID小于它的博客, 为0时从最新的开始
+	BeforeID int64
+	Limit    int
+}
+
+// AuditStore 审计记录存储, 只追加
+type AuditStore interface {
+	// Append 保存审计记录并回填ID
+	Append(ctx context.Context, e *model.AuditEvent) error
+	// List 按ID倒序返回满足q的最多q.Limit条记录
+	List(ctx context.Context, q AuditQuery) ([]*model.AuditEvent, error)
+}
+
+// AuditQuery 审计记录的查询条件
+type AuditQuery struct {
+	// Actor 为空时不限
+	Actor string
+	// Method 为空时不限
+	Method string
+	// BeforeID 只返回ID小于它的记录, 为0时从最新的开始
+	BeforeID int64
+	Limit    int
+}
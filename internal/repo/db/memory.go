@@ -0,0 +1,727 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+)
+
+// memory 基于内存的Registry实现, 进程退出后数据丢失
+type memory struct {
+	// txMu 使事务依次执行
+	txMu   sync.Mutex
+	users  *memoryUsers
+	creds  *memoryCredentials
+	tmpls  *memoryTemplates
+	greets *memoryGreetings
+	rules  *memoryPolicies
+	rpts   *memoryReports
+	usage  *memoryUsage
+	tokens *memoryRefreshTokens
+	blogs  *memoryBlogs
+	audit  *memoryAudit
+}
+
+// NewMemory 创建基于内存的Registry
+func NewMemory() Registry {
+	return &memory{
+		users:  &memoryUsers{byID: map[int64]*model.User{}},
+		creds:  &memoryCredentials{byID: map[int64]*model.Credential{}, byName: map[string]int64{}},
+		tmpls:  &memoryTemplates{byID: map[string]*model.GreetingTemplate{}},
+		greets: &memoryGreetings{},
+		rules:  &memoryPolicies{},
+		rpts:   &memoryReports{byDate: map[string]*model.Report{}},
+		usage:  &memoryUsage{byKey: map[usageKey]*model.Usage{}},
+		tokens: &memoryRefreshTokens{byHash: map[string]*model.RefreshToken{}},
+		blogs:  &memoryBlogs{},
+		audit:  &memoryAudit{},
+	}
+}
+
+func (m *memory) Users() UserStore { return m.users }
+
+func (m *memory) Credentials() CredentialStore { return m.creds }
+
+func (m *memory) GreetingTemplates() GreetingTemplateStore { return m.tmpls }
+
+func (m *memory) Greetings() GreetingStore { return m.greets }
+
+func (m *memory) Policies() PolicyStore { return m.rules }
+
+func (m *memory) Reports() ReportStore { return m.rpts }
+
+func (m *memory) Usage() UsageStore { return m.usage }
+
+func (m *memory) RefreshTokens() RefreshTokenStore { return m.tokens }
+
+func (m *memory) Blogs() BlogStore { return m.blogs }
+
+func (m *memory) AuditEvents() AuditStore { return m.audit }
+
+type memoryUsers struct {
+	mu     sync.RWMutex
+	nextID int64
+	byID   map[int64]*model.User
+}
+
+func (s *memoryUsers) Get(ctx context.Context, id int64) (*model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return u.Clone(), nil
+}
+
+func (s *memoryUsers) Create(ctx context.Context, u *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, old := range s.byID {
+		if old.Username == u.Username || (u.Email != "" && old.Email == u.Email) {
+			return ErrDuplicate
+		}
+	}
+	s.nextID++
+	u.ID = s.nextID
+	now := time.Now()
+	u.CreatedAt, u.UpdatedAt = now, now
+	s.byID[u.ID] = u.Clone()
+	return nil
+}
+
+func (s *memoryUsers) Update(ctx context.Context, u *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.byID[u.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for id, other := range s.byID {
+		if id != u.ID && (other.Username == u.Username || (u.Email != "" && other.Email == u.Email)) {
+			return ErrDuplicate
+		}
+	}
+	u.CreatedAt = old.CreatedAt
+	u.UpdatedAt = time.Now()
+	s.byID[u.ID] = u.Clone()
+	return nil
+}
+
+func (s *memoryUsers) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.byID, id)
+	return nil
+}
+
+// put 保存u的副本, 用于回滚
+func (s *memoryUsers) put(u *model.User) {
+	s.mu.Lock()
+	s.byID[u.ID] = u
+	s.mu.Unlock()
+}
+
+func (s *memoryUsers) CountCreated(ctx context.Context, from, to time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n int64
+	for _, u := range s.byID {
+		if !u.CreatedAt.Before(from) && u.CreatedAt.Before(to) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryUsers) List(ctx context.Context, q UserQuery) ([]*model.User, error) {
+	s.mu.RLock()
+	var list []*model.User
+	for _, u := range s.byID {
+		if q.match(u) && (q.After == nil || q.less(q.After, u)) {
+			list = append(list, u.Clone())
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return q.less(list[i], list[j]) })
+	if q.Limit > 0 && len(list) > q.Limit {
+		list = list[:q.Limit]
+	}
+	return list, nil
+}
+
+// match u是否满足q的筛选条件
+func (q UserQuery) match(u *model.User) bool {
+	return (q.Status == 0 || u.Status == q.Status) &&
+		strings.HasPrefix(u.Username, q.UsernamePrefix) &&
+		(q.CreatedFrom.IsZero() || !u.CreatedAt.Before(q.CreatedFrom)) &&
+		(q.CreatedTo.IsZero() || u.CreatedAt.Before(q.CreatedTo))
+}
+
+// less 按q的排序a是否在b之前
+func (q UserQuery) less(a, b *model.User) bool {
+	if q.Desc {
+		a, b = b, a
+	}
+	switch q.Order {
+	case OrderByUsername:
+		if a.Username != b.Username {
+			return a.Username < b.Username
+		}
+	case OrderByCreatedAt:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	}
+	return a.ID < b.ID
+}
+
+type memoryCredentials struct {
+	mu     sync.RWMutex
+	nextID int64
+	byID   map[int64]*model.Credential
+	byName map[string]int64
+}
+
+func (s *memoryCredentials) GetByUsername(ctx context.Context, username string) (*model.Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.byName[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *s.byID[id]
+	return &c, nil
+}
+
+func (s *memoryCredentials) Create(ctx context.Context, c *model.Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byName[c.Username]; ok {
+		return ErrDuplicate
+	}
+	if c.UserID == 0 {
+		s.nextID++
+		c.UserID = s.nextID
+	} else if _, ok := s.byID[c.UserID]; ok {
+		return ErrDuplicate
+	} else if c.UserID > s.nextID {
+		s.nextID = c.UserID
+	}
+	if c.UpdatedAt.IsZero() {
+		c.UpdatedAt = time.Now()
+	}
+	cp := *c
+	s.byID[c.UserID] = &cp
+	s.byName[c.Username] = c.UserID
+	return nil
+}
+
+func (s *memoryCredentials) UpdatePasswordHash(ctx context.Context, userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	c.PasswordHash = hash
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *memoryCredentials) Delete(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.byID[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.byName, c.Username)
+	delete(s.byID, userID)
+	return nil
+}
+
+// get 按用户ID查询
+func (s *memoryCredentials) get(userID int64) (*model.Credential, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.byID[userID]
+	if !ok {
+		return nil, false
+	}
+	cp := *c
+	return &cp, true
+}
+
+// put 保存c, 用于回滚
+func (s *memoryCredentials) put(c *model.Credential) {
+	s.mu.Lock()
+	s.byID[c.UserID] = c
+	s.byName[c.Username] = c.UserID
+	s.mu.Unlock()
+}
+
+type memoryTemplates struct {
+	mu   sync.RWMutex
+	byID map[string]*model.GreetingTemplate
+}
+
+func (s *memoryTemplates) Get(ctx context.Context, id string) (*model.GreetingTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *t
+	return &cp, nil
+}
+
+func (s *memoryTemplates) Save(ctx context.Context, t *model.GreetingTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.UpdatedAt = time.Now()
+	cp := *t
+	s.byID[t.ID] = &cp
+	return nil
+}
+
+// put 保存t, t为nil时删除id, 用于回滚
+func (s *memoryTemplates) put(id string, t *model.GreetingTemplate) {
+	s.mu.Lock()
+	if t == nil {
+		delete(s.byID, id)
+	} else {
+		s.byID[id] = t
+	}
+	s.mu.Unlock()
+}
+
+type memoryGreetings struct {
+	mu     sync.RWMutex
+	nextID int64
+	// list 按ID升序排列, 回滚后ID可能不连续
+	list []*model.Greeting
+}
+
+func (s *memoryGreetings) Create(ctx context.Context, g *model.Greeting) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	g.ID = s.nextID
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	cp := *g
+	s.list = append(s.list, &cp)
+	return nil
+}
+
+func (s *memoryGreetings) List(ctx context.Context, beforeID int64, limit int) ([]*model.Greeting, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := len(s.list)
+	if beforeID > 0 {
+		end = sort.Search(end, func(i int) bool { return s.list[i].ID >= beforeID })
+	}
+	out := make([]*model.Greeting, 0, limit)
+	for i := end - 1; i >= 0 && len(out) < limit; i-- {
+		cp := *s.list[i]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+// remove 删除ID为id的记录, 用于回滚
+func (s *memoryGreetings) remove(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.list), func(i int) bool { return s.list[i].ID >= id })
+	if i < len(s.list) && s.list[i].ID == id {
+		s.list = append(s.list[:i], s.list[i+1:]...)
+	}
+}
+
+type memoryPolicies struct {
+	mu    sync.RWMutex
+	rules []model.PolicyRule
+}
+
+func (s *memoryPolicies) List(ctx context.Context) ([]*model.PolicyRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*model.PolicyRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		cp := r
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+func (s *memoryPolicies) Add(ctx context.Context, r *model.PolicyRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range s.rules {
+		if x == *r {
+			return ErrDuplicate
+		}
+	}
+	s.rules = append(s.rules, *r)
+	return nil
+}
+
+func (s *memoryPolicies) Remove(ctx context.Context, r *model.PolicyRule) error {
+	_, err := s.remove(r)
+	return err
+}
+
+// remove 删除规则并返回它原来的位置
+func (s *memoryPolicies) remove(r *model.PolicyRule) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, x := range s.rules {
+		if x == *r {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return i, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// insert 把规则放回位置i, 用于回滚
+func (s *memoryPolicies) insert(i int, r model.PolicyRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i > len(s.rules) {
+		i = len(s.rules)
+	}
+	s.rules = append(s.rules, model.PolicyRule{})
+	copy(s.rules[i+1:], s.rules[i:])
+	s.rules[i] = r
+}
+
+type memoryReports struct {
+	mu     sync.RWMutex
+	byDate map[string]*model.Report
+}
+
+func (s *memoryReports) Get(ctx context.Context, date string) (*model.Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.byDate[date]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyReport(r), nil
+}
+
+func (s *memoryReports) Save(ctx context.Context, r *model.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byDate[r.Date] = copyReport(r)
+	return nil
+}
+
+// put 保存r, r为nil时删除date, 用于回滚
+func (s *memoryReports) put(date string, r *model.Report) {
+	s.mu.Lock()
+	if r == nil {
+		delete(s.byDate, date)
+	} else {
+		s.byDate[date] = r
+	}
+	s.mu.Unlock()
+}
+
+func copyReport(r *model.Report) *model.Report {
+	cp := *r
+	cp.ByLocale = make(map[string]int64, len(r.ByLocale))
+	for k, v := range r.ByLocale {
+		cp.ByLocale[k] = v
+	}
+	cp.ByTemplate = make(map[string]int64, len(r.ByTemplate))
+	for k, v := range r.ByTemplate {
+		cp.ByTemplate[k] = v
+	}
+	return &cp
+}
+
+type usageKey struct {
+	date, caller, method string
+}
+
+type memoryUsage struct {
+	mu    sync.RWMutex
+	byKey map[usageKey]*model.Usage
+}
+
+func (s *memoryUsage) Add(ctx context.Context, us []*model.Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range us {
+		k := usageKey{u.Date, u.Caller, u.Method}
+		cur, ok := s.byKey[k]
+		if !ok {
+			cp := *u
+			s.byKey[k] = &cp
+			continue
+		}
+		cur.Calls += u.Calls
+		cur.Errors += u.Errors
+		cur.RequestBytes += u.RequestBytes
+		cur.ResponseBytes += u.ResponseBytes
+		cur.ComputeUnits += u.ComputeUnits
+	}
+	return nil
+}
+
+// sub 从记录中减去us, 用于回滚Add; 减到没有调用时删除记录
+func (s *memoryUsage) sub(us []*model.Usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range us {
+		k := usageKey{u.Date, u.Caller, u.Method}
+		cur, ok := s.byKey[k]
+		if !ok {
+			continue
+		}
+		cur.Calls -= u.Calls
+		cur.Errors -= u.Errors
+		cur.RequestBytes -= u.RequestBytes
+		cur.ResponseBytes -= u.ResponseBytes
+		cur.ComputeUnits -= u.ComputeUnits
+		if cur.Calls <= 0 {
+			delete(s.byKey, k)
+		}
+	}
+}
+
+func (s *memoryUsage) List(ctx context.Context, caller, from, to string) ([]*model.Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*model.Usage
+	for k, u := range s.byKey {
+		if k.date < from || k.date > to || (caller != "" && k.caller != caller) {
+			continue
+		}
+		cp := *u
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		return a.Method < b.Method
+	})
+	return out, nil
+}
+
+type memoryRefreshTokens struct {
+	mu     sync.Mutex
+	byHash map[string]*model.RefreshToken
+}
+
+func (s *memoryRefreshTokens) Create(ctx context.Context, t *model.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byHash[t.Hash]; ok {
+		return ErrDuplicate
+	}
+	cp := *t
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now()
+	}
+	s.byHash[t.Hash] = &cp
+	// 顺便清理过期的token, 内存存储没有后台任务
+	for h, cur := range s.byHash {
+		if cur.ExpiresAt.Before(cp.CreatedAt) {
+			delete(s.byHash, h)
+		}
+	}
+	return nil
+}
+
+func (s *memoryRefreshTokens) Use(ctx context.Context, hash string, at time.Time) (*model.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *t
+	if t.UsedAt.IsZero() {
+		t.UsedAt = at
+	}
+	return &cp, nil
+}
+
+func (s *memoryRefreshTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	s.revokeFamily(familyID, at)
+	return nil
+}
+
+func (s *memoryRefreshTokens) RevokeUser(ctx context.Context, userID int64, at time.Time) error {
+	s.revokeUser(userID, at)
+	return nil
+}
+
+// revokeFamily 作废同一族中所有未作废的token, 返回它们的哈希
+func (s *memoryRefreshTokens) revokeFamily(familyID string, at time.Time) []string {
+	return s.revoke(func(t *model.RefreshToken) bool { return t.FamilyID == familyID }, at)
+}
+
+// revokeUser 作废用户所有未作废的token, 返回它们的哈希
+func (s *memoryRefreshTokens) revokeUser(userID int64, at time.Time) []string {
+	return s.revoke(func(t *model.RefreshToken) bool { return t.UserID == userID }, at)
+}
+
+func (s *memoryRefreshTokens) revoke(match func(t *model.RefreshToken) bool, at time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hashes []string
+	for h, t := range s.byHash {
+		if match(t) && t.RevokedAt.IsZero() {
+			t.RevokedAt = at
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes
+}
+
+// remove 删除token, 用于回滚
+func (s *memoryRefreshTokens) remove(hash string) {
+	s.mu.Lock()
+	delete(s.byHash, hash)
+	s.mu.Unlock()
+}
+
+// reset 把token恢复为未使用或未作废, 用于回滚
+func (s *memoryRefreshTokens) reset(hash string, used, revoked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.byHash[hash]
+	if !ok {
+		return
+	}
+	if used {
+		t.UsedAt = time.Time{}
+	}
+	if revoked {
+		t.RevokedAt = time.Time{}
+	}
+}
+
+type memoryBlogs struct {
+	mu     sync.RWMutex
+	nextID int64
+	// list 按ID升序排列
+	list []*model.Blog
+}
+
+func (s *memoryBlogs) Create(ctx context.Context, b *model.Blog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	b.ID = s.nextID
+	now := time.Now()
+	b.CreatedAt, b.UpdatedAt = now, now
+	cp := *b
+	s.list = append(s.list, &cp)
+	return nil
+}
+
+func (s *memoryBlogs) Get(ctx context.Context, id int64) (*model.Blog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.list), func(i int) bool { return s.list[i].ID >= id })
+	if i == len(s.list) || s.list[i].ID != id {
+		return nil, ErrNotFound
+	}
+	cp := *s.list[i]
+	return &cp, nil
+}
+
+func (s *memoryBlogs) List(ctx context.Context, q BlogQuery) ([]*model.Blog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := len(s.list)
+	if q.BeforeID > 0 {
+		end = sort.Search(end, func(i int) bool { return s.list[i].ID >= q.BeforeID })
+	}
+	out := make([]*model.Blog, 0, q.Limit)
+	for i := end - 1; i >= 0 && len(out) < q.Limit; i-- {
+		if q.AuthorID != 0 && s.list[i].AuthorID != q.AuthorID {
+			continue
+		}
+		cp := *s.list[i]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+// remove 删除ID为id的博客, 用于回滚
+func (s *memoryBlogs) remove(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.list), func(i int) bool { return s.list[i].ID >= id })
+	if i < len(s.list) && s.list[i].ID == id {
+		s.list = append(s.list[:i], s.list[i+1:]...)
+	}
+}
+
+type memoryAudit struct {
+	mu     sync.RWMutex
+	nextID int64
+	// list 按ID升序排列
+	list []*model.AuditEvent
+}
+
+func (s *memoryAudit) Append(ctx context.Context, e *model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	e.ID = s.nextID
+	cp := *e
+	s.list = append(s.list, &cp)
+	return nil
+}
+
+func (s *memoryAudit) List(ctx context.Context, q AuditQuery) ([]*model.AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := len(s.list)
+	if q.BeforeID > 0 {
+		end = sort.Search(end, func(i int) bool { return s.list[i].ID >= q.BeforeID })
+	}
+	out := make([]*model.AuditEvent, 0, q.Limit)
+	for i := end - 1; i >= 0 && len(out) < q.Limit; i-- {
+		e := s.list[i]
+		if q.Actor != "" && e.Actor != q.Actor || q.Method != "" && e.Method != q.Method {
+			continue
+		}
+		cp := *e
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+// remove 删除ID为id的记录, 用于回滚
+func (s *memoryAudit) remove(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.list), func(i int) bool { return s.list[i].ID >= id })
+	if i < len(s.list) && s.list[i].ID == id {
+		s.list = append(s.list[:i], s.list[i+1:]...)
+	}
+}
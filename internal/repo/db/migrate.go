@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"path"
+
+	"github.com/Q1mi/greeter/internal/repo/migrate"
+)
+
+// migrationFiles 各数据库的迁移文件, 每种数据库一个目录, 修改表结构时在两个目录中各增加一个版本
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+func newMigrator(sdb *sql.DB, d *dialect) (*migrate.Migrator, error) {
+	ms, err := migrate.Load(migrationFiles, path.Join("migrations", d.migrations))
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(sdb, d.migrate, ms), nil
+}
+
+// OpenMigrator 打开dsn指向的数据库并返回它的Migrator, 用完后需调用Close.
+// 内存实现不需要迁移, 返回migrate.ErrNoMigrations
+func OpenMigrator(dsn string) (*migrate.Migrator, error) {
+	scheme, rest := splitDSN(dsn)
+	var (
+		c   driver.Connector
+		d   *dialect
+		err error
+	)
+	switch scheme {
+	case "memory":
+		return nil, migrate.ErrNoMigrations
+	case "mysql":
+		c, err = mysqlConnector(rest)
+		d = mysqlDialect
+	case "postgres", "postgresql":
+		c, err = postgresConnector(dsn)
+		d = postgresDialect
+	default:
+		return nil, fmt.Errorf("db: unsupported dsn scheme %q", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sdb := sql.OpenDB(c)
+	m, err := newMigrator(sdb, d)
+	if err != nil {
+		sdb.Close()
+		return nil, err
+	}
+	return m, nil
+}
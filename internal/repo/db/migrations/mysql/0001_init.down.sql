@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS usage_records;
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS casbin_rule;
+DROP TABLE IF EXISTS greetings;
+DROP TABLE IF EXISTS greeting_templates;
+DROP TABLE IF EXISTS credentials;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,90 @@
+-- 初始表结构. 字符串使用utf8mb4_bin, 唯一性和排序与内存实现一样按字节比较
+
+CREATE TABLE IF NOT EXISTS users (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	username VARCHAR(64) NOT NULL,
+	email VARCHAR(255) NULL,
+	phone VARCHAR(16) NOT NULL DEFAULT '',
+	status TINYINT NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	updated_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_username (username),
+	UNIQUE KEY uk_email (email),
+	KEY idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS credentials (
+	user_id BIGINT NOT NULL AUTO_INCREMENT,
+	username VARCHAR(64) NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	updated_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (user_id),
+	UNIQUE KEY uk_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS greeting_templates (
+	id VARCHAR(64) NOT NULL,
+	text TEXT NOT NULL,
+	updated_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS greetings (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	message TEXT NOT NULL,
+	locale VARCHAR(35) NOT NULL,
+	template_id VARCHAR(64) NOT NULL,
+	caller VARCHAR(191) NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS casbin_rule (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	ptype VARCHAR(16) NOT NULL,
+	v0 VARCHAR(191) NOT NULL,
+	v1 VARCHAR(191) NOT NULL,
+	v2 VARCHAR(191) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_rule (ptype, v0, v1, v2)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS reports (
+	date CHAR(10) NOT NULL,
+	greetings BIGINT NOT NULL,
+	unique_callers BIGINT NOT NULL,
+	by_locale TEXT NOT NULL,
+	by_template TEXT NOT NULL,
+	new_users BIGINT NOT NULL,
+	generated_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS usage_records (
+	date CHAR(10) NOT NULL,
+	caller VARCHAR(191) NOT NULL,
+	method VARCHAR(191) NOT NULL,
+	calls BIGINT NOT NULL,
+	errors BIGINT NOT NULL,
+	request_bytes BIGINT NOT NULL,
+	response_bytes BIGINT NOT NULL,
+	compute_units BIGINT NOT NULL,
+	PRIMARY KEY (date, caller, method)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	hash CHAR(64) NOT NULL,
+	user_id BIGINT NOT NULL,
+	username VARCHAR(64) NOT NULL,
+	tenant_id VARCHAR(64) NOT NULL,
+	family_id VARCHAR(64) NOT NULL,
+	expires_at DATETIME(6) NOT NULL,
+	used_at DATETIME(6) NULL,
+	revoked_at DATETIME(6) NULL,
+	created_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (hash),
+	KEY idx_family (family_id),
+	KEY idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS blogs (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	author_id BIGINT NOT NULL,
+	title VARCHAR(200) NOT NULL,
+	content MEDIUMTEXT NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	updated_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (id),
+	KEY idx_author (author_id, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
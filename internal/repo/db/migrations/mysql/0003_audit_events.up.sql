@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	ts DATETIME(6) NOT NULL,
+	method VARCHAR(255) NOT NULL,
+	actor VARCHAR(128) NOT NULL,
+	user_id BIGINT NOT NULL,
+	username VARCHAR(64) NOT NULL,
+	app_id VARCHAR(128) NOT NULL,
+	resource VARCHAR(255) NOT NULL,
+	outcome VARCHAR(16) NOT NULL,
+	code VARCHAR(32) NOT NULL,
+	request_id VARCHAR(64) NOT NULL,
+	trace_id VARCHAR(64) NOT NULL,
+	client_ip VARCHAR(64) NOT NULL,
+	PRIMARY KEY (id),
+	KEY idx_actor (actor, id),
+	KEY idx_method (method, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+	DROP COLUMN scopes,
+	DROP COLUMN roles;
@@ -0,0 +1 @@
+DROP INDEX idx_user ON refresh_tokens;
@@ -0,0 +1 @@
+CREATE INDEX idx_user ON refresh_tokens (user_id);
@@ -0,0 +1,84 @@
+-- 初始表结构. 字符串使用C排序规则, 唯一性和排序与内存实现一样按字节比较
+
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	username VARCHAR(64) COLLATE "C" NOT NULL UNIQUE,
+	email VARCHAR(255) COLLATE "C" NULL UNIQUE,
+	phone VARCHAR(16) NOT NULL DEFAULT '',
+	status SMALLINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
+
+-- user_id通常由调用方指定为users.id, 不推进序列, 因此不应混用自动分配
+CREATE TABLE IF NOT EXISTS credentials (
+	user_id BIGSERIAL PRIMARY KEY,
+	username VARCHAR(64) COLLATE "C" NOT NULL UNIQUE,
+	password_hash VARCHAR(255) NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS greeting_templates (
+	id VARCHAR(64) COLLATE "C" PRIMARY KEY,
+	text TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS greetings (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	message TEXT NOT NULL,
+	locale VARCHAR(35) NOT NULL,
+	template_id VARCHAR(64) NOT NULL,
+	caller VARCHAR(191) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS casbin_rule (
+	id BIGSERIAL PRIMARY KEY,
+	ptype VARCHAR(16) NOT NULL,
+	v0 VARCHAR(191) NOT NULL,
+	v1 VARCHAR(191) NOT NULL,
+	v2 VARCHAR(191) NOT NULL,
+	UNIQUE (ptype, v0, v1, v2)
+);
+
+CREATE TABLE IF NOT EXISTS reports (
+	date CHAR(10) PRIMARY KEY,
+	greetings BIGINT NOT NULL,
+	unique_callers BIGINT NOT NULL,
+	by_locale TEXT NOT NULL,
+	by_template TEXT NOT NULL,
+	new_users BIGINT NOT NULL,
+	generated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_records (
+	date CHAR(10) NOT NULL,
+	caller VARCHAR(191) COLLATE "C" NOT NULL,
+	method VARCHAR(191) COLLATE "C" NOT NULL,
+	calls BIGINT NOT NULL,
+	errors BIGINT NOT NULL,
+	request_bytes BIGINT NOT NULL,
+	response_bytes BIGINT NOT NULL,
+	compute_units BIGINT NOT NULL,
+	PRIMARY KEY (date, caller, method)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	hash CHAR(64) PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	username VARCHAR(64) NOT NULL,
+	tenant_id VARCHAR(64) NOT NULL,
+	family_id VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ NULL,
+	revoked_at TIMESTAMPTZ NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS blogs (
+	id BIGSERIAL PRIMARY KEY,
+	author_id BIGINT NOT NULL,
+	title VARCHAR(200) NOT NULL,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS blogs_author_idx ON blogs (author_id, id);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+	ADD COLUMN roles VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN scopes VARCHAR(1024) NOT NULL DEFAULT '';
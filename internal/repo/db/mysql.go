@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/repo/migrate"
+	"github.com/Q1mi/greeter/pkg/mysql"
+)
+
+var mysqlDialect = &dialect{
+	migrations: "mysql",
+	migrate: migrate.Dialect{
+		Table: `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	applied_at DATETIME(6) NOT NULL,
+	PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`,
+		Lock:   "SELECT GET_LOCK('greeter_migrate', 300)",
+		Unlock: "SELECT RELEASE_LOCK('greeter_migrate')",
+	},
+	pruneTokens: "DELETE FROM refresh_tokens WHERE expires_at < ? LIMIT ?",
+	isDuplicate: func(err error) bool {
+		var me *mysql.Error
+		return errors.As(err, &me) && me.Number == mysql.ErDupEntry
+	},
+}
+
+// NewMySQL 按go-sql-driver/mysql格式的dsn连接MySQL, 如 user:pass@tcp(127.0.0.1:3306)/greeter.
+// 时间以UTC读写, DSN中的parseTime不影响结果; p.Migrate为true时执行未执行的迁移
+func NewMySQL(dsn string, p Pool) (Registry, error) {
+	c, err := mysqlConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return openSQL(c, mysqlDialect, p)
+}
+
+func mysqlConnector(dsn string) (driver.Connector, error) {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c.ParseTime = true
+	return mysql.NewConnector(c), nil
+}
@@ -0,0 +1,21 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMySQLRegistry 需要一个可以执行迁移的MySQL, DSN为go-sql-driver/mysql格式, 如
+//
+//	GREETER_TEST_MYSQL_DSN='root:root@tcp(127.0.0.1:3306)/greeter_test' go test ./internal/repo/db
+func TestMySQLRegistry(t *testing.T) {
+	dsn := os.Getenv("GREETER_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("GREETER_TEST_MYSQL_DSN is not set")
+	}
+	r, err := NewMySQL(dsn, Pool{MaxOpenConns: 4, Migrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRegistry(t, r)
+}
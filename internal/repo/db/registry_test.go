@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+)
+
+// testRegistry 在r上检查各实现都应满足的行为. 用户名带有随机后缀, 可以在已有数据的库上重复执行
+func testRegistry(t *testing.T, r Registry) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("t%d_", time.Now().UnixNano())
+
+	users := r.Users()
+	u := &model.User{Username: prefix + "alice", Email: prefix + "alice@example.com", Status: model.UserActive,
+		Roles: []string{"admin"}, Scopes: []string{"greeter:write", "users:read"}}
+	if err := users.Create(ctx, u); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	defer users.Delete(ctx, u.ID)
+	if u.ID == 0 || u.CreatedAt.IsZero() {
+		t.Fatalf("created user %+v, want ID and CreatedAt filled in", u)
+	}
+	got, err := users.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if got.Username != u.Username || got.Email != u.Email || got.Status != u.Status ||
+		!reflect.DeepEqual(got.Roles, u.Roles) || !reflect.DeepEqual(got.Scopes, u.Scopes) || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Errorf("get user = %+v, want %+v", got, u)
+	}
+	if err := users.Create(ctx, &model.User{Username: u.Username, Status: model.UserActive}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("duplicate username: %v, want ErrDuplicate", err)
+	}
+
+	u.Phone, u.Roles = "+8613800000000", nil
+	if err := users.Update(ctx, u); err != nil {
+		t.Fatalf("update user: %v", err)
+	}
+	if got, _ = users.Get(ctx, u.ID); got.Phone != u.Phone || len(got.Roles) != 0 {
+		t.Errorf("updated user = %+v, want phone %s and no roles", got, u.Phone)
+	}
+	if err := users.Update(ctx, &model.User{ID: -1, Username: prefix + "nobody"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update missing user: %v, want ErrNotFound", err)
+	}
+
+	bob := &model.User{Username: prefix + "bob", Status: model.UserPending}
+	if err := users.Create(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	defer users.Delete(ctx, bob.ID)
+	list, err := users.List(ctx, UserQuery{UsernamePrefix: prefix, Order: OrderByUsername, Desc: true})
+	if err != nil {
+		t.Fatalf("list users: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != bob.ID || list[1].ID != u.ID {
+		t.Errorf("list users by username desc = %v, want bob then alice", list)
+	}
+	// LIKE的通配符按字面匹配
+	if list, _ = users.List(ctx, UserQuery{UsernamePrefix: prefix[:len(prefix)-1] + "%"}); len(list) != 0 {
+		t.Errorf("prefix with %% matched %d users", len(list))
+	}
+	if list, _ = users.List(ctx, UserQuery{UsernamePrefix: prefix, Status: model.UserPending, Limit: 1}); len(list) != 1 || list[0].ID != bob.ID {
+		t.Errorf("list pending users = %v, want bob", list)
+	}
+
+	// RevokeUser只作废该用户的refresh token
+	tokens := r.RefreshTokens()
+	now := time.Now().Truncate(time.Second)
+	for _, tok := range []*model.RefreshToken{
+		{Hash: prefix + "alice1", UserID: u.ID, Username: u.Username, FamilyID: prefix + "f1"},
+		{Hash: prefix + "alice2", UserID: u.ID, Username: u.Username, FamilyID: prefix + "f2"},
+		{Hash: prefix + "bob1", UserID: bob.ID, Username: bob.Username, FamilyID: prefix + "f3"},
+	} {
+		tok.ExpiresAt, tok.CreatedAt = now.Add(time.Hour), now
+		if err := tokens.Create(ctx, tok); err != nil {
+			t.Fatalf("create refresh token: %v", err)
+		}
+	}
+	if err := tokens.RevokeUser(ctx, u.ID, now); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+	for hash, revoked := range map[string]bool{prefix + "alice1": true, prefix + "alice2": true, prefix + "bob1": false} {
+		tok, err := tokens.Use(ctx, hash, now)
+		if err != nil {
+			t.Fatalf("use %s: %v", hash, err)
+		}
+		if !tok.RevokedAt.IsZero() != revoked {
+			t.Errorf("%s revoked at %v, want revoked %v", hash, tok.RevokedAt, revoked)
+		}
+	}
+
+	// 事务中的写入在f返回错误时回滚
+	errRollback := errors.New("rollback")
+	carol := &model.User{Username: prefix + "carol", Status: model.UserActive}
+	err = r.Transaction(ctx, func(ctx context.Context, tx Registry) error {
+		if err := tx.Users().Create(ctx, carol); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("transaction: %v, want the error returned by f", err)
+	}
+	if _, err := users.Get(ctx, carol.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("user created in a rolled back transaction: %v, want ErrNotFound", err)
+	}
+
+	if err := users.Delete(ctx, bob.ID); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if err := users.Delete(ctx, bob.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete twice: %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	testRegistry(t, NewMemory())
+}
@@ -0,0 +1,840 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/migrate"
+)
+
+// Pool 数据库连接池配置, 内存实现忽略
+type Pool struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime 连接最长使用时间, 应小于服务端的空闲超时
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime 连接最长空闲时间, 服务端已关闭的空闲连接在下次使用时才会发现
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout 单条语句的超时时间, 调用方ctx的截止时间更早时以ctx为准; 为0时不限制
+	QueryTimeout time.Duration
+	// Migrate 打开时执行未执行的迁移
+	Migrate bool
+}
+
+// dialect 不同数据库的SQL差异. 语句统一使用?占位符, 执行前按numbered改写
+type dialect struct {
+	// migrations 迁移文件在migrations下的目录
+	migrations string
+	migrate    migrate.Dialect
+	// numbered 占位符为$1、$2..., 否则为?
+	numbered bool
+	// returning 通过INSERT ... RETURNING取得自增ID, 否则使用LastInsertId
+	returning bool
+	// upsert 为true时使用ON CONFLICT ... DO UPDATE, 否则使用ON DUPLICATE KEY UPDATE
+	upsert bool
+	// pruneTokens 删除最多第二个参数个在第一个参数之前过期的refresh token
+	pruneTokens string
+	// isDuplicate err是否为唯一键冲突
+	isDuplicate func(err error) bool
+}
+
+// rebind 把?改写为方言的占位符
+func (d *dialect) rebind(q string) string {
+	if !d.numbered || !strings.Contains(q, "?") {
+		return q
+	}
+	b := make([]byte, 0, len(q)+8)
+	n := 0
+	for i := 0; i < len(q); i++ {
+		if q[i] != '?' {
+			b = append(b, q[i])
+			continue
+		}
+		n++
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(n), 10)
+	}
+	return string(b)
+}
+
+// onConflict 唯一键keys冲突时执行set的子句, set中用excluded引用待插入的值
+func (d *dialect) onConflict(keys string, set ...string) string {
+	if d.upsert {
+		return " ON CONFLICT (" + keys + ") DO UPDATE SET " + strings.Join(set, ", ")
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+// excluded 冲突时引用待插入行中col的值
+func (d *dialect) excluded(col string) string {
+	if d.upsert {
+		return "EXCLUDED." + col
+	}
+	return "VALUES(" + col + ")"
+}
+
+// openSQL 按连接池配置打开数据库, p.Migrate为true时执行未执行的迁移
+func openSQL(c driver.Connector, d *dialect, p Pool) (Registry, error) {
+	sdb := sql.OpenDB(c)
+	sdb.SetMaxOpenConns(p.MaxOpenConns)
+	sdb.SetMaxIdleConns(p.MaxIdleConns)
+	sdb.SetConnMaxLifetime(p.ConnMaxLifetime)
+	sdb.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	if p.Migrate {
+		m, err := newMigrator(sdb, d)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			_, err = m.Up(ctx)
+			cancel()
+		}
+		if err != nil {
+			sdb.Close()
+			return nil, err
+		}
+	}
+	return &sqlRegistry{db: sqlDB{q: sdb, d: d, timeout: p.QueryTimeout}, pool: sdb}, nil
+}
+
+// sqlRegistry 基于database/sql的Registry实现, 方言由dialect决定
+type sqlRegistry struct {
+	db sqlDB
+	// pool 事务中为nil
+	pool *sql.DB
+}
+
+func (m *sqlRegistry) Users() UserStore { return sqlUsers{m.db} }
+
+func (m *sqlRegistry) Credentials() CredentialStore { return sqlCredentials{m.db} }
+
+func (m *sqlRegistry) GreetingTemplates() GreetingTemplateStore { return sqlTemplates{m.db} }
+
+func (m *sqlRegistry) Greetings() GreetingStore { return sqlGreetings{m.db} }
+
+func (m *sqlRegistry) Policies() PolicyStore { return sqlPolicies{m.db} }
+
+func (m *sqlRegistry) Reports() ReportStore { return sqlReports{m.db} }
+
+func (m *sqlRegistry) Usage() UsageStore { return sqlUsage{m.db} }
+
+func (m *sqlRegistry) RefreshTokens() RefreshTokenStore { return sqlRefreshTokens{m.db} }
+
+func (m *sqlRegistry) Blogs() BlogStore { return sqlBlogs{m.db} }
+
+func (m *sqlRegistry) AuditEvents() AuditStore { return sqlAudit{m.db} }
+
+func (m *sqlRegistry) Transaction(ctx context.Context, f func(ctx context.Context, tx Registry) error) error {
+	return traceTx(ctx, func(ctx context.Context) error {
+		return m.db.inTx(ctx, func(tx sqlDB) error {
+			return f(ctx, &sqlRegistry{db: tx})
+		})
+	})
+}
+
+// querier *sql.DB和*sql.Tx共有的方法
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlDB 按方言改写语句后执行, 各个存储共用
+type sqlDB struct {
+	q querier
+	d *dialect
+	// timeout 单条语句的超时时间, 为0时不限制
+	timeout time.Duration
+}
+
+// withTimeout 为一条语句设置超时
+func (s sqlDB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+func (s sqlDB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.q.ExecContext(ctx, s.d.rebind(query), args...)
+}
+
+// query 执行查询, 超时覆盖到rows关闭为止
+func (s sqlDB) query(ctx context.Context, query string, args ...interface{}) (*sqlRows, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	rows, err := s.q.QueryContext(ctx, s.d.rebind(query), args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &sqlRows{Rows: rows, cancel: cancel}, nil
+}
+
+// queryRow 执行查询, 超时覆盖到Scan为止
+func (s sqlDB) queryRow(ctx context.Context, query string, args ...interface{}) sqlRow {
+	ctx, cancel := s.withTimeout(ctx)
+	return sqlRow{Row: s.q.QueryRowContext(ctx, s.d.rebind(query), args...), cancel: cancel}
+}
+
+// sqlRows 关闭时取消语句的超时
+type sqlRows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *sqlRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// sqlRow Scan后取消语句的超时
+type sqlRow struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r sqlRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// insert 执行INSERT并返回自增列id的值
+func (s sqlDB) insert(ctx context.Context, query, id string, args ...interface{}) (int64, error) {
+	if s.d.returning {
+		var n int64
+		err := s.queryRow(ctx, query+" RETURNING "+id, args...).Scan(&n)
+		return n, s.err(err)
+	}
+	res, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return 0, s.err(err)
+	}
+	return res.LastInsertId()
+}
+
+// err 把唯一键冲突和查询无结果转换为ErrDuplicate和ErrNotFound
+func (s sqlDB) err(err error) error {
+	if err != nil && s.d.isDuplicate(err) {
+		return ErrDuplicate
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// affected 检查写操作的影响行数, 为0时返回ErrNotFound
+func (s sqlDB) affected(res sql.Result, err error) error {
+	if err != nil {
+		return s.err(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// inTx 在事务中执行f, f返回错误或panic时回滚; 已在事务中时直接执行
+func (s sqlDB) inTx(ctx context.Context, f func(tx sqlDB) error) (err error) {
+	db, ok := s.q.(*sql.DB)
+	if !ok {
+		return f(s)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	s.q = tx
+	if err := f(s); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// now 当前时间, 截断到微秒(两种数据库时间类型的精度), 使返回给调用方的值与之后读出的一致
+func now() time.Time { return time.Now().UTC().Truncate(time.Microsecond) }
+
+// nullString 空字符串保存为NULL, 使多个没有邮箱的用户不违反唯一键
+func nullString(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+
+// nullTime 零值保存为NULL
+func nullTime(t time.Time) sql.NullTime { return sql.NullTime{Time: t, Valid: !t.IsZero()} }
+
+type sqlUsers struct {
+	sqlDB
+}
+
+// userColumns roles和scopes以空格分隔保存
+const userColumns = "id, username, email, phone, status, roles, scopes, created_at, updated_at"
+
+func (s sqlUsers) scan(row interface{ Scan(...interface{}) error }) (*model.User, error) {
+	var u model.User
+	var email sql.NullString
+	var roles, scopes string
+	if err := row.Scan(&u.ID, &u.Username, &email, &u.Phone, &u.Status, &roles, &scopes, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, s.err(err)
+	}
+	u.Email = email.String
+	u.Roles, u.Scopes = strings.Fields(roles), strings.Fields(scopes)
+	return &u, nil
+}
+
+func (s sqlUsers) Get(ctx context.Context, id int64) (*model.User, error) {
+	return s.scan(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s sqlUsers) Create(ctx context.Context, u *model.User) error {
+	t := now()
+	id, err := s.insert(ctx,
+		"INSERT INTO users (username, email, phone, status, roles, scopes, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", "id",
+		u.Username, nullString(u.Email), u.Phone, u.Status, strings.Join(u.Roles, " "), strings.Join(u.Scopes, " "), t, t)
+	if err != nil {
+		return err
+	}
+	u.ID, u.CreatedAt, u.UpdatedAt = id, t, t
+	return nil
+}
+
+func (s sqlUsers) Update(ctx context.Context, u *model.User) error {
+	t := now()
+	// MySQL在值未变化时影响行数为0, 因此通过随后读取created_at判断用户是否存在
+	if _, err := s.exec(ctx,
+		"UPDATE users SET username = ?, email = ?, phone = ?, status = ?, roles = ?, scopes = ?, updated_at = ? WHERE id = ?",
+		u.Username, nullString(u.Email), u.Phone, u.Status, strings.Join(u.Roles, " "), strings.Join(u.Scopes, " "), t, u.ID); err != nil {
+		return s.err(err)
+	}
+	var created time.Time
+	if err := s.queryRow(ctx, "SELECT created_at FROM users WHERE id = ?", u.ID).Scan(&created); err != nil {
+		return s.err(err)
+	}
+	u.CreatedAt, u.UpdatedAt = created, t
+	return nil
+}
+
+func (s sqlUsers) Delete(ctx context.Context, id int64) error {
+	return s.affected(s.exec(ctx, "DELETE FROM users WHERE id = ?", id))
+}
+
+func (s sqlUsers) CountCreated(ctx context.Context, from, to time.Time) (int64, error) {
+	var n int64
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM users WHERE created_at >= ? AND created_at < ?", from, to).Scan(&n)
+	return n, err
+}
+
+// userOrderColumns UserOrder对应的列
+var userOrderColumns = map[UserOrder]string{
+	OrderByID:        "id",
+	OrderByUsername:  "username",
+	OrderByCreatedAt: "created_at",
+}
+
+func (s sqlUsers) List(ctx context.Context, q UserQuery) ([]*model.User, error) {
+	var where []string
+	var args []interface{}
+	if q.Status != 0 {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.UsernamePrefix != "" {
+		where = append(where, "username LIKE ? ESCAPE '!'")
+		args = append(args, likePrefix(q.UsernamePrefix))
+	}
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.CreatedFrom)
+	}
+	if !q.CreatedTo.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.CreatedTo)
+	}
+	col := userOrderColumns[q.Order]
+	op, dir := ">", ""
+	if q.Desc {
+		op, dir = "<", " DESC"
+	}
+	if a := q.After; a != nil {
+		switch q.Order {
+		case OrderByUsername:
+			where = append(where, "(username "+op+" ? OR (username = ? AND id "+op+" ?))")
+			args = append(args, a.Username, a.Username, a.ID)
+		case OrderByCreatedAt:
+			where = append(where, "(created_at "+op+" ? OR (created_at = ? AND id "+op+" ?))")
+			args = append(args, a.CreatedAt, a.CreatedAt, a.ID)
+		default:
+			where = append(where, "id "+op+" ?")
+			args = append(args, a.ID)
+		}
+	}
+	query := "SELECT " + userColumns + " FROM users"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + col + dir
+	if col != "id" {
+		query += ", id" + dir
+	}
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*model.User
+	for rows.Next() {
+		u, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// likePrefix 转义LIKE的通配符并匹配前缀
+func likePrefix(s string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(s) + "%"
+}
+
+type sqlCredentials struct {
+	sqlDB
+}
+
+func (s sqlCredentials) GetByUsername(ctx context.Context, username string) (*model.Credential, error) {
+	var c model.Credential
+	if err := s.queryRow(ctx,
+		"SELECT user_id, username, password_hash, updated_at FROM credentials WHERE username = ?", username).
+		Scan(&c.UserID, &c.Username, &c.PasswordHash, &c.UpdatedAt); err != nil {
+		return nil, s.err(err)
+	}
+	return &c, nil
+}
+
+func (s sqlCredentials) Create(ctx context.Context, c *model.Credential) error {
+	if c.UpdatedAt.IsZero() {
+		c.UpdatedAt = now()
+	}
+	if c.UserID != 0 {
+		_, err := s.exec(ctx,
+			"INSERT INTO credentials (user_id, username, password_hash, updated_at) VALUES (?, ?, ?, ?)",
+			c.UserID, c.Username, c.PasswordHash, c.UpdatedAt)
+		return s.err(err)
+	}
+	id, err := s.insert(ctx,
+		"INSERT INTO credentials (username, password_hash, updated_at) VALUES (?, ?, ?)", "user_id",
+		c.Username, c.PasswordHash, c.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	c.UserID = id
+	return nil
+}
+
+func (s sqlCredentials) UpdatePasswordHash(ctx context.Context, userID int64, hash string) error {
+	return s.affected(s.exec(ctx,
+		"UPDATE credentials SET password_hash = ?, updated_at = ? WHERE user_id = ?", hash, now(), userID))
+}
+
+func (s sqlCredentials) Delete(ctx context.Context, userID int64) error {
+	return s.affected(s.exec(ctx, "DELETE FROM credentials WHERE user_id = ?", userID))
+}
+
+type sqlTemplates struct {
+	sqlDB
+}
+
+func (s sqlTemplates) Get(ctx context.Context, id string) (*model.GreetingTemplate, error) {
+	var t model.GreetingTemplate
+	if err := s.queryRow(ctx, "SELECT id, text, updated_at FROM greeting_templates WHERE id = ?", id).
+		Scan(&t.ID, &t.Text, &t.UpdatedAt); err != nil {
+		return nil, s.err(err)
+	}
+	return &t, nil
+}
+
+func (s sqlTemplates) Save(ctx context.Context, t *model.GreetingTemplate) error {
+	t.UpdatedAt = now()
+	_, err := s.exec(ctx, "INSERT INTO greeting_templates (id, text, updated_at) VALUES (?, ?, ?)"+
+		s.d.onConflict("id", "text = "+s.d.excluded("text"), "updated_at = "+s.d.excluded("updated_at")),
+		t.ID, t.Text, t.UpdatedAt)
+	return err
+}
+
+type sqlGreetings struct {
+	sqlDB
+}
+
+func (s sqlGreetings) Create(ctx context.Context, g *model.Greeting) error {
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = now()
+	}
+	id, err := s.insert(ctx,
+		"INSERT INTO greetings (name, message, locale, template_id, caller, created_at) VALUES (?, ?, ?, ?, ?, ?)", "id",
+		g.Name, g.Message, g.Locale, g.TemplateID, g.Caller, g.CreatedAt)
+	if err != nil {
+		return err
+	}
+	g.ID = id
+	return nil
+}
+
+func (s sqlGreetings) List(ctx context.Context, beforeID int64, limit int) ([]*model.Greeting, error) {
+	query := "SELECT id, name, message, locale, template_id, caller, created_at FROM greetings"
+	var args []interface{}
+	if beforeID > 0 {
+		query += " WHERE id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]*model.Greeting, 0, limit)
+	for rows.Next() {
+		var g model.Greeting
+		if err := rows.Scan(&g.ID, &g.Name, &g.Message, &g.Locale, &g.TemplateID, &g.Caller, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, &g)
+	}
+	return out, rows.Err()
+}
+
+type sqlPolicies struct {
+	sqlDB
+}
+
+func (s sqlPolicies) List(ctx context.Context) ([]*model.PolicyRule, error) {
+	rows, err := s.query(ctx, "SELECT ptype, v0, v1, v2 FROM casbin_rule ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*model.PolicyRule
+	for rows.Next() {
+		var r model.PolicyRule
+		if err := rows.Scan(&r.PType, &r.V0, &r.V1, &r.V2); err != nil {
+			return nil, err
+		}
+		out = append(out, &r)
+	}
+	return out, rows.Err()
+}
+
+func (s sqlPolicies) Add(ctx context.Context, r *model.PolicyRule) error {
+	_, err := s.exec(ctx, "INSERT INTO casbin_rule (ptype, v0, v1, v2) VALUES (?, ?, ?, ?)", r.PType, r.V0, r.V1, r.V2)
+	return s.err(err)
+}
+
+func (s sqlPolicies) Remove(ctx context.Context, r *model.PolicyRule) error {
+	return s.affected(s.exec(ctx,
+		"DELETE FROM casbin_rule WHERE ptype = ? AND v0 = ? AND v1 = ? AND v2 = ?", r.PType, r.V0, r.V1, r.V2))
+}
+
+type sqlReports struct {
+	sqlDB
+}
+
+func (s sqlReports) Get(ctx context.Context, date string) (*model.Report, error) {
+	var r model.Report
+	var byLocale, byTemplate string
+	if err := s.queryRow(ctx,
+		`SELECT date, greetings, unique_callers, by_locale, by_template, new_users, generated_at
+		FROM reports WHERE date = ?`, date).
+		Scan(&r.Date, &r.Greetings, &r.UniqueCallers, &byLocale, &byTemplate, &r.NewUsers, &r.GeneratedAt); err != nil {
+		return nil, s.err(err)
+	}
+	if err := json.Unmarshal([]byte(byLocale), &r.ByLocale); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(byTemplate), &r.ByTemplate); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s sqlReports) Save(ctx context.Context, r *model.Report) error {
+	byLocale, err := json.Marshal(r.ByLocale)
+	if err != nil {
+		return err
+	}
+	byTemplate, err := json.Marshal(r.ByTemplate)
+	if err != nil {
+		return err
+	}
+	var set []string
+	for _, c := range []string{"greetings", "unique_callers", "by_locale", "by_template", "new_users", "generated_at"} {
+		set = append(set, c+" = "+s.d.excluded(c))
+	}
+	_, err = s.exec(ctx,
+		`INSERT INTO reports (date, greetings, unique_callers, by_locale, by_template, new_users, generated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`+s.d.onConflict("date", set...),
+		r.Date, r.Greetings, r.UniqueCallers, string(byLocale), string(byTemplate), r.NewUsers, r.GeneratedAt)
+	return err
+}
+
+type sqlUsage struct {
+	sqlDB
+}
+
+// usageBatch 每条INSERT语句写入的最大记录数
+const usageBatch = 500
+
+// usageCounters 累加的用量列
+var usageCounters = []string{"calls", "errors", "request_bytes", "response_bytes", "compute_units"}
+
+// Add 在一个事务中写入, 失败时调用方重试不会重复累加
+func (s sqlUsage) Add(ctx context.Context, us []*model.Usage) error {
+	if len(us) == 0 {
+		return nil
+	}
+	var set []string
+	for _, c := range usageCounters {
+		set = append(set, c+" = usage_records."+c+" + "+s.d.excluded(c))
+	}
+	upsert := s.d.onConflict("date, caller, method", set...)
+	return s.inTx(ctx, func(tx sqlDB) error {
+		for len(us) > 0 {
+			n := len(us)
+			if n > usageBatch {
+				n = usageBatch
+			}
+			args := make([]interface{}, 0, 8*n)
+			for _, u := range us[:n] {
+				args = append(args, u.Date, u.Caller, u.Method, u.Calls, u.Errors, u.RequestBytes, u.ResponseBytes, u.ComputeUnits)
+			}
+			query := "INSERT INTO usage_records (date, caller, method, calls, errors, request_bytes, response_bytes, compute_units) VALUES " +
+				strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?), ", n), ", ") + upsert
+			if _, err := tx.exec(ctx, query, args...); err != nil {
+				return err
+			}
+			us = us[n:]
+		}
+		return nil
+	})
+}
+
+func (s sqlUsage) List(ctx context.Context, caller, from, to string) ([]*model.Usage, error) {
+	query := `SELECT date, caller, method, calls, errors, request_bytes, response_bytes, compute_units
+		FROM usage_records WHERE date >= ? AND date <= ?`
+	args := []interface{}{from, to}
+	if caller != "" {
+		query += " AND caller = ?"
+		args = append(args, caller)
+	}
+	query += " ORDER BY date, caller, method"
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*model.Usage
+	for rows.Next() {
+		var u model.Usage
+		if err := rows.Scan(&u.Date, &u.Caller, &u.Method, &u.Calls, &u.Errors, &u.RequestBytes, &u.ResponseBytes, &u.ComputeUnits); err != nil {
+			return nil, err
+		}
+		out = append(out, &u)
+	}
+	return out, rows.Err()
+}
+
+type sqlRefreshTokens struct {
+	sqlDB
+}
+
+// expiredBatch 每次创建token时最多清理的过期token数, 避免一次删除太多行
+const expiredBatch = 100
+
+func (s sqlRefreshTokens) Create(ctx context.Context, t *model.RefreshToken) error {
+	cp := *t
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = now()
+	}
+	if _, err := s.exec(ctx,
+		`INSERT INTO refresh_tokens (hash, user_id, username, tenant_id, family_id, expires_at, used_at, revoked_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cp.Hash, cp.UserID, cp.Username, cp.TenantID, cp.FamilyID, cp.ExpiresAt,
+		nullTime(cp.UsedAt), nullTime(cp.RevokedAt), cp.CreatedAt); err != nil {
+		return s.err(err)
+	}
+	// 与内存实现一样顺便清理过期的token, 清理失败不影响创建
+	s.exec(ctx, s.d.pruneTokens, cp.CreatedAt, expiredBatch)
+	return nil
+}
+
+func (s sqlRefreshTokens) Use(ctx context.Context, hash string, at time.Time) (*model.RefreshToken, error) {
+	var t model.RefreshToken
+	err := s.inTx(ctx, func(tx sqlDB) error {
+		var used, revoked sql.NullTime
+		if err := tx.queryRow(ctx,
+			`SELECT hash, user_id, username, tenant_id, family_id, expires_at, used_at, revoked_at, created_at
+			FROM refresh_tokens WHERE hash = ? FOR UPDATE`, hash).
+			Scan(&t.Hash, &t.UserID, &t.Username, &t.TenantID, &t.FamilyID, &t.ExpiresAt, &used, &revoked, &t.CreatedAt); err != nil {
+			return tx.err(err)
+		}
+		t.UsedAt, t.RevokedAt = used.Time, revoked.Time
+		if used.Valid {
+			return nil
+		}
+		_, err := tx.exec(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE hash = ?", at, hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s sqlRefreshTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	_, err := s.exec(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", at, familyID)
+	return err
+}
+
+func (s sqlRefreshTokens) RevokeUser(ctx context.Context, userID int64, at time.Time) error {
+	_, err := s.exec(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", at, userID)
+	return err
+}
+
+type sqlBlogs struct {
+	sqlDB
+}
+
+const blogColumns = "id, author_id, title, content, created_at, updated_at"
+
+func (s sqlBlogs) Create(ctx context.Context, b *model.Blog) error {
+	t := now()
+	id, err := s.insert(ctx,
+		"INSERT INTO blogs (author_id, title, content, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", "id",
+		b.AuthorID, b.Title, b.Content, t, t)
+	if err != nil {
+		return err
+	}
+	b.ID, b.CreatedAt, b.UpdatedAt = id, t, t
+	return nil
+}
+
+func (s sqlBlogs) Get(ctx context.Context, id int64) (*model.Blog, error) {
+	var b model.Blog
+	if err := s.queryRow(ctx, "SELECT "+blogColumns+" FROM blogs WHERE id = ?", id).
+		Scan(&b.ID, &b.AuthorID, &b.Title, &b.Content, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, s.err(err)
+	}
+	return &b, nil
+}
+
+func (s sqlBlogs) List(ctx context.Context, q BlogQuery) ([]*model.Blog, error) {
+	query := "SELECT " + blogColumns + " FROM blogs"
+	var conds []string
+	var args []interface{}
+	if q.AuthorID != 0 {
+		conds = append(conds, "author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.BeforeID > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, q.BeforeID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, q.Limit)
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]*model.Blog, 0, q.Limit)
+	for rows.Next() {
+		var b model.Blog
+		if err := rows.Scan(&b.ID, &b.AuthorID, &b.Title, &b.Content, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, &b)
+	}
+	return out, rows.Err()
+}
+
+type sqlAudit struct {
+	sqlDB
+}
+
+const auditColumns = "id, ts, method, actor, user_id, username, app_id, resource, outcome, code, request_id, trace_id, client_ip"
+
+func (s sqlAudit) Append(ctx context.Context, e *model.AuditEvent) error {
+	id, err := s.insert(ctx,
+		"INSERT INTO audit_events (ts, method, actor, user_id, username, app_id, resource, outcome, code, request_id, trace_id, client_ip)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "id",
+		e.Time.UTC().Truncate(time.Microsecond), e.Method, e.Actor, e.UserID, e.Username, e.AppID, e.Resource,
+		e.Outcome, e.Code, e.RequestID, e.TraceID, e.ClientIP)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (s sqlAudit) List(ctx context.Context, q AuditQuery) ([]*model.AuditEvent, error) {
+	query := "SELECT " + auditColumns + " FROM audit_events"
+	var conds []string
+	var args []interface{}
+	if q.Actor != "" {
+		conds = append(conds, "actor = ?")
+		args = append(args, q.Actor)
+	}
+	if q.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, q.Method)
+	}
+	if q.BeforeID > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, q.BeforeID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, q.Limit)
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]*model.AuditEvent, 0, q.Limit)
+	for rows.Next() {
+		var e model.AuditEvent
+		if err := rows.Scan(&e.ID, &e.Time, &e.Method, &e.Actor, &e.UserID, &e.Username, &e.AppID, &e.Resource,
+			&e.Outcome, &e.Code, &e.RequestID, &e.TraceID, &e.ClientIP); err != nil {
+			return nil, err
+		}
+		out = append(out, &e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+var (
+	poolMaxOpen = metrics.NewGaugeVec("db_pool_max_open_connections",
+		"Maximum number of open connections to the database, 0 for unlimited.", "db")
+	poolOpen = metrics.NewGaugeVec("db_pool_open_connections",
+		"Number of established connections, both in use and idle.", "db")
+	poolInUse = metrics.NewGaugeVec("db_pool_in_use_connections",
+		"Number of connections currently in use.", "db")
+	poolIdle = metrics.NewGaugeVec("db_pool_idle_connections",
+		"Number of idle connections.", "db")
+	poolWaits = metrics.NewCounterVec("db_pool_waits_total",
+		"Number of times a query waited for a free connection.", "db")
+	poolWaitSeconds = metrics.NewCounterVec("db_pool_wait_seconds_total",
+		"Total time spent waiting for a free connection.", "db")
+	poolClosed = metrics.NewCounterVec("db_pool_closed_connections_total",
+		"Number of connections closed by the pool by reason: max_idle, max_idle_time or max_lifetime.", "db", "reason")
+)
+
+// poolStats 使用连接池的Registry, 内存实现和事务不实现
+type poolStats interface {
+	Stats() sql.DBStats
+}
+
+// Stats 返回连接池状态
+func (m *sqlRegistry) Stats() sql.DBStats { return m.pool.Stats() }
+
+// ReportPoolStats 每隔interval把reg的连接池状态导出为db_pool_*指标, name为db标签的值(如primary、standby),
+// 直到ctx取消. reg没有连接池(如内存实现)时直接返回
+func ReportPoolStats(ctx context.Context, reg Registry, name string, interval time.Duration) {
+	ps, ok := reg.(poolStats)
+	if !ok {
+		return
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var last sql.DBStats
+	for {
+		s := ps.Stats()
+		poolMaxOpen.WithLabelValues(name).Set(float64(s.MaxOpenConnections))
+		poolOpen.WithLabelValues(name).Set(float64(s.OpenConnections))
+		poolInUse.WithLabelValues(name).Set(float64(s.InUse))
+		poolIdle.WithLabelValues(name).Set(float64(s.Idle))
+		// DBStats中的计数是累计值, counter按增量累加
+		poolWaits.WithLabelValues(name).Add(float64(s.WaitCount - last.WaitCount))
+		poolWaitSeconds.WithLabelValues(name).Add((s.WaitDuration - last.WaitDuration).Seconds())
+		poolClosed.WithLabelValues(name, "max_idle").Add(float64(s.MaxIdleClosed - last.MaxIdleClosed))
+		poolClosed.WithLabelValues(name, "max_idle_time").Add(float64(s.MaxIdleTimeClosed - last.MaxIdleTimeClosed))
+		poolClosed.WithLabelValues(name, "max_lifetime").Add(float64(s.MaxLifetimeClosed - last.MaxLifetimeClosed))
+		last = s
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/pkg/tracing"
+)
+
+// traceTx 在名为db.transaction的span中执行f, 记录f返回的错误
+func traceTx(ctx context.Context, f func(ctx context.Context) error) error {
+	ctx, s := tracing.Start(ctx, "db.transaction")
+	defer s.End()
+	err := f(ctx)
+	s.RecordError(err)
+	return err
+}
+
+// Transaction 内存实现的事务: 事务之间依次执行, 写操作立即生效并记录撤销操作, 失败时按相反顺序撤销.
+// 不在事务中的读写不受隔离, 可能看到未提交的修改
+func (m *memory) Transaction(ctx context.Context, f func(ctx context.Context, tx Registry) error) error {
+	return traceTx(ctx, func(ctx context.Context) error {
+		m.txMu.Lock()
+		defer m.txMu.Unlock()
+		tx := &memoryTx{m: m}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.rollback()
+				panic(p)
+			}
+		}()
+		if err := f(ctx, tx); err != nil {
+			tx.rollback()
+			return err
+		}
+		return nil
+	})
+}
+
+// memoryTx 事务中的memory, 各个存储的写操作成功后把撤销操作追加到undo
+type memoryTx struct {
+	m    *memory
+	undo []func()
+}
+
+func (t *memoryTx) onRollback(f func()) { t.undo = append(t.undo, f) }
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+}
+
+func (t *memoryTx) Users() UserStore { return txUsers{t.m.users, t} }
+
+func (t *memoryTx) Credentials() CredentialStore { return txCredentials{t.m.creds, t} }
+
+func (t *memoryTx) GreetingTemplates() GreetingTemplateStore { return txTemplates{t.m.tmpls, t} }
+
+func (t *memoryTx) Greetings() GreetingStore { return txGreetings{t.m.greets, t} }
+
+func (t *memoryTx) Policies() PolicyStore { return txPolicies{t.m.rules, t} }
+
+func (t *memoryTx) Reports() ReportStore { return txReports{t.m.rpts, t} }
+
+func (t *memoryTx) Usage() UsageStore { return txUsage{t.m.usage, t} }
+
+func (t *memoryTx) RefreshTokens() RefreshTokenStore { return txRefreshTokens{t.m.tokens, t} }
+
+func (t *memoryTx) Blogs() BlogStore { return txBlogs{t.m.blogs, t} }
+
+func (t *memoryTx) AuditEvents() AuditStore { return txAudit{t.m.audit, t} }
+
+// Transaction 复用当前事务
+func (t *memoryTx) Transaction(ctx context.Context, f func(ctx context.Context, tx Registry) error) error {
+	return traceTx(ctx, func(ctx context.Context) error { return f(ctx, t) })
+}
+
+type txUsers struct {
+	*memoryUsers
+	tx *memoryTx
+}
+
+func (s txUsers) Create(ctx context.Context, u *model.User) error {
+	if err := s.memoryUsers.Create(ctx, u); err != nil {
+		return err
+	}
+	id := u.ID
+	s.tx.onRollback(func() { s.memoryUsers.Delete(ctx, id) })
+	return nil
+}
+
+func (s txUsers) Update(ctx context.Context, u *model.User) error {
+	old, err := s.memoryUsers.Get(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.memoryUsers.Update(ctx, u); err != nil {
+		return err
+	}
+	s.tx.onRollback(func() { s.memoryUsers.put(old) })
+	return nil
+}
+
+func (s txUsers) Delete(ctx context.Context, id int64) error {
+	old, err := s.memoryUsers.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.memoryUsers.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.tx.onRollback(func() { s.memoryUsers.put(old) })
+	return nil
+}
+
+type txCredentials struct {
+	*memoryCredentials
+	tx *memoryTx
+}
+
+func (s txCredentials) Create(ctx context.Context, c *model.Credential) error {
+	if err := s.memoryCredentials.Create(ctx, c); err != nil {
+		return err
+	}
+	id := c.UserID
+	s.tx.onRollback(func() { s.memoryCredentials.Delete(ctx, id) })
+	return nil
+}
+
+func (s txCredentials) UpdatePasswordHash(ctx context.Context, userID int64, hash string) error {
+	old, ok := s.get(userID)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.memoryCredentials.UpdatePasswordHash(ctx, userID, hash); err != nil {
+		return err
+	}
+	s.tx.onRollback(func() { s.put(old) })
+	return nil
+}
+
+func (s txCredentials) Delete(ctx context.Context, userID int64) error {
+	old, ok := s.get(userID)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.memoryCredentials.Delete(ctx, userID); err != nil {
+		return err
+	}
+	s.tx.onRollback(func() { s.put(old) })
+	return nil
+}
+
+type txTemplates struct {
+	*memoryTemplates
+	tx *memoryTx
+}
+
+func (s txTemplates) Save(ctx context.Context, t *model.GreetingTemplate) error {
+	old, err := s.memoryTemplates.Get(ctx, t.ID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := s.memoryTemplates.Save(ctx, t); err != nil {
+		return err
+	}
+	id := t.ID
+	s.tx.onRollback(func() { s.put(id, old) })
+	return nil
+}
+
+type txGreetings struct {
+	*memoryGreetings
+	tx *memoryTx
+}
+
+func (s txGreetings) Create(ctx context.Context, g *model.Greeting) error {
+	if err := s.memoryGreetings.Create(ctx, g); err != nil {
+		return err
+	}
+	id := g.ID
+	s.tx.onRollback(func() { s.remove(id) })
+	return nil
+}
+
+type txPolicies struct {
+	*memoryPolicies
+	tx *memoryTx
+}
+
+func (s txPolicies) Add(ctx context.Context, r *model.PolicyRule) error {
+	if err := s.memoryPolicies.Add(ctx, r); err != nil {
+		return err
+	}
+	cp := *r
+	s.tx.onRollback(func() { s.remove(&cp) })
+	return nil
+}
+
+func (s txPolicies) Remove(ctx context.Context, r *model.PolicyRule) error {
+	i, err := s.remove(r)
+	if err != nil {
+		return err
+	}
+	cp := *r
+	s.tx.onRollback(func() { s.insert(i, cp) })
+	return nil
+}
+
+type txReports struct {
+	*memoryReports
+	tx *memoryTx
+}
+
+func (s txReports) Save(ctx context.Context, r *model.Report) error {
+	old, err := s.memoryReports.Get(ctx, r.Date)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := s.memoryReports.Save(ctx, r); err != nil {
+		return err
+	}
+	date := r.Date
+	s.tx.onRollback(func() { s.put(date, old) })
+	return nil
+}
+
+type txUsage struct {
+	*memoryUsage
+	tx *memoryTx
+}
+
+func (s txUsage) Add(ctx context.Context, us []*model.Usage) error {
+	if err := s.memoryUsage.Add(ctx, us); err != nil {
+		return err
+	}
+	cp := make([]*model.Usage, len(us))
+	for i, u := range us {
+		c := *u
+		cp[i] = &c
+	}
+	s.tx.onRollback(func() { s.sub(cp) })
+	return nil
+}
+
+type txRefreshTokens struct {
+	*memoryRefreshTokens
+	tx *memoryTx
+}
+
+func (s txRefreshTokens) Create(ctx context.Context, t *model.RefreshToken) error {
+	if err := s.memoryRefreshTokens.Create(ctx, t); err != nil {
+		return err
+	}
+	hash := t.Hash
+	s.tx.onRollback(func() { s.remove(hash) })
+	return nil
+}
+
+func (s txRefreshTokens) Use(ctx context.Context, hash string, at time.Time) (*model.RefreshToken, error) {
+	t, err := s.memoryRefreshTokens.Use(ctx, hash, at)
+	if err != nil {
+		return nil, err
+	}
+	if t.UsedAt.IsZero() {
+		s.tx.onRollback(func() { s.reset(hash, true, false) })
+	}
+	return t, nil
+}
+
+func (s txRefreshTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	s.unrevokeOnRollback(s.revokeFamily(familyID, at))
+	return nil
+}
+
+func (s txRefreshTokens) RevokeUser(ctx context.Context, userID int64, at time.Time) error {
+	s.unrevokeOnRollback(s.revokeUser(userID, at))
+	return nil
+}
+
+// unrevokeOnRollback 回滚时恢复hashes对应的token
+func (s txRefreshTokens) unrevokeOnRollback(hashes []string) {
+	s.tx.onRollback(func() {
+		for _, h := range hashes {
+			s.reset(h, false, true)
+		}
+	})
+}
+
+type txBlogs struct {
+	*memoryBlogs
+	tx *memoryTx
+}
+
+func (s txBlogs) Create(ctx context.Context, b *model.Blog) error {
+	if err := s.memoryBlogs.Create(ctx, b); err != nil {
+		return err
+	}
+	id := b.ID
+	s.tx.onRollback(func() { s.remove(id) })
+	return nil
+}
+
+type txAudit struct {
+	*memoryAudit
+	tx *memoryTx
+}
+
+func (s txAudit) Append(ctx context.Context, e *model.AuditEvent) error {
+	if err := s.memoryAudit.Append(ctx, e); err != nil {
+		return err
+	}
+	id := e.ID
+	s.tx.onRollback(func() { s.remove(id) })
+	return nil
+}
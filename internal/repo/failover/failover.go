@@ -0,0 +1,445 @@
+// Package failover 在主库持续故障时把db.Registry的读请求(standby已提升为主库时包括写请求)切换到备库,
+// 主库恢复后通过健康探测切回, 避免数据库主备切换期间服务完全不可用.
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// State 当前使用的数据库
+type State int
+
+const (
+	// Primary 读写都使用主库
+	Primary State = iota
+	// StandbyReads 读使用备库, 写仍使用主库
+	StandbyReads
+	// Standby 读写都使用备库
+	Standby
+)
+
+func (s State) String() string {
+	switch s {
+	case StandbyReads:
+		return "standby_reads"
+	case Standby:
+		return "standby"
+	}
+	return "primary"
+}
+
+// Config 切换策略
+type Config struct {
+	// FailureThreshold 主库连续失败多少次后切换到备库
+	FailureThreshold int
+	// RecoveryThreshold 切换后主库连续多少次探测成功后切回
+	RecoveryThreshold int
+	// ProbeInterval 探测主库的间隔
+	ProbeInterval time.Duration
+	// WriteToStandby 切换时写请求也使用备库, 只应在备库已提升为可写的主库时开启
+	WriteToStandby bool
+}
+
+var (
+	stateGauge = metrics.NewGaugeVec("db_failover_state",
+		"Database in use: 0 primary, 1 reads on the standby, 2 reads and writes on the standby.")
+	switchesTotal = metrics.NewCounterVec("db_failover_switches_total",
+		"Number of database failover switches by target state.", "to")
+)
+
+// Registry 带主备切换的db.Registry
+type Registry struct {
+	primary, standby db.Registry
+	c                Config
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+}
+
+// New 创建Registry, 需调用Run探测主库
+func New(primary, standby db.Registry, c Config) *Registry {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.RecoveryThreshold <= 0 {
+		c.RecoveryThreshold = 3
+	}
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = 5 * time.Second
+	}
+	stateGauge.WithLabelValues().Set(float64(Primary))
+	return &Registry{primary: primary, standby: standby, c: c}
+}
+
+// State 返回当前状态
+func (r *Registry) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Run 每隔ProbeInterval探测主库, 直到ctx取消
+func (r *Registry) Run(ctx context.Context) {
+	t := time.NewTicker(r.c.ProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			pctx, cancel := context.WithTimeout(ctx, r.c.ProbeInterval)
+			r.observe(Probe(pctx, r.primary))
+			cancel()
+		}
+	}
+}
+
+// Probe 检查reg是否可用: 查询不存在的用户, 能正常返回ErrNotFound即认为可用
+func Probe(ctx context.Context, reg db.Registry) error {
+	if _, err := reg.Users().Get(ctx, 0); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// failed 是否为数据库故障; 记录不存在、唯一键冲突和调用方取消不算
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, db.ErrNotFound) && !errors.Is(err, db.ErrDuplicate) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// observe 记录一次主库请求或探测的结果, 必要时切换
+func (r *Registry) observe(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !failed(err) {
+		r.failures = 0
+		if r.state != Primary {
+			if r.successes++; r.successes >= r.c.RecoveryThreshold {
+				r.switchTo(Primary, nil)
+			}
+		}
+		return
+	}
+	r.successes = 0
+	if r.failures++; r.failures >= r.c.FailureThreshold && r.state == Primary {
+		to := StandbyReads
+		if r.c.WriteToStandby {
+			to = Standby
+		}
+		r.switchTo(to, err)
+	}
+}
+
+func (r *Registry) switchTo(s State, cause error) {
+	r.state, r.failures, r.successes = s, 0, 0
+	stateGauge.WithLabelValues().Set(float64(s))
+	switchesTotal.WithLabelValues(s.String()).Inc()
+	if cause != nil {
+		zaplog.L().Warn("db: primary is failing, switched to standby", zaplog.String("state", s.String()), zaplog.Error(cause))
+	} else {
+		zaplog.L().Info("db: primary recovered, switched back", zaplog.String("state", s.String()))
+	}
+}
+
+// reader 返回读请求使用的Registry, 以及是否为主库
+func (r *Registry) reader() (db.Registry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == Primary {
+		return r.primary, true
+	}
+	return r.standby, false
+}
+
+// writer 返回写请求使用的Registry, 以及是否为主库
+func (r *Registry) writer() (db.Registry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == Standby {
+		return r.standby, false
+	}
+	return r.primary, true
+}
+
+// done 记录使用主库的请求结果, 备库的结果不影响切换
+func (r *Registry) done(primary bool, err error) {
+	if primary {
+		r.observe(err)
+	}
+}
+
+func (r *Registry) Users() db.UserStore { return users{r} }
+
+func (r *Registry) Credentials() db.CredentialStore { return credentials{r} }
+
+func (r *Registry) GreetingTemplates() db.GreetingTemplateStore { return templates{r} }
+
+func (r *Registry) Greetings() db.GreetingStore { return greetings{r} }
+
+func (r *Registry) Policies() db.PolicyStore { return policies{r} }
+
+func (r *Registry) Reports() db.ReportStore { return reports{r} }
+
+func (r *Registry) Usage() db.UsageStore { return usage{r} }
+
+func (r *Registry) RefreshTokens() db.RefreshTokenStore { return refreshTokens{r} }
+
+func (r *Registry) Blogs() db.BlogStore { return blogs{r} }
+
+func (r *Registry) AuditEvents() db.AuditStore { return auditEvents{r} }
+
+// Transaction 在写请求使用的数据库上执行事务. 只有开始和提交事务的错误计入故障, f返回的错误属于业务结果
+func (r *Registry) Transaction(ctx context.Context, f func(ctx context.Context, tx db.Registry) error) error {
+	reg, p := r.writer()
+	var ferr error
+	err := reg.Transaction(ctx, func(ctx context.Context, tx db.Registry) error {
+		ferr = f(ctx, tx)
+		return ferr
+	})
+	if ferr == nil || err != ferr {
+		r.done(p, err)
+	}
+	return err
+}
+
+type users struct{ r *Registry }
+
+func (s users) Get(ctx context.Context, id int64) (*model.User, error) {
+	reg, p := s.r.reader()
+	u, err := reg.Users().Get(ctx, id)
+	s.r.done(p, err)
+	return u, err
+}
+
+func (s users) Create(ctx context.Context, u *model.User) error {
+	reg, p := s.r.writer()
+	err := reg.Users().Create(ctx, u)
+	s.r.done(p, err)
+	return err
+}
+
+func (s users) Update(ctx context.Context, u *model.User) error {
+	reg, p := s.r.writer()
+	err := reg.Users().Update(ctx, u)
+	s.r.done(p, err)
+	return err
+}
+
+func (s users) Delete(ctx context.Context, id int64) error {
+	reg, p := s.r.writer()
+	err := reg.Users().Delete(ctx, id)
+	s.r.done(p, err)
+	return err
+}
+
+func (s users) CountCreated(ctx context.Context, from, to time.Time) (int64, error) {
+	reg, p := s.r.reader()
+	n, err := reg.Users().CountCreated(ctx, from, to)
+	s.r.done(p, err)
+	return n, err
+}
+
+func (s users) List(ctx context.Context, q db.UserQuery) ([]*model.User, error) {
+	reg, p := s.r.reader()
+	list, err := reg.Users().List(ctx, q)
+	s.r.done(p, err)
+	return list, err
+}
+
+type credentials struct{ r *Registry }
+
+func (s credentials) GetByUsername(ctx context.Context, username string) (*model.Credential, error) {
+	reg, p := s.r.reader()
+	c, err := reg.Credentials().GetByUsername(ctx, username)
+	s.r.done(p, err)
+	return c, err
+}
+
+func (s credentials) Create(ctx context.Context, c *model.Credential) error {
+	reg, p := s.r.writer()
+	err := reg.Credentials().Create(ctx, c)
+	s.r.done(p, err)
+	return err
+}
+
+func (s credentials) UpdatePasswordHash(ctx context.Context, userID int64, hash string) error {
+	reg, p := s.r.writer()
+	err := reg.Credentials().UpdatePasswordHash(ctx, userID, hash)
+	s.r.done(p, err)
+	return err
+}
+
+func (s credentials) Delete(ctx context.Context, userID int64) error {
+	reg, p := s.r.writer()
+	err := reg.Credentials().Delete(ctx, userID)
+	s.r.done(p, err)
+	return err
+}
+
+type templates struct{ r *Registry }
+
+func (s templates) Get(ctx context.Context, id string) (*model.GreetingTemplate, error) {
+	reg, p := s.r.reader()
+	t, err := reg.GreetingTemplates().Get(ctx, id)
+	s.r.done(p, err)
+	return t, err
+}
+
+func (s templates) Save(ctx context.Context, t *model.GreetingTemplate) error {
+	reg, p := s.r.writer()
+	err := reg.GreetingTemplates().Save(ctx, t)
+	s.r.done(p, err)
+	return err
+}
+
+type greetings struct{ r *Registry }
+
+func (s greetings) Create(ctx context.Context, g *model.Greeting) error {
+	reg, p := s.r.writer()
+	err := reg.Greetings().Create(ctx, g)
+	s.r.done(p, err)
+	return err
+}
+
+func (s greetings) List(ctx context.Context, beforeID int64, limit int) ([]*model.Greeting, error) {
+	reg, p := s.r.reader()
+	gs, err := reg.Greetings().List(ctx, beforeID, limit)
+	s.r.done(p, err)
+	return gs, err
+}
+
+type policies struct{ r *Registry }
+
+func (s policies) List(ctx context.Context) ([]*model.PolicyRule, error) {
+	reg, p := s.r.reader()
+	rules, err := reg.Policies().List(ctx)
+	s.r.done(p, err)
+	return rules, err
+}
+
+func (s policies) Add(ctx context.Context, rule *model.PolicyRule) error {
+	reg, p := s.r.writer()
+	err := reg.Policies().Add(ctx, rule)
+	s.r.done(p, err)
+	return err
+}
+
+func (s policies) Remove(ctx context.Context, rule *model.PolicyRule) error {
+	reg, p := s.r.writer()
+	err := reg.Policies().Remove(ctx, rule)
+	s.r.done(p, err)
+	return err
+}
+
+type reports struct{ r *Registry }
+
+func (s reports) Get(ctx context.Context, date string) (*model.Report, error) {
+	reg, p := s.r.reader()
+	rpt, err := reg.Reports().Get(ctx, date)
+	s.r.done(p, err)
+	return rpt, err
+}
+
+func (s reports) Save(ctx context.Context, rpt *model.Report) error {
+	reg, p := s.r.writer()
+	err := reg.Reports().Save(ctx, rpt)
+	s.r.done(p, err)
+	return err
+}
+
+type usage struct{ r *Registry }
+
+func (s usage) Add(ctx context.Context, us []*model.Usage) error {
+	reg, p := s.r.writer()
+	err := reg.Usage().Add(ctx, us)
+	s.r.done(p, err)
+	return err
+}
+
+func (s usage) List(ctx context.Context, caller, from, to string) ([]*model.Usage, error) {
+	reg, p := s.r.reader()
+	us, err := reg.Usage().List(ctx, caller, from, to)
+	s.r.done(p, err)
+	return us, err
+}
+
+type refreshTokens struct{ r *Registry }
+
+func (s refreshTokens) Create(ctx context.Context, t *model.RefreshToken) error {
+	reg, p := s.r.writer()
+	err := reg.RefreshTokens().Create(ctx, t)
+	s.r.done(p, err)
+	return err
+}
+
+// Use 会修改记录, 走写库
+func (s refreshTokens) Use(ctx context.Context, hash string, at time.Time) (*model.RefreshToken, error) {
+	reg, p := s.r.writer()
+	t, err := reg.RefreshTokens().Use(ctx, hash, at)
+	s.r.done(p, err)
+	return t, err
+}
+
+func (s refreshTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	reg, p := s.r.writer()
+	err := reg.RefreshTokens().RevokeFamily(ctx, familyID, at)
+	s.r.done(p, err)
+	return err
+}
+
+func (s refreshTokens) RevokeUser(ctx context.Context, userID int64, at time.Time) error {
+	reg, p := s.r.writer()
+	err := reg.RefreshTokens().RevokeUser(ctx, userID, at)
+	s.r.done(p, err)
+	return err
+}
+
+type blogs struct{ r *Registry }
+
+func (s blogs) Create(ctx context.Context, b *model.Blog) error {
+	reg, p := s.r.writer()
+	err := reg.Blogs().Create(ctx, b)
+	s.r.done(p, err)
+	return err
+}
+
+func (s blogs) Get(ctx context.Context, id int64) (*model.Blog, error) {
+	reg, p := s.r.reader()
+	b, err := reg.Blogs().Get(ctx, id)
+	s.r.done(p, err)
+	return b, err
+}
+
+func (s blogs) List(ctx context.Context, q db.BlogQuery) ([]*model.Blog, error) {
+	reg, p := s.r.reader()
+	bs, err := reg.Blogs().List(ctx, q)
+	s.r.done(p, err)
+	return bs, err
+}
+
+type auditEvents struct{ r *Registry }
+
+func (s auditEvents) Append(ctx context.Context, e *model.AuditEvent) error {
+	reg, p := s.r.writer()
+	err := reg.AuditEvents().Append(ctx, e)
+	s.r.done(p, err)
+	return err
+}
+
+func (s auditEvents) List(ctx context.Context, q db.AuditQuery) ([]*model.AuditEvent, error) {
+	reg, p := s.r.reader()
+	es, err := reg.AuditEvents().List(ctx, q)
+	s.r.done(p, err)
+	return es, err
+}
@@ -0,0 +1,317 @@
+// Package migrate 按版本管理数据库表结构. 迁移文件命名为 <版本>_<名字>.up.sql 和 <版本>_<名字>.down.sql,
+// 语句之间以行尾的分号分隔, 以--开头的行为注释. 已执行的版本记录在schema_migrations表中
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMigrations 数据库不需要迁移, 如内存实现
+var ErrNoMigrations = errors.New("migrate: no migrations for this database")
+
+// Migration 一个版本的迁移
+type Migration struct {
+	Version int64
+	Name    string
+	// Up 和 Down 升级和回退执行的语句, Down为空时不能回退
+	Up, Down []string
+}
+
+// Load 读取fsys中dir目录下的迁移文件, 按版本升序返回
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".sql")
+		up := strings.HasSuffix(base, ".up")
+		if !up && !strings.HasSuffix(base, ".down") {
+			return nil, fmt.Errorf("migrate: %s: want <version>_<name>.up.sql or .down.sql", name)
+		}
+		base = base[:strings.LastIndexByte(base, '.')]
+		i := strings.IndexByte(base, '_')
+		if i <= 0 {
+			return nil, fmt.Errorf("migrate: %s: missing version", name)
+		}
+		v, err := strconv.ParseInt(base[:i], 10, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("migrate: %s: invalid version", name)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[v]
+		if m == nil {
+			m = &Migration{Version: v, Name: base[i+1:]}
+			byVersion[v] = m
+		} else if m.Name != base[i+1:] {
+			return nil, fmt.Errorf("migrate: version %d has different names %q and %q", v, m.Name, base[i+1:])
+		}
+		if up {
+			m.Up = Split(string(b))
+		} else {
+			m.Down = Split(string(b))
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.Up) == 0 {
+			return nil, fmt.Errorf("migrate: version %d has no up statements", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Split 按行尾的分号拆分语句, 忽略空行和--注释行
+func Split(s string) []string {
+	var stmts []string
+	var cur []string
+	for _, line := range strings.Split(s, "\n") {
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "--") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasSuffix(t, ";") {
+			cur = append(cur, strings.TrimSuffix(line, ";"))
+			stmts = append(stmts, strings.Join(cur, "\n"))
+			cur = nil
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		stmts = append(stmts, strings.Join(cur, "\n"))
+	}
+	return stmts
+}
+
+// Dialect 不同数据库的差异
+type Dialect struct {
+	// Table 创建schema_migrations表的语句, 需要可以重复执行
+	Table string
+	// Numbered 占位符为$1、$2..., 否则为?
+	Numbered bool
+	// Lock 和 Unlock 获取和释放会话级的锁, 避免多个实例同时迁移; 为空时不加锁. Lock返回0表示等待超时
+	Lock, Unlock string
+	// TxDDL DDL可以在事务中执行, 每个迁移连同版本记录在一个事务中提交
+	TxDDL bool
+}
+
+// Status 一个版本的执行状态
+type Status struct {
+	Version int64
+	Name    string
+	// AppliedAt 执行时间, 零值表示未执行
+	AppliedAt time.Time
+}
+
+// Migrator 在db上执行迁移
+type Migrator struct {
+	db *sql.DB
+	d  Dialect
+	ms []Migration
+}
+
+// New 创建Migrator, ms需按版本升序
+func New(db *sql.DB, d Dialect, ms []Migration) *Migrator {
+	return &Migrator{db: db, d: d, ms: ms}
+}
+
+// Close 关闭数据库
+func (m *Migrator) Close() error { return m.db.Close() }
+
+// Status 返回所有版本的状态, 按版本升序; 数据库中有但ms中没有的版本排在最后, Name为空
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(cn *sql.Conn) error {
+		applied, err := m.applied(ctx, cn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.ms {
+			out = append(out, Status{Version: mg.Version, Name: mg.Name, AppliedAt: applied[mg.Version]})
+			delete(applied, mg.Version)
+		}
+		var unknown []Status
+		for v, at := range applied {
+			unknown = append(unknown, Status{Version: v, AppliedAt: at})
+		}
+		sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+		out = append(out, unknown...)
+		return nil
+	})
+	return out, err
+}
+
+// Up 按版本升序执行所有未执行的迁移, 返回执行的个数
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(cn *sql.Conn) error {
+		applied, err := m.applied(ctx, cn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.ms {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, cn, mg, mg.Up, true); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down 按版本降序回退最近执行的steps个迁移, 返回回退的个数
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(cn *sql.Conn) error {
+		applied, err := m.applied(ctx, cn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.ms) - 1; i >= 0 && n < steps; i-- {
+			mg := m.ms[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if len(mg.Down) == 0 {
+				return fmt.Errorf("migrate: version %d (%s) cannot be reverted", mg.Version, mg.Name)
+			}
+			if err := m.run(ctx, cn, mg, mg.Down, false); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// locked 在持有迁移锁的连接上执行f
+func (m *Migrator) locked(ctx context.Context, f func(cn *sql.Conn) error) error {
+	cn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cn.Close()
+	if m.d.Lock != "" {
+		var v interface{}
+		if err := cn.QueryRowContext(ctx, m.d.Lock).Scan(&v); err != nil {
+			return fmt.Errorf("migrate: lock: %w", err)
+		}
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		if fmt.Sprint(v) == "0" {
+			return errors.New("migrate: timed out waiting for another instance to finish migrating")
+		}
+		defer func() {
+			// 调用方的ctx可能已取消, 仍需释放锁
+			uctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cn.QueryRowContext(uctx, m.d.Unlock).Scan(&v)
+		}()
+	}
+	if _, err := cn.ExecContext(ctx, m.d.Table); err != nil {
+		return fmt.Errorf("migrate: create schema_migrations: %w", err)
+	}
+	return f(cn)
+}
+
+// applied 返回已执行的版本及执行时间
+func (m *Migrator) applied(ctx context.Context, cn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := cn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]time.Time{}
+	for rows.Next() {
+		var v int64
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		out[v] = at
+	}
+	return out, rows.Err()
+}
+
+// execer *sql.Conn和*sql.Tx共有的方法
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// run 执行mg的stmts并更新版本记录, up为false时删除记录
+func (m *Migrator) run(ctx context.Context, cn *sql.Conn, mg Migration, stmts []string, up bool) error {
+	var ex execer = cn
+	var tx *sql.Tx
+	if m.d.TxDDL {
+		var err error
+		if tx, err = cn.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		ex = tx
+	}
+	for i, s := range stmts {
+		if _, err := ex.ExecContext(ctx, s); err != nil {
+			return fmt.Errorf("migrate: version %d (%s) statement %d: %w", mg.Version, mg.Name, i+1, err)
+		}
+	}
+	var err error
+	if up {
+		_, err = ex.ExecContext(ctx, m.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			mg.Version, mg.Name, time.Now().UTC().Truncate(time.Microsecond))
+	} else {
+		_, err = ex.ExecContext(ctx, m.rebind("DELETE FROM schema_migrations WHERE version = ?"), mg.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: record version %d: %w", mg.Version, err)
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// rebind 按Numbered改写?占位符
+func (m *Migrator) rebind(q string) string {
+	if !m.d.Numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// ChainUnary 把多个拦截器组合为一个, 执行顺序与grpc.ChainUnaryInterceptor相同.
+// 用于GraphQL、JSON-RPC等只接受单个拦截器的进程内调用.
+func ChainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			ic, h := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ic(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,131 @@
+// Package server 管理服务模块的注册
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/authz"
+	"github.com/Q1mi/greeter/pkg/config"
+	"github.com/Q1mi/greeter/pkg/health"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/kafka"
+	"github.com/Q1mi/greeter/pkg/leader"
+	"github.com/Q1mi/greeter/pkg/mailtmpl"
+	"github.com/Q1mi/greeter/pkg/notify"
+	"github.com/Q1mi/greeter/pkg/passwd"
+	"github.com/Q1mi/greeter/pkg/scheduler"
+	"github.com/Q1mi/greeter/pkg/stats"
+	"github.com/Q1mi/greeter/pkg/token"
+	"github.com/Q1mi/greeter/pkg/workerpool"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+)
+
+// App 服务模块初始化时可用的公共依赖
+type App struct {
+	Conf   *config.Config
+	DB     db.Registry
+	Passwd *passwd.Hasher
+	// Signer 签发邮箱验证等一次性token
+	Signer *token.Signer
+	// JWT 签发和校验access token
+	JWT *jwt.Signer
+	// Pool 异步任务池
+	Pool *workerpool.Pool
+	// Notifier 在任务池中异步发送邮件等通知
+	Notifier *notify.Dispatcher
+	// Mail 邮件模板
+	Mail *mailtmpl.Renderer
+	// Stats 调用统计
+	Stats *stats.Aggregator
+	// Scheduler 定时任务, 多实例部署时只在leader上执行
+	Scheduler *scheduler.Scheduler
+	// Elector 定时任务选主
+	Elector *leader.Elector
+	// Health 外部依赖的健康检查, 模块可在Init中添加自己的依赖
+	Health *health.Registry
+	// Authz 授权引擎, 未配置时为nil
+	Authz authz.Engine
+	// Commands 异步命令的Kafka消费者, 模块可在Init中注册命令, 未配置时为nil
+	Commands *kafka.Consumer
+}
+
+// Module 一个服务模块, 由各服务包在init中通过RegisterModule注册
+type Module struct {
+	// Name 健康检查使用的服务名, 一般为proto中的服务全名, 如 helloworld.Greeter
+	Name string
+	// Init 启动时在注册服务之前调用, 用于根据配置创建服务实现
+	Init func(ctx context.Context, app *App) error
+	// RegisterGRPC 注册gRPC服务实现, 同样用于GraphQL、JSON-RPC等进程内调用
+	RegisterGRPC func(s grpc.ServiceRegistrar)
+	// RegisterGateway 注册gateway handler, 通过endpoint访问gRPC服务
+	RegisterGateway func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error
+	// Order 模块的顺序, 小的在前, 相同时按RegisterModule的调用顺序. 包的init顺序取决于import关系,
+	// 不能用来决定顺序. JSON-RPC和GraphQL中同名的方法由先注册的服务使用不带包名的名称
+	Order int
+}
+
+var (
+	mu      sync.RWMutex
+	modules []Module
+)
+
+// RegisterModule 注册服务模块, 服务名重复时panic
+func RegisterModule(m Module) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, old := range modules {
+		if old.Name == m.Name {
+			panic(fmt.Sprintf("server: module %s registered twice", m.Name))
+		}
+	}
+	modules = append(modules, m)
+}
+
+// Modules 返回已注册的服务模块, 按Order和注册顺序排列
+func Modules() []Module {
+	mu.RLock()
+	list := append([]Module(nil), modules...)
+	mu.RUnlock()
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Order < list[j].Order })
+	return list
+}
+
+// Init 依次初始化所有模块
+func Init(ctx context.Context, app *App) error {
+	for _, m := range Modules() {
+		if m.Init == nil {
+			continue
+		}
+		if err := m.Init(ctx, app); err != nil {
+			return fmt.Errorf("init module %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// RegisterGRPC 把所有模块注册到s
+func RegisterGRPC(s grpc.ServiceRegistrar) {
+	for _, m := range Modules() {
+		if m.RegisterGRPC != nil {
+			m.RegisterGRPC(s)
+		}
+	}
+}
+
+// RegisterGateway 把所有模块的gateway handler注册到mux
+func RegisterGateway(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error {
+	for _, m := range Modules() {
+		if m.RegisterGateway == nil {
+			continue
+		}
+		if err := m.RegisterGateway(ctx, mux, endpoint, opts); err != nil {
+			return fmt.Errorf("register gateway for %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
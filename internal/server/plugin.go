@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugins 加载Go plugin形式的服务模块.
+// plugin需使用与本程序相同的Go版本和依赖版本以 -buildmode=plugin 编译,
+// 并在init中调用RegisterModule注册自己的服务.
+func LoadPlugins(paths []string) error {
+	for _, p := range paths {
+		before := len(Modules())
+		if _, err := plugin.Open(p); err != nil {
+			return fmt.Errorf("load plugin %s: %w", p, err)
+		}
+		if len(Modules()) == before {
+			return fmt.Errorf("load plugin %s: no module registered", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package admin 实现admin.AdminService服务
+package admin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/profiling"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize 每个ProfileChunk携带的最大字节数
+const chunkSize = 32 << 10
+
+// defaultCPUSeconds 未指定seconds时CPU profile的采样时长
+const defaultCPUSeconds = 30
+
+// errAdminRequired 管理接口在服务内检查角色, 不依赖授权引擎的配置
+var errAdminRequired = errs.New("admin.forbidden", "admin role required")
+
+var profileNames = map[adminpb.ProfileType]string{
+	adminpb.ProfileType_PROFILE_TYPE_CPU:       profiling.CPU,
+	adminpb.ProfileType_PROFILE_TYPE_HEAP:      profiling.Heap,
+	adminpb.ProfileType_PROFILE_TYPE_GOROUTINE: profiling.Goroutine,
+}
+
+func init() {
+	srv := &Server{}
+	enabled := false
+	server.RegisterModule(server.Module{
+		Name: adminpb.AdminService_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			c := app.Conf.Admin
+			enabled = c.Enabled
+			srv.app = app
+			srv.dir = c.ProfileDir
+			srv.max = c.MaxProfileDuration.D()
+			// 报表任务不受admin.enabled影响, 关闭管理服务时仍然生成
+			at, err := time.Parse("15:04", app.Conf.Report.Time)
+			if err != nil {
+				return fmt.Errorf("report.time: %w", err)
+			}
+			srv.reports = logic.NewReportUseCase(app.DB)
+			app.Scheduler.Daily("daily_report", time.Duration(at.Hour())*time.Hour+time.Duration(at.Minute())*time.Minute, reportJob(srv.reports))
+			srv.usage = logic.NewUsageUseCase(app.DB)
+			if m := app.Conf.Metering; m.Enabled && m.ExportDir != "" {
+				at, err := time.Parse("15:04", m.ExportTime)
+				if err != nil {
+					return fmt.Errorf("metering.export_time: %w", err)
+				}
+				app.Scheduler.Daily("usage_export", time.Duration(at.Hour())*time.Hour+time.Duration(at.Minute())*time.Minute, usageExportJob(srv.usage, m.ExportDir))
+			}
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			if enabled {
+				adminpb.RegisterAdminServiceServer(s, srv)
+			}
+		},
+	})
+}
+
+// requireAdmin 只允许带有admin角色的access token调用
+func requireAdmin(ctx context.Context) error {
+	if c, ok := ctxutil.ClaimsFrom(ctx); !ok || !c.HasRole(logic.RoleAdmin) {
+		return errs.Status(codes.PermissionDenied, errAdminRequired)
+	}
+	return nil
+}
+
+type Server struct {
+	adminpb.UnimplementedAdminServiceServer
+	dir string
+	max time.Duration
+	app *server.App
+	// reports 每日统计报表
+	reports *logic.ReportUseCase
+	// usage 调用方用量
+	usage *logic.UsageUseCase
+}
+
+func NewServer(dir string, max time.Duration) *Server {
+	return &Server{dir: dir, max: max}
+}
+
+func (s *Server) CaptureProfile(in *adminpb.CaptureProfileRequest, stream adminpb.AdminService_CaptureProfileServer) error {
+	if err := requireAdmin(stream.Context()); err != nil {
+		return err
+	}
+	kind, ok := profileNames[in.Type]
+	if !ok {
+		return status.Error(codes.InvalidArgument, "unsupported profile type")
+	}
+	if in.Seconds < 0 {
+		return status.Error(codes.InvalidArgument, "seconds must not be negative")
+	}
+	seconds := in.Seconds
+	if seconds == 0 && kind == profiling.CPU {
+		seconds = defaultCPUSeconds
+	}
+	d := time.Duration(seconds) * time.Second
+	if s.max > 0 && d > s.max {
+		return status.Errorf(codes.InvalidArgument, "seconds must not exceed %s", s.max)
+	}
+	if in.SaveToFile && s.dir == "" {
+		return status.Error(codes.FailedPrecondition, "admin.profile_dir is not configured")
+	}
+
+	var buf bytes.Buffer
+	if err := profiling.Capture(stream.Context(), kind, d, &buf); err != nil {
+		return toStatus(err)
+	}
+
+	if in.SaveToFile {
+		path, err := s.save(kind, buf.Bytes())
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return stream.Send(&adminpb.ProfileChunk{Path: path})
+	}
+	for b := buf.Bytes(); len(b) > 0; {
+		n := chunkSize
+		if n > len(b) {
+			n = len(b)
+		}
+		if err := stream.Send(&adminpb.ProfileChunk{Data: b[:n]}); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// save 把profile写入profile目录, 文件名包含类型和采集时间
+func (s *Server) save(kind string, data []byte) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.pb.gz", kind, time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// toStatus 把采集错误转换为gRPC状态
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, profiling.ErrBusy):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
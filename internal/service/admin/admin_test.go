@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// profileStream 只提供ctx的CaptureProfile流
+type profileStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *profileStream) Context() context.Context { return s.ctx }
+
+func (s *profileStream) Send(*adminpb.ProfileChunk) error { return nil }
+
+func TestRequireAdmin(t *testing.T) {
+	// 未初始化的Server在通过角色检查后返回FailedPrecondition, 未通过时返回PermissionDenied
+	s := &Server{}
+	contexts := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"anonymous", context.Background(), codes.PermissionDenied},
+		{"plain user", ctxutil.WithClaims(context.Background(), &ctxutil.Claims{UserID: 1, Username: "bob"}), codes.PermissionDenied},
+		{"ops", ctxutil.WithClaims(context.Background(), &ctxutil.Claims{UserID: 2, Username: "carol", Roles: []string{"ops"}}), codes.PermissionDenied},
+		{"admin", ctxutil.WithClaims(context.Background(), &ctxutil.Claims{UserID: 3, Username: "alice", Roles: []string{logic.RoleAdmin}}), codes.FailedPrecondition},
+	}
+	calls := map[string]func(ctx context.Context) error{
+		"Diagnose": func(ctx context.Context) error {
+			_, err := s.Diagnose(ctx, &adminpb.DiagnoseRequest{})
+			return err
+		},
+		"ListPolicies": func(ctx context.Context) error {
+			_, err := s.ListPolicies(ctx, &adminpb.ListPoliciesRequest{})
+			return err
+		},
+		"AddPolicy": func(ctx context.Context) error {
+			_, err := s.AddPolicy(ctx, &adminpb.AddPolicyRequest{})
+			return err
+		},
+		"GetUsage": func(ctx context.Context) error {
+			_, err := s.GetUsage(ctx, &adminpb.GetUsageRequest{})
+			return err
+		},
+		"PreviewEmail": func(ctx context.Context) error {
+			_, err := s.PreviewEmail(ctx, &adminpb.PreviewEmailRequest{})
+			return err
+		},
+		"CaptureProfile": func(ctx context.Context) error {
+			// 指定保存到文件而没有配置profile目录, 通过角色检查后返回FailedPrecondition
+			return s.CaptureProfile(&adminpb.CaptureProfileRequest{Type: adminpb.ProfileType_PROFILE_TYPE_HEAP, SaveToFile: true}, &profileStream{ctx: ctx})
+		},
+	}
+	for name, call := range calls {
+		for _, c := range contexts {
+			if got := status.Code(call(c.ctx)); got != c.want {
+				t.Errorf("%s as %s: code %s, want %s", name, c.name, got, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package admin
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+)
+
+// BuildInfo 读取二进制中嵌入的模块和VCS信息
+func BuildInfo() *adminpb.BuildInfo {
+	b := &adminpb.BuildInfo{GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.MainPath, b.MainVersion = bi.Main.Path, bi.Main.Version
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.VcsRevision = s.Value
+		case "vcs.time":
+			b.VcsTime = s.Value
+		case "vcs.modified":
+			b.VcsModified = s.Value == "true"
+		}
+	}
+	return b
+}
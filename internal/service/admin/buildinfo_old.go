@@ -0,0 +1,20 @@
+//go:build !go1.18
+// +build !go1.18
+
+package admin
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+)
+
+// BuildInfo 读取二进制中嵌入的模块信息, go1.18之前没有VCS信息
+func BuildInfo() *adminpb.BuildInfo {
+	b := &adminpb.BuildInfo{GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		b.MainPath, b.MainVersion = bi.Main.Path, bi.Main.Version
+	}
+	return b
+}
@@ -0,0 +1,201 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/config"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultHealthTimeout 未指定health_timeout_ms时每个健康检查的超时时间
+const defaultHealthTimeout = 2 * time.Second
+
+// redacted 替换配置中敏感值的字符串
+const redacted = "REDACTED"
+
+// sensitiveKeys 除zaplog.SensitiveFields外, 名字包含这些词的配置项也会被脱敏
+var sensitiveKeys = []string{"authorization", "api_key", "dsn"}
+
+// startTime 进程启动时间, 用于计算uptime
+var startTime = time.Now()
+
+func (s *Server) Diagnose(ctx context.Context, in *adminpb.DiagnoseRequest) (*adminpb.DiagnoseReply, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.app == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	if in.HealthTimeoutMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "health_timeout_ms must not be negative")
+	}
+	timeout := defaultHealthTimeout
+	if in.HealthTimeoutMs > 0 {
+		timeout = time.Duration(in.HealthTimeoutMs) * time.Millisecond
+	}
+
+	conf, err := redactConfig(s.app.Conf)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	host, _ := os.Hostname()
+	reply := &adminpb.DiagnoseReply{
+		Hostname:   host,
+		Pid:        int32(os.Getpid()),
+		Build:      BuildInfo(),
+		ConfigJson: conf,
+		Features:   features(s.app.Conf),
+		Runtime:    s.runtimeStats(),
+	}
+	if s.app.Health != nil {
+		for _, r := range s.app.Health.Run(ctx, timeout) {
+			d := &adminpb.DependencyHealth{
+				Name:      r.Name,
+				Healthy:   r.Healthy(),
+				LatencyMs: float64(r.Latency) / float64(time.Millisecond),
+			}
+			if r.Err != nil {
+				d.Error = r.Err.Error()
+			}
+			reply.Dependencies = append(reply.Dependencies, d)
+		}
+	}
+	return reply, nil
+}
+
+func (s *Server) runtimeStats() *adminpb.RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rs := &adminpb.RuntimeStats{
+		UptimeSeconds:  int64(time.Since(startTime) / time.Second),
+		Goroutines:     int32(runtime.NumGoroutine()),
+		Gomaxprocs:     int32(runtime.GOMAXPROCS(0)),
+		NumCpu:         int32(runtime.NumCPU()),
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapSysBytes:   ms.HeapSys,
+		SysBytes:       ms.Sys,
+		NumGc:          ms.NumGC,
+		GcPauseTotalMs: float64(ms.PauseTotalNs) / float64(time.Millisecond),
+	}
+	if s.app.Stats != nil {
+		for _, m := range s.app.Stats.Snapshot().Methods {
+			rs.RequestsTotal += m.Total
+		}
+	}
+	if s.app.Elector != nil {
+		rs.Leader = s.app.Elector.IsLeader()
+	}
+	if s.app.Pool != nil {
+		rs.WorkerPoolQueued = int32(s.app.Pool.Queued())
+	}
+	return rs
+}
+
+// features 根据配置推导各可选功能是否开启
+func features(c *config.Config) map[string]bool {
+	return map[string]bool{
+		"graphql":          c.Server.GraphQL,
+		"canary":           len(c.Server.Canary.Targets) > 0,
+		"tls_listeners":    tlsListeners(c),
+		"split_listeners":  c.Server.Split(),
+		"health_watch":     c.Health.Interval > 0,
+		"pprof":            c.Debug.EnablePprof,
+		"cors":             c.CORS.Enabled(),
+		"compression":      c.Server.Compression.Enabled,
+		"jwt_auth":         c.Auth.JWT.Enabled,
+		"api_key":          c.Auth.APIKey.Enabled,
+		"refresh_rotation": c.Auth.RefreshTTL > 0 && c.Auth.RefreshRotation,
+		"cache":            c.Cache.TTL > 0,
+		"db_failover":      c.DB.StandbyDSN != "",
+		"db_mysql":         strings.HasPrefix(c.DB.OpenDSN(c.DB.DSN), "mysql:"),
+		"db_postgres":      strings.HasPrefix(c.DB.OpenDSN(c.DB.DSN), "postgres"),
+		"shadow":           c.Shadow.Target != "" && c.Shadow.Percent > 0,
+		"record":           c.Record.Dir != "" && c.Record.Percent > 0,
+		"mock":             len(c.Mock.Methods) > 0,
+		"stats_persist":    c.Stats.File != "",
+		"slo_alerts":       c.SLO.AlertBurnRate > 0 && len(c.SLO.Objectives) > 0,
+		"slo_alert_emails": c.SLO.AlertBurnRate > 0 && len(c.SLO.Objectives) > 0 && len(c.SLO.AlertEmails) > 0,
+		"log_sampling":     c.Log.Sampling.Initial > 0 || len(c.Log.Sampling.Levels) > 0,
+		"log_shipping":     c.Log.Ship.Type != "",
+		"leader_redis":     c.Leader.Backend == config.LeaderRedis,
+		"random_secret":    c.Auth.Secret == "",
+		"tracing":          c.Tracing.Enabled && c.Tracing.Exporter != "",
+		"authz":            c.Authz.Engine != "",
+		"kafka_commands":   len(c.Kafka.Brokers) > 0 && c.Kafka.Consumer.Topic != "",
+		"metering":         c.Metering.Enabled,
+		"usage_export":     c.Metering.Enabled && c.Metering.ExportDir != "",
+	}
+}
+
+// tlsListeners 是否有监听地址使用TLS
+func tlsListeners(c *config.Config) bool {
+	for _, l := range append(c.Server.GRPCListenConfigs(), c.Server.HTTPListenConfigs()...) {
+		if l.TLS.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// redactConfig 把配置序列化为JSON, 敏感项的非空字符串值以及webhook附加请求头替换为redacted
+func redactConfig(c *config.Config) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	b, err = json.MarshalIndent(redact("", v), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func redact(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range v {
+			if key == "headers" {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redact(k, sub)
+		}
+	case []interface{}:
+		for i, sub := range v {
+			v[i] = redact(key, sub)
+		}
+	case string:
+		if v != "" && isSensitive(key) {
+			return redacted
+		}
+	}
+	return v
+}
+
+func isSensitive(key string) bool {
+	if zaplog.IsSensitiveField(key) {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/Q1mi/greeter/pkg/mailtmpl"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) ListEmailTemplates(ctx context.Context, in *adminpb.ListEmailTemplatesRequest) (*adminpb.ListEmailTemplatesReply, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.app == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	reply := &adminpb.ListEmailTemplatesReply{}
+	for _, name := range s.app.Mail.Names() {
+		reply.Templates = append(reply.Templates, &adminpb.EmailTemplate{Name: name, Locales: s.app.Mail.Locales(name)})
+	}
+	return reply, nil
+}
+
+func (s *Server) PreviewEmail(ctx context.Context, in *adminpb.PreviewEmailRequest) (*adminpb.PreviewEmailReply, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.app == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	data, ok := mailtmpl.Sample(in.Template)
+	if !ok || s.app.Mail.Locales(in.Template) == nil {
+		return nil, status.Errorf(codes.NotFound, "unknown email template %q", in.Template)
+	}
+	var locales []string
+	if in.Locale != "" {
+		locales = []string{in.Locale}
+	}
+	r, err := s.app.Mail.Render(in.Template, locales, data)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminpb.PreviewEmailReply{Locale: r.Locale, Subject: r.Subject, Text: r.Text, Html: r.HTML}, nil
+}
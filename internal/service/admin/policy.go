@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/authz"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *Server) ListPolicies(ctx context.Context, in *adminpb.ListPoliciesRequest) (*adminpb.ListPoliciesReply, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.app == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	rules, err := s.app.DB.Policies().List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	reply := &adminpb.ListPoliciesReply{}
+	for _, r := range rules {
+		reply.Rules = append(reply.Rules, &adminpb.PolicyRule{Ptype: r.PType, V0: r.V0, V1: r.V1, V2: r.V2})
+	}
+	return reply, nil
+}
+
+func (s *Server) AddPolicy(ctx context.Context, in *adminpb.AddPolicyRequest) (*emptypb.Empty, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	r, err := s.policyRule(in.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.app.DB.Policies().Add(ctx, r); err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			return nil, status.Error(codes.AlreadyExists, "rule already exists")
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.reloadPolicies(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) RemovePolicy(ctx context.Context, in *adminpb.RemovePolicyRequest) (*emptypb.Empty, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	r, err := s.policyRule(in.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.app.DB.Policies().Remove(ctx, r); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "rule not found")
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.reloadPolicies(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+// policyRule 校验并转换请求中的规则
+func (s *Server) policyRule(pb *adminpb.PolicyRule) (*model.PolicyRule, error) {
+	if s.app == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	if pb == nil {
+		return nil, status.Error(codes.InvalidArgument, "rule is required")
+	}
+	r := authz.Rule{PType: pb.Ptype, V0: pb.V0, V1: pb.V1, V2: pb.V2}
+	if err := authz.ValidateRule(r); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &model.PolicyRule{PType: r.PType, V0: r.V0, V1: r.V1, V2: r.V2}, nil
+}
+
+// reloadPolicies 让本实例的授权引擎立即使用修改后的规则. 规则已保存, 加载失败时只记录日志
+func (s *Server) reloadPolicies(ctx context.Context) {
+	if r, ok := s.app.Authz.(authz.Reloader); ok {
+		if err := r.Reload(ctx); err != nil {
+			zaplog.FromContext(ctx).Error("admin: reload policies", zaplog.Error(err))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/pkg/errs"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *Server) GetReport(ctx context.Context, in *adminpb.GetReportRequest) (*adminpb.Report, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.reports == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	r, err := s.reports.Get(ctx, in.Date)
+	switch {
+	case errors.Is(err, logic.ErrInvalidDate):
+		return nil, errs.Status(codes.InvalidArgument, err)
+	case errors.Is(err, logic.ErrReportNotFound):
+		return nil, errs.Status(codes.NotFound, err)
+	case err != nil:
+		return nil, errs.Status(codes.Internal, errs.ErrInternal)
+	}
+	return &adminpb.Report{
+		Date:          r.Date,
+		Greetings:     r.Greetings,
+		UniqueCallers: r.UniqueCallers,
+		ByLocale:      r.ByLocale,
+		ByTemplate:    r.ByTemplate,
+		NewUsers:      r.NewUsers,
+		GeneratedAt:   timestamppb.New(r.GeneratedAt),
+	}, nil
+}
+
+// reportJob 生成前一天(UTC)的报表
+func reportJob(uc *logic.ReportUseCase) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := uc.Generate(ctx, time.Now().UTC().AddDate(0, 0, -1))
+		return err
+	}
+}
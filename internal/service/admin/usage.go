@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	adminpb "github.com/Q1mi/greeter/proto/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) GetUsage(ctx context.Context, in *adminpb.GetUsageRequest) (*adminpb.GetUsageReply, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.usage == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not initialized")
+	}
+	us, err := s.usage.Get(ctx, in.Caller, in.FromDate, in.ToDate)
+	switch {
+	case errors.Is(err, logic.ErrInvalidDate), errors.Is(err, logic.ErrInvalidRange):
+		return nil, errs.Status(codes.InvalidArgument, err)
+	case err != nil:
+		return nil, errs.Status(codes.Internal, errs.ErrInternal)
+	}
+	out := &adminpb.GetUsageReply{Usages: make([]*adminpb.Usage, 0, len(us))}
+	for _, u := range us {
+		out.Usages = append(out.Usages, &adminpb.Usage{
+			Date:          u.Date,
+			Caller:        u.Caller,
+			Method:        u.Method,
+			Calls:         u.Calls,
+			Errors:        u.Errors,
+			RequestBytes:  u.RequestBytes,
+			ResponseBytes: u.ResponseBytes,
+			ComputeUnits:  u.ComputeUnits,
+		})
+	}
+	return out, nil
+}
+
+// usageExportJob 导出前一天(UTC)的用量
+func usageExportJob(uc *logic.UsageUseCase, dir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		path, err := uc.Export(ctx, time.Now().UTC().AddDate(0, 0, -1).Format(logic.DateLayout), dir)
+		if err != nil {
+			return err
+		}
+		zaplog.FromContext(ctx).Info("admin: usage exported", zaplog.String("path", path))
+		return nil
+	}
+}
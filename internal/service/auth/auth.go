@@ -0,0 +1,129 @@
+// Package auth 实现auth.AuthService服务
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	authpb "github.com/Q1mi/greeter/proto/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errCredentialsRequired    = errs.New("request.credentials_required", "username and password are required")
+	errOldCredentialsRequired = errs.New("request.old_credentials_required", "username and old_password are required")
+	errRefreshTokenRequired   = errs.New("request.refresh_token_required", "refresh_token is required")
+)
+
+func init() {
+	srv := &Server{}
+	server.RegisterModule(server.Module{
+		Name: authpb.AuthService_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			uc, err := logic.NewAuthUseCase(app.DB, app.Passwd, app.JWT, app.Conf.Auth.AccessTTL.D())
+			if err != nil {
+				return err
+			}
+			uc.RefreshTTL = app.Conf.Auth.RefreshTTL.D()
+			uc.RotateRefresh = app.Conf.Auth.RefreshRotation
+			srv.uc = uc
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			authpb.RegisterAuthServiceServer(s, srv)
+		},
+		RegisterGateway: authpb.RegisterAuthServiceHandlerFromEndpoint,
+	})
+}
+
+type Server struct {
+	authpb.UnimplementedAuthServiceServer
+	uc *logic.AuthUseCase
+}
+
+func NewServer(uc *logic.AuthUseCase) *Server {
+	return &Server{uc: uc}
+}
+
+func (s *Server) Login(ctx context.Context, in *authpb.LoginRequest) (*authpb.LoginReply, error) {
+	if in.Username == "" || in.Password == "" {
+		return nil, errs.Status(codes.InvalidArgument, errCredentialsRequired)
+	}
+	sess, err := s.uc.Login(ctx, in.Username, in.Password)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toReply(sess), nil
+}
+
+func (s *Server) RefreshToken(ctx context.Context, in *authpb.RefreshTokenRequest) (*authpb.LoginReply, error) {
+	if in.RefreshToken == "" {
+		return nil, errs.Status(codes.InvalidArgument, errRefreshTokenRequired)
+	}
+	sess, err := s.uc.Refresh(ctx, in.RefreshToken)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toReply(sess), nil
+}
+
+func (s *Server) Logout(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	tok := jwt.BearerToken(ctx)
+	if tok == "" {
+		return nil, errs.Status(codes.Unauthenticated, jwt.ErrMissing)
+	}
+	if err := s.uc.Logout(ctx, tok); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ChangePassword(ctx context.Context, in *authpb.ChangePasswordRequest) (*emptypb.Empty, error) {
+	if in.Username == "" || in.OldPassword == "" {
+		return nil, errs.Status(codes.InvalidArgument, errOldCredentialsRequired)
+	}
+	if err := s.uc.ChangePassword(ctx, in.Username, in.OldPassword, in.NewPassword); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func toReply(sess *logic.Session) *authpb.LoginReply {
+	r := &authpb.LoginReply{
+		UserId:      sess.UserID,
+		Username:    sess.Username,
+		AccessToken: sess.AccessToken,
+		ExpiresAt:   timestamppb.New(sess.ExpiresAt),
+	}
+	if sess.RefreshToken != "" {
+		r.RefreshToken = sess.RefreshToken
+		r.RefreshExpiresAt = timestamppb.New(sess.RefreshExpiresAt)
+	}
+	return r
+}
+
+// toStatus 把logic层错误转换为gRPC状态
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, logic.ErrInvalidCredentials), errors.Is(err, logic.ErrInvalidRefreshToken),
+		errors.Is(err, logic.ErrRefreshTokenExpired), errors.Is(err, logic.ErrRefreshTokenReused):
+		return errs.Status(codes.Unauthenticated, err)
+	case errors.Is(err, logic.ErrWeakPassword), errors.Is(err, logic.ErrPasswordTooLong):
+		return errs.Status(codes.InvalidArgument, err)
+	case errors.Is(err, logic.ErrEmailNotVerified):
+		return errs.Status(codes.FailedPrecondition, err)
+	case errors.Is(err, jwt.ErrUnavailable):
+		return errs.Status(codes.Unavailable, err)
+	case errors.Is(err, jwt.ErrInvalid), errors.Is(err, jwt.ErrExpired), errors.Is(err, jwt.ErrRevoked):
+		return errs.Status(codes.Unauthenticated, err)
+	default:
+		return errs.Status(codes.Internal, errs.ErrInternal)
+	}
+}
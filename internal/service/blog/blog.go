@@ -0,0 +1,107 @@
+// Package blog 实现blog.BlogService服务
+package blog
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/pkg/errs"
+	blogpb "github.com/Q1mi/greeter/proto/blog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errIDRequired = errs.New("request.id_required", "id is required")
+	// errNegativePageSize 与greeterv2使用相同的错误码
+	errNegativePageSize = errs.New("request.negative_page_size", "page_size must not be negative")
+)
+
+func init() {
+	srv := &Server{}
+	server.RegisterModule(server.Module{
+		Name: blogpb.BlogService_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			srv.uc = logic.NewBlogUseCase(app.DB)
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			blogpb.RegisterBlogServiceServer(s, srv)
+		},
+		RegisterGateway: blogpb.RegisterBlogServiceHandlerFromEndpoint,
+	})
+}
+
+type Server struct {
+	blogpb.UnimplementedBlogServiceServer
+	uc *logic.BlogUseCase
+}
+
+func NewServer(uc *logic.BlogUseCase) *Server {
+	return &Server{uc: uc}
+}
+
+func (s *Server) CreateBlog(ctx context.Context, in *blogpb.CreateBlogRequest) (*blogpb.Blog, error) {
+	b, err := s.uc.Create(ctx, in.Title, in.Content)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toPBBlog(b), nil
+}
+
+func (s *Server) GetBlog(ctx context.Context, in *blogpb.GetBlogRequest) (*blogpb.Blog, error) {
+	if in.Id <= 0 {
+		return nil, errs.Status(codes.InvalidArgument, errIDRequired)
+	}
+	b, err := s.uc.Get(ctx, in.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toPBBlog(b), nil
+}
+
+func (s *Server) ListBlogs(ctx context.Context, in *blogpb.ListBlogsRequest) (*blogpb.ListBlogsReply, error) {
+	if in.PageSize < 0 {
+		return nil, errs.Status(codes.InvalidArgument, errNegativePageSize)
+	}
+	list, next, err := s.uc.List(ctx, int(in.PageSize), in.PageToken, in.AuthorId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &blogpb.ListBlogsReply{NextPageToken: next}
+	for _, b := range list {
+		reply.Blogs = append(reply.Blogs, toPBBlog(b))
+	}
+	return reply, nil
+}
+
+func toPBBlog(b *model.Blog) *blogpb.Blog {
+	return &blogpb.Blog{
+		Id:         b.ID,
+		AuthorId:   b.AuthorID,
+		Title:      b.Title,
+		Content:    b.Content,
+		CreateTime: timestamppb.New(b.CreatedAt),
+		UpdateTime: timestamppb.New(b.UpdatedAt),
+	}
+}
+
+// toStatus 把logic层错误转换为gRPC状态
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, logic.ErrInvalidBlogTitle),
+		errors.Is(err, logic.ErrInvalidBlogContent),
+		errors.Is(err, logic.ErrInvalidPageToken):
+		return errs.Status(codes.InvalidArgument, err)
+	case errors.Is(err, logic.ErrBlogNotFound):
+		return errs.Status(codes.NotFound, err)
+	case errors.Is(err, logic.ErrBlogLoginRequired):
+		return errs.Status(codes.Unauthenticated, err)
+	default:
+		return errs.Status(codes.Internal, errs.ErrInternal)
+	}
+}
@@ -0,0 +1,163 @@
+// Package greeter 实现helloworld.Greeter服务
+package greeter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/Q1mi/greeter/internal/adapter/helloworldv1"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/internal/service/greeterv2"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/stats"
+	"github.com/Q1mi/greeter/pkg/validate"
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errChatNameRequired = errs.New("request.chat_name_required", "name is required")
+	errChatTextRequired = errs.New("request.chat_text_required", "text is required")
+	errChatTextTooLong  = errs.New("request.chat_text_too_long", "text must not exceed 1024 characters")
+	errNameRequired     = errs.New("request.name_required", "name is required")
+	errTooManyGreetings = errs.New("request.too_many_greetings", "at most 1000 greetings can be uploaded at once")
+)
+
+const (
+	// maxChatText 一条聊天消息的最大字符数
+	maxChatText = 1024
+	// maxUpload 一次UploadGreetings最多的请求数
+	maxUpload = 1000
+)
+
+func init() {
+	validate.Register(&helloworldpb.ChatMessage{}, func(m proto.Message) error {
+		msg := m.(*helloworldpb.ChatMessage)
+		switch {
+		case msg.GetName() == "":
+			return validate.Field("name", errChatNameRequired)
+		case msg.GetText() == "":
+			return validate.Field("text", errChatTextRequired)
+		case utf8.RuneCountInString(msg.GetText()) > maxChatText:
+			return validate.Field("text", errChatTextTooLong)
+		}
+		return nil
+	})
+	// SayHello允许name为空, 批量上传时每个请求都必须有name
+	validate.RegisterMethod("/helloworld.Greeter/UploadGreetings", func(m proto.Message) error {
+		if m.(*helloworldpb.HelloRequest).GetName() == "" {
+			return validate.Field("name", errNameRequired)
+		}
+		return nil
+	})
+	srv := &Server{}
+	server.RegisterModule(server.Module{
+		Name: helloworldpb.Greeter_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			// 业务逻辑由v2实现, v1通过适配层转换
+			uc, err := greeterv2.NewUseCase(app)
+			if err != nil {
+				return err
+			}
+			srv.v2 = helloworldv1.New(greeterv2.NewServer(uc))
+			srv.stats = app.Stats
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			helloworldpb.RegisterGreeterServer(s, srv)
+		},
+		RegisterGateway: helloworldpb.RegisterGreeterHandlerFromEndpoint,
+	})
+}
+
+type Server struct {
+	helloworldpb.UnimplementedGreeterServer
+	v2    *helloworldv1.Adapter
+	stats *stats.Aggregator
+}
+
+func NewServer(v2 *helloworldv1.Adapter, st *stats.Aggregator) *Server {
+	return &Server{v2: v2, stats: st}
+}
+
+func (s *Server) SayHello(ctx context.Context, in *helloworldpb.HelloRequest) (*helloworldpb.HelloReply, error) {
+	return s.v2.SayHello(ctx, in)
+}
+
+func (s *Server) ListGreetings(ctx context.Context, in *helloworldpb.ListGreetingsRequest) (*helloworldpb.ListGreetingsReply, error) {
+	return s.v2.ListGreetings(ctx, in)
+}
+
+func (s *Server) GetStats(ctx context.Context, in *helloworldpb.GetStatsRequest) (*helloworldpb.GetStatsReply, error) {
+	snap := s.stats.Snapshot()
+	reply := &helloworldpb.GetStatsReply{
+		GreetingsServed: snap.GreetingsServed,
+		UniqueNames:     snap.UniqueNames,
+		StartTime:       timestamppb.New(snap.StartTime),
+	}
+	for _, m := range snap.Methods {
+		reply.Methods = append(reply.Methods, &helloworldpb.MethodStats{
+			Method:          m.Method,
+			LastMinute:      m.Windows[0],
+			LastFiveMinutes: m.Windows[1],
+			LastHour:        m.Windows[2],
+			Total:           m.Total,
+		})
+	}
+	return reply, nil
+}
+
+// Chat 对收到的每条消息回复一条问候, 客户端关闭发送方向后结束. 消息已由校验拦截器检查
+func (s *Server) Chat(stream helloworldpb.Greeter_ChatServer) error {
+	for seq := int64(1); ; seq++ {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply := &helloworldpb.ChatMessage{
+			Name:       "greeter",
+			Text:       fmt.Sprintf("Hello %s, you said: %s", in.GetName(), in.GetText()),
+			Seq:        seq,
+			CreateTime: timestamppb.Now(),
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// UploadGreetings 对流中的每个请求调用SayHello, 客户端关闭发送方向后返回汇总. 出错时已完成的问候不回滚
+func (s *Server) UploadGreetings(stream helloworldpb.Greeter_UploadGreetingsServer) error {
+	ctx := stream.Context()
+	reply := &helloworldpb.UploadGreetingsReply{Locales: map[string]int32{}}
+	names := map[string]bool{}
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			reply.UniqueNames = int32(len(names))
+			return stream.SendAndClose(reply)
+		}
+		if err != nil {
+			return err
+		}
+		if reply.Count >= maxUpload {
+			return errs.Status(codes.InvalidArgument, errTooManyGreetings)
+		}
+		r, err := s.v2.SayHello(ctx, in)
+		if err != nil {
+			return err
+		}
+		reply.Count++
+		names[in.GetName()] = true
+		reply.Locales[r.GetLocale()]++
+		reply.GreetingIds = append(reply.GreetingIds, int64(r.GetObj().GetFields()["greeting_id"].GetNumberValue()))
+	}
+}
@@ -0,0 +1,72 @@
+package greeter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Q1mi/greeter/internal/adapter/helloworldv1"
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/internal/service/greeterv2"
+	"github.com/Q1mi/greeter/pkg/graphql"
+	"github.com/Q1mi/greeter/pkg/jsonrpc"
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	helloworldv2 "github.com/Q1mi/greeter/proto/helloworld/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestV1RegisteredBeforeV2(t *testing.T) {
+	// 本包import了greeterv2, 它的init先执行, 顺序由Module.Order决定
+	pos := map[string]int{}
+	for i, m := range server.Modules() {
+		pos[m.Name] = i
+	}
+	v1, ok1 := pos[helloworldpb.Greeter_ServiceDesc.ServiceName]
+	v2, ok2 := pos[helloworldv2.Greeter_ServiceDesc.ServiceName]
+	if !ok1 || !ok2 || v1 > v2 {
+		t.Fatalf("module positions v1=%d(%v) v2=%d(%v), want v1 first", v1, ok1, v2, ok2)
+	}
+}
+
+func TestUnqualifiedNamesBelongToV1(t *testing.T) {
+	// 拦截器记录实际调用的方法, 不进入handler
+	var called string
+	record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		called = info.FullMethod
+		return nil, status.Error(codes.Aborted, "recorded")
+	}
+	rpc := jsonrpc.NewServer(jsonrpc.WithUnaryInterceptor(record))
+	server.RegisterGRPC(rpc)
+	body := `{"jsonrpc":"2.0","method":"Greeter.SayHello","params":{"name":"q1mi"},"id":1}`
+	rpc.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	if called != "/helloworld.Greeter/SayHello" {
+		t.Errorf("JSON-RPC Greeter.SayHello called %q, want /helloworld.Greeter/SayHello", called)
+	}
+
+	gql := graphql.NewServer(graphql.WithUnaryInterceptor(record))
+	server.RegisterGRPC(gql)
+	called = ""
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{ listGreetings { nextPageToken } }`))
+	req.Header.Set("Content-Type", "application/graphql")
+	gql.ServeHTTP(httptest.NewRecorder(), req)
+	if called != "/helloworld.Greeter/ListGreetings" {
+		t.Errorf("GraphQL listGreetings called %q, want /helloworld.Greeter/ListGreetings", called)
+	}
+}
+
+func TestSayHelloTooLong(t *testing.T) {
+	uc, err := logic.NewGreetingUseCase(db.NewMemory(), map[string]string{"default": "Hello, {{.Name}}"}, nil, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(helloworldv1.New(greeterv2.NewServer(uc)), nil)
+	if _, err := s.SayHello(context.Background(), &helloworldpb.HelloRequest{Name: strings.Repeat("x", 2000)}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("err = %v, want InvalidArgument", err)
+	}
+}
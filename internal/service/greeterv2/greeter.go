@@ -0,0 +1,111 @@
+// Package greeterv2 实现grpc.greeter.helloworld.v2.Greeter服务
+package greeterv2
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/locale"
+	helloworldv2 "github.com/Q1mi/greeter/proto/helloworld/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var errNegativePageSize = errs.New("request.negative_page_size", "page_size must not be negative")
+
+func init() {
+	srv := &Server{}
+	server.RegisterModule(server.Module{
+		Name: helloworldv2.Greeter_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			uc, err := NewUseCase(app)
+			if err != nil {
+				return err
+			}
+			srv.uc = uc
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			helloworldv2.RegisterGreeterServer(s, srv)
+		},
+		RegisterGateway: helloworldv2.RegisterGreeterHandlerFromEndpoint,
+		// 排在v1之后, JSON-RPC的Greeter.SayHello和GraphQL的sayHello等名称仍然属于v1
+		Order: 1,
+	})
+}
+
+// NewUseCase 根据greeting配置创建GreetingUseCase, v1的兼容层也通过它创建实现
+func NewUseCase(app *server.App) (*logic.GreetingUseCase, error) {
+	c := app.Conf.Greeting
+	uc, err := logic.NewGreetingUseCase(app.DB, c.Templates, c.Locales, c.DefaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if c.DefaultLocale != "" {
+		uc.DefaultLocale = c.DefaultLocale
+	}
+	uc.Recorder = app.Stats
+	return uc, nil
+}
+
+type Server struct {
+	helloworldv2.UnimplementedGreeterServer
+	uc *logic.GreetingUseCase
+}
+
+func NewServer(uc *logic.GreetingUseCase) *Server {
+	return &Server{uc: uc}
+}
+
+func (s *Server) SayHello(ctx context.Context, in *helloworldv2.HelloRequest) (*helloworldv2.HelloReply, error) {
+	locales := append(append([]string(nil), in.Locales...), locale.FromIncomingContext(ctx)...)
+	g, err := s.uc.Greet(ctx, in.Name, in.TemplateId, locales)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &helloworldv2.HelloReply{Greeting: toPB(g), CandidateLocales: locales}, nil
+}
+
+func (s *Server) ListGreetings(ctx context.Context, in *helloworldv2.ListGreetingsRequest) (*helloworldv2.ListGreetingsReply, error) {
+	if in.PageSize < 0 {
+		return nil, errs.Status(codes.InvalidArgument, errNegativePageSize)
+	}
+	list, next, err := s.uc.ListGreetings(ctx, int(in.PageSize), in.PageToken)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &helloworldv2.ListGreetingsReply{NextPageToken: next}
+	for _, g := range list {
+		reply.Greetings = append(reply.Greetings, toPB(g))
+	}
+	return reply, nil
+}
+
+func toPB(g *model.Greeting) *helloworldv2.Greeting {
+	return &helloworldv2.Greeting{
+		Id:         g.ID,
+		Name:       g.Name,
+		Message:    g.Message,
+		Locale:     g.Locale,
+		TemplateId: g.TemplateID,
+		TimeOfDay:  logic.TimeOfDay(g.CreatedAt),
+		Caller:     g.Caller,
+		CreateTime: timestamppb.New(g.CreatedAt),
+	}
+}
+
+// toStatus 把logic层错误转换为gRPC状态
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, logic.ErrTemplateNotFound), errors.Is(err, logic.ErrInvalidPageToken), errors.Is(err, logic.ErrGreetingTooLong):
+		return errs.Status(codes.InvalidArgument, err)
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
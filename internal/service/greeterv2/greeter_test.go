@@ -0,0 +1,25 @@
+package greeterv2
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/errs"
+	helloworldv2 "github.com/Q1mi/greeter/proto/helloworld/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSayHelloTooLong(t *testing.T) {
+	uc, err := logic.NewGreetingUseCase(db.NewMemory(), map[string]string{"default": "Hello, {{.Name}}"}, nil, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewServer(uc).SayHello(context.Background(), &helloworldv2.HelloRequest{Name: strings.Repeat("x", 2000)})
+	if status.Code(err) != codes.InvalidArgument || errs.CodeOf(err) != "greeting.too_long" {
+		t.Fatalf("err = %v (code %s), want InvalidArgument greeting.too_long", err, errs.CodeOf(err))
+	}
+}
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/pkg/kafka"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// CommandImport 批量导入用户的异步命令
+const CommandImport = "user.import"
+
+// importCommand user.import消息的内容
+type importCommand struct {
+	Users []logic.ImportUser `json:"users"`
+}
+
+// importHandler 处理user.import命令. 格式错误的用户只记录日志, 存储出错时返回错误由消费者重试
+func importHandler(uc *logic.UserUseCase) kafka.Handler {
+	return func(ctx context.Context, m *kafka.Message) error {
+		var cmd importCommand
+		if err := json.Unmarshal(m.Value, &cmd); err != nil {
+			return fmt.Errorf("decode %s: %w", CommandImport, err)
+		}
+		res, err := uc.Import(ctx, cmd.Users)
+		if err != nil {
+			return err
+		}
+		l := zaplog.FromContext(ctx)
+		for name, err := range res.Invalid {
+			l.Warn("user import: invalid user", zaplog.String("username", name), zaplog.Error(err))
+		}
+		l.Info("user import finished", zaplog.Int("created", res.Created),
+			zaplog.Int("skipped", res.Skipped), zaplog.Int("invalid", len(res.Invalid)))
+		return nil
+	}
+}
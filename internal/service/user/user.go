@@ -0,0 +1,238 @@
+// Package user 实现user.UserService服务
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/server"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/httpclient"
+	"github.com/Q1mi/greeter/pkg/validate"
+	userpb "github.com/Q1mi/greeter/proto/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errIDRequired    = errs.New("request.id_required", "id is required")
+	errTokenRequired = errs.New("request.token_required", "token is required")
+	// errNegativePageSize 与greeterv2使用相同的错误码
+	errNegativePageSize = errs.New("request.negative_page_size", "page_size must not be negative")
+)
+
+func init() {
+	validate.Register(&userpb.CreateUserRequest{}, func(m proto.Message) error {
+		in := m.(*userpb.CreateUserRequest)
+		if err := logic.CheckEmail(in.Email); err != nil {
+			return validate.Field("email", err)
+		}
+		if err := logic.CheckPhone(in.Phone); err != nil {
+			return validate.Field("phone", err)
+		}
+		if err := logic.CheckAccess(in.Roles); err != nil {
+			return validate.Field("roles", err)
+		}
+		if err := logic.CheckAccess(in.Scopes); err != nil {
+			return validate.Field("scopes", err)
+		}
+		return nil
+	})
+	validate.Register(&userpb.UpdateUserRequest{}, func(m proto.Message) error {
+		in := m.(*userpb.UpdateUserRequest)
+		if in.Id <= 0 {
+			return validate.Field("id", errIDRequired)
+		}
+		if in.Email != nil {
+			if err := logic.CheckEmail(in.GetEmail()); err != nil {
+				return validate.Field("email", err)
+			}
+		}
+		if err := logic.CheckPhone(in.GetPhone()); err != nil {
+			return validate.Field("phone", err)
+		}
+		if err := logic.CheckAccess(in.GetAccess().GetRoles()); err != nil {
+			return validate.Field("access.roles", err)
+		}
+		if err := logic.CheckAccess(in.GetAccess().GetScopes()); err != nil {
+			return validate.Field("access.scopes", err)
+		}
+		return nil
+	})
+	srv := &Server{}
+	server.RegisterModule(server.Module{
+		Name: userpb.UserService_ServiceDesc.ServiceName,
+		Init: func(ctx context.Context, app *server.App) error {
+			auth, err := logic.NewAuthUseCase(app.DB, app.Passwd, app.JWT, app.Conf.Auth.AccessTTL.D())
+			if err != nil {
+				return err
+			}
+			uc := logic.NewUserUseCase(app.DB, auth, app.Signer, app.Notifier, app.Mail)
+			uc.VerifyTTL = app.Conf.Auth.VerifyTTL.D()
+			uc.VerifyURL = app.Conf.Auth.VerifyURL
+			if c := app.Conf.ProfileAPI; c.URL != "" {
+				client, err := httpclient.New("profile_api", c.Client)
+				if err != nil {
+					return err
+				}
+				uc.Profiles = logic.NewProfileAPI(c.URL, client)
+			}
+			srv.uc = uc
+			if app.Commands != nil {
+				app.Commands.Handle(CommandImport, importHandler(uc))
+			}
+			return nil
+		},
+		RegisterGRPC: func(s grpc.ServiceRegistrar) {
+			userpb.RegisterUserServiceServer(s, srv)
+		},
+		RegisterGateway: userpb.RegisterUserServiceHandlerFromEndpoint,
+	})
+}
+
+type Server struct {
+	userpb.UnimplementedUserServiceServer
+	uc *logic.UserUseCase
+}
+
+func NewServer(uc *logic.UserUseCase) *Server {
+	return &Server{uc: uc}
+}
+
+func (s *Server) RegisterUser(ctx context.Context, in *userpb.RegisterUserRequest) (*userpb.RegisterUserReply, error) {
+	u, err := s.uc.Register(ctx, in.Username, in.Email, in.Password)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.RegisterUserReply{UserId: u.ID, Status: toPBStatus(u.Status)}, nil
+}
+
+func (s *Server) GetUser(ctx context.Context, in *userpb.GetUserRequest) (*userpb.GetUserReply, error) {
+	if in.Id <= 0 {
+		return nil, errs.Status(codes.InvalidArgument, errIDRequired)
+	}
+	u, p, err := s.uc.GetWithProfile(ctx, in.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &userpb.GetUserReply{UserId: u.ID, Username: u.Username, Status: toPBStatus(u.Status)}
+	if p != nil {
+		reply.Profile = &userpb.UserProfile{DisplayName: p.DisplayName, AvatarUrl: p.AvatarURL, Bio: p.Bio}
+	}
+	return reply, nil
+}
+
+func (s *Server) VerifyEmail(ctx context.Context, in *userpb.VerifyEmailRequest) (*userpb.VerifyEmailReply, error) {
+	if in.Token == "" {
+		return nil, errs.Status(codes.InvalidArgument, errTokenRequired)
+	}
+	u, err := s.uc.VerifyEmail(ctx, in.Token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.VerifyEmailReply{UserId: u.ID, Status: toPBStatus(u.Status)}, nil
+}
+
+func (s *Server) ListUsers(ctx context.Context, in *userpb.ListUsersRequest) (*userpb.ListUsersReply, error) {
+	if in.PageSize < 0 {
+		return nil, errs.Status(codes.InvalidArgument, errNegativePageSize)
+	}
+	list, next, err := s.uc.List(ctx, int(in.PageSize), in.PageToken, in.OrderBy, in.Filter)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &userpb.ListUsersReply{NextPageToken: next}
+	for _, u := range list {
+		reply.Users = append(reply.Users, toPBUser(u))
+	}
+	return reply, nil
+}
+
+func (s *Server) CreateUser(ctx context.Context, in *userpb.CreateUserRequest) (*userpb.CreateUserReply, error) {
+	u, err := s.uc.Create(ctx, &model.User{
+		Username: in.Username,
+		Email:    in.Email,
+		Phone:    in.Phone,
+		Roles:    in.Roles,
+		Scopes:   in.Scopes,
+	}, in.Password)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.CreateUserReply{User: toPBUser(u)}, nil
+}
+
+func (s *Server) UpdateUser(ctx context.Context, in *userpb.UpdateUserRequest) (*userpb.UpdateUserReply, error) {
+	upd := logic.UserUpdate{Email: in.Email, Phone: in.Phone}
+	if a := in.Access; a != nil {
+		upd.Access = &logic.UserAccess{Roles: a.Roles, Scopes: a.Scopes}
+	}
+	u, err := s.uc.Update(ctx, in.Id, upd)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.UpdateUserReply{User: toPBUser(u)}, nil
+}
+
+func (s *Server) DeleteUser(ctx context.Context, in *userpb.DeleteUserRequest) (*userpb.DeleteUserReply, error) {
+	if in.Id <= 0 {
+		return nil, errs.Status(codes.InvalidArgument, errIDRequired)
+	}
+	if err := s.uc.Delete(ctx, in.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.DeleteUserReply{}, nil
+}
+
+func toPBUser(u *model.User) *userpb.User {
+	return &userpb.User{
+		UserId:     u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		Phone:      u.Phone,
+		Status:     toPBStatus(u.Status),
+		CreateTime: timestamppb.New(u.CreatedAt),
+		UpdateTime: timestamppb.New(u.UpdatedAt),
+		Roles:      u.Roles,
+		Scopes:     u.Scopes,
+	}
+}
+
+func toPBStatus(st model.UserStatus) userpb.UserStatus {
+	switch st {
+	case model.UserPending:
+		return userpb.UserStatus_USER_STATUS_PENDING
+	case model.UserActive:
+		return userpb.UserStatus_USER_STATUS_ACTIVE
+	}
+	return userpb.UserStatus_USER_STATUS_UNSPECIFIED
+}
+
+// toStatus 把logic层错误转换为gRPC状态
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, logic.ErrInvalidUsername),
+		errors.Is(err, logic.ErrInvalidEmail),
+		errors.Is(err, logic.ErrInvalidPhone),
+		errors.Is(err, logic.ErrInvalidAccess),
+		errors.Is(err, logic.ErrInvalidFilter),
+		errors.Is(err, logic.ErrInvalidOrderBy),
+		errors.Is(err, logic.ErrInvalidPageToken),
+		errors.Is(err, logic.ErrWeakPassword),
+		errors.Is(err, logic.ErrPasswordTooLong),
+		errors.Is(err, logic.ErrInvalidToken):
+		return errs.Status(codes.InvalidArgument, err)
+	case errors.Is(err, logic.ErrUserExists):
+		return errs.Status(codes.AlreadyExists, err)
+	case errors.Is(err, logic.ErrUserNotFound):
+		return errs.Status(codes.NotFound, err)
+	case errors.Is(err, logic.ErrUserForbidden):
+		return errs.Status(codes.PermissionDenied, err)
+	default:
+		return errs.Status(codes.Internal, errs.ErrInternal)
+	}
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/logic"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/passwd"
+	userpb "github.com/Q1mi/greeter/proto/user"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	hasher, err := passwd.New(passwd.Params{Algorithm: passwd.PBKDF2, Iterations: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := db.NewMemory()
+	auth, err := logic.NewAuthUseCase(reg, hasher, jwt.NewSigner([]byte("test secret"), "", nil), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(logic.NewUserUseCase(reg, auth, nil, nil, nil))
+}
+
+func asAdmin(ctx context.Context) context.Context {
+	return ctxutil.WithClaims(ctx, &ctxutil.Claims{UserID: 1000, Username: "root", Roles: []string{logic.RoleAdmin}})
+}
+
+func TestCreateUser(t *testing.T) {
+	s := newTestServer(t)
+	in := &userpb.CreateUserRequest{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "correct horse battery",
+		Roles:    []string{"ops"},
+		Scopes:   []string{"greeter:write"},
+	}
+	plain := ctxutil.WithClaims(context.Background(), &ctxutil.Claims{UserID: 1, Username: "bob"})
+	if _, err := s.CreateUser(plain, in); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("CreateUser as plain user: %v, want PermissionDenied", err)
+	}
+	reply, err := s.CreateUser(asAdmin(context.Background()), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := reply.User
+	if u.Status != userpb.UserStatus_USER_STATUS_ACTIVE || strings.Join(u.Roles, ",") != "ops" || strings.Join(u.Scopes, ",") != "greeter:write" {
+		t.Fatalf("created user = %v", u)
+	}
+	if _, err := s.CreateUser(asAdmin(context.Background()), in); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("CreateUser duplicate: %v, want AlreadyExists", err)
+	}
+}
+
+// routeServer 记录gateway把请求转给了哪个方法
+type routeServer struct {
+	userpb.UnimplementedUserServiceServer
+	called string
+}
+
+func (s *routeServer) RegisterUser(context.Context, *userpb.RegisterUserRequest) (*userpb.RegisterUserReply, error) {
+	s.called = "RegisterUser"
+	return &userpb.RegisterUserReply{}, nil
+}
+
+func (s *routeServer) CreateUser(context.Context, *userpb.CreateUserRequest) (*userpb.CreateUserReply, error) {
+	s.called = "CreateUser"
+	return &userpb.CreateUserReply{}, nil
+}
+
+func (s *routeServer) ListUsers(context.Context, *userpb.ListUsersRequest) (*userpb.ListUsersReply, error) {
+	s.called = "ListUsers"
+	return &userpb.ListUsersReply{}, nil
+}
+
+func (s *routeServer) GetUser(context.Context, *userpb.GetUserRequest) (*userpb.GetUserReply, error) {
+	s.called = "GetUser"
+	return &userpb.GetUserReply{}, nil
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	srv := &routeServer{}
+	mux := runtime.NewServeMux()
+	if err := userpb.RegisterUserServiceHandlerServer(context.Background(), mux, srv); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodPost, "/v1/users", "CreateUser"},
+		{http.MethodPost, "/v1/users/register", "RegisterUser"},
+		{http.MethodGet, "/v1/users", "ListUsers"},
+		{http.MethodGet, "/v1/users/1", "GetUser"},
+	}
+	for _, tt := range tests {
+		srv.called = ""
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+		if rec.Code != http.StatusOK || srv.called != tt.want {
+			t.Errorf("%s %s: status %d, called %q, want %s", tt.method, tt.path, rec.Code, srv.called, tt.want)
+		}
+	}
+}
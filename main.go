@@ -2,70 +2,1047 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	"github.com/Q1mi/greeter/internal/model"
+	"github.com/Q1mi/greeter/internal/repo/cached"
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/internal/repo/failover"
+	"github.com/Q1mi/greeter/internal/server"
+	_ "github.com/Q1mi/greeter/internal/service/admin"
+	_ "github.com/Q1mi/greeter/internal/service/auth"
+	_ "github.com/Q1mi/greeter/internal/service/blog"
+	_ "github.com/Q1mi/greeter/internal/service/greeter"
+	_ "github.com/Q1mi/greeter/internal/service/greeterv2"
+	_ "github.com/Q1mi/greeter/internal/service/user"
+	"github.com/Q1mi/greeter/pkg/apikey"
+	"github.com/Q1mi/greeter/pkg/audit"
+	"github.com/Q1mi/greeter/pkg/authz"
+	"github.com/Q1mi/greeter/pkg/cache"
+	"github.com/Q1mi/greeter/pkg/canary"
+	"github.com/Q1mi/greeter/pkg/client"
+	"github.com/Q1mi/greeter/pkg/compress"
+	"github.com/Q1mi/greeter/pkg/config"
+	"github.com/Q1mi/greeter/pkg/cors"
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/deprecation"
+	"github.com/Q1mi/greeter/pkg/drain"
+	"github.com/Q1mi/greeter/pkg/errreport"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/gctune"
+	"github.com/Q1mi/greeter/pkg/graphql"
+	"github.com/Q1mi/greeter/pkg/gwerrors"
+	"github.com/Q1mi/greeter/pkg/health"
+	"github.com/Q1mi/greeter/pkg/jsonrpc"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/kafka"
+	"github.com/Q1mi/greeter/pkg/leader"
+	"github.com/Q1mi/greeter/pkg/lifecycle"
+	"github.com/Q1mi/greeter/pkg/listener"
+	"github.com/Q1mi/greeter/pkg/logship"
+	"github.com/Q1mi/greeter/pkg/mailtmpl"
+	"github.com/Q1mi/greeter/pkg/metering"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/mock"
+	"github.com/Q1mi/greeter/pkg/notify"
+	"github.com/Q1mi/greeter/pkg/passwd"
+	"github.com/Q1mi/greeter/pkg/profiling"
+	"github.com/Q1mi/greeter/pkg/recorder"
+	"github.com/Q1mi/greeter/pkg/redis"
+	"github.com/Q1mi/greeter/pkg/scheduler"
+	"github.com/Q1mi/greeter/pkg/shadow"
+	"github.com/Q1mi/greeter/pkg/slo"
+	"github.com/Q1mi/greeter/pkg/stats"
+	"github.com/Q1mi/greeter/pkg/swagger"
+	"github.com/Q1mi/greeter/pkg/tags"
+	"github.com/Q1mi/greeter/pkg/token"
+	"github.com/Q1mi/greeter/pkg/tracing"
+	"github.com/Q1mi/greeter/pkg/trailers"
+	"github.com/Q1mi/greeter/pkg/validate"
+	"github.com/Q1mi/greeter/pkg/workerpool"
+	"github.com/Q1mi/greeter/pkg/zaplog"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime" // 注意v2版本
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	// 注册gzip压缩, 客户端使用grpc.UseCompressor("gzip")时服务端解压请求并以gzip返回响应
+	_ "google.golang.org/grpc/encoding/gzip"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
-type server struct {
-	helloworldpb.UnimplementedGreeterServer
-}
+var confPath = flag.String("conf", "", "配置文件路径, 为空时使用默认配置")
 
-func NewServer() *server {
-	return &server{}
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	os.Exit(runCommand(flag.Args()))
 }
 
-func (s *server) SayHello(ctx context.Context, in *helloworldpb.HelloRequest) (*helloworldpb.HelloReply, error) {
-	return &helloworldpb.HelloReply{Message: in.Name + " world"}, nil
+// serveMain 实现serve子命令, 没有子命令时同样启动服务
+//
+//	greeter -conf conf/config.json serve
+func serveMain(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	confFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: greeter serve [-conf file]")
+		return 2
+	}
+	serve()
+	return 0
 }
 
-func main() {
-	// Create a listener on TCP port
-	lis, err := net.Listen("tcp", ":8091")
+// serve 按配置启动服务, 收到退出信号并停止后由stopOnSignal退出进程, 不会返回
+func serve() {
+	conf, err := config.Load(*confPath)
+	if err != nil {
+		log.Fatalln("Failed to load config:", err)
+	}
+
+	level, err := zaplog.ParseLevel(conf.Log.Level)
+	if err != nil {
+		log.Fatalln("Failed to parse log level:", err)
+	}
+	// 后台组件按启动的相反顺序停止, 日志发送最先启动, 最后停止
+	lc := lifecycle.New()
+	logOpts := []zaplog.Option{zaplog.WithSampling(conf.Log.Sampling), zaplog.WithEncoding(conf.Log.Encoding)}
+	var logOut []io.Writer
+	for _, o := range conf.Log.Outputs {
+		w, err := zaplog.Open(o)
+		if err != nil {
+			log.Fatalln("Failed to open log output:", err)
+		}
+		logOut = append(logOut, w)
+	}
+	if p := conf.Log.ErrorOutput; p != "" {
+		w, err := zaplog.Open(p)
+		if err != nil {
+			log.Fatalln("Failed to open log.error_output:", err)
+		}
+		logOpts = append(logOpts, zaplog.WithOutput(w, zaplog.ErrorLevel))
+	}
+	if conf.Log.Ship.Type != logship.TypeNone {
+		shipLevel := level
+		if conf.Log.Ship.Level != "" {
+			if shipLevel, err = zaplog.ParseLevel(conf.Log.Ship.Level); err != nil {
+				log.Fatalln("Failed to parse log.ship.level:", err)
+			}
+		}
+		shipper, err := logship.New(conf.Log.Ship)
+		if err != nil {
+			log.Fatalln("Failed to create log shipper:", err)
+		}
+		lc.Go("log_shipper", shipper.Run)
+		logOpts = append(logOpts, zaplog.WithTee(shipper, shipLevel))
+	}
+	if conf.Log.Report.Type != errreport.TypeNone {
+		reporter, err := errreport.New(conf.Log.Report)
+		if err != nil {
+			log.Fatalln("Failed to create error reporter:", err)
+		}
+		lc.Go("error_reporter", reporter.Run)
+		logOpts = append(logOpts, zaplog.WithHook(reporter, reporter.Level()))
+	}
+	logger, err := zaplog.New(io.MultiWriter(logOut...), level, logOpts...)
+	if err != nil {
+		log.Fatalln("Failed to create logger:", err)
+	}
+	zaplog.ReplaceGlobals(logger)
+	if conf.Secrets.Vault.Addr != "" {
+		lc.Go("vault_token_renewer", config.RenewSecrets)
+	}
+	// 配置文件中的API key, 重新加载配置时替换
+	apiKeys := apikey.NewStatic(conf.Auth.APIKey.Keys)
+	if conf.ReloadInterval > 0 && *confPath != "" {
+		w := config.NewWatcher(*confPath, conf, conf.ReloadInterval.D())
+		w.OnChange("log.level", func(c *config.Config) {
+			// Load已校验过级别
+			l, _ := zaplog.ParseLevel(c.Log.Level)
+			logger.SetLevel(l)
+		})
+		w.OnChange("auth.api_key.keys", func(c *config.Config) { apiKeys.Replace(c.Auth.APIKey.Keys) })
+		lc.Go("config_watcher", w.Run)
+	}
+	if err := tracing.Setup(conf.Tracing, logger); err != nil {
+		log.Fatalln("Failed to set up tracing:", err)
+	}
+
+	// 尽早调整GC参数, 之后的分配都按新参数管理
+	gc, err := gctune.Apply(conf.GC)
+	if err != nil {
+		log.Fatalln("Failed to apply gc config:", err)
+	}
+	log.Println("GC settings:", gc)
+
+	// 加载外部服务插件, 需在注册服务之前完成
+	if err := server.LoadPlugins(conf.Server.Plugins); err != nil {
+		log.Fatalln("Failed to load plugins:", err)
+	}
+
+	// 初始化服务模块依赖
+	hasher, err := passwd.New(conf.Password)
+	if err != nil {
+		log.Fatalln("Failed to create password hasher:", err)
+	}
+	secret := []byte(conf.Auth.Secret)
+	if len(secret) == 0 {
+		log.Println("auth.secret is not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalln("Failed to generate secret:", err)
+		}
+	}
+	pool := workerpool.New(conf.WorkerPool.Workers, conf.WorkerPool.QueueSize)
+	sender, err := notify.New(conf.Notify)
+	if err != nil {
+		log.Fatalln("Failed to create notifier:", err)
+	}
+	notifier, err := notify.NewDispatcher(sender, pool, conf.Notify)
+	if err != nil {
+		log.Fatalln("Failed to create notifier:", err)
+	}
+	st, err := stats.New(conf.Stats.File)
+	if err != nil {
+		log.Fatalln("Failed to load stats:", err)
+	}
+	lc.Go("stats", func(ctx context.Context) { st.Run(ctx, conf.Stats.PersistInterval.D()) })
+	mail, err := mailtmpl.New(conf.Mail)
+	if err != nil {
+		log.Fatalln("Failed to load mail templates:", err)
+	}
+	tracker, err := slo.New(conf.SLO)
+	if err != nil {
+		log.Fatalln("Failed to create slo tracker:", err)
+	}
+	if len(conf.SLO.AlertEmails) > 0 {
+		tracker.OnAlert(sloAlertMailer(mail, notifier, conf.SLO.AlertEmails))
+	}
+	lc.Go("slo_tracker", func(ctx context.Context) { tracker.Run(ctx, 0) })
+	dbPool := db.Pool{
+		MaxOpenConns:    conf.DB.MaxOpenConns,
+		MaxIdleConns:    conf.DB.MaxIdleConns,
+		ConnMaxLifetime: conf.DB.ConnMaxLifetime.D(),
+		ConnMaxIdleTime: conf.DB.ConnMaxIdleTime.D(),
+		QueryTimeout:    conf.DB.QueryTimeout.D(),
+		Migrate:         conf.DB.AutoMigrate,
+	}
+	reg, err := db.Open(conf.DB.OpenDSN(conf.DB.DSN), dbPool)
+	if err != nil {
+		log.Fatalln("Failed to open database:", err)
+	}
+	lc.Go("db_pool_stats", func(ctx context.Context) { db.ReportPoolStats(ctx, reg, "primary", conf.DB.StatsInterval.D()) })
+	var standby db.Registry
+	if c := conf.DB; c.StandbyDSN != "" {
+		// 备库通常只读, 不在备库上建表
+		dbPool.Migrate = false
+		if standby, err = db.Open(c.OpenDSN(c.StandbyDSN), dbPool); err != nil {
+			log.Fatalln("Failed to open standby database:", err)
+		}
+		lc.Go("standby_pool_stats", func(ctx context.Context) { db.ReportPoolStats(ctx, standby, "standby", c.StatsInterval.D()) })
+		fo := failover.New(reg, standby, failover.Config{
+			FailureThreshold:  c.FailureThreshold,
+			RecoveryThreshold: c.RecoveryThreshold,
+			ProbeInterval:     c.ProbeInterval.D(),
+			WriteToStandby:    c.WriteToStandby,
+		})
+		lc.Go("db_failover", fo.Run)
+		reg = fo
+	}
+	var rc *redis.Client
+	if conf.Leader.Backend == config.LeaderRedis || conf.Auth.JWT.Revocation == jwt.RevocationRedis ||
+		(conf.Cache.TTL > 0 && (conf.Cache.Backend == config.CacheRedis || conf.Redis.Addr != "")) {
+		if rc, err = redis.New(conf.Redis); err != nil {
+			log.Fatalln("Failed to create redis client:", err)
+		}
+	}
+	if conf.Cache.TTL > 0 {
+		var c cache.Cache = cache.NewMemory(conf.Cache.MaxEntries)
+		var bus cache.Bus = cache.NewLocalBus()
+		switch {
+		case conf.Cache.Backend == config.CacheRedis:
+			// 各实例共享Redis中的缓存, 删除即对所有实例生效, 失效通知只需在进程内传递
+			c = cache.NewRedis(rc, conf.Cache.Prefix)
+		case rc != nil:
+			// 各实例使用进程内缓存, 通过Redis的pub/sub通知其他实例清除
+			rb := cache.NewRedisBus(rc, conf.Cache.Prefix+"invalidations")
+			lc.Go("cache_bus", rb.Run)
+			bus = rb
+		}
+		reg = cached.NewRegistry(reg, cache.WithTracing("user", cache.WithMetrics("repo_user", c)), bus, conf.Cache.TTL.D())
+	}
+	engine, err := authz.New(context.Background(), conf.Authz, policyRules{reg.Policies()})
 	if err != nil {
-		log.Fatalln("Failed to listen:", err)
+		log.Fatalln("Failed to create authorizer:", err)
+	}
+	if c, ok := engine.(*authz.Casbin); ok {
+		lc.Go("casbin_reloader", c.Run)
 	}
+	var revoked jwt.RevocationStore = jwt.NewMemoryRevocations()
+	if conf.Auth.JWT.Revocation == jwt.RevocationRedis {
+		revoked = jwt.NewRedisRevocations(rc, "greeter:revoked:")
+	}
+	app := &server.App{
+		Conf:     conf,
+		DB:       reg,
+		Passwd:   hasher,
+		Signer:   token.NewSigner(secret),
+		JWT:      jwt.NewSigner(secret, conf.Auth.JWT.Issuer, revoked),
+		Pool:     pool,
+		Notifier: notifier,
+		Mail:     mail,
+		Stats:    st,
+		Health:   health.NewRegistry(),
+		Authz:    engine,
+	}
+	app.Health.Add("db", func(ctx context.Context) error {
+		// 不存在的ID, 只要能正常返回ErrNotFound即认为可用
+		if _, err := reg.Users().Get(ctx, 0); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	if standby != nil {
+		app.Health.Add("db_standby", func(ctx context.Context) error {
+			return failover.Probe(ctx, standby)
+		})
+	}
+	if conf.Notify.Provider == notify.ProviderSMTP {
+		addr := net.JoinHostPort(conf.Notify.SMTP.Host, strconv.Itoa(conf.Notify.SMTP.Port))
+		app.Health.Add("smtp", func(ctx context.Context) error {
+			var d net.Dialer
+			c, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		})
+	}
+	if conf.Tracing.Enabled && conf.Tracing.Exporter == tracing.ExporterOTLP {
+		app.Health.Add("tracing", conf.Tracing.OTLP.Probe)
+	}
+	if rc != nil {
+		app.Health.Add("redis", func(ctx context.Context) error {
+			_, err := rc.Do(ctx, "PING")
+			return err
+		})
+	}
+	app.Elector = newElector(conf, rc)
+	app.Scheduler = scheduler.New(app.Elector)
+	if k := conf.Kafka; len(k.Brokers) > 0 && k.Consumer.Topic != "" {
+		kc, err := kafka.NewClient(k)
+		if err != nil {
+			log.Fatalln("Failed to create kafka client:", err)
+		}
+		if app.Commands, err = kafka.NewConsumer(kc, k.Consumer, app.Elector); err != nil {
+			log.Fatalln("Failed to create kafka consumer:", err)
+		}
+	}
+	if err := server.Init(context.Background(), app); err != nil {
+		log.Fatalln("Failed to init modules:", err)
+	}
+	// 模块在Init中注册命令和定时任务, 之后开始选主、消费和调度. 停止时按相反顺序, 最后由选主器释放leader锁
+	lc.Go("leader_elector", app.Elector.Run)
+	if app.Commands != nil {
+		lc.Go("kafka_consumer", app.Commands.Run)
+	}
+	lc.Go("scheduler", app.Scheduler.Run)
 
-	// 创建一个gRPC server对象
-	s := grpc.NewServer()
-	// 注册Greeter service到server
-	helloworldpb.RegisterGreeterServer(s, &server{})
+	catalog, err := errs.NewCatalog(conf.Errors)
+	if err != nil {
+		log.Fatalln("Failed to load error messages:", err)
+	}
+	lc.Go("error_catalog", catalog.Run)
 
-	// gRPC-Gateway mux
-	gwmux := runtime.NewServeMux()
-	dops := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	err = helloworldpb.RegisterGreeterHandlerFromEndpoint(context.Background(), gwmux, "127.0.0.1:8091", dops)
+	// 所有进程内调用共用的拦截器
+	extractor, err := tags.New(conf.Tags)
 	if err != nil {
-		log.Fatalln("Failed to register gwmux:", err)
+		log.Fatalln("Failed to create request tag extractor:", err)
+	}
+	unary := []grpc.UnaryServerInterceptor{
+		// 请求标签最先提取, 之后的日志拦截器才能带上
+		extractor.UnaryServerInterceptor(),
+		zaplog.UnaryServerInterceptor(logger),
+		// 错误带上请求ID, 在Catalog外层, 替换消息后的错误同样带上
+		errs.RequestInfoInterceptor(),
+		tracing.UnaryServerInterceptor(),
+		catalog.UnaryServerInterceptor(),
+	}
+	// 流式调用(如Chat、健康检查的Watch)只有gRPC服务支持, 不经过按单条请求处理的标签、访问日志、计量、复制、记录和模拟
+	stream := []grpc.StreamServerInterceptor{
+		zaplog.StreamServerInterceptor(logger),
+		errs.RequestInfoStreamInterceptor(),
+		tracing.StreamServerInterceptor(),
+		catalog.StreamServerInterceptor(),
+	}
+	if conf.Log.Access {
+		unary = append(unary, zaplog.AccessLogInterceptor(conf.Log.AccessLog))
+	}
+	unary = append(unary, tracker.UnaryServerInterceptor(), st.UnaryServerInterceptor())
+	if len(conf.Deprecation.Methods) > 0 {
+		dep, err := deprecation.New(conf.Deprecation)
+		if err != nil {
+			log.Fatalln("Failed to create deprecation notifier:", err)
+		}
+		unary = append(unary, dep.UnaryServerInterceptor())
+	}
+	if c := conf.Auth.APIKey; c.Enabled {
+		// 在用户认证之前, 未接入的应用不需要校验token
+		keys := apikey.New(c, apiKeys)
+		unary = append(unary, keys.UnaryServerInterceptor())
+		stream = append(stream, keys.StreamServerInterceptor())
+	}
+	if conf.Auth.JWT.Enabled {
+		// 在授权之前, 授权按认证得到的Claims决策
+		unary = append(unary, jwt.UnaryServerInterceptor(app.JWT, conf.Auth.JWT.Exempt))
+		stream = append(stream, jwt.StreamServerInterceptor(app.JWT, conf.Auth.JWT.Exempt))
+	}
+	if a := conf.Audit; a.Enabled {
+		// 在认证之后、授权之前, 记录调用者身份和被拒绝的调用
+		var sink audit.Sink = auditEvents{app.DB.AuditEvents()}
+		if a.Sink == audit.SinkFile {
+			f, err := audit.OpenFile(a.File)
+			if err != nil {
+				log.Fatalln("Failed to open audit file:", err)
+			}
+			// serve不会返回, 在停止时关闭文件; 停止时服务已不再处理请求
+			lc.OnShutdown("audit_file", func() {
+				if err := f.Close(); err != nil {
+					log.Println("Failed to close audit file:", err)
+				}
+			})
+			sink = f
+		}
+		auditor := audit.New(a, sink)
+		unary = append(unary, auditor.UnaryServerInterceptor())
+		stream = append(stream, auditor.StreamServerInterceptor())
+	}
+	if app.Authz != nil {
+		// 未通过授权的请求不复制、不记录
+		unary = append(unary, authz.UnaryServerInterceptor(app.Authz))
+		stream = append(stream, authz.StreamServerInterceptor(app.Authz))
+	}
+	// 在鉴权之后, 未认证的请求先返回认证错误
+	unary = append(unary, validate.UnaryServerInterceptor())
+	stream = append(stream, validate.StreamServerInterceptor())
+	if m := conf.Metering; m.Enabled {
+		// 在授权之后, 被拒绝的请求不计量
+		meter := metering.New(m.CallerHeader, usageRecords{app.DB.Usage()})
+		lc.Go("metering", func(ctx context.Context) { meter.Run(ctx, m.FlushInterval.D()) })
+		unary = append(unary, meter.UnaryServerInterceptor())
+	}
+	if conf.Shadow.Target != "" {
+		mirror, err := shadow.New(conf.Shadow)
+		if err != nil {
+			log.Fatalln("Failed to create shadow mirror:", err)
+		}
+		unary = append(unary, mirror.UnaryServerInterceptor())
+	}
+	if conf.Record.Dir != "" {
+		rec, err := recorder.New(conf.Record)
+		if err != nil {
+			log.Fatalln("Failed to create request recorder:", err)
+		}
+		// serve不会返回, 在停止时写出缓冲的记录并关闭文件
+		lc.OnShutdown("recorder", func() {
+			if err := rec.Close(); err != nil {
+				log.Println("Failed to close request recorder:", err)
+			}
+		})
+		logger.Info("recording requests", zaplog.String("path", rec.Path()), zaplog.Any("percent", conf.Record.Percent))
+		unary = append(unary, rec.UnaryServerInterceptor())
+	}
+	if len(conf.Mock.Methods) > 0 {
+		// 在最内层, 模拟的请求同样经过鉴权、复制和记录
+		mocker, err := mock.New(conf.Mock)
+		if err != nil {
+			log.Fatalln("Failed to create mock responder:", err)
+		}
+		logger.Warn("mock responses enabled", zaplog.String("dir", conf.Mock.Dir), zaplog.Int("methods", len(conf.Mock.Methods)))
+		unary = append(unary, mocker.UnaryServerInterceptor())
+	}
+
+	// 监听所有配置的地址. gRPC连接的存活时间由grpc.Server处理, 到期时先发送GOAWAY; HTTP连接由listener关闭
+	limits := conf.Server.Limits
+	grpcLCs, httpLCs := conf.Server.GRPCListenConfigs(), conf.Server.HTTPListenConfigs()
+	grpcListeners := listenAll(grpcLCs, limits, false)
+	httpListeners := listenAll(httpLCs, limits, true)
+
+	// 把选定的gRPC trailer作为HTTP响应头返回; 废弃信息总是转换, 独立gateway模式下的废弃配置在后端
+	trailerHeaders := map[string]string{}
+	for k, h := range deprecation.TrailerHeaders {
+		trailerHeaders[k] = h
+	}
+	for k, h := range conf.Server.TrailerHeaders {
+		trailerHeaders[k] = h
+	}
+	// trailers替换了错误处理, 放在gwerrors之后, 设置响应头后再以统一格式写出错误
+	gwopts := append(gwerrors.ServeMuxOptions(), trailers.ServeMuxOptions(trailerHeaders, gwerrors.HandleError)...)
+	gwopts = append(gwopts, gatewayMarshaler(conf.Server.JSON))
+	// JSON-RPC和GraphQL接口使用相同的规则, 经这些接口的请求同样可以认证
+	headerMatcher := incomingHeaderMatcher(conf.Auth.APIKey.Headers())
+	gwopts = append(gwopts, runtime.WithIncomingHeaderMatcher(headerMatcher))
+	var docs *swagger.Registry
+	if conf.Swagger.Enabled {
+		// 编译进程序的文档和swagger.dirs中的文档合并为一个
+		if docs, err = swagger.New(conf.Swagger.Dirs...); err != nil {
+			log.Fatalln("Failed to load swagger docs:", err)
+		}
+	}
+
+	switch conf.Server.Mode {
+	case config.ModeGRPC:
+		// 纯gRPC后端
+		s := grpc.NewServer(grpcServerOptions(conf, unary, stream)...)
+		server.RegisterGRPC(s)
+		d := drain.New(newHealthServer(s, lc, app.Health, conf.Health), conf.Server.DrainDelay.D())
+		go stopOnSignal(lc, conf.ShutdownTimeout.D(), func(ctx context.Context) {
+			d.Drain(ctx)
+			gracefulStop(ctx, s)
+		})
+		for i, lis := range grpcListeners {
+			log.Println("Serving gRPC on", lis.Addr(), tlsNote(grpcLCs[i]))
+		}
+		serveAll(grpcListeners, s.Serve, nil)
+
+	case config.ModeGateway:
+		// 独立gateway, 转发到远程gRPC后端. gRPC健康状态由后端提供
+		d := drain.New(nil, conf.Server.DrainDelay.D())
+		hh := newHealthHTTP(conf, app.Health, d, nil)
+		mux, err := newGatewayMux(conf.Server.Targets, nil, conf.Server.Backend, gwopts...)
+		if err != nil {
+			log.Fatalln("Failed to register gwmux:", err)
+		}
+		hh.Register(mux)
+		if conf.Debug.EnablePprof {
+			profiling.Register(mux)
+		}
+		registerSwagger(mux, docs, conf, apiKeys)
+		var handler http.Handler = mux
+		if c := conf.Server.Canary; len(c.Targets) > 0 {
+			// 金丝雀发布: 按权重或请求头在两组后端之间分配请求
+			cmux, err := newGatewayMux(c.Targets, nil, conf.Server.Backend, gwopts...)
+			if err != nil {
+				log.Fatalln("Failed to register canary gwmux:", err)
+			}
+			hh.Register(cmux)
+			if conf.Debug.EnablePprof {
+				profiling.Register(cmux)
+			}
+			registerSwagger(cmux, docs, conf, apiKeys)
+			handler = canary.New(mux, cmux, c.Weight, c.Header)
+			log.Printf("Canary: %.1f%% -> %v", c.Weight, c.Targets)
+		}
+		if c := conf.Auth.APIKey; c.Enabled {
+			// 在转发之前拒绝没有有效API key的请求; 组合模式下由gRPC拦截器校验
+			handler = apikey.New(c, apiKeys).Handler(handler)
+		}
+		handler = compress.Handler(conf.Server.Compression, handler)
+		// 在最外层, 预检请求不需要API key, 错误响应同样带有CORS头
+		handler = cors.Handler(conf.CORS, handler)
+		// 请求ID转发给gRPC后端, 两边的日志使用同一个request_id
+		handler = zaplog.RequestIDHandler(handler)
+		gwServer := &http.Server{Handler: d.Handler(handler)}
+		go stopOnSignal(lc, conf.ShutdownTimeout.D(), func(ctx context.Context) {
+			d.Drain(ctx)
+			if err := d.Shutdown(ctx, gwServer); err != nil {
+				log.Println("Failed to shut down gateway:", err)
+			}
+		})
+		for i, lis := range httpListeners {
+			log.Println("Serving gateway on", lis.Addr(), tlsNote(httpLCs[i]), "->", conf.Server.Targets)
+		}
+		serveAll(httpListeners, gwServer.Serve, http.ErrServerClosed)
+
+	default:
+		// 创建一个gRPC server对象
+		s := grpc.NewServer(grpcServerOptions(conf, unary, stream)...)
+		// 注册所有服务模块到server
+		server.RegisterGRPC(s)
+		d := drain.New(newHealthServer(s, lc, app.Health, conf.Health), conf.Server.DrainDelay.D())
+
+		var gwServer *http.Server
+		if len(httpListeners) > 0 {
+			// gRPC-Gateway mux, 通过进程内连接访问gRPC服务, 不占用对外端口的连接数
+			inproc := bufconn.Listen(1 << 20)
+			go s.Serve(inproc)
+			mux, err := newGatewayMux([]string{"inproc"}, func(ctx context.Context, _ string) (net.Conn, error) {
+				return inproc.DialContext(ctx)
+			}, conf.Server.Backend, gwopts...)
+			if err != nil {
+				log.Fatalln("Failed to register gwmux:", err)
+			}
+			newHealthHTTP(conf, app.Health, d, s).Register(mux)
+			if conf.Debug.EnablePprof {
+				profiling.Register(mux)
+			}
+			registerSwagger(mux, docs, conf, apiKeys)
+
+			// JSON-RPC 2.0 兼容接口, 供无法使用REST/gRPC的旧调用方使用
+			rpc := jsonrpc.NewServer(
+				jsonrpc.WithUnaryInterceptor(server.ChainUnary(unary...)),
+				jsonrpc.WithIncomingHeaderMatcher(headerMatcher))
+			server.RegisterGRPC(rpc)
+			mux.Handle("/rpc", rpc)
+
+			if conf.Server.GraphQL {
+				// GraphQL在进程内直接调用服务实现
+				gql := graphql.NewServer(
+					graphql.WithUnaryInterceptor(server.ChainUnary(unary...)),
+					graphql.WithIncomingHeaderMatcher(headerMatcher))
+				server.RegisterGRPC(gql)
+				mux.Handle("/graphql", gql)
+			}
+
+			// 定义HTTP server配置
+			h2s := &http2.Server{MaxConcurrentStreams: limits.MaxConcurrentStreams}
+			if err := d.HTTP2(h2s); err != nil {
+				log.Fatalln("Failed to configure http2:", err)
+			}
+			// 跨域处理和请求ID只用于HTTP请求, gRPC请求不经过浏览器的跨域检查, 请求ID由拦截器生成
+			handler := zaplog.RequestIDHandler(cors.Handler(conf.CORS, compress.Handler(conf.Server.Compression, mux)))
+			// 共用端口时按Content-Type把gRPC请求交给s; 分开监听时HTTP端口只处理HTTP请求
+			if !conf.Server.Split() {
+				handler = grpcHandlerFunc(s, handler)
+			}
+			if hasPlaintext(httpLCs) {
+				// 明文端口上的HTTP/2(h2c), gRPC客户端不使用TLS时需要
+				handler = h2c.NewHandler(handler, h2s)
+			}
+			gwServer = &http.Server{
+				Handler: d.Handler(handler), // 请求的统一入口
+			}
+			if hasTLS(httpLCs) {
+				// TLS连接通过ALPN协商h2后同样交给h2s, Drain时的GOAWAY也发送到这些连接.
+				// http2.ConfigureServer会重置h2s的连接状态, 因此不能再对gwServer调用
+				gwServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+					"h2": func(hs *http.Server, c *tls.Conn, h http.Handler) {
+						h2s.ServeConn(c, &http2.ServeConnOpts{Handler: h, BaseConfig: hs})
+					},
+				}
+			}
+		}
+		go stopOnSignal(lc, conf.ShutdownTimeout.D(), func(ctx context.Context) {
+			d.Drain(ctx)
+			// 先关闭HTTP, gateway转发中的请求还需要gRPC服务
+			if gwServer != nil {
+				if err := d.Shutdown(ctx, gwServer); err != nil {
+					log.Println("Failed to shut down server:", err)
+				}
+			}
+			if len(grpcListeners) > 0 {
+				gracefulStop(ctx, s)
+			}
+		})
+		for i, lis := range grpcListeners {
+			log.Println("Serving gRPC on", lis.Addr(), tlsNote(grpcLCs[i]))
+		}
+		serveAll(grpcListeners, s.Serve, nil)
+		for i, lis := range httpListeners {
+			scheme := "http://"
+			if httpLCs[i].TLS.Enabled() {
+				scheme = "https://"
+			}
+			log.Println("Serving on " + scheme + loopbackAddr(lis.Addr().String()))
+		}
+		if gwServer != nil {
+			serveAll(httpListeners, gwServer.Serve, http.ErrServerClosed) // 启动HTTP服务
+		}
+	}
+	// 停止后由stopOnSignal退出进程
+	select {}
+}
+
+// newElector 根据leader配置创建选主器, rc不为nil时使用Redis锁; 实例ID为主机名和进程ID
+func newElector(conf *config.Config, rc *redis.Client) *leader.Elector {
+	var lock leader.Lock = leader.NewLocalLock()
+	if conf.Leader.Backend == config.LeaderRedis {
+		lock = leader.NewRedisLock(rc)
+	}
+	host, _ := os.Hostname()
+	id := fmt.Sprintf("%s-%d", host, os.Getpid())
+	return leader.New(lock, conf.Leader.Key, id, conf.Leader.TTL.D())
+}
+
+// sloAlertMailer 把SLO告警状态变化用邮件发送给to中的每个地址, 使用默认语言
+func sloAlertMailer(mail *mailtmpl.Renderer, notifier *notify.Dispatcher, to []string) func(slo.Alert) {
+	host, _ := os.Hostname()
+	return func(a slo.Alert) {
+		r, err := mail.Render(mailtmpl.SLOAlert, nil, mailtmpl.SLOAlertData{
+			SLO:        a.SLO,
+			SLI:        a.SLI,
+			Firing:     a.Firing,
+			BurnRate5m: a.BurnRate5m,
+			BurnRate1h: a.BurnRate1h,
+			Instance:   host,
+			Time:       a.Time,
+		})
+		if err != nil {
+			log.Printf("slo: render alert email: %v", err)
+			return
+		}
+		for _, addr := range to {
+			if err := notifier.Dispatch(context.Background(), r.Message(addr)); err != nil {
+				log.Printf("slo: queue alert email to %s: %v", addr, err)
+			}
+		}
+	}
+}
+
+// stopOnSignal 收到SIGINT或SIGTERM时先执行stop(摘流并关闭服务), 再停止后台组件(提交消费offset、发送缓冲的日志等)后退出.
+// 整个过程最多timeout
+func stopOnSignal(lc *lifecycle.Manager, timeout time.Duration, stop func(ctx context.Context)) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-ch
+	log.Println("Received", sig, "draining and stopping")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stop(ctx)
+	if err := lc.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	os.Exit(0)
+}
+
+// serveAll 在每个监听地址上启动serve, 任一返回closed以外的错误时退出进程
+func serveAll(listeners []net.Listener, serve func(net.Listener) error, closed error) {
+	for _, lis := range listeners {
+		go func(lis net.Listener) {
+			if err := serve(lis); err != closed {
+				log.Fatalln(err)
+			}
+		}(lis)
+	}
+}
+
+// hasTLS lcs中是否有使用TLS的地址
+func hasTLS(lcs []listener.Config) bool {
+	for _, c := range lcs {
+		if c.TLS.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPlaintext lcs中是否有不使用TLS的地址
+func hasPlaintext(lcs []listener.Config) bool {
+	for _, c := range lcs {
+		if !c.TLS.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAll 监听lcs中的所有地址并限制连接数, maxAge为true时关闭存活超过limits.max_connection_age的连接
+func listenAll(lcs []listener.Config, limits config.Limits, maxAge bool) []net.Listener {
+	var listeners []net.Listener
+	for _, c := range lcs {
+		lis, err := listener.Listen(c)
+		if err != nil {
+			log.Fatalln("Failed to listen:", err)
+		}
+		lis = listener.Limit(lis, limits.MaxConnections)
+		if maxAge {
+			lis = listener.MaxAge(lis, limits.MaxConnectionAge.D(), limits.MaxConnectionAgeGrace.D())
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners
+}
+
+// tlsNote 启动日志中标注监听地址是否使用TLS
+func tlsNote(c listener.Config) string {
+	switch {
+	case c.TLS.ClientCAFile != "":
+		return "(mTLS)"
+	case c.TLS.Enabled():
+		return "(TLS)"
+	}
+	return "(plaintext)"
+}
+
+// gracefulStop 等待进行中的请求完成后停止s, ctx结束时强制停止
+func gracefulStop(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// newHealthServer 在s上注册grpc.health.v1服务, 整体("")和各服务模块共用一个状态, 初始为SERVING.
+// 配置了检查间隔时按reg中依赖检查的结果在SERVING和NOT_SERVING之间切换; 停止时由drain设为NOT_SERVING
+func newHealthServer(s *grpc.Server, lc *lifecycle.Manager, reg *health.Registry, c config.Health) *grpchealth.Server {
+	hs := grpchealth.NewServer()
+	services := []string{""}
+	for _, m := range server.Modules() {
+		services = append(services, m.Name)
+	}
+	set := func(st healthpb.HealthCheckResponse_ServingStatus) {
+		for _, name := range services {
+			hs.SetServingStatus(name, st)
+		}
+	}
+	set(healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	if c.Interval <= 0 {
+		return hs
+	}
+	required := map[string]bool{}
+	for _, name := range c.Required {
+		required[name] = true
+	}
+	serving := true
+	lc.Go("health", func(ctx context.Context) {
+		reg.Watch(ctx, c.Interval.D(), c.Timeout.D(), func(rs []health.Result) {
+			var failed []string
+			for _, r := range rs {
+				if !r.Healthy() && (len(required) == 0 || required[r.Name]) {
+					failed = append(failed, fmt.Sprintf("%s: %v", r.Name, r.Err))
+				}
+			}
+			if ok := len(failed) == 0; ok != serving {
+				serving = ok
+				if ok {
+					log.Println("health: dependencies recovered, set to SERVING")
+					set(healthpb.HealthCheckResponse_SERVING)
+				} else {
+					log.Printf("health: set to NOT_SERVING: %s", strings.Join(failed, "; "))
+					set(healthpb.HealthCheckResponse_NOT_SERVING)
+				}
+			}
+		})
+	})
+	return hs
+}
+
+// newHealthHTTP 创建/livez、/readyz和/healthz接口, 除依赖检查外, 停止中或s(不为nil时)没有注册服务时也未就绪
+func newHealthHTTP(conf *config.Config, reg *health.Registry, d *drain.Drainer, s *grpc.Server) *health.HTTP {
+	h := health.NewHTTP(reg, conf.Health.Timeout.D(), conf.Health.Required)
+	// 能处理请求说明配置已加载并通过校验
+	h.Condition("config", func() error { return nil })
+	if s != nil {
+		h.Condition("grpc", func() error {
+			if len(s.GetServiceInfo()) == 0 {
+				return errors.New("no gRPC services registered")
+			}
+			return nil
+		})
+	}
+	h.Condition("shutdown", func() error {
+		if d.Draining() {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+	return h
+}
+
+// grpcServerOptions 根据配置生成grpc.Server选项.
+// 组合模式下gRPC请求经由h2c转给ServeHTTP, 连接相关的选项由http2.Server和listener负责
+func grpcServerOptions(conf *config.Config, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) []grpc.ServerOption {
+	limits := conf.Server.Limits
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             conf.Server.Keepalive.MinTime.D(),
+			PermitWithoutStream: conf.Server.Keepalive.PermitWithoutStream,
+		}),
+	}
+	if hasTLS(conf.Server.GRPCListenConfigs()) {
+		// TLS握手由listener完成, 凭证把连接状态(包括客户端证书)提供给peer.FromContext
+		opts = append(opts, grpc.Creds(listener.Credentials()))
+	}
+	if limits.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(limits.MaxConcurrentStreams))
+	}
+	if limits.MaxConnectionAge > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionAge:      limits.MaxConnectionAge.D(),
+			MaxConnectionAgeGrace: limits.MaxConnectionAgeGrace.D(),
+		}))
+	}
+	return opts
+}
+
+// newGatewayMux 创建gateway的HTTP mux, 请求在targets之间轮询; dialer不为nil时用它建立连接, backend为连接的keepalive和生命周期配置
+func newGatewayMux(targets []string, dialer func(context.Context, string) (net.Conn, error), backend client.ConnConfig, opts ...runtime.ServeMuxOption) (*http.ServeMux, error) {
+	r := manual.NewBuilderWithScheme("greeter")
+	addrs := make([]resolver.Address, 0, len(targets))
+	for _, t := range targets {
+		addrs = append(addrs, resolver.Address{Addr: t})
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+
+	gwmux := runtime.NewServeMux(opts...)
+	dops := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`),
+	}
+	cops, err := backend.DialOptions(dialer)
+	if err != nil {
+		return nil, err
+	}
+	dops = append(dops, cops...)
+	err = server.RegisterGateway(context.Background(), gwmux, r.Scheme()+":///backend", dops)
+	if err != nil {
+		return nil, err
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/", gwmux)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux, nil
+}
+
+// registerSwagger 按配置注册swagger文档和浏览页面, 需要认证时先校验basic认证或API key; docs为nil时不注册
+func registerSwagger(mux *http.ServeMux, docs *swagger.Registry, conf *config.Config, keys apikey.Store) {
+	if docs == nil {
+		return
+	}
+	c := conf.Swagger
+	var wrap func(http.Handler) http.Handler
+	switch c.Auth {
+	case swagger.AuthBasic:
+		wrap = func(h http.Handler) http.Handler { return swagger.BasicAuth(c.Username, c.Password, h) }
+	case swagger.AuthAPIKey:
+		wrap = apikey.New(conf.Auth.APIKey, keys).Handler
+	}
+	docs.Register(mux, wrap)
+}
+
+// gatewayMarshaler 按配置替换gateway默认的JSON编解码, 与默认一样支持google.api.HttpBody响应
+func gatewayMarshaler(c config.GatewayJSON) runtime.ServeMuxOption {
+	return runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.HTTPBodyMarshaler{
+		Marshaler: &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				EmitUnpopulated: c.EmitUnpopulated,
+				UseProtoNames:   c.UseProtoNames,
+				UseEnumNumbers:  c.UseEnumNumbers,
+				Indent:          c.Indent,
+				Multiline:       c.Indent != "",
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: c.DiscardUnknown},
+		},
+	})
+}
 
-	// 定义HTTP server配置
-	gwServer := &http.Server{
-		Addr:    "127.0.0.1:8091",
-		Handler: grpcHandlerFunc(s, mux), // 请求的统一入口
+// incomingHeaderMatcher 除gateway默认转发的HTTP头外, 把请求ID、trace context、baggage和forward中的头原样转发给gRPC服务
+func incomingHeaderMatcher(forward []string) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		switch k := strings.ToLower(key); k {
+		case ctxutil.RequestIDHeader, ctxutil.TraceParentHeader, "tracestate", ctxutil.BaggageHeader:
+			return k, true
+		default:
+			for _, f := range forward {
+				if k == f {
+					return k, true
+				}
+			}
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}
+}
+
+// usageRecords 把计量的用量写入db.UsageStore
+type usageRecords struct {
+	db.UsageStore
+}
+
+func (s usageRecords) Add(ctx context.Context, rs []*metering.Record) error {
+	us := make([]*model.Usage, 0, len(rs))
+	for _, r := range rs {
+		us = append(us, &model.Usage{
+			Date: r.Date, Caller: r.Caller, Method: r.Method, Calls: r.Calls, Errors: r.Errors,
+			RequestBytes: r.RequestBytes, ResponseBytes: r.ResponseBytes, ComputeUnits: r.ComputeUnits,
+		})
+	}
+	return s.UsageStore.Add(ctx, us)
+}
+
+// auditEvents 把审计记录写入db.AuditStore
+type auditEvents struct {
+	db.AuditStore
+}
+
+func (s auditEvents) Write(ctx context.Context, e *audit.Event) error {
+	return s.AuditStore.Append(ctx, &model.AuditEvent{
+		Time: e.Time, Method: e.Method, Actor: e.Actor, UserID: e.UserID, Username: e.Username, AppID: e.AppID,
+		Resource: e.Resource, Outcome: e.Outcome, Code: e.Code, RequestID: e.RequestID, TraceID: e.TraceID, ClientIP: e.ClientIP,
+	})
+}
+
+// policyRules 把数据库中的授权规则提供给casbin引擎
+type policyRules struct {
+	db.PolicyStore
+}
+
+func (s policyRules) List(ctx context.Context) ([]authz.Rule, error) {
+	rules, err := s.PolicyStore.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]authz.Rule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, authz.Rule{PType: r.PType, V0: r.V0, V1: r.V1, V2: r.V2})
+	}
+	return out, nil
+}
+
+func (s policyRules) Add(ctx context.Context, r authz.Rule) error {
+	return s.PolicyStore.Add(ctx, &model.PolicyRule{PType: r.PType, V0: r.V0, V1: r.V1, V2: r.V2})
+}
+
+// loopbackAddr 把监听地址转换为本机可访问的地址, 如 ":8091" 转换为 "127.0.0.1:8091"
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
 	}
-	log.Println("Serving on http://127.0.0.1:8091")
-	log.Fatalln(gwServer.Serve(lis)) // 启动HTTP服务
+	return net.JoinHostPort(host, port)
 }
 
 // grpcHandlerFunc 将gRPC请求和HTTP请求分别调用不同的handler处理
 func grpcHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler) http.Handler {
-	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
 			grpcServer.ServeHTTP(w, r)
 		} else {
 			otherHandler.ServeHTTP(w, r)
 		}
-	}), &http2.Server{})
+	})
 }
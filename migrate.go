@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Q1mi/greeter/internal/repo/db"
+	"github.com/Q1mi/greeter/pkg/config"
+)
+
+// migrateMain 实现migrate子命令: 对db.dsn指向的数据库执行或回退迁移, 或查看各版本的状态
+//
+//	greeter -conf conf/config.json migrate up
+//	greeter -conf conf/config.json migrate down 1
+//	greeter -conf conf/config.json migrate status
+func migrateMain(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	confFlag(fs)
+	timeout := fs.Duration("timeout", 10*time.Minute, "整个命令的超时时间")
+	fs.Parse(args)
+	cmd, steps := fs.Arg(0), 1
+	switch {
+	case cmd == "down" && fs.NArg() == 2:
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || n <= 0 {
+			fmt.Fprintln(os.Stderr, "migrate down: steps must be a positive integer")
+			return 2
+		}
+		steps = n
+	case (cmd == "up" || cmd == "down" || cmd == "status") && fs.NArg() == 1:
+	default:
+		fmt.Fprintln(os.Stderr, "usage: greeter migrate [-conf file] [-timeout d] up | down [steps] | status")
+		return 2
+	}
+
+	conf, err := config.Load(*confPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+	m, err := db.OpenMigrator(conf.DB.OpenDSN(conf.DB.DSN))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open database:", err)
+		return 1
+	}
+	defer m.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		fmt.Printf("applied %d migration(s)\n", n)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "down":
+		n, err := m.Down(ctx, steps)
+		fmt.Printf("reverted %d migration(s)\n", n)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		list, err := m.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range list {
+			name, state := s.Name, "pending"
+			if name == "" {
+				name = "(unknown)"
+			}
+			if !s.AppliedAt.IsZero() {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-24s %s\n", s.Version, name, state)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,280 @@
+// Package apikey 按app-id/app-secret请求头认证调用方, 每个key可以单独限流, 并记录最后使用时间.
+// 与JWT认证相互独立: JWT标识用户, API key标识接入的应用.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// 默认的请求头, 经gateway访问时为同名HTTP头
+const (
+	DefaultIDHeader     = "app-id"
+	DefaultSecretHeader = "app-secret"
+)
+
+var (
+	// ErrMissing 请求没有携带app-id或app-secret
+	ErrMissing = errs.New("apikey.missing", "app-id and app-secret are required")
+	// ErrInvalid app-id不存在、已停用或app-secret错误
+	ErrInvalid = errs.New("apikey.invalid", "invalid app-id or app-secret")
+	// ErrRateLimited 超过了key的限流
+	ErrRateLimited = errs.New("apikey.rate_limited", "too many requests for this app-id, please retry later")
+	// ErrNotFound Store中没有该key
+	ErrNotFound = errors.New("apikey: key not found")
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("apikey_requests_total",
+		"Number of API key checks by app id and result (ok, exempt, missing, invalid, limited or error). app_id is empty for unknown keys.", "app_id", "result")
+	lastUsed = metrics.NewGaugeVec("apikey_last_used_timestamp_seconds",
+		"Unix time of the last accepted request of each app id.", "app_id")
+)
+
+// Key 一个接入应用的凭据
+type Key struct {
+	// ID 请求头app-id的值
+	ID string `json:"app_id"`
+	// Name 应用名称, 只用于日志
+	Name string `json:"name"`
+	// Secret app-secret明文, 与SecretSHA256二选一
+	Secret string `json:"secret"`
+	// SecretSHA256 app-secret的SHA-256(十六进制), 避免在配置中保存明文
+	SecretSHA256 string `json:"secret_sha256"`
+	// RateLimit 每秒允许的请求数, 不大于0时不限流
+	RateLimit float64 `json:"rate_limit"`
+	// Burst 允许的突发请求数, 不大于0时为RateLimit(至少1)
+	Burst int `json:"burst"`
+	// Disabled 停用的key按不存在处理
+	Disabled bool `json:"disabled"`
+}
+
+// Config API key认证配置
+type Config struct {
+	// Enabled 是否要求请求携带有效的API key, Exempt中的方法除外
+	Enabled bool `json:"enabled"`
+	// IDHeader 携带app-id的metadata key, 为空时为app-id
+	IDHeader string `json:"id_header"`
+	// SecretHeader 携带app-secret的metadata key, 为空时为app-secret
+	SecretHeader string `json:"secret_header"`
+	// Keys 配置文件中的key, Store为config时使用
+	Keys []Key `json:"keys"`
+	// Exempt 不需要API key的gRPC方法全名, 以*结尾时匹配该前缀
+	Exempt []string `json:"exempt"`
+	// ExemptPaths 独立gateway上不需要API key的HTTP路径前缀, 如 /healthz、/metrics
+	ExemptPaths []string `json:"exempt_paths"`
+}
+
+// Headers 返回携带app-id和app-secret的metadata key(小写), 未启用时为nil. gateway需要把这些HTTP头转发给gRPC服务
+func (c Config) Headers() []string {
+	if !c.Enabled {
+		return nil
+	}
+	id, secret := c.IDHeader, c.SecretHeader
+	if id == "" {
+		id = DefaultIDHeader
+	}
+	if secret == "" {
+		secret = DefaultSecretHeader
+	}
+	return []string{strings.ToLower(id), strings.ToLower(secret)}
+}
+
+// Validate 检查key是否完整
+func (c Config) Validate() error {
+	seen := map[string]bool{}
+	for _, k := range c.Keys {
+		if k.ID == "" {
+			return fmt.Errorf("apikey: app_id is required")
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("apikey: duplicate app_id %s", k.ID)
+		}
+		seen[k.ID] = true
+		if (k.Secret == "") == (k.SecretSHA256 == "") {
+			return fmt.Errorf("apikey: %s: exactly one of secret and secret_sha256 is required", k.ID)
+		}
+		if k.SecretSHA256 != "" {
+			if b, err := hex.DecodeString(k.SecretSHA256); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("apikey: %s: secret_sha256 must be 64 hex characters", k.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Store key存储, 必须并发安全
+type Store interface {
+	// Get 按app-id查询, 不存在时返回ErrNotFound
+	Get(ctx context.Context, id string) (*Key, error)
+	// Touch 记录key在at时被使用
+	Touch(ctx context.Context, id string, at time.Time) error
+}
+
+// Static 配置文件中的key, 最后使用时间保存在内存中
+type Static struct {
+	keysMu sync.RWMutex
+	keys   map[string]*Key
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// NewStatic 创建Static, keys应已通过Config.Validate
+func NewStatic(keys []Key) *Static {
+	s := &Static{used: map[string]time.Time{}}
+	s.Replace(keys)
+	return s
+}
+
+// Replace 替换全部key, 用于配置重新加载; keys应已通过Config.Validate. 已有key的最后使用时间保留
+func (s *Static) Replace(keys []Key) {
+	m := make(map[string]*Key, len(keys))
+	for i := range keys {
+		k := keys[i]
+		m[k.ID] = &k
+	}
+	s.keysMu.Lock()
+	s.keys = m
+	s.keysMu.Unlock()
+}
+
+func (s *Static) Get(_ context.Context, id string) (*Key, error) {
+	s.keysMu.RLock()
+	k, ok := s.keys[id]
+	s.keysMu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *Static) Touch(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used[id] = at
+	return nil
+}
+
+// LastUsed 返回key的最后使用时间, 本进程启动后未使用过时ok为false
+func (s *Static) LastUsed(id string) (t time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok = s.used[id]
+	return t, ok
+}
+
+// Authenticator 校验API key并按key限流
+type Authenticator struct {
+	c     Config
+	store Store
+	now   func() time.Time
+
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+// New 创建Authenticator
+func New(c Config, store Store) *Authenticator {
+	c.Enabled = true
+	h := c.Headers()
+	c.IDHeader, c.SecretHeader = h[0], h[1]
+	return &Authenticator{c: c, store: store, now: time.Now, limiters: map[string]*limiter{}}
+}
+
+// Check 校验id和secret并消耗一次限流配额. 超过限流时返回ErrRateLimited和建议的重试等待时间
+func (a *Authenticator) Check(ctx context.Context, id, secret string) (*Key, time.Duration, error) {
+	if id == "" || secret == "" {
+		requestsTotal.WithLabelValues("", "missing").Inc()
+		return nil, 0, ErrMissing
+	}
+	k, err := a.store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		requestsTotal.WithLabelValues("", "invalid").Inc()
+		return nil, 0, ErrInvalid
+	}
+	if err != nil {
+		requestsTotal.WithLabelValues("", "error").Inc()
+		return nil, 0, err
+	}
+	if k.Disabled || !k.matches(secret) {
+		requestsTotal.WithLabelValues("", "invalid").Inc()
+		return nil, 0, ErrInvalid
+	}
+	now := a.now()
+	if wait := a.limiter(k).take(now); wait > 0 {
+		requestsTotal.WithLabelValues(k.ID, "limited").Inc()
+		return k, wait, ErrRateLimited
+	}
+	requestsTotal.WithLabelValues(k.ID, "ok").Inc()
+	lastUsed.WithLabelValues(k.ID).Set(float64(now.Unix()))
+	if err := a.store.Touch(ctx, k.ID, now); err != nil {
+		// 只影响最后使用时间, 不拒绝请求
+		zaplog.FromContext(ctx).Warn("apikey: touch", zaplog.String("app_id", k.ID), zaplog.Error(err))
+	}
+	return k, 0, nil
+}
+
+// matches 按SHA-256比较secret, 比较时间与内容无关
+func (k *Key) matches(secret string) bool {
+	sum := sha256.Sum256([]byte(secret))
+	want := k.SecretSHA256
+	if want == "" {
+		w := sha256.Sum256([]byte(k.Secret))
+		want = hex.EncodeToString(w[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(want))) == 1
+}
+
+func (a *Authenticator) limiter(k *Key) *limiter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	burst := float64(k.Burst)
+	if burst <= 0 {
+		burst = math.Max(k.RateLimit, 1)
+	}
+	// key的限流修改后(如配置重新加载)重建令牌桶
+	l, ok := a.limiters[k.ID]
+	if !ok || l.rate != k.RateLimit || l.burst != burst {
+		l = &limiter{rate: k.RateLimit, burst: burst, tokens: burst, last: a.now()}
+		a.limiters[k.ID] = l
+	}
+	return l
+}
+
+// limiter 令牌桶, rate不大于0时不限流
+type limiter struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take 取一个令牌, 没有时返回下一个令牌可用前需要等待的时间
+func (l *limiter) take(now time.Time) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens--
+	return 0
+}
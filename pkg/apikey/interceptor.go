@@ -0,0 +1,121 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/gwerrors"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// UnaryServerInterceptor 校验metadata中的app-id和app-secret, 通过后在日志和请求标签中加上app_id
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if matchAny(info.FullMethod, a.c.Exempt) {
+			requestsTotal.WithLabelValues("", "exempt").Inc()
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		k, wait, err := a.Check(ctx, first(md.Get(a.c.IDHeader)), first(md.Get(a.c.SecretHeader)))
+		if err != nil {
+			return nil, toStatus(ctx, err, wait)
+		}
+		ctxutil.TagsFrom(ctx).Set("app_id", k.ID)
+		return handler(zaplog.With(ctx, zaplog.String("app_id", k.ID)), req)
+	}
+}
+
+// StreamServerInterceptor 与UnaryServerInterceptor相同, 用于流式调用, 只在建立流时校验和计入限流一次
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if matchAny(info.FullMethod, a.c.Exempt) {
+			requestsTotal.WithLabelValues("", "exempt").Inc()
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		md, _ := metadata.FromIncomingContext(ctx)
+		k, wait, err := a.Check(ctx, first(md.Get(a.c.IDHeader)), first(md.Get(a.c.SecretHeader)))
+		if err != nil {
+			return toStatus(ctx, err, wait)
+		}
+		ctxutil.TagsFrom(ctx).Set("app_id", k.ID)
+		return handler(srv, ctxutil.WrapServerStream(ss, zaplog.With(ctx, zaplog.String("app_id", k.ID))))
+	}
+}
+
+// Handler 在独立gateway上校验请求头中的app-id和app-secret, 未通过的请求不转发到后端.
+// 错误响应与gateway的JSON状态格式相同
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range a.c.ExemptPaths {
+			if strings.HasPrefix(r.URL.Path, p) {
+				requestsTotal.WithLabelValues("", "exempt").Inc()
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		_, wait, err := a.Check(r.Context(), r.Header.Get(a.c.IDHeader), r.Header.Get(a.c.SecretHeader))
+		if err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		st := status.Convert(toStatus(r.Context(), err, wait))
+		// 请求没有到达gRPC服务, 请求ID取自请求头或新生成
+		id := r.Header.Get(ctxutil.RequestIDHeader)
+		if id == "" {
+			id = randomHex(8)
+		}
+		st = errs.WithRequestInfo(st, id)
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		}
+		gwerrors.WriteError(w, r, st)
+	})
+}
+
+// toStatus 把Check的错误转换为gRPC状态, 限流时带上RetryInfo
+func toStatus(ctx context.Context, err error, wait time.Duration) error {
+	switch err {
+	case ErrMissing, ErrInvalid:
+		return errs.Status(codes.Unauthenticated, err)
+	case ErrRateLimited:
+		return errs.StatusWithDetails(codes.ResourceExhausted, err, &errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	}
+	zaplog.FromContext(ctx).Error("apikey: check", zaplog.Error(err))
+	return errs.Status(codes.Internal, errs.ErrInternal)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func first(vs []string) string {
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[0]
+}
+
+func matchAny(method string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == method || strings.HasSuffix(p, "*") && strings.HasPrefix(method, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
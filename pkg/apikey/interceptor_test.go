@@ -0,0 +1,122 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/testing/interceptor"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed secret"))
+	store := NewStatic([]Key{
+		{ID: "web", Secret: "web secret"},
+		{ID: "batch", SecretSHA256: hex.EncodeToString(sum[:])},
+		{ID: "old", Secret: "old secret", Disabled: true},
+	})
+	a := New(Config{Exempt: []string{"/grpc.health.v1.Health/*"}}, store)
+	ic := a.UnaryServerInterceptor()
+
+	tests := []struct {
+		name       string
+		method     string
+		id, secret string
+		err        error
+		// appID 通过时标签和日志中的app_id, 为空时不设置
+		appID string
+	}{
+		{"valid", "", "web", "web secret", nil, "web"},
+		{"valid sha256", "", "batch", "hashed secret", nil, "batch"},
+		{"missing secret", "", "web", "", ErrMissing, ""},
+		{"missing both", "", "", "", ErrMissing, ""},
+		{"wrong secret", "", "web", "batch secret", ErrInvalid, ""},
+		{"unknown id", "", "mobile", "web secret", ErrInvalid, ""},
+		{"disabled", "", "old", "old secret", ErrInvalid, ""},
+		{"exempt", "/grpc.health.v1.Health/Check", "", "", nil, ""},
+	}
+	for _, tt := range tests {
+		var kv []string
+		if tt.id != "" {
+			kv = append(kv, DefaultIDHeader, tt.id)
+		}
+		if tt.secret != "" {
+			kv = append(kv, DefaultSecretHeader, tt.secret)
+		}
+		ctx, tags := ctxutil.WithTags(context.Background())
+		h := &interceptor.Handler{Resp: "ok"}
+		res := interceptor.Run(interceptor.IncomingContext(ctx, kv...), nil, interceptor.Info(tt.method), h.Handle, ic)
+		if tt.err != nil {
+			if status.Code(res.Err) != codes.Unauthenticated || !errors.Is(res.Err, tt.err) {
+				t.Errorf("%s: err %v, want Unauthenticated %v", tt.name, res.Err, tt.err)
+			}
+			if h.Calls() != 0 {
+				t.Errorf("%s: handler called without a valid key", tt.name)
+			}
+			continue
+		}
+		if res.Err != nil || h.Calls() != 1 {
+			t.Errorf("%s: err %v, handler called %d times", tt.name, res.Err, h.Calls())
+			continue
+		}
+		var appID string
+		for _, tag := range tags.Values() {
+			if tag.Key == "app_id" {
+				appID = tag.Value
+			}
+		}
+		if appID != tt.appID {
+			t.Errorf("%s: app_id tag %q, want %q", tt.name, appID, tt.appID)
+		}
+	}
+	if _, ok := store.LastUsed("web"); !ok {
+		t.Error("last use of web was not recorded")
+	}
+	if _, ok := store.LastUsed("old"); ok {
+		t.Error("last use of the disabled key was recorded")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	a := New(Config{IDHeader: "X-App-Id", SecretHeader: "X-App-Secret"},
+		NewStatic([]Key{{ID: "web", Secret: "web secret", RateLimit: 2, Burst: 2}}))
+	a.now = func() time.Time { return now }
+	ic := a.UnaryServerInterceptor()
+	call := func() *interceptor.Result {
+		// 自定义的请求头按小写的metadata key读取
+		ctx := interceptor.IncomingContext(context.Background(), "x-app-id", "web", "x-app-secret", "web secret")
+		return interceptor.Run(ctx, nil, nil, nil, ic)
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := call(); res.Err != nil {
+			t.Fatalf("request %d within burst: %v", i+1, res.Err)
+		}
+	}
+	res := call()
+	if status.Code(res.Err) != codes.ResourceExhausted || !errors.Is(res.Err, ErrRateLimited) {
+		t.Fatalf("request over burst: %v, want ResourceExhausted", res.Err)
+	}
+	var delay time.Duration
+	for _, d := range status.Convert(res.Err).Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			delay = ri.RetryDelay.AsDuration()
+		}
+	}
+	if delay != 500*time.Millisecond {
+		t.Errorf("RetryInfo delay %s, want 500ms at 2 requests per second", delay)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res := call(); res.Err != nil {
+		t.Errorf("request after the retry delay: %v", res.Err)
+	}
+}
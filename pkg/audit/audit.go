@@ -0,0 +1,199 @@
+// Package audit 为敏感的RPC记录审计日志: 谁(JWT或API key认证的调用者)在什么时间调用了什么方法、
+// 操作了哪个资源、结果如何. 审计记录写到独立的只追加存储(文件或数据库表), 与应用日志分开保存.
+package audit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// 审计存储类型
+const (
+	// SinkFile 追加写入JSON Lines文件
+	SinkFile = "file"
+	// SinkDB 写入数据库的audit_events表
+	SinkDB = "db"
+)
+
+// 调用结果
+const (
+	OutcomeSuccess = "success"
+	// OutcomeDenied 未认证或没有权限
+	OutcomeDenied = "denied"
+	OutcomeError  = "error"
+)
+
+// Config 审计配置
+type Config struct {
+	// Enabled 是否记录审计日志
+	Enabled bool `json:"enabled"`
+	// Methods 需要审计的gRPC方法全名, 以*结尾时匹配该前缀
+	Methods []string `json:"methods"`
+	// ResourceFields 从请求消息中取操作对象的字段, 按顺序取第一个非空的顶层字段, 如 id、username
+	ResourceFields []string `json:"resource_fields"`
+	// Sink 存储类型: file 或 db
+	Sink string `json:"sink"`
+	// File sink为file时写入的文件
+	File string `json:"file"`
+}
+
+// Event 一条审计记录
+type Event struct {
+	Time time.Time `json:"ts"`
+	// Method gRPC方法全名
+	Method string `json:"method"`
+	// Actor 调用者: user:<id>、app:<app_id> 或 anonymous
+	Actor    string `json:"actor"`
+	UserID   int64  `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	AppID    string `json:"app_id,omitempty"`
+	// Resource 操作对象, 如 id=42, 请求中没有配置的字段时为空
+	Resource string `json:"resource,omitempty"`
+	// Outcome success、denied 或 error
+	Outcome string `json:"outcome"`
+	// Code gRPC状态码, 如 OK、PermissionDenied
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty"`
+}
+
+// Sink 审计存储, 只追加
+type Sink interface {
+	Write(ctx context.Context, e *Event) error
+}
+
+var (
+	eventsTotal = metrics.NewCounterVec("audit_events_total",
+		"Number of audit events written by outcome.", "outcome")
+	writeErrors = metrics.NewCounterVec("audit_write_errors_total",
+		"Number of audit events that could not be written to the sink.")
+)
+
+// Auditor 审计拦截器
+type Auditor struct {
+	c    Config
+	sink Sink
+	now  func() time.Time
+}
+
+// New 创建Auditor
+func New(c Config, sink Sink) *Auditor {
+	return &Auditor{c: c, sink: sink, now: time.Now}
+}
+
+// UnaryServerInterceptor 在调用结束后为Methods中的方法写入一条审计记录.
+// 应放在认证之后、授权之前, 以便记录调用者身份和被拒绝的调用
+func (a *Auditor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !matchAny(info.FullMethod, a.c.Methods) {
+			return handler(ctx, req)
+		}
+		start := a.now()
+		resp, err := handler(ctx, req)
+		a.write(ctx, start, info.FullMethod, req, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 与UnaryServerInterceptor相同, 用于流式调用, 流结束时记录, 不记录操作对象
+func (a *Auditor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !matchAny(info.FullMethod, a.c.Methods) {
+			return handler(srv, ss)
+		}
+		start := a.now()
+		err := handler(srv, ss)
+		a.write(ss.Context(), start, info.FullMethod, nil, err)
+		return err
+	}
+}
+
+// write 写入审计记录, 失败时记录到应用日志, 不影响调用结果
+func (a *Auditor) write(ctx context.Context, start time.Time, method string, req interface{}, err error) {
+	e := &Event{
+		Time:      start.UTC(),
+		Method:    method,
+		Actor:     "anonymous",
+		Resource:  a.resource(req),
+		RequestID: ctxutil.RequestID(ctx),
+		ClientIP:  ctxutil.ClientIP(ctx),
+	}
+	e.TraceID, _ = ctxutil.Trace(ctx)
+	if c, ok := ctxutil.ClaimsFrom(ctx); ok {
+		e.UserID, e.Username = c.UserID, c.Username
+	} else if id, ok := ctxutil.UserID(ctx); ok {
+		e.UserID = id
+	}
+	for _, t := range ctxutil.TagsFrom(ctx).Values() {
+		if t.Key == "app_id" {
+			e.AppID = t.Value
+		}
+	}
+	switch {
+	case e.UserID != 0:
+		e.Actor = "user:" + strconv.FormatInt(e.UserID, 10)
+	case e.AppID != "":
+		e.Actor = "app:" + e.AppID
+	}
+	code := status.Code(err)
+	e.Code = code.String()
+	switch code {
+	case codes.OK:
+		e.Outcome = OutcomeSuccess
+	case codes.Unauthenticated, codes.PermissionDenied:
+		e.Outcome = OutcomeDenied
+	default:
+		e.Outcome = OutcomeError
+	}
+	// 调用方取消后仍要写入
+	wctx := ctx
+	if ctx.Err() != nil {
+		wctx = context.Background()
+	}
+	if werr := a.sink.Write(wctx, e); werr != nil {
+		writeErrors.WithLabelValues().Inc()
+		zaplog.FromContext(ctx).Error("audit: write event", zaplog.Error(werr),
+			zaplog.String("method", method), zaplog.String("actor", e.Actor), zaplog.String("outcome", e.Outcome))
+		return
+	}
+	eventsTotal.WithLabelValues(e.Outcome).Inc()
+}
+
+// resource 取请求中第一个非空的ResourceFields字段, 格式为 name=value
+func (a *Auditor) resource(req interface{}) string {
+	pm, ok := req.(proto.Message)
+	if !ok || len(a.c.ResourceFields) == 0 {
+		return ""
+	}
+	m := pm.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for _, name := range a.c.ResourceFields {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil || fd.IsList() || fd.IsMap() || fd.Message() != nil || !m.Has(fd) {
+			continue
+		}
+		return name + "=" + m.Get(fd).String()
+	}
+	return ""
+}
+
+func matchAny(method string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == method || strings.HasSuffix(p, "*") && strings.HasPrefix(method, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
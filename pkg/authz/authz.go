@@ -0,0 +1,165 @@
+// Package authz 在调用handler之前检查请求是否被授权. 授权规则由可替换的Engine决定,
+// 如OPA(规则写在Rego策略中)或配置文件中每个方法需要的角色, 代码中只负责收集输入和执行决策.
+package authz
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// 授权引擎
+const (
+	// EngineNone 不检查授权
+	EngineNone = ""
+	// EngineOPA 由Open Policy Agent按Rego策略决策
+	EngineOPA = "opa"
+	// EngineCasbin 按数据库中Casbin格式的规则决策
+	EngineCasbin = "casbin"
+	// EngineRoles 按配置中每个方法需要的角色和scope决策
+	EngineRoles = "roles"
+)
+
+// ErrorDomain 拒绝时ErrorInfo详情的domain
+const ErrorDomain = "greeter.authz"
+
+// Config 授权配置
+type Config struct {
+	// Engine 授权引擎: 空(不检查)、opa、casbin或roles
+	Engine string `json:"engine"`
+	// OPA engine为opa时的配置
+	OPA OPAConfig `json:"opa"`
+	// Casbin engine为casbin时的配置
+	Casbin CasbinConfig `json:"casbin"`
+	// Roles engine为roles时的配置
+	Roles RolesConfig `json:"roles"`
+}
+
+var (
+	// ErrDenied 策略拒绝了请求
+	ErrDenied = errs.New("authz.denied", "permission denied")
+	// ErrUnavailable 无法得到授权决策. 此时拒绝请求, 不放行
+	ErrUnavailable = errs.New("authz.unavailable", "authorization is temporarily unavailable")
+)
+
+var decisionsTotal = metrics.NewCounterVec("authz_decisions_total",
+	"Number of authorization decisions by method and result (allow, deny or error).", "method", "result")
+
+// Input 授权决策的输入
+type Input struct {
+	// Method gRPC方法全名
+	Method string
+	// Claims 认证信息, 未认证时为nil
+	Claims *ctxutil.Claims
+	// TenantID 当前租户ID
+	TenantID string
+	// Request 请求消息
+	Request proto.Message
+}
+
+// Decision 授权决策
+type Decision struct {
+	Allow bool
+	// Reason 拒绝的原因, 记录在日志中, 不返回给调用方
+	Reason string
+	// Metadata 拒绝时放入ErrorInfo详情返回给调用方的信息, 如需要的角色
+	Metadata map[string]string
+}
+
+// Engine 授权引擎, 必须并发安全
+type Engine interface {
+	Decide(ctx context.Context, in *Input) (Decision, error)
+}
+
+// New 按配置创建授权引擎, 未配置时返回nil. store用于casbin引擎, 其他引擎不使用
+func New(ctx context.Context, c Config, store RuleStore) (Engine, error) {
+	switch c.Engine {
+	case EngineNone:
+		return nil, nil
+	case EngineOPA:
+		o, err := NewOPA(ctx, c.OPA)
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	case EngineCasbin:
+		e, err := NewCasbin(ctx, c.Casbin, store)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	case EngineRoles:
+		r, err := NewRoles(c.Roles)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("authz: unknown engine %q", c.Engine)
+	}
+}
+
+// UnaryServerInterceptor 用e决定是否调用handler. 拒绝时返回PermissionDenied, details中带有ErrorInfo; 无法决策时返回Unavailable
+func UnaryServerInterceptor(e Engine) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		in := &Input{Method: info.FullMethod, TenantID: ctxutil.TenantID(ctx)}
+		in.Claims, _ = ctxutil.ClaimsFrom(ctx)
+		in.Request, _ = req.(proto.Message)
+		d, err := e.Decide(ctx, in)
+		switch {
+		case err != nil:
+			decisionsTotal.WithLabelValues(info.FullMethod, "error").Inc()
+			zaplog.FromContext(ctx).Error("authz: decide", zaplog.Error(err))
+			return nil, errs.Status(codes.Unavailable, ErrUnavailable)
+		case !d.Allow:
+			decisionsTotal.WithLabelValues(info.FullMethod, "deny").Inc()
+			zaplog.FromContext(ctx).Info("authz: request denied", zaplog.String("reason", d.Reason))
+			return nil, denied(info.FullMethod, d)
+		}
+		decisionsTotal.WithLabelValues(info.FullMethod, "allow").Inc()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 在建立流时按方法和Claims授权, Input中没有请求消息
+func StreamServerInterceptor(e Engine) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		in := &Input{Method: info.FullMethod, TenantID: ctxutil.TenantID(ctx)}
+		in.Claims, _ = ctxutil.ClaimsFrom(ctx)
+		d, err := e.Decide(ctx, in)
+		switch {
+		case err != nil:
+			decisionsTotal.WithLabelValues(info.FullMethod, "error").Inc()
+			zaplog.FromContext(ctx).Error("authz: decide", zaplog.Error(err))
+			return errs.Status(codes.Unavailable, ErrUnavailable)
+		case !d.Allow:
+			decisionsTotal.WithLabelValues(info.FullMethod, "deny").Inc()
+			zaplog.FromContext(ctx).Info("authz: request denied", zaplog.String("reason", d.Reason))
+			return denied(info.FullMethod, d)
+		}
+		decisionsTotal.WithLabelValues(info.FullMethod, "allow").Inc()
+		return handler(srv, ss)
+	}
+}
+
+// denied 返回拒绝的错误, ErrorInfo的reason为错误码, metadata中有方法名和决策给出的信息
+func denied(method string, d Decision) error {
+	meta := map[string]string{"method": method}
+	for k, v := range d.Metadata {
+		meta[k] = v
+	}
+	return errs.StatusWithDetails(codes.PermissionDenied, ErrDenied, &errdetails.ErrorInfo{
+		Reason:   ErrDenied.Code,
+		Domain:   ErrorDomain,
+		Metadata: meta,
+	})
+}
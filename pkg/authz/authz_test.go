@@ -0,0 +1,110 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	"github.com/Q1mi/greeter/pkg/testing/interceptor"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	e, err := NewRoles(RolesConfig{Methods: []MethodRoles{
+		{Method: "/helloworld.Greeter/*"},
+		{Method: "/user.UserService/*", Roles: []string{AnyRole}},
+		{Method: "/admin.AdminService/*", Roles: []string{"admin"}},
+		{Method: "/user.UserService/DeleteUser", Roles: []string{"admin"}, Scopes: []string{"users:write"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与服务中一样, 先经过jwt认证再授权
+	signer := jwt.NewSigner([]byte("test secret"), "", nil)
+	chain := interceptor.Chain(jwt.UnaryServerInterceptor(signer, nil), UnaryServerInterceptor(e))
+	token := func(roles, scopes []string) string {
+		tok, _, err := signer.Sign(&ctxutil.Claims{UserID: 1, Username: "alice", Roles: roles, Scopes: scopes}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	user, admin := token(nil, nil), token([]string{"admin"}, []string{"users:write"})
+	adminNoScope := token([]string{"admin"}, nil)
+
+	tests := []struct {
+		method string
+		tok    string
+		code   codes.Code
+		// required 拒绝时ErrorInfo中的required_roles
+		required string
+	}{
+		{"/helloworld.Greeter/SayHello", user, codes.OK, ""},
+		{"/user.UserService/GetUser", user, codes.OK, ""},
+		{"/admin.AdminService/Diagnose", user, codes.PermissionDenied, "admin"},
+		{"/admin.AdminService/Diagnose", admin, codes.OK, ""},
+		// 完全相同的方法优先于前缀规则, 并且需要全部scope
+		{"/user.UserService/DeleteUser", user, codes.PermissionDenied, "admin"},
+		{"/user.UserService/DeleteUser", adminNoScope, codes.PermissionDenied, "admin"},
+		{"/user.UserService/DeleteUser", admin, codes.OK, ""},
+		// 没有匹配规则时默认拒绝
+		{"/blog.BlogService/CreatePost", admin, codes.PermissionDenied, ""},
+	}
+	for _, tt := range tests {
+		ctx := interceptor.IncomingContext(context.Background(), "authorization", "Bearer "+tt.tok)
+		h := &interceptor.Handler{Resp: "ok"}
+		res := interceptor.Run(ctx, nil, interceptor.Info(tt.method), h.Handle, chain)
+		if status.Code(res.Err) != tt.code {
+			t.Errorf("%s: code %s, want %s", tt.method, status.Code(res.Err), tt.code)
+			continue
+		}
+		if tt.code == codes.OK {
+			if h.Calls() != 1 || res.Resp != "ok" {
+				t.Errorf("%s: handler called %d times, resp %v", tt.method, h.Calls(), res.Resp)
+			}
+			continue
+		}
+		if h.Calls() != 0 {
+			t.Errorf("%s: handler called after the request was denied", tt.method)
+		}
+		info := errorInfo(res.Err)
+		if info == nil || info.Reason != ErrDenied.Code || info.Domain != ErrorDomain || info.Metadata["method"] != tt.method {
+			t.Errorf("%s: ErrorInfo %v, want reason %s for the method", tt.method, info, ErrDenied.Code)
+			continue
+		}
+		if got := info.Metadata["required_roles"]; got != tt.required {
+			t.Errorf("%s: required_roles %q, want %q", tt.method, got, tt.required)
+		}
+	}
+}
+
+func TestUnavailableEngine(t *testing.T) {
+	h := &interceptor.Handler{}
+	res := interceptor.Run(context.Background(), nil, nil, h.Handle, UnaryServerInterceptor(failingEngine{}))
+	// 无法决策时拒绝, 不放行
+	if status.Code(res.Err) != codes.Unavailable || errs.CodeOf(res.Err) != ErrUnavailable.Code || h.Calls() != 0 {
+		t.Errorf("err = %v, handler called %d times, want Unavailable without calling the handler", res.Err, h.Calls())
+	}
+}
+
+type failingEngine struct{}
+
+func (failingEngine) Decide(context.Context, *Input) (Decision, error) {
+	return Decision{}, errors.New("opa is down")
+}
+
+// errorInfo 取出err的状态中的ErrorInfo
+func errorInfo(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
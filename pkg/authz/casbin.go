@@ -0,0 +1,266 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// CasbinModel 内置enforcer实现的Casbin模型. 规则与此模型的Casbin兼容, 可以导出给其他使用Casbin的服务.
+// 请求主体依次为用户名、Claims中的角色和 tenant:<租户ID>, 未认证时为anonymous; 规则主体为*时匹配所有请求
+const CasbinModel = `[request_definition]
+r = sub, obj
+
+[policy_definition]
+p = sub, obj, eft
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj)
+`
+
+// 规则类型
+const (
+	// PTypePolicy 访问规则: 主体, 方法, 效果
+	PTypePolicy = "p"
+	// PTypeGroup 角色继承: 主体(用户或角色), 角色
+	PTypeGroup = "g"
+)
+
+// 规则效果
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Anonymous 未认证请求的主体
+const Anonymous = "anonymous"
+
+// CasbinConfig Casbin引擎配置, 规则保存在数据库中, 通过管理服务的接口修改
+type CasbinConfig struct {
+	// Seed 数据库中没有规则时写入的初始规则, CSV格式, 如 "p, admin, /admin.AdminService/*, allow"
+	Seed []string `json:"seed"`
+	// ReloadInterval 从数据库重新加载规则的间隔, 如 "30s", 用于同步其他实例的修改; 为空时只在本实例修改后加载
+	ReloadInterval string `json:"reload_interval"`
+}
+
+// Rule 一条规则, 含义见CasbinModel
+type Rule struct {
+	PType string
+	V0    string
+	V1    string
+	V2    string
+}
+
+func (r Rule) String() string {
+	s := r.PType + ", " + r.V0 + ", " + r.V1
+	if r.V2 != "" {
+		s += ", " + r.V2
+	}
+	return s
+}
+
+// ParseRule 解析CSV格式的规则
+func ParseRule(line string) (Rule, error) {
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	var r Rule
+	switch len(parts) {
+	case 4:
+		r.V2 = parts[3]
+		fallthrough
+	case 3:
+		r.PType, r.V0, r.V1 = parts[0], parts[1], parts[2]
+	default:
+		return Rule{}, fmt.Errorf("authz: invalid rule %q", line)
+	}
+	return r, ValidateRule(r)
+}
+
+// ValidateRule 检查规则的格式
+func ValidateRule(r Rule) error {
+	if r.V0 == "" || r.V1 == "" {
+		return fmt.Errorf("authz: rule %q: subject and object are required", r)
+	}
+	switch r.PType {
+	case PTypePolicy:
+		if r.V2 != "" && r.V2 != EffectAllow && r.V2 != EffectDeny {
+			return fmt.Errorf("authz: rule %q: effect must be allow or deny", r)
+		}
+	case PTypeGroup:
+		if r.V2 != "" {
+			return fmt.Errorf("authz: rule %q: group rules take two values", r)
+		}
+	default:
+		return fmt.Errorf("authz: rule %q: ptype must be p or g", r)
+	}
+	return nil
+}
+
+// RuleStore 规则存储
+type RuleStore interface {
+	List(ctx context.Context) ([]Rule, error)
+	Add(ctx context.Context, r Rule) error
+}
+
+// Reloader 可以重新加载规则的Engine, 规则修改后调用
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+// Casbin 按CasbinModel执行数据库中的规则的Engine
+type Casbin struct {
+	store    RuleStore
+	interval time.Duration
+
+	mu       sync.RWMutex
+	policies []Rule
+	// roles 主体 -> 直接所属的角色
+	roles map[string][]string
+}
+
+// NewCasbin 创建Casbin Engine, 数据库中没有规则时先写入c.Seed
+func NewCasbin(ctx context.Context, c CasbinConfig, store RuleStore) (*Casbin, error) {
+	if store == nil {
+		return nil, errors.New("authz: casbin engine requires a rule store")
+	}
+	e := &Casbin{store: store}
+	if c.ReloadInterval != "" {
+		d, err := time.ParseDuration(c.ReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("authz: casbin.reload_interval: %w", err)
+		}
+		e.interval = d
+	}
+	rules, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authz: list rules: %w", err)
+	}
+	if len(rules) == 0 {
+		for _, line := range c.Seed {
+			r, err := ParseRule(line)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.Add(ctx, r); err != nil {
+				return nil, fmt.Errorf("authz: seed rule %q: %w", r, err)
+			}
+		}
+	}
+	if err := e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload 从数据库重新加载规则
+func (e *Casbin) Reload(ctx context.Context) error {
+	rules, err := e.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("authz: list rules: %w", err)
+	}
+	var policies []Rule
+	roles := map[string][]string{}
+	for _, r := range rules {
+		switch r.PType {
+		case PTypePolicy:
+			policies = append(policies, r)
+		case PTypeGroup:
+			roles[r.V0] = append(roles[r.V0], r.V1)
+		}
+	}
+	e.mu.Lock()
+	e.policies, e.roles = policies, roles
+	e.mu.Unlock()
+	return nil
+}
+
+// Run 每隔ReloadInterval重新加载一次规则, 直到ctx取消; 未配置间隔时直接返回
+func (e *Casbin) Run(ctx context.Context) {
+	if e.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := e.Reload(ctx); err != nil {
+				zaplog.L().Warn("authz: reload casbin rules", zaplog.Error(err))
+			}
+		}
+	}
+}
+
+func (e *Casbin) Decide(ctx context.Context, in *Input) (Decision, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	subjects := e.subjects(in)
+	var allowed string
+	for _, p := range e.policies {
+		if !keyMatch(in.Method, p.V1) || (p.V0 != "*" && !subjects[p.V0]) {
+			continue
+		}
+		if p.V2 == EffectDeny {
+			return Decision{Reason: "denied by " + p.String()}, nil
+		}
+		if allowed == "" {
+			allowed = p.String()
+		}
+	}
+	if allowed == "" {
+		return Decision{Reason: "no matching rule"}, nil
+	}
+	return Decision{Allow: true, Reason: allowed}, nil
+}
+
+// subjects 返回请求的所有主体, 包括通过g规则继承的角色
+func (e *Casbin) subjects(in *Input) map[string]bool {
+	var direct []string
+	if c := in.Claims; c != nil {
+		direct = append([]string{c.Username}, c.Roles...)
+	} else {
+		direct = []string{Anonymous}
+	}
+	if in.TenantID != "" {
+		direct = append(direct, "tenant:"+in.TenantID)
+	}
+	out := map[string]bool{}
+	var walk func(s string)
+	walk = func(s string) {
+		if s == "" || out[s] {
+			return
+		}
+		out[s] = true
+		for _, r := range e.roles[s] {
+			walk(r)
+		}
+	}
+	for _, s := range direct {
+		walk(s)
+	}
+	return out
+}
+
+// keyMatch 与Casbin的keyMatch相同: pattern中第一个*之后的部分匹配任意内容
+func keyMatch(key, pattern string) bool {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return key == pattern
+	}
+	return strings.HasPrefix(key, pattern[:i])
+}
@@ -0,0 +1,185 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/recorder"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// OPAConfig OPA配置. 决策通过OPA的REST API(POST /v1/data/<decision>)获得
+type OPAConfig struct {
+	// URL OPA服务地址, 如 http://127.0.0.1:8181
+	URL string `json:"url"`
+	// Decision 决策规则的路径, 如 greeter/authz/allow. 规则结果为布尔值,
+	// 或 {"allow": 布尔值, "reason": 字符串}; 结果未定义时拒绝
+	Decision string `json:"decision"`
+	// Policies 启动时上传到OPA的Rego文件(支持通配符), 策略ID为文件名; 为空时使用OPA中已有的策略
+	Policies []string `json:"policies"`
+	// Token 访问OPA使用的Bearer token, 可为空
+	Token string `json:"token"`
+	// Timeout 单次请求超时, 如 "500ms"
+	Timeout string `json:"timeout"`
+}
+
+// OPA 通过OPA REST API决策的Engine
+type OPA struct {
+	c      OPAConfig
+	client *http.Client
+}
+
+// NewOPA 创建OPA Engine并上传配置中的策略
+func NewOPA(ctx context.Context, c OPAConfig) (*OPA, error) {
+	if c.URL == "" || c.Decision == "" {
+		return nil, errors.New("authz: opa.url and opa.decision are required")
+	}
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	c.Decision = strings.Trim(c.Decision, "/")
+	timeout := 500 * time.Millisecond
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("authz: opa.timeout: %w", err)
+		}
+		timeout = d
+	}
+	o := &OPA{c: c, client: &http.Client{Timeout: timeout}}
+	for _, pattern := range c.Policies {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("authz: opa.policies: %w", err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("authz: opa.policies: no files match %s", pattern)
+		}
+		for _, f := range files {
+			if err := o.putPolicy(ctx, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+// putPolicy 上传一个Rego文件, 同名策略被替换
+func (o *OPA) putPolicy(ctx context.Context, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	resp, err := o.do(ctx, http.MethodPut, "/v1/policies/"+id, "text/plain", b)
+	if err != nil {
+		return fmt.Errorf("authz: upload policy %s: %w", file, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (o *OPA) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.c.Token)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("opa returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// opaInput 发送给OPA的input
+type opaInput struct {
+	Method   string          `json:"method"`
+	Service  string          `json:"service"`
+	Claims   *opaClaims      `json:"claims"`
+	TenantID string          `json:"tenant_id"`
+	Request  json.RawMessage `json:"request"`
+}
+
+type opaClaims struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	TenantID  string    `json:"tenant_id"`
+	Roles     []string  `json:"roles"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (o *OPA) Decide(ctx context.Context, in *Input) (Decision, error) {
+	oi := opaInput{Method: in.Method, TenantID: in.TenantID, Request: json.RawMessage("{}")}
+	// /pkg.Service/Method -> pkg.Service
+	if i := strings.LastIndexByte(in.Method, '/'); i > 0 {
+		oi.Service = in.Method[1:i]
+	}
+	if c := in.Claims; c != nil {
+		oi.Claims = &opaClaims{UserID: c.UserID, Username: c.Username, TenantID: c.TenantID, Roles: c.Roles, Scopes: c.Scopes, ExpiresAt: c.ExpiresAt}
+	}
+	if in.Request != nil {
+		// 密码等字段不发送给OPA, 避免出现在OPA的决策日志中
+		req := proto.Clone(in.Request)
+		recorder.Sanitize(req.ProtoReflect())
+		b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(req)
+		if err != nil {
+			return Decision{}, err
+		}
+		oi.Request = b
+	}
+	body, err := json.Marshal(struct {
+		Input opaInput `json:"input"`
+	}{oi})
+	if err != nil {
+		return Decision{}, err
+	}
+	resp, err := o.do(ctx, http.MethodPost, "/v1/data/"+o.c.Decision, "application/json", body)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return Decision{}, fmt.Errorf("decode opa response: %w", err)
+	}
+	return parseResult(out.Result)
+}
+
+// parseResult 解析决策规则的结果, 见OPAConfig.Decision
+func parseResult(r json.RawMessage) (Decision, error) {
+	if len(r) == 0 {
+		return Decision{Reason: "decision is undefined"}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(r, &allow); err == nil {
+		return Decision{Allow: allow}, nil
+	}
+	var d struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(r, &d); err != nil {
+		return Decision{}, fmt.Errorf("unexpected opa result %s", r)
+	}
+	return Decision{Allow: d.Allow, Reason: d.Reason}, nil
+}
@@ -0,0 +1,123 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AnyRole MethodRoles.Roles中表示任意已认证用户的值
+const AnyRole = "*"
+
+// MethodRoles 调用一个方法需要的角色和scope
+type MethodRoles struct {
+	// Method gRPC方法全名, 可以*结尾匹配前缀, 如 /user.UserService/*
+	Method string `json:"method"`
+	// Roles 拥有其中任意一个角色即可调用, 为 ["*"] 时任意已认证用户都可调用; 与Scopes都为空时不需要认证
+	Roles []string `json:"roles"`
+	// Scopes 必须拥有全部scope才可调用
+	Scopes []string `json:"scopes"`
+}
+
+// RolesConfig roles引擎配置, 按配置文件中方法需要的角色和scope决策, 角色和scope取自access token
+type RolesConfig struct {
+	// Methods 方法的要求, 一个方法匹配多条时使用最具体的一条: 完全相同的优先, 其次为*之前最长的
+	Methods []MethodRoles `json:"methods"`
+	// Default 没有匹配的规则时的效果: allow或deny, 为空时为deny
+	Default string `json:"default"`
+}
+
+// Roles 按方法需要的角色和scope授权
+type Roles struct {
+	methods      []MethodRoles
+	defaultAllow bool
+}
+
+// NewRoles 校验配置并创建Roles
+func NewRoles(c RolesConfig) (*Roles, error) {
+	r := &Roles{}
+	switch c.Default {
+	case "", EffectDeny:
+	case EffectAllow:
+		r.defaultAllow = true
+	default:
+		return nil, fmt.Errorf("authz: roles.default must be %s or %s, got %q", EffectAllow, EffectDeny, c.Default)
+	}
+	seen := map[string]bool{}
+	for _, m := range c.Methods {
+		if !strings.HasPrefix(m.Method, "/") {
+			return nil, fmt.Errorf("authz: roles: invalid method %q", m.Method)
+		}
+		if i := strings.Index(m.Method, "*"); i >= 0 && i != len(m.Method)-1 {
+			return nil, fmt.Errorf("authz: roles: %s: * is only allowed at the end", m.Method)
+		}
+		if seen[m.Method] {
+			return nil, fmt.Errorf("authz: roles: duplicate method %s", m.Method)
+		}
+		seen[m.Method] = true
+		r.methods = append(r.methods, m)
+	}
+	return r, nil
+}
+
+func (r *Roles) Decide(ctx context.Context, in *Input) (Decision, error) {
+	m, ok := r.match(in.Method)
+	if !ok {
+		if r.defaultAllow {
+			return Decision{Allow: true, Reason: "no matching method, default allow"}, nil
+		}
+		return Decision{Reason: "no matching method"}, nil
+	}
+	if len(m.Roles) == 0 && len(m.Scopes) == 0 {
+		return Decision{Allow: true, Reason: "public method " + m.Method}, nil
+	}
+	meta := map[string]string{}
+	if len(m.Roles) > 0 {
+		meta["required_roles"] = strings.Join(m.Roles, ",")
+	}
+	if len(m.Scopes) > 0 {
+		meta["required_scopes"] = strings.Join(m.Scopes, ",")
+	}
+	c := in.Claims
+	if c == nil {
+		return Decision{Reason: "unauthenticated", Metadata: meta}, nil
+	}
+	if len(m.Roles) > 0 && !hasAnyRole(c.Roles, m.Roles) {
+		return Decision{Reason: "missing role for " + m.Method, Metadata: meta}, nil
+	}
+	for _, s := range m.Scopes {
+		if !c.HasScope(s) {
+			return Decision{Reason: "missing scope " + s + " for " + m.Method, Metadata: meta}, nil
+		}
+	}
+	return Decision{Allow: true, Reason: "matched " + m.Method}, nil
+}
+
+// match 返回与method最具体的一条规则
+func (r *Roles) match(method string) (MethodRoles, bool) {
+	var best MethodRoles
+	bestLen := -1
+	for _, m := range r.methods {
+		if m.Method == method {
+			return m, true
+		}
+		if strings.HasSuffix(m.Method, "*") && keyMatch(method, m.Method) && len(m.Method) > bestLen {
+			best, bestLen = m, len(m.Method)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+func hasAnyRole(have, want []string) bool {
+	for _, w := range want {
+		if w == AnyRole {
+			return true
+		}
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
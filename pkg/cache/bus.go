@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/redis"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// Bus 缓存失效通知通道. 实例修改数据后发布失效的键, 其他实例收到后清除本地缓存
+type Bus interface {
+	// Publish 发布失效的键
+	Publish(ctx context.Context, keys ...string) error
+	// Subscribe 注册收到失效通知时的回调, 回调不应阻塞
+	Subscribe(fn func(keys []string))
+}
+
+// LocalBus 进程内的Bus实现, 只通知同一进程的订阅者, 用于单实例部署
+type LocalBus struct {
+	mu   sync.RWMutex
+	subs []func(keys []string)
+}
+
+// NewLocalBus 创建进程内Bus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, keys ...string) error {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(keys)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(fn func(keys []string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], fn)
+}
+
+// RedisBus 通过Redis的pub/sub在实例之间传递失效通知, 用于多实例各自使用进程内缓存的部署.
+// 本实例发布的通知也会经Redis回到自己的订阅者. 订阅连接断开期间发布的通知会丢失, 对应的缓存最多保留到过期
+type RedisBus struct {
+	c       *redis.Client
+	channel string
+	backoff time.Duration
+
+	mu   sync.RWMutex
+	subs []func(keys []string)
+}
+
+// NewRedisBus 创建在channel上收发通知的Bus, 需要调用Run才能收到通知
+func NewRedisBus(c *redis.Client, channel string) *RedisBus {
+	return &RedisBus{c: c, channel: channel, backoff: time.Second}
+}
+
+// Publish 把keys编码为JSON数组发布到channel
+func (b *RedisBus) Publish(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	msg, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	_, err = b.c.Publish(ctx, b.channel, string(msg))
+	return err
+}
+
+func (b *RedisBus) Subscribe(fn func(keys []string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], fn)
+}
+
+// Run 订阅channel并把收到的通知交给订阅者, 连接断开后重新订阅, 直到ctx取消
+func (b *RedisBus) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := b.receive(ctx); err != nil && ctx.Err() == nil {
+			zaplog.L().Warn("cache: receive invalidations", zaplog.String("channel", b.channel), zaplog.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(b.backoff):
+			}
+		}
+	}
+}
+
+// receive 建立一次订阅并接收通知, 直到连接出错或ctx取消
+func (b *RedisBus) receive(ctx context.Context) error {
+	ps, err := b.c.Subscribe(ctx, b.channel)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ps.Close()
+		case <-done:
+			ps.Close()
+		}
+	}()
+	for {
+		_, msg, err := ps.Receive()
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if err := json.Unmarshal([]byte(msg), &keys); err != nil {
+			zaplog.L().Warn("cache: invalid invalidation message", zaplog.String("message", msg), zaplog.Error(err))
+			continue
+		}
+		b.mu.RLock()
+		subs := b.subs
+		b.mu.RUnlock()
+		for _, fn := range subs {
+			fn(keys)
+		}
+	}
+}
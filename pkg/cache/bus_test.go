@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/redis"
+)
+
+// pubsubServer 只支持SUBSCRIBE和PUBLISH的Redis服务端
+type pubsubServer struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func newPubSubServer(t *testing.T) *pubsubServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pubsubServer{ln: ln, subs: map[string][]net.Conn{}}
+	t.Cleanup(func() { ln.Close(); s.dropSubscribers() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc)
+		}
+	}()
+	return s
+}
+
+func (s *pubsubServer) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		switch args[0] {
+		case "SUBSCRIBE":
+			for i, ch := range args[1:] {
+				s.subs[ch] = append(s.subs[ch], nc)
+				fmt.Fprintf(nc, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(ch), ch, i+1)
+			}
+		case "PUBLISH":
+			ch, msg := args[1], args[2]
+			for _, sub := range s.subs[ch] {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(ch), ch, len(msg), msg)
+			}
+			fmt.Fprintf(nc, ":%d\r\n", len(s.subs[ch]))
+		default:
+			fmt.Fprintf(nc, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mu.Unlock()
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var l int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &l); err != nil {
+			return nil, err
+		}
+		b := make([]byte, l+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:l])
+	}
+	return args, nil
+}
+
+// subscribers 返回channel的订阅连接数
+func (s *pubsubServer) subscribers(ch string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[ch])
+}
+
+// dropSubscribers 断开所有订阅连接
+func (s *pubsubServer) dropSubscribers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch, conns := range s.subs {
+		for _, nc := range conns {
+			nc.Close()
+		}
+		delete(s.subs, ch)
+	}
+}
+
+// waitFor 等待cond成立, 最多1s
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// recorder 记录收到的失效通知
+type recorder struct {
+	mu   sync.Mutex
+	keys [][]string
+}
+
+func (r *recorder) record(keys []string) {
+	r.mu.Lock()
+	r.keys = append(r.keys, keys)
+	r.mu.Unlock()
+}
+
+func (r *recorder) got() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.keys...)
+}
+
+func TestLocalBus(t *testing.T) {
+	b := NewLocalBus()
+	var r1, r2 recorder
+	b.Subscribe(r1.record)
+	b.Subscribe(r2.record)
+	if err := b.Publish(context.Background(), "user:1", "user:2"); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"user:1", "user:2"}}
+	if !reflect.DeepEqual(r1.got(), want) || !reflect.DeepEqual(r2.got(), want) {
+		t.Errorf("subscribers got %v and %v, want %v", r1.got(), r2.got(), want)
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	srv := newPubSubServer(t)
+	const channel = "greeter:cache:invalidations"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 两个实例各自订阅
+	var recs [2]recorder
+	var buses [2]*RedisBus
+	for i := range buses {
+		rc, err := redis.New(redis.Config{Addr: srv.ln.Addr().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		buses[i] = NewRedisBus(rc, channel)
+		buses[i].backoff = 10 * time.Millisecond
+		buses[i].Subscribe(recs[i].record)
+		go buses[i].Run(ctx)
+	}
+	waitFor(t, "both instances to subscribe", func() bool { return srv.subscribers(channel) == 2 })
+
+	if err := buses[0].Publish(ctx, "user:1", "user:2"); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"user:1", "user:2"}}
+	for i := range recs {
+		waitFor(t, fmt.Sprintf("instance %d to receive the invalidation", i), func() bool { return len(recs[i].got()) > 0 })
+		if got := recs[i].got(); !reflect.DeepEqual(got, want) {
+			t.Errorf("instance %d received %v, want %v", i, got, want)
+		}
+	}
+
+	// 订阅连接断开后重新订阅, 之后的通知照常送达
+	srv.dropSubscribers()
+	waitFor(t, "both instances to resubscribe", func() bool { return srv.subscribers(channel) == 2 })
+	if err := buses[1].Publish(ctx, "user:3"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the invalidation after resubscribing", func() bool { return len(recs[0].got()) == 2 })
+	if got := recs[0].got()[1]; !reflect.DeepEqual(got, []string{"user:3"}) {
+		t.Errorf("received %v after resubscribing, want [user:3]", got)
+	}
+}
+
+func TestRedisBusStopsWithContext(t *testing.T) {
+	srv := newPubSubServer(t)
+	rc, err := redis.New(redis.Config{Addr: srv.ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b := NewRedisBus(rc, "ch")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, "the subscription", func() bool { return srv.subscribers("ch") == 1 })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was canceled")
+	}
+}
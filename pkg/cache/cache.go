@@ -0,0 +1,117 @@
+// Package cache 定义键值缓存和跨实例失效通知接口, 并提供进程内和Redis实现
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMiss 缓存中没有该键或已过期
+var ErrMiss = errors.New("cache: miss")
+
+// Cache 键值缓存, 值为序列化后的字节, 便于替换为Redis等外部缓存
+type Cache interface {
+	// Get 读取缓存, 不存在时返回ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set 写入缓存, ttl<=0表示不过期
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete 删除缓存, 键不存在时不返回错误
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Memory 进程内LRU缓存
+type Memory struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+	onEvict func(key, reason string)
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory 创建最多保存max个键的内存缓存, 超出时淘汰最久未使用的键; max<=0表示不限制
+func NewMemory(max int) *Memory {
+	return &Memory{max: max, ll: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		m.remove(el)
+		m.evicted(e.key, EvictExpired)
+		return nil, ErrMiss
+	}
+	m.ll.MoveToFront(el)
+	return e.value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if el, ok := m.entries[key]; ok {
+		el.Value = &entry{key: key, value: value, expires: expires}
+		m.ll.MoveToFront(el)
+		return nil
+	}
+	m.entries[key] = m.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	if m.max > 0 && m.ll.Len() > m.max {
+		back := m.ll.Back()
+		m.remove(back)
+		m.evicted(back.Value.(*entry).key, EvictCapacity)
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		if el, ok := m.entries[k]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// Len 返回当前保存的键数量(包括已过期但尚未清理的键)
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len()
+}
+
+// OnEvict 设置淘汰回调, 在过期或超出容量而删除键时调用(Delete不调用); 调用时持有锁, fn不能访问m
+func (m *Memory) OnEvict(fn func(key, reason string)) {
+	m.mu.Lock()
+	m.onEvict = fn
+	m.mu.Unlock()
+}
+
+func (m *Memory) evicted(key, reason string) {
+	if m.onEvict != nil {
+		m.onEvict(key, reason)
+	}
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.ll.Remove(el)
+	delete(m.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// 淘汰原因, 用作cache_evictions_total的reason标签
+const (
+	// EvictCapacity 超出容量, 淘汰最久未使用的键
+	EvictCapacity = "capacity"
+	// EvictExpired 读取时发现已过期
+	EvictExpired = "expired"
+	// EvictStale 缓存的值与数据源不一致(如数据源已更新)
+	EvictStale = "stale"
+)
+
+var (
+	operationsTotal = metrics.NewCounterVec("cache_operations_total",
+		"Number of cache operations by cache, operation and result (hit, miss or error for get; ok or error otherwise).", "cache", "operation", "result")
+	evictionsTotal = metrics.NewCounterVec("cache_evictions_total",
+		"Number of entries removed by the cache itself, by cache and reason.", "cache", "reason")
+	entries = metrics.NewGaugeVec("cache_entries",
+		"Number of entries currently held by the cache.", "cache")
+)
+
+// Metrics 一个缓存层的指标. 各层(repo缓存、模板缓存等)使用相同的指标名, 用cache标签区分, 便于对比命中率
+type Metrics struct {
+	name string
+}
+
+// NewMetrics 创建名为name的缓存层的指标
+func NewMetrics(name string) *Metrics {
+	return &Metrics{name: name}
+}
+
+// Hit 记录一次命中
+func (m *Metrics) Hit() { operationsTotal.WithLabelValues(m.name, "get", "hit").Inc() }
+
+// Miss 记录一次未命中
+func (m *Metrics) Miss() { operationsTotal.WithLabelValues(m.name, "get", "miss").Inc() }
+
+// Result 记录一次get之外的操作, 如set、delete
+func (m *Metrics) Result(operation string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	operationsTotal.WithLabelValues(m.name, operation, result).Inc()
+}
+
+// Evict 记录一次淘汰
+func (m *Metrics) Evict(reason string) { evictionsTotal.WithLabelValues(m.name, reason).Inc() }
+
+// SetEntries 设置当前条目数
+func (m *Metrics) SetEntries(n int) { entries.WithLabelValues(m.name).Set(float64(n)) }
+
+// evictNotifier 能报告自行淘汰的缓存实现, 如Memory
+type evictNotifier interface {
+	OnEvict(fn func(key, reason string))
+}
+
+type instrumented struct {
+	m    *Metrics
+	next Cache
+}
+
+// WithMetrics 包装c, 记录Get、Set、Delete的结果. c能报告淘汰(如Memory)时同时记录淘汰和条目数
+func WithMetrics(name string, c Cache) Cache {
+	m := NewMetrics(name)
+	if n, ok := c.(evictNotifier); ok {
+		n.OnEvict(func(_, reason string) { m.Evict(reason) })
+	}
+	return &instrumented{m: m, next: c}
+}
+
+func (c *instrumented) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := c.next.Get(ctx, key)
+	switch err {
+	case nil:
+		c.m.Hit()
+	case ErrMiss:
+		c.m.Miss()
+	default:
+		c.m.Result("get", err)
+	}
+	c.updateEntries()
+	return b, err
+}
+
+func (c *instrumented) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := c.next.Set(ctx, key, value, ttl)
+	c.m.Result("set", err)
+	c.updateEntries()
+	return err
+}
+
+func (c *instrumented) Delete(ctx context.Context, keys ...string) error {
+	err := c.next.Delete(ctx, keys...)
+	c.m.Result("delete", err)
+	c.updateEntries()
+	return err
+}
+
+func (c *instrumented) updateEntries() {
+	if l, ok := c.next.(interface{ Len() int }); ok {
+		c.m.SetEntries(l.Len())
+	}
+}
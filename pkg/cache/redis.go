@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/redis"
+)
+
+// Redis 保存在Redis中的缓存, 多个实例共享, 键为prefix+key
+type Redis struct {
+	c      *redis.Client
+	prefix string
+}
+
+// NewRedis 创建Redis缓存
+func NewRedis(c *redis.Client, prefix string) *Redis {
+	return &Redis{c: c, prefix: prefix}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	s, err := redis.String(r.c.Do(ctx, "GET", r.prefix+key))
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", r.prefix + key, value}
+	if ttl > 0 {
+		// PX的最小单位为毫秒, 不足1毫秒时按1毫秒
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", ms)
+	}
+	_, err := r.c.Do(ctx, args...)
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, k := range keys {
+		args = append(args, r.prefix+k)
+	}
+	_, err := r.c.Do(ctx, args...)
+	return err
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPanicked 合并的调用中fn发生了panic
+var ErrPanicked = errors.New("cache: call panicked")
+
+// Group 合并相同键的并发调用: 同一时刻同一个键只执行一次fn, 其他调用等待并共享结果.
+// 缓存未命中时用于避免大量请求同时回源
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Do 执行fn并返回结果, 已有相同键的调用在执行时等待其结束; shared表示结果来自其他调用
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		// fn panic时也要唤醒等待者并删除记录, 等待者得到ErrPanicked
+		if !returned {
+			c.err = ErrPanicked
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	returned = true
+	return c.val, c.err, false
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/tracing"
+)
+
+type traced struct {
+	name string
+	next Cache
+}
+
+// WithTracing 包装c, 每次Get、Set、Delete记录一个子span, name为缓存名.
+// span只记录键前缀(第一个冒号之前的部分), 不记录完整的键, 避免把用户ID等写入trace
+func WithTracing(name string, c Cache) Cache {
+	return &traced{name: name, next: c}
+}
+
+func (t *traced) start(ctx context.Context, op, key string) (context.Context, *tracing.Span) {
+	ctx, s := tracing.Start(ctx, "cache."+op)
+	s.SetAttribute("cache.name", t.name)
+	s.SetAttribute("cache.key_prefix", KeyPrefix(key))
+	return ctx, s
+}
+
+func (t *traced) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, s := t.start(ctx, "get", key)
+	defer s.End()
+	b, err := t.next.Get(ctx, key)
+	s.SetAttribute("cache.hit", err == nil)
+	if err != ErrMiss {
+		s.RecordError(err)
+	}
+	return b, err
+}
+
+func (t *traced) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, s := t.start(ctx, "set", key)
+	defer s.End()
+	s.SetAttribute("cache.value_bytes", len(value))
+	err := t.next.Set(ctx, key, value, ttl)
+	s.RecordError(err)
+	return err
+}
+
+func (t *traced) Delete(ctx context.Context, keys ...string) error {
+	var first string
+	if len(keys) > 0 {
+		first = keys[0]
+	}
+	ctx, s := t.start(ctx, "delete", first)
+	defer s.End()
+	s.SetAttribute("cache.keys", len(keys))
+	err := t.next.Delete(ctx, keys...)
+	s.RecordError(err)
+	return err
+}
+
+// KeyPrefix 返回键中第一个冒号之前的部分, 没有冒号时返回空
+func KeyPrefix(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
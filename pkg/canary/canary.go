@@ -0,0 +1,88 @@
+// Package canary 在stable和canary两组后端之间按权重分配HTTP请求, 可通过请求头强制指定后端,
+// 用于在没有服务网格时进行金丝雀发布.
+package canary
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// DefaultHeader 强制选择后端的请求头, "true"表示canary, "false"表示stable
+const DefaultHeader = "X-Canary"
+
+// 后端名称, 用作指标标签
+const (
+	Stable = "stable"
+	Canary = "canary"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("canary_requests_total",
+		"Number of gateway requests by backend and HTTP status code.", "backend", "code")
+	requestDuration = metrics.NewHistogramVec("canary_request_duration_seconds",
+		"Gateway request latency by backend.", nil, "backend")
+)
+
+// Router 按权重选择后端的http.Handler
+type Router struct {
+	stable, canary http.Handler
+	// weight 分配到canary的请求百分比
+	weight float64
+	header string
+}
+
+// New 创建Router, weight为分配到canary的请求百分比(0-100), header为空时使用DefaultHeader
+func New(stable, canary http.Handler, weight float64, header string) *Router {
+	if header == "" {
+		header = DefaultHeader
+	}
+	return &Router{stable: stable, canary: canary, weight: weight, header: header}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	backend, h := Stable, rt.stable
+	if rt.pickCanary(r) {
+		backend, h = Canary, rt.canary
+	}
+	w.Header().Set(rt.header+"-Backend", backend)
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	start := time.Now()
+	h.ServeHTTP(rec, r)
+	requestDuration.WithLabelValues(backend).Observe(time.Since(start).Seconds())
+	requestsTotal.WithLabelValues(backend, strconv.Itoa(rec.code)).Inc()
+}
+
+func (rt *Router) pickCanary(r *http.Request) bool {
+	switch strings.ToLower(r.Header.Get(rt.header)) {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return rand.Float64()*100 < rt.weight
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code, r.wroteHeader = code, true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush 支持gateway的流式响应
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"context"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// BaggageInterceptor 把ctx中的baggage项(包括服务端收到的)放入outgoing metadata, 使其继续向下游传递.
+// outgoing metadata中已有baggage时不覆盖
+func BaggageInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if len(md.Get(ctxutil.BaggageHeader)) == 0 {
+			if b := ctxutil.FormatBaggage(ctxutil.BaggageItems(ctx)); b != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, ctxutil.BaggageHeader, b)
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,90 @@
+// Package client 调用本服务及其他gRPC服务的客户端工具: 默认超时、幂等方法的退避重试、对冲请求、keepalive,
+// 以及span和baggage的传递. 下游服务可以直接用NewGreeterClient或Dial, 不需要自己组装这些选项.
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Config 客户端配置
+type Config struct {
+	// Target gRPC服务地址
+	Target string `json:"target"`
+	// Timeout 调用的ctx没有deadline时一元调用的超时(包括重试), 默认10s
+	Timeout string `json:"timeout"`
+	// Retry 重试配置, max_retries为0时不重试
+	Retry RetryConfig `json:"retry"`
+	// Hedging 对冲请求配置, methods为空时不启用
+	Hedging HedgingConfig `json:"hedging"`
+	// Conn 连接keepalive和生命周期配置
+	Conn ConnConfig `json:"conn"`
+}
+
+// DefaultConfig 返回连接target的推荐配置: 5s超时, 对幂等方法最多重试2次, keepalive与服务端默认的keepalive.min_time一致
+func DefaultConfig(target string) Config {
+	return Config{
+		Target:  target,
+		Timeout: "5s",
+		Retry:   RetryConfig{MaxRetries: 2},
+		Conn: ConnConfig{
+			KeepaliveTime:    "5m",
+			KeepaliveTimeout: "20s",
+		},
+	}
+}
+
+// Dial 按配置创建到Target的连接, 调用时记录client span并传递trace context和baggage; opts追加在默认选项之后.
+// 一元调用的拦截器从外到内为: 默认超时、span、baggage、重试、对冲, 同一次调用的所有请求属于同一个span
+func Dial(c Config, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	timeout, err := duration("timeout", c.Timeout, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	dops := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(timeoutInterceptor(timeout), tracing.UnaryClientInterceptor(), BaggageInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	}
+	if c.Retry.MaxRetries > 0 {
+		r, err := NewRetry(c.Retry)
+		if err != nil {
+			return nil, err
+		}
+		dops = append(dops, grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor()))
+	}
+	if len(c.Hedging.Methods) > 0 {
+		h, err := NewHedging(c.Hedging)
+		if err != nil {
+			return nil, err
+		}
+		dops = append(dops, grpc.WithChainUnaryInterceptor(h.UnaryClientInterceptor()))
+	}
+	cops, err := c.Conn.DialOptions(nil)
+	if err != nil {
+		return nil, err
+	}
+	dops = append(dops, cops...)
+	cc, err := grpc.Dial(c.Target, append(dops, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("client: dial %s: %w", c.Target, err)
+	}
+	return cc, nil
+}
+
+// timeoutInterceptor 为没有deadline的调用设置超时
+func timeoutInterceptor(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ConnConfig 客户端连接的keepalive和生命周期配置, 时长为空表示不启用.
+// 部分中间设备会静默丢弃长时间没有流量的连接, 不开启keepalive时客户端在请求超时前无法发现这种半开连接
+type ConnConfig struct {
+	// KeepaliveTime 连接上没有活动多久后发送ping, 如 "5m"; 不能小于服务端的keepalive.min_time, 否则会被断开
+	KeepaliveTime string `json:"keepalive_time"`
+	// KeepaliveTimeout ping发出后等待响应的时间, 超时则关闭连接, 默认20s
+	KeepaliveTimeout string `json:"keepalive_timeout"`
+	// KeepaliveWithoutStream 没有进行中的请求时是否也发送ping, 需要服务端允许(permit_without_stream)
+	KeepaliveWithoutStream bool `json:"keepalive_without_stream"`
+	// IdleTimeout 连接上没有请求超过该时间后关闭并重新建立连接
+	IdleTimeout string `json:"idle_timeout"`
+	// MaxConnectionAge 连接的最长存活时间, 到期后在没有进行中的请求时关闭并重新建立连接
+	MaxConnectionAge string `json:"max_connection_age"`
+}
+
+var recycledTotal = metrics.NewCounterVec("client_connections_recycled_total",
+	"Number of client connections closed by the client by reason (idle or max_age).", "reason")
+
+// DialOptions 按配置生成连接选项. dial用于建立底层连接, 为nil时使用TCP.
+// grpc会立即重新建立被关闭的连接, 因此IdleTimeout和MaxConnectionAge的作用是定期更换连接, 而不是保持断开
+func (c ConnConfig) DialOptions(dial func(ctx context.Context, addr string) (net.Conn, error)) ([]grpc.DialOption, error) {
+	var (
+		kp       keepalive.ClientParameters
+		idle     time.Duration
+		maxAge   time.Duration
+		opts     []grpc.DialOption
+		parseErr error
+	)
+	parse := func(name, v string, d *time.Duration) {
+		if v == "" || parseErr != nil {
+			return
+		}
+		if *d, parseErr = time.ParseDuration(v); parseErr == nil && *d <= 0 {
+			parseErr = fmt.Errorf("must be positive")
+		}
+		if parseErr != nil {
+			parseErr = fmt.Errorf("client: invalid %s %q: %w", name, v, parseErr)
+		}
+	}
+	parse("keepalive_time", c.KeepaliveTime, &kp.Time)
+	parse("keepalive_timeout", c.KeepaliveTimeout, &kp.Timeout)
+	parse("idle_timeout", c.IdleTimeout, &idle)
+	parse("max_connection_age", c.MaxConnectionAge, &maxAge)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if kp.Time > 0 {
+		kp.PermitWithoutStream = c.KeepaliveWithoutStream
+		opts = append(opts, grpc.WithKeepaliveParams(kp))
+	}
+	if idle > 0 || maxAge > 0 {
+		m := newConnManager(idle, maxAge, dial)
+		opts = append(opts,
+			grpc.WithContextDialer(m.dial),
+			grpc.WithChainUnaryInterceptor(m.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(m.streamInterceptor))
+	} else if dial != nil {
+		opts = append(opts, grpc.WithContextDialer(dial))
+	}
+	return opts, nil
+}
+
+// connManager 在没有进行中的请求时关闭空闲或到期的连接, grpc会自动重新连接.
+// 进行中的请求按DialOptions返回的选项统计(gateway的多个ClientConn共用), 只在完全没有请求时才关闭, 不会中断请求
+type connManager struct {
+	idle, maxAge time.Duration
+	next         func(ctx context.Context, addr string) (net.Conn, error)
+
+	mu       sync.Mutex
+	inflight int
+	// lastActive 最后一个请求结束的时间
+	lastActive time.Time
+	conns      map[*managedConn]bool
+	// running 检查协程是否在运行
+	running bool
+}
+
+type managedConn struct {
+	net.Conn
+	m       *connManager
+	created time.Time
+}
+
+func (c *managedConn) Close() error {
+	c.m.mu.Lock()
+	delete(c.m.conns, c)
+	c.m.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func newConnManager(idle, maxAge time.Duration, dial func(ctx context.Context, addr string) (net.Conn, error)) *connManager {
+	if dial == nil {
+		var d net.Dialer
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return &connManager{idle: idle, maxAge: maxAge, next: dial, conns: map[*managedConn]bool{}}
+}
+
+func (m *connManager) dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := m.next(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &managedConn{Conn: conn, m: m, created: time.Now()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 有连接时才运行检查, 连接都关闭后退出
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+	m.conns[c] = true
+	return c, nil
+}
+
+// run 定期检查连接, 直到没有连接
+func (m *connManager) run() {
+	interval := m.idle
+	if m.maxAge > 0 && (interval == 0 || m.maxAge < interval) {
+		interval = m.maxAge
+	}
+	interval /= 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !m.recycle(time.Now()) {
+			return
+		}
+	}
+}
+
+// recycle 关闭空闲或到期的连接, 返回是否还有连接
+func (m *connManager) recycle(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() { m.running = len(m.conns) > 0 }()
+	if m.inflight > 0 {
+		return len(m.conns) > 0
+	}
+	for c := range m.conns {
+		reason := ""
+		switch {
+		case m.maxAge > 0 && now.Sub(c.created) >= m.maxAge:
+			reason = "max_age"
+		case m.idle > 0 && now.Sub(c.created) >= m.idle && now.Sub(m.lastActive) >= m.idle:
+			reason = "idle"
+		default:
+			continue
+		}
+		// 持有锁期间不会有新请求开始, 已选中该连接但还未发出的请求由grpc透明重试
+		delete(m.conns, c)
+		c.Conn.Close()
+		recycledTotal.WithLabelValues(reason).Inc()
+	}
+	return len(m.conns) > 0
+}
+
+func (m *connManager) begin() {
+	m.mu.Lock()
+	m.inflight++
+	m.mu.Unlock()
+}
+
+func (m *connManager) end() {
+	m.mu.Lock()
+	m.inflight--
+	m.lastActive = time.Now()
+	m.mu.Unlock()
+}
+
+func (m *connManager) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	m.begin()
+	defer m.end()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (m *connManager) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	m.begin()
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		m.end()
+		return nil, err
+	}
+	ts := &trackedStream{ClientStream: s, finished: make(chan struct{})}
+	ts.end = func() { ts.once.Do(func() { close(ts.finished); m.end() }) }
+	// 调用方不读到流结束就取消时, 以ctx结束为准
+	go func() {
+		select {
+		case <-ctx.Done():
+			ts.end()
+		case <-ts.finished:
+		}
+	}()
+	return ts, nil
+}
+
+// trackedStream 在流结束(RecvMsg返回错误)时结束计数
+type trackedStream struct {
+	grpc.ClientStream
+	once     sync.Once
+	finished chan struct{}
+	end      func()
+}
+
+func (s *trackedStream) RecvMsg(msg interface{}) error {
+	err := s.ClientStream.RecvMsg(msg)
+	if err != nil {
+		s.end()
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	"google.golang.org/grpc"
+)
+
+// GreeterClient helloworld.Greeter服务的客户端, 用完后调用Close关闭连接
+type GreeterClient struct {
+	helloworldpb.GreeterClient
+	conn *grpc.ClientConn
+}
+
+// NewGreeterClient 按配置连接Greeter服务, 通常以DefaultConfig为基础修改:
+//
+//	c, err := client.NewGreeterClient(client.DefaultConfig("greeter:8091"))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	resp, err := c.SayHello(ctx, &helloworldpb.HelloRequest{Name: "q1mi"})
+func NewGreeterClient(c Config, opts ...grpc.DialOption) (*GreeterClient, error) {
+	cc, err := Dial(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GreeterClient{GreeterClient: helloworldpb.NewGreeterClient(cc), conn: cc}, nil
+}
+
+// Conn 底层连接, 可用于创建同一地址上其他服务的客户端
+func (c *GreeterClient) Conn() *grpc.ClientConn { return c.conn }
+
+// Close 关闭连接
+func (c *GreeterClient) Close() error { return c.conn.Close() }
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// HedgingConfig 对冲请求配置, 语义与gRPC service config中的hedgingPolicy相同:
+// 首次请求发出后每隔Delay再发送一次, 最多MaxAttempts次; 第一个成功或返回致命错误的结果被采用, 其余请求取消.
+// 只应对幂等方法启用
+type HedgingConfig struct {
+	// Methods 启用对冲的方法(gRPC方法全名)
+	Methods []string `json:"methods"`
+	// MaxAttempts 包括首次在内的最多请求次数, 2-5
+	MaxAttempts int `json:"max_attempts"`
+	// Delay 发送下一次请求前的等待时间, 如 "50ms"; 为0时同时发出所有请求
+	Delay string `json:"delay"`
+	// NonFatalCodes 不采用、立即发送下一次请求的状态码, 如 ["UNAVAILABLE"]
+	NonFatalCodes []string `json:"non_fatal_codes"`
+}
+
+// maxHedgingAttempts 与gRPC的限制相同
+const maxHedgingAttempts = 5
+
+// previousAttemptsKey gRPC约定的metadata, 值为此前已发送的请求次数
+const previousAttemptsKey = "grpc-previous-rpc-attempts"
+
+var (
+	hedgedCallsTotal = metrics.NewCounterVec("client_hedged_calls_total",
+		"Number of client calls to methods with hedging enabled.", "method")
+	hedgesTotal = metrics.NewCounterVec("client_hedges_total",
+		"Number of additional hedged attempts sent; divide by client_hedged_calls_total for the hedge rate.", "method")
+	hedgeWinsTotal = metrics.NewCounterVec("client_hedge_wins_total",
+		"Number of hedged calls by the attempt whose result was used (0 is the original request).", "method", "attempt")
+)
+
+// Hedging 对冲请求拦截器
+type Hedging struct {
+	methods     map[string]bool
+	maxAttempts int
+	delay       time.Duration
+	nonFatal    map[codes.Code]bool
+}
+
+// NewHedging 校验配置并创建Hedging
+func NewHedging(c HedgingConfig) (*Hedging, error) {
+	if c.MaxAttempts < 2 || c.MaxAttempts > maxHedgingAttempts {
+		return nil, fmt.Errorf("client: hedging max_attempts must be in [2, %d], got %d", maxHedgingAttempts, c.MaxAttempts)
+	}
+	h := &Hedging{methods: map[string]bool{}, maxAttempts: c.MaxAttempts, nonFatal: map[codes.Code]bool{}}
+	if c.Delay != "" {
+		d, err := time.ParseDuration(c.Delay)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("client: invalid hedging delay %q", c.Delay)
+		}
+		h.delay = d
+	}
+	for _, m := range c.Methods {
+		h.methods[m] = true
+	}
+	for _, s := range c.NonFatalCodes {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+			return nil, fmt.Errorf("client: unknown status code %q in hedging non_fatal_codes", s)
+		}
+		h.nonFatal[code] = true
+	}
+	return h, nil
+}
+
+// attempt 一次请求的结果
+type attempt struct {
+	n               int
+	reply           proto.Message
+	header, trailer metadata.MD
+	err             error
+}
+
+// UnaryClientInterceptor 对配置的方法发送对冲请求, 其他方法直接调用
+func (h *Hedging) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		msg, ok := reply.(proto.Message)
+		if !h.methods[method] || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		hedgedCallsTotal.WithLabelValues(method).Inc()
+
+		// 每次请求使用各自的响应和header/trailer, 采用的结果再复制给调用方
+		var headerAddr, trailerAddr *metadata.MD
+		callOpts := make([]grpc.CallOption, 0, len(opts)+2)
+		for _, o := range opts {
+			switch o := o.(type) {
+			case grpc.HeaderCallOption:
+				headerAddr = o.HeaderAddr
+			case grpc.TrailerCallOption:
+				trailerAddr = o.TrailerAddr
+			default:
+				callOpts = append(callOpts, o)
+			}
+		}
+
+		// 返回时取消未完成的请求
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		results := make(chan *attempt, h.maxAttempts)
+		send := func(n int) {
+			a := &attempt{n: n, reply: msg.ProtoReflect().New().Interface()}
+			actx := ctx
+			if n > 0 {
+				hedgesTotal.WithLabelValues(method).Inc()
+				actx = metadata.AppendToOutgoingContext(ctx, previousAttemptsKey, strconv.Itoa(n))
+			}
+			o := append(callOpts[:len(callOpts):len(callOpts)], grpc.Header(&a.header), grpc.Trailer(&a.trailer))
+			go func() {
+				a.err = invoker(actx, method, req, a.reply, cc, o...)
+				results <- a
+			}()
+		}
+
+		send(0)
+		sent, done := 1, 0
+		timer := time.NewTimer(h.delay)
+		defer timer.Stop()
+		var last *attempt
+	wait:
+		for done < sent {
+			select {
+			case <-timer.C:
+				if sent < h.maxAttempts {
+					send(sent)
+					sent++
+					timer.Reset(h.delay)
+				}
+			case a := <-results:
+				done++
+				last = a
+				if a.err == nil || !h.nonFatal[status.Code(a.err)] {
+					break wait
+				}
+				// 非致命错误: 不再等待, 立即发送下一次
+				if sent < h.maxAttempts {
+					send(sent)
+					sent++
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(h.delay)
+				}
+			}
+		}
+
+		hedgeWinsTotal.WithLabelValues(method, strconv.Itoa(last.n)).Inc()
+		if headerAddr != nil {
+			*headerAddr = last.header
+		}
+		if trailerAddr != nil {
+			*trailerAddr = last.trailer
+		}
+		if last.err != nil {
+			return last.err
+		}
+		proto.Reset(msg)
+		proto.Merge(msg, last.reply)
+		return nil
+	}
+}
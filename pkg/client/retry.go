@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// RetryConfig 一元调用的重试配置. 只重试幂等方法: proto中idempotency_level为NO_SIDE_EFFECTS或IDEMPOTENT的方法
+// 和Methods中的方法, 因为返回UNAVAILABLE或DEADLINE_EXCEEDED时请求可能已经被服务端处理
+type RetryConfig struct {
+	// MaxRetries 最多重试次数, 为0时不重试
+	MaxRetries int `json:"max_retries"`
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍, 默认100ms
+	Backoff string `json:"backoff"`
+	// MaxBackoff 单次等待的上限, 默认2s
+	MaxBackoff string `json:"max_backoff"`
+	// PerAttemptTimeout 每次请求的超时, 为空时只受调用的deadline限制.
+	// 设置后单次请求超时而调用的deadline未到时会重试
+	PerAttemptTimeout string `json:"per_attempt_timeout"`
+	// Codes 重试的状态码, 默认 ["UNAVAILABLE", "DEADLINE_EXCEEDED"]
+	Codes []string `json:"codes"`
+	// Methods 另外允许重试的方法(gRPC方法全名), 以*结尾时匹配该前缀
+	Methods []string `json:"methods"`
+}
+
+var defaultRetryCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+
+var retriesTotal = metrics.NewCounterVec("client_retries_total",
+	"Number of client call retries by method and the status code that caused the retry.", "method", "code")
+
+// Retry 按指数退避重试失败调用的拦截器
+type Retry struct {
+	maxRetries        int
+	backoff           time.Duration
+	maxBackoff        time.Duration
+	perAttemptTimeout time.Duration
+	codes             map[codes.Code]bool
+	methods           []string
+	// idempotent 缓存方法是否幂等
+	idempotent sync.Map
+}
+
+// NewRetry 校验配置并创建Retry
+func NewRetry(c RetryConfig) (*Retry, error) {
+	if c.MaxRetries < 0 {
+		return nil, fmt.Errorf("client: retry max_retries must not be negative, got %d", c.MaxRetries)
+	}
+	r := &Retry{maxRetries: c.MaxRetries, codes: map[codes.Code]bool{}, methods: c.Methods}
+	var err error
+	if r.backoff, err = duration("retry backoff", c.Backoff, 100*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if r.maxBackoff, err = duration("retry max_backoff", c.MaxBackoff, 2*time.Second); err != nil {
+		return nil, err
+	}
+	if r.perAttemptTimeout, err = duration("retry per_attempt_timeout", c.PerAttemptTimeout, 0); err != nil {
+		return nil, err
+	}
+	for _, s := range c.Codes {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+			return nil, fmt.Errorf("client: unknown status code %q in retry codes", s)
+		}
+		r.codes[code] = true
+	}
+	if len(r.codes) == 0 {
+		for _, code := range defaultRetryCodes {
+			r.codes[code] = true
+		}
+	}
+	return r, nil
+}
+
+// UnaryClientInterceptor 重试幂等方法返回的Codes中的错误, 调用的ctx结束后不再重试.
+// 与Hedging同时启用时放在它外层, 每次重试都是一次完整的对冲请求
+func (r *Retry) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		retryable := r.maxRetries > 0 && r.canRetry(method)
+		for attempt := 0; ; attempt++ {
+			err := r.attempt(ctx, method, req, reply, cc, invoker, attempt, opts)
+			code := status.Code(err)
+			if err == nil || !retryable || attempt >= r.maxRetries || ctx.Err() != nil || !r.codes[code] {
+				return err
+			}
+			retriesTotal.WithLabelValues(method, code.String()).Inc()
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(r.wait(attempt)):
+			}
+		}
+	}
+}
+
+// attempt 发送一次请求, 重试的请求带有grpc-previous-rpc-attempts
+func (r *Retry) attempt(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, attempt int, opts []grpc.CallOption) error {
+	if attempt > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, previousAttemptsKey, strconv.Itoa(attempt))
+	}
+	if r.perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.perAttemptTimeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// wait 第attempt次失败后的等待时间: 指数退避加随机抖动
+func (r *Retry) wait(attempt int) time.Duration {
+	d := r.backoff << uint(attempt)
+	if d <= 0 || d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	// 在[d/2, d)之间随机, 避免多个实例同时重试
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// canRetry 方法是否可以安全地重新发送
+func (r *Retry) canRetry(method string) bool {
+	for _, p := range r.methods {
+		if p == method || strings.HasSuffix(p, "*") && strings.HasPrefix(method, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	if v, ok := r.idempotent.Load(method); ok {
+		return v.(bool)
+	}
+	ok := idempotent(method)
+	r.idempotent.Store(method, ok)
+	return ok
+}
+
+// idempotent 按编译进程序的proto描述判断方法是否幂等, 找不到描述时为false
+func idempotent(method string) bool {
+	name := strings.TrimPrefix(method, "/")
+	i := strings.LastIndexByte(name, '/')
+	if i < 0 {
+		return false
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return false
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return false
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return false
+	}
+	opts, _ := md.Options().(*descriptorpb.MethodOptions)
+	switch opts.GetIdempotencyLevel() {
+	case descriptorpb.MethodOptions_NO_SIDE_EFFECTS, descriptorpb.MethodOptions_IDEMPOTENT:
+		return true
+	}
+	return false
+}
+
+func duration(key, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("client: invalid %s %q", key, s)
+	}
+	return d, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	// 注册proto描述, 按其中的idempotency_level判断是否重试
+	_ "github.com/Q1mi/greeter/proto/admin"
+	_ "github.com/Q1mi/greeter/proto/blog"
+	_ "github.com/Q1mi/greeter/proto/helloworld"
+	_ "github.com/Q1mi/greeter/proto/helloworld/v2"
+	_ "github.com/Q1mi/greeter/proto/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeInvoker 记录调用次数, 每次返回fn的结果
+type fakeInvoker struct {
+	mu       sync.Mutex
+	calls    int
+	attempts []string
+	fn       func(ctx context.Context) error
+}
+
+func (f *fakeInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	f.mu.Lock()
+	f.calls++
+	f.attempts = append(f.attempts, md.Get(previousAttemptsKey)...)
+	f.mu.Unlock()
+	return f.fn(ctx)
+}
+
+func (f *fakeInvoker) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func unavailable(context.Context) error { return status.Error(codes.Unavailable, "down") }
+
+func newTestRetry(t *testing.T, c RetryConfig) grpc.UnaryClientInterceptor {
+	t.Helper()
+	if c.Backoff == "" {
+		c.Backoff = "1ms"
+	}
+	r, err := NewRetry(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.UnaryClientInterceptor()
+}
+
+func TestRetryOnlyIdempotentMethods(t *testing.T) {
+	tests := []struct {
+		method  string
+		methods []string
+		calls   int
+	}{
+		// SayHello每次调用都会写入问候记录, 不能重试
+		{"/helloworld.Greeter/SayHello", nil, 1},
+		{"/helloworld.Greeter/ListGreetings", nil, 4},
+		{"/helloworld.Greeter/GetStats", nil, 4},
+		{"/grpc.greeter.helloworld.v2.Greeter/ListGreetings", nil, 4},
+		{"/user.UserService/GetUser", nil, 4},
+		{"/user.UserService/ListUsers", nil, 4},
+		{"/blog.BlogService/GetBlog", nil, 4},
+		{"/blog.BlogService/ListBlogs", nil, 4},
+		{"/admin.AdminService/ListPolicies", nil, 4},
+		{"/admin.AdminService/GetReport", nil, 4},
+		{"/admin.AdminService/ListEmailTemplates", nil, 4},
+		{"/admin.AdminService/GetUsage", nil, 4},
+		// 没有标注idempotency_level的方法不重试
+		{"/user.UserService/CreateUser", nil, 1},
+		{"/admin.AdminService/AddPolicy", nil, 1},
+		{"/unknown.Service/Method", nil, 1},
+		// 在methods中明确允许后重试
+		{"/helloworld.Greeter/SayHello", []string{"/helloworld.Greeter/*"}, 4},
+	}
+	for _, tt := range tests {
+		f := &fakeInvoker{fn: unavailable}
+		err := newTestRetry(t, RetryConfig{MaxRetries: 3, Methods: tt.methods})(context.Background(), tt.method, nil, nil, nil, f.invoke)
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("%s: err = %v, want Unavailable", tt.method, err)
+		}
+		if f.count() != tt.calls {
+			t.Errorf("%s (methods %v): %d calls, want %d", tt.method, tt.methods, f.count(), tt.calls)
+		}
+	}
+}
+
+func TestRetryCodes(t *testing.T) {
+	const method = "/helloworld.Greeter/ListGreetings"
+	// 默认只重试UNAVAILABLE和DEADLINE_EXCEEDED
+	f := &fakeInvoker{fn: func(context.Context) error { return status.Error(codes.InvalidArgument, "bad") }}
+	newTestRetry(t, RetryConfig{MaxRetries: 3})(context.Background(), method, nil, nil, nil, f.invoke)
+	if f.count() != 1 {
+		t.Errorf("InvalidArgument retried: %d calls", f.count())
+	}
+
+	// 成功后不再重试, 重试的请求带有grpc-previous-rpc-attempts
+	f = &fakeInvoker{}
+	f.fn = func(context.Context) error {
+		if f.calls < 3 {
+			return status.Error(codes.Unavailable, "down")
+		}
+		return nil
+	}
+	if err := newTestRetry(t, RetryConfig{MaxRetries: 5})(context.Background(), method, nil, nil, nil, f.invoke); err != nil {
+		t.Fatal(err)
+	}
+	if f.count() != 3 || len(f.attempts) != 2 || f.attempts[0] != "1" || f.attempts[1] != "2" {
+		t.Errorf("calls %d, previous attempts %v, want 3 calls with [1 2]", f.count(), f.attempts)
+	}
+
+	if _, err := NewRetry(RetryConfig{Codes: []string{"NOT_A_CODE"}}); err == nil {
+		t.Error("unknown code accepted")
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	const method = "/helloworld.Greeter/ListGreetings"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	f := &fakeInvoker{fn: unavailable}
+	start := time.Now()
+	err := newTestRetry(t, RetryConfig{MaxRetries: 1000, Backoff: "10ms", MaxBackoff: "20ms"})(ctx, method, nil, nil, nil, f.invoke)
+	elapsed := time.Since(start)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("err = %v, want the last Unavailable", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("retried for %s after the 100ms deadline", elapsed)
+	}
+	if n := f.count(); n < 2 || n >= 1000 {
+		t.Errorf("%d calls, want a few retries stopped by the deadline", n)
+	}
+}
+
+func TestRetryPerAttemptTimeout(t *testing.T) {
+	const method = "/helloworld.Greeter/ListGreetings"
+	// 每次请求都在单次超时后失败, 调用的deadline到达后不再重试
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	f := &fakeInvoker{fn: func(ctx context.Context) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}}
+	start := time.Now()
+	err := newTestRetry(t, RetryConfig{MaxRetries: 1000, PerAttemptTimeout: "20ms"})(ctx, method, nil, nil, nil, f.invoke)
+	elapsed := time.Since(start)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("retried for %s after the 150ms deadline", elapsed)
+	}
+	if n := f.count(); n < 2 || n > 10 {
+		t.Errorf("%d calls, want several attempts of 20ms within 150ms", n)
+	}
+}
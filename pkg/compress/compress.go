@@ -0,0 +1,171 @@
+// Package compress 对HTTP响应进行gzip压缩. 响应先缓存到MinSize字节再决定是否压缩, 较小的响应原样返回.
+// gRPC的gzip由grpc/encoding/gzip提供, 客户端使用grpc.UseCompressor("gzip")时请求和响应都会压缩.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Config HTTP压缩配置
+type Config struct {
+	// Enabled 是否压缩HTTP响应
+	Enabled bool `json:"enabled"`
+	// MinSize 响应达到该字节数才压缩, 更小的响应压缩后收益不大
+	MinSize int `json:"min_size"`
+	// Level gzip压缩级别, 1(最快)到9(最小), 0为默认级别
+	Level int `json:"level"`
+}
+
+// Validate 检查配置
+func (c Config) Validate() error {
+	if c.MinSize < 0 {
+		return fmt.Errorf("compress: min_size must not be negative")
+	}
+	if c.Level < 0 || c.Level > gzip.BestCompression {
+		return fmt.Errorf("compress: level must be in [0, 9], got %d", c.Level)
+	}
+	return nil
+}
+
+type handler struct {
+	next    http.Handler
+	minSize int
+	pool    sync.Pool
+}
+
+// Handler 对接受gzip的请求压缩next的响应, 未启用时直接返回next. c应已通过Validate
+func Handler(c Config, next http.Handler) http.Handler {
+	if !c.Enabled {
+		return next
+	}
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	h := &handler{next: next, minSize: c.MinSize}
+	h.pool.New = func() interface{} {
+		zw, _ := gzip.NewWriterLevel(nil, level)
+		return zw
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	rw := &responseWriter{ResponseWriter: w, h: h, code: http.StatusOK}
+	defer rw.close()
+	h.next.ServeHTTP(rw, r)
+}
+
+// acceptsGzip 按Accept-Encoding判断客户端是否接受gzip, 忽略q=0的项
+func acceptsGzip(v string) bool {
+	for _, part := range strings.Split(v, ",") {
+		enc := strings.TrimSpace(part)
+		q := ""
+		if i := strings.IndexByte(enc, ';'); i >= 0 {
+			enc, q = strings.TrimSpace(enc[:i]), strings.ReplaceAll(enc[i+1:], " ", "")
+		}
+		if (strings.EqualFold(enc, "gzip") || enc == "*") && q != "q=0" && q != "q=0.0" {
+			return true
+		}
+	}
+	return false
+}
+
+// responseWriter 缓存响应的开头, 达到minSize或handler结束时决定是否压缩
+type responseWriter struct {
+	http.ResponseWriter
+	h    *handler
+	code int
+	buf  []byte
+	// started 已写出响应头
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.code = code
+	// 没有响应体的状态码不需要等待
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		w.start(false)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.h.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush 流式响应需要立即发送时, 按已缓存的大小决定是否压缩
+func (w *responseWriter) Flush() {
+	if !w.started {
+		w.start(len(w.buf) >= w.h.minSize)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start 写出响应头和已缓存的数据
+func (w *responseWriter) start(compress bool) error {
+	w.started = true
+	hdr := w.ResponseWriter.Header()
+	if hdr.Get("Content-Encoding") != "" {
+		compress = false
+	}
+	if compress {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		w.gz = w.h.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *responseWriter) close() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.h.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Q1mi/greeter/pkg/httpclient"
+)
+
+// 配置来源
+const (
+	// SourceFile 只使用本地配置文件
+	SourceFile = "file"
+	// SourceConsul 使用Consul KV中的配置
+	SourceConsul = "consul"
+	// SourceEtcd 使用etcd v3中的配置, 通过etcd的HTTP/JSON网关访问
+	SourceEtcd = "etcd"
+)
+
+// Source 配置来源. 为consul或etcd时先读取本地配置文件, 再用远端key中的JSON覆盖, 多个实例可以共用一份集中管理的配置;
+// source本身只能在本地文件中配置
+type Source struct {
+	// Type file、consul 或 etcd
+	Type string `json:"type"`
+	// Addr 服务地址, 如 "http://127.0.0.1:8500"、"http://127.0.0.1:2379"
+	Addr string `json:"addr"`
+	// Key 保存JSON配置的key, 如 "greeter/config"
+	Key string `json:"key"`
+	// Token Consul的ACL token, 可以为空
+	Token string `json:"token"`
+	// Client HTTP客户端的超时和重试
+	Client httpclient.Config `json:"client"`
+}
+
+// remote 远端配置存储
+type remote interface {
+	// fetch 返回key的内容和版本, 版本在内容变化后改变
+	fetch(ctx context.Context) ([]byte, string, error)
+}
+
+func newRemote(s Source) (remote, error) {
+	if s.Addr == "" || s.Key == "" {
+		return nil, fmt.Errorf("config: source.addr and source.key are required when source.type is %s", s.Type)
+	}
+	hc, err := httpclient.New("config_"+s.Type, s.Client)
+	if err != nil {
+		return nil, fmt.Errorf("config: source.client: %w", err)
+	}
+	addr := strings.TrimRight(s.Addr, "/")
+	switch s.Type {
+	case SourceConsul:
+		return &consulKV{addr: addr, key: strings.TrimLeft(s.Key, "/"), token: s.Token, hc: hc}, nil
+	case SourceEtcd:
+		return &etcdKV{addr: addr, key: s.Key, hc: hc}, nil
+	}
+	return nil, fmt.Errorf("config: unknown source.type %q", s.Type)
+}
+
+// consulKV Consul KV HTTP API
+type consulKV struct {
+	addr, key, token string
+	hc               *http.Client
+}
+
+func (c *consulKV) fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+"/v1/kv/"+(&url.URL{Path: c.key}).EscapedPath(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	b, err := doRemote(c.hc, req)
+	if err != nil || b == nil {
+		return nil, "", notFound("consul", c.key, err)
+	}
+	var kvs []struct {
+		ModifyIndex uint64 `json:"ModifyIndex"`
+		Value       []byte `json:"Value"`
+	}
+	if err := json.Unmarshal(b, &kvs); err != nil {
+		return nil, "", fmt.Errorf("consul: decode %s: %w", c.key, err)
+	}
+	if len(kvs) == 0 {
+		return nil, "", notFound("consul", c.key, nil)
+	}
+	return kvs[0].Value, fmt.Sprint(kvs[0].ModifyIndex), nil
+}
+
+// etcdKV etcd v3的HTTP/JSON网关
+type etcdKV struct {
+	addr, key string
+	hc        *http.Client
+}
+
+func (e *etcdKV) fetch(ctx context.Context) ([]byte, string, error) {
+	body, _ := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(e.key))})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.addr+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	b, err := doRemote(e.hc, req)
+	if err != nil || b == nil {
+		return nil, "", notFound("etcd", e.key, err)
+	}
+	var r struct {
+		Kvs []struct {
+			Value       []byte `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, "", fmt.Errorf("etcd: decode %s: %w", e.key, err)
+	}
+	if len(r.Kvs) == 0 {
+		return nil, "", notFound("etcd", e.key, nil)
+	}
+	return r.Kvs[0].Value, r.Kvs[0].ModRevision, nil
+}
+
+// doRemote 发送请求并返回200响应的body, 404时返回nil
+func doRemote(hc *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// notFound 请求失败时返回err, 否则返回key不存在的错误
+func notFound(store, key string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", store, err)
+	}
+	return fmt.Errorf("%s: key %s not found", store, key)
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// Secrets 密钥引用的解析配置. 配置中的字符串可以包含 ${file:<路径>} 或 ${vault:<路径>#<字段>},
+// 加载时替换为密钥的值, 如 "db": {"dsn": "mysql://greeter:${file:/run/secrets/db_password}@tcp(db:3306)/greeter"}
+type Secrets struct {
+	// CacheTTL 密钥值的缓存时间, 提供方给出有效期(如Vault的lease_duration)时使用其有效期; 为0时不缓存
+	CacheTTL Duration `json:"cache_ttl"`
+	// Vault HashiCorp Vault配置, addr为空时不能使用${vault:...}
+	Vault VaultConfig `json:"vault"`
+}
+
+// SecretProvider 按引用读取密钥, 返回值及其有效期, 有效期为0表示未知
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, time.Duration, error)
+}
+
+// FileSecrets 挂载的密钥文件, 如Kubernetes Secret或Docker secret. 引用为文件路径, 值去掉末尾的换行
+type FileSecrets struct{}
+
+func (FileSecrets) Secret(_ context.Context, ref string) (string, time.Duration, error) {
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimRight(string(b), "\r\n"), 0, nil
+}
+
+// secretRef 匹配 ${scheme:ref}
+var secretRef = regexp.MustCompile(`\$\{([a-z]+):([^}]+)\}`)
+
+// SecretResolver 替换字符串中的密钥引用, 读取的值按有效期缓存, 配置重新加载时不必每次访问提供方
+type SecretResolver struct {
+	providers map[string]SecretProvider
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// NewSecretResolver 创建SecretResolver, providers的key为引用中的scheme, 如file、vault
+func NewSecretResolver(ttl time.Duration, providers map[string]SecretProvider) *SecretResolver {
+	return &SecretResolver{providers: providers, ttl: ttl, now: time.Now, cache: map[string]cachedSecret{}}
+}
+
+// Expand 替换s中的所有密钥引用. 错误信息只包含引用, 不包含密钥的值
+func (r *SecretResolver) Expand(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var firstErr error
+	out := secretRef.ReplaceAllStringFunc(s, func(m string) string {
+		if firstErr != nil {
+			return m
+		}
+		sub := secretRef.FindStringSubmatch(m)
+		v, err := r.secret(ctx, sub[1], sub[2])
+		if err != nil {
+			firstErr = fmt.Errorf("%s: %w", m, err)
+			return m
+		}
+		return v
+	})
+	return out, firstErr
+}
+
+func (r *SecretResolver) secret(ctx context.Context, scheme, ref string) (string, error) {
+	p, ok := r.providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("no secret provider for %q", scheme)
+	}
+	key := scheme + ":" + ref
+	now := r.now()
+	r.mu.Lock()
+	c, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.value, nil
+	}
+	v, ttl, err := p.Secret(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		ttl = r.ttl
+	}
+	if ttl > 0 {
+		r.mu.Lock()
+		r.cache[key] = cachedSecret{value: v, expires: now.Add(ttl)}
+		r.mu.Unlock()
+	}
+	return v, nil
+}
+
+// ExpandConfig 替换c中所有字符串(包括切片和map中的)里的密钥引用, 出错时指出所在的配置项
+func (r *SecretResolver) ExpandConfig(ctx context.Context, c *Config) error {
+	return r.expandValue(ctx, reflect.ValueOf(c).Elem(), "")
+}
+
+func (r *SecretResolver) expandValue(ctx context.Context, v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := r.Expand(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return r.expandValue(ctx, v.Elem(), path)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := r.expandValue(ctx, v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.expandValue(ctx, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			s, err := r.Expand(ctx, iter.Value().String())
+			if err != nil {
+				return fmt.Errorf("config: %s.%v: %w", path, iter.Key(), err)
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(s).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+var (
+	secretsMu sync.Mutex
+	// resolver 最近一次Load使用的SecretResolver, vault配置不变时重复使用以保留缓存
+	resolver    *SecretResolver
+	vault       *Vault
+	vaultConfig VaultConfig
+)
+
+// expandSecrets 替换c中的密钥引用. secrets.vault中的值只能引用文件, 如 "token": "${file:/var/run/secrets/vault-token}"
+func expandSecrets(c *Config) error {
+	vc := c.Secrets.Vault
+	if err := expandFiles(&vc.Addr, &vc.Token, &vc.Namespace); err != nil {
+		return fmt.Errorf("config: secrets.vault: %w", err)
+	}
+	c.Secrets.Vault = vc
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if resolver == nil || vc != vaultConfig || resolver.ttl != c.Secrets.CacheTTL.D() {
+		providers := map[string]SecretProvider{"file": FileSecrets{}}
+		var v *Vault
+		if vc.Addr != "" {
+			var err error
+			if v, err = NewVault(vc); err != nil {
+				return fmt.Errorf("config: secrets.vault: %w", err)
+			}
+			providers["vault"] = v
+		}
+		resolver, vault, vaultConfig = NewSecretResolver(c.Secrets.CacheTTL.D(), providers), v, vc
+	}
+	return resolver.ExpandConfig(context.Background(), c)
+}
+
+// expandFiles 替换ss中的${file:...}引用, 用于在其他密钥来源可用之前解析的配置项
+func expandFiles(ss ...*string) error {
+	files := NewSecretResolver(0, map[string]SecretProvider{"file": FileSecrets{}})
+	for _, s := range ss {
+		var err error
+		if *s, err = files.Expand(context.Background(), *s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenewSecrets 在Vault token到期前续期, 直到ctx取消; 未配置Vault或token不可续期时直接返回.
+// 配置重新加载后换用新的Vault配置
+func RenewSecrets(ctx context.Context) {
+	for {
+		secretsMu.Lock()
+		v := vault
+		secretsMu.Unlock()
+		if v == nil {
+			return
+		}
+		wait := 30 * time.Second
+		ttl, err := v.RenewToken(ctx)
+		switch {
+		case err != nil:
+			zaplog.L().Warn("config: renew vault token", zaplog.Error(err))
+		case ttl <= 0:
+			// 不会过期或不可续期的token
+			return
+		default:
+			wait = ttl / 2
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
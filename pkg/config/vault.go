@@ -0,0 +1,123 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/httpclient"
+)
+
+// VaultConfig HashiCorp Vault连接配置
+type VaultConfig struct {
+	// Addr 服务地址, 如 "https://vault.example.com:8200"
+	Addr string `json:"addr"`
+	// Token 访问token, 为空时使用环境变量VAULT_TOKEN; 可以引用文件, 如 "${file:/var/run/secrets/vault-token}"
+	Token string `json:"token"`
+	// Namespace Vault企业版的命名空间, 可以为空
+	Namespace string `json:"namespace"`
+	// Client HTTP客户端的超时和重试
+	Client httpclient.Config `json:"client"`
+}
+
+// Vault 从Vault读取密钥. 引用格式为 <路径>#<字段>, 路径为去掉/v1/的API路径,
+// 如KV v2引擎的 secret/data/greeter#db_password
+type Vault struct {
+	addr, token, namespace string
+	hc                     *http.Client
+}
+
+// NewVault 创建Vault客户端
+func NewVault(c VaultConfig) (*Vault, error) {
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault: token is required, set secrets.vault.token or VAULT_TOKEN")
+	}
+	hc, err := httpclient.New("vault", c.Client)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{addr: strings.TrimRight(c.Addr, "/"), token: token, namespace: c.Namespace, hc: hc}, nil
+}
+
+// vaultResponse Vault接口的响应, 只包含用到的字段
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *Vault) Secret(ctx context.Context, ref string) (string, time.Duration, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return "", 0, fmt.Errorf("vault: reference must be <path>#<field>")
+	}
+	path, field := ref[:i], ref[i+1:]
+	var r vaultResponse
+	if err := v.do(ctx, http.MethodGet, path, &r); err != nil {
+		return "", 0, err
+	}
+	data := r.Data
+	// KV v2的值在data.data中, 同时有data.metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	s, ok := data[field].(string)
+	if !ok {
+		return "", 0, fmt.Errorf("vault: %s has no string field %q", path, field)
+	}
+	return s, time.Duration(r.LeaseDuration) * time.Second, nil
+}
+
+// RenewToken 续期token, 返回续期后的有效期; token不可续期时返回0
+func (v *Vault) RenewToken(ctx context.Context) (time.Duration, error) {
+	var r vaultResponse
+	if err := v.do(ctx, http.MethodPost, "auth/token/renew-self", &r); err != nil {
+		return 0, err
+	}
+	if r.Auth == nil || !r.Auth.Renewable {
+		return 0, nil
+	}
+	return time.Duration(r.Auth.LeaseDuration) * time.Second, nil
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, out *vaultResponse) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("vault: read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, out); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("vault: decode %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: %s %s: status %d: %s", method, path, resp.StatusCode, strings.Join(out.Errors, "; "))
+	}
+	return nil
+}
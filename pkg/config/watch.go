@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// Watcher 定期检查配置文件和远端配置(source.type为consul或etcd时), 有变化且新配置合法时通知订阅了变化项的回调.
+// 已创建的组件不会自动使用新配置, 只有通过OnChange订阅的项可以不重启生效
+type Watcher struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cur     *Config
+	version string
+	subs    []subscription
+}
+
+type subscription struct {
+	key string
+	fn  func(c *Config)
+}
+
+// NewWatcher 创建Watcher, c为启动时从path加载的配置
+func NewWatcher(path string, c *Config, interval time.Duration) *Watcher {
+	w := &Watcher{path: path, interval: interval, cur: c}
+	w.version, _ = w.currentVersion()
+	return w
+}
+
+// OnChange 订阅配置项的变化, key为以.分隔的JSON字段路径, 如 "log.level"、"auth.api_key.keys", 为空时订阅任意变化.
+// fn在该项的值变化后以新配置调用, 按订阅顺序依次执行, 不应阻塞
+func (w *Watcher) OnChange(key string, fn func(c *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, subscription{key: key, fn: fn})
+}
+
+// Current 返回最近一次加载成功的配置, 调用方不应修改
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cur
+}
+
+// Reload 配置有变化时重新加载并通知订阅者, 返回是否重新加载. 新配置不合法时返回错误并保留原有的配置
+func (w *Watcher) Reload() (bool, error) {
+	version, err := w.currentVersion()
+	if err != nil {
+		return false, err
+	}
+	w.mu.Lock()
+	unchanged := version == w.version
+	w.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+	c, rv, err := load(w.path)
+	if err != nil {
+		return false, err
+	}
+	// 以加载时的远端版本为准, 检查版本之后的修改在下次检查时发现
+	version = joinVersion(version, rv)
+	w.mu.Lock()
+	old := w.cur
+	w.cur, w.version = c, version
+	subs := w.subs
+	w.mu.Unlock()
+
+	before, after := tree(old), tree(c)
+	for _, s := range subs {
+		if s.key == "" || !reflect.DeepEqual(lookup(before, s.key), lookup(after, s.key)) {
+			s.fn(c)
+		}
+	}
+	return true, nil
+}
+
+// Run 每隔interval检查一次文件变化, 直到ctx取消; interval不大于0时直接返回
+func (w *Watcher) Run(ctx context.Context) {
+	if w.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reloaded, err := w.Reload()
+			if err != nil {
+				zaplog.L().Warn("config: reload", zaplog.String("path", w.path), zaplog.Error(err))
+			} else if reloaded {
+				zaplog.L().Info("config: reloaded", zaplog.String("path", w.path))
+			}
+		}
+	}
+}
+
+// currentVersion 返回配置文件和远端配置的当前版本
+func (w *Watcher) currentVersion() (string, error) {
+	v, err := fileVersion(w.path)
+	if err != nil {
+		return "", err
+	}
+	w.mu.Lock()
+	src := w.cur.Source
+	w.mu.Unlock()
+	if src.Type == SourceFile {
+		return v, nil
+	}
+	_, rv, err := fetchRemote(src)
+	if err != nil {
+		return "", err
+	}
+	return joinVersion(v, rv), nil
+}
+
+// joinVersion 合并文件版本和远端版本; v中已有远端版本时替换
+func joinVersion(v, remote string) string {
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		v = v[:i]
+	}
+	if remote == "" {
+		return v
+	}
+	return v + "/" + remote
+}
+
+// fileVersion 以大小和修改时间标识文件内容
+func fileVersion(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// tree 把c转换为JSON对应的map, 便于按字段路径比较
+func tree(c *Config) interface{} {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	json.Unmarshal(b, &v)
+	return v
+}
+
+func lookup(v interface{}, key string) interface{} {
+	for _, k := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
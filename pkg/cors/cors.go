@@ -0,0 +1,199 @@
+// Package cors 为HTTP接口处理跨域请求(CORS), 使浏览器中的页面可以直接调用REST接口.
+// 预检请求(OPTIONS)由这里直接应答, 不转发给gateway.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 跨域配置, AllowedOrigins为空时不处理跨域请求
+type Config struct {
+	// AllowedOrigins 允许的来源, 如 https://app.example.com; "*"允许所有来源, https://*.example.com 允许其子域名
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods 允许的方法, 为空时为GET、POST、PUT、PATCH、DELETE
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders 允许的请求头, "*"允许所有请求头. CORS安全的请求头(如Accept、Content-Language)总是允许
+	AllowedHeaders []string `json:"allowed_headers"`
+	// ExposedHeaders 允许页面读取的响应头, 如 X-Request-Id
+	ExposedHeaders []string `json:"exposed_headers"`
+	// MaxAge 浏览器缓存预检结果的时间, 如 "10m", 为空时不设置
+	MaxAge string `json:"max_age"`
+	// AllowCredentials 是否允许携带Cookie和Authorization等凭据, 为true时不能使用"*"来源
+	AllowCredentials bool `json:"allow_credentials"`
+}
+
+// Enabled 是否处理跨域请求
+func (c Config) Enabled() bool { return len(c.AllowedOrigins) > 0 }
+
+// Validate 检查配置
+func (c Config) Validate() error {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" && c.AllowCredentials {
+			return fmt.Errorf("cors: allowed_origins must not contain * when allow_credentials is true")
+		}
+		if i := strings.Index(o, "*"); i >= 0 && o != "*" && !strings.HasPrefix(o[i:], "*.") {
+			return fmt.Errorf("cors: invalid origin pattern %q", o)
+		}
+	}
+	if c.MaxAge != "" {
+		if _, err := time.ParseDuration(c.MaxAge); err != nil {
+			return fmt.Errorf("cors: invalid max_age: %w", err)
+		}
+	}
+	return nil
+}
+
+var defaultMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// safeHeaders CORS安全的请求头, 不需要配置即可使用
+var safeHeaders = map[string]bool{"accept": true, "accept-language": true, "content-language": true, "content-type": true}
+
+type handler struct {
+	next        http.Handler
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   []wildcard
+	methods     map[string]bool
+	methodList  string
+	anyHeader   bool
+	headers     map[string]bool
+	exposed     string
+	maxAge      string
+	credentials bool
+}
+
+// Handler 为next处理跨域请求, c应已通过Validate. 未启用时直接返回next
+func Handler(c Config, next http.Handler) http.Handler {
+	if !c.Enabled() {
+		return next
+	}
+	h := &handler{next: next, origins: map[string]bool{}, methods: map[string]bool{}, headers: map[string]bool{},
+		exposed: strings.Join(c.ExposedHeaders, ", "), credentials: c.AllowCredentials}
+	for _, o := range c.AllowedOrigins {
+		switch {
+		case o == "*":
+			h.anyOrigin = true
+		case strings.Contains(o, "*."):
+			// https://*.example.com -> 前缀https://、后缀.example.com
+			i := strings.Index(o, "*.")
+			h.wildcards = append(h.wildcards, wildcard{strings.ToLower(o[:i]), strings.ToLower(o[i+1:])})
+		default:
+			h.origins[strings.ToLower(o)] = true
+		}
+	}
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	for _, m := range methods {
+		h.methods[strings.ToUpper(m)] = true
+	}
+	h.methodList = strings.ToUpper(strings.Join(methods, ", "))
+	for _, hd := range c.AllowedHeaders {
+		if hd == "*" {
+			h.anyHeader = true
+		}
+		h.headers[strings.ToLower(hd)] = true
+	}
+	if c.MaxAge != "" {
+		d, _ := time.ParseDuration(c.MaxAge)
+		h.maxAge = strconv.Itoa(int(d / time.Second))
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+	if !h.allowOrigin(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// 不带CORS头, 由浏览器拒绝页面读取响应
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if !preflight {
+		h.setOrigin(w, origin)
+		if h.exposed != "" {
+			w.Header().Set("Access-Control-Expose-Headers", h.exposed)
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var reqHeaders []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, hd := range strings.Split(v, ",") {
+			if hd = strings.ToLower(strings.TrimSpace(hd)); hd != "" {
+				reqHeaders = append(reqHeaders, hd)
+			}
+		}
+	}
+	for _, hd := range reqHeaders {
+		if !h.anyHeader && !h.headers[hd] && !safeHeaders[hd] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	h.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", h.methodList)
+	if len(reqHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if h.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", h.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOrigin 设置允许的来源和凭据头
+func (h *handler) setOrigin(w http.ResponseWriter, origin string) {
+	if h.anyOrigin && !h.credentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if h.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (h *handler) allowOrigin(origin string) bool {
+	if h.anyOrigin {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if h.origins[o] {
+		return true
+	}
+	for _, w := range h.wildcards {
+		if len(o) > len(w.prefix)+len(w.suffix) && strings.HasPrefix(o, w.prefix) && strings.HasSuffix(o, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard 子域名通配的来源, 如 https://*.example.com
+type wildcard struct {
+	prefix, suffix string
+}
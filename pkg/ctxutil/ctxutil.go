@@ -0,0 +1,329 @@
+// Package ctxutil 统一管理放在context中的请求信息:
+// 用户ID、租户ID、请求ID、trace ID、baggage、认证信息(Claims)、客户端IP和请求标签.
+// 拦截器、logic和repo层都应通过这里的函数读写, 不要自行定义context key.
+package ctxutil
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	tenantIDKey
+	requestIDKey
+	claimsKey
+	clientIPKey
+	traceKey
+	tagsKey
+	baggageKey
+	sampledKey
+)
+
+// Claims 认证后得到的用户信息
+type Claims struct {
+	UserID   int64
+	Username string
+	TenantID string
+	Roles    []string
+	// Scopes token被授予的权限范围
+	Scopes    []string
+	ExpiresAt time.Time
+	// TokenID 认证使用的token的ID, 用于撤销
+	TokenID string
+	// SessionID 签发token的登录, 即refresh token的族ID, 登出时据此作废refresh token
+	SessionID string
+}
+
+// HasRole 是否拥有指定角色
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope 是否拥有指定scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// WithUserID 设置当前用户ID
+func WithUserID(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// UserID 返回当前用户ID; 未设置时从Claims中取
+func UserID(ctx context.Context) (int64, bool) {
+	if id, ok := ctx.Value(userIDKey).(int64); ok {
+		return id, true
+	}
+	if c, ok := ClaimsFrom(ctx); ok && c.UserID != 0 {
+		return c.UserID, true
+	}
+	return 0, false
+}
+
+// WithTenantID 设置当前租户ID
+func WithTenantID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantIDKey, id)
+}
+
+// TenantID 返回当前租户ID; 未设置时从Claims中取
+func TenantID(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantIDKey).(string); ok {
+		return id
+	}
+	if c, ok := ClaimsFrom(ctx); ok {
+		return c.TenantID
+	}
+	return ""
+}
+
+// RequestIDHeader 传递请求ID使用的metadata key
+const RequestIDHeader = "x-request-id"
+
+// WithRequestID 设置请求ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID 返回请求ID; 未设置时取incoming metadata中的x-request-id
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		return id
+	}
+	return firstMD(ctx, RequestIDHeader)
+}
+
+// TraceParentHeader 传递W3C Trace Context使用的metadata key
+const TraceParentHeader = "traceparent"
+
+type traceIDs struct {
+	traceID, spanID string
+}
+
+// WithTrace 设置当前trace ID和span ID
+func WithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceKey, traceIDs{traceID, spanID})
+}
+
+// Trace 返回当前trace ID和span ID, 未设置时为空
+func Trace(ctx context.Context) (traceID, spanID string) {
+	t, _ := ctx.Value(traceKey).(traceIDs)
+	return t.traceID, t.spanID
+}
+
+// WithSampled 设置当前trace是否被采样导出
+func WithSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, sampledKey, sampled)
+}
+
+// Sampled 返回当前trace是否被采样导出, 尚未决定时ok为false
+func Sampled(ctx context.Context) (sampled, ok bool) {
+	sampled, ok = ctx.Value(sampledKey).(bool)
+	return sampled, ok
+}
+
+// BaggageHeader 传递W3C Baggage使用的metadata key
+const BaggageHeader = "baggage"
+
+// maxBaggageItems 最多解析的baggage项数, 与W3C Baggage规范的要求一致
+const maxBaggageItems = 64
+
+// WithBaggage 设置一个baggage项(如实验ID、来源渠道), 随请求向下游传递
+func WithBaggage(ctx context.Context, key, value string) context.Context {
+	old := BaggageItems(ctx)
+	items := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		items[k] = v
+	}
+	items[key] = value
+	return context.WithValue(ctx, baggageKey, items)
+}
+
+// Baggage 返回baggage项, 不存在时为空
+func Baggage(ctx context.Context, key string) string {
+	return BaggageItems(ctx)[key]
+}
+
+// BaggageItems 返回所有baggage项, 调用方不应修改返回的map.
+// 没有通过WithBaggage设置过时取incoming metadata中的baggage
+func BaggageItems(ctx context.Context) map[string]string {
+	if items, ok := ctx.Value(baggageKey).(map[string]string); ok {
+		return items
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return ParseBaggage(md.Get(BaggageHeader))
+}
+
+// ParseBaggage 解析baggage头, 格式: key1=value1;prop,key2=value2; 忽略属性和格式错误的项
+func ParseBaggage(headers []string) map[string]string {
+	var items map[string]string
+	for _, h := range headers {
+		for _, member := range strings.Split(h, ",") {
+			if len(items) >= maxBaggageItems {
+				return items
+			}
+			if i := strings.IndexByte(member, ';'); i >= 0 {
+				member = member[:i]
+			}
+			i := strings.IndexByte(member, '=')
+			if i <= 0 {
+				continue
+			}
+			key := strings.TrimSpace(member[:i])
+			value, err := url.PathUnescape(strings.TrimSpace(member[i+1:]))
+			if key == "" || err != nil {
+				continue
+			}
+			if items == nil {
+				items = map[string]string{}
+			}
+			items[key] = value
+		}
+	}
+	return items
+}
+
+// FormatBaggage 把baggage项编码为baggage头, 没有项时返回空
+func FormatBaggage(items map[string]string) string {
+	members := make([]string, 0, len(items))
+	for k, v := range items {
+		members = append(members, k+"="+url.PathEscape(v))
+	}
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+// WithClaims 设置认证信息
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, c)
+}
+
+// ClaimsFrom 返回认证信息
+func ClaimsFrom(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*Claims)
+	return c, ok && c != nil
+}
+
+// WithClientIP 设置客户端IP
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP 返回客户端IP.
+// 依次取显式设置的值、x-forwarded-for(经gateway转发时)的第一个地址、gRPC peer地址.
+func ClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIPKey).(string); ok {
+		return ip
+	}
+	if xff := firstMD(ctx, "x-forwarded-for"); xff != "" {
+		if i := strings.IndexByte(xff, ','); i >= 0 {
+			xff = xff[:i]
+		}
+		return strings.TrimSpace(xff)
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// Tag 一个请求标签
+type Tag struct {
+	Key, Value string
+}
+
+// Tags 请求标签, 由最外层的拦截器放入ctx; 内层拦截器和handler添加的标签,
+// 外层在请求结束后也能看到(如访问日志). 并发安全, nil值可以直接使用
+type Tags struct {
+	mu   sync.Mutex
+	tags []Tag
+}
+
+// Set 设置标签, key已存在时覆盖原值
+func (t *Tags) Set(key, value string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.tags {
+		if t.tags[i].Key == key {
+			t.tags[i].Value = value
+			return
+		}
+	}
+	t.tags = append(t.tags, Tag{key, value})
+}
+
+// Values 按添加顺序返回所有标签
+func (t *Tags) Values() []Tag {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Tag(nil), t.tags...)
+}
+
+// WithTags 在ctx中放入新的空标签集合
+func WithTags(ctx context.Context) (context.Context, *Tags) {
+	t := &Tags{}
+	return context.WithValue(ctx, tagsKey, t), t
+}
+
+// TagsFrom 返回ctx中的标签集合, 没有时返回nil
+func TagsFrom(ctx context.Context) *Tags {
+	t, _ := ctx.Value(tagsKey).(*Tags)
+	return t
+}
+
+// serverStream 替换了Context的grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// WrapServerStream 返回Context为ctx的ss, 供流式拦截器向handler传递修改后的ctx
+func WrapServerStream(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	if w, ok := ss.(*serverStream); ok {
+		return &serverStream{ServerStream: w.ServerStream, ctx: ctx}
+	}
+	return &serverStream{ServerStream: ss, ctx: ctx}
+}
+
+func firstMD(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if vs := md.Get(key); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+// Package deprecation 标记已废弃的方法: 调用时在trailer中返回废弃信息, 经gateway转换为
+// Deprecation、Sunset等HTTP响应头, 并按方法统计调用次数, 用于衡量调用方的迁移进度.
+package deprecation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Method 一个废弃的方法
+type Method struct {
+	// Method gRPC方法全名, 如 /helloworld.Greeter/SayHello
+	Method string `json:"method"`
+	// Since 开始废弃的时间, RFC3339或 2006-01-02 格式, 可为空
+	Since string `json:"since"`
+	// Sunset 计划下线的时间, 格式同Since, 可为空
+	Sunset string `json:"sunset"`
+	// Message 给调用方的提示, 如替代接口
+	Message string `json:"message"`
+	// Link 迁移说明文档的地址, 可为空
+	Link string `json:"link"`
+}
+
+// Config 废弃方法配置
+type Config struct {
+	Methods []Method `json:"methods"`
+}
+
+// TrailerHeaders gateway需要转换为HTTP响应头的trailer, 与server.trailer_headers合并使用
+var TrailerHeaders = map[string]string{
+	"deprecation": "Deprecation",
+	"sunset":      "Sunset",
+	"link":        "Link",
+	"warning":     "Warning",
+}
+
+var requestsTotal = metrics.NewCounterVec("deprecated_requests_total",
+	"Number of calls to deprecated methods.", "method")
+
+// Notifier 为废弃方法附加废弃信息
+type Notifier struct {
+	// trailers 方法 -> 附加的trailer
+	trailers map[string]metadata.MD
+}
+
+// New 校验配置并创建Notifier
+func New(c Config) (*Notifier, error) {
+	n := &Notifier{trailers: map[string]metadata.MD{}}
+	for _, m := range c.Methods {
+		if m.Method == "" {
+			return nil, fmt.Errorf("deprecation: method is required")
+		}
+		// 取值遵循RFC 9745(Deprecation)和RFC 8594(Sunset)
+		md := metadata.Pairs("deprecation", "true")
+		if m.Since != "" {
+			t, err := parseTime(m.Since)
+			if err != nil {
+				return nil, fmt.Errorf("deprecation: %s: since: %w", m.Method, err)
+			}
+			md.Set("deprecation", "@"+strconv.FormatInt(t.Unix(), 10))
+		}
+		if m.Sunset != "" {
+			t, err := parseTime(m.Sunset)
+			if err != nil {
+				return nil, fmt.Errorf("deprecation: %s: sunset: %w", m.Method, err)
+			}
+			md.Set("sunset", t.UTC().Format(http.TimeFormat))
+		}
+		if m.Link != "" {
+			md.Set("link", fmt.Sprintf("<%s>; rel=\"deprecation\"", m.Link))
+		}
+		msg := m.Message
+		if msg == "" {
+			msg = "this method is deprecated"
+		}
+		md.Set("warning", fmt.Sprintf("299 - %q", msg))
+		n.trailers[m.Method] = md
+	}
+	return n, nil
+}
+
+// Deprecated 方法是否已废弃
+func (n *Notifier) Deprecated(method string) bool {
+	_, ok := n.trailers[method]
+	return ok
+}
+
+// UnaryServerInterceptor 调用废弃方法时设置trailer并计数
+func (n *Notifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := n.trailers[info.FullMethod]; ok {
+			requestsTotal.WithLabelValues(info.FullMethod).Inc()
+			// 出错时同样返回, 不影响请求本身
+			grpc.SetTrailer(ctx, md)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,111 @@
+// Package drain 在停止服务前摘除流量: 把gRPC健康状态设为NOT_SERVING并向HTTP/2连接发送GOAWAY,
+// 然后等待负载均衡的探测间隔, 使按健康状态选择后端的客户端在连接关闭前就不再发送新请求.
+package drain
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/health"
+)
+
+// Drainer 停止前的摘流步骤, 并统计进行中的HTTP请求以便等待它们完成
+type Drainer struct {
+	health *health.Server
+	delay  time.Duration
+	// goaway 只用于触发http2.Server的GOAWAY, 不监听端口
+	goaway *http.Server
+
+	mu       sync.Mutex
+	inflight int
+	draining bool
+}
+
+// New 创建Drainer. hs为nil时不修改健康状态; delay为发出通知后等待的时间, 应不小于负载均衡的健康检查间隔
+func New(hs *health.Server, delay time.Duration) *Drainer {
+	return &Drainer{health: hs, delay: delay}
+}
+
+// HTTP2 在Drain时向h2s上的所有连接发送GOAWAY, 必须在h2s开始处理连接之前调用.
+// http2.Server只在http.Server.Shutdown时发送GOAWAY, 而Shutdown会关闭监听, 因此注册到一个单独的http.Server上
+func (d *Drainer) HTTP2(h2s *http2.Server) error {
+	d.goaway = &http.Server{}
+	return http2.ConfigureServer(d.goaway, h2s)
+}
+
+// Handler 统计经过h的进行中请求, Shutdown等待它们完成
+func (d *Drainer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.inflight++
+		d.mu.Unlock()
+		defer func() {
+			d.mu.Lock()
+			d.inflight--
+			d.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Drain 把所有服务的健康状态设为NOT_SERVING并发送GOAWAY, 然后等待delay或ctx结束. 之后应调用Shutdown或GracefulStop
+func (d *Drainer) Drain(ctx context.Context) {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+	if d.health != nil {
+		// Shutdown之后的状态更新都会被忽略, 不会被依赖检查改回SERVING
+		d.health.Shutdown()
+	}
+	if d.goaway != nil {
+		// 没有监听和连接, 只执行http2注册的GOAWAY回调, 立即返回
+		d.goaway.Shutdown(ctx)
+	}
+	if d.delay <= 0 {
+		return
+	}
+	log.Printf("drain: health set to NOT_SERVING, waiting %s for load balancers", d.delay)
+	t := time.NewTimer(d.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// Draining 是否已开始Drain
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Shutdown 关闭srv的监听, 再次向HTTP/2连接发送GOAWAY(包括等待期间新建立的连接), 并等待进行中的请求完成.
+// h2c连接被劫持后不受srv.Shutdown管理, 因此按Handler统计的请求等待
+func (d *Drainer) Shutdown(ctx context.Context, srv *http.Server) error {
+	if d.goaway != nil {
+		d.goaway.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		d.mu.Lock()
+		n := d.inflight
+		d.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
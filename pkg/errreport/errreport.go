@@ -0,0 +1,376 @@
+// Package errreport 把error级别的日志上报到Sentry或通用webhook, 生产环境的错误不必从日志中检索就能发现.
+// Reporter作为zaplog的Hook使用: Report只把事件放入有界队列, 队列满时丢弃, 不阻塞记录日志的请求.
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// 上报目标类型
+const (
+	// TypeNone 不上报
+	TypeNone = ""
+	// TypeSentry 通过DSN发送到Sentry的store接口
+	TypeSentry = "sentry"
+	// TypeWebhook 以JSON POST到任意URL, 如告警网关或IM机器人的中转服务
+	TypeWebhook = "webhook"
+)
+
+// Config 错误上报配置
+type Config struct {
+	// Type 目标类型: 空(不上报)、sentry或webhook
+	Type string `json:"type"`
+	// DSN Sentry的DSN, 如 https://<key>@sentry.example.com/<project>
+	DSN string `json:"dsn"`
+	// URL webhook地址
+	URL string `json:"url"`
+	// Headers webhook附加的请求头, 如鉴权
+	Headers map[string]string `json:"headers"`
+	// Level 上报的最低日志级别, 默认error
+	Level string `json:"level"`
+	// Service, Env, Release 附加到每个事件, ServerName为空时使用主机名
+	Service    string `json:"service"`
+	Env        string `json:"env"`
+	Release    string `json:"release"`
+	ServerName string `json:"server_name"`
+	// QueueSize 等待发送的事件上限, 超出时丢弃新的事件, 默认100
+	QueueSize int `json:"queue_size"`
+	// Timeout 单次发送超时, 如 "5s"
+	Timeout string `json:"timeout"`
+}
+
+var (
+	eventsReported = metrics.NewCounterVec("errreport_events_reported_total",
+		"Number of error events sent to the error reporting backend.")
+	eventsDropped = metrics.NewCounterVec("errreport_events_dropped_total",
+		"Number of error events dropped by reason (queue_full or send_failed).", "reason")
+)
+
+// sender 把一个事件编码为目标服务的请求
+type sender interface {
+	request(ctx context.Context, e *event) (*http.Request, error)
+}
+
+// event 待发送的事件
+type event struct {
+	id string
+	zaplog.Entry
+}
+
+// Reporter 错误上报器, 实现zaplog.Hook
+type Reporter struct {
+	level  zaplog.Level
+	labels labels
+	s      sender
+	client *http.Client
+	ch     chan *event
+	// failing 上一个事件是否发送失败, 只在状态变化时打印, 避免目标不可用时刷屏
+	failing bool
+}
+
+// labels 附加到每个事件的信息
+type labels struct {
+	service, env, release, serverName string
+}
+
+// New 创建上报器, 需调用Run开始发送
+func New(c Config) (*Reporter, error) {
+	r := &Reporter{level: zaplog.ErrorLevel}
+	if c.Level != "" {
+		l, err := zaplog.ParseLevel(c.Level)
+		if err != nil {
+			return nil, fmt.Errorf("errreport: level: %w", err)
+		}
+		r.level = l
+	}
+	if c.Service == "" {
+		c.Service = "greeter"
+	}
+	if c.ServerName == "" {
+		c.ServerName, _ = os.Hostname()
+	}
+	r.labels = labels{service: c.Service, env: c.Env, release: c.Release, serverName: c.ServerName}
+	switch c.Type {
+	case TypeSentry:
+		s, err := newSentry(c.DSN, r.labels)
+		if err != nil {
+			return nil, err
+		}
+		r.s = s
+	case TypeWebhook:
+		if c.URL == "" {
+			return nil, errors.New("errreport: url is required")
+		}
+		r.s = &webhook{url: c.URL, headers: c.Headers, labels: r.labels}
+	default:
+		return nil, fmt.Errorf("errreport: unknown type %q", c.Type)
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 100
+	}
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("errreport: timeout: %w", err)
+		}
+		timeout = d
+	}
+	r.client = &http.Client{Timeout: timeout}
+	r.ch = make(chan *event, c.QueueSize)
+	return r, nil
+}
+
+// Level 上报的最低级别, 用于zaplog.WithHook
+func (r *Reporter) Level() zaplog.Level { return r.level }
+
+// Report 把日志放入发送队列, 队列满时丢弃
+func (r *Reporter) Report(e zaplog.Entry) {
+	select {
+	case r.ch <- &event{id: eventID(), Entry: e}:
+	default:
+		eventsDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Run 依次发送队列中的事件直到ctx取消, 取消后发送剩余的事件
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for len(r.ch) > 0 {
+				r.send(context.Background(), <-r.ch)
+			}
+			return
+		case e := <-r.ch:
+			r.send(ctx, e)
+		}
+	}
+}
+
+// send 发送一个事件, 失败时丢弃, 不重试: 同样的错误通常很快会再次出现
+func (r *Reporter) send(ctx context.Context, e *event) {
+	err := r.post(ctx, e)
+	if err == nil {
+		eventsReported.WithLabelValues().Inc()
+		if r.failing {
+			r.failing = false
+			log.Println("errreport: reporting errors recovered")
+		}
+		return
+	}
+	eventsDropped.WithLabelValues("send_failed").Inc()
+	// 不能写到zaplog, 否则这条日志又会被上报
+	if !r.failing {
+		r.failing = true
+		log.Printf("errreport: report error: %v", err)
+	}
+}
+
+func (r *Reporter) post(ctx context.Context, e *event) error {
+	req, err := r.s.request(ctx, e)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// sentry Sentry的store接口, 事件格式见 https://develop.sentry.dev/sdk/event-payloads/
+type sentry struct {
+	url, auth string
+	labels    labels
+}
+
+func newSentry(dsn string, l labels) (*sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("errreport: invalid sentry dsn")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("errreport: sentry dsn has no project id")
+	}
+	store := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], project)
+	auth := "Sentry sentry_version=7, sentry_client=greeter-errreport/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return &sentry{url: store, auth: auth, labels: l}, nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func (s *sentry) request(ctx context.Context, e *event) (*http.Request, error) {
+	tags := map[string]string{"service": s.labels.service}
+	extra := map[string]interface{}{}
+	var errMsg string
+	for _, f := range e.Fields {
+		switch f.Key {
+		case "trace_id", "span_id", "request_id", "method":
+			tags[f.Key] = fmt.Sprint(f.Value)
+		case "error":
+			errMsg = fmt.Sprint(f.Value)
+		default:
+			extra[f.Key] = jsonValue(f.Value)
+		}
+	}
+	// Sentry要求最外层在前
+	frames := make([]sentryFrame, 0, len(e.Stack))
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		f := e.Stack[i]
+		frames = append(frames, sentryFrame{
+			Function: f.Function,
+			Filename: shortFile(f.File),
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(f.Function, "github.com/Q1mi/greeter/"),
+		})
+	}
+	value := e.Message
+	if errMsg != "" {
+		value += ": " + errMsg
+	}
+	body := map[string]interface{}{
+		"event_id":    e.id,
+		"timestamp":   e.Time.UTC().Format(time.RFC3339Nano),
+		"level":       sentryLevel(e.Level),
+		"logger":      "zaplog",
+		"platform":    "go",
+		"message":     map[string]string{"formatted": e.Message},
+		"server_name": s.labels.serverName,
+		"environment": s.labels.env,
+		"release":     s.labels.release,
+		"tags":        tags,
+		"extra":       extra,
+		"exception": map[string]interface{}{
+			"values": []interface{}{map[string]interface{}{
+				"type":       e.Message,
+				"value":      value,
+				"stacktrace": map[string]interface{}{"frames": frames},
+			}},
+		},
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	return req, nil
+}
+
+func sentryLevel(l zaplog.Level) string {
+	if l == zaplog.WarnLevel {
+		return "warning"
+	}
+	return l.String()
+}
+
+// webhook 通用webhook, 事件为一个JSON对象
+type webhook struct {
+	url     string
+	headers map[string]string
+	labels  labels
+}
+
+func (w *webhook) request(ctx context.Context, e *event) (*http.Request, error) {
+	fields := make(map[string]interface{}, len(e.Fields))
+	for _, f := range e.Fields {
+		fields[f.Key] = jsonValue(f.Value)
+	}
+	stack := make([]string, len(e.Stack))
+	for i, f := range e.Stack {
+		stack[i] = fmt.Sprintf("%s\n\t%s:%d", f.Function, f.File, f.Line)
+	}
+	body := map[string]interface{}{
+		"id":          e.id,
+		"ts":          e.Time.Format(time.RFC3339Nano),
+		"level":       e.Level.String(),
+		"msg":         e.Message,
+		"fields":      fields,
+		"stack":       stack,
+		"service":     w.labels.service,
+		"env":         w.labels.env,
+		"release":     w.labels.release,
+		"server_name": w.labels.serverName,
+	}
+	if v, ok := e.Lookup("trace_id"); ok {
+		body["trace_id"] = v
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// shortFile 保留最后两级路径, 如 service/greeter.go
+func shortFile(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return path
+	}
+	if j := strings.LastIndex(path[:i], "/"); j >= 0 {
+		return path[j+1:]
+	}
+	return path
+}
+
+// jsonValue 不能编码为JSON的值改为其字符串形式, 与zaplog的处理相同, 避免整个事件无法发送
+func jsonValue(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// eventID 32位十六进制的事件ID, Sentry要求的格式
+func eventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package errs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/locale"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Config 错误消息目录配置
+type Config struct {
+	// Dir 消息目录所在目录, 每种语言一个 <语言>.json 文件(错误码 -> 消息), 如 zh-cn.json; 为空时使用默认消息
+	Dir string `json:"dir"`
+	// DefaultLocale 请求没有语言偏好或目录中没有对应语言时使用的语言
+	DefaultLocale string `json:"default_locale"`
+	// ReloadInterval 检查文件变化的间隔, 如 "10s", 为空时不重新加载
+	ReloadInterval string `json:"reload_interval"`
+}
+
+// Catalog 各语言的错误消息, 并发安全
+type Catalog struct {
+	dir      string
+	fallback string
+	interval time.Duration
+
+	mu       sync.RWMutex
+	messages map[string]map[string]string
+	// version 目录中各文件的名字、大小和修改时间, 用于判断是否需要重新加载
+	version string
+}
+
+// NewCatalog 按配置加载消息目录
+func NewCatalog(c Config) (*Catalog, error) {
+	cat := &Catalog{dir: c.Dir, fallback: c.DefaultLocale}
+	if cat.fallback == "" {
+		cat.fallback = "en"
+	}
+	if c.ReloadInterval != "" {
+		d, err := time.ParseDuration(c.ReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("errs: reload_interval: %w", err)
+		}
+		cat.interval = d
+	}
+	if _, err := cat.Reload(); err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// Reload 文件有变化时重新加载, 返回是否重新加载. 出错时保留原有的消息
+func (c *Catalog) Reload() (bool, error) {
+	if c.dir == "" {
+		return false, nil
+	}
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return false, err
+	}
+	sort.Strings(files)
+	var version strings.Builder
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(&version, "%s:%d:%d;", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	c.mu.RLock()
+	unchanged := c.messages != nil && c.version == version.String()
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	messages := map[string]map[string]string{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return false, err
+		}
+		m := map[string]string{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return false, fmt.Errorf("errs: %s: %w", f, err)
+		}
+		messages[locale.Normalize(strings.TrimSuffix(filepath.Base(f), ".json"))] = m
+	}
+	c.mu.Lock()
+	c.messages, c.version = messages, version.String()
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Run 每隔ReloadInterval检查一次文件变化, 直到ctx取消; 未配置间隔时直接返回
+func (c *Catalog) Run(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				zaplog.L().Warn("errs: reload message catalog", zaplog.String("dir", c.dir), zaplog.Error(err))
+			} else if reloaded {
+				zaplog.L().Info("errs: message catalog reloaded", zaplog.String("dir", c.dir))
+			}
+		}
+	}
+}
+
+// Message 按语言偏好(优先的在前)返回e的消息, 依次尝试回退链中的语言, 都没有时返回默认消息
+func (c *Catalog) Message(tags []string, e *Error) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, t := range locale.Chain(tags, c.fallback) {
+		if msg, ok := c.messages[t][e.Code]; ok {
+			return msg
+		}
+	}
+	return e.Message
+}
+
+// UnaryServerInterceptor 把handler返回的业务错误的消息替换为请求语言对应的文本, 状态码和details不变
+func (c *Catalog) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		var e *Error
+		if err == nil || !errors.As(err, &e) {
+			return resp, err
+		}
+		p := status.Convert(err).Proto()
+		p.Message = c.Message(locale.FromIncomingContext(ctx), e)
+		return resp, status.ErrorProto(p)
+	}
+}
+
+// StreamServerInterceptor 与UnaryServerInterceptor相同, 用于流式调用
+func (c *Catalog) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		var e *Error
+		if err == nil || !errors.As(err, &e) {
+			return err
+		}
+		p := status.Convert(err).Proto()
+		p.Message = c.Message(locale.FromIncomingContext(ss.Context()), e)
+		return status.ErrorProto(p)
+	}
+}
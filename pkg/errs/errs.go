@@ -0,0 +1,60 @@
+// Package errs 定义带错误码的业务错误, 并按请求的语言把错误消息替换为消息目录(Catalog)中的文本.
+// 错误码是稳定的标识, 如 user.not_found; Error()返回的默认消息用于日志, 以及消息目录中找不到对应文本时.
+package errs
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// Error 带错误码的错误. 通常定义为包级变量, 用errors.Is判断
+type Error struct {
+	Code    string
+	Message string
+}
+
+// New 创建错误
+func New(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// ErrInternal 不向调用方暴露细节的内部错误
+var ErrInternal = New("internal", "internal error")
+
+// CodeOf 返回err链中第一个*Error的错误码, 没有时为空
+func CodeOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// statusError 同时是gRPC状态和原始错误, 拦截器可以取出错误码查找消息
+type statusError struct {
+	st  *status.Status
+	err error
+}
+
+func (e *statusError) Error() string              { return e.st.Err().Error() }
+func (e *statusError) GRPCStatus() *status.Status { return e.st }
+func (e *statusError) Unwrap() error              { return e.err }
+
+// Status 把err转换为状态码为c的gRPC错误, 消息为err.Error(), 仍可用errors.Is/As取得err
+func Status(c codes.Code, err error) error {
+	return &statusError{st: status.New(c, err.Error()), err: err}
+}
+
+// StatusWithDetails 与Status相同, 并在状态中加入details; 加入失败时不带details
+func StatusWithDetails(c codes.Code, err error, details ...protoiface.MessageV1) error {
+	st := status.New(c, err.Error())
+	if withDetails, derr := st.WithDetails(details...); derr == nil {
+		st = withDetails
+	}
+	return &statusError{st: st, err: err}
+}
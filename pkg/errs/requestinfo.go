@@ -0,0 +1,59 @@
+package errs
+
+import (
+	"context"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// WithRequestInfo 在st的details中加入请求ID, 调用方可据此在日志中查找请求; id为空或已有RequestInfo时不变
+func WithRequestInfo(st *status.Status, id string) *status.Status {
+	if id == "" {
+		return st
+	}
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.RequestInfo); ok {
+			return st
+		}
+	}
+	if withInfo, err := st.WithDetails(&errdetails.RequestInfo{RequestId: id}); err == nil {
+		return withInfo
+	}
+	return st
+}
+
+// TraceIDTrailer 返回错误时携带trace ID的trailer, gateway据此填写错误响应中的trace_id
+const TraceIDTrailer = "x-trace-id"
+
+// RequestInfoInterceptor 在返回的错误中加入请求ID, 并在trailer中返回trace ID. 放在zaplog.UnaryServerInterceptor之后、Catalog之前
+func RequestInfoInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if id, _ := ctxutil.Trace(ctx); id != "" {
+			grpc.SetTrailer(ctx, metadata.Pairs(TraceIDTrailer, id))
+		}
+		return resp, WithRequestInfo(status.Convert(err), ctxutil.RequestID(ctx)).Err()
+	}
+}
+
+// RequestInfoStreamInterceptor 与RequestInfoInterceptor相同, 用于流式调用
+func RequestInfoStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if err == nil {
+			return nil
+		}
+		ctx := ss.Context()
+		if id, _ := ctxutil.Trace(ctx); id != "" {
+			ss.SetTrailer(metadata.Pairs(TraceIDTrailer, id))
+		}
+		return WithRequestInfo(status.Convert(err), ctxutil.RequestID(ctx)).Err()
+	}
+}
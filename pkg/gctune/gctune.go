@@ -0,0 +1,125 @@
+// Package gctune 在启动时根据配置调整GC参数: GOGC、内存上限(GOMEMLIMIT)和堆ballast
+package gctune
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Config GC配置, 未设置的项保持运行时默认值(包括GOGC、GOMEMLIMIT环境变量)
+type Config struct {
+	// GOGC 触发GC的堆增长百分比, 0表示不修改, -1表示关闭GC
+	GOGC int `json:"gogc"`
+	// MemoryLimit 软内存上限, 如 "512MiB", 为空表示不修改; 需要Go 1.19及以上
+	MemoryLimit string `json:"memory_limit"`
+	// Ballast 启动时分配的堆ballast大小, 如 "256MiB", 用于在堆较小时减少GC次数
+	Ballast string `json:"ballast"`
+}
+
+// Effective 调整后实际生效的值
+type Effective struct {
+	GOGC int
+	// MemoryLimit 为-1表示当前Go版本不支持
+	MemoryLimit int64
+	Ballast     int64
+}
+
+func (e Effective) String() string {
+	limit := "unsupported"
+	if e.MemoryLimit == math.MaxInt64 {
+		limit = "off"
+	} else if e.MemoryLimit >= 0 {
+		limit = FormatSize(e.MemoryLimit)
+	}
+	gogc := strconv.Itoa(e.GOGC)
+	if e.GOGC < 0 {
+		gogc = "off"
+	}
+	return fmt.Sprintf("GOGC=%s GOMEMLIMIT=%s ballast=%s", gogc, limit, FormatSize(e.Ballast))
+}
+
+// ballast 只需保持引用, 从不读写; 大块分配的页在未访问前不会占用物理内存
+var ballast []byte
+
+// Apply 应用配置并返回生效的值, 应在启动时调用一次
+func Apply(c Config) (Effective, error) {
+	var limit, size int64 = -1, 0
+	var err error
+	if c.MemoryLimit != "" {
+		if limit, err = ParseSize(c.MemoryLimit); err != nil {
+			return Effective{}, fmt.Errorf("gctune: memory_limit: %w", err)
+		}
+		if !memoryLimitSupported {
+			return Effective{}, fmt.Errorf("gctune: memory_limit requires Go 1.19 or later, built with %s", runtime.Version())
+		}
+	}
+	if c.Ballast != "" {
+		if size, err = ParseSize(c.Ballast); err != nil {
+			return Effective{}, fmt.Errorf("gctune: ballast: %w", err)
+		}
+	}
+	if c.GOGC < -1 {
+		return Effective{}, fmt.Errorf("gctune: invalid gogc %d", c.GOGC)
+	}
+
+	var e Effective
+	if c.GOGC != 0 {
+		debug.SetGCPercent(c.GOGC)
+		e.GOGC = c.GOGC
+	} else {
+		// SetGCPercent返回旧值, 读取后恢复
+		e.GOGC = debug.SetGCPercent(-1)
+		debug.SetGCPercent(e.GOGC)
+	}
+	if limit >= 0 {
+		setMemoryLimit(limit)
+	}
+	e.MemoryLimit = memoryLimit()
+	if size > 0 {
+		ballast = make([]byte, size)
+	}
+	e.Ballast = int64(len(ballast))
+	return e, nil
+}
+
+var units = []struct {
+	suffix string
+	n      int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"B", 1},
+}
+
+// ParseSize 解析字节数, 支持B、KiB、MiB、GiB和KB、MB、GB后缀, 不带后缀时单位为字节
+func ParseSize(s string) (int64, error) {
+	num, mul := strings.TrimSpace(s), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mul = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.n
+			break
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if v > (1<<63-1)/mul {
+		return 0, fmt.Errorf("size %q overflows", s)
+	}
+	return v * mul, nil
+}
+
+// FormatSize 以二进制单位格式化字节数
+func FormatSize(n int64) string {
+	for _, u := range units[:3] {
+		if n >= u.n && n%u.n == 0 {
+			return strconv.FormatInt(n/u.n, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
@@ -0,0 +1,13 @@
+//go:build go1.19
+// +build go1.19
+
+package gctune
+
+import "runtime/debug"
+
+const memoryLimitSupported = true
+
+func setMemoryLimit(n int64) { debug.SetMemoryLimit(n) }
+
+// memoryLimit 负数参数只读取当前值
+func memoryLimit() int64 { return debug.SetMemoryLimit(-1) }
@@ -0,0 +1,10 @@
+//go:build !go1.19
+// +build !go1.19
+
+package gctune
+
+const memoryLimitSupported = false
+
+func setMemoryLimit(n int64) {}
+
+func memoryLimit() int64 { return -1 }
@@ -0,0 +1,448 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Error GraphQL响应中的错误
+type Error struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// orderedMap 按selection顺序输出的JSON对象
+type orderedMap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap { return &orderedMap{vals: map[string]interface{}{}} }
+
+func (m *orderedMap) set(k string, v interface{}) {
+	if _, ok := m.vals[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.vals[k] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(m.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type execution struct {
+	s         *Server
+	schema    *schema
+	doc       *document
+	vars      map[string]interface{}
+	errors    []*Error
+	introspec map[string]interface{}
+}
+
+func (e *execution) addError(err error, path []interface{}) {
+	gerr := &Error{Message: err.Error(), Path: append([]interface{}(nil), path...)}
+	if st, ok := status.FromError(err); ok {
+		gerr.Message = st.Message()
+		gerr.Extensions = map[string]interface{}{"code": st.Code().String()}
+	}
+	e.errors = append(e.errors, gerr)
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("must provide operation name if query contains multiple operations")
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation named %q", name)
+}
+
+// coerceVariables 取变量值, 未提供时使用默认值
+func coerceVariables(op *operation, input map[string]interface{}) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	for _, def := range op.variables {
+		v, ok := input[def.name]
+		if !ok {
+			if def.defValue.kind != valNull {
+				dv, err := literal(def.defValue, nil)
+				if err != nil {
+					return nil, err
+				}
+				vars[def.name] = dv
+				continue
+			}
+			if def.typ.nonNull {
+				return nil, fmt.Errorf("variable \"$%s\" of required type %q was not provided", def.name, def.typ)
+			}
+			continue
+		}
+		if v == nil && def.typ.nonNull {
+			return nil, fmt.Errorf("variable \"$%s\" of non-null type %q must not be null", def.name, def.typ)
+		}
+		vars[def.name] = v
+	}
+	return vars, nil
+}
+
+// literal 把AST中的值转换为可用于JSON编码的Go值
+func literal(v value, vars map[string]interface{}) (interface{}, error) {
+	switch v.kind {
+	case valVariable:
+		return vars[v.raw], nil
+	case valInt, valFloat:
+		return json.Number(v.raw), nil
+	case valString, valEnum:
+		return v.raw, nil
+	case valBool:
+		return v.raw == "true", nil
+	case valNull:
+		return nil, nil
+	case valList:
+		out := make([]interface{}, 0, len(v.list))
+		for _, item := range v.list {
+			iv, err := literal(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, iv)
+		}
+		return out, nil
+	case valObject:
+		out := map[string]interface{}{}
+		for _, f := range v.fields {
+			fv, err := literal(f.val, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[f.name] = fv
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported value")
+}
+
+func (e *execution) skip(dirs []directive) bool {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		var cond bool
+		for _, a := range d.args {
+			if a.name == "if" {
+				v, _ := literal(a.val, e.vars)
+				cond, _ = v.(bool)
+			}
+		}
+		if (d.name == "skip") == cond {
+			return true
+		}
+	}
+	return false
+}
+
+type collected struct {
+	key   string
+	nodes []*fieldNode
+}
+
+// collectFields 展开fragment并按响应key合并字段
+func (e *execution) collectFields(sel []selection, visited map[string]bool, out []*collected) []*collected {
+	for _, s := range sel {
+		switch n := s.(type) {
+		case *fieldNode:
+			if e.skip(n.directives) {
+				continue
+			}
+			merged := false
+			for _, c := range out {
+				if c.key == n.key() {
+					c.nodes = append(c.nodes, n)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				out = append(out, &collected{key: n.key(), nodes: []*fieldNode{n}})
+			}
+		case *inlineFragment:
+			if e.skip(n.directives) {
+				continue
+			}
+			out = e.collectFields(n.selection, visited, out)
+		case *fragmentSpread:
+			if e.skip(n.directives) || visited[n.name] {
+				continue
+			}
+			visited[n.name] = true
+			if f, ok := e.doc.fragments[n.name]; ok {
+				out = e.collectFields(f.selection, visited, out)
+			}
+		}
+	}
+	return out
+}
+
+func subSelection(nodes []*fieldNode) []selection {
+	var sel []selection
+	for _, n := range nodes {
+		sel = append(sel, n.selection...)
+	}
+	return sel
+}
+
+// validate 在执行前检查字段和参数, 避免无效的mutation产生副作用
+func (e *execution) validate(sel []selection, t *gqlType, visited map[string]bool) error {
+	for _, s := range sel {
+		switch n := s.(type) {
+		case *fieldNode:
+			if n.name == "__typename" {
+				continue
+			}
+			if t == e.schema.query && (n.name == "__schema" || n.name == "__type") {
+				continue
+			}
+			f, ok := t.fieldByName[n.name]
+			if !ok {
+				return fmt.Errorf("cannot query field %q on type %q", n.name, t.name)
+			}
+			for _, a := range n.args {
+				known := false
+				for _, fa := range f.args {
+					if fa.name == a.name {
+						known = true
+					}
+				}
+				if !known {
+					return fmt.Errorf("unknown argument %q on field %q", a.name, t.name+"."+f.name)
+				}
+			}
+			if f.typ.isLeaf() {
+				if len(n.selection) > 0 {
+					return fmt.Errorf("field %q must not have a selection since type %q has no subfields", n.name, f.typ)
+				}
+				continue
+			}
+			if len(n.selection) == 0 {
+				return fmt.Errorf("field %q of type %q must have a selection of subfields", n.name, f.typ)
+			}
+			if err := e.validate(n.selection, f.typ.named(), visited); err != nil {
+				return err
+			}
+		case *inlineFragment:
+			if err := e.validate(n.selection, t, visited); err != nil {
+				return err
+			}
+		case *fragmentSpread:
+			f, ok := e.doc.fragments[n.name]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", n.name)
+			}
+			if visited[n.name] {
+				continue
+			}
+			visited[n.name] = true
+			if err := e.validate(f.selection, t, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *execution) executeRoot(ctx context.Context, op *operation, root *gqlType) *orderedMap {
+	data := newOrderedMap()
+	for _, c := range e.collectFields(op.selection, map[string]bool{}, nil) {
+		n := c.nodes[0]
+		path := []interface{}{c.key}
+		switch n.name {
+		case "__typename":
+			data.set(c.key, root.name)
+			continue
+		case "__schema":
+			data.set(c.key, e.project(subSelection(c.nodes), e.introspection()["__schema"]))
+			continue
+		case "__type":
+			var name string
+			for _, a := range n.args {
+				if a.name == "name" {
+					v, _ := literal(a.val, e.vars)
+					name, _ = v.(string)
+				}
+			}
+			types := e.introspection()["types"].(map[string]interface{})
+			data.set(c.key, e.project(subSelection(c.nodes), types[name]))
+			continue
+		}
+		f := root.fieldByName[n.name]
+		v, err := e.resolve(ctx, f, n)
+		if err != nil {
+			e.addError(err, path)
+			data.set(c.key, nil)
+			continue
+		}
+		out, err := e.complete(f.typ, c.nodes, v, path)
+		if err != nil {
+			e.addError(err, path)
+		}
+		data.set(c.key, out)
+	}
+	return data
+}
+
+// resolve 把参数组装成请求消息并在进程内调用gRPC handler
+func (e *execution) resolve(ctx context.Context, f *field, n *fieldNode) (interface{}, error) {
+	args := map[string]interface{}{}
+	for _, a := range n.args {
+		v, err := literal(a.val, e.vars)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			args[a.name] = v
+		}
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	m := f.method
+	dec := func(in interface{}) error {
+		return protojson.Unmarshal(body, in.(proto.Message))
+	}
+	ctx = withMethod(ctx, m.fullMethod)
+	resp, err := m.md.Handler(m.impl, ctx, dec, e.s.interceptor)
+	if err != nil {
+		return nil, err
+	}
+	if m.empty {
+		return true, nil
+	}
+	msg, ok := resp.(proto.Message)
+	if !ok || resp == nil {
+		return nil, nil
+	}
+	b, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	dec2 := json.NewDecoder(bytes.NewReader(b))
+	dec2.UseNumber()
+	var out interface{}
+	if err := dec2.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// complete 按字段类型和selection裁剪结果
+func (e *execution) complete(t *gqlType, nodes []*fieldNode, v interface{}, path []interface{}) (interface{}, error) {
+	switch t.kind {
+	case kindNonNull:
+		out, err := e.complete(t.ofType, nodes, v, path)
+		if err == nil && out == nil {
+			err = fmt.Errorf("cannot return null for non-nullable field")
+		}
+		return out, err
+	case kindList:
+		if v == nil {
+			return nil, nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected list value")
+		}
+		out := make([]interface{}, 0, len(items))
+		for i, item := range items {
+			iv, err := e.complete(t.ofType, nodes, item, append(path, i))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, iv)
+		}
+		return out, nil
+	case kindScalar, kindEnum:
+		return v, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected object value for %s", t.name)
+	}
+	out := newOrderedMap()
+	for _, c := range e.collectFields(subSelection(nodes), map[string]bool{}, nil) {
+		n := c.nodes[0]
+		if n.name == "__typename" {
+			out.set(c.key, t.name)
+			continue
+		}
+		f := t.fieldByName[n.name]
+		fv, err := e.complete(f.typ, c.nodes, obj[f.jsonName], append(path, c.key))
+		if err != nil {
+			return nil, err
+		}
+		out.set(c.key, fv)
+	}
+	return out, nil
+}
+
+// project 在内省数据上按selection取值, 不做类型校验
+func (e *execution) project(sel []selection, v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			out = append(out, e.project(sel, item))
+		}
+		return out
+	case map[string]interface{}:
+		if len(sel) == 0 {
+			return nil
+		}
+		out := newOrderedMap()
+		for _, c := range e.collectFields(sel, map[string]bool{}, nil) {
+			n := c.nodes[0]
+			fv := val[n.name]
+			if fn, ok := fv.(func() interface{}); ok {
+				fv = fn()
+			}
+			out.set(c.key, e.project(subSelection(c.nodes), fv))
+		}
+		return out
+	case func() interface{}:
+		return e.project(sel, val())
+	}
+	return v
+}
@@ -0,0 +1,234 @@
+// Package graphql 根据已注册gRPC服务的proto描述生成GraphQL schema,
+// 并在进程内直接调用gRPC handler完成解析, 不经过网络.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxBodySize 请求体大小上限
+const maxBodySize = 1 << 20
+
+// Server GraphQL HTTP handler, 实现了grpc.ServiceRegistrar
+type Server struct {
+	mu          sync.RWMutex
+	services    []registered
+	schema      *schema
+	schemaErr   error
+	interceptor grpc.UnaryServerInterceptor
+	matcher     runtime.HeaderMatcherFunc
+}
+
+// Option Server配置项
+type Option func(*Server)
+
+// WithUnaryInterceptor 设置调用gRPC handler时使用的拦截器
+func WithUnaryInterceptor(i grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) { s.interceptor = i }
+}
+
+// WithIncomingHeaderMatcher 设置哪些HTTP请求头转换为incoming metadata, 应与gateway的
+// runtime.WithIncomingHeaderMatcher相同, 使经过这里和经过REST接口的请求带有相同的metadata.
+// 默认为runtime.DefaultHeaderMatcher
+func WithIncomingHeaderMatcher(m runtime.HeaderMatcherFunc) Option {
+	return func(s *Server) { s.matcher = m }
+}
+
+// NewServer 创建GraphQL Server, 需通过RegisterService注册服务后使用
+func NewServer(opts ...Option) *Server {
+	s := &Server{schemaErr: fmt.Errorf("graphql: no services registered"), matcher: runtime.DefaultHeaderMatcher}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// RegisterService 注册gRPC服务, 与grpc.Server的用法相同
+func (s *Server) RegisterService(sd *grpc.ServiceDesc, impl interface{}) {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(sd.ServiceName))
+	if err != nil {
+		panic(fmt.Sprintf("graphql: service %s not found in proto registry: %v", sd.ServiceName, err))
+	}
+	desc, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		panic(fmt.Sprintf("graphql: %s is not a service", sd.ServiceName))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services = append(s.services, registered{desc: desc, sd: sd, impl: impl})
+	s.schema, s.schemaErr = buildSchema(s.services)
+}
+
+// SDL 返回schema的SDL文本
+func (s *Server) SDL() (string, error) {
+	sch, err := s.current()
+	if err != nil {
+		return "", err
+	}
+	return sch.SDL(), nil
+}
+
+func (s *Server) current() (*schema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schema, s.schemaErr
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// ServeHTTP 处理GraphQL请求:
+// POST支持application/json和application/graphql; GET仅允许query操作, 不带query参数时返回SDL.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sch, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var req request
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, sch.SDL())
+			return
+		}
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeResponse(w, http.StatusBadRequest, &response{Errors: []*Error{{Message: "invalid variables: " + err.Error()}}})
+				return
+			}
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, &response{Errors: []*Error{{Message: err.Error()}}})
+			return
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == "application/graphql" {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			writeResponse(w, http.StatusBadRequest, &response{Errors: []*Error{{Message: "invalid request body: " + err.Error()}}})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, resp := s.execute(s.incomingContext(r), sch, &req, r.Method == http.MethodGet)
+	writeResponse(w, status, resp)
+}
+
+func (s *Server) execute(ctx context.Context, sch *schema, req *request, queryOnly bool) (int, *response) {
+	fail := func(err error) (int, *response) {
+		return http.StatusBadRequest, &response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return fail(err)
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return fail(err)
+	}
+	var root *gqlType
+	switch op.kind {
+	case "query":
+		root = sch.query
+	case "mutation":
+		if queryOnly {
+			return http.StatusMethodNotAllowed, &response{Errors: []*Error{{Message: "mutations must be sent with POST"}}}
+		}
+		if sch.mutation == nil {
+			return fail(fmt.Errorf("schema does not support mutations"))
+		}
+		root = sch.mutation
+	default:
+		return fail(fmt.Errorf("unsupported operation %q", op.kind))
+	}
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return fail(err)
+	}
+	e := &execution{s: s, schema: sch, doc: doc, vars: vars}
+	if err := e.validate(op.selection, root, map[string]bool{}); err != nil {
+		return fail(err)
+	}
+	data := e.executeRoot(ctx, op, root)
+	return http.StatusOK, &response{Data: data, Errors: e.errors}
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp *response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// incomingContext 与gateway相同: Authorization总是转为authorization, 其余请求头按matcher转换为incoming metadata
+func (s *Server) incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for k, vs := range r.Header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if k == "Authorization" {
+			md.Append("authorization", vs...)
+		}
+		if key, ok := s.matcher(k); ok {
+			md.Append(key, vs...)
+		}
+	}
+	return metadata.NewIncomingContext(r.Context(), md)
+}
+
+// transportStream 使handler中的grpc.SetHeader等调用不会报错
+type transportStream struct {
+	method string
+	mu     sync.Mutex
+	header metadata.MD
+}
+
+func (t *transportStream) Method() string { return t.method }
+
+func (t *transportStream) SetHeader(md metadata.MD) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.header = metadata.Join(t.header, md)
+	return nil
+}
+
+func (t *transportStream) SendHeader(md metadata.MD) error { return t.SetHeader(md) }
+
+func (t *transportStream) SetTrailer(md metadata.MD) error { return nil }
+
+func withMethod(ctx context.Context, fullMethod string) context.Context {
+	return grpc.NewContextWithServerTransportStream(ctx, &transportStream{method: fullMethod})
+}
+
+var _ grpc.ServiceRegistrar = (*Server)(nil)
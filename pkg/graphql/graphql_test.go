@@ -0,0 +1,80 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
+// greeter 在next_page_token中返回认证的用户名和x-request-id
+type greeter struct {
+	helloworldpb.UnimplementedGreeterServer
+}
+
+func (greeter) ListGreetings(ctx context.Context, in *helloworldpb.ListGreetingsRequest) (*helloworldpb.ListGreetingsReply, error) {
+	c, _ := ctxutil.ClaimsFrom(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &helloworldpb.ListGreetingsReply{NextPageToken: c.Username + " " + strings.Join(md.Get("x-request-id"), ",")}, nil
+}
+
+// forwardRequestID 与main.go中的incomingHeaderMatcher一样额外转发x-request-id
+func forwardRequestID(key string) (string, bool) {
+	if strings.EqualFold(key, "x-request-id") {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func TestAuthenticatedQuery(t *testing.T) {
+	signer := jwt.NewSigner([]byte("test secret"), "", nil)
+	tok, _, err := signer.Sign(&ctxutil.Claims{UserID: 1, Username: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(
+		WithUnaryInterceptor(jwt.UnaryServerInterceptor(signer, nil)),
+		WithIncomingHeaderMatcher(forwardRequestID))
+	helloworldpb.RegisterGreeterServer(s, greeter{})
+
+	query := func(auth string) (map[string]interface{}, []*Error) {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{ listGreetings { nextPageToken } }`))
+		req.Header.Set("Content-Type", "application/graphql")
+		req.Header.Set("X-Request-Id", "req-1")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		var resp struct {
+			Data   map[string]interface{} `json:"data"`
+			Errors []*Error               `json:"errors"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body, err)
+		}
+		return resp.Data, resp.Errors
+	}
+
+	data, errors := query("Bearer " + tok)
+	if len(errors) > 0 {
+		t.Fatalf("authenticated query failed: %+v", errors[0])
+	}
+	got, _ := data["listGreetings"].(map[string]interface{})
+	if got["nextPageToken"] != "alice req-1" {
+		t.Errorf("listGreetings = %v, want claims of alice and x-request-id req-1", data)
+	}
+
+	if _, errors = query(""); len(errors) == 0 || errors[0].Extensions["code"] != "Unauthenticated" {
+		t.Errorf("query without token: %+v, want Unauthenticated", errors)
+	}
+}
@@ -0,0 +1,127 @@
+package graphql
+
+// introspection 构建__schema/__type查询使用的数据, 字段名遵循GraphQL内省规范
+func (e *execution) introspection() map[string]interface{} {
+	if e.introspec != nil {
+		return e.introspec
+	}
+	types := map[string]interface{}{}
+	var typeRef func(t *gqlType) interface{}
+	full := func(t *gqlType) map[string]interface{} {
+		if m, ok := types[t.name].(map[string]interface{}); ok {
+			return m
+		}
+		m := map[string]interface{}{
+			"kind":           kindNames[t.kind],
+			"name":           t.name,
+			"description":    nil,
+			"specifiedByURL": nil,
+			"interfaces":     nil,
+			"possibleTypes":  nil,
+			"fields":         nil,
+			"inputFields":    nil,
+			"enumValues":     nil,
+			"ofType":         nil,
+		}
+		types[t.name] = m
+		switch t.kind {
+		case kindObject:
+			m["interfaces"] = []interface{}{}
+			m["fields"] = func() interface{} {
+				out := make([]interface{}, 0, len(t.fields))
+				for _, f := range t.fields {
+					out = append(out, map[string]interface{}{
+						"name":              f.name,
+						"description":       nil,
+						"args":              inputValues(f.args, typeRef),
+						"type":              typeRef(f.typ),
+						"isDeprecated":      false,
+						"deprecationReason": nil,
+					})
+				}
+				return out
+			}
+		case kindInputObject:
+			m["inputFields"] = func() interface{} { return inputValues(t.inputFields, typeRef) }
+		case kindEnum:
+			values := make([]interface{}, 0, len(t.enumValues))
+			for _, v := range t.enumValues {
+				values = append(values, map[string]interface{}{
+					"name":              v,
+					"description":       nil,
+					"isDeprecated":      false,
+					"deprecationReason": nil,
+				})
+			}
+			m["enumValues"] = values
+		}
+		return m
+	}
+	typeRef = func(t *gqlType) interface{} {
+		if t.kind == kindList || t.kind == kindNonNull {
+			return map[string]interface{}{
+				"kind":   kindNames[t.kind],
+				"name":   nil,
+				"ofType": typeRef(t.ofType),
+			}
+		}
+		return full(t)
+	}
+
+	all := make([]interface{}, 0, len(e.schema.types))
+	for _, t := range e.schema.typeList() {
+		all = append(all, full(t))
+	}
+	var mutation interface{}
+	if e.schema.mutation != nil {
+		mutation = full(e.schema.mutation)
+	}
+	boolArg := func(name string) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"name":         name,
+			"description":  nil,
+			"type":         typeRef(nonNull(booleanType)),
+			"defaultValue": nil,
+		}}
+	}
+	e.introspec = map[string]interface{}{
+		"types": types,
+		"__schema": map[string]interface{}{
+			"description":      nil,
+			"queryType":        full(e.schema.query),
+			"mutationType":     mutation,
+			"subscriptionType": nil,
+			"types":            all,
+			"directives": []interface{}{
+				map[string]interface{}{
+					"name":         "include",
+					"description":  nil,
+					"isRepeatable": false,
+					"locations":    []interface{}{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+					"args":         boolArg("if"),
+				},
+				map[string]interface{}{
+					"name":         "skip",
+					"description":  nil,
+					"isRepeatable": false,
+					"locations":    []interface{}{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+					"args":         boolArg("if"),
+				},
+			},
+		},
+	}
+	return e.introspec
+}
+
+func inputValues(values []*inputValue, typeRef func(*gqlType) interface{}) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		out = append(out, map[string]interface{}{
+			"name":         v.name,
+			"description":  nil,
+			"type":         typeRef(v.typ),
+			"defaultValue": nil,
+		})
+	}
+	return out
+}
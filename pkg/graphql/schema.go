@@ -0,0 +1,430 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type kind int
+
+const (
+	kindScalar kind = iota
+	kindObject
+	kindInputObject
+	kindEnum
+	kindList
+	kindNonNull
+)
+
+var kindNames = map[kind]string{
+	kindScalar:      "SCALAR",
+	kindObject:      "OBJECT",
+	kindInputObject: "INPUT_OBJECT",
+	kindEnum:        "ENUM",
+	kindList:        "LIST",
+	kindNonNull:     "NON_NULL",
+}
+
+type gqlType struct {
+	kind        kind
+	name        string
+	fields      []*field
+	inputFields []*inputValue
+	enumValues  []string
+	ofType      *gqlType
+
+	fieldByName map[string]*field
+}
+
+type field struct {
+	name string
+	args []*inputValue
+	typ  *gqlType
+
+	// 根字段对应的RPC方法
+	method *method
+	// 对象字段在protojson输出中的key
+	jsonName string
+}
+
+type inputValue struct {
+	name string
+	typ  *gqlType
+}
+
+type method struct {
+	fullMethod string
+	desc       protoreflect.MethodDescriptor
+	md         grpc.MethodDesc
+	impl       interface{}
+	// 返回google.protobuf.Empty的方法映射为Boolean
+	empty bool
+}
+
+var (
+	stringType  = &gqlType{kind: kindScalar, name: "String"}
+	intType     = &gqlType{kind: kindScalar, name: "Int"}
+	floatType   = &gqlType{kind: kindScalar, name: "Float"}
+	booleanType = &gqlType{kind: kindScalar, name: "Boolean"}
+	idType      = &gqlType{kind: kindScalar, name: "ID"}
+	jsonType    = &gqlType{kind: kindScalar, name: "JSON"}
+)
+
+func (t *gqlType) isLeaf() bool {
+	n := t.named()
+	return n.kind == kindScalar || n.kind == kindEnum
+}
+
+func (t *gqlType) named() *gqlType {
+	for t.kind == kindList || t.kind == kindNonNull {
+		t = t.ofType
+	}
+	return t
+}
+
+func (t *gqlType) String() string {
+	switch t.kind {
+	case kindList:
+		return "[" + t.ofType.String() + "]"
+	case kindNonNull:
+		return t.ofType.String() + "!"
+	}
+	return t.name
+}
+
+func nonNull(t *gqlType) *gqlType {
+	if t.kind == kindNonNull {
+		return t
+	}
+	return &gqlType{kind: kindNonNull, ofType: t}
+}
+
+func nullable(t *gqlType) *gqlType {
+	if t.kind == kindNonNull {
+		return t.ofType
+	}
+	return t
+}
+
+func listOf(t *gqlType) *gqlType { return &gqlType{kind: kindList, ofType: t} }
+
+type schema struct {
+	query    *gqlType
+	mutation *gqlType
+	types    map[string]*gqlType
+}
+
+func (s *schema) typeList() []*gqlType {
+	names := make([]string, 0, len(s.types))
+	for n := range s.types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]*gqlType, 0, len(names))
+	for _, n := range names {
+		out = append(out, s.types[n])
+	}
+	return out
+}
+
+type registered struct {
+	desc protoreflect.ServiceDescriptor
+	sd   *grpc.ServiceDesc
+	impl interface{}
+}
+
+type builder struct {
+	s       *schema
+	outputs map[protoreflect.FullName]*gqlType
+	inputs  map[protoreflect.FullName]*gqlType
+	enums   map[protoreflect.FullName]*gqlType
+}
+
+// buildSchema 根据已注册服务的proto描述生成GraphQL schema:
+// 无副作用(idempotency_level=NO_SIDE_EFFECTS)或以Get/List开头的方法映射为query, 其余为mutation.
+func buildSchema(services []registered) (*schema, error) {
+	b := &builder{
+		s: &schema{
+			query:    &gqlType{kind: kindObject, name: "Query", fieldByName: map[string]*field{}},
+			mutation: &gqlType{kind: kindObject, name: "Mutation", fieldByName: map[string]*field{}},
+			types:    map[string]*gqlType{},
+		},
+		outputs: map[protoreflect.FullName]*gqlType{},
+		inputs:  map[protoreflect.FullName]*gqlType{},
+		enums:   map[protoreflect.FullName]*gqlType{},
+	}
+	for _, t := range []*gqlType{stringType, intType, floatType, booleanType, idType} {
+		b.s.types[t.name] = t
+	}
+	for _, svc := range services {
+		for _, md := range svc.sd.Methods {
+			m := svc.desc.Methods().ByName(protoreflect.Name(md.MethodName))
+			if m == nil {
+				return nil, fmt.Errorf("graphql: method %s not found in %s", md.MethodName, svc.desc.FullName())
+			}
+			b.addRoot(svc, md, m)
+		}
+	}
+	if len(b.s.query.fields) == 0 {
+		return nil, fmt.Errorf("graphql: no query methods registered")
+	}
+	b.s.types["Query"] = b.s.query
+	if len(b.s.mutation.fields) > 0 {
+		b.s.types["Mutation"] = b.s.mutation
+	} else {
+		b.s.mutation = nil
+	}
+	return b.s, nil
+}
+
+func (b *builder) addRoot(svc registered, md grpc.MethodDesc, m protoreflect.MethodDescriptor) {
+	root := b.s.mutation
+	opts, _ := m.Options().(*descriptorpb.MethodOptions)
+	name := string(m.Name())
+	if opts.GetIdempotencyLevel() == descriptorpb.MethodOptions_NO_SIDE_EFFECTS ||
+		strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List") {
+		root = b.s.query
+	}
+	fieldName := lowerFirst(name)
+	if _, ok := root.fieldByName[fieldName]; ok {
+		fieldName = lowerFirst(string(svc.desc.Name())) + name
+	}
+	f := &field{
+		name: fieldName,
+		method: &method{
+			fullMethod: fmt.Sprintf("/%s/%s", svc.sd.ServiceName, md.MethodName),
+			desc:       m,
+			md:         md,
+			impl:       svc.impl,
+			empty:      m.Output().FullName() == "google.protobuf.Empty",
+		},
+	}
+	if f.method.empty {
+		f.typ = booleanType
+	} else {
+		f.typ = nullable(b.output(m.Output()))
+	}
+	fields := m.Input().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if t := b.inputField(fields.Get(i)); t != nil {
+			f.args = append(f.args, &inputValue{name: fields.Get(i).JSONName(), typ: t})
+		}
+	}
+	root.fields = append(root.fields, f)
+	root.fieldByName[fieldName] = f
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// typeName 用去掉包名的全名作为类型名, 嵌套消息以下划线连接
+func typeName(d protoreflect.Descriptor, suffix string) string {
+	full := string(d.FullName())
+	if pkg := string(d.ParentFile().Package()); pkg != "" {
+		full = strings.TrimPrefix(full, pkg+".")
+	}
+	return strings.ReplaceAll(full, ".", "_") + suffix
+}
+
+func (b *builder) register(t *gqlType, d protoreflect.Descriptor, suffix string) {
+	if _, ok := b.s.types[t.name]; ok {
+		// 不同包中存在同名类型时退化为带包名的全名
+		t.name = strings.ReplaceAll(string(d.FullName()), ".", "_") + suffix
+	}
+	b.s.types[t.name] = t
+}
+
+func (b *builder) useJSON() *gqlType {
+	b.s.types[jsonType.name] = jsonType
+	return jsonType
+}
+
+// wellKnown 将常用的google.protobuf类型映射为标量, 与protojson的编码方式保持一致
+func (b *builder) wellKnown(md protoreflect.MessageDescriptor) *gqlType {
+	switch md.FullName() {
+	case "google.protobuf.Struct", "google.protobuf.Value", "google.protobuf.ListValue", "google.protobuf.Any":
+		return b.useJSON()
+	case "google.protobuf.Timestamp", "google.protobuf.Duration", "google.protobuf.FieldMask",
+		"google.protobuf.StringValue", "google.protobuf.BytesValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return stringType
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return intType
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return floatType
+	case "google.protobuf.BoolValue":
+		return booleanType
+	}
+	return nil
+}
+
+func scalarFor(k protoreflect.Kind) *gqlType {
+	switch k {
+	case protoreflect.BoolKind:
+		return booleanType
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return intType
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return floatType
+	}
+	// 64位整数和bytes在protojson中都编码为字符串
+	return stringType
+}
+
+func (b *builder) enum(ed protoreflect.EnumDescriptor) *gqlType {
+	if t, ok := b.enums[ed.FullName()]; ok {
+		return t
+	}
+	t := &gqlType{kind: kindEnum, name: typeName(ed, "")}
+	values := ed.Values()
+	for i := 0; i < values.Len(); i++ {
+		t.enumValues = append(t.enumValues, string(values.Get(i).Name()))
+	}
+	b.enums[ed.FullName()] = t
+	b.register(t, ed, "")
+	return t
+}
+
+func (b *builder) output(md protoreflect.MessageDescriptor) *gqlType {
+	if t := b.wellKnown(md); t != nil {
+		return t
+	}
+	if md.FullName() == "google.protobuf.Empty" {
+		return booleanType
+	}
+	if t, ok := b.outputs[md.FullName()]; ok {
+		return t
+	}
+	t := &gqlType{kind: kindObject, name: typeName(md, ""), fieldByName: map[string]*field{}}
+	b.outputs[md.FullName()] = t
+	b.register(t, md, "")
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		f := &field{name: fd.JSONName(), jsonName: fd.JSONName(), typ: b.outputField(fd)}
+		t.fields = append(t.fields, f)
+		t.fieldByName[f.name] = f
+	}
+	if len(t.fields) == 0 {
+		// GraphQL对象类型至少需要一个字段
+		f := &field{name: "_empty", typ: booleanType}
+		t.fields = append(t.fields, f)
+		t.fieldByName[f.name] = f
+	}
+	return t
+}
+
+func (b *builder) outputField(fd protoreflect.FieldDescriptor) *gqlType {
+	if fd.IsMap() {
+		return nonNull(b.useJSON())
+	}
+	var t *gqlType
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		t = b.output(fd.Message())
+	case protoreflect.EnumKind:
+		t = b.enum(fd.Enum())
+	default:
+		t = scalarFor(fd.Kind())
+	}
+	if fd.IsList() {
+		return nonNull(listOf(nonNull(t)))
+	}
+	if fd.HasPresence() {
+		return t
+	}
+	return nonNull(t)
+}
+
+func (b *builder) input(md protoreflect.MessageDescriptor) *gqlType {
+	if t := b.wellKnown(md); t != nil {
+		return t
+	}
+	if t, ok := b.inputs[md.FullName()]; ok {
+		return t
+	}
+	t := &gqlType{kind: kindInputObject, name: typeName(md, "Input")}
+	b.inputs[md.FullName()] = t
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if ft := b.inputField(fields.Get(i)); ft != nil {
+			t.inputFields = append(t.inputFields, &inputValue{name: fields.Get(i).JSONName(), typ: ft})
+		}
+	}
+	if len(t.inputFields) == 0 {
+		return nil
+	}
+	b.register(t, md, "Input")
+	return t
+}
+
+func (b *builder) inputField(fd protoreflect.FieldDescriptor) *gqlType {
+	if fd.IsMap() {
+		return b.useJSON()
+	}
+	var t *gqlType
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if t = b.input(fd.Message()); t == nil {
+			return nil
+		}
+	case protoreflect.EnumKind:
+		t = b.enum(fd.Enum())
+	default:
+		t = scalarFor(fd.Kind())
+	}
+	if fd.IsList() {
+		return listOf(nonNull(t))
+	}
+	return t
+}
+
+// SDL 以schema definition language输出schema
+func (s *schema) SDL() string {
+	var sb strings.Builder
+	for _, t := range s.typeList() {
+		switch t.kind {
+		case kindScalar:
+			if t == jsonType {
+				sb.WriteString("scalar JSON\n\n")
+			}
+		case kindEnum:
+			fmt.Fprintf(&sb, "enum %s {\n", t.name)
+			for _, v := range t.enumValues {
+				fmt.Fprintf(&sb, "  %s\n", v)
+			}
+			sb.WriteString("}\n\n")
+		case kindInputObject:
+			fmt.Fprintf(&sb, "input %s {\n", t.name)
+			for _, f := range t.inputFields {
+				fmt.Fprintf(&sb, "  %s: %s\n", f.name, f.typ)
+			}
+			sb.WriteString("}\n\n")
+		case kindObject:
+			fmt.Fprintf(&sb, "type %s {\n", t.name)
+			for _, f := range t.fields {
+				sb.WriteString("  " + f.name)
+				if len(f.args) > 0 {
+					args := make([]string, 0, len(f.args))
+					for _, a := range f.args {
+						args = append(args, a.name+": "+a.typ.String())
+					}
+					sb.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				fmt.Fprintf(&sb, ": %s\n", f.typ)
+			}
+			sb.WriteString("}\n\n")
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,111 @@
+package gwerrors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/tracing"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Envelope gateway所有错误响应的JSON格式. Code为gRPC状态码, Details为status中的details(如RequestInfo、ErrorInfo、BadRequest),
+// TraceID为处理请求的trace, 请求没有到达gRPC服务且没有traceparent时为空
+type Envelope struct {
+	Code    int32             `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details"`
+	TraceID string            `json:"trace_id"`
+}
+
+// HandleError 以Envelope格式写出gRPC错误, 响应头和trailer的处理与gateway默认的错误处理相同.
+// gateway自身产生的错误(如请求体解析失败、后端不可用)没有RequestInfo, 加入请求头中的请求ID
+func HandleError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	runtime.DefaultHTTPErrorHandler(ctx, mux, &envelopeMarshaler{Marshaler: marshaler, traceID: traceID(ctx, r)}, w, r, withRequestInfo(err, r))
+}
+
+// withRequestInfo 在err的status中加入r的请求ID, 保留HTTPStatusError指定的状态码
+func withRequestInfo(err error, r *http.Request) error {
+	var hse *runtime.HTTPStatusError
+	if errors.As(err, &hse) {
+		return &runtime.HTTPStatusError{HTTPStatus: hse.HTTPStatus, Err: withRequestInfo(hse.Err, r)}
+	}
+	return errs.WithRequestInfo(status.Convert(err), requestID(r)).Err()
+}
+
+// WriteError 在请求到达gateway mux之前(如认证中间件中)以Envelope格式写出st
+func WriteError(w http.ResponseWriter, r *http.Request, st *status.Status) {
+	m := &envelopeMarshaler{Marshaler: &runtime.JSONPb{MarshalOptions: protojson.MarshalOptions{}}, traceID: traceID(r.Context(), r)}
+	b, err := m.Marshal(st.Proto())
+	if err != nil {
+		b = []byte(`{"code": 13, "message": "failed to marshal error message"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(st.Code()))
+	w.Write(b)
+}
+
+// envelopeMarshaler 把status编码为Envelope, 其他消息交给原marshaler
+type envelopeMarshaler struct {
+	runtime.Marshaler
+	traceID string
+}
+
+func (m *envelopeMarshaler) Marshal(v interface{}) ([]byte, error) {
+	pb, ok := v.(*spb.Status)
+	if !ok {
+		return m.Marshaler.Marshal(v)
+	}
+	e := Envelope{Code: pb.Code, Message: pb.Message, Details: []json.RawMessage{}, TraceID: m.traceID}
+	for _, d := range pb.Details {
+		b, err := m.Marshaler.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		e.Details = append(e.Details, b)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	// 与成功响应使用相同的缩进
+	if indent := indentOf(m.Marshaler); indent != "" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", indent); err == nil {
+			return buf.Bytes(), nil
+		}
+	}
+	return b, nil
+}
+
+func indentOf(m runtime.Marshaler) string {
+	if hb, ok := m.(*runtime.HTTPBodyMarshaler); ok {
+		m = hb.Marshaler
+	}
+	if pb, ok := m.(*runtime.JSONPb); ok {
+		return pb.Indent
+	}
+	return ""
+}
+
+// traceID 优先取gRPC服务在trailer中返回的trace ID, 其次为请求的traceparent
+func traceID(ctx context.Context, r *http.Request) string {
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for _, m := range []map[string][]string{md.TrailerMD, md.HeaderMD} {
+			if vs := m[errs.TraceIDTrailer]; len(vs) > 0 {
+				return vs[0]
+			}
+		}
+	}
+	if sc, ok := tracing.ParseTraceParent(r.Header.Get(ctxutil.TraceParentHeader)); ok {
+		return sc.TraceID
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package gwerrors
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handle 用HandleError写出err, 返回状态码和Envelope中RequestInfo的请求ID
+func handle(t *testing.T, err error, requestID string) (int, []string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/hello", nil)
+	if requestID != "" {
+		r.Header.Set(ctxutil.RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	HandleError(context.Background(), runtime.NewServeMux(), &runtime.JSONPb{}, w, r, err)
+	var e Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	var ids []string
+	for _, d := range e.Details {
+		var info struct {
+			Type      string `json:"@type"`
+			RequestID string `json:"requestId"`
+		}
+		if err := json.Unmarshal(d, &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Type == "type.googleapis.com/google.rpc.RequestInfo" {
+			ids = append(ids, info.RequestID)
+		}
+	}
+	return w.Code, ids
+}
+
+func TestHandleErrorRequestInfo(t *testing.T) {
+	// gateway自身的错误带上请求头中的请求ID
+	code, ids := handle(t, status.Error(codes.Unavailable, "connection refused"), "req-1")
+	if code != http.StatusServiceUnavailable || len(ids) != 1 || ids[0] != "req-1" {
+		t.Errorf("gateway error: status %d, request ids %q, want 503 [req-1]", code, ids)
+	}
+
+	// 没有请求头时新生成
+	if _, ids := handle(t, status.Error(codes.InvalidArgument, "bad body"), ""); len(ids) != 1 || len(ids[0]) != 16 {
+		t.Errorf("without a request id header: request ids %q, want one generated id", ids)
+	}
+
+	// gRPC服务返回的RequestInfo不变
+	fromService := errs.WithRequestInfo(status.New(codes.NotFound, "user not found"), "svc-1").Err()
+	if _, ids := handle(t, fromService, "req-1"); len(ids) != 1 || ids[0] != "svc-1" {
+		t.Errorf("service error: request ids %q, want [svc-1]", ids)
+	}
+
+	// 保留HTTPStatusError指定的状态码
+	hse := &runtime.HTTPStatusError{HTTPStatus: http.StatusMethodNotAllowed, Err: status.Error(codes.Unimplemented, "Method Not Allowed")}
+	if code, ids := handle(t, hse, "req-2"); code != http.StatusMethodNotAllowed || len(ids) != 1 || ids[0] != "req-2" {
+		t.Errorf("HTTPStatusError: status %d, request ids %q, want 405 [req-2]", code, ids)
+	}
+}
@@ -0,0 +1,66 @@
+// Package gwerrors 统一gateway错误响应的JSON格式(见Envelope), 对未知路径和不支持的方法同样返回带请求ID的Envelope,
+// 而不是gateway默认的不带请求ID的响应.
+package gwerrors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var routingErrorsTotal = metrics.NewCounterVec("gateway_routing_errors_total",
+	"Number of gateway requests that matched no route, by HTTP status (404 unknown path, 405 wrong method).", "code")
+
+// ServeMuxOptions 返回以Envelope格式写出错误、并处理路由错误的gateway选项. 路由错误仍经过mux的错误处理.
+// 其他选项需要替换错误处理时(如trailers)应放在之后, 并在替换的处理中调用HandleError
+func ServeMuxOptions() []runtime.ServeMuxOption {
+	return []runtime.ServeMuxOption{runtime.WithErrorHandler(HandleError), runtime.WithRoutingErrorHandler(handleRoutingError)}
+}
+
+func handleRoutingError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	routingErrorsTotal.WithLabelValues(strconv.Itoa(httpStatus)).Inc()
+	code := codes.Internal
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusMethodNotAllowed:
+		code = codes.Unimplemented
+		// Unimplemented默认对应501, 这里保留405
+		w = &statusWriter{ResponseWriter: w, code: httpStatus}
+	}
+	st := errs.WithRequestInfo(status.New(code, http.StatusText(httpStatus)), requestID(r))
+	runtime.HTTPError(ctx, mux, marshaler, w, r, st.Err())
+}
+
+// requestID 请求没有到达gRPC服务时的请求ID, 取自请求头或新生成
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(ctxutil.RequestIDHeader); id != "" {
+		return id
+	}
+	return randomHex(8)
+}
+
+// statusWriter 把写出的状态码替换为code
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(int) { w.ResponseWriter.WriteHeader(w.code) }
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+// Package health 汇总外部依赖的健康检查, 供诊断接口和gRPC健康状态使用
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check 检查一个依赖, 返回nil表示健康; 应在ctx取消后尽快返回
+type Check func(ctx context.Context) error
+
+// Result 一次检查的结果
+type Result struct {
+	Name    string
+	Err     error
+	Latency time.Duration
+}
+
+// Healthy 是否健康
+func (r Result) Healthy() bool { return r.Err == nil }
+
+type entry struct {
+	name  string
+	check Check
+}
+
+// Registry 健康检查注册表, 并发安全
+type Registry struct {
+	mu     sync.RWMutex
+	checks []entry
+}
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add 添加名为name的检查, 同名检查会被替换
+func (r *Registry) Add(name string, c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i].check = c
+			return
+		}
+	}
+	r.checks = append(r.checks, entry{name: name, check: c})
+}
+
+// Run 并发执行所有检查, 每个检查最多等待timeout, 结果按添加顺序排列
+func (r *Registry) Run(ctx context.Context, timeout time.Duration) []Result {
+	r.mu.RLock()
+	checks := append([]entry(nil), r.checks...)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, e := range checks {
+		wg.Add(1)
+		go func(i int, e entry) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			results[i] = Result{Name: e.name, Err: e.check(cctx), Latency: time.Since(start)}
+		}(i, e)
+	}
+	wg.Wait()
+	return results
+}
+
+// Watch 立即执行一次所有检查, 之后每隔interval执行一次, 把结果交给fn; ctx取消时返回
+func (r *Registry) Watch(ctx context.Context, interval, timeout time.Duration, fn func([]Result)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		fn(r.Run(ctx, timeout))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
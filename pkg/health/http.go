@@ -0,0 +1,105 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type condition struct {
+	name  string
+	check func() error
+}
+
+// HTTP 提供 /livez、/readyz 和 /healthz 接口, 以JSON返回每项检查的结果
+type HTTP struct {
+	reg      *Registry
+	timeout  time.Duration
+	required map[string]bool
+	conds    []condition
+}
+
+// NewHTTP 创建HTTP健康检查接口. required为失败时返回未就绪的检查名, 为空时为reg中的所有检查
+func NewHTTP(reg *Registry, timeout time.Duration, required []string) *HTTP {
+	h := &HTTP{reg: reg, timeout: timeout, required: map[string]bool{}}
+	for _, name := range required {
+		h.required[name] = true
+	}
+	return h
+}
+
+// Condition 添加不访问外部依赖的就绪条件, 如服务已注册、未在停止中; 应在Register之前调用
+func (h *HTTP) Condition(name string, check func() error) {
+	h.conds = append(h.conds, condition{name: name, check: check})
+}
+
+// Register 把接口注册到mux. /livez只表示进程可以处理请求, 不检查依赖;
+// /readyz检查就绪条件和依赖, 未就绪时返回503; /healthz与/readyz相同, 供只探测/healthz的工具使用
+func (h *HTTP) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/livez", h.live)
+	mux.HandleFunc("/readyz", h.ready)
+	mux.HandleFunc("/healthz", h.ready)
+}
+
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// LatencyMs 依赖检查的耗时, 就绪条件没有该字段
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	// Optional 失败时不影响就绪状态
+	Optional bool `json:"optional,omitempty"`
+}
+
+type response struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks,omitempty"`
+}
+
+func (h *HTTP) live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, response{Status: "ok"})
+}
+
+func (h *HTTP) ready(w http.ResponseWriter, r *http.Request) {
+	resp := response{Status: "ok"}
+	fail := func(c checkResult, err error) checkResult {
+		c.Status, c.Error = "fail", err.Error()
+		if !c.Optional {
+			resp.Status = "fail"
+		}
+		return c
+	}
+	for _, c := range h.conds {
+		cr := checkResult{Name: c.name, Status: "ok"}
+		if err := c.check(); err != nil {
+			cr = fail(cr, err)
+		}
+		resp.Checks = append(resp.Checks, cr)
+	}
+	if h.reg != nil {
+		for _, res := range h.reg.Run(r.Context(), h.timeout) {
+			cr := checkResult{
+				Name:      res.Name,
+				Status:    "ok",
+				LatencyMs: float64(res.Latency.Microseconds()) / 1000,
+				Optional:  len(h.required) > 0 && !h.required[res.Name],
+			}
+			if res.Err != nil {
+				cr = fail(cr, res.Err)
+			}
+			resp.Checks = append(resp.Checks, cr)
+		}
+	}
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
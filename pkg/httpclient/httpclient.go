@@ -0,0 +1,175 @@
+// Package httpclient 创建调用外部HTTP接口使用的http.Client: 每次请求记录span并传递trace context和baggage,
+// 失败时按退避重试, 并导出请求数、耗时和重试次数指标. 调用外部HTTP接口时都应通过它创建client.
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/tracing"
+)
+
+// Config 客户端配置
+type Config struct {
+	// Timeout 一次调用的总超时(包括重试), 如 "3s"
+	Timeout string `json:"timeout"`
+	// MaxRetries 最多重试次数, 为0时不重试. 只重试幂等方法(GET、HEAD、OPTIONS、PUT、DELETE)
+	// 和带有Idempotency-Key头的请求, 且请求体可以重新读取
+	MaxRetries int `json:"max_retries"`
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍, 如 "100ms"
+	Backoff string `json:"backoff"`
+	// MaxBackoff 单次等待的上限, 也是Retry-After的上限, 如 "2s"
+	MaxBackoff string `json:"max_backoff"`
+}
+
+var (
+	requestsTotal = metrics.NewCounterVec("httpclient_requests_total",
+		"Number of outbound HTTP attempts by client, method and status code (error for transport failures).", "client", "method", "code")
+	requestDuration = metrics.NewHistogramVec("httpclient_request_duration_seconds",
+		"Outbound HTTP attempt latency by client.", nil, "client")
+	retriesTotal = metrics.NewCounterVec("httpclient_retries_total",
+		"Number of outbound HTTP retries by client.", "client")
+)
+
+// New 创建名为name的client, name用作指标标签和span属性
+func New(name string, c Config) (*http.Client, error) {
+	if c.MaxRetries < 0 {
+		return nil, fmt.Errorf("httpclient: max_retries must not be negative, got %d", c.MaxRetries)
+	}
+	timeout, err := duration("timeout", c.Timeout, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	t := &transport{name: name, next: http.DefaultTransport, retries: c.MaxRetries}
+	if t.backoff, err = duration("backoff", c.Backoff, 100*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if t.maxBackoff, err = duration("max_backoff", c.MaxBackoff, 2*time.Second); err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
+
+func duration(key, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("httpclient: %s: %w", key, err)
+	}
+	return d, nil
+}
+
+type transport struct {
+	name       string
+	next       http.RoundTripper
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// retryableStatus 可以重试的响应状态码
+var retryableStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "http "+req.Method)
+	defer span.End()
+	span.SetAttribute("http.client", t.name)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.host", req.URL.Host)
+	span.SetAttribute("http.path", req.URL.Path)
+
+	retryable := t.retries > 0 && canRetry(req)
+	for attempt := 0; ; attempt++ {
+		r, err := t.attempt(ctx, req, attempt)
+		if !retryable || attempt >= t.retries || ctx.Err() != nil || (err == nil && !retryableStatus[r.StatusCode]) {
+			if err == nil {
+				span.SetAttribute("http.status_code", r.StatusCode)
+			}
+			span.SetAttribute("http.attempts", attempt+1)
+			span.RecordError(err)
+			return r, err
+		}
+		wait := t.wait(attempt, r)
+		if r != nil {
+			io.Copy(io.Discard, io.LimitReader(r.Body, 4096))
+			r.Body.Close()
+		}
+		retriesTotal.WithLabelValues(t.name).Inc()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt 发送一次请求, 每次使用新的请求体和trace头
+func (t *transport) attempt(ctx context.Context, req *http.Request, attempt int) (*http.Response, error) {
+	r := req.Clone(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	if traceID, spanID := ctxutil.Trace(ctx); traceID != "" {
+		r.Header.Set(ctxutil.TraceParentHeader, "00-"+traceID+"-"+spanID+"-01")
+	}
+	if b := ctxutil.FormatBaggage(ctxutil.BaggageItems(ctx)); b != "" && r.Header.Get(ctxutil.BaggageHeader) == "" {
+		r.Header.Set(ctxutil.BaggageHeader, b)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(r)
+	requestDuration.WithLabelValues(t.name).ObserveContext(ctx, time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	requestsTotal.WithLabelValues(t.name, req.Method, code).Inc()
+	return resp, err
+}
+
+// canRetry 请求是否可以安全地重新发送
+func canRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// wait 第attempt次失败后的等待时间: 指数退避加随机抖动, 响应带有Retry-After(秒)时使用它
+func (t *transport) wait(attempt int, r *http.Response) time.Duration {
+	if r != nil {
+		if s, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil && s >= 0 {
+			if d := time.Duration(s) * time.Second; d < t.maxBackoff {
+				return d
+			}
+			return t.maxBackoff
+		}
+	}
+	d := t.backoff << uint(attempt)
+	if d <= 0 || d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+	// 在[d/2, d)之间随机, 避免多个实例同时重试
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
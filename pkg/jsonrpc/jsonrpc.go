@@ -0,0 +1,315 @@
+// Package jsonrpc 提供JSON-RPC 2.0兼容接口, 方法名映射到已注册的gRPC方法,
+// 在进程内直接调用gRPC handler, 支持批量请求.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// JSON-RPC 2.0 规范定义的错误码
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeServerError gRPC handler返回的错误, 原始gRPC状态码放在data.code中
+	CodeServerError = -32000
+)
+
+// maxBodySize 请求体大小上限
+const maxBodySize = 1 << 20
+
+// Error JSON-RPC错误对象
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type method struct {
+	fullMethod string
+	md         grpc.MethodDesc
+	impl       interface{}
+}
+
+// Server JSON-RPC HTTP handler, 实现了grpc.ServiceRegistrar
+type Server struct {
+	mu          sync.RWMutex
+	methods     map[string]*method
+	interceptor grpc.UnaryServerInterceptor
+	matcher     runtime.HeaderMatcherFunc
+}
+
+// Option Server配置项
+type Option func(*Server)
+
+// WithUnaryInterceptor 设置调用gRPC handler时使用的拦截器
+func WithUnaryInterceptor(i grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) { s.interceptor = i }
+}
+
+// WithIncomingHeaderMatcher 设置哪些HTTP请求头转换为incoming metadata, 应与gateway的
+// runtime.WithIncomingHeaderMatcher相同, 使经过这里和经过REST接口的请求带有相同的metadata.
+// 默认为runtime.DefaultHeaderMatcher
+func WithIncomingHeaderMatcher(m runtime.HeaderMatcherFunc) Option {
+	return func(s *Server) { s.matcher = m }
+}
+
+// NewServer 创建JSON-RPC Server
+func NewServer(opts ...Option) *Server {
+	s := &Server{methods: map[string]*method{}, matcher: runtime.DefaultHeaderMatcher}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// RegisterService 注册gRPC服务的unary方法.
+// 方法名为 "Service.Method" (如 Greeter.SayHello), 同时支持带包名的全名 "helloworld.Greeter.SayHello".
+func (s *Server) RegisterService(sd *grpc.ServiceDesc, impl interface{}) {
+	short := sd.ServiceName
+	if i := strings.LastIndex(short, "."); i >= 0 {
+		short = short[i+1:]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, md := range sd.Methods {
+		m := &method{fullMethod: "/" + sd.ServiceName + "/" + md.MethodName, md: md, impl: impl}
+		s.methods[sd.ServiceName+"."+md.MethodName] = m
+		if _, ok := s.methods[short+"."+md.MethodName]; !ok {
+			s.methods[short+"."+md.MethodName] = m
+		}
+	}
+}
+
+func (s *Server) lookup(name string) *method {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.methods[name]
+}
+
+// ServeHTTP 处理JSON-RPC请求, 仅支持POST
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeJSON(w, errorResponse(nil, CodeParseError, err.Error()))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	ctx := s.incomingContext(r)
+
+	// 批量请求
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, errorResponse(nil, CodeParseError, "parse error"))
+			return
+		}
+		if len(batch) == 0 {
+			writeJSON(w, errorResponse(nil, CodeInvalidRequest, "empty batch"))
+			return
+		}
+		out := make([]*response, 0, len(batch))
+		for _, raw := range batch {
+			if resp := s.handle(ctx, raw); resp != nil {
+				out = append(out, resp)
+			}
+		}
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, out)
+		return
+	}
+
+	if !json.Valid(body) {
+		writeJSON(w, errorResponse(nil, CodeParseError, "parse error"))
+		return
+	}
+	resp := s.handle(ctx, body)
+	if resp == nil {
+		// notification 不返回内容
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// handle 处理单个请求, notification返回nil
+func (s *Server) handle(ctx context.Context, raw json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nil, CodeInvalidRequest, "invalid request")
+	}
+	if req.Version != "2.0" || req.Method == "" || !validID(req.ID) {
+		return errorResponse(req.ID, CodeInvalidRequest, "invalid request")
+	}
+	notify := req.ID == nil
+
+	resp := s.call(ctx, &req)
+	if notify {
+		return nil
+	}
+	return resp
+}
+
+func (s *Server) call(ctx context.Context, req *request) *response {
+	m := s.lookup(req.Method)
+	if m == nil {
+		return errorResponse(req.ID, CodeMethodNotFound, "method not found: "+req.Method)
+	}
+	params, err := objectParams(req.Params)
+	if err != nil {
+		return errorResponse(req.ID, CodeInvalidParams, err.Error())
+	}
+
+	var decErr error
+	dec := func(in interface{}) error {
+		if err := protojson.Unmarshal(params, in.(proto.Message)); err != nil {
+			decErr = err
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	}
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &transportStream{method: m.fullMethod})
+	reply, err := m.md.Handler(m.impl, ctx, dec, s.interceptor)
+	if decErr != nil {
+		return errorResponse(req.ID, CodeInvalidParams, decErr.Error())
+	}
+	if err != nil {
+		st := status.Convert(err)
+		return &response{
+			Version: "2.0",
+			Error: &Error{
+				Code:    CodeServerError,
+				Message: st.Message(),
+				Data:    map[string]interface{}{"code": st.Code().String()},
+			},
+			ID: req.ID,
+		}
+	}
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return errorResponse(req.ID, CodeInternalError, "invalid reply")
+	}
+	result, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return errorResponse(req.ID, CodeInternalError, err.Error())
+	}
+	return &response{Version: "2.0", Result: result, ID: req.ID}
+}
+
+// objectParams 统一params格式: 支持对象, 或只包含一个对象的数组
+func objectParams(raw json.RawMessage) ([]byte, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return []byte("{}"), nil
+	}
+	switch raw[0] {
+	case '{':
+		return raw, nil
+	case '[':
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return []byte("{}"), nil
+		}
+		if len(list) == 1 && bytes.HasPrefix(bytes.TrimSpace(list[0]), []byte("{")) {
+			return list[0], nil
+		}
+	}
+	return nil, fmt.Errorf("params must be an object or an array with a single object")
+}
+
+// validID id只能是字符串, 数字或null
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{Version: "2.0", Error: &Error{Code: code, Message: msg}, ID: id}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// incomingContext 与gateway相同: Authorization总是转为authorization, 其余请求头按matcher转换为incoming metadata
+func (s *Server) incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for k, vs := range r.Header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if k == "Authorization" {
+			md.Append("authorization", vs...)
+		}
+		if key, ok := s.matcher(k); ok {
+			md.Append(key, vs...)
+		}
+	}
+	return metadata.NewIncomingContext(r.Context(), md)
+}
+
+// transportStream 使handler中的grpc.SetHeader等调用不会报错
+type transportStream struct {
+	method string
+}
+
+func (t *transportStream) Method() string { return t.method }
+
+func (t *transportStream) SetHeader(md metadata.MD) error { return nil }
+
+func (t *transportStream) SendHeader(md metadata.MD) error { return nil }
+
+func (t *transportStream) SetTrailer(md metadata.MD) error { return nil }
+
+var _ grpc.ServiceRegistrar = (*Server)(nil)
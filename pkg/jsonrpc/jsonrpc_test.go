@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/jwt"
+	helloworldpb "github.com/Q1mi/greeter/proto/helloworld"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
+// greeter 在回复中返回认证的用户名和x-request-id
+type greeter struct {
+	helloworldpb.UnimplementedGreeterServer
+}
+
+func (greeter) SayHello(ctx context.Context, in *helloworldpb.HelloRequest) (*helloworldpb.HelloReply, error) {
+	c, _ := ctxutil.ClaimsFrom(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &helloworldpb.HelloReply{Message: c.Username + " " + strings.Join(md.Get("x-request-id"), ",")}, nil
+}
+
+// forwardRequestID 与main.go中的incomingHeaderMatcher一样额外转发x-request-id
+func forwardRequestID(key string) (string, bool) {
+	if strings.EqualFold(key, "x-request-id") {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func TestAuthenticatedCall(t *testing.T) {
+	signer := jwt.NewSigner([]byte("test secret"), "", nil)
+	tok, _, err := signer.Sign(&ctxutil.Claims{UserID: 1, Username: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(
+		WithUnaryInterceptor(jwt.UnaryServerInterceptor(signer, nil)),
+		WithIncomingHeaderMatcher(forwardRequestID))
+	helloworldpb.RegisterGreeterServer(s, greeter{})
+
+	call := func(auth string) response {
+		req := httptest.NewRequest(http.MethodPost, "/rpc",
+			strings.NewReader(`{"jsonrpc":"2.0","method":"Greeter.SayHello","params":{"name":"q1mi"},"id":1}`))
+		req.Header.Set("X-Request-Id", "req-1")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		var resp response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body, err)
+		}
+		return resp
+	}
+
+	resp := call("Bearer " + tok)
+	if resp.Error != nil {
+		t.Fatalf("authenticated call failed: %+v", resp.Error)
+	}
+	var reply struct{ Message string }
+	if err := json.Unmarshal(resp.Result, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "alice req-1" {
+		t.Errorf("message = %q, want claims of alice and x-request-id req-1", reply.Message)
+	}
+
+	resp = call("")
+	if resp.Error == nil || resp.Error.Data.(map[string]interface{})["code"] != "Unauthenticated" {
+		t.Errorf("call without token: %+v, want Unauthenticated", resp.Error)
+	}
+}
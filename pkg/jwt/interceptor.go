@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrMissing 请求没有携带access token
+var ErrMissing = errs.New("auth.missing_token", "access token is required")
+
+// Config JWT认证配置
+type Config struct {
+	// Enabled 是否要求请求携带有效的access token, Exempt中的方法除外
+	Enabled bool `json:"enabled"`
+	// Issuer 签发时写入、校验时要求的iss, 为空时不校验
+	Issuer string `json:"issuer"`
+	// Revocation Logout撤销记录的存储: memory(默认, 只在本实例生效)或redis
+	Revocation string `json:"revocation"`
+	// Exempt 不需要认证的方法全名, 以*结尾时匹配该前缀, 如 /grpc.health.v1.Health/*
+	Exempt []string `json:"exempt"`
+}
+
+var authTotal = metrics.NewCounterVec("auth_requests_total",
+	"Number of authentication results by result (ok, exempt, missing, invalid, expired, revoked or error).", "result")
+
+// UnaryServerInterceptor 从authorization metadata(经gateway时为Authorization头)中取出Bearer token,
+// 校验通过后把Claims放入ctx. Exempt中的方法没有token也可以调用, 携带有效token时同样放入Claims
+func UnaryServerInterceptor(s *Signer, exempt []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, s, info.FullMethod, exempt)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 与UnaryServerInterceptor相同, 用于流式调用. 只在建立流时校验一次,
+// token在流的存续期间过期或被撤销不会中断已建立的流
+func StreamServerInterceptor(s *Signer, exempt []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), s, info.FullMethod, exempt)
+		if err != nil {
+			return err
+		}
+		return handler(srv, ctxutil.WrapServerStream(ss, ctx))
+	}
+}
+
+// authenticate 校验ctx中的token, 返回放入了Claims的ctx
+func authenticate(ctx context.Context, s *Signer, method string, exempt []string) (context.Context, error) {
+	skip := isExempt(method, exempt)
+	tok := BearerToken(ctx)
+	if tok == "" {
+		if skip {
+			authTotal.WithLabelValues("exempt").Inc()
+			return ctx, nil
+		}
+		authTotal.WithLabelValues("missing").Inc()
+		return nil, errs.Status(codes.Unauthenticated, ErrMissing)
+	}
+	c, err := s.Verify(ctx, tok)
+	switch {
+	case err != nil && skip:
+		authTotal.WithLabelValues("exempt").Inc()
+		return ctx, nil
+	case errors.Is(err, ErrUnavailable):
+		authTotal.WithLabelValues("error").Inc()
+		return nil, errs.Status(codes.Unavailable, err)
+	case errors.Is(err, ErrExpired):
+		authTotal.WithLabelValues("expired").Inc()
+		return nil, errs.Status(codes.Unauthenticated, err)
+	case errors.Is(err, ErrRevoked):
+		authTotal.WithLabelValues("revoked").Inc()
+		return nil, errs.Status(codes.Unauthenticated, err)
+	case err != nil:
+		authTotal.WithLabelValues("invalid").Inc()
+		return nil, errs.Status(codes.Unauthenticated, err)
+	}
+	authTotal.WithLabelValues("ok").Inc()
+	return ctxutil.WithClaims(ctx, c), nil
+}
+
+// BearerToken 返回authorization metadata中的Bearer token, 没有时返回空字符串
+func BearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:])
+		}
+	}
+	return ""
+}
+
+func isExempt(method string, exempt []string) bool {
+	for _, p := range exempt {
+		if p == method || strings.HasSuffix(p, "*") && strings.HasPrefix(method, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/testing/interceptor"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const healthCheck = "/grpc.health.v1.Health/Check"
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s := NewSigner([]byte("test secret"), "greeter", NewMemoryRevocations())
+	tok, _, err := s.Sign(&ctxutil.Claims{UserID: 7, Username: "alice", Roles: []string{"admin"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, _ := s.Sign(&ctxutil.Claims{UserID: 7}, -time.Hour)
+	other, _, _ := NewSigner([]byte("other secret"), "greeter", nil).Sign(&ctxutil.Claims{UserID: 7}, time.Minute)
+	revoked, _, _ := s.Sign(&ctxutil.Claims{UserID: 7}, time.Minute)
+	c, err := s.Verify(context.Background(), revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	ic := UnaryServerInterceptor(s, []string{"/grpc.health.v1.Health/*"})
+
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		code   codes.Code
+		err    error
+		// user 放入ctx的Claims的用户名, 为空时ctx中没有Claims
+		user string
+	}{
+		{"valid token", "", "Bearer " + tok, codes.OK, nil, "alice"},
+		{"lower-case scheme", "", "bearer " + tok, codes.OK, nil, "alice"},
+		{"missing token", "", "", codes.Unauthenticated, ErrMissing, ""},
+		{"not bearer", "", "Basic YWxpY2U6c2VjcmV0", codes.Unauthenticated, ErrMissing, ""},
+		{"expired", "", "Bearer " + expired, codes.Unauthenticated, ErrExpired, ""},
+		{"wrong key", "", "Bearer " + other, codes.Unauthenticated, ErrInvalid, ""},
+		{"revoked", "", "Bearer " + revoked, codes.Unauthenticated, ErrRevoked, ""},
+		{"exempt without token", healthCheck, "", codes.OK, nil, ""},
+		// 豁免的方法忽略无效token, 携带有效token时同样放入Claims
+		{"exempt with invalid token", healthCheck, "Bearer " + other, codes.OK, nil, ""},
+		{"exempt with valid token", healthCheck, "Bearer " + tok, codes.OK, nil, "alice"},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.auth != "" {
+			ctx = interceptor.IncomingContext(ctx, "authorization", tt.auth)
+		}
+		h := &interceptor.Handler{Resp: "ok"}
+		res := interceptor.Run(ctx, nil, interceptor.Info(tt.method), h.Handle, ic)
+		if status.Code(res.Err) != tt.code {
+			t.Errorf("%s: code %s, want %s", tt.name, status.Code(res.Err), tt.code)
+			continue
+		}
+		if tt.err != nil {
+			if !errors.Is(res.Err, tt.err) {
+				t.Errorf("%s: err %v, want %v", tt.name, res.Err, tt.err)
+			}
+			if h.Calls() != 0 {
+				t.Errorf("%s: handler called after a rejected token", tt.name)
+			}
+			continue
+		}
+		c, ok := ctxutil.ClaimsFrom(h.LastContext())
+		switch {
+		case tt.user == "" && ok:
+			t.Errorf("%s: claims %+v in ctx, want none", tt.name, c)
+		case tt.user != "" && (!ok || c.Username != tt.user || c.UserID != 7 || !c.HasRole("admin")):
+			t.Errorf("%s: claims %+v, want alice with the admin role", tt.name, c)
+		}
+	}
+}
+
+func TestUnavailableRevocations(t *testing.T) {
+	s := NewSigner([]byte("test secret"), "", failingRevocations{})
+	tok, _, err := s.Sign(&ctxutil.Claims{UserID: 7}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 无法查询撤销记录时拒绝请求
+	ctx := interceptor.IncomingContext(context.Background(), "authorization", "Bearer "+tok)
+	res := interceptor.Run(ctx, nil, nil, nil, UnaryServerInterceptor(s, nil))
+	if status.Code(res.Err) != codes.Unavailable || errs.CodeOf(res.Err) != ErrUnavailable.Code {
+		t.Errorf("err = %v, want Unavailable %s", res.Err, ErrUnavailable.Code)
+	}
+}
+
+type failingRevocations struct{}
+
+func (failingRevocations) Revoke(context.Context, string, time.Time) error { return errors.New("down") }
+
+func (failingRevocations) Revoked(context.Context, string) (bool, error) {
+	return false, errors.New("down")
+}
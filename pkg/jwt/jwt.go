@@ -0,0 +1,179 @@
+// Package jwt 签发和校验HS256签名的JWT access token, 并提供从请求中取出token完成认证的拦截器.
+// token的sub为用户ID, 校验通过后以ctxutil.Claims放入ctx, 供授权和业务逻辑使用.
+package jwt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+var (
+	// ErrInvalid token格式错误、签名不匹配或签发者不符
+	ErrInvalid = errs.New("auth.invalid_token", "invalid access token")
+	// ErrExpired token已过期
+	ErrExpired = errs.New("auth.token_expired", "access token expired")
+	// ErrRevoked token已通过Logout撤销
+	ErrRevoked = errs.New("auth.token_revoked", "access token has been revoked")
+	// ErrUnavailable 无法查询撤销记录. 此时拒绝请求, 不放行
+	ErrUnavailable = errs.New("auth.unavailable", "authentication is temporarily unavailable")
+)
+
+// header 只签发和接受HS256
+const header = `{"alg":"HS256","typ":"JWT"}`
+
+// leeway 校验exp和nbf时允许的时钟误差
+const leeway = 30 * time.Second
+
+type payload struct {
+	Issuer   string   `json:"iss,omitempty"`
+	Subject  string   `json:"sub"`
+	Name     string   `json:"name,omitempty"`
+	TenantID string   `json:"tid,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	// Scope 以空格分隔的scope, 与OAuth 2.0相同
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+	// SessionID 登录会话, 同一次登录刷新得到的token相同
+	SessionID string `json:"sid,omitempty"`
+}
+
+// Signer 签发、校验和撤销access token
+type Signer struct {
+	key     []byte
+	issuer  string
+	revoked RevocationStore
+	now     func() time.Time
+}
+
+// NewSigner 创建Signer. 签名密钥由secret派生, 与token包的一次性令牌使用不同的密钥; issuer不为空时校验iss.
+// revoked为已撤销token的存储, 为nil时token不能撤销
+func NewSigner(secret []byte, issuer string, revoked RevocationStore) *Signer {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("jwt"))
+	return &Signer{key: m.Sum(nil), issuer: issuer, revoked: revoked, now: time.Now}
+}
+
+// Sign 为c签发有效期为ttl的token, 返回token和过期时间. c.ExpiresAt被忽略
+func (s *Signer) Sign(c *ctxutil.Claims, ttl time.Duration) (string, time.Time, error) {
+	now := s.now()
+	exp := now.Add(ttl)
+	b, err := json.Marshal(payload{
+		Issuer:    s.issuer,
+		Subject:   strconv.FormatInt(c.UserID, 10),
+		Name:      c.Username,
+		TenantID:  c.TenantID,
+		Roles:     c.Roles,
+		Scope:     strings.Join(c.Scopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+		ID:        randomID(),
+		SessionID: c.SessionID,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	enc := base64.RawURLEncoding
+	signing := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString(b)
+	return signing + "." + enc.EncodeToString(s.mac(signing)), time.Unix(exp.Unix(), 0), nil
+}
+
+// Verify 校验token的签名、签发者、有效期以及是否已撤销, 返回其中的用户信息
+func (s *Signer) Verify(ctx context.Context, tok string) (*ctxutil.Claims, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	enc := base64.RawURLEncoding
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, s.mac(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalid
+	}
+	// 签名正确时头部只可能是本服务签发的, 仍检查alg以拒绝其他算法
+	h, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(h, &hdr) != nil || hdr.Alg != "HS256" {
+		return nil, ErrInvalid
+	}
+	b, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var p payload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, ErrInvalid
+	}
+	if s.issuer != "" && p.Issuer != s.issuer {
+		return nil, ErrInvalid
+	}
+	uid, err := strconv.ParseInt(p.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	now := s.now()
+	if p.NotBefore != 0 && now.Add(leeway).Unix() < p.NotBefore {
+		return nil, ErrInvalid
+	}
+	if now.Add(-leeway).Unix() > p.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if s.revoked != nil && p.ID != "" {
+		revoked, err := s.revoked.Revoked(ctx, p.ID)
+		if err != nil {
+			zaplog.FromContext(ctx).Error("jwt: check revocation", zaplog.Error(err))
+			return nil, ErrUnavailable
+		}
+		if revoked {
+			return nil, ErrRevoked
+		}
+	}
+	return &ctxutil.Claims{
+		UserID:    uid,
+		Username:  p.Name,
+		TenantID:  p.TenantID,
+		Roles:     p.Roles,
+		Scopes:    strings.Fields(p.Scope),
+		ExpiresAt: time.Unix(p.ExpiresAt, 0),
+		TokenID:   p.ID,
+		SessionID: p.SessionID,
+	}, nil
+}
+
+// Revoke 撤销c对应的token, 撤销记录保存到token过期为止
+func (s *Signer) Revoke(ctx context.Context, c *ctxutil.Claims) error {
+	if s.revoked == nil || c.TokenID == "" {
+		return nil
+	}
+	return s.revoked.Revoke(ctx, c.TokenID, c.ExpiresAt)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Signer) mac(signing string) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(signing))
+	return m.Sum(nil)
+}
@@ -0,0 +1,82 @@
+package jwt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/redis"
+)
+
+// 撤销记录的存储方式
+const (
+	// RevocationMemory 保存在进程内, 多实例部署时只在处理Logout的实例上生效
+	RevocationMemory = "memory"
+	// RevocationRedis 保存在redis配置的Redis中, 所有实例共享
+	RevocationRedis = "redis"
+)
+
+// RevocationStore 保存已撤销的token ID, 每条记录只需保存到token过期为止. 必须并发安全
+type RevocationStore interface {
+	Revoke(ctx context.Context, id string, until time.Time) error
+	Revoked(ctx context.Context, id string) (bool, error)
+}
+
+// MemoryRevocations 进程内的撤销记录, 过期的记录在之后的Revoke中清理
+type MemoryRevocations struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+	now func() time.Time
+}
+
+// NewMemoryRevocations 创建进程内的撤销记录
+func NewMemoryRevocations() *MemoryRevocations {
+	return &MemoryRevocations{ids: map[string]time.Time{}, now: time.Now}
+}
+
+func (m *MemoryRevocations) Revoke(_ context.Context, id string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for k, exp := range m.ids {
+		if now.After(exp) {
+			delete(m.ids, k)
+		}
+	}
+	// 与RedisRevocations相同, 加上校验时允许的时钟误差
+	m.ids[id] = until.Add(leeway)
+	return nil
+}
+
+func (m *MemoryRevocations) Revoked(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	exp, ok := m.ids[id]
+	return ok && !m.now().After(exp), nil
+}
+
+// RedisRevocations 保存在Redis中的撤销记录, 键为prefix+token ID, 到token过期时由Redis删除
+type RedisRevocations struct {
+	c      *redis.Client
+	prefix string
+}
+
+// NewRedisRevocations 创建Redis中的撤销记录
+func NewRedisRevocations(c *redis.Client, prefix string) *RedisRevocations {
+	return &RedisRevocations{c: c, prefix: prefix}
+}
+
+func (r *RedisRevocations) Revoke(ctx context.Context, id string, until time.Time) error {
+	// 加上校验时允许的时钟误差, 误差范围内仍有效的token也保持撤销
+	ttl := time.Until(until) + leeway
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := r.c.Do(ctx, "SET", r.prefix+id, "1", "PX", ttl.Milliseconds())
+	return err
+}
+
+func (r *RedisRevocations) Revoked(ctx context.Context, id string) (bool, error) {
+	n, err := redis.Int(r.c.Do(ctx, "EXISTS", r.prefix+id))
+	return n > 0, err
+}
@@ -0,0 +1,45 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+)
+
+// TestMemoryRevocationsLeeway 撤销的token在过期后的时钟误差范围内仍然被拒绝
+func TestMemoryRevocationsLeeway(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	clock := func() time.Time { return now }
+	revoked := NewMemoryRevocations()
+	revoked.now = clock
+	s := NewSigner([]byte("test secret"), "greeter", revoked)
+	s.now = clock
+	ctx := context.Background()
+
+	tok, exp, err := s.Sign(&ctxutil.Claims{UserID: 1, Username: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Verify(ctx, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	now = exp.Add(10 * time.Second)
+	if _, err := s.Verify(ctx, tok); !errors.Is(err, ErrRevoked) {
+		t.Errorf("verify at exp+10s: %v, want ErrRevoked", err)
+	}
+	now = exp.Add(leeway + time.Second)
+	if _, err := s.Verify(ctx, tok); !errors.Is(err, ErrExpired) {
+		t.Errorf("verify after the leeway: %v, want ErrExpired", err)
+	}
+	if ok, _ := revoked.Revoked(ctx, c.TokenID); ok {
+		t.Error("revocation kept after the token can no longer be used")
+	}
+}
@@ -0,0 +1,499 @@
+// Package kafka 最小的Kafka客户端和消费者, 只实现消费一个topic、提交offset和发送消息所需的协议.
+// 不参与consumer group的分区分配: 消费者读取topic的所有分区, 通过leader选主保证同时只有一个实例消费.
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config Kafka配置
+type Config struct {
+	// Brokers 初始broker地址, 为空时不启用
+	Brokers []string `json:"brokers"`
+	// ClientID 请求中携带的客户端ID, 默认greeter
+	ClientID string `json:"client_id"`
+	// Timeout 连接和单个请求的超时, 如 "10s"
+	Timeout string `json:"timeout"`
+	// Consumer 异步命令的消费配置
+	Consumer ConsumerConfig `json:"consumer"`
+}
+
+// 查询分区offset时的特殊时间戳
+const (
+	// OffsetLatest 下一条写入消息的offset
+	OffsetLatest int64 = -1
+	// OffsetEarliest 最早一条仍保留的消息的offset
+	OffsetEarliest int64 = -2
+)
+
+// Client Kafka客户端, 并发安全. 元数据按需获取, leader变化时自动刷新
+type Client struct {
+	seeds    []string
+	clientID string
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	conns   map[string]*brokerConn
+	brokers map[int32]string
+	// leaders topic -> 分区 -> leader broker ID
+	leaders map[string]map[int32]int32
+	// coordinators group -> coordinator地址
+	coordinators map[string]string
+}
+
+// NewClient 创建客户端, 不立即连接
+func NewClient(c Config) (*Client, error) {
+	if len(c.Brokers) == 0 {
+		return nil, errors.New("kafka: brokers is required")
+	}
+	cl := &Client{
+		seeds:        c.Brokers,
+		clientID:     c.ClientID,
+		timeout:      10 * time.Second,
+		conns:        map[string]*brokerConn{},
+		brokers:      map[int32]string{},
+		leaders:      map[string]map[int32]int32{},
+		coordinators: map[string]string{},
+	}
+	if cl.clientID == "" {
+		cl.clientID = "greeter"
+	}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: timeout: %w", err)
+		}
+		cl.timeout = d
+	}
+	return cl, nil
+}
+
+// Close 关闭所有连接
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, bc := range c.conns {
+		bc.close()
+	}
+	return nil
+}
+
+func (c *Client) conn(addr string) *brokerConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bc, ok := c.conns[addr]
+	if !ok {
+		bc = &brokerConn{addr: addr, clientID: c.clientID, timeout: c.timeout}
+		c.conns[addr] = bc
+	}
+	return bc
+}
+
+// any 依次在已知broker和初始broker上执行f, 直到成功
+func (c *Client) any(f func(bc *brokerConn) error) error {
+	c.mu.Lock()
+	addrs := make([]string, 0, len(c.brokers)+len(c.seeds))
+	for _, a := range c.brokers {
+		addrs = append(addrs, a)
+	}
+	addrs = append(addrs, c.seeds...)
+	c.mu.Unlock()
+	var err error
+	for _, a := range addrs {
+		if err = f(c.conn(a)); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// refreshMetadata 获取topic的分区和leader
+func (c *Client) refreshMetadata(topic string) error {
+	e := &encoder{}
+	e.arrayLen(1)
+	e.string(topic)
+	return c.any(func(bc *brokerConn) error {
+		d, err := bc.roundTrip(apiMetadata, e.b, 0)
+		if err != nil {
+			return err
+		}
+		brokers := map[int32]string{}
+		for n := d.arrayLen(); n > 0; n-- {
+			id := d.int32()
+			host := d.string()
+			port := d.int32()
+			d.string() // rack
+			brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		d.int32() // controller_id
+		leaders := map[int32]int32{}
+		var topicErr error
+		for n := d.arrayLen(); n > 0; n-- {
+			code := d.int16()
+			name := d.string()
+			d.int8() // is_internal
+			for pn := d.arrayLen(); pn > 0; pn-- {
+				d.int16() // 分区错误码, leader不可用时leader为-1
+				p := d.int32()
+				leader := d.int32()
+				for rn := d.arrayLen(); rn > 0; rn-- {
+					d.int32()
+				}
+				for rn := d.arrayLen(); rn > 0; rn-- {
+					d.int32()
+				}
+				leaders[p] = leader
+			}
+			if name == topic {
+				topicErr = codeError(code)
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+		if topicErr != nil {
+			return fmt.Errorf("kafka: metadata for %s: %w", topic, topicErr)
+		}
+		c.mu.Lock()
+		c.brokers = brokers
+		c.leaders[topic] = leaders
+		c.mu.Unlock()
+		return nil
+	})
+}
+
+// Partitions 返回topic的所有分区
+func (c *Client) Partitions(topic string) ([]int32, error) {
+	if err := c.refreshMetadata(topic); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ps := make([]int32, 0, len(c.leaders[topic]))
+	for p := int32(0); int(p) < len(c.leaders[topic]); p++ {
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// leader 返回分区leader的连接, 需要时刷新元数据
+func (c *Client) leader(topic string, partition int32) (*brokerConn, error) {
+	for refreshed := false; ; refreshed = true {
+		c.mu.Lock()
+		id, ok := c.leaders[topic][partition]
+		addr := c.brokers[id]
+		c.mu.Unlock()
+		if ok && id >= 0 && addr != "" {
+			return c.conn(addr), nil
+		}
+		if refreshed {
+			return nil, fmt.Errorf("kafka: no leader for %s/%d", topic, partition)
+		}
+		if err := c.refreshMetadata(topic); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkLeader leader变化或连接失败时丢弃缓存的元数据, 下次请求重新获取
+func (c *Client) checkLeader(topic string, err error) error {
+	if stale(err) {
+		c.mu.Lock()
+		delete(c.leaders, topic)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// stale 错误是否可能因为缓存的broker已失效, 连接错误也算在内
+func stale(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ke Error
+	if errors.As(err, &ke) {
+		return ke.staleMetadata()
+	}
+	return true
+}
+
+// ListOffset 返回分区在ts(OffsetEarliest或OffsetLatest)处的offset
+func (c *Client) ListOffset(topic string, partition int32, ts int64) (int64, error) {
+	bc, err := c.leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	e := &encoder{}
+	e.int32(-1) // replica_id
+	e.arrayLen(1)
+	e.string(topic)
+	e.arrayLen(1)
+	e.int32(partition)
+	e.int64(ts)
+	d, err := bc.roundTrip(apiListOffsets, e.b, 0)
+	if err != nil {
+		return 0, c.checkLeader(topic, err)
+	}
+	var offset int64
+	err = errors.New("kafka: partition missing in list offsets response")
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for pn := d.arrayLen(); pn > 0; pn-- {
+			d.int32()
+			code := d.int16()
+			d.int64() // timestamp
+			offset = d.int64()
+			err = codeError(code)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return offset, c.checkLeader(topic, err)
+}
+
+// Fetch 从offset开始获取消息, 没有新消息时服务端最多等待maxWait. 同时返回分区的high watermark
+func (c *Client) Fetch(topic string, partition int32, offset int64, maxWait time.Duration, maxBytes int32) ([]*Message, int64, error) {
+	bc, err := c.leader(topic, partition)
+	if err != nil {
+		return nil, 0, err
+	}
+	e := &encoder{}
+	e.int32(-1) // replica_id
+	e.int32(int32(maxWait / time.Millisecond))
+	e.int32(1) // min_bytes
+	e.int32(maxBytes)
+	e.int8(1) // isolation_level: 只读取已提交的事务消息
+	e.arrayLen(1)
+	e.string(topic)
+	e.arrayLen(1)
+	e.int32(partition)
+	e.int64(offset)
+	e.int32(maxBytes)
+	d, err := bc.roundTrip(apiFetch, e.b, maxWait)
+	if err != nil {
+		return nil, 0, c.checkLeader(topic, err)
+	}
+	d.int32() // throttle_time_ms
+	var (
+		records []byte
+		hw      int64
+	)
+	err = errors.New("kafka: partition missing in fetch response")
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for pn := d.arrayLen(); pn > 0; pn-- {
+			d.int32()
+			code := d.int16()
+			hw = d.int64()
+			d.int64() // last_stable_offset
+			for an := d.arrayLen(); an > 0; an-- {
+				d.int64()
+				d.int64()
+			}
+			records = d.bytes()
+			err = codeError(code)
+		}
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	if err != nil {
+		return nil, 0, c.checkLeader(topic, err)
+	}
+	msgs, err := decodeBatches(topic, partition, records, offset)
+	return msgs, hw, err
+}
+
+// Produce 发送消息并等待所有同步副本确认. 有key的消息按key的哈希选择分区, 否则写入分区0
+func (c *Client) Produce(topic string, msgs ...*Message) error {
+	ps, err := c.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	if len(ps) == 0 {
+		return fmt.Errorf("kafka: topic %s has no partitions", topic)
+	}
+	byPartition := map[int32][]*Message{}
+	for _, m := range msgs {
+		var p int32
+		if m.Key != nil {
+			h := fnv.New32a()
+			h.Write(m.Key)
+			p = int32(h.Sum32() % uint32(len(ps)))
+		}
+		byPartition[p] = append(byPartition[p], m)
+	}
+	now := time.Now()
+	for p, ms := range byPartition {
+		bc, err := c.leader(topic, p)
+		if err != nil {
+			return err
+		}
+		e := &encoder{}
+		e.nullString("") // transactional_id
+		e.int16(-1)      // acks: 所有同步副本
+		e.int32(int32(c.timeout / time.Millisecond))
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(p)
+		e.bytes(encodeBatch(ms, now))
+		d, err := bc.roundTrip(apiProduce, e.b, 0)
+		if err != nil {
+			return c.checkLeader(topic, err)
+		}
+		err = errors.New("kafka: partition missing in produce response")
+		for n := d.arrayLen(); n > 0; n-- {
+			d.string()
+			for pn := d.arrayLen(); pn > 0; pn-- {
+				d.int32()
+				code := d.int16()
+				d.int64() // base_offset
+				d.int64() // log_append_time
+				err = codeError(code)
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+		if err != nil {
+			return fmt.Errorf("kafka: produce to %s/%d: %w", topic, p, c.checkLeader(topic, err))
+		}
+	}
+	return nil
+}
+
+// coordinator 返回group的coordinator连接
+func (c *Client) coordinator(group string) (*brokerConn, error) {
+	c.mu.Lock()
+	addr, ok := c.coordinators[group]
+	c.mu.Unlock()
+	if ok {
+		return c.conn(addr), nil
+	}
+	e := &encoder{}
+	e.string(group)
+	err := c.any(func(bc *brokerConn) error {
+		d, err := bc.roundTrip(apiFindCoordinator, e.b, 0)
+		if err != nil {
+			return err
+		}
+		code := d.int16()
+		d.int32() // node_id
+		host := d.string()
+		port := d.int32()
+		if d.err != nil {
+			return d.err
+		}
+		if err := codeError(code); err != nil {
+			return err
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kafka: find coordinator for %s: %w", group, err)
+	}
+	c.mu.Lock()
+	c.coordinators[group] = addr
+	c.mu.Unlock()
+	return c.conn(addr), nil
+}
+
+func (c *Client) checkCoordinator(group string, err error) error {
+	if stale(err) {
+		c.mu.Lock()
+		delete(c.coordinators, group)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// FetchOffsets 返回group在各分区已提交的offset, 没有提交过的分区为-1
+func (c *Client) FetchOffsets(group, topic string, partitions []int32) (map[int32]int64, error) {
+	bc, err := c.coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{}
+	e.string(group)
+	e.arrayLen(1)
+	e.string(topic)
+	e.arrayLen(len(partitions))
+	for _, p := range partitions {
+		e.int32(p)
+	}
+	d, err := bc.roundTrip(apiOffsetFetch, e.b, 0)
+	if err != nil {
+		return nil, c.checkCoordinator(group, err)
+	}
+	offsets := map[int32]int64{}
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for pn := d.arrayLen(); pn > 0; pn-- {
+			p := d.int32()
+			offset := d.int64()
+			d.string() // metadata
+			if code := d.int16(); code != 0 && err == nil {
+				err = codeError(code)
+			}
+			offsets[p] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err != nil {
+		return nil, c.checkCoordinator(group, err)
+	}
+	return offsets, nil
+}
+
+// CommitOffsets 以group的名义提交offsets(下一条要处理的消息的offset).
+// 不加入group, 使用generation -1提交, 要求没有其他成员以consumer group协议使用同名group
+func (c *Client) CommitOffsets(group, topic string, offsets map[int32]int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	bc, err := c.coordinator(group)
+	if err != nil {
+		return err
+	}
+	e := &encoder{}
+	e.string(group)
+	e.int32(-1)  // generation_id
+	e.string("") // member_id
+	e.int64(-1)  // retention_time: 使用broker的默认值
+	e.arrayLen(1)
+	e.string(topic)
+	e.arrayLen(len(offsets))
+	for p, o := range offsets {
+		e.int32(p)
+		e.int64(o)
+		e.nullString("")
+	}
+	d, err := bc.roundTrip(apiOffsetCommit, e.b, 0)
+	if err != nil {
+		return c.checkCoordinator(group, err)
+	}
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for pn := d.arrayLen(); pn > 0; pn-- {
+			d.int32()
+			if code := d.int16(); code != 0 && err == nil {
+				err = codeError(code)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return c.checkCoordinator(group, err)
+}
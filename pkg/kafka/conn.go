@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxResponseSize 单个响应的上限, 防止错误的长度导致过大的分配
+const maxResponseSize = 64 << 20
+
+// brokerConn 到一个broker的连接, 同一时间只有一个请求在进行. 出错后关闭连接, 下次请求时重新建立
+type brokerConn struct {
+	addr     string
+	clientID string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	corr int32
+}
+
+// roundTrip 发送请求并返回响应体(不含correlation ID). wait为服务端可能等待的额外时间, 如Fetch的max_wait
+func (c *brokerConn) roundTrip(api int16, body []byte, wait time.Duration) (*decoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, bufio.NewReader(conn)
+	}
+	c.corr++
+	h := &encoder{}
+	h.int32(0) // 长度, 稍后填充
+	h.int16(api)
+	h.int16(apiVersions[api])
+	h.int32(c.corr)
+	h.string(c.clientID)
+	req := append(h.b, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout + wait))
+	resp, err := c.send(req)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return nil, fmt.Errorf("kafka: %s: %w", c.addr, err)
+	}
+	return &decoder{b: resp}, nil
+}
+
+func (c *brokerConn) send(req []byte) ([]byte, error) {
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, err
+	}
+	var head [8]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(head[:]))
+	if corr := int32(binary.BigEndian.Uint32(head[4:])); corr != c.corr {
+		return nil, fmt.Errorf("correlation id mismatch: got %d, want %d", corr, c.corr)
+	}
+	if size < 4 || size > maxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *brokerConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
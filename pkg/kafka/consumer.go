@@ -0,0 +1,364 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/leader"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/tracing"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// 消息头
+const (
+	// CommandHeader 消息对应的命令, 用于选择Handler, 如 user.import
+	CommandHeader = "command"
+	// DLQErrorHeader 转入死信topic的消息上记录最后一次处理错误
+	DLQErrorHeader = "x-dlq-error"
+	// DLQSourceHeader 转入死信topic的消息上记录原消息的位置, 格式 topic/partition/offset
+	DLQSourceHeader = "x-dlq-source"
+)
+
+// ConsumerConfig 消费配置
+type ConsumerConfig struct {
+	// Topic 消费的topic, 为空时不消费
+	Topic string `json:"topic"`
+	// Group 提交offset使用的group名
+	Group string `json:"group"`
+	// DLQTopic 处理失败的消息转入的topic, 为空时只记录日志后跳过
+	DLQTopic string `json:"dlq_topic"`
+	// StartOffset 没有提交过offset或offset已过期时的起点: earliest或latest
+	StartOffset string `json:"start_offset"`
+	// MaxAttempts 每条消息最多处理的次数, 默认3
+	MaxAttempts int `json:"max_attempts"`
+	// RetryBackoff 两次处理之间的等待时间, 之后每次翻倍, 如 "1s"
+	RetryBackoff string `json:"retry_backoff"`
+	// CommitInterval 提交offset的间隔, 如 "5s"
+	CommitInterval string `json:"commit_interval"`
+	// MaxWait 没有新消息时Fetch在服务端等待的时间, 如 "500ms"
+	MaxWait string `json:"max_wait"`
+	// MaxBytes 每次Fetch每个分区最多返回的字节数, 默认1MB
+	MaxBytes int32 `json:"max_bytes"`
+}
+
+// Handler 处理一条消息. 返回错误时按配置重试, 仍失败时转入死信topic
+type Handler func(ctx context.Context, m *Message) error
+
+var (
+	messagesTotal = metrics.NewCounterVec("kafka_consumer_messages_total",
+		"Number of consumed messages by command and result (success, dlq or dropped).", "command", "result")
+	attemptsTotal = metrics.NewCounterVec("kafka_consumer_handler_errors_total",
+		"Number of failed handler attempts by command.", "command")
+	consumerLag = metrics.NewGaugeVec("kafka_consumer_lag",
+		"Number of messages between the last fetched offset and the high watermark.", "topic", "partition")
+)
+
+// Consumer 消费一个topic的所有分区, 按CommandHeader把消息交给Handler. 同一分区的消息顺序处理,
+// offset在消息处理完成(成功或转入死信topic)后定期提交, 因此重启后可能重复处理少量消息, Handler应保证幂等
+type Consumer struct {
+	client      *Client
+	c           ConsumerConfig
+	elector     *leader.Elector
+	start       int64
+	maxAttempts int
+	backoff     time.Duration
+	commitEvery time.Duration
+	maxWait     time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	started  bool
+	// offsets 各分区已处理完成、待提交的offset
+	offsets map[int32]int64
+}
+
+// NewConsumer 创建消费者. elector不为nil时只在本实例为leader时消费, 避免多个实例重复处理
+func NewConsumer(client *Client, c ConsumerConfig, elector *leader.Elector) (*Consumer, error) {
+	if c.Topic == "" || c.Group == "" {
+		return nil, errors.New("kafka: consumer topic and group are required")
+	}
+	cs := &Consumer{
+		client:      client,
+		c:           c,
+		elector:     elector,
+		start:       OffsetEarliest,
+		maxAttempts: c.MaxAttempts,
+		backoff:     time.Second,
+		commitEvery: 5 * time.Second,
+		maxWait:     500 * time.Millisecond,
+		handlers:    map[string]Handler{},
+		offsets:     map[int32]int64{},
+	}
+	switch c.StartOffset {
+	case "", "earliest":
+	case "latest":
+		cs.start = OffsetLatest
+	default:
+		return nil, fmt.Errorf("kafka: invalid start_offset %q", c.StartOffset)
+	}
+	if cs.maxAttempts <= 0 {
+		cs.maxAttempts = 3
+	}
+	if cs.c.MaxBytes <= 0 {
+		cs.c.MaxBytes = 1 << 20
+	}
+	for _, d := range []struct {
+		name string
+		s    string
+		v    *time.Duration
+	}{
+		{"retry_backoff", c.RetryBackoff, &cs.backoff},
+		{"commit_interval", c.CommitInterval, &cs.commitEvery},
+		{"max_wait", c.MaxWait, &cs.maxWait},
+	} {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("kafka: invalid %s %q", d.name, d.s)
+		}
+		*d.v = v
+	}
+	return cs, nil
+}
+
+// Handle 注册命令的Handler, 必须在Run之前调用
+func (cs *Consumer) Handle(command string, h Handler) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.started {
+		panic("kafka: Handle called after Run")
+	}
+	cs.handlers[command] = h
+}
+
+// Run 消费直到ctx取消, 退出前提交已处理的offset
+func (cs *Consumer) Run(ctx context.Context) {
+	cs.mu.Lock()
+	cs.started = true
+	cs.mu.Unlock()
+	for ctx.Err() == nil {
+		cctx, cancel := ctx, context.CancelFunc(func() {})
+		if cs.elector != nil {
+			var ok bool
+			if cctx, cancel, ok = cs.elector.LeaderContext(ctx); !ok {
+				sleep(ctx, time.Second)
+				continue
+			}
+		}
+		if err := cs.consume(cctx); err != nil {
+			zaplog.L().Warn("kafka: consume", zaplog.String("topic", cs.c.Topic), zaplog.Error(err))
+			sleep(cctx, cs.backoff)
+		}
+		cancel()
+	}
+}
+
+// consume 为每个分区启动一个goroutine, 直到ctx取消或获取分区信息失败
+func (cs *Consumer) consume(ctx context.Context) error {
+	ps, err := cs.client.Partitions(cs.c.Topic)
+	if err != nil {
+		return err
+	}
+	committed, err := cs.client.FetchOffsets(cs.c.Group, cs.c.Topic, ps)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	go func() {
+		t := time.NewTicker(cs.commitEvery)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				cs.commit()
+			}
+		}
+	}()
+	for _, p := range ps {
+		offset, ok := committed[p]
+		if !ok {
+			offset = -1
+		}
+		wg.Add(1)
+		go func(p int32, offset int64) {
+			defer wg.Done()
+			cs.consumePartition(ctx, p, offset)
+		}(p, offset)
+	}
+	wg.Wait()
+	close(done)
+	cs.commit()
+	return nil
+}
+
+func (cs *Consumer) commit() {
+	cs.mu.Lock()
+	offsets := cs.offsets
+	cs.offsets = map[int32]int64{}
+	cs.mu.Unlock()
+	if err := cs.client.CommitOffsets(cs.c.Group, cs.c.Topic, offsets); err != nil {
+		zaplog.L().Warn("kafka: commit offsets", zaplog.String("topic", cs.c.Topic), zaplog.Error(err))
+		// 放回去下次再提交, 期间更新的offset优先
+		cs.mu.Lock()
+		for p, o := range offsets {
+			if _, ok := cs.offsets[p]; !ok {
+				cs.offsets[p] = o
+			}
+		}
+		cs.mu.Unlock()
+	}
+}
+
+func (cs *Consumer) consumePartition(ctx context.Context, p int32, offset int64) {
+	label := strconv.Itoa(int(p))
+	for ctx.Err() == nil {
+		if offset < 0 {
+			o, err := cs.client.ListOffset(cs.c.Topic, p, cs.start)
+			if err != nil {
+				zaplog.L().Warn("kafka: list offset", zaplog.String("topic", cs.c.Topic), zaplog.Int("partition", int(p)), zaplog.Error(err))
+				sleep(ctx, cs.backoff)
+				continue
+			}
+			offset = o
+		}
+		msgs, hw, err := cs.client.Fetch(cs.c.Topic, p, offset, cs.maxWait, cs.c.MaxBytes)
+		if errors.Is(err, ErrOffsetOutOfRange) {
+			zaplog.L().Warn("kafka: offset out of range, resetting", zaplog.String("topic", cs.c.Topic),
+				zaplog.Int("partition", int(p)), zaplog.Int64("offset", offset))
+			offset = -1
+			continue
+		}
+		if err != nil {
+			zaplog.L().Warn("kafka: fetch", zaplog.String("topic", cs.c.Topic), zaplog.Int("partition", int(p)), zaplog.Error(err))
+			sleep(ctx, cs.backoff)
+			continue
+		}
+		for _, m := range msgs {
+			if !cs.process(ctx, m) {
+				return
+			}
+			offset = m.Offset + 1
+			cs.mu.Lock()
+			cs.offsets[p] = offset
+			cs.mu.Unlock()
+		}
+		consumerLag.WithLabelValues(cs.c.Topic, label).Set(float64(hw - offset))
+	}
+}
+
+// process 处理一条消息直到成功或转入死信topic, ctx取消时返回false, 消息的offset不提交
+func (cs *Consumer) process(ctx context.Context, m *Message) bool {
+	command := m.Header(CommandHeader)
+	cs.mu.Lock()
+	h, ok := cs.handlers[command]
+	cs.mu.Unlock()
+
+	var err error
+	if !ok {
+		command = "unknown"
+		err = fmt.Errorf("kafka: no handler for command %q", m.Header(CommandHeader))
+	} else {
+		wait := cs.backoff
+		for attempt := 1; ; attempt++ {
+			if err = cs.handle(ctx, command, h, m); err == nil {
+				messagesTotal.WithLabelValues(command, "success").Inc()
+				return true
+			}
+			attemptsTotal.WithLabelValues(command).Inc()
+			if attempt >= cs.maxAttempts || !sleep(ctx, wait) {
+				break
+			}
+			wait *= 2
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+
+	l := zaplog.L().With(zaplog.String("command", command), zaplog.String("topic", m.Topic),
+		zaplog.Int("partition", int(m.Partition)), zaplog.Int64("offset", m.Offset), zaplog.Error(err))
+	if cs.c.DLQTopic == "" {
+		l.Error("kafka: message dropped")
+		messagesTotal.WithLabelValues(command, "dropped").Inc()
+		return true
+	}
+	// 死信topic写入失败时不能跳过消息, 一直重试到成功或ctx取消
+	for {
+		derr := cs.client.Produce(cs.c.DLQTopic, dlqMessage(m, err))
+		if derr == nil {
+			break
+		}
+		l.Warn("kafka: produce to dlq", zaplog.String("dlq_error", derr.Error()))
+		if !sleep(ctx, cs.backoff) {
+			return false
+		}
+	}
+	l.Warn("kafka: message moved to dlq", zaplog.String("dlq_topic", cs.c.DLQTopic))
+	messagesTotal.WithLabelValues(command, "dlq").Inc()
+	return true
+}
+
+// handle 调用一次Handler. trace上下文、baggage和请求ID取自消息头, 与gRPC请求的metadata一致
+func (cs *Consumer) handle(ctx context.Context, command string, h Handler, m *Message) (err error) {
+	if sc, ok := tracing.ParseTraceParent(m.Header(ctxutil.TraceParentHeader)); ok {
+		ctx = ctxutil.WithTrace(ctx, sc.TraceID, sc.SpanID)
+	}
+	if b := m.Header(ctxutil.BaggageHeader); b != "" {
+		for k, v := range ctxutil.ParseBaggage([]string{b}) {
+			ctx = ctxutil.WithBaggage(ctx, k, v)
+		}
+	}
+	if id := m.Header(ctxutil.RequestIDHeader); id != "" {
+		ctx = ctxutil.WithRequestID(ctx, id)
+	}
+	ctx, span := tracing.Start(ctx, "kafka.consume "+command)
+	span.SetAttribute("messaging.kafka.topic", m.Topic)
+	span.SetAttribute("messaging.kafka.partition", int(m.Partition))
+	span.SetAttribute("messaging.kafka.offset", m.Offset)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("kafka: handler panic: %v", r)
+		}
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx = zaplog.With(ctx, zaplog.String("command", command), zaplog.Int64("offset", m.Offset))
+	return h(ctx, m)
+}
+
+// dlqMessage 复制原消息, 附加失败原因和原位置
+func dlqMessage(m *Message, err error) *Message {
+	headers := make([]Header, 0, len(m.Headers)+2)
+	for _, h := range m.Headers {
+		if h.Key != DLQErrorHeader && h.Key != DLQSourceHeader {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers,
+		Header{Key: DLQErrorHeader, Value: []byte(err.Error())},
+		Header{Key: DLQSourceHeader, Value: []byte(fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset))})
+	return &Message{Key: m.Key, Value: m.Value, Headers: headers}
+}
+
+// sleep 等待d, ctx取消时返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
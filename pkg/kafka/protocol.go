@@ -0,0 +1,233 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 使用的API及版本. 只使用不含tagged fields的旧版本, Kafka 0.11及以上(包括4.x)都支持
+const (
+	apiProduce         int16 = 0
+	apiFetch           int16 = 1
+	apiListOffsets     int16 = 2
+	apiMetadata        int16 = 3
+	apiOffsetCommit    int16 = 8
+	apiOffsetFetch     int16 = 9
+	apiFindCoordinator int16 = 10
+)
+
+var apiVersions = map[int16]int16{
+	apiProduce:         3,
+	apiFetch:           4,
+	apiListOffsets:     1,
+	apiMetadata:        1,
+	apiOffsetCommit:    2,
+	apiOffsetFetch:     1,
+	apiFindCoordinator: 0,
+}
+
+// Error Kafka协议中的错误码
+type Error int16
+
+// 用到的错误码
+const (
+	ErrOffsetOutOfRange        Error = 1
+	ErrUnknownTopicOrPartition Error = 3
+	ErrLeaderNotAvailable      Error = 5
+	ErrNotLeaderForPartition   Error = 6
+	ErrRequestTimedOut         Error = 7
+	ErrCoordinatorLoading      Error = 14
+	ErrCoordinatorNotAvailable Error = 15
+	ErrNotCoordinator          Error = 16
+)
+
+var errorNames = map[Error]string{
+	ErrOffsetOutOfRange:        "OFFSET_OUT_OF_RANGE",
+	ErrUnknownTopicOrPartition: "UNKNOWN_TOPIC_OR_PARTITION",
+	ErrLeaderNotAvailable:      "LEADER_NOT_AVAILABLE",
+	ErrNotLeaderForPartition:   "NOT_LEADER_OR_FOLLOWER",
+	ErrRequestTimedOut:         "REQUEST_TIMED_OUT",
+	ErrCoordinatorLoading:      "COORDINATOR_LOAD_IN_PROGRESS",
+	ErrCoordinatorNotAvailable: "COORDINATOR_NOT_AVAILABLE",
+	ErrNotCoordinator:          "NOT_COORDINATOR",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return "kafka: " + name
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+// staleMetadata 是否因为leader或coordinator变化导致, 重新获取元数据后可以重试
+func (e Error) staleMetadata() bool {
+	switch e {
+	case ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition,
+		ErrCoordinatorLoading, ErrCoordinatorNotAvailable, ErrNotCoordinator:
+		return true
+	}
+	return false
+}
+
+func codeError(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return Error(code)
+}
+
+var errShortResponse = errors.New("kafka: short response")
+
+// encoder 按Kafka协议的大端格式编码
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *encoder) int16(v int16) { e.b = append(e.b, byte(v>>8), byte(v)) }
+func (e *encoder) int32(v int32) { e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v)) }
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// nullString 空字符串编码为null
+func (e *encoder) nullString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+	e.string(s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) arrayLen(n int) { e.int32(int32(n)) }
+
+func (e *encoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+// varBytes record中的变长字节, nil编码为-1
+func (e *encoder) varBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder 解码响应, 出错后的读取都返回零值, 最后检查err
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortResponse
+		d.b = nil
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// arrayLen 返回数组长度, null数组为0
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		// 每个元素至少占一个字节, 防止错误的长度导致过大的分配
+		d.err = errShortResponse
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errShortResponse
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
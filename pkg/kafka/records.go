@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Header 消息头
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message 一条消息. 消费时Topic、Partition、Offset和Time由服务端填充
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Time      time.Time
+}
+
+// Header 返回第一个名为key的消息头的值
+func (m *Message) Header(key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// record batch(v2格式)中的压缩方式, 只支持不压缩和gzip
+const (
+	compressionNone = 0
+	compressionGzip = 1
+)
+
+// batchControl 事务控制消息所在batch的标记位
+const batchControl = 0x20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// decodeBatches 解析Fetch返回的record batch, 跳过offset小于minOffset的消息.
+// 响应末尾可能有被截断的batch, 直接忽略, 下次从它开始重新获取
+func decodeBatches(topic string, partition int32, data []byte, minOffset int64) ([]*Message, error) {
+	var msgs []*Message
+	for len(data) >= 17 {
+		baseOffset := int64(binary.BigEndian.Uint64(data))
+		size := int(int32(binary.BigEndian.Uint32(data[8:])))
+		// batch头部(不含baseOffset和batchLength)为49字节
+		if size < 49 || len(data) < 12+size {
+			break
+		}
+		batch := data[12 : 12+size]
+		data = data[12+size:]
+		if magic := batch[4]; magic != 2 {
+			return nil, fmt.Errorf("kafka: unsupported message format v%d", magic)
+		}
+		d := &decoder{b: batch}
+		d.int32() // partitionLeaderEpoch
+		d.int8()  // magic
+		d.int32() // crc
+		attrs := d.int16()
+		d.int32() // lastOffsetDelta
+		firstTimestamp := d.int64()
+		d.int64() // maxTimestamp
+		d.int64() // producerId
+		d.int16() // producerEpoch
+		d.int32() // baseSequence
+		count := d.int32()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if attrs&batchControl != 0 {
+			continue
+		}
+		switch attrs & 7 {
+		case compressionNone:
+		case compressionGzip:
+			zr, err := gzip.NewReader(bytes.NewReader(d.b))
+			if err != nil {
+				return nil, fmt.Errorf("kafka: gzip: %w", err)
+			}
+			b, err := io.ReadAll(zr)
+			if err != nil {
+				return nil, fmt.Errorf("kafka: gzip: %w", err)
+			}
+			d.b = b
+		default:
+			return nil, fmt.Errorf("kafka: unsupported compression codec %d", attrs&7)
+		}
+		for i := int32(0); i < count; i++ {
+			rd := &decoder{b: d.take(int(d.varint()))}
+			rd.int8() // attributes
+			tsDelta := rd.varint()
+			offsetDelta := rd.varint()
+			m := &Message{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    baseOffset + offsetDelta,
+				Key:       rd.varBytes(),
+				Value:     rd.varBytes(),
+				Time:      time.Unix(0, (firstTimestamp+tsDelta)*int64(time.Millisecond)),
+			}
+			for n := rd.varint(); n > 0 && rd.err == nil; n-- {
+				m.Headers = append(m.Headers, Header{Key: string(rd.varBytes()), Value: rd.varBytes()})
+			}
+			if d.err != nil || rd.err != nil {
+				return nil, errors.New("kafka: malformed record")
+			}
+			if m.Offset >= minOffset {
+				msgs = append(msgs, m)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+// encodeBatch 把消息编码为一个不压缩的record batch
+func encodeBatch(msgs []*Message, now time.Time) []byte {
+	ts := now.UnixNano() / int64(time.Millisecond)
+	e := &encoder{}
+	e.int64(0)  // baseOffset, 由服务端分配
+	e.int32(0)  // batchLength, 稍后填充
+	e.int32(-1) // partitionLeaderEpoch
+	e.int8(2)   // magic
+	e.int32(0)  // crc, 稍后填充
+	crcStart := len(e.b)
+	e.int16(compressionNone)
+	e.int32(int32(len(msgs) - 1)) // lastOffsetDelta
+	e.int64(ts)                   // firstTimestamp
+	e.int64(ts)                   // maxTimestamp
+	e.int64(-1)                   // producerId
+	e.int16(-1)                   // producerEpoch
+	e.int32(-1)                   // baseSequence
+	e.arrayLen(len(msgs))
+	for i, m := range msgs {
+		r := &encoder{}
+		r.int8(0)   // attributes
+		r.varint(0) // timestampDelta
+		r.varint(int64(i))
+		r.varBytes(m.Key)
+		r.varBytes(m.Value)
+		r.varint(int64(len(m.Headers)))
+		for _, h := range m.Headers {
+			r.varBytes([]byte(h.Key))
+			r.varBytes(h.Value)
+		}
+		e.varint(int64(len(r.b)))
+		e.b = append(e.b, r.b...)
+	}
+	binary.BigEndian.PutUint32(e.b[8:], uint32(len(e.b)-12))
+	binary.BigEndian.PutUint32(e.b[crcStart-4:], crc32.Checksum(e.b[crcStart:], castagnoli))
+	return e.b
+}
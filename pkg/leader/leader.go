@@ -0,0 +1,129 @@
+// Package leader 基于带过期时间的锁在多个实例间选主, 保证定时任务只在一个实例上执行
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// Lock 带过期时间的分布式锁
+type Lock interface {
+	// Acquire 尝试以id的身份持有key ttl时间; 已由id持有时续期. 返回是否持有锁
+	Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error)
+	// Release 释放由id持有的锁, 由其他实例持有时不做任何事
+	Release(ctx context.Context, key, id string) error
+}
+
+var (
+	isLeader = metrics.NewGaugeVec("leader_is_leader",
+		"Whether this instance currently holds leadership (1) or not (0).", "key")
+	transitions = metrics.NewCounterVec("leader_transitions_total",
+		"Number of leadership changes observed by this instance.", "key", "to")
+)
+
+// Elector 周期性地获取或续期锁, 持有锁期间本实例为leader
+type Elector struct {
+	lock Lock
+	key  string
+	id   string
+	ttl  time.Duration
+
+	mu     sync.RWMutex
+	leader bool
+	// lost 失去leader身份时关闭, 用于取消正在执行的任务
+	lost chan struct{}
+}
+
+// New 创建Elector, id应在实例间唯一(如 主机名+进程ID)
+func New(lock Lock, key, id string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+	isLeader.WithLabelValues(key).Set(0)
+	return &Elector{lock: lock, key: key, id: id, ttl: ttl}
+}
+
+// IsLeader 返回本实例当前是否为leader
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// LeaderContext 本实例为leader时返回在失去leader身份时取消的ctx, 否则返回false
+func (e *Elector) LeaderContext(parent context.Context) (context.Context, context.CancelFunc, bool) {
+	e.mu.RLock()
+	leader, lost := e.leader, e.lost
+	e.mu.RUnlock()
+	if !leader {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel, true
+}
+
+// Run 每ttl/3尝试一次获取或续期, 直到ctx取消; 退出时释放锁
+func (e *Elector) Run(ctx context.Context) {
+	t := time.NewTicker(e.ttl / 3)
+	defer t.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			if e.IsLeader() {
+				// ctx已取消, 使用新的ctx释放锁, 让其他实例尽快接管
+				rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				if err := e.lock.Release(rctx, e.key, e.id); err != nil {
+					log.Printf("leader: release %s: %v", e.key, err)
+				}
+				cancel()
+				e.set(false)
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (e *Elector) campaign(ctx context.Context) {
+	actx, cancel := context.WithTimeout(ctx, e.ttl/3)
+	ok, err := e.lock.Acquire(actx, e.key, e.id, e.ttl)
+	cancel()
+	if err != nil {
+		// 无法确认是否仍持有锁时放弃leader身份, 宁可短暂无leader也不出现两个leader
+		log.Printf("leader: acquire %s: %v", e.key, err)
+		ok = false
+	}
+	e.set(ok)
+}
+
+func (e *Elector) set(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader == leader {
+		return
+	}
+	e.leader = leader
+	if leader {
+		e.lost = make(chan struct{})
+		log.Printf("leader: %s became leader of %s", e.id, e.key)
+		isLeader.WithLabelValues(e.key).Set(1)
+		transitions.WithLabelValues(e.key, "leader").Inc()
+	} else {
+		close(e.lost)
+		log.Printf("leader: %s lost leadership of %s", e.id, e.key)
+		isLeader.WithLabelValues(e.key).Set(0)
+		transitions.WithLabelValues(e.key, "follower").Inc()
+	}
+}
@@ -0,0 +1,85 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/redis"
+)
+
+// LocalLock 进程内的Lock实现, 适用于单实例部署
+type LocalLock struct {
+	mu    sync.Mutex
+	locks map[string]localEntry
+}
+
+type localEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewLocalLock 创建进程内锁
+func NewLocalLock() *LocalLock {
+	return &LocalLock{locks: map[string]localEntry{}}
+}
+
+func (l *LocalLock) Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if e, ok := l.locks[key]; ok && e.id != id && now.Before(e.expires) {
+		return false, nil
+	}
+	l.locks[key] = localEntry{id: id, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (l *LocalLock) Release(ctx context.Context, key, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.locks[key]; ok && e.id == id {
+		delete(l.locks, key)
+	}
+	return nil
+}
+
+// 持有者为自己时续期, 否则在键不存在时获取
+const acquireScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+  return 1
+end
+return 0`
+
+// 只删除自己持有的锁
+const releaseScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// RedisLock 基于Redis键过期的Lock实现
+type RedisLock struct {
+	c *redis.Client
+}
+
+// NewRedisLock 创建基于Redis的锁
+func NewRedisLock(c *redis.Client) *RedisLock {
+	return &RedisLock{c: c}
+}
+
+func (l *RedisLock) Acquire(ctx context.Context, key, id string, ttl time.Duration) (bool, error) {
+	n, err := redis.Int(l.c.Do(ctx, "EVAL", acquireScript, 1, key, id, ttl.Milliseconds()))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (l *RedisLock) Release(ctx context.Context, key, id string) error {
+	_, err := l.c.Do(ctx, "EVAL", releaseScript, 1, key, id)
+	return err
+}
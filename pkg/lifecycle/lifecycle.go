@@ -0,0 +1,82 @@
+// Package lifecycle 管理后台组件(消费者、日志发送等)的启动和停止.
+// 组件按添加顺序启动, 停止时按相反顺序逐个取消并等待退出, 后添加的组件可以依赖先添加的组件.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Component 后台组件, 应运行到ctx取消, 并在返回前完成清理(如提交offset、发送缓冲的数据)
+type Component func(ctx context.Context)
+
+type running struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Manager 组件管理器, 并发安全
+type Manager struct {
+	mu         sync.Mutex
+	components []*running
+	stopped    bool
+}
+
+// New 创建Manager
+func New() *Manager {
+	return &Manager{}
+}
+
+// Go 在新的goroutine中启动组件; Shutdown之后调用时不启动
+func (m *Manager) Go(name string, c Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &running{name: name, cancel: cancel, done: make(chan struct{})}
+	m.components = append(m.components, r)
+	go func() {
+		defer close(r.done)
+		c(ctx)
+	}()
+}
+
+// OnShutdown 在Shutdown停止到这里时调用f, 用于关闭文件等不需要后台运行的资源; 与组件一起按添加的相反顺序执行
+func (m *Manager) OnShutdown(name string, f func()) {
+	m.Go(name, func(ctx context.Context) {
+		<-ctx.Done()
+		f()
+	})
+}
+
+// Shutdown 按启动的相反顺序停止组件, ctx到期时不再等待, 返回未及时退出的组件
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	cs := m.components
+	m.components = nil
+	m.mu.Unlock()
+
+	var pending []string
+	for i := len(cs) - 1; i >= 0; i-- {
+		r := cs[i]
+		r.cancel()
+		start := time.Now()
+		select {
+		case <-r.done:
+			log.Printf("lifecycle: %s stopped in %s", r.name, time.Since(start).Round(time.Millisecond))
+		case <-ctx.Done():
+			pending = append(pending, r.name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("lifecycle: components did not stop in time: %v", pending)
+	}
+	return nil
+}
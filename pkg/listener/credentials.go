@@ -0,0 +1,53 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Credentials 返回grpc.Creds使用的传输凭证. TLS握手由Listen返回的listener完成配置,
+// 凭证在*tls.Conn上执行握手并把连接状态作为credentials.TLSInfo提供给peer.FromContext, 明文连接原样使用,
+// 因此同一个grpc.Server可以同时服务TLS和明文的监听地址
+func Credentials() credentials.TransportCredentials {
+	return creds{}
+}
+
+type creds struct{}
+
+// plainInfo 明文连接的AuthInfo
+type plainInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (plainInfo) AuthType() string { return "insecure" }
+
+func (creds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return conn, plainInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}, nil
+	}
+	// grpc.Server在调用前已设置连接的握手超时
+	if err := tc.HandshakeContext(context.Background()); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return tc, credentials.TLSInfo{
+		State:          tc.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (creds) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, plainInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}, nil
+}
+
+func (creds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c creds) Clone() credentials.TransportCredentials { return c }
+
+func (creds) OverrideServerName(string) error { return nil }
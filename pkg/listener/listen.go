@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// TLSConfig 监听地址的TLS配置, CertFile为空时不使用TLS
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile 校验客户端证书的CA, 设置后客户端必须提供该CA签发的证书
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+// Enabled 是否使用TLS
+func (c TLSConfig) Enabled() bool { return c.CertFile != "" }
+
+// Config 一个监听地址
+type Config struct {
+	// Addr 监听地址, 如 ":8091"、"0.0.0.0:8091"、"[::1]:8091"
+	Addr string    `json:"addr"`
+	TLS  TLSConfig `json:"tls"`
+}
+
+// Validate 检查配置是否完整
+func (c Config) Validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("listener: addr is required")
+	}
+	if err := CheckAddr(c.Addr); err != nil {
+		return err
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("listener: %s: tls.cert_file and tls.key_file must be set together", c.Addr)
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		return fmt.Errorf("listener: %s: tls.client_ca_file requires tls.cert_file", c.Addr)
+	}
+	return nil
+}
+
+// CheckAddr 检查addr是否为 host:port 格式且端口在1-65535之间, host可以为空
+func CheckAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("listener: %s: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("listener: %s: port must be a number in [1, 65535]", addr)
+	}
+	return nil
+}
+
+// Listen 监听c.Addr, 配置了TLS时返回的listener在Accept的连接上进行TLS握手.
+// IPv4和IPv6地址分别只监听对应的协议, 因此可以同时配置 0.0.0.0:port 和 [::]:port; 主机名为空时同时监听两种协议
+func Listen(c Config) (net.Listener, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var tc *tls.Config
+	if c.TLS.Enabled() {
+		var err error
+		if tc, err = c.TLS.config(); err != nil {
+			return nil, fmt.Errorf("listener: %s: %w", c.Addr, err)
+		}
+	}
+	l, err := net.Listen(network(c.Addr), c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil {
+		l = tls.NewListener(l, tc)
+	}
+	return l, nil
+}
+
+// network 按地址中的IP字面量选择tcp4或tcp6, 避免IPv6通配地址同时占用IPv4端口
+func network(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+func (c TLSConfig) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// gRPC要求通过ALPN协商h2
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if c.ClientCAFile != "" {
+		b, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
@@ -0,0 +1,55 @@
+// Package listener 提供限制连接数和连接存活时间的net.Listener包装
+package listener
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// Limit 限制同时打开的连接数, 达到上限后Accept阻塞直到有连接关闭; n<=0时不限制
+func Limit(l net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return l
+	}
+	return netutil.LimitListener(l, n)
+}
+
+// MaxAge 在连接存活age(加上±10%的随机抖动, 避免客户端同时重连)再经过grace后关闭连接; age<=0时不限制.
+// 用于HTTP/h2c连接, 纯gRPC服务应使用keepalive.ServerParameters, 以便先发送GOAWAY.
+func MaxAge(l net.Listener, age, grace time.Duration) net.Listener {
+	if age <= 0 {
+		return l
+	}
+	return &maxAgeListener{Listener: l, age: age, grace: grace}
+}
+
+type maxAgeListener struct {
+	net.Listener
+	age, grace time.Duration
+}
+
+func (l *maxAgeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	jitter := time.Duration(rand.Int63n(int64(l.age)/5+1)) - l.age/10
+	ac := &agedConn{Conn: c}
+	ac.timer = time.AfterFunc(l.age+jitter+l.grace, func() { ac.Conn.Close() })
+	return ac, nil
+}
+
+type agedConn struct {
+	net.Conn
+	once  sync.Once
+	timer *time.Timer
+}
+
+func (c *agedConn) Close() error {
+	c.once.Do(func() { c.timer.Stop() })
+	return c.Conn.Close()
+}
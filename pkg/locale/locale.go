@@ -0,0 +1,95 @@
+// Package locale 解析Accept-Language并生成语言回退链
+package locale
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// 读取语言偏好的metadata key, 经gateway转发的HTTP头带有grpcgateway-前缀
+var metadataKeys = []string{"accept-language", "grpcgateway-accept-language"}
+
+// Normalize 统一语言标签格式, 如 zh_CN -> zh-cn
+func Normalize(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// Base 返回主语言, 如 zh-cn -> zh
+func Base(tag string) string {
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// Parse 解析Accept-Language, 按q值从高到低返回语言标签, 忽略 * 和 q=0
+func Parse(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var list []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := Normalize(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		list = append(list, weighted{tag, q})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	tags := make([]string, 0, len(list))
+	for _, w := range list {
+		tags = append(tags, w.tag)
+	}
+	return tags
+}
+
+// Chain 生成回退链: 依次为每个标签及其主语言, 最后是fallback, 去重
+func Chain(tags []string, fallback string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(t string) {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	for _, t := range tags {
+		t = Normalize(t)
+		add(t)
+		add(Base(t))
+	}
+	add(Normalize(fallback))
+	return out
+}
+
+// FromIncomingContext 从incoming metadata中读取语言偏好
+func FromIncomingContext(ctx context.Context) []string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	for _, k := range metadataKeys {
+		if vs := md.Get(k); len(vs) > 0 {
+			return Parse(strings.Join(vs, ","))
+		}
+	}
+	return nil
+}
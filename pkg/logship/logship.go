@@ -0,0 +1,356 @@
+// Package logship 把日志批量发送到Loki或Elasticsearch的bulk接口, 用于没有节点日志采集agent的部署.
+// Shipper作为zaplog的额外输出使用: Write只把日志行放入有界队列, 队列满时丢弃, 不阻塞记录日志的请求.
+package logship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// 发送目标类型
+const (
+	// TypeNone 不发送
+	TypeNone = ""
+	// TypeLoki 发送到Loki的 /loki/api/v1/push
+	TypeLoki = "loki"
+	// TypeElasticsearch 发送到Elasticsearch(或兼容的ELK接收端)的 /_bulk
+	TypeElasticsearch = "elasticsearch"
+)
+
+// Config 日志发送配置
+type Config struct {
+	// Type 目标类型: 空(不发送)、loki或elasticsearch
+	Type string `json:"type"`
+	// URL 目标服务地址, 如 http://loki:3100 或 http://elasticsearch:9200
+	URL string `json:"url"`
+	// Index elasticsearch的索引名, 默认greeter-logs
+	Index string `json:"index"`
+	// Level 发送的最低日志级别, 为空时与log.level相同
+	Level string `json:"level"`
+	// Service, Env, Instance 附加到每条日志的标签, Instance为空时使用主机名
+	Service  string `json:"service"`
+	Env      string `json:"env"`
+	Instance string `json:"instance"`
+	// Labels 其他附加标签
+	Labels map[string]string `json:"labels"`
+	// Headers 附加的请求头, 如鉴权或多租户的X-Scope-OrgID
+	Headers map[string]string `json:"headers"`
+	// QueueSize 等待发送的日志行上限, 超出时丢弃新的日志, 默认10000
+	QueueSize int `json:"queue_size"`
+	// BatchSize 每批发送的日志行数上限, 默认500
+	BatchSize int `json:"batch_size"`
+	// FlushInterval 不满一批时的发送间隔, 如 "1s"
+	FlushInterval string `json:"flush_interval"`
+	// MaxRetries 一批发送失败后的重试次数, 仍失败时丢弃这一批, 默认3
+	MaxRetries int `json:"max_retries"`
+	// Timeout 单次发送超时, 如 "5s"
+	Timeout string `json:"timeout"`
+}
+
+var (
+	linesShipped = metrics.NewCounterVec("logship_lines_shipped_total",
+		"Number of log lines sent to the log backend.")
+	linesDropped = metrics.NewCounterVec("logship_lines_dropped_total",
+		"Number of log lines dropped by reason (queue_full or send_failed).", "reason")
+	queuedLines = metrics.NewGaugeVec("logship_queued_lines",
+		"Number of log lines waiting to be sent.")
+)
+
+// line 一条待发送的日志
+type line struct {
+	ts   time.Time
+	data []byte
+}
+
+// encoder 把一批日志编码为目标服务的请求
+type encoder interface {
+	path() string
+	contentType() string
+	encode(lines []line) ([]byte, error)
+}
+
+// Shipper 日志发送器, 实现io.Writer
+type Shipper struct {
+	enc        encoder
+	url        string
+	headers    map[string]string
+	client     *http.Client
+	ch         chan line
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	// failing 上一批是否发送失败, 只在状态变化时打印, 避免目标不可用时刷屏
+	failing bool
+}
+
+// New 创建发送器, 需调用Run开始发送
+func New(c Config) (*Shipper, error) {
+	if c.URL == "" {
+		return nil, errors.New("logship: url is required")
+	}
+	labels := map[string]string{}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	if c.Service == "" {
+		c.Service = "greeter"
+	}
+	if c.Instance == "" {
+		c.Instance, _ = os.Hostname()
+	}
+	labels["service"] = c.Service
+	labels["instance"] = c.Instance
+	if c.Env != "" {
+		labels["env"] = c.Env
+	}
+
+	s := &Shipper{url: c.URL, headers: c.Headers, batchSize: c.BatchSize, maxRetries: c.MaxRetries, interval: time.Second}
+	switch c.Type {
+	case TypeLoki:
+		s.enc = lokiEncoder{labels: labels}
+	case TypeElasticsearch:
+		if c.Index == "" {
+			c.Index = "greeter-logs"
+		}
+		enc, err := newBulkEncoder(c.Index, labels)
+		if err != nil {
+			return nil, err
+		}
+		s.enc = enc
+	default:
+		return nil, fmt.Errorf("logship: unknown type %q", c.Type)
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10000
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = 500
+	}
+	if s.maxRetries <= 0 {
+		s.maxRetries = 3
+	}
+	if c.FlushInterval != "" {
+		d, err := time.ParseDuration(c.FlushInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("logship: invalid flush_interval %q", c.FlushInterval)
+		}
+		s.interval = d
+	}
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("logship: timeout: %w", err)
+		}
+		timeout = d
+	}
+	s.client = &http.Client{Timeout: timeout}
+	s.ch = make(chan line, c.QueueSize)
+	return s, nil
+}
+
+// Write 把一行日志放入队列, 队列满时丢弃. 总是返回成功, 发送失败不影响本地日志输出
+func (s *Shipper) Write(p []byte) (int, error) {
+	l := line{ts: time.Now(), data: append([]byte(nil), bytes.TrimRight(p, "\n")...)}
+	select {
+	case s.ch <- l:
+	default:
+		linesDropped.WithLabelValues("queue_full").Inc()
+	}
+	return len(p), nil
+}
+
+// Run 批量发送队列中的日志直到ctx取消, 取消后发送剩余的日志
+func (s *Shipper) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	batch := make([]line, 0, s.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			for len(s.ch) > 0 {
+				batch = append(batch, <-s.ch)
+				if len(batch) == s.batchSize {
+					s.send(context.Background(), batch, 0)
+					batch = batch[:0]
+				}
+			}
+			if len(batch) > 0 {
+				s.send(context.Background(), batch, 0)
+			}
+			return
+		case l := <-s.ch:
+			batch = append(batch, l)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-t.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		queuedLines.WithLabelValues().Set(float64(len(s.ch)))
+		s.send(ctx, batch, s.maxRetries)
+		batch = make([]line, 0, s.batchSize)
+	}
+}
+
+// send 发送一批日志, 失败时按指数退避重试. 重试期间新的日志在队列中等待, 队列满后丢弃
+func (s *Shipper) send(ctx context.Context, batch []line, retries int) {
+	body, err := s.enc.encode(batch)
+	if err != nil {
+		linesDropped.WithLabelValues("send_failed").Add(float64(len(batch)))
+		return
+	}
+	wait := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = s.post(ctx, body)
+		if err == nil {
+			linesShipped.WithLabelValues().Add(float64(len(batch)))
+			if s.failing {
+				s.failing = false
+				log.Println("logship: sending logs recovered")
+			}
+			return
+		}
+		if attempt >= retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			attempt = retries
+		case <-time.After(wait):
+			wait *= 2
+		}
+	}
+	linesDropped.WithLabelValues("send_failed").Add(float64(len(batch)))
+	// 不能写到zaplog, 否则这条日志又会进入发送队列
+	if !s.failing {
+		s.failing = true
+		log.Printf("logship: send logs to %s: %v", s.url, err)
+	}
+}
+
+func (s *Shipper) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+s.enc.path(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.enc.contentType())
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// lokiEncoder 按级别分组为Loki stream. 级别是唯一取自日志内容的标签, 避免标签基数过高
+type lokiEncoder struct {
+	labels map[string]string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (lokiEncoder) path() string        { return "/loki/api/v1/push" }
+func (lokiEncoder) contentType() string { return "application/json" }
+
+func (e lokiEncoder) encode(lines []line) ([]byte, error) {
+	streams := map[string]*lokiStream{}
+	var levels []string
+	for _, l := range lines {
+		lv := levelOf(l.data)
+		st, ok := streams[lv]
+		if !ok {
+			st = &lokiStream{Stream: map[string]string{"level": lv}}
+			for k, v := range e.labels {
+				st.Stream[k] = v
+			}
+			streams[lv] = st
+			levels = append(levels, lv)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(l.ts.UnixNano(), 10), string(l.data)})
+	}
+	sort.Strings(levels)
+	req := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, lv := range levels {
+		req.Streams = append(req.Streams, streams[lv])
+	}
+	return json.Marshal(&req)
+}
+
+func levelOf(data []byte) string {
+	var v struct {
+		Level string `json:"level"`
+	}
+	if json.Unmarshal(data, &v) != nil || v.Level == "" {
+		return "unknown"
+	}
+	return v.Level
+}
+
+// bulkEncoder 编码为Elasticsearch bulk请求, 标签作为字段加到每条日志的开头
+type bulkEncoder struct {
+	action []byte
+	fields []byte
+}
+
+func newBulkEncoder(index string, labels map[string]string) (*bulkEncoder, error) {
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+	if err != nil {
+		return nil, err
+	}
+	fields, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+	// 去掉花括号, 得到 "k":"v",... 形式的片段
+	fields = fields[1 : len(fields)-1]
+	return &bulkEncoder{action: action, fields: fields}, nil
+}
+
+func (bulkEncoder) path() string        { return "/_bulk" }
+func (bulkEncoder) contentType() string { return "application/x-ndjson" }
+
+func (e *bulkEncoder) encode(lines []line) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if len(l.data) < 2 || l.data[0] != '{' {
+			continue
+		}
+		buf.Write(e.action)
+		buf.WriteByte('\n')
+		buf.WriteByte('{')
+		if len(e.fields) > 0 {
+			buf.Write(e.fields)
+			buf.WriteByte(',')
+		}
+		buf.Write(l.data[1:])
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
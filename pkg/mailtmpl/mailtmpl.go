@@ -0,0 +1,226 @@
+// Package mailtmpl 渲染内嵌的邮件模板. 每个模板按语言各有一份纯文本(必需)和HTML(可选)版本,
+// 文件名为 templates/<模板>.<语言>.txt|html, 纯文本模板中的 {{define "subject"}} 为邮件标题.
+package mailtmpl
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/locale"
+	"github.com/Q1mi/greeter/pkg/notify"
+)
+
+// 内置模板
+const (
+	// VerifyEmail 注册后的邮箱验证邮件, 数据为VerifyEmailData
+	VerifyEmail = "verify_email"
+	// SLOAlert SLO告警和恢复邮件, 数据为SLOAlertData
+	SLOAlert = "slo_alert"
+)
+
+// VerifyEmailData VerifyEmail模板的数据
+type VerifyEmailData struct {
+	Username string
+	// URL 完整的验证链接
+	URL string
+	// TTL 链接有效期, 如 "24h0m0s"
+	TTL string
+}
+
+// SLOAlertData SLOAlert模板的数据
+type SLOAlertData struct {
+	SLO    string
+	SLI    string
+	Firing bool
+	// BurnRate5m BurnRate1h 最短两个窗口的burn rate
+	BurnRate5m float64
+	BurnRate1h float64
+	Instance   string
+	Time       time.Time
+}
+
+// samples 预览模板时使用的示例数据
+var samples = map[string]interface{}{
+	VerifyEmail: VerifyEmailData{
+		Username: "alice",
+		URL:      "https://example.com/verify?token=sample-token",
+		TTL:      (24 * time.Hour).String(),
+	},
+	SLOAlert: SLOAlertData{
+		SLO:        "say_hello",
+		SLI:        "availability",
+		Firing:     true,
+		BurnRate5m: 20.5,
+		BurnRate1h: 15.2,
+		Instance:   "greeter-0",
+		Time:       time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC),
+	},
+}
+
+//go:embed templates
+var files embed.FS
+
+// Config 邮件模板配置
+type Config struct {
+	// DefaultLocale 请求的语言都没有对应模板时使用的语言
+	DefaultLocale string `json:"default_locale"`
+}
+
+// Rendered 渲染结果
+type Rendered struct {
+	// Locale 实际使用的模板语言
+	Locale  string
+	Subject string
+	Text    string
+	// HTML 模板没有HTML版本时为空
+	HTML string
+}
+
+// Message 生成发给to的通知
+func (r *Rendered) Message(to string) *notify.Message {
+	return &notify.Message{To: to, Subject: r.Subject, Body: r.Text, HTML: r.HTML}
+}
+
+type variant struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer 邮件模板渲染器, 创建后只读, 并发安全
+type Renderer struct {
+	fallback string
+	// templates 模板名 -> 语言 -> 模板
+	templates map[string]map[string]*variant
+}
+
+// New 解析内嵌的全部模板, 每个模板都必须有DefaultLocale的纯文本版本
+func New(c Config) (*Renderer, error) {
+	if c.DefaultLocale == "" {
+		c.DefaultLocale = "en"
+	}
+	r := &Renderer{fallback: locale.Normalize(c.DefaultLocale), templates: map[string]map[string]*variant{}}
+	entries, err := fs.ReadDir(files, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		parts := strings.Split(e.Name(), ".")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("mailtmpl: unexpected file name %q", e.Name())
+		}
+		name, loc, ext := parts[0], locale.Normalize(parts[1]), parts[2]
+		b, err := files.ReadFile(path.Join("templates", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if r.templates[name] == nil {
+			r.templates[name] = map[string]*variant{}
+		}
+		v := r.templates[name][loc]
+		if v == nil {
+			v = &variant{}
+			r.templates[name][loc] = v
+		}
+		switch ext {
+		case "txt":
+			v.text, err = texttemplate.New(e.Name()).Option("missingkey=error").Parse(string(b))
+			if err == nil && v.text.Lookup("subject") == nil {
+				err = fmt.Errorf("no subject defined")
+			}
+		case "html":
+			v.html, err = htmltemplate.New(e.Name()).Option("missingkey=error").Parse(string(b))
+		default:
+			err = fmt.Errorf("unknown extension")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mailtmpl: %s: %w", e.Name(), err)
+		}
+	}
+	for name, locs := range r.templates {
+		for loc, v := range locs {
+			if v.text == nil {
+				return nil, fmt.Errorf("mailtmpl: %s.%s has no text version", name, loc)
+			}
+		}
+		if locs[r.fallback] == nil {
+			return nil, fmt.Errorf("mailtmpl: %s has no %s version", name, r.fallback)
+		}
+	}
+	return r, nil
+}
+
+// Names 返回所有模板名, 按字母排序
+func (r *Renderer) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Locales 返回模板支持的语言, 按字母排序, 模板不存在时返回nil
+func (r *Renderer) Locales(name string) []string {
+	locs := r.templates[name]
+	if locs == nil {
+		return nil
+	}
+	out := make([]string, 0, len(locs))
+	for loc := range locs {
+		out = append(out, loc)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Sample 返回模板的示例数据, 用于预览
+func Sample(name string) (interface{}, bool) {
+	v, ok := samples[name]
+	return v, ok
+}
+
+// Render 按locales的回退链选择语言渲染模板, 没有匹配的语言时使用DefaultLocale
+func (r *Renderer) Render(name string, locales []string, data interface{}) (*Rendered, error) {
+	locs := r.templates[name]
+	if locs == nil {
+		return nil, fmt.Errorf("mailtmpl: unknown template %q", name)
+	}
+	for _, loc := range locale.Chain(locales, r.fallback) {
+		if v := locs[loc]; v != nil {
+			return v.render(loc, data)
+		}
+	}
+	// New保证了DefaultLocale版本存在
+	return locs[r.fallback].render(r.fallback, data)
+}
+
+func (v *variant) render(loc string, data interface{}) (*Rendered, error) {
+	out := &Rendered{Locale: loc}
+	var buf bytes.Buffer
+	if err := v.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return nil, fmt.Errorf("mailtmpl: %w", err)
+	}
+	// 标题中不能有换行
+	out.Subject = strings.Join(strings.Fields(buf.String()), " ")
+	buf.Reset()
+	if err := v.text.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("mailtmpl: %w", err)
+	}
+	out.Text = buf.String()
+	if v.html != nil {
+		buf.Reset()
+		if err := v.html.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("mailtmpl: %w", err)
+		}
+		out.HTML = buf.String()
+	}
+	return out, nil
+}
@@ -0,0 +1,190 @@
+// Package metering 按调用方计量API用量: 调用次数、请求和响应大小、计算时间单位,
+// 在内存中按(日期, 调用方, 方法)汇总后定期写入Store, 供内部调用方的结算和分摊使用.
+package metering
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultCallerHeader 默认标识调用方的metadata key, 经gateway访问时为HTTP头 Grpc-Metadata-X-Client-Id
+const DefaultCallerHeader = "x-client-id"
+
+// DateLayout 用量日期的格式
+const DateLayout = "2006-01-02"
+
+// Record 一个调用方一天(UTC)内调用一个方法的用量
+type Record struct {
+	Date   string
+	Caller string
+	Method string
+	Calls  int64
+	// Errors 返回错误的调用次数, 已包含在Calls中
+	Errors        int64
+	RequestBytes  int64
+	ResponseBytes int64
+	// ComputeUnits 每次调用按handler耗时的毫秒数向上取整, 至少为1
+	ComputeUnits int64
+}
+
+// Store 用量存储, Add把记录累加到已有的值上
+type Store interface {
+	Add(ctx context.Context, rs []*Record) error
+}
+
+var flushesTotal = metrics.NewCounterVec("metering_flushes_total",
+	"Number of usage flushes to the store by result.", "result")
+
+type key struct {
+	date, caller, method string
+}
+
+// Meter 计量拦截器, 并发安全
+type Meter struct {
+	header string
+	store  Store
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[key]*Record
+}
+
+// New 创建Meter. header为标识调用方的metadata key, 为空时使用DefaultCallerHeader
+func New(header string, store Store) *Meter {
+	if header == "" {
+		header = DefaultCallerHeader
+	}
+	return &Meter{header: header, store: store, now: time.Now, pending: map[key]*Record{}}
+}
+
+// Caller 返回请求的调用方: 优先使用header, 其次为已认证的用户(user:<id>), 最后为客户端IP
+func (m *Meter) Caller(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(m.header); len(vs) > 0 && vs[0] != "" {
+			return vs[0]
+		}
+	}
+	if id, ok := ctxutil.UserID(ctx); ok {
+		return "user:" + strconv.FormatInt(id, 10)
+	}
+	if ip := ctxutil.ClientIP(ctx); ip != "" {
+		return ip
+	}
+	return "unknown"
+}
+
+// UnaryServerInterceptor 记录每次调用的用量
+func (m *Meter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := m.now()
+		resp, err := handler(ctx, req)
+		elapsed := m.now().Sub(start)
+		r := &Record{
+			Date:   start.UTC().Format(DateLayout),
+			Caller: m.Caller(ctx),
+			Method: info.FullMethod,
+			Calls:  1,
+			// 不足1毫秒的调用按1个单位计
+			ComputeUnits: int64((elapsed + time.Millisecond - 1) / time.Millisecond),
+		}
+		if r.ComputeUnits < 1 {
+			r.ComputeUnits = 1
+		}
+		if err != nil {
+			r.Errors = 1
+		}
+		if pm, ok := req.(proto.Message); ok {
+			r.RequestBytes = int64(proto.Size(pm))
+		}
+		if pm, ok := resp.(proto.Message); ok && err == nil {
+			r.ResponseBytes = int64(proto.Size(pm))
+		}
+		m.add(r)
+		return resp, err
+	}
+}
+
+// add 把r合并到待写入的用量中
+func (m *Meter) add(r *Record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := key{r.Date, r.Caller, r.Method}
+	cur, ok := m.pending[k]
+	if !ok {
+		m.pending[k] = r
+		return
+	}
+	cur.Calls += r.Calls
+	cur.Errors += r.Errors
+	cur.RequestBytes += r.RequestBytes
+	cur.ResponseBytes += r.ResponseBytes
+	cur.ComputeUnits += r.ComputeUnits
+}
+
+// Flush 把待写入的用量写入Store, 失败时保留到下次重试
+func (m *Meter) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[key]*Record{}
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	rs := make([]*Record, 0, len(pending))
+	for _, r := range pending {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		return a.Method < b.Method
+	})
+	if err := m.store.Add(ctx, rs); err != nil {
+		flushesTotal.WithLabelValues("error").Inc()
+		for _, r := range rs {
+			m.add(r)
+		}
+		return err
+	}
+	flushesTotal.WithLabelValues("success").Inc()
+	return nil
+}
+
+// Run 每隔interval(不大于0时为30s)写入一次用量, ctx取消时最后写入一次
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.Flush(ctx); err != nil {
+				log.Printf("metering: flush: %v", err)
+			}
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := m.Flush(fctx); err != nil {
+				log.Printf("metering: final flush: %v", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
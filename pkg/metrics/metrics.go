@@ -0,0 +1,180 @@
+// Package metrics 实现Prometheus文本格式的指标导出, 支持counter、gauge和histogram.
+// 抓取方接受OpenMetrics格式时改用OpenMetrics输出, histogram分桶附带exemplar(如trace ID).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector 可以被Registry导出的指标
+type Collector interface {
+	// Name 指标名
+	Name() string
+	// write 按文本格式写出HELP/TYPE和样本, om为true时使用OpenMetrics格式
+	write(w *bufio.Writer, om bool)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry 创建空的Registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]Collector{}}
+}
+
+// Default 默认Registry, New*函数创建的指标都注册到这里
+var Default = NewRegistry()
+
+// Register 注册指标, 名字重复时返回错误
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("metrics: %s already registered", c.Name())
+	}
+	r.collectors[c.Name()] = c
+	return nil
+}
+
+// MustRegister 注册指标, 失败时panic
+func (r *Registry) MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ServeHTTP 输出所有指标, Accept包含OpenMetrics时使用OpenMetrics格式, 否则使用Prometheus文本格式
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for n := range r.collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	cs := make([]Collector, 0, len(names))
+	for _, n := range names {
+		cs = append(cs, r.collectors[n])
+	}
+	r.mu.RUnlock()
+
+	om := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if om {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.write(bw, om)
+	}
+	if om {
+		bw.WriteString("# EOF\n")
+	}
+	bw.Flush()
+}
+
+// Handler 返回导出Default的http.Handler
+func Handler() http.Handler { return Default }
+
+// desc 指标的公共部分
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d *desc) Name() string { return d.name }
+
+// header 写出HELP和TYPE. OpenMetrics中counter的指标族名不带_total后缀, HELP中的引号需要转义
+func (d *desc) header(w *bufio.Writer, typ string, om bool) {
+	name, help := d.name, escapeHelp(d.help)
+	if om {
+		name, help = d.familyName(typ), escapeLabel(d.help)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// familyName OpenMetrics的指标族名
+func (d *desc) familyName(typ string) string {
+	if typ == "counter" {
+		return strings.TrimSuffix(d.name, "_total")
+	}
+	return d.name
+}
+
+// key 把label值拼成map key
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelString 生成 {a="x",b="y"} 形式的label文本, extra追加在最后
+func (d *desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	n := 0
+	add := func(k, v string) {
+		if n > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(v))
+		b.WriteByte('"')
+		n++
+	}
+	for i, l := range d.labels {
+		add(l, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		add(extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+)
+
+// CounterVec 带label的counter
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*Counter
+	lvs    map[string][]string
+}
+
+// Counter 单调递增的计数
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// NewCounterVec 创建CounterVec并注册到Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, labels}, values: map[string]*Counter{}, lvs: map[string][]string{}}
+	Default.MustRegister(c)
+	return c
+}
+
+// WithLabelValues 返回对应label值的Counter, label值按创建时的顺序传入
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	k := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[k]
+	if !ok {
+		v = &Counter{}
+		c.values[k] = v
+		c.lvs[k] = append([]string(nil), values...)
+	}
+	return v
+}
+
+// Inc 加1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add 增加v, v必须非负
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+// Value 当前值
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+func (c *CounterVec) write(w *bufio.Writer, om bool) {
+	c.header(w, "counter", om)
+	name := c.name
+	if om {
+		// OpenMetrics的counter样本必须以_total结尾
+		name = c.familyName("counter") + "_total"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.lvs) {
+		fmt.Fprintf(w, "%s%s %s\n", name, c.labelString(c.lvs[k]), formatFloat(c.values[k].Value()))
+	}
+}
+
+// GaugeVec 带label的gauge
+type GaugeVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*Gauge
+	lvs    map[string][]string
+}
+
+// Gauge 可增可减的值
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// NewGaugeVec 创建GaugeVec并注册到Default
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{name, help, labels}, values: map[string]*Gauge{}, lvs: map[string][]string{}}
+	Default.MustRegister(g)
+	return g
+}
+
+// WithLabelValues 返回对应label值的Gauge
+func (g *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	k := g.key(values)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	v, ok := g.values[k]
+	if !ok {
+		v = &Gauge{}
+		g.values[k] = v
+		g.lvs[k] = append([]string(nil), values...)
+	}
+	return v
+}
+
+// Set 设置值
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+// Add 增加v, v可以为负
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.v += v
+	g.mu.Unlock()
+}
+
+// Inc 加1
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec 减1
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value 当前值
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+func (g *GaugeVec) write(w *bufio.Writer, om bool) {
+	g.header(w, "gauge", om)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range sortedKeys(g.lvs) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(g.lvs[k]), formatFloat(g.values[k].Value()))
+	}
+}
+
+// GaugeFunc 导出时调用fn取值的gauge
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc 创建GaugeFunc并注册到Default
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, fn: fn}
+	Default.MustRegister(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer, om bool) {
+	g.header(w, "gauge", om)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// DefBuckets 默认的histogram分桶(秒)
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec 带label的histogram
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*Histogram
+	lvs     map[string][]string
+}
+
+// Histogram 按分桶统计观测值的分布
+type Histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+	// exemplars 每个分桶(最后一个为+Inf)最近一次带exemplar的观测, 只在OpenMetrics格式中输出
+	exemplars []*exemplar
+}
+
+// exemplar 关联到一次观测的label, 如 trace_id
+type exemplar struct {
+	labels string
+	value  float64
+	time   time.Time
+}
+
+// maxExemplarRunes OpenMetrics规定exemplar的label名和值总共不超过128个字符
+const maxExemplarRunes = 128
+
+// NewHistogramVec 创建HistogramVec并注册到Default, buckets为nil时使用DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{desc: desc{name, help, labels}, buckets: b, values: map[string]*Histogram{}, lvs: map[string][]string{}}
+	Default.MustRegister(h)
+	return h
+}
+
+// WithLabelValues 返回对应label值的Histogram
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	k := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[k]
+	if !ok {
+		v = &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets)), exemplars: make([]*exemplar, len(h.buckets)+1)}
+		h.values[k] = v
+		h.lvs[k] = append([]string(nil), values...)
+	}
+	return v
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) { h.ObserveWithExemplar(v, nil) }
+
+// ObserveWithExemplar 记录一个观测值, 并把labels作为所在分桶的exemplar. labels为空或超过长度限制时与Observe相同
+func (h *Histogram) ObserveWithExemplar(v float64, labels map[string]string) {
+	var e *exemplar
+	if len(labels) > 0 {
+		keys, n := make([]string, 0, len(labels)), 0
+		for k, lv := range labels {
+			keys = append(keys, k)
+			n += utf8.RuneCountInString(k) + utf8.RuneCountInString(lv)
+		}
+		if n <= maxExemplarRunes {
+			sort.Strings(keys)
+			var b strings.Builder
+			for i, k := range keys {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, `%s="%s"`, k, escapeLabel(labels[k]))
+			}
+			e = &exemplar{labels: "{" + b.String() + "}", value: v, time: time.Now()}
+		}
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	if e != nil {
+		h.exemplars[i] = e
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// ObserveContext 记录一个观测值, ctx中有trace时以trace_id作为exemplar, 便于从慢请求的分桶跳转到对应的trace
+func (h *Histogram) ObserveContext(ctx context.Context, v float64) {
+	if traceID, _ := ctxutil.Trace(ctx); traceID != "" {
+		h.ObserveWithExemplar(v, map[string]string{"trace_id": traceID})
+		return
+	}
+	h.Observe(v)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer, om bool) {
+	h.header(w, "histogram", om)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.lvs) {
+		lv := h.lvs[k]
+		v := h.values[k]
+		v.mu.Lock()
+		var cum uint64
+		for i, ub := range h.buckets {
+			cum += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d", h.name, h.labelString(lv, "le", formatFloat(ub)), cum)
+			writeExemplar(w, om, v.exemplars[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d", h.name, h.labelString(lv, "le", formatFloat(math.Inf(1))), v.count)
+		writeExemplar(w, om, v.exemplars[len(h.buckets)])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(lv), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(lv), v.count)
+		v.mu.Unlock()
+	}
+}
+
+// writeExemplar 在OpenMetrics格式中追加exemplar并结束当前行
+func writeExemplar(w *bufio.Writer, om bool, e *exemplar) {
+	if om && e != nil {
+		fmt.Fprintf(w, " # %s %s %s", e.labels, formatFloat(e.value), strconv.FormatFloat(float64(e.time.UnixNano())/1e9, 'f', 3, 64))
+	}
+	w.WriteByte('\n')
+}
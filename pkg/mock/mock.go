@@ -0,0 +1,221 @@
+// Package mock 让选定的方法直接返回fixture文件中的响应, 不经过业务逻辑和数据库,
+// 供前端在后端功能完成前对接REST接口. fixture为响应消息的protojson, 可使用text/template变量.
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Header 模拟响应携带的metadata key, 经gateway返回为 Grpc-Metadata-X-Mock 响应头
+const Header = "x-mock"
+
+// Method 一个返回模拟响应的方法
+type Method struct {
+	// Method gRPC方法全名, 如 /helloworld.Greeter/SayHello
+	Method string `json:"method"`
+	// File fixture文件, 相对于Dir, 为空时为 <包名.服务名>/<方法名>.json
+	File string `json:"file"`
+	// Delay 返回前等待的时间, 用于模拟接口耗时, 如 "200ms"
+	Delay string `json:"delay"`
+}
+
+// Config 模拟响应配置
+type Config struct {
+	// Dir fixture文件所在目录
+	Dir string `json:"dir"`
+	// Methods 返回模拟响应的方法, 为空时不启用
+	Methods []Method `json:"methods"`
+}
+
+// Data fixture模板可用的变量
+type Data struct {
+	// Method gRPC方法全名
+	Method string
+	// Request 请求消息, 字段名与proto定义相同, 未设置的字段为零值
+	Request map[string]interface{}
+	// Metadata 请求metadata, 每个key只取第一个值
+	Metadata map[string]string
+	// RequestID 请求ID, 与日志中的request_id相同
+	RequestID string
+	// Now 当前时间
+	Now time.Time
+	// Seq 该方法的第几次调用, 从1开始
+	Seq int64
+}
+
+var responsesTotal = metrics.NewCounterVec("mock_responses_total",
+	"Number of mock responses by method and result.", "method", "result")
+
+var funcs = template.FuncMap{
+	// json 把值序列化为JSON, 用于在fixture中安全地输出字符串
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// rfc3339 按protojson的Timestamp格式输出时间
+	"rfc3339": func(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) },
+}
+
+type method struct {
+	file   string
+	delay  time.Duration
+	output protoreflect.MessageType
+}
+
+// Mocker 模拟响应拦截器. fixture在每次调用时读取, 修改后不需要重启
+type Mocker struct {
+	methods map[string]*method
+
+	mu  sync.Mutex
+	seq map[string]int64
+}
+
+// New 校验配置并创建Mocker, 方法必须已注册且fixture文件可被解析
+func New(c Config) (*Mocker, error) {
+	m := &Mocker{methods: map[string]*method{}, seq: map[string]int64{}}
+	for _, mc := range c.Methods {
+		md, err := findMethod(mc.Method)
+		if err != nil {
+			return nil, err
+		}
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+		if err != nil {
+			return nil, fmt.Errorf("mock: %s: %w", mc.Method, err)
+		}
+		file := mc.File
+		if file == "" {
+			file = filepath.Join(string(md.Parent().FullName()), string(md.Name())+".json")
+		}
+		mm := &method{file: filepath.Join(c.Dir, file), output: mt}
+		if mc.Delay != "" {
+			if mm.delay, err = time.ParseDuration(mc.Delay); err != nil {
+				return nil, fmt.Errorf("mock: %s: invalid delay: %w", mc.Method, err)
+			}
+		}
+		if _, err := mm.template(); err != nil {
+			return nil, err
+		}
+		m.methods[mc.Method] = mm
+	}
+	return m, nil
+}
+
+// UnaryServerInterceptor 对配置的方法返回fixture中的响应, 不调用handler
+func (m *Mocker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mm, ok := m.methods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		m.mu.Lock()
+		m.seq[info.FullMethod]++
+		seq := m.seq[info.FullMethod]
+		m.mu.Unlock()
+		resp, err := mm.respond(ctx, info.FullMethod, req, seq)
+		if err != nil {
+			responsesTotal.WithLabelValues(info.FullMethod, "error").Inc()
+			return nil, status.Errorf(codes.Internal, "mock: %v", err)
+		}
+		responsesTotal.WithLabelValues(info.FullMethod, "ok").Inc()
+		grpc.SetHeader(ctx, metadata.Pairs(Header, "true"))
+		if mm.delay > 0 {
+			t := time.NewTimer(mm.delay)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		}
+		return resp, nil
+	}
+}
+
+func (mm *method) template() (*template.Template, error) {
+	b, err := os.ReadFile(mm.file)
+	if err != nil {
+		return nil, fmt.Errorf("mock: %w", err)
+	}
+	t, err := template.New(filepath.Base(mm.file)).Funcs(funcs).Option("missingkey=zero").Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("mock: %w", err)
+	}
+	return t, nil
+}
+
+// respond 渲染fixture并解析为响应消息
+func (mm *method) respond(ctx context.Context, fullMethod string, req interface{}, seq int64) (proto.Message, error) {
+	t, err := mm.template()
+	if err != nil {
+		return nil, err
+	}
+	data := Data{Method: fullMethod, Request: map[string]interface{}{}, Metadata: map[string]string{},
+		RequestID: ctxutil.RequestID(ctx), Now: time.Now(), Seq: seq}
+	if pm, ok := req.(proto.Message); ok {
+		b, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(pm)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &data.Request); err != nil {
+			return nil, err
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, vs := range md {
+			if len(vs) > 0 {
+				data.Metadata[k] = vs[0]
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	out := mm.output.New().Interface()
+	if err := protojson.Unmarshal(buf.Bytes(), out); err != nil {
+		return nil, fmt.Errorf("%s: %w", mm.file, err)
+	}
+	return out, nil
+}
+
+// findMethod 按 /package.Service/Method 查找方法描述
+func findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndexByte(name, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("mock: invalid method %q", fullMethod)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return nil, fmt.Errorf("mock: service of %s: %w", fullMethod, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("mock: %s is not a service", name[:i])
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("mock: method %s not found", fullMethod)
+	}
+	return md, nil
+}
@@ -0,0 +1,267 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testScramble = []byte("0123456789abcdefghij")
+
+// fakeServer 在net.Pipe的另一端模拟caching_sha2_password完整认证的服务端, 复用conn的分包和序号
+type fakeServer struct {
+	*conn
+	t    *testing.T
+	key  *rsa.PrivateKey
+	cert *tls.Certificate
+}
+
+func (s *fakeServer) read() []byte {
+	s.t.Helper()
+	p, err := s.readPacket()
+	if err != nil {
+		s.t.Fatalf("server read: %v", err)
+	}
+	return p
+}
+
+func (s *fakeServer) write(p []byte) {
+	s.t.Helper()
+	if err := s.writePacket(p); err != nil {
+		s.t.Fatalf("server write: %v", err)
+	}
+}
+
+// hello 发送带有caps的握手包
+func (s *fakeServer) hello(caps uint32) {
+	s.t.Helper()
+	b := []byte{10}
+	b = append(b, "8.0.30\x00"...)
+	b = appendUint32(b, 1)
+	b = append(b, testScramble[:8]...)
+	b = append(b, 0, byte(caps), byte(caps>>8), charsetUTF8MB4, 2, 0, byte(caps>>16), byte(caps>>24), 21)
+	b = append(b, make([]byte, 10)...)
+	b = append(b, testScramble[8:]...)
+	b = append(b, 0)
+	b = append(b, "caching_sha2_password\x00"...)
+	s.write(b)
+}
+
+// greet 握手并读取客户端的认证响应, 有证书时先完成TLS握手
+func (s *fakeServer) greet() {
+	s.t.Helper()
+	caps := uint32(clientProtocol41 | clientSecureConnection | clientPluginAuth)
+	if s.cert != nil {
+		caps |= clientSSL
+	}
+	s.hello(caps)
+	p := s.read()
+	if s.cert != nil {
+		if len(p) != 32 || uint32(p[1])<<8&clientSSL == 0 {
+			s.t.Fatalf("got %x, want an SSLRequest", p)
+		}
+		tc := tls.Server(s.nc, &tls.Config{Certificates: []tls.Certificate{*s.cert}})
+		if err := tc.Handshake(); err != nil {
+			s.t.Fatalf("server tls handshake: %v", err)
+		}
+		s.nc, s.r, s.w = tc, bufio.NewReader(tc), bufio.NewWriter(tc)
+		s.read()
+	}
+	// 缓存中没有该用户, 要求完整认证
+	s.write([]byte{0x01, 4})
+}
+
+// decrypt 解出客户端用公钥加密的密码
+func (s *fakeServer) decrypt(enc []byte) string {
+	s.t.Helper()
+	pw, err := rsa.DecryptOAEP(sha1.New(), nil, s.key, enc, nil)
+	if err != nil {
+		s.t.Fatalf("decrypt password: %v", err)
+	}
+	for i := range pw {
+		pw[i] ^= testScramble[i%len(testScramble)]
+	}
+	return string(pw)
+}
+
+func (s *fakeServer) ok() { s.write([]byte{0x00, 0, 0, 2, 0, 0, 0}) }
+
+// runAuth 用c连接fakeServer, serve返回后关闭服务端, 返回客户端握手的结果
+func runAuth(t *testing.T, c *Config, cert *tls.Certificate, serve func(s *fakeServer)) error {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	server.SetDeadline(time.Now().Add(10 * time.Second))
+	done := make(chan error, 1)
+	go func() {
+		err := newConn(client, c).handshake()
+		if err != nil {
+			// 与Connect相同, 握手失败时关闭连接
+			client.Close()
+		}
+		done <- err
+	}()
+	s := &fakeServer{conn: newConn(server, &Config{}), t: t, key: testKey(t), cert: cert}
+	serve(s)
+	server.Close()
+	return <-done
+}
+
+var rsaKey *rsa.PrivateKey
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	if rsaKey == nil {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsaKey = k
+	}
+	return rsaKey
+}
+
+func pemPublicKey(t *testing.T, k *rsa.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// TestFullAuthNoKey 没有TLS、公钥和allowPublicKeyRetrieval时不索取公钥, 认证失败
+func TestFullAuthNoKey(t *testing.T) {
+	err := runAuth(t, &Config{User: "greeter", Password: "secret", Net: "tcp"}, nil, func(s *fakeServer) {
+		s.greet()
+		if p, err := s.readPacket(); err == nil {
+			t.Errorf("client sent %x after the full auth request", p)
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "allowPublicKeyRetrieval") {
+		t.Errorf("handshake: %v, want a full authentication error", err)
+	}
+}
+
+// TestFullAuthServerPubKey 使用配置的公钥加密, 不向服务端索取
+func TestFullAuthServerPubKey(t *testing.T) {
+	c := &Config{User: "greeter", Password: "secret", Net: "tcp", ServerPubKey: &testKey(t).PublicKey}
+	err := runAuth(t, c, nil, func(s *fakeServer) {
+		s.greet()
+		p := s.read()
+		if bytes.Equal(p, []byte{2}) {
+			t.Fatal("client requested the server public key")
+		}
+		if pw := s.decrypt(p); pw != "secret\x00" {
+			t.Errorf("server decrypted %q", pw)
+		}
+		s.ok()
+	})
+	if err != nil {
+		t.Errorf("handshake: %v", err)
+	}
+}
+
+// TestFullAuthRetrieval allowPublicKeyRetrieval=true时使用服务端返回的公钥
+func TestFullAuthRetrieval(t *testing.T) {
+	c := &Config{User: "greeter", Password: "secret", Net: "tcp", AllowPublicKeyRetrieval: true}
+	err := runAuth(t, c, nil, func(s *fakeServer) {
+		s.greet()
+		if p := s.read(); !bytes.Equal(p, []byte{2}) {
+			t.Fatalf("got %x, want a public key request", p)
+		}
+		s.write(append([]byte{0x01}, pemPublicKey(t, &s.key.PublicKey)...))
+		if pw := s.decrypt(s.read()); pw != "secret\x00" {
+			t.Errorf("server decrypted %q", pw)
+		}
+		s.ok()
+	})
+	if err != nil {
+		t.Errorf("handshake: %v", err)
+	}
+}
+
+// TestFullAuthTLS TLS连接上直接发送密码
+func TestFullAuthTLS(t *testing.T) {
+	cert := testCert(t)
+	c := &Config{User: "greeter", Password: "secret", Net: "tcp", TLS: &tls.Config{InsecureSkipVerify: true}}
+	err := runAuth(t, c, cert, func(s *fakeServer) {
+		s.greet()
+		if p := s.read(); string(p) != "secret\x00" {
+			t.Errorf("server got %q, want the password", p)
+		}
+		s.ok()
+	})
+	if err != nil {
+		t.Errorf("handshake: %v", err)
+	}
+}
+
+// TestTLSUnsupported 要求TLS而服务端不支持时不发送认证信息
+func TestTLSUnsupported(t *testing.T) {
+	c := &Config{User: "greeter", Password: "secret", Net: "tcp", TLS: &tls.Config{InsecureSkipVerify: true}}
+	err := runAuth(t, c, nil, func(s *fakeServer) {
+		s.hello(clientProtocol41 | clientSecureConnection | clientPluginAuth)
+		if p, err := s.readPacket(); err == nil {
+			t.Errorf("client sent %x to a server without TLS", p)
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "TLS") {
+		t.Errorf("handshake: %v, want a TLS error", err)
+	}
+}
+
+func TestParseDSNAuth(t *testing.T) {
+	c, err := ParseDSN("greeter:pass@tcp(db.internal:3306)/greeter?tls=true&allowPublicKeyRetrieval=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TLS == nil || c.TLS.ServerName != "db.internal" || c.TLS.InsecureSkipVerify || !c.AllowPublicKeyRetrieval {
+		t.Errorf("ParseDSN = %+v, want verified tls for db.internal and public key retrieval", c)
+	}
+	if c, err = ParseDSN("greeter@tcp(db.internal:3306)/greeter?tls=skip-verify"); err != nil || !c.TLS.InsecureSkipVerify {
+		t.Errorf("tls=skip-verify: %+v, %v", c, err)
+	}
+	if _, err := ParseDSN("greeter@tcp(db.internal:3306)/greeter?tls=custom"); err == nil {
+		t.Error("tls=custom: want an error")
+	}
+	if _, err := ParseDSN("greeter@tcp(db.internal:3306)/greeter?serverPubKey=/nonexistent.pem"); err == nil {
+		t.Error("missing serverPubKey file: want an error")
+	}
+	path := filepath.Join(t.TempDir(), "server.pem")
+	if err := os.WriteFile(path, pemPublicKey(t, &testKey(t).PublicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ParseDSN("greeter@tcp(db.internal:3306)/greeter?serverPubKey=" + url.QueryEscape(path)); err != nil || c.ServerPubKey == nil || c.ServerPubKey.N.Cmp(testKey(t).N) != 0 {
+		t.Errorf("serverPubKey: %+v, %v", c, err)
+	}
+}
+
+// testCert 自签名的服务端证书
+func testCert(t *testing.T) *tls.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{"localhost"}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: k}
+}
@@ -0,0 +1,691 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// 单个包的最大长度, 更长的数据拆成多个包
+const maxPacket = 1<<24 - 1
+
+// 客户端能力标志
+const (
+	clientLongPassword     = 0x1
+	clientLongFlag         = 0x4
+	clientConnectWithDB    = 0x8
+	clientProtocol41       = 0x200
+	clientSSL              = 0x800
+	clientTransactions     = 0x2000
+	clientSecureConnection = 0x8000
+	clientMultiResults     = 0x20000
+	clientPluginAuth       = 0x80000
+)
+
+// 服务端状态标志
+const (
+	statusMoreResults        = 0x8
+	statusNoBackslashEscapes = 0x200
+)
+
+// 命令
+const (
+	comQuit  = 0x01
+	comQuery = 0x03
+	comPing  = 0x0e
+)
+
+// utf8mb4_general_ci
+const charsetUTF8MB4 = 45
+
+var errMalformed = errors.New("mysql: malformed packet")
+
+// conn 一个连接, 不是并发安全的, 由database/sql保证同一时间只有一个使用者
+type conn struct {
+	nc  net.Conn
+	r   *bufio.Reader
+	w   *bufio.Writer
+	c   *Config
+	seq byte
+	// status 最近一个OK或EOF包中的服务端状态
+	status uint16
+	// bad 发生网络或协议错误后连接状态未知, 不再使用
+	bad bool
+}
+
+func newConn(nc net.Conn, c *Config) *conn {
+	return &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), c: c}
+}
+
+// readPacket 读取一个完整的包, 合并被拆分的部分
+func (cn *conn) readPacket() ([]byte, error) {
+	var out []byte
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(cn.r, h[:]); err != nil {
+			return nil, err
+		}
+		n := int(h[0]) | int(h[1])<<8 | int(h[2])<<16
+		if h[3] != cn.seq {
+			return nil, errors.New("mysql: packets out of order")
+		}
+		cn.seq++
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(cn.r, buf); err != nil {
+			return nil, err
+		}
+		if out == nil && n < maxPacket {
+			return buf, nil
+		}
+		out = append(out, buf...)
+		if n < maxPacket {
+			return out, nil
+		}
+	}
+}
+
+// writePacket 写入一个包, 长度为maxPacket整数倍时以空包结尾
+func (cn *conn) writePacket(b []byte) error {
+	for {
+		n := len(b)
+		if n > maxPacket {
+			n = maxPacket
+		}
+		h := [4]byte{byte(n), byte(n >> 8), byte(n >> 16), cn.seq}
+		cn.seq++
+		if _, err := cn.w.Write(h[:]); err != nil {
+			return err
+		}
+		if _, err := cn.w.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+		if n < maxPacket {
+			return cn.w.Flush()
+		}
+	}
+}
+
+// writeCommand 从序号0开始发送一个命令
+func (cn *conn) writeCommand(cmd byte, arg string) error {
+	cn.seq = 0
+	b := make([]byte, 1+len(arg))
+	b[0] = cmd
+	copy(b[1:], arg)
+	return cn.writePacket(b)
+}
+
+// handshake 读取服务端的初始握手包并完成认证
+func (cn *conn) handshake() error {
+	p, err := cn.readPacket()
+	if err != nil {
+		return err
+	}
+	if len(p) > 0 && p[0] == 0xff {
+		return cn.parseError(p)
+	}
+	if len(p) == 0 || p[0] != 10 {
+		return fmt.Errorf("mysql: unsupported protocol version")
+	}
+	r := reader{b: p[1:]}
+	r.nulString() // 服务端版本
+	r.skip(4)     // 连接ID
+	scramble := append([]byte(nil), r.bytes(8)...)
+	r.skip(1)
+	caps := uint32(r.uint16())
+	plugin := "mysql_native_password"
+	if r.len() > 0 {
+		r.skip(1) // 字符集
+		cn.status = r.uint16()
+		caps |= uint32(r.uint16()) << 16
+		n := int(r.byte())
+		r.skip(10)
+		if caps&clientSecureConnection != 0 {
+			if n -= 8; n < 13 {
+				n = 13
+			}
+			// 第二部分以NUL结尾, 与第一部分合计20字节
+			part := r.bytes(n)
+			if len(part) > 0 {
+				part = part[:len(part)-1]
+			}
+			scramble = append(scramble, part...)
+		}
+		if caps&clientPluginAuth != 0 {
+			plugin = r.nulString()
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if caps&clientProtocol41 == 0 {
+		return errors.New("mysql: server does not support protocol 4.1")
+	}
+	flags := uint32(clientLongPassword | clientLongFlag | clientProtocol41 | clientTransactions |
+		clientSecureConnection | clientMultiResults | clientPluginAuth)
+	if cn.c.DBName != "" {
+		flags |= clientConnectWithDB
+	}
+	if cn.c.TLS != nil {
+		if err := cn.startTLS(caps, flags); err != nil {
+			return err
+		}
+		flags |= clientSSL
+	}
+	auth, err := cn.authResponse(plugin, scramble)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	b = appendUint32(b, flags)
+	b = appendUint32(b, maxPacket)
+	b = append(b, charsetUTF8MB4)
+	b = append(b, make([]byte, 23)...)
+	b = append(b, cn.c.User...)
+	b = append(b, 0)
+	b = append(b, byte(len(auth)))
+	b = append(b, auth...)
+	if cn.c.DBName != "" {
+		b = append(b, cn.c.DBName...)
+		b = append(b, 0)
+	}
+	b = append(b, plugin...)
+	b = append(b, 0)
+	if err := cn.writePacket(b); err != nil {
+		return err
+	}
+	return cn.authResult(plugin, scramble)
+}
+
+// startTLS 发送SSLRequest并在当前连接上完成TLS握手, 之后的包都经TLS收发
+func (cn *conn) startTLS(caps, flags uint32) error {
+	if caps&clientSSL == 0 {
+		return errors.New("mysql: server does not support TLS")
+	}
+	var b []byte
+	b = appendUint32(b, flags|clientSSL)
+	b = appendUint32(b, maxPacket)
+	b = append(b, charsetUTF8MB4)
+	b = append(b, make([]byte, 23)...)
+	if err := cn.writePacket(b); err != nil {
+		return err
+	}
+	tc := tls.Client(cn.nc, cn.c.TLS)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	cn.nc, cn.r, cn.w = tc, bufio.NewReader(tc), bufio.NewWriter(tc)
+	return nil
+}
+
+// authResponse 按认证插件计算密码的散列
+func (cn *conn) authResponse(plugin string, scramble []byte) ([]byte, error) {
+	if len(scramble) > 20 {
+		scramble = scramble[:20]
+	}
+	switch plugin {
+	case "mysql_native_password":
+		return scrambleNative(scramble, cn.c.Password), nil
+	case "caching_sha2_password":
+		return scrambleSHA256(scramble, cn.c.Password), nil
+	}
+	return nil, fmt.Errorf("mysql: unsupported auth plugin %q", plugin)
+}
+
+// authResult 处理认证结果, 包括切换认证插件和caching_sha2_password的完整认证
+func (cn *conn) authResult(plugin string, scramble []byte) error {
+	for {
+		p, err := cn.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return errMalformed
+		}
+		switch p[0] {
+		case 0x00:
+			cn.parseOK(p)
+			return nil
+		case 0xff:
+			return cn.parseError(p)
+		case 0xfe:
+			// 切换认证插件, 使用新的scramble重新计算
+			r := reader{b: p[1:]}
+			plugin = r.nulString()
+			scramble = r.rest()
+			if n := len(scramble); n > 0 && scramble[n-1] == 0 {
+				scramble = scramble[:n-1]
+			}
+			auth, err := cn.authResponse(plugin, scramble)
+			if err != nil {
+				return err
+			}
+			if err := cn.writePacket(auth); err != nil {
+				return err
+			}
+		case 0x01:
+			if plugin != "caching_sha2_password" || len(p) < 2 {
+				return errMalformed
+			}
+			switch p[1] {
+			case 3:
+				// 快速认证成功, 后面是OK包
+			case 4:
+				if err := cn.fullAuth(scramble); err != nil {
+					return err
+				}
+			default:
+				return errMalformed
+			}
+		default:
+			return errMalformed
+		}
+	}
+}
+
+// fullAuth caching_sha2_password的完整认证. TLS或unix socket上直接发送密码, 否则用服务端的RSA公钥加密.
+// 公钥优先使用配置的ServerPubKey, 只有AllowPublicKeyRetrieval为true时才向服务端索取:
+// 索取到的公钥无法验证, 中间人可以换成自己的公钥解出密码
+func (cn *conn) fullAuth(scramble []byte) error {
+	pw := append([]byte(cn.c.Password), 0)
+	if cn.c.TLS != nil || cn.c.Net == "unix" {
+		return cn.writePacket(pw)
+	}
+	pub := cn.c.ServerPubKey
+	if pub == nil {
+		if !cn.c.AllowPublicKeyRetrieval {
+			return errors.New("mysql: caching_sha2_password full authentication needs tls, serverPubKey or allowPublicKeyRetrieval=true")
+		}
+		if err := cn.writePacket([]byte{2}); err != nil {
+			return err
+		}
+		p, err := cn.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(p) > 0 && p[0] == 0xff {
+			return cn.parseError(p)
+		}
+		if len(p) < 2 || p[0] != 0x01 {
+			return errMalformed
+		}
+		if pub, err = parsePubKey(p[1:]); err != nil {
+			return err
+		}
+	}
+	if len(scramble) > 20 {
+		scramble = scramble[:20]
+	}
+	for i := range pw {
+		pw[i] ^= scramble[i%len(scramble)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, pw, nil)
+	if err != nil {
+		return err
+	}
+	return cn.writePacket(enc)
+}
+
+// parsePubKey 解析PEM格式的RSA公钥
+func parsePubKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("mysql: invalid server public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("mysql: server public key is not RSA")
+	}
+	return pub, nil
+}
+
+// scrambleNative SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+func scrambleNative(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	h1 := sha1.Sum([]byte(password))
+	h2 := sha1.Sum(h1[:])
+	h := sha1.New()
+	h.Write(scramble)
+	h.Write(h2[:])
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= h1[i]
+	}
+	return out
+}
+
+// scrambleSHA256 SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+func scrambleSHA256(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	h1 := sha256.Sum256([]byte(password))
+	h2 := sha256.Sum256(h1[:])
+	h := sha256.New()
+	h.Write(h2[:])
+	h.Write(scramble)
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= h1[i]
+	}
+	return out
+}
+
+// result Exec的结果, 实现driver.Result
+type result struct {
+	affected, insertID int64
+}
+
+func (r result) LastInsertId() (int64, error) { return r.insertID, nil }
+
+func (r result) RowsAffected() (int64, error) { return r.affected, nil }
+
+func (cn *conn) parseOK(p []byte) result {
+	r := reader{b: p[1:]}
+	res := result{affected: int64(r.lenInt()), insertID: int64(r.lenInt())}
+	cn.status = r.uint16()
+	return res
+}
+
+func (cn *conn) parseEOF(p []byte) {
+	if len(p) >= 5 {
+		cn.status = binary.LittleEndian.Uint16(p[3:5])
+	}
+}
+
+func (cn *conn) parseError(p []byte) error {
+	r := reader{b: p[1:]}
+	e := &Error{Number: r.uint16()}
+	if rest := r.rest(); len(rest) >= 6 && rest[0] == '#' {
+		e.SQLState, e.Message = string(rest[1:6]), string(rest[6:])
+	} else {
+		e.Message = string(rest)
+	}
+	return e
+}
+
+func isEOF(p []byte) bool { return len(p) > 0 && p[0] == 0xfe && len(p) < 9 }
+
+// begin 检查连接并按ctx设置超时
+func (cn *conn) begin(ctx context.Context) error {
+	if cn.bad {
+		return driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		cn.nc.SetDeadline(dl)
+	} else {
+		cn.nc.SetDeadline(time.Time{})
+	}
+	return nil
+}
+
+// fail 记录错误, 服务端返回的错误不影响连接, 其他错误使连接不再可用
+func (cn *conn) fail(err error) error {
+	if _, ok := err.(*Error); !ok && err != nil {
+		cn.bad = true
+	}
+	return err
+}
+
+// exec 执行不返回结果集的语句, 返回了结果集时丢弃
+func (cn *conn) exec(query string) (result, error) {
+	if err := cn.writeCommand(comQuery, query); err != nil {
+		return result{}, cn.fail(err)
+	}
+	var res result
+	for {
+		rows, r, err := cn.readResult()
+		if err != nil {
+			return result{}, cn.fail(err)
+		}
+		if rows != nil {
+			if err := rows.discard(); err != nil {
+				return result{}, cn.fail(err)
+			}
+		} else {
+			res = r
+		}
+		if cn.status&statusMoreResults == 0 {
+			return res, nil
+		}
+	}
+}
+
+// query 执行返回结果集的语句
+func (cn *conn) query(query string) (*rows, error) {
+	if err := cn.writeCommand(comQuery, query); err != nil {
+		return nil, cn.fail(err)
+	}
+	rs, _, err := cn.readResult()
+	if err != nil {
+		return nil, cn.fail(err)
+	}
+	if rs == nil {
+		// 没有结果集的语句, 返回空的结果
+		rs = &rows{cn: cn, done: true}
+		if cn.status&statusMoreResults != 0 {
+			if err := cn.drainResults(); err != nil {
+				return nil, cn.fail(err)
+			}
+		}
+	}
+	return rs, nil
+}
+
+// readResult 读取一个结果: OK包或结果集的列定义
+func (cn *conn) readResult() (*rows, result, error) {
+	p, err := cn.readPacket()
+	if err != nil {
+		return nil, result{}, err
+	}
+	if len(p) == 0 {
+		return nil, result{}, errMalformed
+	}
+	switch p[0] {
+	case 0x00:
+		return nil, cn.parseOK(p), nil
+	case 0xff:
+		return nil, result{}, cn.parseError(p)
+	case 0xfb:
+		return nil, result{}, errors.New("mysql: LOAD DATA LOCAL INFILE is not supported")
+	}
+	r := reader{b: p}
+	n := int(r.lenInt())
+	if r.err != nil {
+		return nil, result{}, r.err
+	}
+	rs := &rows{cn: cn, cols: make([]column, n)}
+	for i := range rs.cols {
+		p, err := cn.readPacket()
+		if err != nil {
+			return nil, result{}, err
+		}
+		if rs.cols[i], err = parseColumn(p); err != nil {
+			return nil, result{}, err
+		}
+	}
+	p, err = cn.readPacket()
+	if err != nil {
+		return nil, result{}, err
+	}
+	if !isEOF(p) {
+		return nil, result{}, errMalformed
+	}
+	cn.parseEOF(p)
+	return rs, result{}, nil
+}
+
+// Prepare 不使用服务端预处理语句, 执行时在客户端拼接参数
+func (cn *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{cn: cn, query: query}, nil
+}
+
+// Close 发送COM_QUIT并关闭连接
+func (cn *conn) Close() error {
+	if !cn.bad {
+		cn.nc.SetDeadline(time.Now().Add(time.Second))
+		cn.writeCommand(comQuit, "")
+	}
+	return cn.nc.Close()
+}
+
+// Begin 实现driver.Conn, 使用BeginTx
+func (cn *conn) Begin() (driver.Tx, error) {
+	return cn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// 与database/sql的IsolationLevel一致
+var isolationLevels = map[driver.IsolationLevel]string{
+	1: "READ UNCOMMITTED",
+	2: "READ COMMITTED",
+	4: "REPEATABLE READ",
+	6: "SERIALIZABLE",
+}
+
+// BeginTx 开始事务, 支持设置隔离级别和只读
+func (cn *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := cn.begin(ctx); err != nil {
+		return nil, err
+	}
+	if opts.Isolation != 0 {
+		level, ok := isolationLevels[opts.Isolation]
+		if !ok {
+			return nil, fmt.Errorf("mysql: unsupported isolation level %d", opts.Isolation)
+		}
+		if _, err := cn.exec("SET TRANSACTION ISOLATION LEVEL " + level); err != nil {
+			return nil, err
+		}
+	}
+	q := "START TRANSACTION"
+	if opts.ReadOnly {
+		q += " READ ONLY"
+	}
+	if _, err := cn.exec(q); err != nil {
+		return nil, err
+	}
+	return &tx{cn: cn}, nil
+}
+
+// ExecContext 拼接参数后执行
+func (cn *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := cn.begin(ctx); err != nil {
+		return nil, err
+	}
+	q, err := cn.interpolate(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return cn.exec(q)
+}
+
+// QueryContext 拼接参数后查询
+func (cn *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := cn.begin(ctx); err != nil {
+		return nil, err
+	}
+	q, err := cn.interpolate(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return cn.query(q)
+}
+
+// Ping 发送COM_PING
+func (cn *conn) Ping(ctx context.Context) error {
+	if err := cn.begin(ctx); err != nil {
+		return err
+	}
+	if err := cn.writeCommand(comPing, ""); err != nil {
+		return cn.fail(err)
+	}
+	_, _, err := cn.readResult()
+	return cn.fail(err)
+}
+
+// ResetSession 从连接池取出前调用, 出错的连接不再使用
+func (cn *conn) ResetSession(ctx context.Context) error {
+	if cn.bad {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid 放回连接池前调用
+func (cn *conn) IsValid() bool { return !cn.bad }
+
+type tx struct {
+	cn *conn
+}
+
+func (t *tx) Commit() error {
+	if t.cn.bad {
+		return driver.ErrBadConn
+	}
+	_, err := t.cn.exec("COMMIT")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	if t.cn.bad {
+		return driver.ErrBadConn
+	}
+	_, err := t.cn.exec("ROLLBACK")
+	return err
+}
+
+// stmt 只保存SQL, 每次执行时拼接参数
+type stmt struct {
+	cn    *conn
+	query string
+}
+
+func (s *stmt) Close() error { return nil }
+
+// NumInput 返回-1, 参数个数在拼接时检查
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.cn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.cn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
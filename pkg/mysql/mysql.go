@@ -0,0 +1,222 @@
+// Package mysql 是一个精简的MySQL database/sql驱动, 只使用文本协议: 参数在客户端转义后拼入SQL,
+// 不使用服务端预处理语句. 支持mysql_native_password和caching_sha2_password认证以及TLS.
+// DSN格式与github.com/go-sql-driver/mysql相同, 需要时可以直接替换为该驱动:
+//
+//	user:password@tcp(127.0.0.1:3306)/dbname?timeout=5s&parseTime=true&tls=true
+//
+// caching_sha2_password的完整认证需要发送密码: 使用TLS或unix socket时直接发送; 否则用serverPubKey
+// 指定的PEM文件中的服务端公钥加密(与go-sql-driver/mysql不同, 这里是经过URL编码的文件路径而不是注册的名字),
+// 或者设置allowPublicKeyRetrieval=true向服务端索取公钥, 索取的公钥无法验证, 只应在可信网络中使用.
+// 都不满足时认证失败, 不会把密码交给未经验证的公钥.
+//
+// 驱动不在init中注册, 通过sql.OpenDB(NewConnector(cfg))使用, 不会与其他名为mysql的驱动冲突.
+package mysql
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 解析后的DSN
+type Config struct {
+	User     string
+	Password string
+	// Net 为tcp或unix
+	Net  string
+	Addr string
+	// DBName 连接后使用的数据库, 为空时不选择
+	DBName string
+	// Timeout 建立连接和认证的超时时间, 默认10s
+	Timeout time.Duration
+	// ParseTime 为true时DATE、DATETIME和TIMESTAMP列返回UTC的time.Time, 否则返回[]byte
+	ParseTime bool
+	// TLS 不为nil时在认证前升级为TLS连接. DSN中tls=true校验服务端证书, tls=skip-verify不校验
+	TLS *tls.Config
+	// ServerPubKey caching_sha2_password完整认证时加密密码使用的服务端公钥
+	ServerPubKey *rsa.PublicKey
+	// AllowPublicKeyRetrieval 为true时, 没有TLS和ServerPubKey的完整认证向服务端索取公钥
+	AllowPublicKeyRetrieval bool
+	// Params 其他参数, 连接后执行 SET <name>=<value>, 如 time_zone='+00:00'
+	Params map[string]string
+}
+
+// ParseDSN 解析 [user[:password]@][net[(addr)]]/dbname[?param=value&...]
+func ParseDSN(dsn string) (*Config, error) {
+	c := &Config{Net: "tcp", Addr: "127.0.0.1:3306", Timeout: 10 * time.Second}
+	slash := strings.LastIndexByte(dsn, '/')
+	if slash < 0 {
+		return nil, fmt.Errorf("mysql: invalid dsn: missing /")
+	}
+	prefix, rest := dsn[:slash], dsn[slash+1:]
+	if at := strings.LastIndexByte(prefix, '@'); at >= 0 {
+		c.User = prefix[:at]
+		if i := strings.IndexByte(c.User, ':'); i >= 0 {
+			c.User, c.Password = c.User[:i], c.User[i+1:]
+		}
+		prefix = prefix[at+1:]
+	}
+	if prefix != "" {
+		if i := strings.IndexByte(prefix, '('); i >= 0 {
+			if !strings.HasSuffix(prefix, ")") {
+				return nil, fmt.Errorf("mysql: invalid dsn: unclosed address")
+			}
+			c.Net, c.Addr = prefix[:i], prefix[i+1:len(prefix)-1]
+		} else {
+			c.Net = prefix
+		}
+	}
+	if c.Net != "tcp" && c.Net != "unix" {
+		return nil, fmt.Errorf("mysql: unsupported network %q", c.Net)
+	}
+	c.DBName = rest
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		c.DBName = rest[:i]
+		params, err := url.ParseQuery(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("mysql: invalid dsn params: %w", err)
+		}
+		for k, vs := range params {
+			v := vs[len(vs)-1]
+			switch k {
+			case "timeout":
+				if c.Timeout, err = time.ParseDuration(v); err != nil {
+					return nil, fmt.Errorf("mysql: invalid timeout: %w", err)
+				}
+			case "parseTime":
+				if c.ParseTime, err = strconv.ParseBool(v); err != nil {
+					return nil, fmt.Errorf("mysql: invalid parseTime: %w", err)
+				}
+			case "tls":
+				switch v {
+				case "true":
+					host, _, err := net.SplitHostPort(c.Addr)
+					if err != nil {
+						host = c.Addr
+					}
+					c.TLS = &tls.Config{ServerName: host}
+				case "skip-verify":
+					c.TLS = &tls.Config{InsecureSkipVerify: true}
+				case "false":
+					c.TLS = nil
+				default:
+					return nil, fmt.Errorf("mysql: invalid tls %q, must be true, skip-verify or false", v)
+				}
+			case "serverPubKey":
+				b, err := os.ReadFile(v)
+				if err != nil {
+					return nil, fmt.Errorf("mysql: read serverPubKey: %w", err)
+				}
+				if c.ServerPubKey, err = parsePubKey(b); err != nil {
+					return nil, err
+				}
+			case "allowPublicKeyRetrieval":
+				if c.AllowPublicKeyRetrieval, err = strconv.ParseBool(v); err != nil {
+					return nil, fmt.Errorf("mysql: invalid allowPublicKeyRetrieval: %w", err)
+				}
+			default:
+				if c.Params == nil {
+					c.Params = map[string]string{}
+				}
+				c.Params[k] = v
+			}
+		}
+	}
+	return c, nil
+}
+
+// Connector 按Config建立连接, 实现driver.Connector
+type Connector struct {
+	c *Config
+}
+
+// NewConnector 按c创建Connector, 用于sql.OpenDB. Net、Addr和Timeout为零值时使用ParseDSN的默认值
+func NewConnector(c *Config) *Connector {
+	cp := *c
+	if cp.Net == "" {
+		cp.Net = "tcp"
+	}
+	if cp.Addr == "" {
+		cp.Addr = "127.0.0.1:3306"
+	}
+	if cp.Timeout <= 0 {
+		cp.Timeout = 10 * time.Second
+	}
+	return &Connector{c: &cp}
+}
+
+// Connect 建立连接并完成认证, ctx或Timeout先到期时放弃
+func (ct *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	d := net.Dialer{Timeout: ct.c.Timeout}
+	nc, err := d.DialContext(ctx, ct.c.Net, ct.c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(ct.c.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	nc.SetDeadline(deadline)
+	cn := newConn(nc, ct.c)
+	if err := cn.handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	for k, v := range ct.c.Params {
+		if _, err := cn.exec("SET " + k + "=" + v); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	nc.SetDeadline(time.Time{})
+	return cn, nil
+}
+
+// Driver 返回Driver, 满足driver.Connector
+func (ct *Connector) Driver() driver.Driver { return Driver{} }
+
+// Driver 实现driver.Driver, 需要按名字注册时使用 sql.Register("mysql", mysql.Driver{})
+type Driver struct{}
+
+// Open 按dsn建立一个连接
+func (d Driver) Open(dsn string) (driver.Conn, error) {
+	ct, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return ct.Connect(context.Background())
+}
+
+// OpenConnector 实现driver.DriverContext
+func (Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	c, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnector(c), nil
+}
+
+// Error 服务端返回的错误
+type Error struct {
+	Number   uint16
+	SQLState string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	if e.SQLState != "" {
+		return fmt.Sprintf("mysql: Error %d (%s): %s", e.Number, e.SQLState, e.Message)
+	}
+	return fmt.Sprintf("mysql: Error %d: %s", e.Number, e.Message)
+}
+
+// ErDupEntry 唯一键冲突的错误号
+const ErDupEntry = 1062
@@ -0,0 +1,44 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRoundTrip 把需要转义的字符串经服务端原样读回, 分别在默认模式和NO_BACKSLASH_ESCAPES下执行. 需要一个MySQL, 如
+//
+//	GREETER_TEST_MYSQL_DSN='root:root@tcp(127.0.0.1:3306)/greeter_test' go test ./pkg/mysql
+func TestRoundTrip(t *testing.T) {
+	dsn := os.Getenv("GREETER_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("GREETER_TEST_MYSQL_DSN is not set")
+	}
+	values := []string{"it's", `say "hi"`, `C:\tmp\`, `\'; DROP TABLE users; --`, "a\x00b", "line1\nline2\r\n", "ctrl-z\x1a", "?", "你好"}
+	for _, mode := range []string{"", "'NO_BACKSLASH_ESCAPES'"} {
+		c, err := ParseDSN(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != "" {
+			c.Params = map[string]string{"sql_mode": mode}
+		}
+		db := sql.OpenDB(NewConnector(c))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		for _, v := range values {
+			var got string
+			var n int64
+			if err := db.QueryRowContext(ctx, "SELECT ?, LENGTH(?)", v, v).Scan(&got, &n); err != nil {
+				t.Errorf("sql_mode %s: SELECT %q: %v", mode, v, err)
+				continue
+			}
+			if got != v || n != int64(len(v)) {
+				t.Errorf("sql_mode %s: SELECT %q returned %q (%d bytes)", mode, v, got, n)
+			}
+		}
+		cancel()
+		db.Close()
+	}
+}
@@ -0,0 +1,390 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 列类型
+const (
+	typeTiny     = 1
+	typeShort    = 2
+	typeLong     = 3
+	typeFloat    = 4
+	typeDouble   = 5
+	typeTime     = 7 // TIMESTAMP
+	typeLongLong = 8
+	typeInt24    = 9
+	typeDate     = 10
+	typeDatetime = 12
+	typeYear     = 13
+	typeNewDate  = 14
+)
+
+const flagUnsigned = 32
+
+type column struct {
+	name  string
+	typ   byte
+	flags uint16
+}
+
+// parseColumn 解析ColumnDefinition41
+func parseColumn(p []byte) (column, error) {
+	r := reader{b: p}
+	for i := 0; i < 4; i++ {
+		r.lenString() // catalog, schema, table, org_table
+	}
+	c := column{name: r.lenString()}
+	r.lenString() // org_name
+	r.lenInt()
+	r.skip(2) // 字符集
+	r.skip(4) // 列长度
+	c.typ = r.byte()
+	c.flags = r.uint16()
+	return c, r.err
+}
+
+// rows 文本协议的结果集, 逐行读取
+type rows struct {
+	cn   *conn
+	cols []column
+	done bool
+}
+
+func (rs *rows) Columns() []string {
+	names := make([]string, len(rs.cols))
+	for i, c := range rs.cols {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Close 读完剩余的行, 之后连接才能执行下一个命令
+func (rs *rows) Close() error {
+	if rs.done {
+		return nil
+	}
+	if err := rs.discard(); err != nil {
+		return rs.cn.fail(err)
+	}
+	return rs.cn.fail(rs.cn.drainResults())
+}
+
+func (rs *rows) Next(dest []driver.Value) error {
+	if rs.done {
+		return io.EOF
+	}
+	p, err := rs.cn.readPacket()
+	if err != nil {
+		rs.done = true
+		return rs.cn.fail(err)
+	}
+	if isEOF(p) {
+		rs.done = true
+		rs.cn.parseEOF(p)
+		if err := rs.cn.drainResults(); err != nil {
+			return rs.cn.fail(err)
+		}
+		return io.EOF
+	}
+	if len(p) > 0 && p[0] == 0xff {
+		rs.done = true
+		return rs.cn.parseError(p)
+	}
+	r := reader{b: p}
+	for i, c := range rs.cols {
+		b, null := r.lenBytes()
+		if r.err != nil {
+			rs.done = true
+			return rs.cn.fail(r.err)
+		}
+		if null {
+			dest[i] = nil
+			continue
+		}
+		if dest[i], err = rs.convert(c, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convert 把文本值转换为driver.Value: 整数为int64(超出范围的无符号数保留[]byte), 浮点数为float64,
+// parseTime时日期时间为UTC的time.Time, 其他为[]byte
+func (rs *rows) convert(c column, b []byte) (driver.Value, error) {
+	switch c.typ {
+	case typeTiny, typeShort, typeLong, typeLongLong, typeInt24, typeYear:
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n, nil
+		}
+		if c.flags&flagUnsigned != 0 {
+			return b, nil
+		}
+		return nil, fmt.Errorf("mysql: invalid integer %q in column %s", b, c.name)
+	case typeFloat, typeDouble:
+		return strconv.ParseFloat(string(b), 64)
+	case typeDate, typeNewDate, typeDatetime, typeTime:
+		if rs.cn.c.ParseTime {
+			return parseTime(string(b))
+		}
+	}
+	return b, nil
+}
+
+// parseTime 解析 YYYY-MM-DD[ HH:MM:SS[.ffffff]], 全零值返回time.Time{}
+func parseTime(s string) (time.Time, error) {
+	if strings.Trim(s, "0-:. ") == "" {
+		return time.Time{}, nil
+	}
+	layout := "2006-01-02 15:04:05"
+	if len(s) == len("2006-01-02") {
+		layout = "2006-01-02"
+	}
+	t, err := time.ParseInLocation(layout, s, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("mysql: invalid time %q", s)
+	}
+	return t, nil
+}
+
+// discard 读完当前结果集的剩余行
+func (rs *rows) discard() error {
+	for !rs.done {
+		p, err := rs.cn.readPacket()
+		if err != nil {
+			return err
+		}
+		if isEOF(p) {
+			rs.done = true
+			rs.cn.parseEOF(p)
+		} else if len(p) > 0 && p[0] == 0xff {
+			rs.done = true
+			return rs.cn.parseError(p)
+		}
+	}
+	return nil
+}
+
+// drainResults 丢弃存储过程等返回的后续结果
+func (cn *conn) drainResults() error {
+	for cn.status&statusMoreResults != 0 {
+		rs, _, err := cn.readResult()
+		if err != nil {
+			return err
+		}
+		if rs != nil {
+			if err := rs.discard(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate 把query中的?依次替换为转义后的参数. ?不区分是否在字符串字面量中, 字面量中需要?时应作为参数传入
+func (cn *conn) interpolate(query string, args []driver.NamedValue) (string, error) {
+	if n := strings.Count(query, "?"); n != len(args) {
+		return "", fmt.Errorf("mysql: query has %d placeholders but %d arguments", n, len(args))
+	}
+	if len(args) == 0 {
+		return query, nil
+	}
+	b := make([]byte, 0, len(query)+16*len(args))
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b = append(b, query[i])
+			continue
+		}
+		arg := args[n]
+		n++
+		if arg.Name != "" {
+			return "", errors.New("mysql: named arguments are not supported")
+		}
+		var err error
+		if b, err = cn.appendValue(b, arg.Value); err != nil {
+			return "", err
+		}
+	}
+	return string(b), nil
+}
+
+func (cn *conn) appendValue(b []byte, v driver.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "NULL"...), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case uint64:
+		return strconv.AppendUint(b, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(b, v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return append(b, '1'), nil
+		}
+		return append(b, '0'), nil
+	case time.Time:
+		if v.IsZero() {
+			return append(b, "'0000-00-00 00:00:00'"...), nil
+		}
+		b = append(b, '\'')
+		b = v.UTC().AppendFormat(b, "2006-01-02 15:04:05.999999")
+		return append(b, '\''), nil
+	case []byte:
+		if v == nil {
+			return append(b, "NULL"...), nil
+		}
+		const hex = "0123456789abcdef"
+		b = append(b, "X'"...)
+		for _, c := range v {
+			b = append(b, hex[c>>4], hex[c&0xf])
+		}
+		return append(b, '\''), nil
+	case string:
+		return cn.appendString(b, v), nil
+	}
+	return nil, fmt.Errorf("mysql: unsupported argument type %T", v)
+}
+
+// appendString 按服务端的NO_BACKSLASH_ESCAPES状态转义字符串
+func (cn *conn) appendString(b []byte, s string) []byte {
+	b = append(b, '\'')
+	if cn.status&statusNoBackslashEscapes != 0 {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\'' {
+				b = append(b, '\'')
+			}
+			b = append(b, s[i])
+		}
+		return append(b, '\'')
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b = append(b, '\\', '0')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case 0x1a:
+			b = append(b, '\\', 'Z')
+		case '\'', '"', '\\':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '\'')
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// reader 按协议格式读取包内容, 越界时记录errMalformed并返回零值
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) len() int { return len(r.b) }
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errMalformed
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *reader) skip(n int) { r.bytes(n) }
+
+func (r *reader) rest() []byte {
+	out := r.b
+	r.b = nil
+	return out
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return uint16(b[0]) | uint16(b[1])<<8
+	}
+	return 0
+}
+
+func (r *reader) nulString() string {
+	for i, c := range r.b {
+		if c == 0 {
+			s := string(r.b[:i])
+			r.b = r.b[i+1:]
+			return s
+		}
+	}
+	s := string(r.b)
+	r.b = nil
+	return s
+}
+
+// lenInt 读取length-encoded整数, 0xfb(NULL)返回0
+func (r *reader) lenInt() uint64 {
+	n, _ := r.lenIntNull()
+	return n
+}
+
+func (r *reader) lenIntNull() (uint64, bool) {
+	var size int
+	switch c := r.byte(); {
+	case c < 0xfb:
+		return uint64(c), false
+	case c == 0xfb:
+		return 0, true
+	case c == 0xfc:
+		size = 2
+	case c == 0xfd:
+		size = 3
+	case c == 0xfe:
+		size = 8
+	default:
+		r.err = errMalformed
+		return 0, false
+	}
+	var n uint64
+	for i, c := range r.bytes(size) {
+		n |= uint64(c) << (8 * i)
+	}
+	return n, false
+}
+
+// lenBytes 读取length-encoded字符串, 第二个返回值表示NULL
+func (r *reader) lenBytes() ([]byte, bool) {
+	n, null := r.lenIntNull()
+	if null || r.err != nil {
+		return nil, null
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errMalformed
+		return nil, false
+	}
+	return r.bytes(int(n)), false
+}
+
+func (r *reader) lenString() string {
+	b, _ := r.lenBytes()
+	return string(b)
+}
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestAppendString(t *testing.T) {
+	tests := []struct {
+		in, want, noBackslash string
+	}{
+		{"q1mi", `'q1mi'`, `'q1mi'`},
+		{"", `''`, `''`},
+		{"it's", `'it\'s'`, `'it''s'`},
+		{`say "hi"`, `'say \"hi\"'`, `'say "hi"'`},
+		{`C:\tmp`, `'C:\\tmp'`, `'C:\tmp'`},
+		// 以反斜杠结尾时不能转义掉结束的引号
+		{`\`, `'\\'`, `'\'`},
+		{`\'; DROP TABLE users; --`, `'\\\'; DROP TABLE users; --'`, `'\''; DROP TABLE users; --'`},
+		{"a\x00b", `'a\0b'`, "'a\x00b'"},
+		{"line1\nline2\r\n", `'line1\nline2\r\n'`, "'line1\nline2\r\n'"},
+		{"ctrl-z\x1a", `'ctrl-z\Z'`, "'ctrl-z\x1a'"},
+		{"你好", `'你好'`, `'你好'`},
+	}
+	cn := &conn{}
+	ansi := &conn{status: statusNoBackslashEscapes}
+	for _, tt := range tests {
+		if got := string(cn.appendString(nil, tt.in)); got != tt.want {
+			t.Errorf("appendString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		if got := string(ansi.appendString(nil, tt.in)); got != tt.noBackslash {
+			t.Errorf("appendString(%q) with NO_BACKSLASH_ESCAPES = %s, want %s", tt.in, got, tt.noBackslash)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	args := func(vs ...driver.Value) []driver.NamedValue { return namedValues(vs) }
+	ts := time.Date(2022, 5, 1, 8, 30, 0, 123456000, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		query string
+		args  []driver.NamedValue
+		want  string
+	}{
+		{"SELECT 1", nil, "SELECT 1"},
+		{"SELECT * FROM users WHERE username = ? AND status = ?", args("it's", int64(2)),
+			`SELECT * FROM users WHERE username = 'it\'s' AND status = 2`},
+		// 参数中的?不被当作占位符
+		{"INSERT INTO t (a, b) VALUES (?, ?)", args("?", `\?`), `INSERT INTO t (a, b) VALUES ('?', '\\?')`},
+		{"SELECT ?, ?, ?, ?", args(nil, true, false, uint64(1<<63)), "SELECT NULL, 1, 0, 9223372036854775808"},
+		{"SELECT ?, ?", args(1.5, []byte("\x00'")), "SELECT 1.5, X'0027'"},
+		{"SELECT ?", args([]byte(nil)), "SELECT NULL"},
+		{"SELECT ?, ?", args(ts, time.Time{}), "SELECT '2022-05-01 00:30:00.123456', '0000-00-00 00:00:00'"},
+	}
+	cn := &conn{}
+	for _, tt := range tests {
+		got, err := cn.interpolate(tt.query, tt.args)
+		if err != nil {
+			t.Errorf("interpolate(%q): %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("interpolate(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestInterpolateInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []driver.NamedValue
+	}{
+		{"too few arguments", "SELECT ?, ?", namedValues([]driver.Value{int64(1)})},
+		{"too many arguments", "SELECT ?", namedValues([]driver.Value{int64(1), int64(2)})},
+		{"arguments without placeholders", "SELECT 1", namedValues([]driver.Value{int64(1)})},
+		// 字符串字面量中的?同样计为占位符
+		{"? in a literal", "SELECT '?' WHERE a = ?", namedValues([]driver.Value{int64(1)})},
+		{"named argument", "SELECT ?", []driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}}},
+		{"unsupported type", "SELECT ?", namedValues([]driver.Value{struct{}{}})},
+	}
+	cn := &conn{}
+	for _, tt := range tests {
+		if got, err := cn.interpolate(tt.query, tt.args); err == nil {
+			t.Errorf("%s: interpolate(%q) = %s, want an error", tt.name, tt.query, got)
+		}
+	}
+}
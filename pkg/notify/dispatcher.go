@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/workerpool"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// Dispatcher 在任务池中异步发送通知, 失败后按指数退避重新提交到任务池
+type Dispatcher struct {
+	sender      Sender
+	pool        *workerpool.Pool
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewDispatcher 创建Dispatcher, 重试参数取自c
+func NewDispatcher(sender Sender, pool *workerpool.Pool, c Config) (*Dispatcher, error) {
+	d := &Dispatcher{sender: sender, pool: pool, maxAttempts: c.MaxAttempts, backoff: time.Second}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = 3
+	}
+	if c.RetryBackoff != "" {
+		b, err := time.ParseDuration(c.RetryBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("notify: retry_backoff: %w", err)
+		}
+		d.backoff = b
+	}
+	return d, nil
+}
+
+// Dispatch 提交通知, 只在无法放入任务池时返回错误, 发送结果写日志.
+// 发送在任务池中进行, 不受ctx取消的影响, 只沿用ctx中的Logger, 使日志能与请求关联
+func (d *Dispatcher) Dispatch(ctx context.Context, msg *Message) error {
+	return d.submit(zaplog.FromContext(ctx), msg, 1)
+}
+
+func (d *Dispatcher) submit(l *zaplog.Logger, msg *Message, attempt int) error {
+	return d.pool.Submit(func(ctx context.Context) {
+		ctx = zaplog.NewContext(ctx, l)
+		err := d.sender.Send(ctx, msg)
+		if err == nil {
+			return
+		}
+		if attempt >= d.maxAttempts || ctx.Err() != nil {
+			l.Error("notify: send failed", zaplog.String("to", msg.To), zaplog.Int("attempts", attempt), zaplog.Error(err))
+			return
+		}
+		wait := d.backoff << uint(attempt-1)
+		l.Warn("notify: send failed, retrying", zaplog.String("to", msg.To), zaplog.Int("attempt", attempt), zaplog.Duration("wait", wait), zaplog.Error(err))
+		time.AfterFunc(wait, func() {
+			if err := d.submit(l, msg, attempt+1); err != nil {
+				l.Error("notify: requeue message", zaplog.String("to", msg.To), zaplog.Error(err))
+			}
+		})
+	})
+}
@@ -0,0 +1,106 @@
+// Package notify 定义通知发送接口, 提供SMTP、webhook和日志三种实现,
+// 并通过Dispatcher在任务池中异步发送、失败重试.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+)
+
+// Message 一条通知
+type Message struct {
+	// To 接收人, 如邮箱地址
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	// Body 纯文本内容
+	Body string `json:"body"`
+	// HTML HTML内容, 可选; 邮件会同时包含纯文本和HTML两个版本
+	HTML string `json:"html,omitempty"`
+}
+
+// Sender 发送通知
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// 支持的provider
+const (
+	ProviderLog     = "log"
+	ProviderSMTP    = "smtp"
+	ProviderWebhook = "webhook"
+)
+
+// Config 通知配置
+type Config struct {
+	// Provider log, smtp 或 webhook
+	Provider string        `json:"provider"`
+	SMTP     SMTPConfig    `json:"smtp"`
+	Webhook  WebhookConfig `json:"webhook"`
+	// MaxAttempts 最多尝试发送的次数
+	MaxAttempts int `json:"max_attempts"`
+	// RetryBackoff 第一次重试前的等待时间, 之后每次翻倍, 如 "1s"
+	RetryBackoff string `json:"retry_backoff"`
+}
+
+// Log 只把通知写到日志的Sender, 用于本地开发
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg *Message) error {
+	zaplog.FromContext(ctx).Info("notify: message", zaplog.String("to", msg.To),
+		zaplog.String("subject", msg.Subject), zaplog.String("body", msg.Body))
+	return nil
+}
+
+// New 根据配置创建Sender, 返回的Sender会记录各provider的发送指标
+func New(c Config) (Sender, error) {
+	var s Sender
+	switch c.Provider {
+	case "", ProviderLog:
+		c.Provider = ProviderLog
+		s = Log{}
+	case ProviderSMTP:
+		smtp, err := NewSMTP(c.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		s = smtp
+	case ProviderWebhook:
+		wh, err := NewWebhook(c.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		s = wh
+	default:
+		return nil, fmt.Errorf("notify: unknown provider %q", c.Provider)
+	}
+	return &instrumented{provider: c.Provider, next: s}, nil
+}
+
+var (
+	sentTotal = metrics.NewCounterVec("notify_sent_total",
+		"Number of notification send attempts by provider and result.", "provider", "result")
+	sendDuration = metrics.NewHistogramVec("notify_send_duration_seconds",
+		"Time spent sending a notification.", nil, "provider")
+)
+
+// instrumented 记录发送次数和耗时
+type instrumented struct {
+	provider string
+	next     Sender
+}
+
+func (s *instrumented) Send(ctx context.Context, msg *Message) error {
+	start := time.Now()
+	err := s.next.Send(ctx, msg)
+	sendDuration.WithLabelValues(s.provider).ObserveContext(ctx, time.Since(start).Seconds())
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	sentTotal.WithLabelValues(s.provider, result).Inc()
+	return err
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig SMTP服务器配置
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// From 发件人地址
+	From string `json:"from"`
+}
+
+// SMTP 通过SMTP发送邮件
+type SMTP struct {
+	c    SMTPConfig
+	addr string
+	auth smtp.Auth
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTP 创建SMTP Sender
+func NewSMTP(c SMTPConfig) (*SMTP, error) {
+	if c.Host == "" || c.From == "" {
+		return nil, errors.New("notify: smtp.host and smtp.from are required")
+	}
+	if c.Port == 0 {
+		c.Port = 587
+	}
+	s := &SMTP{c: c, addr: net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), send: smtp.SendMail}
+	if c.Username != "" {
+		s.auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	return s, nil
+}
+
+// Send 发送邮件; net/smtp不支持context, ctx结束时不等待发送结果直接返回
+func (s *SMTP) Send(ctx context.Context, msg *Message) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.c.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		buf.Write(crlf(msg.Body))
+	} else if err := writeAlternative(&buf, msg); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.send(s.addr, s.auth, s.c.From, []string{msg.To}, buf.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeAlternative 写出multipart/alternative正文, 纯文本在前, 客户端优先显示最后一个能显示的版本
+func writeAlternative(buf *bytes.Buffer, msg *Message) error {
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ typ, body string }{{"text/plain", msg.Body}, {"text/html", msg.HTML}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write(crlf(part.body)); err != nil {
+			return err
+		}
+		if err := qw.Close(); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// crlf 把换行统一为SMTP要求的\r\n
+func crlf(s string) []byte {
+	return bytes.ReplaceAll([]byte(strings.ReplaceAll(s, "\r\n", "\n")), []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig webhook配置
+type WebhookConfig struct {
+	// URL 接收通知的地址, 消息以JSON格式POST过去
+	URL string `json:"url"`
+	// Headers 附加的请求头, 如鉴权token
+	Headers map[string]string `json:"headers"`
+	// Timeout 单次请求超时, 如 "5s"
+	Timeout string `json:"timeout"`
+}
+
+// Webhook 把通知POST到HTTP接口
+type Webhook struct {
+	c      WebhookConfig
+	client *http.Client
+}
+
+// NewWebhook 创建Webhook Sender
+func NewWebhook(c WebhookConfig) (*Webhook, error) {
+	if c.URL == "" {
+		return nil, errors.New("notify: webhook.url is required")
+	}
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("notify: webhook.timeout: %w", err)
+		}
+		timeout = d
+	}
+	return &Webhook{c: c, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Send 发送通知, 非2xx响应视为失败
+func (w *Webhook) Send(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notify: webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package passwd
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// argon2Key argon2id, t为迭代次数, m为内存开销(KiB), p为并行度
+func argon2Key(password, salt []byte, t, m, p, keyLen int) []byte {
+	return argon2.IDKey(password, salt, uint32(t), uint32(m), uint8(p), uint32(keyLen))
+}
+
+// pbkdf2Key PBKDF2-HMAC-SHA256
+func pbkdf2Key(password, salt []byte, iter, keyLen int) []byte {
+	return pbkdf2.Key(password, salt, iter, keyLen, sha256.New)
+}
+
+// scryptKey scrypt, r或p不是正数时scrypt.Key会除零, 先行拒绝
+func scryptKey(password, salt []byte, n, r, p, keyLen int) ([]byte, error) {
+	if r <= 0 || p <= 0 {
+		return nil, errors.New("passwd: scrypt r and p must be positive")
+	}
+	return scrypt.Key(password, salt, n, r, p, keyLen)
+}
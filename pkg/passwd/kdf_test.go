@@ -0,0 +1,104 @@
+package passwd
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestArgon2id 参考实现(github.com/P-H-C/phc-winner-argon2)test.c中argon2id v=19的测试向量
+func TestArgon2id(t *testing.T) {
+	if testing.Short() {
+		t.Skip("argon2id with m=64MiB")
+	}
+	tests := []struct {
+		password, salt string
+		want           string
+	}{
+		{"password", "somesalt", "09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7"},
+		{"differentpassword", "somesalt", "0b84d652cf6b0c4beaef0dfe278ba6a80df6696281d7e0d2891b817d8c458fde"},
+	}
+	for _, tt := range tests {
+		want := unhex(t, tt.want)
+		got := argon2Key([]byte(tt.password), []byte(tt.salt), 2, 1<<16, 1, len(want))
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("argon2id(%q, %q, t=2, m=65536, p=1) = %x, want %s", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+// TestPBKDF2 PBKDF2-HMAC-SHA256的已知结果, 前两条来自RFC 7914第11节
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iter           int
+		want           string
+	}{
+		{"passwd", "salt", 1,
+			"55 ac 04 6e 56 e3 08 9f ec 16 91 c2 25 44 b6 05 f9 41 85 21 6d de 04 65 e6 8b 9d 57 c2 0d ac bc" +
+				"49 ca 9c cc f1 79 b6 45 99 16 64 b3 9d 77 ef 31 7c 71 b8 45 b1 e3 0b d5 09 11 20 41 d3 a1 97 83"},
+		{"Password", "NaCl", 80000,
+			"4d dc d8 f6 0b 98 be 21 83 0c ee 5e f2 27 01 f9 64 1a 44 18 d0 4c 04 14 ae ff 08 87 6b 34 ab 56" +
+				"a1 d4 25 a1 22 58 33 54 9a db 84 1b 51 c9 b3 17 6a 27 2b de bb a1 d0 78 47 8f 62 b3 97 f3 3c 8d"},
+		// 长度不是32的整数倍时截断最后一块
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8e"},
+	}
+	for _, tt := range tests {
+		want := unhex(t, tt.want)
+		got := pbkdf2Key([]byte(tt.password), []byte(tt.salt), tt.iter, len(want))
+		if hex.EncodeToString(got) != hex.EncodeToString(want) {
+			t.Errorf("pbkdf2(%q, %q, %d) = %x, want %x", tt.password, tt.salt, tt.iter, got, want)
+		}
+	}
+}
+
+// TestScrypt RFC 7914第12节的测试向量
+func TestScrypt(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		n, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1,
+			"77 d6 57 62 38 65 7b 20 3b 19 ca 42 c1 8a 04 97 f1 6b 48 44 e3 07 4a e8 df df fa 3f ed e2 14 42" +
+				"fc d0 06 9d ed 09 48 f8 32 6a 75 3a 0f c8 1f 17 e8 d3 e0 fb 2e 0d 36 28 cf 35 e2 0c 38 d1 89 06"},
+		{"password", "NaCl", 1024, 8, 16,
+			"fd ba be 1c 9d 34 72 00 78 56 e7 19 0d 01 e9 fe 7c 6a d7 cb c8 23 78 30 e7 73 76 63 4b 37 31 62" +
+				"2e af 30 d9 2e 22 a3 88 6f f1 09 27 9d 98 30 da c7 27 af b9 4a 83 ee 6d 83 60 cb df a2 cc 06 40"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1,
+			"70 23 bd cb 3a fd 73 48 46 1c 06 cd 81 fd 38 eb fd a8 fb ba 90 4f 8e 3e a9 b5 43 f6 54 5d a1 f2" +
+				"d5 43 29 55 61 3f 0f cf 62 d4 97 05 24 2a 9a f9 e6 1e 85 dc 0d 65 1e 40 df cf 01 7b 45 57 58 87"},
+	}
+	for _, tt := range tests {
+		if testing.Short() && tt.n > 1024 {
+			continue
+		}
+		want := unhex(t, tt.want)
+		got, err := scryptKey([]byte(tt.password), []byte(tt.salt), tt.n, tt.r, tt.p, len(want))
+		if err != nil {
+			t.Errorf("scrypt(%q, N=%d): %v", tt.password, tt.n, err)
+			continue
+		}
+		if hex.EncodeToString(got) != hex.EncodeToString(want) {
+			t.Errorf("scrypt(%q, %q, N=%d, r=%d, p=%d) = %x, want %x", tt.password, tt.salt, tt.n, tt.r, tt.p, got, want)
+		}
+	}
+}
+
+func TestScryptInvalidParams(t *testing.T) {
+	for _, p := range [][3]int{{0, 1, 1}, {1, 1, 1}, {15, 1, 1}, {16, 0, 1}, {16, 1, 0}, {16, 1 << 15, 1 << 15}} {
+		if _, err := scryptKey([]byte("pw"), []byte("salt"), p[0], p[1], p[2], 32); err == nil {
+			t.Errorf("scrypt N=%d r=%d p=%d accepted", p[0], p[1], p[2])
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package passwd 提供密码哈希和校验, 算法由golang.org/x/crypto实现.
+// 支持argon2id(默认)、bcrypt、scrypt和pbkdf2-sha256. bcrypt使用其标准格式, 其他算法使用PHC字符串格式保存参数,
+// 参数或算法调整后可通过Verify返回的needsRehash在用户登录时升级旧哈希, 如把scrypt哈希升级为argon2id.
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 支持的算法
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
+	PBKDF2   = "pbkdf2-sha256"
+)
+
+// MaxBcryptLen bcrypt只使用密码的前72个字节
+const MaxBcryptLen = 72
+
+// ErrMismatch 密码不匹配
+var ErrMismatch = errors.New("passwd: password mismatch")
+
+// ErrInvalidHash 哈希字符串格式错误或算法不支持
+var ErrInvalidHash = errors.New("passwd: invalid hash")
+
+// ErrPasswordTooLong 使用bcrypt时密码超过MaxBcryptLen字节
+var ErrPasswordTooLong = errors.New("passwd: password too long")
+
+// Params 哈希参数, 可在配置文件中调整
+type Params struct {
+	// Algorithm argon2id、bcrypt、scrypt 或 pbkdf2-sha256
+	Algorithm string `json:"algorithm"`
+	// Memory argon2id的内存开销, 单位KiB
+	Memory int `json:"memory"`
+	// Time argon2id的迭代次数
+	Time int `json:"time"`
+	// Cost bcrypt的cost, 4到31
+	Cost int `json:"cost"`
+	// Iterations pbkdf2迭代次数
+	Iterations int `json:"iterations"`
+	// N, R scrypt的CPU/内存开销参数, N必须是2的幂
+	N int `json:"n"`
+	R int `json:"r"`
+	// P scrypt和argon2id的并行度, argon2id最大为255
+	P int `json:"p"`
+	// SaltLen 盐的字节数, bcrypt固定为16
+	SaltLen int `json:"salt_len"`
+	// KeyLen 哈希结果的字节数, bcrypt固定为23
+	KeyLen int `json:"key_len"`
+}
+
+// DefaultParams 返回默认参数
+func DefaultParams() Params {
+	return Params{
+		Algorithm:  Argon2id,
+		Memory:     19 * 1024,
+		Time:       2,
+		Cost:       12,
+		Iterations: 310000,
+		N:          1 << 15,
+		R:          8,
+		P:          1,
+		SaltLen:    16,
+		KeyLen:     32,
+	}
+}
+
+// Hasher 按照给定参数生成并校验密码哈希
+type Hasher struct {
+	p Params
+}
+
+// New 创建Hasher, 未设置的参数使用默认值
+func New(p Params) (*Hasher, error) {
+	def := DefaultParams()
+	if p.Algorithm == "" {
+		p.Algorithm = def.Algorithm
+	}
+	if p.Memory == 0 {
+		p.Memory = def.Memory
+	}
+	if p.Time == 0 {
+		p.Time = def.Time
+	}
+	if p.Cost == 0 {
+		p.Cost = def.Cost
+	}
+	if p.Iterations == 0 {
+		p.Iterations = def.Iterations
+	}
+	if p.N == 0 {
+		p.N = def.N
+	}
+	if p.R == 0 {
+		p.R = def.R
+	}
+	if p.P == 0 {
+		p.P = def.P
+	}
+	if p.SaltLen == 0 {
+		p.SaltLen = def.SaltLen
+	}
+	if p.KeyLen == 0 {
+		p.KeyLen = def.KeyLen
+	}
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Time < 1 || p.P < 1 || p.P > 255 || p.Memory < 8*p.P {
+			return nil, fmt.Errorf("passwd: invalid argon2id m=%d t=%d p=%d", p.Memory, p.Time, p.P)
+		}
+	case Bcrypt:
+		if p.Cost < bcrypt.MinCost || p.Cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("passwd: invalid bcrypt cost %d", p.Cost)
+		}
+	case Scrypt:
+		if p.N <= 1 || p.N&(p.N-1) != 0 {
+			return nil, fmt.Errorf("passwd: scrypt N must be a power of 2, got %d", p.N)
+		}
+		if p.R < 1 || p.P < 1 || p.R*p.P >= 1<<30 {
+			return nil, fmt.Errorf("passwd: invalid scrypt r=%d p=%d", p.R, p.P)
+		}
+	case PBKDF2:
+		if p.Iterations < 1 {
+			return nil, fmt.Errorf("passwd: invalid iterations %d", p.Iterations)
+		}
+	default:
+		return nil, fmt.Errorf("passwd: unsupported algorithm %q", p.Algorithm)
+	}
+	return &Hasher{p: p}, nil
+}
+
+// Hash 生成密码哈希. 使用bcrypt时密码超过MaxBcryptLen字节返回ErrPasswordTooLong, 而不是截断
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.p.Algorithm == Bcrypt {
+		if len(password) > MaxBcryptLen {
+			return "", ErrPasswordTooLong
+		}
+		b, err := bcrypt.GenerateFromPassword([]byte(password), h.p.Cost)
+		return string(b), err
+	}
+	salt := make([]byte, h.p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	ph := &phc{alg: h.p.Algorithm, salt: salt}
+	switch h.p.Algorithm {
+	case Argon2id:
+		ph.m, ph.t, ph.p = h.p.Memory, h.p.Time, h.p.P
+	case Scrypt:
+		ph.n, ph.r, ph.p = h.p.N, h.p.R, h.p.P
+	default:
+		ph.iter = h.p.Iterations
+	}
+	key, err := ph.derive([]byte(password), h.p.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	ph.key = key
+	return ph.String(), nil
+}
+
+// Verify 以固定时间比较校验密码, 不匹配时返回ErrMismatch. encoded可以是任一支持的算法生成的哈希.
+// needsRehash为true表示哈希使用的算法或参数与当前配置不同, 调用方应在校验成功后重新生成哈希.
+func (h *Hasher) Verify(password, encoded string) (needsRehash bool, err error) {
+	if isBcrypt(encoded) {
+		return h.verifyBcrypt(password, encoded)
+	}
+	ph, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := ph.derive([]byte(password), len(ph.key))
+	if err != nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare(key, ph.key) != 1 {
+		return false, ErrMismatch
+	}
+	return !h.matches(ph), nil
+}
+
+// isBcrypt 是否为$2a$、$2b$或$2y$开头的bcrypt哈希
+func isBcrypt(s string) bool {
+	return len(s) > 4 && s[0] == '$' && s[1] == '2' && strings.IndexByte("aby", s[2]) >= 0 && s[3] == '$'
+}
+
+// verifyBcrypt 校验bcrypt哈希. 超过MaxBcryptLen字节的密码只有前72个字节参与计算, 视为不匹配
+func (h *Hasher) verifyBcrypt(password, encoded string) (bool, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	if len(password) > MaxBcryptLen {
+		return false, ErrMismatch
+	}
+	switch err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, ErrMismatch
+	case err != nil:
+		return false, ErrInvalidHash
+	}
+	return h.p.Algorithm != Bcrypt || cost != h.p.Cost, nil
+}
+
+func (h *Hasher) matches(ph *phc) bool {
+	if ph.alg != h.p.Algorithm || len(ph.key) != h.p.KeyLen || len(ph.salt) != h.p.SaltLen {
+		return false
+	}
+	switch ph.alg {
+	case Argon2id:
+		return ph.m == h.p.Memory && ph.t == h.p.Time && ph.p == h.p.P
+	case Scrypt:
+		return ph.n == h.p.N && ph.r == h.p.R && ph.p == h.p.P
+	}
+	return ph.iter == h.p.Iterations
+}
+
+// argon2Version argon2id哈希字符串中的版本, 即0x13
+const argon2Version = "v=19"
+
+// phc 解析后的哈希字符串:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>、$scrypt$ln=15,r=8,p=1$<salt>$<key> 或 $pbkdf2-sha256$i=310000$<salt>$<key>
+type phc struct {
+	alg       string
+	iter      int
+	n, r, p   int
+	m, t      int
+	salt, key []byte
+}
+
+func (ph *phc) derive(password []byte, keyLen int) ([]byte, error) {
+	switch ph.alg {
+	case Argon2id:
+		return argon2Key(password, ph.salt, ph.t, ph.m, ph.p, keyLen), nil
+	case Scrypt:
+		return scryptKey(password, ph.salt, ph.n, ph.r, ph.p, keyLen)
+	}
+	return pbkdf2Key(password, ph.salt, ph.iter, keyLen), nil
+}
+
+func (ph *phc) String() string {
+	var params string
+	switch ph.alg {
+	case Argon2id:
+		params = fmt.Sprintf("%s$m=%d,t=%d,p=%d", argon2Version, ph.m, ph.t, ph.p)
+	case Scrypt:
+		ln := 0
+		for v := ph.n; v > 1; v >>= 1 {
+			ln++
+		}
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", ln, ph.r, ph.p)
+	default:
+		params = fmt.Sprintf("i=%d", ph.iter)
+	}
+	enc := base64.RawStdEncoding
+	return "$" + ph.alg + "$" + params + "$" + enc.EncodeToString(ph.salt) + "$" + enc.EncodeToString(ph.key)
+}
+
+func parsePHC(s string) (*phc, error) {
+	parts := strings.Split(s, "$")
+	// argon2id在参数前多一段版本, 只支持0x13
+	if len(parts) == 6 && parts[1] == Argon2id {
+		if parts[2] != argon2Version {
+			return nil, ErrInvalidHash
+		}
+		parts = append(parts[:2], parts[3:]...)
+	} else if len(parts) == 5 && parts[1] == Argon2id {
+		return nil, ErrInvalidHash
+	}
+	if len(parts) != 5 || parts[0] != "" {
+		return nil, ErrInvalidHash
+	}
+	ph := &phc{alg: parts[1]}
+	for _, kv := range strings.Split(parts[2], ",") {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, ErrInvalidHash
+		}
+		v, err := strconv.Atoi(kv[i+1:])
+		if err != nil || v <= 0 {
+			return nil, ErrInvalidHash
+		}
+		switch kv[:i] {
+		case "ln":
+			if v > 30 {
+				return nil, ErrInvalidHash
+			}
+			ph.n = 1 << uint(v)
+		case "r":
+			ph.r = v
+		case "p":
+			ph.p = v
+		case "i":
+			ph.iter = v
+		case "m":
+			ph.m = v
+		case "t":
+			ph.t = v
+		default:
+			return nil, ErrInvalidHash
+		}
+	}
+	switch ph.alg {
+	case Argon2id:
+		if ph.m == 0 || ph.t == 0 || ph.p == 0 || ph.p > 255 || ph.n != 0 || ph.r != 0 || ph.iter != 0 {
+			return nil, ErrInvalidHash
+		}
+	case Scrypt:
+		if ph.n == 0 || ph.r == 0 || ph.p == 0 {
+			return nil, ErrInvalidHash
+		}
+	case PBKDF2:
+		if ph.iter == 0 {
+			return nil, ErrInvalidHash
+		}
+	default:
+		return nil, ErrInvalidHash
+	}
+	var err error
+	enc := base64.RawStdEncoding
+	if ph.salt, err = enc.DecodeString(parts[3]); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if ph.key, err = enc.DecodeString(parts[4]); err != nil || len(ph.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+	return ph, nil
+}
@@ -0,0 +1,187 @@
+package passwd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// 由RFC 7914和argon2参考实现的测试向量拼成的哈希字符串, bcrypt来自Openwall crypt_blowfish的测试向量
+const (
+	argon2Password = "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	bcryptUU       = "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"
+	pbkdf2Passwd   = "$pbkdf2-sha256$i=1$c2FsdA$VawEblbjCJ/sFpHCJUS2BflBhSFt3gRl5oudV8INrLxJypzM8Xm2RZkWZLOdd+8xfHG4RbHjC9UJESBB06GXgw"
+	scryptEmpty    = "$scrypt$ln=4,r=1,p=1$$d9ZXYjhleyA7GcpCwYoEl/FrSETjB0ro39/6P+3iFEL80Aad7QlI+DJqdToPyB8X6NPg+y4NNijPNeIMONGJBg"
+	scryptPassword = "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA"
+)
+
+// bcryptMinCost bcrypt允许的最小cost, 使测试更快
+const bcryptMinCost = 4
+
+func newHasher(t *testing.T, p Params) *Hasher {
+	t.Helper()
+	h, err := New(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestVerifyStored(t *testing.T) {
+	pbkdf2 := Params{Algorithm: PBKDF2, Iterations: 1, SaltLen: 4, KeyLen: 64}
+	scrypt := Params{Algorithm: Scrypt, N: 1024, R: 8, P: 16, SaltLen: 4, KeyLen: 64}
+	argon2 := Params{Algorithm: Argon2id, Memory: 65536, Time: 2, P: 1, SaltLen: 8, KeyLen: 32}
+	bcrypt := Params{Algorithm: Bcrypt, Cost: 5}
+	tests := []struct {
+		name     string
+		params   Params
+		encoded  string
+		password string
+		err      error
+		rehash   bool
+	}{
+		{"pbkdf2 current", pbkdf2, pbkdf2Passwd, "passwd", nil, false},
+		{"pbkdf2 wrong password", pbkdf2, pbkdf2Passwd, "Passwd", ErrMismatch, false},
+		{"pbkdf2 more iterations configured", Params{Algorithm: PBKDF2, Iterations: 2, SaltLen: 4, KeyLen: 64}, pbkdf2Passwd, "passwd", nil, true},
+		{"pbkdf2 stored, scrypt configured", scrypt, pbkdf2Passwd, "passwd", nil, true},
+		{"scrypt current", scrypt, scryptPassword, "password", nil, false},
+		{"scrypt wrong password", scrypt, scryptPassword, "passw0rd", ErrMismatch, false},
+		{"scrypt larger N configured", Params{Algorithm: Scrypt, N: 2048, R: 8, P: 16, SaltLen: 4, KeyLen: 64}, scryptPassword, "password", nil, true},
+		{"scrypt longer salt configured", Params{Algorithm: Scrypt, N: 1024, R: 8, P: 16, SaltLen: 16, KeyLen: 64}, scryptPassword, "password", nil, true},
+		{"scrypt shorter key configured", Params{Algorithm: Scrypt, N: 1024, R: 8, P: 16, SaltLen: 4, KeyLen: 32}, scryptPassword, "password", nil, true},
+		{"scrypt stored, pbkdf2 configured", pbkdf2, scryptPassword, "password", nil, true},
+		{"argon2id current", argon2, argon2Password, "password", nil, false},
+		{"argon2id wrong password", argon2, argon2Password, "differentpassword", ErrMismatch, false},
+		{"argon2id more memory configured", Params{Algorithm: Argon2id, Memory: 1 << 17, Time: 2, P: 1, SaltLen: 8, KeyLen: 32}, argon2Password, "password", nil, true},
+		{"argon2id more passes configured", Params{Algorithm: Argon2id, Memory: 65536, Time: 3, P: 1, SaltLen: 8, KeyLen: 32}, argon2Password, "password", nil, true},
+		// 旧的scrypt和pbkdf2哈希在登录时升级为argon2id
+		{"scrypt stored, argon2id configured", argon2, scryptPassword, "password", nil, true},
+		{"pbkdf2 stored, argon2id configured", argon2, pbkdf2Passwd, "passwd", nil, true},
+		{"argon2id stored, bcrypt configured", bcrypt, argon2Password, "password", nil, true},
+		{"bcrypt current", bcrypt, bcryptUU, "U*U", nil, false},
+		{"bcrypt wrong password", bcrypt, bcryptUU, "U*V", ErrMismatch, false},
+		{"bcrypt higher cost configured", Params{Algorithm: Bcrypt, Cost: 10}, bcryptUU, "U*U", nil, true},
+		{"bcrypt stored, argon2id configured", argon2, bcryptUU, "U*U", nil, true},
+		{"scrypt empty password and salt", Params{Algorithm: Scrypt, N: 16, R: 1, P: 1, SaltLen: 16, KeyLen: 64}, scryptEmpty, "", nil, true},
+	}
+	for _, tt := range tests {
+		if testing.Short() && strings.Contains(tt.encoded, "m=65536") {
+			continue
+		}
+		rehash, err := newHasher(t, tt.params).Verify(tt.password, tt.encoded)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if rehash != tt.rehash {
+			t.Errorf("%s: needsRehash %v, want %v", tt.name, rehash, tt.rehash)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	for _, p := range []Params{
+		{Algorithm: Argon2id, Memory: 64, Time: 1, P: 1},
+		{Algorithm: Bcrypt, Cost: bcryptMinCost},
+		{Algorithm: PBKDF2, Iterations: 1000},
+		{Algorithm: Scrypt, N: 1 << 10, R: 8, P: 1},
+	} {
+		h := newHasher(t, p)
+		encoded, err := h.Hash("pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix := "$" + p.Algorithm + "$"
+		if p.Algorithm == Bcrypt {
+			prefix = "$2a$04$"
+		}
+		if !strings.HasPrefix(encoded, prefix) {
+			t.Errorf("%s: hash %s", p.Algorithm, encoded)
+		}
+		again, _ := h.Hash("pa$$word")
+		if again == encoded {
+			t.Errorf("%s: equal hashes, want a random salt", p.Algorithm)
+		}
+		if rehash, err := h.Verify("pa$$word", encoded); err != nil || rehash {
+			t.Errorf("%s: Verify = %v, %v, want a match without rehash", p.Algorithm, rehash, err)
+		}
+		if _, err := h.Verify("password", encoded); err != ErrMismatch {
+			t.Errorf("%s: wrong password: %v, want ErrMismatch", p.Algorithm, err)
+		}
+	}
+}
+
+// TestDefaultArgon2id 默认使用argon2id并保存参数
+func TestDefaultArgon2id(t *testing.T) {
+	h := newHasher(t, Params{})
+	encoded, err := h.Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("default hash %s, want argon2id", encoded)
+	}
+	if rehash, err := h.Verify("pa$$word", encoded); err != nil || rehash {
+		t.Errorf("Verify = %v, %v, want a match without rehash", rehash, err)
+	}
+}
+
+// TestBcryptTooLong bcrypt不截断超过72字节的密码
+func TestBcryptTooLong(t *testing.T) {
+	h := newHasher(t, Params{Algorithm: Bcrypt, Cost: bcryptMinCost})
+	long := strings.Repeat("a", MaxBcryptLen+1)
+	if _, err := h.Hash(long); !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("Hash of %d bytes: %v, want ErrPasswordTooLong", len(long), err)
+	}
+	encoded, err := h.Hash(long[:MaxBcryptLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 前72个字节相同的更长密码不匹配
+	if _, err := h.Verify(long, encoded); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with a longer password: %v, want ErrMismatch", err)
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	h := newHasher(t, Params{Algorithm: PBKDF2, Iterations: 1000})
+	for _, s := range []string{
+		"",
+		"plaintext",
+		"$bcrypt$2b$12$abcdefghijklmnopqrstuu",
+		"$argon2id$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=256$c2FsdA$a2V5",
+		"$argon2id$v=19$i=1$c2FsdA$a2V5",
+		"$2b$03$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2b$05$short",
+		"$pbkdf2-sha256$i=0$c2FsdA$a2V5",
+		"$pbkdf2-sha256$iter=1$c2FsdA$a2V5",
+		"$pbkdf2-sha256$i=1$c2FsdA$",
+		"$pbkdf2-sha256$i=1$not base64$a2V5",
+		"$scrypt$ln=15,r=8$c2FsdA$a2V5",
+		"$scrypt$ln=31,r=8,p=1$c2FsdA$a2V5",
+		"pbkdf2-sha256$i=1$c2FsdA$a2V5$",
+	} {
+		if _, err := h.Verify("passwd", s); err != ErrInvalidHash {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidHash", s, err)
+		}
+	}
+}
+
+func TestNewInvalidParams(t *testing.T) {
+	for _, p := range []Params{
+		{Algorithm: "md5"},
+		{Algorithm: Bcrypt, Cost: 3},
+		{Algorithm: Bcrypt, Cost: 32},
+		{Algorithm: Argon2id, P: 256},
+		{Algorithm: Argon2id, Memory: 7, P: 1},
+		{Algorithm: Scrypt, N: 1000},
+		{Algorithm: PBKDF2, Iterations: -1},
+	} {
+		if _, err := New(p); err == nil {
+			t.Errorf("New(%+v) accepted", p)
+		}
+	}
+}
@@ -0,0 +1,703 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errMalformed = errors.New("postgres: malformed message")
+
+// conn 一个连接, 不是并发安全的, 由database/sql保证同一时间只有一个使用者
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+	c  *Config
+	// buf 正在组装的消息
+	buf []byte
+	// start 当前消息长度字段在buf中的位置
+	start int
+	// bad 发生网络或协议错误后连接状态未知, 不再使用
+	bad bool
+}
+
+func newConn(nc net.Conn, c *Config) *conn {
+	return &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), c: c}
+}
+
+// begin 开始一个类型为t的消息, t为0时为没有类型字节的StartupMessage
+func (cn *conn) begin(t byte) {
+	if t != 0 {
+		cn.buf = append(cn.buf, t)
+	}
+	cn.start = len(cn.buf)
+	cn.buf = append(cn.buf, 0, 0, 0, 0)
+}
+
+// end 回填当前消息的长度
+func (cn *conn) end() {
+	binary.BigEndian.PutUint32(cn.buf[cn.start:], uint32(len(cn.buf)-cn.start))
+}
+
+func (cn *conn) int16(v int) { cn.buf = append(cn.buf, byte(v>>8), byte(v)) }
+
+func (cn *conn) int32(v int) {
+	cn.buf = append(cn.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (cn *conn) cstring(s string) {
+	cn.buf = append(cn.buf, s...)
+	cn.buf = append(cn.buf, 0)
+}
+
+// flush 发送已组装的消息
+func (cn *conn) flush() error {
+	_, err := cn.w.Write(cn.buf)
+	cn.buf = cn.buf[:0]
+	if err != nil {
+		return err
+	}
+	return cn.w.Flush()
+}
+
+// recv 读取下一条消息, 跳过NoticeResponse、ParameterStatus和NotificationResponse
+func (cn *conn) recv() (byte, []byte, error) {
+	for {
+		var h [5]byte
+		if _, err := io.ReadFull(cn.r, h[:]); err != nil {
+			return 0, nil, err
+		}
+		n := int(binary.BigEndian.Uint32(h[1:])) - 4
+		if n < 0 {
+			return 0, nil, errMalformed
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return 0, nil, err
+		}
+		switch h[0] {
+		case 'N', 'S', 'A':
+			continue
+		}
+		return h[0], b, nil
+	}
+}
+
+// sslRequestCode SSLRequest中代替协议版本的请求码
+const sslRequestCode = 1234<<16 | 5679
+
+// startTLS 发送SSLRequest, 服务端同意时在当前连接上完成TLS握手
+func (cn *conn) startTLS() error {
+	cn.begin(0)
+	cn.int32(sslRequestCode)
+	cn.end()
+	if err := cn.flush(); err != nil {
+		return err
+	}
+	b, err := cn.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 'S':
+	case 'N':
+		if cn.c.TLSOptional {
+			return nil
+		}
+		return errors.New("postgres: server does not support SSL")
+	default:
+		return errMalformed
+	}
+	// 握手前收到的数据未经TLS保护, 可能是中间人注入的
+	if cn.r.Buffered() > 0 {
+		return errors.New("postgres: unexpected data after SSL response")
+	}
+	tc := tls.Client(cn.nc, cn.c.TLS)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	cn.nc, cn.r, cn.w = tc, bufio.NewReader(tc), bufio.NewWriter(tc)
+	return nil
+}
+
+// startup 发送StartupMessage并完成认证, 直到服务端ReadyForQuery. 配置了TLS时先升级连接
+func (cn *conn) startup() error {
+	if cn.c.TLS != nil && cn.c.Net != "unix" {
+		if err := cn.startTLS(); err != nil {
+			return err
+		}
+	}
+	cn.begin(0)
+	cn.int32(3 << 16)
+	cn.cstring("user")
+	cn.cstring(cn.c.User)
+	if cn.c.Database != "" {
+		cn.cstring("database")
+		cn.cstring(cn.c.Database)
+	}
+	// 结果按文本解析, 固定编码和日期格式
+	cn.cstring("client_encoding")
+	cn.cstring("UTF8")
+	cn.cstring("DateStyle")
+	cn.cstring("ISO, MDY")
+	for k, v := range cn.c.Params {
+		cn.cstring(k)
+		cn.cstring(v)
+	}
+	cn.buf = append(cn.buf, 0)
+	cn.end()
+	if err := cn.flush(); err != nil {
+		return err
+	}
+	var sc *scram
+	for {
+		t, b, err := cn.recv()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'E':
+			return parseError(b)
+		case 'K':
+		case 'Z':
+			return nil
+		case 'R':
+			if len(b) < 4 {
+				return errMalformed
+			}
+			code, data := binary.BigEndian.Uint32(b), b[4:]
+			switch code {
+			case 0:
+			case 3:
+				err = cn.password(cn.c.Password)
+			case 5:
+				if len(data) < 4 {
+					return errMalformed
+				}
+				err = cn.password(md5Password(cn.c.User, cn.c.Password, data[:4]))
+			case 10:
+				if !bytes.Contains(data, []byte("SCRAM-SHA-256\x00")) {
+					return fmt.Errorf("postgres: unsupported SASL mechanisms %q", data)
+				}
+				if sc, err = newSCRAM(cn.c.Password); err != nil {
+					return err
+				}
+				cn.begin('p')
+				cn.cstring("SCRAM-SHA-256")
+				first := sc.clientFirst()
+				cn.int32(len(first))
+				cn.buf = append(cn.buf, first...)
+				cn.end()
+				err = cn.flush()
+			case 11:
+				if sc == nil {
+					return errMalformed
+				}
+				var final string
+				if final, err = sc.clientFinal(string(data)); err != nil {
+					return err
+				}
+				cn.begin('p')
+				cn.buf = append(cn.buf, final...)
+				cn.end()
+				err = cn.flush()
+			case 12:
+				if sc == nil {
+					return errMalformed
+				}
+				err = sc.verify(string(data))
+			default:
+				return fmt.Errorf("postgres: unsupported authentication method %d", code)
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			return errMalformed
+		}
+	}
+}
+
+func (cn *conn) password(pw string) error {
+	cn.begin('p')
+	cn.cstring(pw)
+	cn.end()
+	return cn.flush()
+}
+
+// md5Password "md5" + md5(md5(password + user) + salt)
+func md5Password(user, password string, salt []byte) string {
+	h := md5.Sum([]byte(password + user))
+	inner := hex.EncodeToString(h[:])
+	h = md5.Sum(append([]byte(inner), salt...))
+	return "md5" + hex.EncodeToString(h[:])
+}
+
+// scram SCRAM-SHA-256认证(RFC 7677)的客户端状态. 用户名由StartupMessage指定, 这里为空
+type scram struct {
+	password    string
+	nonce       string
+	clientBare  string
+	authMessage string
+	salted      []byte
+}
+
+func newSCRAM(password string) (*scram, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &scram{password: password, nonce: base64.StdEncoding.EncodeToString(b)}
+	s.clientBare = "n=,r=" + s.nonce
+	return s, nil
+}
+
+func (s *scram) clientFirst() string { return "n,," + s.clientBare }
+
+// clientFinal 按server-first-message计算client-final-message
+func (s *scram) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iter := 0
+	for _, kv := range strings.Split(serverFirst, ",") {
+		if len(kv) < 2 || kv[1] != '=' {
+			continue
+		}
+		switch v := kv[2:]; kv[0] {
+		case 'r':
+			nonce = v
+		case 's':
+			salt = v
+		case 'i':
+			iter, _ = strconv.Atoi(v)
+		}
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.nonce) || iter <= 0 {
+		return "", errors.New("postgres: invalid SCRAM server-first-message")
+	}
+	s.salted = scramHi([]byte(s.password), rawSalt, iter)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.clientBare + "," + serverFirst + "," + withoutProof
+	clientKey := hmacSHA256(s.salted, "Client Key")
+	stored := sha256.Sum256(clientKey)
+	proof := hmacSHA256(stored[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify 校验server-final-message中的服务端签名
+func (s *scram) verify(serverFinal string) error {
+	if !strings.HasPrefix(serverFinal, "v=") {
+		return errors.New("postgres: invalid SCRAM server-final-message")
+	}
+	sig, err := base64.StdEncoding.DecodeString(serverFinal[2:])
+	if err != nil {
+		return errors.New("postgres: invalid SCRAM server-final-message")
+	}
+	want := hmacSHA256(hmacSHA256(s.salted, "Server Key"), s.authMessage)
+	if !hmac.Equal(sig, want) {
+		return errors.New("postgres: SCRAM server signature mismatch")
+	}
+	return nil
+}
+
+// scramHi PBKDF2-HMAC-SHA256的第一个块, 即RFC 5802中的Hi函数
+func scramHi(password, salt []byte, iter int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iter; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// parseError 解析ErrorResponse的字段
+func parseError(b []byte) error {
+	e := &Error{}
+	for len(b) > 0 && b[0] != 0 {
+		code := b[0]
+		i := bytes.IndexByte(b[1:], 0)
+		if i < 0 {
+			return errMalformed
+		}
+		v := string(b[1 : 1+i])
+		b = b[2+i:]
+		switch code {
+		case 'S':
+			e.Severity = v
+		case 'C':
+			e.Code = v
+		case 'M':
+			e.Message = v
+		case 'D':
+			e.Detail = v
+		}
+	}
+	return e
+}
+
+// prepare 检查连接并按ctx设置超时
+func (cn *conn) prepare(ctx context.Context) error {
+	if cn.bad {
+		return driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		cn.nc.SetDeadline(dl)
+	} else {
+		cn.nc.SetDeadline(time.Time{})
+	}
+	return nil
+}
+
+// fail 记录错误, 服务端返回的错误不影响连接, 其他错误使连接不再可用
+func (cn *conn) fail(err error) error {
+	if _, ok := err.(*Error); !ok && err != nil {
+		cn.bad = true
+	}
+	return err
+}
+
+// send 用扩展查询协议发送query和参数: Parse、Bind、Describe、Execute、Sync
+func (cn *conn) send(query string, args []driver.NamedValue) error {
+	cn.begin('P')
+	cn.cstring("")
+	cn.cstring(query)
+	cn.int16(0)
+	cn.end()
+
+	cn.begin('B')
+	cn.cstring("")
+	cn.cstring("")
+	cn.int16(0)
+	cn.int16(len(args))
+	for _, a := range args {
+		if a.Name != "" {
+			cn.buf = cn.buf[:0]
+			return errors.New("postgres: named arguments are not supported")
+		}
+		v, null, err := encode(a.Value)
+		if err != nil {
+			cn.buf = cn.buf[:0]
+			return err
+		}
+		if null {
+			cn.int32(-1)
+			continue
+		}
+		cn.int32(len(v))
+		cn.buf = append(cn.buf, v...)
+	}
+	cn.int16(0)
+	cn.end()
+
+	cn.begin('D')
+	cn.buf = append(cn.buf, 'P')
+	cn.cstring("")
+	cn.end()
+
+	cn.begin('E')
+	cn.cstring("")
+	cn.int32(0)
+	cn.end()
+
+	cn.begin('S')
+	cn.end()
+	if err := cn.flush(); err != nil {
+		return cn.fail(err)
+	}
+	return nil
+}
+
+// encode 把参数编码为文本格式
+func encode(v driver.Value) ([]byte, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, true, nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), false, nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), false, nil
+	case bool:
+		return strconv.AppendBool(nil, v), false, nil
+	case string:
+		return []byte(v), false, nil
+	case []byte:
+		if v == nil {
+			return nil, true, nil
+		}
+		b := make([]byte, 2+hex.EncodedLen(len(v)))
+		b[0], b[1] = '\\', 'x'
+		hex.Encode(b[2:], v)
+		return b, false, nil
+	case time.Time:
+		return v.UTC().AppendFormat(nil, "2006-01-02 15:04:05.999999Z07:00"), false, nil
+	}
+	return nil, false, fmt.Errorf("postgres: unsupported argument type %T", v)
+}
+
+// result Exec的结果, 实现driver.Result
+type result struct {
+	affected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("postgres: LastInsertId is not supported, use RETURNING")
+}
+
+func (r result) RowsAffected() (int64, error) { return r.affected, nil }
+
+// commandRows 从CommandComplete的标签中取影响行数, 如 "INSERT 0 1"、"UPDATE 3"
+func commandRows(tag []byte) int64 {
+	s := strings.TrimRight(string(tag), "\x00")
+	if i := strings.LastIndexByte(s, ' '); i >= 0 {
+		if n, err := strconv.ParseInt(s[i+1:], 10, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// wait 读取到ReadyForQuery, 返回其间第一个ErrorResponse
+func (cn *conn) wait(res *result) error {
+	var first error
+	for {
+		t, b, err := cn.recv()
+		if err != nil {
+			return cn.fail(err)
+		}
+		switch t {
+		case 'Z':
+			return first
+		case 'E':
+			if first == nil {
+				first = parseError(b)
+			}
+		case 'C':
+			if res != nil {
+				res.affected = commandRows(b)
+			}
+		}
+	}
+}
+
+// exec 执行语句并丢弃返回的行
+func (cn *conn) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := cn.send(query, args); err != nil {
+		return nil, err
+	}
+	var res result
+	if err := cn.wait(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// query 执行查询, 读取到RowDescription后返回逐行读取的rows
+func (cn *conn) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := cn.send(query, args); err != nil {
+		return nil, err
+	}
+	for {
+		t, b, err := cn.recv()
+		if err != nil {
+			return nil, cn.fail(err)
+		}
+		switch t {
+		case '1', '2':
+		case 'T':
+			cols, err := parseRowDescription(b)
+			if err != nil {
+				return nil, cn.fail(err)
+			}
+			return &rows{cn: cn, cols: cols}, nil
+		case 'n':
+			// 不返回行的语句
+			return &rows{cn: cn}, nil
+		case 'E':
+			err := parseError(b)
+			if werr := cn.wait(nil); werr != nil {
+				if _, ok := werr.(*Error); !ok {
+					return nil, werr
+				}
+			}
+			return nil, err
+		default:
+			return nil, cn.fail(errMalformed)
+		}
+	}
+}
+
+// Prepare 不使用命名的预处理语句, 每次执行时重新发送Parse
+func (cn *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{cn: cn, query: query}, nil
+}
+
+// Close 发送Terminate并关闭连接
+func (cn *conn) Close() error {
+	if !cn.bad {
+		cn.nc.SetDeadline(time.Now().Add(time.Second))
+		cn.begin('X')
+		cn.end()
+		cn.flush()
+	}
+	return cn.nc.Close()
+}
+
+// Begin 实现driver.Conn, 使用BeginTx
+func (cn *conn) Begin() (driver.Tx, error) {
+	return cn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// 与database/sql的IsolationLevel一致
+var isolationLevels = map[driver.IsolationLevel]string{
+	1: "READ UNCOMMITTED",
+	2: "READ COMMITTED",
+	4: "REPEATABLE READ",
+	6: "SERIALIZABLE",
+}
+
+// BeginTx 开始事务, 支持设置隔离级别和只读
+func (cn *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := cn.prepare(ctx); err != nil {
+		return nil, err
+	}
+	q := "BEGIN"
+	if opts.Isolation != 0 {
+		level, ok := isolationLevels[opts.Isolation]
+		if !ok {
+			return nil, fmt.Errorf("postgres: unsupported isolation level %d", opts.Isolation)
+		}
+		q += " ISOLATION LEVEL " + level
+	}
+	if opts.ReadOnly {
+		q += " READ ONLY"
+	}
+	if _, err := cn.exec(q, nil); err != nil {
+		return nil, err
+	}
+	return &tx{cn: cn}, nil
+}
+
+// ExecContext 执行语句, 参数占位符为$1、$2...
+func (cn *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := cn.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return cn.exec(query, args)
+}
+
+// QueryContext 执行查询, 参数占位符为$1、$2...
+func (cn *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := cn.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return cn.query(query, args)
+}
+
+// Ping 执行一条空语句
+func (cn *conn) Ping(ctx context.Context) error {
+	_, err := cn.ExecContext(ctx, "", nil)
+	return err
+}
+
+// ResetSession 从连接池取出前调用, 出错的连接不再使用
+func (cn *conn) ResetSession(ctx context.Context) error {
+	if cn.bad {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid 放回连接池前调用
+func (cn *conn) IsValid() bool { return !cn.bad }
+
+type tx struct {
+	cn *conn
+}
+
+func (t *tx) Commit() error {
+	if t.cn.bad {
+		return driver.ErrBadConn
+	}
+	_, err := t.cn.exec("COMMIT", nil)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	if t.cn.bad {
+		return driver.ErrBadConn
+	}
+	_, err := t.cn.exec("ROLLBACK", nil)
+	return err
+}
+
+// stmt 只保存SQL, 每次执行时发送
+type stmt struct {
+	cn    *conn
+	query string
+}
+
+func (s *stmt) Close() error { return nil }
+
+// NumInput 返回-1, 参数个数由服务端检查
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.cn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.cn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
@@ -0,0 +1,192 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// 类型OID
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidOID         = 26
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidDate        = 1082
+	oidTimestamp   = 1114
+	oidTimestamptz = 1184
+)
+
+type column struct {
+	name string
+	oid  uint32
+}
+
+// parseRowDescription 解析RowDescription中的列名和类型
+func parseRowDescription(b []byte) ([]column, error) {
+	if len(b) < 2 {
+		return nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	cols := make([]column, n)
+	for i := range cols {
+		j := bytes.IndexByte(b, 0)
+		if j < 0 || len(b) < j+19 {
+			return nil, errMalformed
+		}
+		cols[i].name = string(b[:j])
+		// 表OID(4) 列号(2) 类型OID(4) 类型长度(2) 类型修饰(4) 格式(2)
+		cols[i].oid = binary.BigEndian.Uint32(b[j+7:])
+		b = b[j+19:]
+	}
+	return cols, nil
+}
+
+// rows 扩展查询协议的结果, 逐行读取直到ReadyForQuery
+type rows struct {
+	cn   *conn
+	cols []column
+	done bool
+	// err 读取行时收到的ErrorResponse, 在ReadyForQuery之后返回
+	err error
+}
+
+func (rs *rows) Columns() []string {
+	names := make([]string, len(rs.cols))
+	for i, c := range rs.cols {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Close 读完剩余的消息, 之后连接才能执行下一个命令
+func (rs *rows) Close() error {
+	for !rs.done {
+		if err := rs.next(nil); err != nil && err != io.EOF {
+			if _, ok := err.(*Error); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rs *rows) Next(dest []driver.Value) error { return rs.next(dest) }
+
+// next 读取下一行到dest, dest为nil时丢弃
+func (rs *rows) next(dest []driver.Value) error {
+	for {
+		if rs.done {
+			if rs.err != nil {
+				err := rs.err
+				rs.err = nil
+				return err
+			}
+			return io.EOF
+		}
+		t, b, err := rs.cn.recv()
+		if err != nil {
+			rs.done = true
+			return rs.cn.fail(err)
+		}
+		switch t {
+		case 'Z':
+			rs.done = true
+		case 'E':
+			if rs.err == nil {
+				rs.err = parseError(b)
+			}
+		case 'C', 'I', 's':
+		case 'D':
+			if dest == nil {
+				continue
+			}
+			if err := rs.decodeRow(b, dest); err != nil {
+				return err
+			}
+			return nil
+		default:
+			rs.done = true
+			return rs.cn.fail(errMalformed)
+		}
+	}
+}
+
+func (rs *rows) decodeRow(b []byte, dest []driver.Value) error {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(rs.cols) {
+		return rs.cn.fail(errMalformed)
+	}
+	b = b[2:]
+	for i, c := range rs.cols {
+		if len(b) < 4 {
+			return rs.cn.fail(errMalformed)
+		}
+		n := int32(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		if n < 0 {
+			dest[i] = nil
+			continue
+		}
+		if int(n) > len(b) {
+			return rs.cn.fail(errMalformed)
+		}
+		v, err := decode(c, b[:n])
+		if err != nil {
+			return err
+		}
+		dest[i] = v
+		b = b[n:]
+	}
+	return nil
+}
+
+// decode 把文本值转换为driver.Value: 整数为int64, 浮点数为float64, bool为bool,
+// 日期时间为UTC的time.Time, bytea为解码后的[]byte, 其他为[]byte
+func decode(c column, b []byte) (driver.Value, error) {
+	switch c.oid {
+	case oidInt2, oidInt4, oidInt8, oidOID:
+		return strconv.ParseInt(string(b), 10, 64)
+	case oidFloat4, oidFloat8:
+		return strconv.ParseFloat(string(b), 64)
+	case oidBool:
+		return len(b) == 1 && b[0] == 't', nil
+	case oidBytea:
+		if !bytes.HasPrefix(b, []byte(`\x`)) {
+			return nil, fmt.Errorf("postgres: unsupported bytea output in column %s, set bytea_output to hex", c.name)
+		}
+		out := make([]byte, hex.DecodedLen(len(b)-2))
+		_, err := hex.Decode(out, b[2:])
+		return out, err
+	case oidDate, oidTimestamp, oidTimestamptz:
+		return parseTime(string(b))
+	}
+	return b, nil
+}
+
+// timeLayouts DateStyle为ISO时日期时间的输出格式, 秒后的小数部分在解析时自动接受
+var timeLayouts = []string{
+	"2006-01-02 15:04:05Z07",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z07:00:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, l := range timeLayouts {
+		if t, err := time.ParseInLocation(l, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("postgres: invalid time %q", s)
+}
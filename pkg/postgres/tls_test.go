@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 为localhost签发的自签名证书, 同时写入PEM文件用作sslrootcert
+func testCA(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{"localhost"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: k}, path
+}
+
+// serveStartup 读取SSLRequest并回复answer, answer为S时用cert完成TLS握手, 之后接受StartupMessage.
+// 返回服务端是否收到了StartupMessage
+func serveStartup(t *testing.T, server net.Conn, answer byte, cert tls.Certificate) bool {
+	t.Helper()
+	var req [8]byte
+	if _, err := io.ReadFull(server, req[:]); err != nil {
+		t.Fatalf("read SSLRequest: %v", err)
+	}
+	if binary.BigEndian.Uint32(req[:4]) != 8 || binary.BigEndian.Uint32(req[4:]) != 80877103 {
+		t.Fatalf("got %x, want an SSLRequest", req)
+	}
+	server.Write([]byte{answer})
+	var nc net.Conn = server
+	if answer == 'S' {
+		tc := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err := tc.Handshake(); err != nil {
+			// 客户端拒绝证书
+			return false
+		}
+		nc = tc
+	}
+	r := bufio.NewReader(nc)
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return false
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(n)-4); err != nil {
+		return false
+	}
+	nc.Write(append(message('R', "\x00\x00\x00\x00"), message('Z', "I")...))
+	return true
+}
+
+func TestStartupTLS(t *testing.T) {
+	cert, root := testCA(t)
+	tests := []struct {
+		name   string
+		dsn    string
+		answer byte
+		// ok 为true时完成startup, 否则在发送StartupMessage前失败
+		ok bool
+	}{
+		{"verify-full", "postgres://q1mi@localhost/greeter?sslmode=verify-full&sslrootcert=" + root, 'S', true},
+		{"verify-ca", "postgres://q1mi@127.0.0.1/greeter?sslmode=verify-ca&sslrootcert=" + root, 'S', true},
+		{"require", "postgres://q1mi@localhost/greeter?sslmode=require", 'S', true},
+		{"prefer without ssl", "postgres://q1mi@localhost/greeter", 'N', true},
+		{"require without ssl", "postgres://q1mi@localhost/greeter?sslmode=require", 'N', false},
+		{"verify-full wrong host", "postgres://q1mi@db.internal/greeter?sslmode=verify-full&sslrootcert=" + root, 'S', false},
+		{"verify-full untrusted", "postgres://q1mi@localhost/greeter?sslmode=verify-full", 'S', false},
+		// 指定sslrootcert时require也校验证书
+		{"require with untrusted root", "postgres://q1mi@localhost/greeter?sslmode=require&sslrootcert=" + otherRoot(t), 'S', false},
+	}
+	for _, tt := range tests {
+		c, err := ParseDSN(tt.dsn)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// 用TCP而不是net.Pipe: 客户端拒绝证书时双方同时写入, 没有缓冲的Pipe会互相等待
+		client, server := tcpPair(t)
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		server.SetDeadline(time.Now().Add(5 * time.Second))
+		done := make(chan error, 1)
+		go func() {
+			err := newConn(client, c).startup()
+			client.Close()
+			done <- err
+		}()
+		got := serveStartup(t, server, tt.answer, cert)
+		server.Close()
+		err = <-done
+		if tt.ok && (err != nil || !got) {
+			t.Errorf("%s: startup %v, server got StartupMessage %v", tt.name, err, got)
+		}
+		if !tt.ok && (err == nil || got) {
+			t.Errorf("%s: startup %v, server got StartupMessage %v, want failure before startup", tt.name, err, got)
+		}
+	}
+}
+
+// tcpPair 一对本地TCP连接
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// otherRoot 与testCA无关的CA证书文件
+func otherRoot(t *testing.T) string {
+	_, path := testCA(t)
+	return path
+}
+
+// TestStartTLSInjected SSL响应后紧跟的明文数据被拒绝
+func TestStartTLSInjected(t *testing.T) {
+	c, err := ParseDSN("postgres://q1mi@localhost/greeter?sslmode=require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	cn := newConn(nil, c)
+	cn.r = bufio.NewReader(strings.NewReader("S" + string(message('R', "\x00\x00\x00\x00"))))
+	cn.w = bufio.NewWriter(&out)
+	if err := cn.startTLS(); err == nil || !strings.Contains(err.Error(), "unexpected data") {
+		t.Errorf("startTLS = %v, want an error for data before the TLS handshake", err)
+	}
+}
@@ -0,0 +1,17 @@
+package profiling
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Register 把net/http/pprof和expvar的接口注册到mux的/debug/下, 接口没有鉴权
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+}
@@ -0,0 +1,65 @@
+// Package profiling 按需采集pprof格式的性能profile, 无需通过HTTP暴露/debug/pprof; 需要时也可以用Register暴露
+package profiling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// 支持的profile类型
+const (
+	CPU       = "cpu"
+	Heap      = "heap"
+	Goroutine = "goroutine"
+)
+
+// ErrBusy 已有CPU profile正在采集
+var ErrBusy = errors.New("profiling: cpu profile already in progress")
+
+// Capture 采集一次profile并把pprof数据写入w.
+// CPU profile持续采样d; 其他类型在等待d后采集快照. ctx取消时提前结束并返回ctx.Err().
+func Capture(ctx context.Context, kind string, d time.Duration, w io.Writer) error {
+	if kind == CPU {
+		return captureCPU(ctx, d, w)
+	}
+	p := pprof.Lookup(kind)
+	if p == nil {
+		return fmt.Errorf("profiling: unknown profile %q", kind)
+	}
+	if err := sleep(ctx, d); err != nil {
+		return err
+	}
+	if kind == Heap {
+		// 与/debug/pprof/heap?gc=1一致, 先GC以得到最新的存活对象统计
+		runtime.GC()
+	}
+	return p.WriteTo(w, 0)
+}
+
+func captureCPU(ctx context.Context, d time.Duration, w io.Writer) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return ErrBusy
+	}
+	err := sleep(ctx, d)
+	pprof.StopCPUProfile()
+	return err
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
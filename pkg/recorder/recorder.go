@@ -0,0 +1,167 @@
+// Package recorder 把请求(方法、metadata、请求体)脱敏后记录到文件, 并可重新发送到其他环境,
+// 用于在本地复现线上问题. 每行一个JSON对象, 请求体使用protojson格式, 便于查看和修改.
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted 替换敏感内容的值
+const Redacted = zaplog.Redacted
+
+// Config 记录配置
+type Config struct {
+	// Dir 记录文件所在目录, 为空时不记录
+	Dir string `json:"dir"`
+	// Percent 记录的请求比例, 0-100
+	Percent float64 `json:"percent"`
+	// Methods 只记录这些方法(gRPC方法全名), 为空时记录所有方法
+	Methods []string `json:"methods"`
+}
+
+// Entry 一条记录
+type Entry struct {
+	Time     time.Time           `json:"time"`
+	Method   string              `json:"method"`
+	Metadata map[string][]string `json:"metadata,omitempty"`
+	Payload  json.RawMessage     `json:"payload"`
+}
+
+// sensitiveMD 不记录原值的metadata key, gateway转发的同名HTTP头(grpcgateway-前缀)同样处理
+var sensitiveMD = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"x-api-key":     true,
+}
+
+// Recorder 请求记录器, 并发安全
+type Recorder struct {
+	percent float64
+	methods map[string]bool
+
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// New 在c.Dir下创建本次进程的记录文件
+func New(c Config) (*Recorder, error) {
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("recorder: percent must be in [0, 100], got %v", c.Percent)
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("requests-%s-%d.jsonl", time.Now().Format("20060102-150405"), os.Getpid())
+	f, err := os.OpenFile(filepath.Join(c.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{percent: c.Percent, f: f, w: bufio.NewWriter(f)}
+	if len(c.Methods) > 0 {
+		r.methods = map[string]bool{}
+		for _, m := range c.Methods {
+			r.methods[m] = true
+		}
+	}
+	return r, nil
+}
+
+// Path 返回记录文件路径
+func (r *Recorder) Path() string { return r.f.Name() }
+
+// Close 写出缓冲的记录并关闭文件
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// UnaryServerInterceptor 按比例记录请求
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if msg, ok := req.(proto.Message); ok && r.selected(info.FullMethod) {
+			if err := r.record(ctx, info.FullMethod, msg); err != nil {
+				zaplog.FromContext(ctx).Warn("recorder: record request", zaplog.Error(err))
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (r *Recorder) selected(method string) bool {
+	if r.percent <= 0 || (r.methods != nil && !r.methods[method]) {
+		return false
+	}
+	return rand.Float64()*100 < r.percent
+}
+
+func (r *Recorder) record(ctx context.Context, method string, msg proto.Message) error {
+	cp := proto.Clone(msg)
+	Sanitize(cp.ProtoReflect())
+	payload, err := protojson.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	e := Entry{Time: time.Now(), Method: method, Payload: payload}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		e.Metadata = map[string][]string{}
+		for k, vs := range md {
+			if strings.HasPrefix(k, ":") {
+				continue
+			}
+			if sensitiveMD[strings.TrimPrefix(k, "grpcgateway-")] {
+				vs = []string{Redacted}
+			}
+			e.Metadata[k] = vs
+		}
+	}
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(b)
+	r.w.WriteByte('\n')
+	// 每条都写出, 进程异常退出时不丢失记录
+	return r.w.Flush()
+}
+
+// Sanitize 把消息中名字包含zaplog.SensitiveFields中的词的非空字符串字段替换为Redacted, 递归处理子消息
+func Sanitize(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() && zaplog.IsSensitiveField(string(fd.Name())):
+			m.Set(fd, protoreflect.ValueOfString(Redacted))
+		case fd.Kind() == protoreflect.MessageKind && fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				Sanitize(l.Get(i).Message())
+			}
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsMap():
+			Sanitize(v.Message())
+		}
+		return true
+	})
+}
@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ReplayHeader 重放请求携带的metadata key, 值为"true"
+const ReplayHeader = "x-replay"
+
+// ReadFile 读取记录文件中的所有记录
+func ReadFile(path string) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		e := new(Entry)
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("recorder: %s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// Replay 把一条记录发送到cc, 返回响应. 请求和响应类型从已注册的proto描述中查找,
+// 已脱敏的metadata不会发送
+func Replay(ctx context.Context, cc grpc.ClientConnInterface, e *Entry) (proto.Message, error) {
+	md, err := findMethod(e.Method)
+	if err != nil {
+		return nil, err
+	}
+	in, err := newMessage(md.Input())
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(e.Payload, in); err != nil {
+		return nil, fmt.Errorf("recorder: decode payload of %s: %w", e.Method, err)
+	}
+	out, err := newMessage(md.Output())
+	if err != nil {
+		return nil, err
+	}
+	omd := metadata.MD{}
+	for k, vs := range e.Metadata {
+		if k == "content-type" || k == "user-agent" || k == "te" || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		if len(vs) == 1 && vs[0] == Redacted {
+			continue
+		}
+		omd[k] = vs
+	}
+	omd.Set(ReplayHeader, "true")
+	err = cc.Invoke(metadata.NewOutgoingContext(ctx, omd), e.Method, in, out)
+	return out, err
+}
+
+// findMethod 按 /package.Service/Method 查找方法描述
+func findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndexByte(name, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("recorder: invalid method %q", fullMethod)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return nil, fmt.Errorf("recorder: service of %s: %w", fullMethod, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("recorder: %s is not a service", name[:i])
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("recorder: method %s not found", fullMethod)
+	}
+	return md, nil
+}
+
+func newMessage(d protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(d.FullName())
+	if err != nil {
+		return nil, fmt.Errorf("recorder: message %s: %w", d.FullName(), err)
+	}
+	return mt.New().Interface(), nil
+}
@@ -0,0 +1,334 @@
+// Package redis 是一个精简的Redis客户端(RESP2协议), 只提供执行命令和连接池,
+// 供选主、缓存等需要跨实例共享状态的功能使用
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Nil 键不存在或命令返回空值
+var Nil = errors.New("redis: nil")
+
+// Error Redis服务端返回的错误
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Config 连接配置
+type Config struct {
+	// Addr 服务地址, 如 "127.0.0.1:6379"
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	// PoolSize 保留的空闲连接数
+	PoolSize int `json:"pool_size"`
+	// DialTimeout 建立连接的超时时间, 如 "5s"
+	DialTimeout string `json:"dial_timeout"`
+}
+
+// Client 并发安全的Redis客户端
+type Client struct {
+	c           Config
+	dialTimeout time.Duration
+	idle        chan *conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// New 创建客户端, 连接在第一次执行命令时建立
+func New(c Config) (*Client, error) {
+	if c.Addr == "" {
+		return nil, errors.New("redis: addr is required")
+	}
+	if c.PoolSize <= 0 {
+		c.PoolSize = 10
+	}
+	d := 5 * time.Second
+	if c.DialTimeout != "" {
+		var err error
+		if d, err = time.ParseDuration(c.DialTimeout); err != nil {
+			return nil, fmt.Errorf("redis: dial_timeout: %w", err)
+		}
+	}
+	return &Client{c: c, dialTimeout: d, idle: make(chan *conn, c.PoolSize)}, nil
+}
+
+// Do 执行一条命令, 返回值为string、int64、[]interface{}或nil对应的Nil错误
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		cn.nc.SetDeadline(dl)
+	} else {
+		cn.nc.SetDeadline(time.Time{})
+	}
+	v, err := cn.do(args)
+	if _, ok := err.(Error); err != nil && !ok && err != Nil {
+		// 网络或协议错误, 连接状态未知, 直接丢弃
+		cn.nc.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return v, err
+}
+
+// Close 关闭所有空闲连接, 之后不能再使用
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.idle)
+	for cn := range c.idle {
+		cn.nc.Close()
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn, ok := <-c.idle:
+		if ok {
+			return cn, nil
+		}
+		return nil, errors.New("redis: client closed")
+	default:
+	}
+	return c.dial(ctx)
+}
+
+// dial 建立新连接, 完成认证并选择数据库
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: c.dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.c.Password != "" {
+		if _, err := cn.do([]interface{}{"AUTH", c.c.Password}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.c.DB != 0 {
+		if _, err := cn.do([]interface{}{"SELECT", c.c.DB}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.nc.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.nc.Close()
+	}
+}
+
+// Publish 向channel发布消息, 返回收到消息的订阅者数量
+func (c *Client) Publish(ctx context.Context, channel, message string) (int64, error) {
+	return Int(c.Do(ctx, "PUBLISH", channel, message))
+}
+
+// PubSub 订阅连接, 独占一个连接, 不放回连接池. Receive不能并发调用
+type PubSub struct {
+	cn *conn
+}
+
+// Subscribe 建立新连接并订阅channels, 收到全部订阅确认后返回
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("redis: no channels to subscribe")
+	}
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		cn.nc.SetDeadline(dl)
+	}
+	args := make([]interface{}, 0, len(channels)+1)
+	args = append(args, "SUBSCRIBE")
+	for _, ch := range channels {
+		args = append(args, ch)
+	}
+	// 每个channel有一条确认, 第一条是命令的回复
+	v, err := cn.do(args)
+	for i := 1; err == nil && i < len(channels); i++ {
+		v, err = cn.read()
+	}
+	if err == nil {
+		if vs, ok := v.([]interface{}); !ok || len(vs) != 3 || vs[0] != "subscribe" {
+			err = fmt.Errorf("redis: unexpected subscribe reply %v", v)
+		}
+	}
+	if err != nil {
+		cn.nc.Close()
+		return nil, err
+	}
+	cn.nc.SetDeadline(time.Time{})
+	return &PubSub{cn: cn}, nil
+}
+
+// Receive 等待下一条消息, 返回其channel和内容. 连接断开或被Close时返回错误, 之后应重新订阅
+func (ps *PubSub) Receive() (channel, message string, err error) {
+	for {
+		v, err := ps.cn.read()
+		if err != nil {
+			return "", "", err
+		}
+		vs, ok := v.([]interface{})
+		if !ok || len(vs) != 3 {
+			return "", "", fmt.Errorf("redis: unexpected pubsub reply %v", v)
+		}
+		if vs[0] != "message" {
+			// 订阅确认等其他推送
+			continue
+		}
+		channel, _ = vs[1].(string)
+		message, _ = vs[2].(string)
+		return channel, message, nil
+	}
+}
+
+// Close 关闭订阅连接, 阻塞中的Receive返回错误
+func (ps *PubSub) Close() error {
+	return ps.cn.nc.Close()
+}
+
+// String 把Do的结果转换为string
+func String(v interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply type %T", v)
+	}
+	return s, nil
+}
+
+// Int 把Do的结果转换为int64
+func Int(v interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply type %T", v)
+	}
+}
+
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+func (cn *conn) do(args []interface{}) (interface{}, error) {
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var s string
+		switch a := a.(type) {
+		case string:
+			s = a
+		case []byte:
+			s = string(a)
+		case int:
+			s = strconv.Itoa(a)
+		case int64:
+			s = strconv.FormatInt(a, 10)
+		default:
+			s = fmt.Sprint(a)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	typ, body := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, Nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, Nil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			v, err := cn.read()
+			switch err := err.(type) {
+			case nil:
+				vs[i] = v
+			case Error:
+				// 元素级错误(如EXEC中的某条命令失败)作为值返回, 保证读完整个回复
+				vs[i] = err
+			default:
+				if err != Nil {
+					return nil, err
+				}
+			}
+		}
+		return vs, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", typ)
+	}
+}
@@ -0,0 +1,138 @@
+// Package scheduler 按固定间隔执行定时任务, 多实例部署时只在leader上执行
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/leader"
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// Job 定时任务, ctx在Scheduler停止或本实例失去leader身份时取消
+type Job func(ctx context.Context) error
+
+var runsTotal = metrics.NewCounterVec("scheduler_job_runs_total",
+	"Number of scheduled job runs by job and result.", "job", "result")
+
+type job struct {
+	name     string
+	interval time.Duration
+	// daily 为true时每天在UTC零点后at执行, 不使用interval
+	daily bool
+	at    time.Duration
+	fn    Job
+}
+
+// Scheduler 定时任务调度器
+type Scheduler struct {
+	elector *leader.Elector
+
+	mu      sync.Mutex
+	jobs    []job
+	started bool
+}
+
+// New 创建调度器, elector为nil时任务在本实例上总是执行
+func New(elector *leader.Elector) *Scheduler {
+	return &Scheduler{elector: elector}
+}
+
+// Every 添加每隔interval执行一次的任务, 必须在Run之前调用
+func (s *Scheduler) Every(name string, interval time.Duration, fn Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic("scheduler: Every called after Run")
+	}
+	s.jobs = append(s.jobs, job{name: name, interval: interval, fn: fn})
+}
+
+// Daily 添加每天在UTC零点后at(如 10*time.Minute 表示00:10)执行的任务, 必须在Run之前调用
+func (s *Scheduler) Daily(name string, at time.Duration, fn Job) {
+	if at < 0 || at >= 24*time.Hour {
+		panic("scheduler: Daily offset must be in [0, 24h)")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic("scheduler: Daily called after Run")
+	}
+	s.jobs = append(s.jobs, job{name: name, daily: true, at: at, fn: fn})
+}
+
+// Run 执行所有任务直到ctx取消
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			if j.daily {
+				s.runDaily(ctx, j)
+				return
+			}
+			t := time.NewTicker(j.interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					s.run(ctx, j)
+				}
+			}
+		}(j)
+	}
+	wg.Wait()
+}
+
+// runDaily 每天在指定时间执行j, 直到ctx取消
+func (s *Scheduler) runDaily(ctx context.Context, j job) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(j.at)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		t := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+			s.run(ctx, j)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j job) {
+	if s.elector != nil {
+		lctx, cancel, ok := s.elector.LeaderContext(ctx)
+		if !ok {
+			runsTotal.WithLabelValues(j.name, "skipped").Inc()
+			return
+		}
+		defer cancel()
+		ctx = lctx
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("scheduler: job %s panic: %v", j.name, r)
+			runsTotal.WithLabelValues(j.name, "error").Inc()
+		}
+	}()
+	if err := j.fn(ctx); err != nil {
+		log.Printf("scheduler: job %s: %v", j.name, err)
+		runsTotal.WithLabelValues(j.name, "error").Inc()
+		return
+	}
+	runsTotal.WithLabelValues(j.name, "success").Inc()
+}
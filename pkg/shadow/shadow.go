@@ -0,0 +1,157 @@
+// Package shadow 把一部分请求异步复制到另一个gRPC服务(如新版本), 用生产流量验证新版本.
+// 复制的请求不影响原请求: 不等待、不比较响应, 失败只记录指标.
+package shadow
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Header 复制请求携带的metadata key, 值为"true". 目标服务可据此跳过发送邮件等外部副作用
+const Header = "x-shadow"
+
+// Config 流量复制配置
+type Config struct {
+	// Target 目标gRPC服务地址, 为空时不复制
+	Target string `json:"target"`
+	// Percent 复制的请求比例, 0-100
+	Percent float64 `json:"percent"`
+	// Methods 只复制这些方法(gRPC方法全名), 为空时复制所有方法
+	Methods []string `json:"methods"`
+	// Timeout 单个复制请求的超时时间, 如 "2s"
+	Timeout string `json:"timeout"`
+	// MaxInFlight 同时进行的复制请求上限, 超出时丢弃
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+var requestsTotal = metrics.NewCounterVec("shadow_requests_total",
+	"Number of mirrored requests by method and result (gRPC status code, or dropped when over max_in_flight).", "method", "result")
+
+// Mirror 流量复制器
+type Mirror struct {
+	conn    *grpc.ClientConn
+	percent float64
+	methods map[string]bool
+	timeout time.Duration
+	sem     chan struct{}
+}
+
+// New 创建复制器并连接目标服务
+func New(c Config) (*Mirror, error) {
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("shadow: percent must be in [0, 100], got %v", c.Percent)
+	}
+	m := &Mirror{percent: c.Percent, timeout: 2 * time.Second}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("shadow: timeout: %w", err)
+		}
+		m.timeout = d
+	}
+	if len(c.Methods) > 0 {
+		m.methods = map[string]bool{}
+		for _, name := range c.Methods {
+			m.methods[name] = true
+		}
+	}
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = 100
+	}
+	m.sem = make(chan struct{}, c.MaxInFlight)
+	conn, err := grpc.Dial(c.Target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(discardCodec{})))
+	if err != nil {
+		return nil, fmt.Errorf("shadow: dial %s: %w", c.Target, err)
+	}
+	m.conn = conn
+	return m, nil
+}
+
+// Close 关闭到目标服务的连接
+func (m *Mirror) Close() error {
+	return m.conn.Close()
+}
+
+// UnaryServerInterceptor 按比例异步复制请求, 然后正常处理原请求. 本身就是复制请求的不再复制, 避免循环
+func (m *Mirror) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m.selected(ctx, info.FullMethod) {
+			if msg, ok := req.(proto.Message); ok {
+				// handler可能修改请求, 先复制一份
+				m.mirror(ctx, info.FullMethod, proto.Clone(msg))
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (m *Mirror) selected(ctx context.Context, method string) bool {
+	if m.percent <= 0 || (m.methods != nil && !m.methods[method]) {
+		return false
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(Header)) > 0 {
+		return false
+	}
+	return rand.Float64()*100 < m.percent
+}
+
+func (m *Mirror) mirror(ctx context.Context, method string, req proto.Message) {
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		requestsTotal.WithLabelValues(method, "dropped").Inc()
+		return
+	}
+	md := outgoingMD(ctx)
+	go func() {
+		defer func() { <-m.sem }()
+		// 不继承原请求的ctx, 原请求结束后复制请求仍可完成
+		sctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), m.timeout)
+		defer cancel()
+		err := m.conn.Invoke(sctx, method, req, nil)
+		requestsTotal.WithLabelValues(method, status.Code(err).String()).Inc()
+	}()
+}
+
+// outgoingMD 复制原请求的metadata和baggage, 去掉HTTP/2伪头和传输相关的key并加上复制标记
+func outgoingMD(ctx context.Context) metadata.MD {
+	in, _ := metadata.FromIncomingContext(ctx)
+	out := metadata.MD{}
+	for k, vs := range in {
+		if strings.HasPrefix(k, ":") || k == "content-type" || k == "user-agent" || k == "te" || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		out[k] = append([]string(nil), vs...)
+	}
+	// 包括拦截器在本次请求中设置的baggage项
+	if b := ctxutil.FormatBaggage(ctxutil.BaggageItems(ctx)); b != "" {
+		out.Set(ctxutil.BaggageHeader, b)
+	}
+	out.Set(Header, "true")
+	return out
+}
+
+// discardCodec 按protobuf编码请求, 丢弃响应内容, 因此复制请求不需要知道响应类型
+type discardCodec struct{}
+
+func (discardCodec) Marshal(v interface{}) ([]byte, error) {
+	return encoding.GetCodec("proto").Marshal(v)
+}
+
+func (discardCodec) Unmarshal(data []byte, v interface{}) error { return nil }
+
+func (discardCodec) Name() string { return "proto" }
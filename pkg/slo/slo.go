@@ -0,0 +1,303 @@
+// Package slo 按方法统计可用性和延迟SLI, 计算SLO错误预算的消耗速度(burn rate)并导出为指标,
+// 预算消耗过快时写告警日志并通知注册的回调.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Windows 计算SLI和burn rate的滚动窗口
+var Windows = []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour}
+
+// bucketCount 保留的分钟桶数量, 覆盖最大的窗口
+const bucketCount = 360
+
+// Objective 一个SLO
+type Objective struct {
+	// Name SLO名称, 用作指标标签
+	Name string `json:"name"`
+	// Method gRPC方法全名, 如 /helloworld.Greeter/SayHello, 为空表示所有方法
+	Method string `json:"method"`
+	// Availability 成功请求比例的目标, 如 0.999, 为0表示不考核可用性
+	Availability float64 `json:"availability"`
+	// LatencyThreshold 延迟阈值, 如 "300ms"
+	LatencyThreshold string `json:"latency_threshold"`
+	// Latency 延迟低于阈值的请求比例的目标, 如 0.99, 为0表示不考核延迟
+	Latency float64 `json:"latency"`
+
+	threshold time.Duration
+}
+
+// Config SLO配置
+type Config struct {
+	Objectives []Objective `json:"objectives"`
+	// AlertBurnRate 最短的两个窗口的burn rate同时超过该值时告警, 为0时不告警.
+	// 14.4表示按当前速度约2%的30天预算在1小时内耗尽
+	AlertBurnRate float64 `json:"alert_burn_rate"`
+	// AlertEmails 告警和恢复时收到邮件的地址, 为空时只写日志
+	AlertEmails []string `json:"alert_emails"`
+}
+
+// Alert 一次告警状态变化
+type Alert struct {
+	SLO string
+	SLI string
+	// Firing true表示开始告警, false表示恢复
+	Firing     bool
+	BurnRate5m float64
+	BurnRate1h float64
+	Time       time.Time
+}
+
+var (
+	sliRatio = metrics.NewGaugeVec("slo_sli_ratio",
+		"Ratio of good requests per method over a rolling window; sli is availability or latency_<threshold>.", "method", "sli", "window")
+	burnRate = metrics.NewGaugeVec("slo_error_budget_burn_rate",
+		"Error budget burn rate per SLO over a rolling window; 1 means the budget lasts exactly the SLO period.", "slo", "sli", "window")
+	alertsTotal = metrics.NewCounterVec("slo_alerts_total",
+		"Number of fast-burn alerts raised per SLO.", "slo", "sli")
+)
+
+type bucket struct {
+	minute              int64
+	total, errors, slow uint64
+}
+
+// Tracker 记录请求结果并定期评估SLO
+type Tracker struct {
+	c   Config
+	now func() time.Time
+
+	mu sync.Mutex
+	// counts 方法 -> 延迟阈值 -> 分钟桶; 阈值为0的桶只用于可用性
+	counts map[string]map[time.Duration]*[bucketCount]bucket
+	// alerting 正在告警的 slo/sli
+	alerting map[string]bool
+	onAlert  []func(Alert)
+}
+
+// New 创建Tracker
+func New(c Config) (*Tracker, error) {
+	for i := range c.Objectives {
+		o := &c.Objectives[i]
+		if o.Name == "" {
+			return nil, fmt.Errorf("slo: objective %d has no name", i)
+		}
+		if o.Availability < 0 || o.Availability >= 1 || o.Latency < 0 || o.Latency >= 1 {
+			return nil, fmt.Errorf("slo: %s: targets must be in [0, 1)", o.Name)
+		}
+		if o.Latency > 0 {
+			d, err := time.ParseDuration(o.LatencyThreshold)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("slo: %s: invalid latency_threshold %q", o.Name, o.LatencyThreshold)
+			}
+			o.threshold = d
+		}
+	}
+	return &Tracker{
+		c:        c,
+		now:      time.Now,
+		counts:   map[string]map[time.Duration]*[bucketCount]bucket{},
+		alerting: map[string]bool{},
+	}, nil
+}
+
+// OnAlert 注册告警状态变化时的回调, 必须在Run之前调用. 回调在持有锁时执行, 不应阻塞
+func (t *Tracker) OnAlert(fn func(Alert)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onAlert = append(t.onAlert, fn)
+}
+
+// thresholds 返回需要为method统计的延迟阈值, 总包含0(只统计总数和错误数)
+func (t *Tracker) thresholds(method string) []time.Duration {
+	ds := []time.Duration{0}
+	for _, o := range t.c.Objectives {
+		if o.threshold > 0 && (o.Method == "" || o.Method == method) {
+			ds = append(ds, o.threshold)
+		}
+	}
+	return ds
+}
+
+// Record 记录一次请求
+func (t *Tracker) Record(method string, code codes.Code, latency time.Duration) {
+	minute := t.now().Unix() / 60
+	failed := isServerError(code)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.counts[method]
+	if !ok {
+		m = map[time.Duration]*[bucketCount]bucket{}
+		for _, d := range t.thresholds(method) {
+			m[d] = new([bucketCount]bucket)
+		}
+		t.counts[method] = m
+	}
+	for d, bs := range m {
+		b := &bs[minute%bucketCount]
+		if b.minute != minute {
+			*b = bucket{minute: minute}
+		}
+		b.total++
+		if failed {
+			b.errors++
+		}
+		if d > 0 && latency > d {
+			b.slow++
+		}
+	}
+}
+
+// isServerError 是否计为不可用. 参数错误、未认证等由调用方造成的错误不消耗错误预算
+func isServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Internal, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// sum 汇总最近n分钟的桶
+func sum(bs *[bucketCount]bucket, minute int64, n int) (total, errors, slow uint64) {
+	for i := range bs {
+		if b := &bs[i]; b.minute > minute-int64(n) && b.minute <= minute {
+			total += b.total
+			errors += b.errors
+			slow += b.slow
+		}
+	}
+	return
+}
+
+func windowLabel(w time.Duration) string {
+	if w%time.Hour == 0 {
+		return strconv.Itoa(int(w/time.Hour)) + "h"
+	}
+	return strconv.Itoa(int(w/time.Minute)) + "m"
+}
+
+// Evaluate 更新SLI和burn rate指标, 并检查是否需要告警
+func (t *Tracker) Evaluate() {
+	minute := t.now().Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	methods := make([]string, 0, len(t.counts))
+	for m := range t.counts {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	for _, m := range methods {
+		for d, bs := range t.counts[m] {
+			for _, w := range Windows {
+				total, errs, slow := sum(bs, minute, int(w/time.Minute))
+				if total == 0 {
+					continue
+				}
+				if d == 0 {
+					sliRatio.WithLabelValues(m, "availability", windowLabel(w)).Set(1 - float64(errs)/float64(total))
+				} else {
+					sliRatio.WithLabelValues(m, "latency_"+d.String(), windowLabel(w)).Set(1 - float64(slow)/float64(total))
+				}
+			}
+		}
+	}
+
+	for _, o := range t.c.Objectives {
+		if o.Availability > 0 {
+			t.evaluate(o, "availability", 0, 1-o.Availability, minute, methods)
+		}
+		if o.Latency > 0 {
+			t.evaluate(o, "latency", o.threshold, 1-o.Latency, minute, methods)
+		}
+	}
+}
+
+// evaluate 计算一个SLI在各窗口的burn rate: 坏请求比例/错误预算比例
+func (t *Tracker) evaluate(o Objective, sli string, threshold time.Duration, budget float64, minute int64, methods []string) {
+	rates := make([]float64, len(Windows))
+	for i, w := range Windows {
+		var total, bad uint64
+		for _, m := range methods {
+			if o.Method != "" && o.Method != m {
+				continue
+			}
+			bs := t.counts[m][threshold]
+			if bs == nil {
+				continue
+			}
+			n, errs, slow := sum(bs, minute, int(w/time.Minute))
+			total += n
+			if threshold > 0 {
+				bad += slow
+			} else {
+				bad += errs
+			}
+		}
+		if total > 0 {
+			rates[i] = float64(bad) / float64(total) / budget
+		}
+		burnRate.WithLabelValues(o.Name, sli, windowLabel(w)).Set(rates[i])
+	}
+
+	if t.c.AlertBurnRate <= 0 {
+		return
+	}
+	key := o.Name + "/" + sli
+	firing := rates[0] > t.c.AlertBurnRate && rates[1] > t.c.AlertBurnRate
+	if firing == t.alerting[key] {
+		return
+	}
+	t.alerting[key] = firing
+	l := zaplog.L().With(zaplog.String("slo", o.Name), zaplog.String("sli", sli),
+		zaplog.Any("burn_rate_5m", rates[0]), zaplog.Any("burn_rate_1h", rates[1]))
+	if firing {
+		alertsTotal.WithLabelValues(o.Name, sli).Inc()
+		l.Error("slo: error budget is burning too fast")
+	} else {
+		l.Info("slo: error budget burn rate back to normal")
+	}
+	a := Alert{SLO: o.Name, SLI: sli, Firing: firing, BurnRate5m: rates[0], BurnRate1h: rates[1], Time: t.now()}
+	for _, fn := range t.onAlert {
+		fn(a)
+	}
+}
+
+// Run 每隔interval评估一次, 直到ctx取消
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			t.Evaluate()
+		}
+	}
+}
+
+// UnaryServerInterceptor 记录每个请求的结果和耗时
+func (t *Tracker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		t.Record(info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
@@ -0,0 +1,245 @@
+// Package stats 在内存中汇总调用统计: 问候次数、不同名字的数量和各方法在时间窗口内的调用次数,
+// 并定期把累计值保存到文件, 重启后继续累计.
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// windowMinutes 保留的分钟桶数量, 决定最大统计窗口
+const windowMinutes = 60
+
+// Windows 对外提供的统计窗口
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// methodCounter 一个方法的调用计数
+type methodCounter struct {
+	total   uint64
+	buckets [windowMinutes]uint64
+	// minutes 每个桶对应的分钟数(Unix时间/60), 用于判断桶是否过期
+	minutes [windowMinutes]int64
+}
+
+func (c *methodCounter) add(minute int64) {
+	i := minute % windowMinutes
+	if c.minutes[i] != minute {
+		c.minutes[i] = minute
+		c.buckets[i] = 0
+	}
+	c.buckets[i]++
+	c.total++
+}
+
+// window 统计最近n分钟(含当前分钟)的调用次数
+func (c *methodCounter) window(minute int64, n int) uint64 {
+	var sum uint64
+	for i := 0; i < windowMinutes; i++ {
+		if m := c.minutes[i]; m > minute-int64(n) && m <= minute {
+			sum += c.buckets[i]
+		}
+	}
+	return sum
+}
+
+// MethodStats 一个方法的调用统计
+type MethodStats struct {
+	Method string
+	// Windows 与stats.Windows一一对应的调用次数
+	Windows []uint64
+	Total   uint64
+}
+
+// Snapshot 某一时刻的统计结果
+type Snapshot struct {
+	GreetingsServed uint64
+	UniqueNames     uint64
+	Methods         []MethodStats
+	StartTime       time.Time
+}
+
+// persisted 保存到文件的累计值
+type persisted struct {
+	GreetingsServed uint64            `json:"greetings_served"`
+	Names           []string          `json:"names"`
+	MethodTotals    map[string]uint64 `json:"method_totals"`
+	StartTime       time.Time         `json:"start_time"`
+}
+
+// Aggregator 调用统计汇总
+type Aggregator struct {
+	path string
+
+	mu        sync.Mutex
+	greetings uint64
+	names     map[string]struct{}
+	methods   map[string]*methodCounter
+	start     time.Time
+	dirty     bool
+	now       func() time.Time
+}
+
+// New 创建Aggregator; path不为空时从文件加载之前保存的累计值
+func New(path string) (*Aggregator, error) {
+	a := &Aggregator{
+		path:    path,
+		names:   map[string]struct{}{},
+		methods: map[string]*methodCounter{},
+		start:   time.Now(),
+		now:     time.Now,
+	}
+	if path == "" {
+		return a, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p persisted
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	a.greetings = p.GreetingsServed
+	for _, n := range p.Names {
+		a.names[n] = struct{}{}
+	}
+	for m, t := range p.MethodTotals {
+		a.methods[m] = &methodCounter{total: t}
+	}
+	if !p.StartTime.IsZero() {
+		a.start = p.StartTime
+	}
+	return a, nil
+}
+
+// RecordGreeting 记录一次问候
+func (a *Aggregator) RecordGreeting(name string) {
+	a.mu.Lock()
+	a.greetings++
+	a.names[name] = struct{}{}
+	a.dirty = true
+	a.mu.Unlock()
+}
+
+// RecordCall 记录一次方法调用
+func (a *Aggregator) RecordCall(method string) {
+	minute := a.now().Unix() / 60
+	a.mu.Lock()
+	c, ok := a.methods[method]
+	if !ok {
+		c = &methodCounter{}
+		a.methods[method] = c
+	}
+	c.add(minute)
+	a.dirty = true
+	a.mu.Unlock()
+}
+
+// Snapshot 返回当前统计, 方法按名字排序
+func (a *Aggregator) Snapshot() *Snapshot {
+	minute := a.now().Unix() / 60
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := &Snapshot{
+		GreetingsServed: a.greetings,
+		UniqueNames:     uint64(len(a.names)),
+		StartTime:       a.start,
+	}
+	for m, c := range a.methods {
+		ms := MethodStats{Method: m, Total: c.total}
+		for _, w := range Windows {
+			ms.Windows = append(ms.Windows, c.window(minute, int(w/time.Minute)))
+		}
+		s.Methods = append(s.Methods, ms)
+	}
+	sort.Slice(s.Methods, func(i, j int) bool { return s.Methods[i].Method < s.Methods[j].Method })
+	return s
+}
+
+// Persist 把累计值写入文件, 没有变化时跳过
+func (a *Aggregator) Persist() error {
+	if a.path == "" {
+		return nil
+	}
+	a.mu.Lock()
+	if !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	p := persisted{
+		GreetingsServed: a.greetings,
+		Names:           make([]string, 0, len(a.names)),
+		MethodTotals:    make(map[string]uint64, len(a.methods)),
+		StartTime:       a.start,
+	}
+	for n := range a.names {
+		p.Names = append(p.Names, n)
+	}
+	for m, c := range a.methods {
+		p.MethodTotals[m] = c.total
+	}
+	a.dirty = false
+	a.mu.Unlock()
+
+	sort.Strings(p.Names)
+	b, err := json.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名, 避免写到一半时进程退出导致文件损坏
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), a.path)
+}
+
+// Run 每隔interval保存一次, ctx结束时最后保存一次后返回
+func (a *Aggregator) Run(ctx context.Context, interval time.Duration) {
+	if a.path == "" || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := a.Persist(); err != nil {
+				log.Printf("stats: persist: %v", err)
+			}
+		case <-ctx.Done():
+			if err := a.Persist(); err != nil {
+				log.Printf("stats: persist: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// UnaryServerInterceptor 统计每个方法的调用次数
+func (a *Aggregator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		a.RecordCall(info.FullMethod)
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,172 @@
+// Package structutil 在Go值和google.protobuf.Struct/ListValue/Value之间转换.
+// 与structpb.NewValue相比支持更多类型(各种整数、切片、以string为key的map、time.Time等),
+// 并在遇到无法表示的值时返回带路径的错误, 而不是静默转换.
+package structutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxSafeInt float64能精确表示的最大整数, 超过时转换会丢失精度
+const maxSafeInt = 1 << 53
+
+// FromMap 把map转换为Struct
+func FromMap(m map[string]interface{}) (*structpb.Struct, error) {
+	s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(m))}
+	for k, v := range m {
+		pv, err := value(v, k)
+		if err != nil {
+			return nil, err
+		}
+		s.Fields[k] = pv
+	}
+	return s, nil
+}
+
+// FromSlice 把切片或数组(如[]string、[]interface{})转换为ListValue
+func FromSlice(s interface{}) (*structpb.ListValue, error) {
+	rv := reflect.ValueOf(s)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("structutil: FromSlice: %T is not a slice", s)
+	}
+	return list(rv, "")
+}
+
+// Value 把单个Go值转换为Value, 支持nil、bool、整数、浮点数、string、[]byte(base64)、
+// time.Time(RFC3339)、time.Duration(字符串)、json.Number、*structpb.Struct等proto值,
+// 以及由这些类型组成的切片和以string为key的map
+func Value(v interface{}) (*structpb.Value, error) {
+	return value(v, "")
+}
+
+// ToMap 把Struct转换为map, s为nil时返回nil
+func ToMap(s *structpb.Struct) map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+// ToSlice 把ListValue转换为切片, l为nil时返回nil
+func ToSlice(l *structpb.ListValue) []interface{} {
+	if l == nil {
+		return nil
+	}
+	return l.AsSlice()
+}
+
+func value(v interface{}, path string) (*structpb.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return structpb.NewNullValue(), nil
+	case *structpb.Value:
+		return v, nil
+	case *structpb.Struct:
+		return structpb.NewStructValue(v), nil
+	case *structpb.ListValue:
+		return structpb.NewListValue(v), nil
+	case []byte:
+		return structpb.NewValue(v)
+	case time.Time:
+		return structpb.NewStringValue(v.Format(time.RFC3339Nano)), nil
+	case time.Duration:
+		return structpb.NewStringValue(v.String()), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("structutil: %s: %w", pathName(path), err)
+		}
+		return structpb.NewNumberValue(f), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return structpb.NewBoolValue(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if n > maxSafeInt || n < -maxSafeInt {
+			return nil, fmt.Errorf("structutil: %s: integer %d cannot be represented exactly as a number", pathName(path), n)
+		}
+		return structpb.NewNumberValue(float64(n)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n > maxSafeInt {
+			return nil, fmt.Errorf("structutil: %s: integer %d cannot be represented exactly as a number", pathName(path), n)
+		}
+		return structpb.NewNumberValue(float64(n)), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("structutil: %s: %v is not a valid JSON number", pathName(path), f)
+		}
+		return structpb.NewNumberValue(f), nil
+	case reflect.String:
+		return structpb.NewStringValue(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		l, err := list(rv, path)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewListValue(l), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("structutil: %s: map key type %s is not string", pathName(path), rv.Type().Key())
+		}
+		if rv.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		s := &structpb.Struct{Fields: make(map[string]*structpb.Value, rv.Len())}
+		it := rv.MapRange()
+		for it.Next() {
+			k := it.Key().String()
+			pv, err := value(it.Value().Interface(), join(path, k))
+			if err != nil {
+				return nil, err
+			}
+			s.Fields[k] = pv
+		}
+		return structpb.NewStructValue(s), nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		return value(rv.Elem().Interface(), path)
+	}
+	return nil, fmt.Errorf("structutil: %s: unsupported type %T", pathName(path), v)
+}
+
+func list(rv reflect.Value, path string) (*structpb.ListValue, error) {
+	l := &structpb.ListValue{Values: make([]*structpb.Value, rv.Len())}
+	for i := 0; i < rv.Len(); i++ {
+		pv, err := value(rv.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		l.Values[i] = pv
+	}
+	return l, nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathName(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return path
+}
@@ -0,0 +1,63 @@
+package swagger
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// 文档的访问控制
+const (
+	// AuthNone 不需要认证
+	AuthNone = ""
+	// AuthBasic HTTP basic认证, 用户名和密码来自配置
+	AuthBasic = "basic"
+	// AuthAPIKey 与gateway相同的API key校验, 请求头中需要app-id和app-secret
+	AuthAPIKey = "api_key"
+)
+
+// Config 文档和页面的配置. 对外暴露内部接口文档是常见的安全审计问题, 生产环境应关闭或要求认证
+type Config struct {
+	// Enabled 是否在HTTP端口提供 /swagger/ 和 /openapi.json
+	Enabled bool `json:"enabled"`
+	// Auth 访问需要的认证: 空(不需要)、basic 或 api_key
+	Auth string `json:"auth"`
+	// Username, Password auth为basic时的用户名和密码, 密码可以使用 ${file:...} 引用
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Dirs 除编译进程序的文档外, 启动时在这些目录(包括子目录)中查找 *.swagger.json, 如插件服务的文档
+	Dirs []string `json:"dirs"`
+}
+
+// Validate 检查配置
+func (c Config) Validate() error {
+	switch c.Auth {
+	case AuthNone, AuthAPIKey:
+	case AuthBasic:
+		if c.Username == "" || c.Password == "" {
+			return fmt.Errorf("swagger: username and password are required when auth is %s", AuthBasic)
+		}
+	default:
+		return fmt.Errorf("swagger: unknown auth %q", c.Auth)
+	}
+	return nil
+}
+
+// BasicAuth 要求请求携带正确的basic认证用户名和密码, 否则返回401
+func BasicAuth(username, password string, next http.Handler) http.Handler {
+	// 比较哈希, 耗时与输入长度无关
+	wantUser, wantPass := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		gotUser, gotPass := sha256.Sum256([]byte(u)), sha256.Sum256([]byte(p))
+		userOK := subtle.ConstantTimeCompare(gotUser[:], wantUser[:])
+		passOK := subtle.ConstantTimeCompare(gotPass[:], wantPass[:])
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="greeter API docs", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package swagger
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Merge 把多个swagger 2.0文档合并为一个, 标题为greeter API: paths、definitions、securityDefinitions按名称合并,
+// tags、consumes和produces去重. 同一路径的同一方法出现在多个文档中, 或同名的定义内容不同时返回错误, 列出所有冲突
+func Merge(docs ...map[string]interface{}) (map[string]interface{}, error) {
+	paths := map[string]interface{}{}
+	defs := map[string]interface{}{}
+	secs := map[string]interface{}{}
+	var tags []interface{}
+	var consumes, produces []string
+	seenTags := map[string]bool{}
+	var conflicts []string
+	for _, d := range docs {
+		title, _ := object(d["info"])["title"].(string)
+		for p, v := range object(d["paths"]) {
+			item := object(paths[p])
+			if item == nil {
+				item = map[string]interface{}{}
+				paths[p] = item
+			}
+			for m, op := range object(v) {
+				if _, ok := item[m]; ok && m != "parameters" {
+					conflicts = append(conflicts, fmt.Sprintf("%s %s defined again in %s", strings.ToUpper(m), p, title))
+					continue
+				}
+				item[m] = op
+			}
+		}
+		for _, kind := range []struct {
+			key string
+			to  map[string]interface{}
+		}{{"definitions", defs}, {"securityDefinitions", secs}} {
+			for name, v := range object(d[kind.key]) {
+				if cur, ok := kind.to[name]; ok {
+					if !reflect.DeepEqual(cur, v) {
+						conflicts = append(conflicts, fmt.Sprintf("%s %s differs in %s", kind.key, name, title))
+					}
+					continue
+				}
+				kind.to[name] = v
+			}
+		}
+		list, _ := d["tags"].([]interface{})
+		for _, t := range list {
+			if n, _ := object(t)["name"].(string); !seenTags[n] {
+				seenTags[n] = true
+				tags = append(tags, t)
+			}
+		}
+		consumes = union(consumes, stringList(d["consumes"]))
+		produces = union(produces, stringList(d["produces"]))
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("swagger: merge: %s", strings.Join(conflicts, "; "))
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		a, _ := object(tags[i])["name"].(string)
+		b, _ := object(tags[j])["name"].(string)
+		return a < b
+	})
+	out := map[string]interface{}{
+		"swagger":     "2.0",
+		"info":        map[string]interface{}{"title": "greeter API", "version": "1.0"},
+		"paths":       paths,
+		"definitions": defs,
+	}
+	if len(tags) > 0 {
+		out["tags"] = tags
+	}
+	if len(secs) > 0 {
+		out["securityDefinitions"] = secs
+	}
+	if len(consumes) > 0 {
+		out["consumes"] = consumes
+	}
+	if len(produces) > 0 {
+		out["produces"] = produces
+	}
+	return out, nil
+}
+
+func union(a, b []string) []string {
+	for _, s := range b {
+		found := false
+		for _, t := range a {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, s)
+		}
+	}
+	return a
+}
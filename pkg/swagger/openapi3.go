@@ -0,0 +1,282 @@
+package swagger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// v3Suffix 转换后的OpenAPI 3文档的后缀, 如 /swagger/blog/blog.openapi.json
+const v3Suffix = ".openapi.json"
+
+// 参数中直接移到schema的字段
+var schemaKeys = []string{"type", "format", "items", "enum", "default", "minimum", "maximum", "pattern", "minLength", "maxLength", "minItems", "maxItems", "uniqueItems"}
+
+// ToV3 把swagger 2.0文档转换为OpenAPI 3.0.3, 覆盖protoc-gen-openapiv2生成的写法:
+// body参数转为requestBody, 其他参数的类型移到schema, definitions移到components.schemas, securityDefinitions移到components.securitySchemes
+func ToV3(v2 []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(v2, &doc); err != nil {
+		return nil, fmt.Errorf("swagger: %w", err)
+	}
+	if v, _ := doc["swagger"].(string); v != "2.0" {
+		return nil, fmt.Errorf("swagger: not a swagger 2.0 document (swagger=%v)", doc["swagger"])
+	}
+	return json.MarshalIndent(convert(doc), "", "  ")
+}
+
+// convert 转换v2文档, 不修改doc
+func convert(doc map[string]interface{}) map[string]interface{} {
+	doc = clone(doc).(map[string]interface{})
+	out := map[string]interface{}{"openapi": "3.0.3"}
+	for _, k := range []string{"info", "tags", "security", "externalDocs"} {
+		if v, ok := doc[k]; ok {
+			out[k] = v
+		}
+	}
+	if host, _ := doc["host"].(string); host != "" || doc["basePath"] != nil {
+		base, _ := doc["basePath"].(string)
+		schemes := stringList(doc["schemes"])
+		if len(schemes) == 0 {
+			schemes = []string{"https"}
+		}
+		var servers []interface{}
+		for _, s := range schemes {
+			u := base
+			if host != "" {
+				u = s + "://" + host + base
+			}
+			servers = append(servers, map[string]interface{}{"url": u})
+		}
+		out["servers"] = servers
+	}
+	consumes, produces := stringList(doc["consumes"]), stringList(doc["produces"])
+	paths := map[string]interface{}{}
+	for p, v := range object(doc["paths"]) {
+		item := map[string]interface{}{}
+		for method, op := range object(v) {
+			if method == "parameters" {
+				item[method] = convertParams(op, nil)
+				continue
+			}
+			item[method] = convertOp(object(op), consumes, produces)
+		}
+		paths[p] = item
+	}
+	out["paths"] = paths
+	components := map[string]interface{}{}
+	if defs := object(doc["definitions"]); len(defs) > 0 {
+		components["schemas"] = defs
+	}
+	if sec := object(doc["securityDefinitions"]); len(sec) > 0 {
+		schemes := map[string]interface{}{}
+		for name, s := range sec {
+			schemes[name] = convertSecurity(object(s))
+		}
+		components["securitySchemes"] = schemes
+	}
+	if len(components) > 0 {
+		out["components"] = components
+	}
+	return rewriteRefs(out).(map[string]interface{})
+}
+
+func convertOp(op map[string]interface{}, consumes, produces []string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range op {
+		switch k {
+		case "parameters", "responses", "consumes", "produces", "schemes":
+		default:
+			out[k] = v
+		}
+	}
+	if c := stringList(op["consumes"]); len(c) > 0 {
+		consumes = c
+	}
+	if p := stringList(op["produces"]); len(p) > 0 {
+		produces = p
+	}
+	if len(consumes) == 0 {
+		consumes = []string{"application/json"}
+	}
+	if len(produces) == 0 {
+		produces = []string{"application/json"}
+	}
+	var body map[string]interface{}
+	if params := convertParams(op["parameters"], &body); len(params) > 0 {
+		out["parameters"] = params
+	}
+	if body != nil {
+		rb := map[string]interface{}{"content": content(consumes, body["schema"])}
+		for _, k := range []string{"description", "required"} {
+			if v, ok := body[k]; ok {
+				rb[k] = v
+			}
+		}
+		out["requestBody"] = rb
+	}
+	responses := map[string]interface{}{}
+	for code, v := range object(op["responses"]) {
+		r := object(v)
+		nr := map[string]interface{}{"description": r["description"]}
+		if nr["description"] == nil {
+			nr["description"] = ""
+		}
+		if s, ok := r["schema"]; ok {
+			nr["content"] = content(produces, s)
+		}
+		if hs := object(r["headers"]); len(hs) > 0 {
+			headers := map[string]interface{}{}
+			for name, h := range hs {
+				hm := object(h)
+				nh := map[string]interface{}{"schema": paramSchema(hm)}
+				if d, ok := hm["description"]; ok {
+					nh["description"] = d
+				}
+				headers[name] = nh
+			}
+			nr["headers"] = headers
+		}
+		responses[code] = nr
+	}
+	out["responses"] = responses
+	return out
+}
+
+// convertParams 转换参数列表, body参数放入body(为nil时丢弃)
+func convertParams(v interface{}, body *map[string]interface{}) []interface{} {
+	var out []interface{}
+	list, _ := v.([]interface{})
+	for _, p := range list {
+		pm := object(p)
+		switch pm["in"] {
+		case "body":
+			if body != nil {
+				*body = pm
+			}
+			continue
+		case "formData":
+			// protoc-gen-openapiv2不生成表单参数
+			continue
+		}
+		np := map[string]interface{}{}
+		for k, v := range pm {
+			switch k {
+			case "collectionFormat", "allowEmptyValue":
+			default:
+				np[k] = v
+			}
+		}
+		for _, k := range schemaKeys {
+			delete(np, k)
+		}
+		if _, ok := pm["$ref"]; !ok {
+			np["schema"] = paramSchema(pm)
+		}
+		if pm["type"] == "array" {
+			// multi对应 ?a=1&a=2, 其他格式以分隔符连接
+			np["explode"] = pm["collectionFormat"] == "multi"
+		}
+		out = append(out, np)
+	}
+	return out
+}
+
+func paramSchema(p map[string]interface{}) map[string]interface{} {
+	s := map[string]interface{}{}
+	for _, k := range schemaKeys {
+		if v, ok := p[k]; ok {
+			s[k] = v
+		}
+	}
+	return s
+}
+
+func content(types []string, schema interface{}) map[string]interface{} {
+	c := map[string]interface{}{}
+	for _, t := range types {
+		c[t] = map[string]interface{}{"schema": schema}
+	}
+	return c
+}
+
+func convertSecurity(s map[string]interface{}) map[string]interface{} {
+	switch s["type"] {
+	case "basic":
+		return withDescription(map[string]interface{}{"type": "http", "scheme": "basic"}, s)
+	case "oauth2":
+		flow := map[string]interface{}{"scopes": s["scopes"]}
+		if flow["scopes"] == nil {
+			flow["scopes"] = map[string]interface{}{}
+		}
+		for _, k := range []string{"authorizationUrl", "tokenUrl"} {
+			if v, ok := s[k]; ok {
+				flow[k] = v
+			}
+		}
+		name := map[interface{}]string{"implicit": "implicit", "password": "password", "application": "clientCredentials", "accessCode": "authorizationCode"}[s["flow"]]
+		return withDescription(map[string]interface{}{"type": "oauth2", "flows": map[string]interface{}{name: flow}}, s)
+	}
+	return s
+}
+
+func withDescription(out, from map[string]interface{}) map[string]interface{} {
+	if d, ok := from["description"]; ok {
+		out["description"] = d
+	}
+	return out
+}
+
+// rewriteRefs 把 #/definitions/ 引用改为 #/components/schemas/
+func rewriteRefs(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, c := range v {
+			if s, ok := c.(string); ok && k == "$ref" {
+				v[k] = strings.Replace(s, "#/definitions/", "#/components/schemas/", 1)
+				continue
+			}
+			v[k] = rewriteRefs(c)
+		}
+	case []interface{}:
+		for i, c := range v {
+			v[i] = rewriteRefs(c)
+		}
+	}
+	return v
+}
+
+// clone 深拷贝JSON解码得到的值
+func clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, c := range v {
+			m[k] = clone(c)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, c := range v {
+			l[i] = clone(c)
+		}
+		return l
+	}
+	return v
+}
+
+func object(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if s, ok := s.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+// Package swagger 提供gateway接口的swagger(OpenAPI v2)文档、转换后的OpenAPI 3文档和浏览页面.
+// 文档由protoc-gen-openapiv2生成到api目录, 与ui目录中的页面一起通过go:embed编译进程序, 不依赖运行目录下的文件;
+// 插件等额外服务的文档可以放在配置的目录中, 启动时一并发现和合并.
+package swagger
+
+//go:generate protoc -I ../../proto --openapiv2_out=api admin/admin.proto auth/auth.proto blog/blog.proto helloworld/hello_world.proto helloworld/v2/hello_world.proto user/user.proto
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Prefix 文档和页面的URL前缀
+const Prefix = "/swagger/"
+
+const suffix = ".swagger.json"
+
+// mergedName 合并后的文档名
+const mergedName = "swagger.json"
+
+//go:embed api ui
+var assets embed.FS
+
+var (
+	embeddedAPI, _ = fs.Sub(assets, "api")
+	ui, _          = fs.Sub(assets, "ui")
+)
+
+// Spec 索引中的一个文档
+type Spec struct {
+	// Name 相对所在目录的路径, 如 blog/blog.swagger.json
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// URL 相对Prefix的地址
+	URL string `json:"url"`
+	// OpenAPI 转换为OpenAPI 3后的地址, 相对Prefix
+	OpenAPI string `json:"openapi"`
+}
+
+type doc struct {
+	Spec
+	data []byte
+	v2   map[string]interface{}
+}
+
+// Registry 发现的所有文档及其合并结果, 创建后只读
+type Registry struct {
+	docs   []*doc
+	byName map[string]*doc
+	// merged, mergedV3 合并后的v2文档和转换后的v3文档
+	merged, mergedV3 []byte
+}
+
+// New 发现编译进程序的文档和dirs中(包括子目录)所有以.swagger.json结尾的文件, 合并为一个文档.
+// 不同目录中的同名文档、重复的接口(路径和方法相同)和内容不同的同名定义都会返回错误
+func New(dirs ...string) (*Registry, error) {
+	r := &Registry{byName: map[string]*doc{}}
+	if err := r.scan(embeddedAPI, "embedded"); err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		if err := r.scan(os.DirFS(d), d); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(r.docs, func(i, j int) bool { return r.docs[i].Name < r.docs[j].Name })
+	docs := make([]map[string]interface{}, len(r.docs))
+	for i, d := range r.docs {
+		docs[i] = d.v2
+	}
+	merged, err := Merge(docs...)
+	if err != nil {
+		return nil, err
+	}
+	if r.merged, err = json.MarshalIndent(merged, "", "  "); err != nil {
+		return nil, err
+	}
+	if r.mergedV3, err = json.MarshalIndent(convert(merged), "", "  "); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// scan 加载fsys中的所有文档, source用于错误信息
+func (r *Registry) scan(fsys fs.FS, source string) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("swagger: scan %s: %w", source, err)
+		}
+		if d.IsDir() || !strings.HasSuffix(p, suffix) {
+			return nil
+		}
+		if _, ok := r.byName[p]; ok {
+			return fmt.Errorf("swagger: %s in %s: duplicate document name", p, source)
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("swagger: %s in %s: %w", p, source, err)
+		}
+		var v2 map[string]interface{}
+		if err := json.Unmarshal(b, &v2); err != nil {
+			return fmt.Errorf("swagger: %s in %s: %w", p, source, err)
+		}
+		if v, _ := v2["swagger"].(string); v != "2.0" {
+			return fmt.Errorf("swagger: %s in %s: not a swagger 2.0 document", p, source)
+		}
+		title, _ := object(v2["info"])["title"].(string)
+		dd := &doc{
+			Spec: Spec{Name: p, Title: title, URL: p, OpenAPI: strings.TrimSuffix(p, suffix) + v3Suffix},
+			data: b,
+			v2:   v2,
+		}
+		r.docs = append(r.docs, dd)
+		r.byName[p] = dd
+		return nil
+	})
+}
+
+// Specs 返回所有文档的索引, 第一项为合并后的文档
+func (r *Registry) Specs() []Spec {
+	out := []Spec{{Name: mergedName, Title: "greeter API", URL: mergedName, OpenAPI: "../openapi.json"}}
+	for _, d := range r.docs {
+		out = append(out, d.Spec)
+	}
+	return out
+}
+
+// Register 在mux上注册Prefix和 /openapi.json: 页面为 /swagger/, 合并后的文档为 /swagger/swagger.json,
+// 单个文档为 /swagger/<proto路径>.swagger.json, 如 /swagger/blog/blog.swagger.json, 换成 .openapi.json 后缀时为OpenAPI 3格式;
+// /swagger/specs.json 列出所有文档; /openapi.json 为合并后的OpenAPI 3文档, 供Postman等工具导入.
+// wrap不为nil时用它包装这些接口, 如BasicAuth
+func (r *Registry) Register(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	docs, v3 := http.StripPrefix(strings.TrimSuffix(Prefix, "/"), r.Handler()), http.Handler(http.HandlerFunc(r.ServeOpenAPI))
+	if wrap != nil {
+		docs, v3 = wrap(docs), wrap(v3)
+	}
+	mux.Handle(Prefix, docs)
+	mux.Handle("/openapi.json", v3)
+}
+
+// Handler 处理去掉Prefix后的请求路径
+func (r *Registry) Handler() http.Handler {
+	files := http.FileServer(http.FS(ui))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch p := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/"); {
+		case p == "specs.json":
+			writeJSON(w, nil, r.Specs())
+		case p == mergedName:
+			writeJSON(w, r.merged, nil)
+		case strings.HasSuffix(p, suffix):
+			r.ServeSwaggerFile(w, req, p)
+		case strings.HasSuffix(p, v3Suffix):
+			r.serveV3File(w, req, p)
+		default:
+			files.ServeHTTP(w, req)
+		}
+	})
+}
+
+// ServeSwaggerFile 返回名为name的文档, name为索引中的Name, 不存在时返回404
+func (r *Registry) ServeSwaggerFile(w http.ResponseWriter, req *http.Request, name string) {
+	d, ok := r.byName[name]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	writeJSON(w, d.data, nil)
+}
+
+// ServeOpenAPI 返回合并后的OpenAPI 3文档
+func (r *Registry) ServeOpenAPI(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, r.mergedV3, nil)
+}
+
+// serveV3File 返回名为name(以.openapi.json结尾)的文档转换后的OpenAPI 3文档
+func (r *Registry) serveV3File(w http.ResponseWriter, req *http.Request, name string) {
+	d, ok := r.byName[strings.TrimSuffix(name, v3Suffix)+suffix]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	b, err := ToV3(d.data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, b, nil)
+}
+
+// writeJSON 写出已编码的b, b为nil时编码v
+func writeJSON(w http.ResponseWriter, b []byte, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if b == nil {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	w.Write(b)
+}
@@ -0,0 +1,81 @@
+// Package tags 把请求中指定的字段提取为请求标签(类似grpc_ctxtags), 日志等无需每个handler单独处理即可带上这些字段
+package tags
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// KeyPrefix 从请求字段提取的标签名前缀, 如 request.name
+const KeyPrefix = "request."
+
+// Config 请求标签配置
+type Config struct {
+	// Fields 提取的字段, 格式为 消息全名.字段名, 如 helloworld.HelloRequest.name; 只支持标量字段
+	Fields []string `json:"fields"`
+}
+
+// Extractor 提取请求标签
+type Extractor struct {
+	// fields 消息全名 -> 要提取的字段
+	fields map[protoreflect.FullName][]protoreflect.FieldDescriptor
+}
+
+// New 校验配置中的字段并创建Extractor, 消息需已注册(导入了对应的proto包)
+func New(c Config) (*Extractor, error) {
+	e := &Extractor{fields: map[protoreflect.FullName][]protoreflect.FieldDescriptor{}}
+	for _, f := range c.Fields {
+		i := strings.LastIndexByte(f, '.')
+		if i <= 0 {
+			return nil, fmt.Errorf("tags: invalid field %q, want <message>.<field>", f)
+		}
+		name := protoreflect.FullName(f[:i])
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("tags: field %q: %w", f, err)
+		}
+		fd := mt.Descriptor().Fields().ByName(protoreflect.Name(f[i+1:]))
+		if fd == nil {
+			return nil, fmt.Errorf("tags: message %s has no field %s", name, f[i+1:])
+		}
+		if fd.IsList() || fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			return nil, fmt.Errorf("tags: field %q is not a scalar", f)
+		}
+		e.fields[name] = append(e.fields[name], fd)
+	}
+	return e, nil
+}
+
+// UnaryServerInterceptor 在ctx中放入请求标签并提取配置的字段, 应作为第一个拦截器,
+// 使后续的日志等拦截器都能读到标签
+func (e *Extractor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, tags := ctxutil.WithTags(ctx)
+		if msg, ok := req.(proto.Message); ok {
+			m := msg.ProtoReflect()
+			for _, fd := range e.fields[m.Descriptor().FullName()] {
+				// 未设置(零值)的字段不提取
+				if m.Has(fd) {
+					tags.Set(KeyPrefix+string(fd.Name()), format(fd, m.Get(fd)))
+				}
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func format(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Kind() == protoreflect.EnumKind {
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,191 @@
+// Package interceptor 提供测试gRPC拦截器用的辅助工具:
+// 伪造UnaryServerInfo、构造metadata、记录span, 以及让请求依次经过任意拦截器链.
+package interceptor
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultMethod 未指定方法名时使用的gRPC方法全名
+const DefaultMethod = "/helloworld.Greeter/SayHello"
+
+// Info 构造UnaryServerInfo, fullMethod为空时使用DefaultMethod
+func Info(fullMethod string) *grpc.UnaryServerInfo {
+	if fullMethod == "" {
+		fullMethod = DefaultMethod
+	}
+	return &grpc.UnaryServerInfo{FullMethod: fullMethod}
+}
+
+// StreamInfo 构造StreamServerInfo
+func StreamInfo(fullMethod string, clientStream, serverStream bool) *grpc.StreamServerInfo {
+	if fullMethod == "" {
+		fullMethod = DefaultMethod
+	}
+	return &grpc.StreamServerInfo{FullMethod: fullMethod, IsClientStream: clientStream, IsServerStream: serverStream}
+}
+
+// MD 按key, value成对构造metadata
+func MD(kv ...string) metadata.MD {
+	return metadata.Pairs(kv...)
+}
+
+// IncomingContext 在ctx上附加incoming metadata, 与已有的metadata合并
+func IncomingContext(ctx context.Context, kv ...string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return metadata.NewIncomingContext(ctx, metadata.Join(md, MD(kv...)))
+}
+
+// Handler 可记录调用情况的假handler
+type Handler struct {
+	// Resp, Err handler的返回值
+	Resp interface{}
+	Err  error
+	// Fn 不为nil时代替Resp/Err决定返回值
+	Fn func(ctx context.Context, req interface{}) (interface{}, error)
+
+	mu    sync.Mutex
+	calls int
+	ctx   context.Context
+	req   interface{}
+}
+
+// Handle 实现grpc.UnaryHandler
+func (h *Handler) Handle(ctx context.Context, req interface{}) (interface{}, error) {
+	h.mu.Lock()
+	h.calls++
+	h.ctx, h.req = ctx, req
+	h.mu.Unlock()
+	if h.Fn != nil {
+		return h.Fn(ctx, req)
+	}
+	return h.Resp, h.Err
+}
+
+// Calls 返回handler被调用的次数
+func (h *Handler) Calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
+
+// LastContext 返回最后一次调用时handler收到的ctx, 用于检查拦截器注入的值
+func (h *Handler) LastContext() context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ctx
+}
+
+// LastRequest 返回最后一次调用时handler收到的请求
+func (h *Handler) LastRequest() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.req
+}
+
+// Chain 把多个拦截器组合为一个, 执行顺序与grpc.ChainUnaryInterceptor相同
+func Chain(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			ic, h := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ic(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// Result 一次Run的结果
+type Result struct {
+	Resp interface{}
+	Err  error
+	// Stream 记录了拦截器或handler通过grpc.SetHeader/SetTrailer设置的metadata
+	Stream *Stream
+}
+
+// Run 让请求依次经过拦截器链后交给handler处理.
+// info为nil时使用Info(""); ctx中会注入Stream, 使grpc.SetHeader等调用可用.
+func Run(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler, interceptors ...grpc.UnaryServerInterceptor) *Result {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if info == nil {
+		info = Info("")
+	}
+	if handler == nil {
+		handler = (&Handler{}).Handle
+	}
+	st := &Stream{method: info.FullMethod}
+	ctx = grpc.NewContextWithServerTransportStream(ctx, st)
+	resp, err := Chain(interceptors...)(ctx, req, info, handler)
+	return &Result{Resp: resp, Err: err, Stream: st}
+}
+
+// Stream 实现grpc.ServerTransportStream, 记录设置的header和trailer
+type Stream struct {
+	method string
+
+	mu      sync.Mutex
+	header  metadata.MD
+	trailer metadata.MD
+	sent    bool
+}
+
+// NewStream 创建Stream
+func NewStream(fullMethod string) *Stream {
+	return &Stream{method: fullMethod}
+}
+
+func (s *Stream) Method() string { return s.method }
+
+func (s *Stream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *Stream) SendHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	s.sent = true
+	return nil
+}
+
+func (s *Stream) SetTrailer(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// Header 返回已设置的header
+func (s *Stream) Header() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.header.Copy()
+}
+
+// Trailer 返回已设置的trailer
+func (s *Stream) Trailer() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trailer.Copy()
+}
+
+// HeaderSent 是否调用过SendHeader
+func (s *Stream) HeaderSent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
@@ -0,0 +1,108 @@
+package interceptor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Span 记录下来的一个span
+type Span struct {
+	Name       string
+	Attributes map[string]interface{}
+	Err        error
+	Start      time.Time
+	End        time.Time
+	// Parent 父span的名字, 没有父span时为空
+	Parent string
+}
+
+// Ended span是否已结束
+func (s *Span) Ended() bool { return !s.End.IsZero() }
+
+// SpanRecorder 在内存中记录span, 供测试检查拦截器的埋点
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+type spanKey struct{}
+
+// Start 开始一个span, 返回的ctx中带有该span, 作为后续span的父span
+func (r *SpanRecorder) Start(ctx context.Context, name string) (context.Context, *RecordingSpan) {
+	s := &Span{Name: name, Attributes: map[string]interface{}{}, Start: time.Now()}
+	if p, ok := ctx.Value(spanKey{}).(*RecordingSpan); ok {
+		s.Parent = p.span.Name
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	rs := &RecordingSpan{r: r, span: s}
+	return context.WithValue(ctx, spanKey{}, rs), rs
+}
+
+// Spans 返回所有记录的span的副本, 按开始顺序排列
+func (r *SpanRecorder) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Span, 0, len(r.spans))
+	for _, s := range r.spans {
+		cp := *s
+		cp.Attributes = make(map[string]interface{}, len(s.Attributes))
+		for k, v := range s.Attributes {
+			cp.Attributes[k] = v
+		}
+		out = append(out, cp)
+	}
+	return out
+}
+
+// Find 按名字查找第一个span
+func (r *SpanRecorder) Find(name string) (Span, bool) {
+	for _, s := range r.Spans() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Span{}, false
+}
+
+// Reset 清空已记录的span
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+// RecordingSpan 正在记录的span
+type RecordingSpan struct {
+	r    *SpanRecorder
+	span *Span
+}
+
+// SetAttribute 设置span属性
+func (s *RecordingSpan) SetAttribute(key string, v interface{}) {
+	s.r.mu.Lock()
+	s.span.Attributes[key] = v
+	s.r.mu.Unlock()
+}
+
+// RecordError 记录错误
+func (s *RecordingSpan) RecordError(err error) {
+	s.r.mu.Lock()
+	s.span.Err = err
+	s.r.mu.Unlock()
+}
+
+// End 结束span
+func (s *RecordingSpan) End() {
+	s.r.mu.Lock()
+	s.span.End = time.Now()
+	s.r.mu.Unlock()
+}
+
+// SpanFromContext 取出ctx中当前的span
+func SpanFromContext(ctx context.Context) (*RecordingSpan, bool) {
+	s, ok := ctx.Value(spanKey{}).(*RecordingSpan)
+	return s, ok
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// BatchExporter 批量发送span的目标, 如OTLP
+type BatchExporter interface {
+	ExportBatch(ctx context.Context, spans []*SpanData) error
+}
+
+// BufferConfig 导出缓冲配置
+type BufferConfig struct {
+	// Size 缓冲的span上限, 超出时丢弃新的span, 默认2048
+	Size int `json:"size"`
+	// BatchSize 每批发送的span数量上限, 默认256
+	BatchSize int `json:"batch_size"`
+	// FlushInterval 不满一批时的发送间隔, 如 "1s"
+	FlushInterval string `json:"flush_interval"`
+	// MaxRetries 一批发送失败后的重试次数, 仍失败时丢弃这一批, 默认3
+	MaxRetries int `json:"max_retries"`
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍, 如 "500ms"
+	Backoff string `json:"backoff"`
+	// FallbackToLog 丢弃span时改为写debug日志, 导出目标不可用时仍可在本地查看
+	FallbackToLog bool `json:"fallback_to_log"`
+}
+
+var (
+	spansExported = metrics.NewCounterVec("tracing_spans_exported_total",
+		"Number of spans sent to the trace backend.")
+	spansDropped = metrics.NewCounterVec("tracing_spans_dropped_total",
+		"Number of spans dropped by reason (buffer_full or export_failed).", "reason")
+	exportRetries = metrics.NewCounterVec("tracing_export_retries_total",
+		"Number of retried span batch exports.")
+	bufferedSpans = metrics.NewGaugeVec("tracing_buffered_spans",
+		"Number of spans waiting in the export buffer.")
+)
+
+// Buffered 把span放入有界缓冲, 由Run批量发送到BatchExporter. Export不阻塞, 缓冲满时丢弃
+type Buffered struct {
+	next       BatchExporter
+	fallback   Exporter
+	ch         chan *SpanData
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	backoff    time.Duration
+}
+
+// NewBuffered 创建缓冲导出器, fallback不为nil时接收被丢弃的span
+func NewBuffered(next BatchExporter, c BufferConfig, fallback Exporter) (*Buffered, error) {
+	b := &Buffered{next: next, fallback: fallback, batchSize: c.BatchSize, maxRetries: c.MaxRetries,
+		interval: time.Second, backoff: 500 * time.Millisecond}
+	if c.Size <= 0 {
+		c.Size = 2048
+	}
+	if b.batchSize <= 0 {
+		b.batchSize = 256
+	}
+	if c.MaxRetries < 0 {
+		return nil, errors.New("tracing: buffer.max_retries must not be negative")
+	}
+	if c.MaxRetries == 0 {
+		b.maxRetries = 3
+	}
+	if c.FlushInterval != "" {
+		d, err := time.ParseDuration(c.FlushInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("tracing: invalid buffer.flush_interval %q", c.FlushInterval)
+		}
+		b.interval = d
+	}
+	if c.Backoff != "" {
+		d, err := time.ParseDuration(c.Backoff)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: buffer.backoff: %w", err)
+		}
+		b.backoff = d
+	}
+	b.ch = make(chan *SpanData, c.Size)
+	return b, nil
+}
+
+func (b *Buffered) Export(s *SpanData) {
+	select {
+	case b.ch <- s:
+		bufferedSpans.WithLabelValues().Set(float64(len(b.ch)))
+	default:
+		b.drop("buffer_full", s)
+	}
+}
+
+func (b *Buffered) drop(reason string, spans ...*SpanData) {
+	spansDropped.WithLabelValues(reason).Add(float64(len(spans)))
+	if b.fallback != nil {
+		for _, s := range spans {
+			b.fallback.Export(s)
+		}
+	}
+}
+
+// Run 批量发送缓冲中的span直到ctx取消, 取消后尽量发送剩余的span
+func (b *Buffered) Run(ctx context.Context) {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	batch := make([]*SpanData, 0, b.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			for len(b.ch) > 0 && len(batch) < b.batchSize {
+				batch = append(batch, <-b.ch)
+			}
+			if len(batch) > 0 {
+				b.send(context.Background(), batch, 0)
+			}
+			return
+		case s := <-b.ch:
+			batch = append(batch, s)
+			if len(batch) < b.batchSize {
+				continue
+			}
+		case <-t.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		bufferedSpans.WithLabelValues().Set(float64(len(b.ch)))
+		b.send(ctx, batch, b.maxRetries)
+		batch = make([]*SpanData, 0, b.batchSize)
+	}
+}
+
+// send 发送一批span, 失败时按指数退避重试retries次. 重试期间新的span在缓冲中等待
+func (b *Buffered) send(ctx context.Context, batch []*SpanData, retries int) {
+	wait := b.backoff
+	for attempt := 0; ; attempt++ {
+		err := b.next.ExportBatch(ctx, batch)
+		if err == nil {
+			spansExported.WithLabelValues().Add(float64(len(batch)))
+			return
+		}
+		if attempt >= retries {
+			b.drop("export_failed", batch...)
+			return
+		}
+		exportRetries.WithLabelValues().Inc()
+		select {
+		case <-ctx.Done():
+			b.drop("export_failed", batch...)
+			return
+		case <-time.After(wait):
+		}
+		if wait < 30*time.Second {
+			wait *= 2
+		}
+	}
+}
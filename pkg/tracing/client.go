@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var clientDuration = metrics.NewHistogramVec("grpc_client_handling_seconds",
+	"Latency of outbound gRPC calls, with trace_id exemplars in OpenMetrics format.", nil, "method", "code")
+
+// UnaryClientInterceptor 为每次调用记录一个client span, 并通过traceparent把trace传给服务端,
+// 服务端的span以它为父span. 重试时所有请求属于同一个span
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, s := clientSpan(ctx, method, cc)
+		defer s.End()
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		code := status.Code(err).String()
+		clientDuration.WithLabelValues(method, code).ObserveContext(ctx, time.Since(start).Seconds())
+		s.SetAttribute("rpc.code", code)
+		s.RecordError(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor 为每个流记录一个client span, 在RecvMsg返回错误(包括io.EOF)时结束;
+// 没有读到流结束就丢弃的流不导出span
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, s := clientSpan(ctx, method, cc)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			s.SetAttribute("rpc.code", status.Code(err).String())
+			s.RecordError(err)
+			s.End()
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, span: s, method: method, start: time.Now(), ctx: ctx}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	span   *Span
+	method string
+	start  time.Time
+	ctx    context.Context
+	once   sync.Once
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			end := err
+			if end == io.EOF {
+				end = nil
+			}
+			code := status.Code(end).String()
+			clientDuration.WithLabelValues(s.method, code).ObserveContext(s.ctx, time.Since(s.start).Seconds())
+			s.span.SetAttribute("rpc.code", code)
+			s.span.RecordError(end)
+			s.span.End()
+		})
+	}
+	return err
+}
+
+// clientSpan 开始名为方法全名的子span, 并把它的ID作为traceparent放入outgoing metadata
+func clientSpan(ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, *Span) {
+	ctx, s := Start(ctx, method)
+	s.SetAttribute("rpc.system", "grpc")
+	s.SetAttribute("rpc.target", cc.Target())
+	flags := "00"
+	if sampled, _ := ctxutil.Sampled(ctx); sampled {
+		flags = "01"
+	}
+	sc := s.SpanContext()
+	ctx = metadata.AppendToOutgoingContext(ctx, ctxutil.TraceParentHeader, "00-"+sc.TraceID+"-"+sc.SpanID+"-"+flags)
+	return ctx, s
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import "github.com/Q1mi/greeter/pkg/zaplog"
+
+type logExporter struct {
+	l *zaplog.Logger
+	// fallback 为true时以info级别记录未能导出的span
+	fallback bool
+}
+
+// NewLogExporter 把每个span写为一条debug日志, 用于本地开发时查看span
+func NewLogExporter(l *zaplog.Logger) Exporter {
+	return logExporter{l: l}
+}
+
+// NewFallbackLogExporter 把未能发送到trace后端的span写为info日志, 后端不可用时仍可在本地日志中查看
+func NewFallbackLogExporter(l *zaplog.Logger) Exporter {
+	return logExporter{l: l, fallback: true}
+}
+
+func (e logExporter) Export(s *SpanData) {
+	level := zaplog.DebugLevel
+	if e.fallback {
+		level = zaplog.InfoLevel
+	}
+	if !e.l.Enabled(level) {
+		return
+	}
+	fields := []zaplog.Field{
+		zaplog.String("span", s.Name),
+		zaplog.String("trace_id", s.TraceID),
+		zaplog.String("span_id", s.SpanID),
+		zaplog.Duration("duration", s.End.Sub(s.Start)),
+	}
+	if s.ParentSpanID != "" {
+		fields = append(fields, zaplog.String("parent_span_id", s.ParentSpanID))
+	}
+	if len(s.Attributes) > 0 {
+		fields = append(fields, zaplog.Any("attributes", s.Attributes))
+	}
+	if len(s.Links) > 0 {
+		fields = append(fields, zaplog.Any("links", s.Links))
+	}
+	if s.Error != "" {
+		fields = append(fields, zaplog.String("error", s.Error))
+	}
+	if e.fallback {
+		e.l.Info("span not exported", fields...)
+		return
+	}
+	e.l.Debug("span finished", fields...)
+}
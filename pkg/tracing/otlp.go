@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OTLPConfig OTLP/HTTP导出配置, Jaeger collector等支持OTLP的后端都可以接收
+type OTLPConfig struct {
+	// Endpoint 接收span的地址, 如 http://127.0.0.1:4318/v1/traces
+	Endpoint string `json:"endpoint"`
+	// ServiceName 上报的service.name, 默认greeter
+	ServiceName string `json:"service_name"`
+	// Headers 附加的请求头, 如鉴权token
+	Headers map[string]string `json:"headers"`
+	// Timeout 单次发送超时, 如 "5s"
+	Timeout string `json:"timeout"`
+}
+
+// Probe 检查能否与Endpoint建立TCP连接, 不发送数据
+func (c OTLPConfig) Probe(ctx context.Context) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// OTLP 以OTLP/HTTP JSON格式批量发送span
+type OTLP struct {
+	c OTLPConfig
+	// client 不能使用httpclient, 否则发送span本身又会产生span
+	client *http.Client
+}
+
+// NewOTLP 创建OTLP导出器
+func NewOTLP(c OTLPConfig) (*OTLP, error) {
+	if c.Endpoint == "" {
+		return nil, errors.New("tracing: otlp.endpoint is required")
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = "greeter"
+	}
+	timeout := 5 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: otlp.timeout: %w", err)
+		}
+		timeout = d
+	}
+	return &OTLP{c: c, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// ExportBatch 发送一批span, 非2xx响应视为失败
+func (o *OTLP) ExportBatch(ctx context.Context, spans []*SpanData) error {
+	body, err := json.Marshal(o.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.c.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.c.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("tracing: otlp endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// 以下为OTLP JSON编码中用到的结构, trace ID和span ID使用十六进制字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpStatusError OTLP中表示错误的状态码
+const otlpStatusError = 2
+
+// otlpKindInternal 未区分类型的span按内部span上报
+const otlpKindInternal = 1
+
+func (o *OTLP) request(spans []*SpanData) *otlpRequest {
+	ss := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	ss.Scope.Name = "github.com/Q1mi/greeter/pkg/tracing"
+	for _, s := range spans {
+		os := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		for k, v := range s.Attributes {
+			os.Attributes = append(os.Attributes, otlpKeyValue{Key: k, Value: otlpValue(v)})
+		}
+		for _, l := range s.Links {
+			os.Links = append(os.Links, otlpLink{TraceID: l.TraceID, SpanID: l.SpanID})
+		}
+		if s.Error != "" {
+			os.Status = &otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		ss.Spans = append(ss.Spans, os)
+	}
+	service := otlpKeyValue{Key: "service.name", Value: otlpValue(o.c.ServiceName)}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{service}},
+		ScopeSpans: []otlpScopeSpans{ss},
+	}}}
+}
+
+// otlpValue 把属性值编码为OTLP AnyValue, 整数按规范编码为字符串
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
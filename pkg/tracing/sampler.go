@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 采样方式, 与Jaeger客户端的sampler类型一致
+const (
+	// SamplerConst Param为1时全部采样, 为0时都不采样
+	SamplerConst = "const"
+	// SamplerProbabilistic 按trace ID以Param的比例采样, 各实例对同一trace的结果一致
+	SamplerProbabilistic = "probabilistic"
+	// SamplerRateLimiting 每秒最多采样Param个trace
+	SamplerRateLimiting = "ratelimiting"
+)
+
+// SamplerConfig 采样配置, 未被采样的trace只在ctx和日志中传递ID, 不导出span
+type SamplerConfig struct {
+	// Type const、probabilistic或ratelimiting, 默认const
+	Type  string  `json:"type"`
+	Param float64 `json:"param"`
+}
+
+// Sampler 决定一个trace是否导出, 在trace的第一个span开始时调用, 子span沿用父span的结果
+type Sampler interface {
+	Sample(traceID string) bool
+}
+
+// NewSampler 按配置创建Sampler
+func NewSampler(c SamplerConfig) (Sampler, error) {
+	switch c.Type {
+	case "", SamplerConst:
+		if c.Param != 0 && c.Param != 1 {
+			return nil, fmt.Errorf("tracing: const sampler param must be 0 or 1, got %v", c.Param)
+		}
+		return constSampler(c.Param == 1), nil
+	case SamplerProbabilistic:
+		if c.Param < 0 || c.Param > 1 {
+			return nil, fmt.Errorf("tracing: probabilistic sampler param must be in [0, 1], got %v", c.Param)
+		}
+		return probabilisticSampler(c.Param), nil
+	case SamplerRateLimiting:
+		if c.Param <= 0 {
+			return nil, fmt.Errorf("tracing: ratelimiting sampler param must be positive, got %v", c.Param)
+		}
+		// 允许的突发为1秒的量, 至少1个
+		burst := math.Max(c.Param, 1)
+		return &rateLimitingSampler{rate: c.Param, burst: burst, tokens: burst, last: time.Now()}, nil
+	}
+	return nil, fmt.Errorf("tracing: unknown sampler type %q", c.Type)
+}
+
+type constSampler bool
+
+func (s constSampler) Sample(string) bool { return bool(s) }
+
+type probabilisticSampler float64
+
+// Sample 取trace ID的低64位与比例比较, 与Jaeger的probabilistic sampler一致
+func (s probabilisticSampler) Sample(traceID string) bool {
+	if s >= 1 {
+		return true
+	}
+	if len(traceID) < 16 {
+		return false
+	}
+	v, err := strconv.ParseUint(traceID[len(traceID)-16:], 16, 64)
+	if err != nil {
+		return false
+	}
+	return float64(v) < float64(s)*math.MaxUint64
+}
+
+// rateLimitingSampler 令牌桶
+type rateLimitingSampler struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (s *rateLimitingSampler) Sample(string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.tokens = math.Min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
@@ -0,0 +1,348 @@
+// Package tracing 记录span并交给Exporter导出, 用于在trace瀑布图中查看一次请求内部各步骤的耗时.
+// span的trace ID和span ID与zaplog日志中的trace_id、span_id一致, 日志和trace可以互相关联.
+// 没有设置Exporter时span不导出, 只在ctx中传递ID.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"github.com/Q1mi/greeter/pkg/zaplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 导出方式
+const (
+	// ExporterNone 不导出span
+	ExporterNone = ""
+	// ExporterLog 每个span结束时写一条debug日志
+	ExporterLog = "log"
+	// ExporterOTLP 经缓冲批量发送到OTLP/HTTP后端
+	ExporterOTLP = "otlp"
+)
+
+// Config trace配置
+type Config struct {
+	// Enabled 是否导出span, 为false时不论Exporter为何都不导出
+	Enabled bool `json:"enabled"`
+	// Exporter 导出方式: 空(不导出)、log或otlp
+	Exporter string `json:"exporter"`
+	// Sampler 采样配置, 默认全部采样
+	Sampler SamplerConfig `json:"sampler"`
+	// OTLP otlp导出的目标, Jaeger等后端的collector地址和上报的服务名
+	OTLP OTLPConfig `json:"otlp"`
+	// Buffer otlp导出的缓冲和重试配置
+	Buffer BufferConfig `json:"buffer"`
+}
+
+// Setup 按配置设置全局Exporter和Sampler, otlp导出时在后台启动发送循环
+func Setup(c Config, l *zaplog.Logger) error {
+	sampler, err := NewSampler(c.Sampler)
+	if err != nil {
+		return err
+	}
+	SetSampler(sampler)
+	if !c.Enabled {
+		SetExporter(nil)
+		return nil
+	}
+	switch c.Exporter {
+	case ExporterNone:
+		SetExporter(nil)
+	case ExporterLog:
+		SetExporter(NewLogExporter(l))
+	case ExporterOTLP:
+		o, err := NewOTLP(c.OTLP)
+		if err != nil {
+			return err
+		}
+		var fallback Exporter
+		if c.Buffer.FallbackToLog {
+			fallback = NewFallbackLogExporter(l)
+		}
+		b, err := NewBuffered(o, c.Buffer, fallback)
+		if err != nil {
+			return err
+		}
+		go b.Run(context.Background())
+		SetExporter(b)
+	default:
+		return fmt.Errorf("tracing: unknown exporter %q", c.Exporter)
+	}
+	return nil
+}
+
+// SpanContext 标识一个span, 用于父子关系和link
+type SpanContext struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+// IsValid trace ID和span ID是否都已设置
+func (sc SpanContext) IsValid() bool { return sc.TraceID != "" && sc.SpanID != "" }
+
+// SpanData 已结束的span, 交给Exporter导出
+type SpanData struct {
+	SpanContext
+	Name         string                 `json:"name"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	// Links 相关但不是父子关系的span, 如填充了本次读到的缓存的请求
+	Links []SpanContext `json:"links,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// Exporter 导出已结束的span, 必须并发安全且不阻塞调用方
+type Exporter interface {
+	Export(s *SpanData)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+	sampler    Sampler = constSampler(true)
+)
+
+// SetExporter 设置全局Exporter, 为nil时不导出
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	exporter = e
+	exporterMu.Unlock()
+}
+
+func currentExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+// SetSampler 设置全局Sampler, 为nil时全部采样
+func SetSampler(s Sampler) {
+	if s == nil {
+		s = constSampler(true)
+	}
+	exporterMu.Lock()
+	sampler = s
+	exporterMu.Unlock()
+}
+
+func sample(traceID string) bool {
+	exporterMu.RLock()
+	s := sampler
+	exporterMu.RUnlock()
+	return s.Sample(traceID)
+}
+
+// serverDuration 由span所在的拦截器记录, exemplar中的trace_id与导出的span一致
+var serverDuration = metrics.NewHistogramVec("grpc_server_handling_seconds",
+	"Latency of gRPC requests handled by the server, with trace_id exemplars in OpenMetrics format.", nil, "method", "code")
+
+type spanKey struct{}
+
+// Span 进行中的span. 方法都可以在nil上调用, 因此不需要检查FromContext的返回值
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+	// sampled 结束时是否导出
+	sampled bool
+}
+
+// Start 开始ctx中当前span的子span, ctx中没有trace时开始新的trace.
+// 返回的ctx中trace ID和span ID为新span的ID
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	traceID, parentID := ctxutil.Trace(ctx)
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	s := newSpan(FromContext(ctx), name, SpanContext{TraceID: traceID, SpanID: randomHex(8)}, parentID)
+	ctx = ctxutil.WithSampled(ctxutil.WithTrace(ctx, traceID, s.data.SpanID), s.sampled)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// newSpan 创建span, 与parent属于同一trace时沿用它的采样结果, 否则由Sampler决定
+func newSpan(parent *Span, name string, sc SpanContext, parentID string) *Span {
+	s := &Span{data: SpanData{SpanContext: sc, Name: name, ParentSpanID: parentID, Start: time.Now()}}
+	if parent != nil && parent.data.TraceID == sc.TraceID {
+		s.sampled = parent.sampled
+	} else {
+		s.sampled = sample(sc.TraceID)
+	}
+	return s
+}
+
+// FromContext 返回ctx中当前的span, 没有时返回nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContext 返回span的ID
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute 设置属性
+func (s *Span) SetAttribute(key string, v interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = v
+}
+
+// AddLink 添加link, 无效的SpanContext被忽略
+func (s *Span) AddLink(sc SpanContext) {
+	if s == nil || !sc.IsValid() {
+		return
+	}
+	s.mu.Lock()
+	s.data.Links = append(s.data.Links, sc)
+	s.mu.Unlock()
+}
+
+// RecordError 记录错误, err为nil时忽略
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束span并导出, 重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+	if e := currentExporter(); e != nil && s.sampled {
+		e.Export(&d)
+	}
+}
+
+// UnaryServerInterceptor 为每个请求记录一个span, 名字为方法全名, 并以trace ID为exemplar记录请求耗时.
+// span使用zaplog.UnaryServerInterceptor确定的trace ID和span ID, 父span取自traceparent, 因此放在它之后
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, s := serverSpan(ctx, info.FullMethod)
+		defer s.End()
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		code := status.Code(err).String()
+		serverDuration.WithLabelValues(info.FullMethod, code).ObserveContext(ctx, time.Since(start).Seconds())
+		s.SetAttribute("rpc.code", code)
+		s.RecordError(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 为每个流记录一个span, 从开始到handler返回, 属性中有收发的消息数. 与UnaryServerInterceptor一样放在zaplog之后
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, s := serverSpan(ss.Context(), info.FullMethod)
+		defer s.End()
+		start := time.Now()
+		cs := &countingStream{ServerStream: ctxutil.WrapServerStream(ss, ctx)}
+		err := handler(srv, cs)
+		code := status.Code(err).String()
+		serverDuration.WithLabelValues(info.FullMethod, code).ObserveContext(ctx, time.Since(start).Seconds())
+		s.SetAttribute("rpc.code", code)
+		s.SetAttribute("rpc.messages_received", cs.received)
+		s.SetAttribute("rpc.messages_sent", cs.sent)
+		s.RecordError(err)
+		return err
+	}
+}
+
+// countingStream 统计流上成功收发的消息数. gRPC不允许并发RecvMsg或并发SendMsg, 各自的计数不需要加锁
+type countingStream struct {
+	grpc.ServerStream
+	received, sent int64
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+	}
+	return err
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+	}
+	return err
+}
+
+// serverSpan 开始服务端span, 使用zaplog确定的trace ID和span ID; ctx中没有trace时开始新的trace
+func serverSpan(ctx context.Context, method string) (context.Context, *Span) {
+	traceID, spanID := ctxutil.Trace(ctx)
+	if traceID == "" {
+		return Start(ctx, method)
+	}
+	s := newSpan(FromContext(ctx), method, SpanContext{TraceID: traceID, SpanID: spanID}, parentSpanID(ctx))
+	// 请求级Logger在采样之前创建, 在这里补上采样结果
+	ctx = zaplog.With(ctx, zaplog.Bool("sampled", s.sampled))
+	ctx = ctxutil.WithSampled(ctx, s.sampled)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// parentSpanID 从traceparent中取父span ID
+func parentSpanID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vs := md.Get(ctxutil.TraceParentHeader)
+	if len(vs) == 0 {
+		return ""
+	}
+	sc, _ := ParseTraceParent(vs[0])
+	return sc.SpanID
+}
+
+// ParseTraceParent 解析traceparent, 格式: version-traceid-spanid-flags. 用于从消息头等非gRPC来源恢复trace
+func ParseTraceParent(v string) (SpanContext, bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return SpanContext{}, false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return SpanContext{}, false
+	}
+	return SpanContext{TraceID: strings.ToLower(parts[1]), SpanID: strings.ToLower(parts[2])}, true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
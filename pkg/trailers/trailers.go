@@ -0,0 +1,59 @@
+// Package trailers 把gRPC响应中指定的trailer转换为普通的HTTP响应头.
+// gateway默认只在客户端声明 TE: trailers 时以 Grpc-Trailer-* 的形式返回trailer, 多数HTTP客户端看不到.
+package trailers
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+)
+
+// ServeMuxOptions 返回在成功和错误响应中设置映射头的gateway选项.
+// mapping为trailer key到HTTP头名称的映射, 头名称为空时使用trailer key本身; 设置响应头后由next写出错误, 为nil时使用gateway默认的错误处理
+func ServeMuxOptions(mapping map[string]string, next runtime.ErrorHandlerFunc) []runtime.ServeMuxOption {
+	if len(mapping) == 0 {
+		return nil
+	}
+	if next == nil {
+		next = runtime.DefaultHTTPErrorHandler
+	}
+	m := make(map[string]string, len(mapping))
+	for k, h := range mapping {
+		if h == "" {
+			h = k
+		}
+		m[strings.ToLower(k)] = textproto.CanonicalMIMEHeaderKey(h)
+	}
+	return []runtime.ServeMuxOption{
+		runtime.WithForwardResponseOption(func(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+			promote(ctx, w, m)
+			return nil
+		}),
+		runtime.WithErrorHandler(func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+			promote(ctx, w, m)
+			next(ctx, mux, marshaler, w, r, err)
+		}),
+	}
+}
+
+// promote 在写出响应前把映射的trailer设置为响应头
+func promote(ctx context.Context, w http.ResponseWriter, m map[string]string) {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+	for k, vs := range md.TrailerMD {
+		h, ok := m[k]
+		if !ok {
+			continue
+		}
+		w.Header().Del(h)
+		for _, v := range vs {
+			w.Header().Add(h, v)
+		}
+	}
+}
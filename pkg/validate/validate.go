@@ -0,0 +1,129 @@
+// Package validate 按消息类型或方法校验请求, 校验规则由服务包在init中通过Register、RegisterMethod注册.
+// 拦截器对一元调用校验请求, 对流式调用校验每一条收到的消息, 未注册规则的消息不校验.
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/metrics"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Func 校验一条消息, 返回的错误应为*errs.Error或由Field包装的*errs.Error, 以便按语言替换消息
+type Func func(m proto.Message) error
+
+var (
+	mu    sync.RWMutex
+	rules = map[protoreflect.FullName]Func{}
+	// methodRules 只对某个方法收到的消息生效, 优先于按类型的规则
+	methodRules = map[string]Func{}
+)
+
+var failuresTotal = metrics.NewCounterVec("validation_failures_total",
+	"Number of messages rejected by validation rules by method.", "method")
+
+// Register 注册m所属消息类型的校验规则, 重复注册时panic
+func Register(m proto.Message, f Func) {
+	name := m.ProtoReflect().Descriptor().FullName()
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := rules[name]; ok {
+		panic(fmt.Sprintf("validate: rule for %s registered twice", name))
+	}
+	rules[name] = f
+}
+
+// RegisterMethod 注册方法(全名, 如 /helloworld.Greeter/UploadGreetings)收到的消息的校验规则, 代替该消息类型的规则.
+// 用于同一消息类型在不同方法中要求不同的情况, 重复注册时panic
+func RegisterMethod(method string, f Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := methodRules[method]; ok {
+		panic(fmt.Sprintf("validate: rule for %s registered twice", method))
+	}
+	methodRules[method] = f
+}
+
+// fieldError 某个字段不合法
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string { return e.err.Error() }
+func (e *fieldError) Unwrap() error { return e.err }
+
+// Field 标记err由字段field引起, 返回的状态中带有BadRequest
+func Field(field string, err error) error {
+	return &fieldError{field: field, err: err}
+}
+
+// Check 按注册的规则校验method收到的m, 不合法时返回InvalidArgument状态; m不是proto消息或没有规则时返回nil
+func Check(method string, m interface{}) error {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+	mu.RLock()
+	f, ok := methodRules[method]
+	if !ok {
+		f = rules[pm.ProtoReflect().Descriptor().FullName()]
+	}
+	mu.RUnlock()
+	if f == nil {
+		return nil
+	}
+	err := f(pm)
+	if err == nil {
+		return nil
+	}
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		return errs.StatusWithDetails(codes.InvalidArgument, err, &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: fe.field, Description: err.Error()}},
+		})
+	}
+	return errs.Status(codes.InvalidArgument, err)
+}
+
+// UnaryServerInterceptor 校验请求消息, 不合法时不调用handler
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := Check(info.FullMethod, req); err != nil {
+			failuresTotal.WithLabelValues(info.FullMethod).Inc()
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 校验流上收到的每一条消息, 不合法时RecvMsg返回错误, handler应原样返回以结束流
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &stream{ServerStream: ss, method: info.FullMethod})
+	}
+}
+
+type stream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *stream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := Check(s.method, m); err != nil {
+		failuresTotal.WithLabelValues(s.method).Inc()
+		return err
+	}
+	return nil
+}
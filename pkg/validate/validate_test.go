@@ -0,0 +1,91 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Q1mi/greeter/pkg/errs"
+	"github.com/Q1mi/greeter/pkg/testing/interceptor"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var (
+	errEmpty   = errs.New("test.empty", "value is required")
+	errTooLong = errs.New("test.too_long", "value is too long")
+)
+
+const strictMethod = "/test.Service/Strict"
+
+func init() {
+	Register(&wrapperspb.StringValue{}, func(m proto.Message) error {
+		if m.(*wrapperspb.StringValue).Value == "" {
+			return Field("value", errEmpty)
+		}
+		return nil
+	})
+	// strictMethod收到的StringValue使用更严格的规则, 代替按类型的规则
+	RegisterMethod(strictMethod, func(m proto.Message) error {
+		if len(m.(*wrapperspb.StringValue).Value) > 3 {
+			return errTooLong
+		}
+		return nil
+	})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		req    interface{}
+		err    error
+		// field BadRequest中的字段, 为空时没有BadRequest
+		field string
+	}{
+		{"valid", "", wrapperspb.String("q1mi"), nil, ""},
+		{"field error", "", wrapperspb.String(""), errEmpty, "value"},
+		{"method rule", strictMethod, wrapperspb.String("q1mi"), errTooLong, ""},
+		// 方法规则代替类型规则, 空字符串在strictMethod中合法
+		{"method rule replaces type rule", strictMethod, wrapperspb.String(""), nil, ""},
+		{"no rule", "", wrapperspb.Int64(-1), nil, ""},
+		{"not a proto message", "", "plain string", nil, ""},
+	}
+	for _, tt := range tests {
+		h := &interceptor.Handler{Resp: "ok"}
+		res := interceptor.Run(context.Background(), tt.req, interceptor.Info(tt.method), h.Handle, UnaryServerInterceptor())
+		if tt.err == nil {
+			if res.Err != nil || h.Calls() != 1 || h.LastRequest() != tt.req {
+				t.Errorf("%s: err %v, handler called %d times", tt.name, res.Err, h.Calls())
+			}
+			continue
+		}
+		if status.Code(res.Err) != codes.InvalidArgument || !errors.Is(res.Err, tt.err) {
+			t.Errorf("%s: err %v, want InvalidArgument %v", tt.name, res.Err, tt.err)
+		}
+		if h.Calls() != 0 {
+			t.Errorf("%s: handler called with an invalid request", tt.name)
+		}
+		var field string
+		for _, d := range status.Convert(res.Err).Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok && len(br.FieldViolations) == 1 {
+				field = br.FieldViolations[0].Field
+			}
+		}
+		if field != tt.field {
+			t.Errorf("%s: BadRequest field %q, want %q", tt.name, field, tt.field)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a second rule for StringValue did not panic")
+		}
+	}()
+	Register(&wrapperspb.StringValue{}, func(proto.Message) error { return nil })
+}
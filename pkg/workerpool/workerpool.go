@@ -0,0 +1,107 @@
+// Package workerpool 固定数量goroutine的任务池, 用于发送通知等异步任务
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	// ErrQueueFull 任务队列已满
+	ErrQueueFull = errors.New("workerpool: queue is full")
+	// ErrClosed 任务池已关闭
+	ErrClosed = errors.New("workerpool: closed")
+)
+
+// Task 任务, ctx在任务池关闭时被取消
+type Task func(ctx context.Context)
+
+// Pool 任务池
+type Pool struct {
+	tasks  chan Task
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New 创建任务池, workers为goroutine数量, queueSize为等待队列长度
+func New(workers, queueSize int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{tasks: make(chan Task, queueSize), ctx: ctx, cancel: cancel}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		p.run(t)
+	}
+}
+
+func (p *Pool) run(t Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("workerpool: task panic: %v", r)
+		}
+	}()
+	t(p.ctx)
+}
+
+// Submit 提交任务, 队列满时返回ErrQueueFull而不是阻塞
+func (p *Pool) Submit(t Task) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	select {
+	case p.tasks <- t:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Queued 返回等待执行的任务数
+func (p *Pool) Queued() int {
+	return len(p.tasks)
+}
+
+// Shutdown 停止接收新任务并等待已提交的任务执行完; ctx结束时取消正在执行的任务并返回
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
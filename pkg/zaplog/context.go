@@ -0,0 +1,49 @@
+package zaplog
+
+import (
+	"context"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+)
+
+type ctxKey struct{}
+
+// NewContext 把请求级Logger放入ctx
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext 返回ctx中的请求级Logger; 没有时返回附加了trace信息的全局Logger.
+// logic、repo层记录日志时都应使用它, 以便日志可以按请求关联.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+	return WithTrace(ctx, L())
+}
+
+// With 把fields附加到ctx中的Logger上, 返回的ctx及其派生的ctx通过FromContext取到的Logger都携带这些字段
+func With(ctx context.Context, fields ...Field) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// WithTrace 返回附加了ctx中trace_id、span_id、sampled(已决定采样时)和request_id的Logger.
+// ctx中既没有trace也没有请求ID时(如后台任务)生成一个request_id, 同一Logger记录的日志仍可以关联
+func WithTrace(ctx context.Context, l *Logger) *Logger {
+	var fields []Field
+	traceID, spanID := ctxutil.Trace(ctx)
+	if traceID != "" {
+		fields = append(fields, String("trace_id", traceID), String("span_id", spanID))
+		if sampled, ok := ctxutil.Sampled(ctx); ok {
+			fields = append(fields, Bool("sampled", sampled))
+		}
+	}
+	id := ctxutil.RequestID(ctx)
+	if id == "" && traceID == "" {
+		id = randomHex(8)
+	}
+	if id != "" {
+		fields = append(fields, String("request_id", id))
+	}
+	return l.With(fields...)
+}
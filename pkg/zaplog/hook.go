@@ -0,0 +1,89 @@
+package zaplog
+
+import (
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Entry 交给Hook的一条日志
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	// Fields Logger附加的字段和本次记录的字段, 按顺序排列
+	Fields []Field
+	// Stack 记录日志处的调用栈, 最内层在前
+	Stack []Frame
+}
+
+// Lookup 返回key对应的字段值, 同名字段取最后一个, 如 e.Lookup("trace_id")
+func (e Entry) Lookup(key string) (interface{}, bool) {
+	for i := len(e.Fields) - 1; i >= 0; i-- {
+		if e.Fields[i].Key == key {
+			return e.Fields[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Frame 调用栈中的一帧
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Hook 接收level及以上级别的日志, 类似zap.Hooks, 用于把错误上报到Sentry等服务.
+// Report在记录日志的goroutine中同步调用, 不应阻塞; Entry中的切片不会被修改, 可以保留
+type Hook interface {
+	Report(e Entry)
+}
+
+// hook 已注册的Hook及其级别
+type hook struct {
+	h     Hook
+	level Level
+}
+
+// WithHook 把level及以上级别的日志连同调用栈交给h, 不受主输出级别的影响, 但会先经过采样和限流
+func WithHook(h Hook, level Level) Option {
+	return func(co *core) error {
+		co.hooks = append(co.hooks, hook{h: h, level: level})
+		return nil
+	}
+}
+
+// report 把日志交给级别满足的Hook, 调用栈只在需要时获取一次
+func (l *Logger) report(now time.Time, level Level, msg string, fields []Field) {
+	var e *Entry
+	for _, h := range l.core.hooks {
+		if level < h.level {
+			continue
+		}
+		if e == nil {
+			fs := make([]Field, 0, len(l.fields)+len(fields))
+			fs = append(append(fs, l.fields...), fields...)
+			e = &Entry{Time: now, Level: level, Message: msg, Fields: fs, Stack: stack(2)}
+		}
+		h.h.Report(*e)
+	}
+}
+
+// stack 返回调用栈, 跳过runtime.Callers和stack本身, 并去掉zaplog包内的帧
+func stack(skip int) []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var out []Frame
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "github.com/Q1mi/greeter/pkg/zaplog.") {
+			out = append(out, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
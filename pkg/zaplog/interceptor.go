@@ -0,0 +1,114 @@
+package zaplog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/ctxutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor 为每个请求确定trace和请求ID, 并把附加了这些字段的Logger放入ctx.
+// trace_id取自traceparent(W3C Trace Context), 没有时新生成; span_id为本次调用新生成;
+// request_id取自x-request-id, 没有时新生成; ctx中已有的请求标签也作为字段附加.
+// 后续拦截器和handler可以用With继续附加字段.
+func UnaryServerInterceptor(base *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withRequest(ctx, base, info.FullMethod), req)
+	}
+}
+
+// StreamServerInterceptor 与UnaryServerInterceptor相同, 用于流式调用, 整个流使用同一组trace和请求ID
+func StreamServerInterceptor(base *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, ctxutil.WrapServerStream(ss, withRequest(ss.Context(), base, info.FullMethod)))
+	}
+}
+
+// withRequest 在ctx中设置trace和请求ID, 并放入附加了这些字段的Logger
+func withRequest(ctx context.Context, base *Logger, method string) context.Context {
+	traceID := parentTraceID(ctx)
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	ctx = ctxutil.WithTrace(ctx, traceID, randomHex(8))
+	if ctxutil.RequestID(ctx) == "" {
+		ctx = ctxutil.WithRequestID(ctx, randomHex(8))
+	}
+	l := WithTrace(ctx, base).With(String("method", method)).With(tagFields(ctxutil.TagsFrom(ctx).Values())...)
+	return NewContext(ctx, l)
+}
+
+// AccessLogInterceptor 在每个请求结束后记录一条访问日志, 包括客户端地址、状态码、耗时, 以及handler等添加的请求标签;
+// c.Payloads为true时还记录替换了敏感字段的请求和响应消息. 放在UnaryServerInterceptor之后
+func AccessLogInterceptor(c AccessLogConfig) grpc.UnaryServerInterceptor {
+	r := newRedactor(c.RedactFields)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tags := ctxutil.TagsFrom(ctx)
+		// 此前的标签已经作为字段附加在ctx中的Logger上
+		before := len(tags.Values())
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		fields := append(tagFields(tags.Values()[before:]), String("peer", ctxutil.ClientIP(ctx)),
+			String("code", status.Code(err).String()), Duration("duration", time.Since(start)))
+		if c.Payloads {
+			fields = append(fields, Any("request", r.payload(req, c.MaxPayloadBytes)))
+			if err == nil {
+				fields = append(fields, Any("response", r.payload(resp, c.MaxPayloadBytes)))
+			}
+		}
+		FromContext(ctx).Info("request finished", fields...)
+		return resp, err
+	}
+}
+
+func tagFields(tags []ctxutil.Tag) []Field {
+	fields := make([]Field, 0, len(tags))
+	for _, t := range tags {
+		fields = append(fields, String(t.Key, t.Value))
+	}
+	return fields
+}
+
+// parentTraceID 从traceparent中取trace ID, 格式: version-traceid-spanid-flags
+func parentTraceID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vs := md.Get(ctxutil.TraceParentHeader)
+	if len(vs) == 0 {
+		return ""
+	}
+	parts := strings.Split(vs[0], "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestIDHandler 为没有x-request-id的HTTP请求生成请求ID, 并写入请求头(由gateway转发给gRPC服务)和响应头,
+// gateway的日志和gRPC服务的日志使用同一个request_id
+func RequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(ctxutil.RequestIDHeader)
+		if id == "" {
+			id = randomHex(8)
+			r.Header.Set(ctxutil.RequestIDHeader, id)
+		}
+		w.Header().Set(ctxutil.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(ctxutil.WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,101 @@
+package zaplog
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted 替换访问日志中敏感字段的值
+const Redacted = "REDACTED"
+
+// SensitiveFields 名字包含这些词的字段总是替换, 请求记录(recorder)等其他脱敏处理也使用这份列表
+var SensitiveFields = []string{"password", "token", "secret"}
+
+// IsSensitiveField 字段名是否包含SensitiveFields中的词, 不区分大小写
+func IsSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range SensitiveFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessLogConfig 访问日志的可选内容
+type AccessLogConfig struct {
+	// Payloads 是否记录请求和响应消息(protojson)
+	Payloads bool `json:"payloads"`
+	// RedactFields 消息中替换为REDACTED的字段名, 不区分大小写, 如email、phone; 名字包含password、token、secret的字段总是替换
+	RedactFields []string `json:"redact_fields"`
+	// MaxPayloadBytes 每个消息最多记录的字节数, 超出时截断为字符串, 0为不限制
+	MaxPayloadBytes int `json:"max_payload_bytes"`
+}
+
+// redactor 按字段名替换消息中的敏感值
+type redactor struct {
+	fields map[string]bool
+}
+
+func newRedactor(fields []string) *redactor {
+	r := &redactor{fields: map[string]bool{}}
+	for _, f := range fields {
+		r.fields[strings.ToLower(f)] = true
+	}
+	return r
+}
+
+func (r *redactor) sensitive(name string) bool {
+	return r.fields[strings.ToLower(name)] || IsSensitiveField(name)
+}
+
+// payload 返回m替换敏感字段后的protojson, 超过max字节时返回截断的字符串; m不是proto消息时返回nil
+func (r *redactor) payload(v interface{}, max int) interface{} {
+	m, ok := v.(proto.Message)
+	if !ok || m == nil {
+		return nil
+	}
+	m = proto.Clone(m)
+	r.redact(m.ProtoReflect())
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	if max > 0 && len(b) > max {
+		return string(b[:max]) + "...(truncated)"
+	}
+	return json.RawMessage(b)
+}
+
+// redact 把敏感的非空字符串字段替换为Redacted, 其他类型的敏感字段清除, 递归处理子消息
+func (r *redactor) redact(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case r.sensitive(string(fd.Name())):
+			if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() {
+				m.Set(fd, protoreflect.ValueOfString(Redacted))
+			} else {
+				m.Clear(fd)
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					r.redact(mv.Message())
+					return true
+				})
+			}
+		case fd.Kind() == protoreflect.MessageKind && fd.IsList():
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				r.redact(l.Get(i).Message())
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			r.redact(v.Message())
+		}
+		return true
+	})
+}
@@ -0,0 +1,167 @@
+package zaplog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/metrics"
+)
+
+// SamplingPolicy 一个级别的采样策略: 每个时间窗口内同一级别同一消息的前Initial条全部记录,
+// 之后每Thereafter条记录一条. Initial<=0表示不采样
+type SamplingPolicy struct {
+	Initial    int `json:"initial"`
+	Thereafter int `json:"thereafter"`
+}
+
+// SamplingConfig 采样配置, 与zap.SamplingConfig的含义相同
+type SamplingConfig struct {
+	// Tick 时间窗口, 如 "1s", 为空时为1秒
+	Tick string `json:"tick"`
+	// Initial, Thereafter 所有级别的默认策略
+	Initial    int `json:"initial"`
+	Thereafter int `json:"thereafter"`
+	// Levels 按级别覆盖默认策略, key为级别名称
+	Levels map[string]SamplingPolicy `json:"levels"`
+	// Messages 按消息限流, key为完整的日志消息, 如高频路径上的 "request finished"; 不论级别, 先于采样检查
+	Messages map[string]RateLimit `json:"messages"`
+}
+
+// RateLimit 一条消息的限流: 平均每秒最多Rate条, 允许Burst条突发
+type RateLimit struct {
+	Rate float64 `json:"rate"`
+	// Burst 不大于0时为Rate(至少1)
+	Burst int `json:"burst"`
+}
+
+// Option Logger选项
+type Option func(*core) error
+
+// WithSampling 对高频日志采样, 避免负载高时debug日志等占满磁盘或日志管道
+func WithSampling(c SamplingConfig) Option {
+	return func(co *core) error {
+		tick := time.Second
+		if c.Tick != "" {
+			d, err := time.ParseDuration(c.Tick)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("zaplog: invalid sampling tick %q", c.Tick)
+			}
+			tick = d
+		}
+		s := &sampler{tick: tick}
+		def := SamplingPolicy{Initial: c.Initial, Thereafter: c.Thereafter}
+		for l := DebugLevel; l <= ErrorLevel; l++ {
+			s.policies[l-DebugLevel] = def
+		}
+		for name, p := range c.Levels {
+			l, err := ParseLevel(name)
+			if err != nil {
+				return err
+			}
+			s.policies[l-DebugLevel] = p
+		}
+		if len(c.Messages) > 0 {
+			s.limits = make(map[string]*bucket, len(c.Messages))
+			for msg, l := range c.Messages {
+				if l.Rate <= 0 {
+					return fmt.Errorf("zaplog: sampling.messages[%q].rate must be positive", msg)
+				}
+				burst := float64(l.Burst)
+				if burst <= 0 {
+					burst = math.Max(l.Rate, 1)
+				}
+				s.limits[msg] = &bucket{rate: l.Rate, burst: burst, tokens: burst}
+			}
+		}
+		co.sampler = s
+		return nil
+	}
+}
+
+var (
+	sampledDropped = metrics.NewCounterVec("log_sampled_dropped_total",
+		"Number of log entries dropped by sampling.", "level")
+	rateLimitedDropped = metrics.NewCounterVec("log_rate_limited_dropped_total",
+		"Number of log entries dropped by per-message rate limits, by message.", "msg")
+)
+
+const (
+	numLevels   = int(ErrorLevel-DebugLevel) + 1
+	numCounters = 4096
+)
+
+type sampler struct {
+	tick     time.Duration
+	policies [numLevels]SamplingPolicy
+	// limits 按消息的限流, 创建后只读
+	limits map[string]*bucket
+
+	mu       sync.Mutex
+	counters [numLevels][numCounters]counter
+}
+
+type counter struct {
+	resetAt time.Time
+	n       int
+}
+
+// sample 返回是否记录该条日志. 与zap相同, 按消息哈希计数, 冲突的消息共享计数
+func (s *sampler) sample(level Level, msg string) bool {
+	i := int(level - DebugLevel)
+	if i < 0 || i >= numLevels {
+		return true
+	}
+	if b, ok := s.limits[msg]; ok && !b.take(time.Now()) {
+		rateLimitedDropped.WithLabelValues(msg).Inc()
+		return false
+	}
+	p := s.policies[i]
+	if p.Initial <= 0 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(msg))
+	now := time.Now()
+
+	s.mu.Lock()
+	c := &s.counters[i][h.Sum32()%numCounters]
+	if now.After(c.resetAt) {
+		c.resetAt, c.n = now.Add(s.tick), 0
+	}
+	c.n++
+	n := c.n
+	s.mu.Unlock()
+
+	if n <= p.Initial || (p.Thereafter > 0 && (n-p.Initial)%p.Thereafter == 0) {
+		return true
+	}
+	sampledDropped.WithLabelValues(level.String()).Inc()
+	return false
+}
+
+// bucket 令牌桶
+type bucket struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take 取一个令牌, 没有时返回false
+func (b *bucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,22 @@
+package zaplog
+
+import (
+	"io"
+	"os"
+)
+
+// Open 打开日志输出: "stdout"、"stderr" 或文件路径. 文件以追加方式打开, 不存在时创建;
+// 关闭stdout和stderr时不会真正关闭
+func Open(path string) (io.WriteCloser, error) {
+	switch path {
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
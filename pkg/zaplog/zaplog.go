@@ -0,0 +1,324 @@
+// Package zaplog 结构化日志, 每条日志输出为一行JSON.
+// API与go.uber.org/zap的常用部分保持一致(Logger、Field、With), 便于以后直接替换为zap.
+package zaplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level 日志级别
+type Level int8
+
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", l)
+	}
+}
+
+// ParseLevel 解析级别名称: debug, info, warn, error
+func ParseLevel(s string) (Level, error) {
+	for l := DebugLevel; l <= ErrorLevel; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("zaplog: unknown level %q", s)
+}
+
+// Field 日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, v string) Field                 { return Field{key, v} }
+func Int(key string, v int) Field                { return Field{key, v} }
+func Int64(key string, v int64) Field            { return Field{key, v} }
+func Bool(key string, v bool) Field              { return Field{key, v} }
+func Duration(key string, v time.Duration) Field { return Field{key, v.String()} }
+func Any(key string, v interface{}) Field        { return Field{key, v} }
+
+// Error 以error为key记录错误, err为nil时输出null
+func Error(err error) Field {
+	if err == nil {
+		return Field{"error", nil}
+	}
+	return Field{"error", err.Error()}
+}
+
+// 日志格式
+const (
+	// EncodingJSON 每条日志一行JSON, 供容器平台和日志收集服务解析
+	EncodingJSON = "json"
+	// EncodingConsole 时间、级别、消息以tab分隔, 字段以JSON附在最后, 便于直接阅读
+	EncodingConsole = "console"
+)
+
+// core 同一个输出的所有Logger共享, 保证并发写入时每行完整
+type core struct {
+	mu      sync.Mutex
+	w       io.Writer
+	level   Level
+	console bool
+	sampler *sampler
+	// tees 额外的输出, 如日志收集服务
+	tees []tee
+	// hooks 接收日志的Hook, 如错误上报
+	hooks []hook
+	// minLevel 所有输出中最低的级别, 用于Enabled; SetLevel会修改, 原子读写
+	minLevel int32
+}
+
+// tee 额外的输出及其级别
+type tee struct {
+	w     io.Writer
+	level Level
+	// console 使用console格式, 否则为JSON
+	console bool
+	// output 由WithOutput添加, 格式跟随主输出
+	output bool
+}
+
+// Logger 结构化日志记录器, 并发安全
+type Logger struct {
+	core   *core
+	fields []Field
+}
+
+// New 创建输出到w, 记录level及以上级别的Logger
+func New(w io.Writer, level Level, opts ...Option) (*Logger, error) {
+	c := &core{w: w, level: level}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	c.updateMinLevel()
+	return &Logger{core: c}, nil
+}
+
+// updateMinLevel 重新计算minLevel, 修改level、tees或hooks后调用
+func (c *core) updateMinLevel() {
+	min := c.level
+	for _, t := range c.tees {
+		if t.level < min {
+			min = t.level
+		}
+	}
+	for _, h := range c.hooks {
+		if h.level < min {
+			min = h.level
+		}
+	}
+	atomic.StoreInt32(&c.minLevel, int32(min))
+}
+
+// SetLevel 修改主输出的级别, 对共享同一输出的所有Logger(包括With得到的子Logger)生效; 额外输出的级别不变
+func (l *Logger) SetLevel(level Level) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.level = level
+	l.core.updateMinLevel()
+}
+
+// Level 返回主输出的级别
+func (l *Logger) Level() Level {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	return l.core.level
+}
+
+// WithTee 把level及以上级别的日志以JSON格式同时写到w, 类似zapcore.NewTee. 每次Write是一行完整的日志,
+// w不应阻塞, 否则会拖慢所有记录日志的请求
+func WithTee(w io.Writer, level Level) Option {
+	return func(co *core) error {
+		co.tees = append(co.tees, tee{w: w, level: level})
+		return nil
+	}
+}
+
+// WithEncoding 设置主输出和WithOutput的格式: json(默认) 或 console. WithTee的输出始终为JSON
+func WithEncoding(enc string) Option {
+	return func(co *core) error {
+		switch enc {
+		case "", EncodingJSON:
+			co.console = false
+		case EncodingConsole:
+			co.console = true
+		default:
+			return fmt.Errorf("zaplog: unknown encoding %q", enc)
+		}
+		for i := range co.tees {
+			if co.tees[i].output {
+				co.tees[i].console = co.console
+			}
+		}
+		return nil
+	}
+}
+
+// WithOutput 把level及以上级别的日志同时写到w, 格式与主输出相同, 如单独的错误日志文件
+func WithOutput(w io.Writer, level Level) Option {
+	return func(co *core) error {
+		co.tees = append(co.tees, tee{w: w, level: level, console: co.console, output: true})
+		return nil
+	}
+}
+
+// With 返回附加了fields的子Logger, 子Logger的每条日志都携带这些字段
+func (l *Logger) With(fields ...Field) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	fs := make([]Field, 0, len(l.fields)+len(fields))
+	fs = append(append(fs, l.fields...), fields...)
+	return &Logger{core: l.core, fields: fs}
+}
+
+// Enabled 是否有输出或Hook记录该级别的日志
+func (l *Logger) Enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&l.core.minLevel)
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) { l.log(DebugLevel, msg, fields) }
+func (l *Logger) Info(msg string, fields ...Field)  { l.log(InfoLevel, msg, fields) }
+func (l *Logger) Warn(msg string, fields ...Field)  { l.log(WarnLevel, msg, fields) }
+func (l *Logger) Error(msg string, fields ...Field) { l.log(ErrorLevel, msg, fields) }
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	if s := l.core.sampler; s != nil && !s.sample(level, msg) {
+		return
+	}
+	now := time.Now()
+	// 两种格式按需编码, 每种最多编码一次
+	var js, cs []byte
+	encode := func(console bool) []byte {
+		if console {
+			if cs == nil {
+				cs = l.encodeConsole(now, level, msg, fields)
+			}
+			return cs
+		}
+		if js == nil {
+			js = l.encodeJSON(now, level, msg, fields)
+		}
+		return js
+	}
+	l.core.mu.Lock()
+	if level >= l.core.level {
+		l.core.w.Write(encode(l.core.console))
+	}
+	for _, t := range l.core.tees {
+		if level >= t.level {
+			t.w.Write(encode(t.console))
+		}
+	}
+	l.core.mu.Unlock()
+	if len(l.core.hooks) > 0 {
+		l.report(now, level, msg, fields)
+	}
+}
+
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+func (l *Logger) encodeJSON(now time.Time, level Level, msg string, fields []Field) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"ts":"`)
+	buf.WriteString(now.Format(timeLayout))
+	buf.WriteString(`","level":"`)
+	buf.WriteString(level.String())
+	buf.WriteString(`","msg":`)
+	writeJSON(&buf, msg)
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			buf.WriteByte(',')
+			writeJSON(&buf, f.Key)
+			buf.WriteByte(':')
+			writeJSON(&buf, f.Value)
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// encodeConsole 与zap的console格式相同: 时间、大写级别、消息以tab分隔, 有字段时以JSON对象附在最后
+func (l *Logger) encodeConsole(now time.Time, level Level, msg string, fields []Field) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(now.Format(timeLayout))
+	buf.WriteByte('\t')
+	buf.WriteString(strings.ToUpper(level.String()))
+	buf.WriteByte('\t')
+	buf.WriteString(msg)
+	if len(l.fields)+len(fields) > 0 {
+		buf.WriteString("\t{")
+		n := 0
+		for _, fs := range [][]Field{l.fields, fields} {
+			for _, f := range fs {
+				if n > 0 {
+					buf.WriteString(", ")
+				}
+				n++
+				writeJSON(&buf, f.Key)
+				buf.WriteString(": ")
+				writeJSON(&buf, f.Value)
+			}
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+var (
+	globalMu sync.RWMutex
+	global   = &Logger{core: &core{w: os.Stderr, level: InfoLevel, minLevel: int32(InfoLevel)}}
+)
+
+// L 返回全局Logger
+func L() *Logger {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// ReplaceGlobals 替换全局Logger, 应在启动时调用
+func ReplaceGlobals(l *Logger) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = l
+}
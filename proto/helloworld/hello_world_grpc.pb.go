@@ -24,6 +24,14 @@ const _ = grpc.SupportPackageIsVersion7
 type GreeterClient interface {
 	// 打招呼方法
 	SayHello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloReply, error)
+	// 分页查询问候记录, 按时间倒序
+	ListGreetings(ctx context.Context, in *ListGreetingsRequest, opts ...grpc.CallOption) (*ListGreetingsReply, error)
+	// 查询调用统计
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsReply, error)
+	// 双向流式聊天: 客户端每发送一条消息, 服务端回复一条问候. 只支持gRPC, gateway不转发
+	Chat(ctx context.Context, opts ...grpc.CallOption) (Greeter_ChatClient, error)
+	// 批量打招呼: 客户端流式发送多个请求, 结束后返回汇总. 经gateway时请求体为换行分隔的JSON
+	UploadGreetings(ctx context.Context, opts ...grpc.CallOption) (Greeter_UploadGreetingsClient, error)
 }
 
 type greeterClient struct {
@@ -43,12 +51,103 @@ func (c *greeterClient) SayHello(ctx context.Context, in *HelloRequest, opts ...
 	return out, nil
 }
 
+func (c *greeterClient) ListGreetings(ctx context.Context, in *ListGreetingsRequest, opts ...grpc.CallOption) (*ListGreetingsReply, error) {
+	out := new(ListGreetingsReply)
+	err := c.cc.Invoke(ctx, "/helloworld.Greeter/ListGreetings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *greeterClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsReply, error) {
+	out := new(GetStatsReply)
+	err := c.cc.Invoke(ctx, "/helloworld.Greeter/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *greeterClient) Chat(ctx context.Context, opts ...grpc.CallOption) (Greeter_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Greeter_ServiceDesc.Streams[0], "/helloworld.Greeter/Chat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &greeterChatClient{stream}
+	return x, nil
+}
+
+type Greeter_ChatClient interface {
+	Send(*ChatMessage) error
+	Recv() (*ChatMessage, error)
+	grpc.ClientStream
+}
+
+type greeterChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *greeterChatClient) Send(m *ChatMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *greeterChatClient) Recv() (*ChatMessage, error) {
+	m := new(ChatMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *greeterClient) UploadGreetings(ctx context.Context, opts ...grpc.CallOption) (Greeter_UploadGreetingsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Greeter_ServiceDesc.Streams[1], "/helloworld.Greeter/UploadGreetings", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &greeterUploadGreetingsClient{stream}
+	return x, nil
+}
+
+type Greeter_UploadGreetingsClient interface {
+	Send(*HelloRequest) error
+	CloseAndRecv() (*UploadGreetingsReply, error)
+	grpc.ClientStream
+}
+
+type greeterUploadGreetingsClient struct {
+	grpc.ClientStream
+}
+
+func (x *greeterUploadGreetingsClient) Send(m *HelloRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *greeterUploadGreetingsClient) CloseAndRecv() (*UploadGreetingsReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadGreetingsReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // GreeterServer is the server API for Greeter service.
 // All implementations must embed UnimplementedGreeterServer
 // for forward compatibility
 type GreeterServer interface {
 	// 打招呼方法
 	SayHello(context.Context, *HelloRequest) (*HelloReply, error)
+	// 分页查询问候记录, 按时间倒序
+	ListGreetings(context.Context, *ListGreetingsRequest) (*ListGreetingsReply, error)
+	// 查询调用统计
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsReply, error)
+	// 双向流式聊天: 客户端每发送一条消息, 服务端回复一条问候. 只支持gRPC, gateway不转发
+	Chat(Greeter_ChatServer) error
+	// 批量打招呼: 客户端流式发送多个请求, 结束后返回汇总. 经gateway时请求体为换行分隔的JSON
+	UploadGreetings(Greeter_UploadGreetingsServer) error
 	mustEmbedUnimplementedGreeterServer()
 }
 
@@ -59,6 +158,18 @@ type UnimplementedGreeterServer struct {
 func (UnimplementedGreeterServer) SayHello(context.Context, *HelloRequest) (*HelloReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SayHello not implemented")
 }
+func (UnimplementedGreeterServer) ListGreetings(context.Context, *ListGreetingsRequest) (*ListGreetingsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGreetings not implemented")
+}
+func (UnimplementedGreeterServer) GetStats(context.Context, *GetStatsRequest) (*GetStatsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedGreeterServer) Chat(Greeter_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedGreeterServer) UploadGreetings(Greeter_UploadGreetingsServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadGreetings not implemented")
+}
 func (UnimplementedGreeterServer) mustEmbedUnimplementedGreeterServer() {}
 
 // UnsafeGreeterServer may be embedded to opt out of forward compatibility for this service.
@@ -90,6 +201,94 @@ func _Greeter_SayHello_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Greeter_ListGreetings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGreetingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GreeterServer).ListGreetings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/helloworld.Greeter/ListGreetings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GreeterServer).ListGreetings(ctx, req.(*ListGreetingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Greeter_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GreeterServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/helloworld.Greeter/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GreeterServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Greeter_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GreeterServer).Chat(&greeterChatServer{stream})
+}
+
+type Greeter_ChatServer interface {
+	Send(*ChatMessage) error
+	Recv() (*ChatMessage, error)
+	grpc.ServerStream
+}
+
+type greeterChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *greeterChatServer) Send(m *ChatMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *greeterChatServer) Recv() (*ChatMessage, error) {
+	m := new(ChatMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Greeter_UploadGreetings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GreeterServer).UploadGreetings(&greeterUploadGreetingsServer{stream})
+}
+
+type Greeter_UploadGreetingsServer interface {
+	SendAndClose(*UploadGreetingsReply) error
+	Recv() (*HelloRequest, error)
+	grpc.ServerStream
+}
+
+type greeterUploadGreetingsServer struct {
+	grpc.ServerStream
+}
+
+func (x *greeterUploadGreetingsServer) SendAndClose(m *UploadGreetingsReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *greeterUploadGreetingsServer) Recv() (*HelloRequest, error) {
+	m := new(HelloRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Greeter_ServiceDesc is the grpc.ServiceDesc for Greeter service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -101,7 +300,27 @@ var Greeter_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SayHello",
 			Handler:    _Greeter_SayHello_Handler,
 		},
+		{
+			MethodName: "ListGreetings",
+			Handler:    _Greeter_ListGreetings_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Greeter_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Chat",
+			Handler:       _Greeter_Chat_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "UploadGreetings",
+			Handler:       _Greeter_UploadGreetings_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "helloworld/hello_world.proto",
 }
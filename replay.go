@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Q1mi/greeter/pkg/recorder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// replayMain 实现replay子命令: 把recorder记录的请求重新发送到目标环境, 逐条输出结果
+//
+//	greeter replay -target 127.0.0.1:8091 requests-*.jsonl
+func replayMain(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "127.0.0.1:8091", "目标gRPC服务地址")
+	timeout := fs.Duration("timeout", 5*time.Second, "单个请求的超时时间")
+	verbose := fs.Bool("v", false, "输出响应内容")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: greeter replay [-target addr] [-timeout d] [-v] file...")
+		return 2
+	}
+
+	cc, err := grpc.Dial(*target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dial:", err)
+		return 1
+	}
+	defer cc.Close()
+
+	failed := 0
+	for _, path := range fs.Args() {
+		entries, err := recorder.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, e := range entries {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			start := time.Now()
+			resp, err := recorder.Replay(ctx, cc, e)
+			cancel()
+			fmt.Printf("%s %s %s\n", e.Method, status.Code(err), time.Since(start).Round(time.Microsecond))
+			if err != nil {
+				failed++
+				if *verbose {
+					fmt.Println("  ", err)
+				}
+			} else if *verbose {
+				fmt.Println("  ", protojson.Format(resp))
+			}
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
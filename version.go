@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Q1mi/greeter/internal/service/admin"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// version 发布版本, 构建时通过 -ldflags "-X main.version=v1.2.3" 设置, 为空时使用模块版本
+var version string
+
+// versionMain 实现version子命令: 输出版本、Go版本和VCS信息, 与AdminService.Diagnose返回的构建信息相同
+//
+//	greeter version
+//	greeter version -json
+func versionMain(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: greeter version [-json]")
+		return 2
+	}
+	b := admin.BuildInfo()
+	if version != "" {
+		b.MainVersion = version
+	}
+	if *asJSON {
+		fmt.Println(protojson.Format(b))
+		return 0
+	}
+	fmt.Println("greeter", b.MainVersion)
+	fmt.Println("  go:      ", b.GoVersion)
+	if b.VcsRevision != "" {
+		rev := b.VcsRevision
+		if b.VcsModified {
+			rev += " (modified)"
+		}
+		fmt.Println("  revision:", rev)
+		fmt.Println("  time:    ", b.VcsTime)
+	}
+	return 0
+}